@@ -8,6 +8,12 @@ fi
 # Run our golang tests
 go test ./... -race
 
+# The protoadapter package is a module of its own, as it depends upon
+# google.golang.org/protobuf, and is only built with the protobuf tag.
+start=$(pwd)
+cd protoadapter && go test -tags protobuf -race ./...
+cd ${start}
+
 # If that worked build our examples, to ensure they work
 # and that we've not broken compatibility
 for i in _examples/embedded/*; do
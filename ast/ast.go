@@ -210,3 +210,18 @@ func (bs *BlockStatement) String() string {
 	out.WriteString("}\n")
 	return out.String()
 }
+
+// IsIndexTarget returns true if the given expression refers to a member
+// of an array or hash, i.e. `a[1]`, or `a.b`.
+//
+// Such members may be the target of an assignment, or of an operator
+// such as `++`, as well as a variable.
+func IsIndexTarget(exp Expression) bool {
+	switch node := exp.(type) {
+	case *IndexExpression:
+		return true
+	case *InfixExpression:
+		return node.Operator == "."
+	}
+	return false
+}
@@ -68,3 +68,45 @@ func (ie *IndexExpression) String() string {
 	out.WriteString("])")
 	return out.String()
 }
+
+// SliceExpression holds a slice-expression, such as `a[1:3]`.
+type SliceExpression struct {
+	// Token is the actual token
+	Token token.Token
+
+	// Left is the thing being sliced.
+	Left Expression
+
+	// Start is the index of the first member of the slice, or nil
+	// if the slice begins at the start.
+	Start Expression
+
+	// End is the index after the last member of the slice, or nil
+	// if the slice continues to the end.
+	End Expression
+}
+
+func (se *SliceExpression) expressionNode() {}
+
+// TokenLiteral returns the literal token.
+func (se *SliceExpression) TokenLiteral() string { return se.Token.Literal }
+
+// String returns this object as a string.
+func (se *SliceExpression) String() string {
+	if se == nil {
+		return ""
+	}
+	var out bytes.Buffer
+	out.WriteString("(")
+	out.WriteString(se.Left.String())
+	out.WriteString("[")
+	if se.Start != nil {
+		out.WriteString(se.Start.String())
+	}
+	out.WriteString(":")
+	if se.End != nil {
+		out.WriteString(se.End.String())
+	}
+	out.WriteString("])")
+	return out.String()
+}
@@ -11,6 +11,10 @@ type AssignStatement struct {
 	Token token.Token
 	Name  *Identifier
 	Value Expression
+
+	// Target is used instead of Name when we're assigning to an
+	// array/hash member, for example `a[1] = 3`, or `x.y = "z"`.
+	Target Expression
 }
 
 func (as *AssignStatement) expressionNode() {}
@@ -25,7 +29,11 @@ func (as *AssignStatement) String() string {
 	}
 
 	var out bytes.Buffer
-	out.WriteString(as.Name.String())
+	if as.Target != nil {
+		out.WriteString(as.Target.String())
+	} else {
+		out.WriteString(as.Name.String())
+	}
 	out.WriteString("=")
 	out.WriteString(as.Value.String())
 	return out.String()
@@ -39,8 +39,12 @@ func (fes *ForeachStatement) String() string {
 
 	var out bytes.Buffer
 	out.WriteString("foreach ")
+	if fes.Index != "" {
+		out.WriteString(fes.Index)
+		out.WriteString(", ")
+	}
 	out.WriteString(fes.Ident)
-	out.WriteString(" ")
+	out.WriteString(" in ")
 	out.WriteString(fes.Value.String())
 	out.WriteString(fes.Body.String())
 	return out.String()
@@ -0,0 +1,117 @@
+package ast
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/skx/evalfilter/v2/token"
+)
+
+// HashPattern holds a hash-pattern, used within a match expression.
+//
+// A hash-pattern matches a hash which contains each of the given keys,
+// where the value of each key matches the corresponding pattern.  Any
+// additional keys present in the hash are ignored.
+type HashPattern struct {
+	// Token holds the token
+	Token token.Token // the '{' token
+
+	// Keys holds the keys we look for, in the order they were written.
+	Keys []Expression
+
+	// Values holds the pattern each key's value must match.
+	Values []Expression
+}
+
+func (hp *HashPattern) expressionNode() {}
+
+// TokenLiteral returns the literal token.
+func (hp *HashPattern) TokenLiteral() string { return hp.Token.Literal }
+
+// String returns this object as a string.
+func (hp *HashPattern) String() string {
+	if hp == nil {
+		return ""
+	}
+
+	var out bytes.Buffer
+	pairs := make([]string, 0)
+	for i, key := range hp.Keys {
+		pairs = append(pairs, key.String()+":"+hp.Values[i].String())
+	}
+	out.WriteString("{")
+	out.WriteString(strings.Join(pairs, ", "))
+	out.WriteString("}")
+	return out.String()
+}
+
+// MatchArm holds a single arm of a match expression.
+type MatchArm struct {
+	// Token is the actual token
+	Token token.Token
+
+	// Pattern is the pattern we test the value against.
+	//
+	// This is either a literal, a hash-pattern, the wildcard `_`,
+	// or an identifier which will be bound to the value.
+	Pattern Expression
+
+	// The code to execute if there is a match
+	Block *BlockStatement
+}
+
+func (ma *MatchArm) expressionNode() {}
+
+// TokenLiteral returns the literal token.
+func (ma *MatchArm) TokenLiteral() string { return ma.Token.Literal }
+
+// String returns this object as a string.
+func (ma *MatchArm) String() string {
+	if ma == nil {
+		return ""
+	}
+
+	var out bytes.Buffer
+	out.WriteString(ma.Pattern.String())
+	out.WriteString(" =>")
+	out.WriteString(ma.Block.String())
+	return out.String()
+}
+
+// MatchExpression holds a match expression.
+type MatchExpression struct {
+	// Token is the actual token
+	Token token.Token
+
+	// Value is the thing that is tested against each pattern.
+	Value Expression
+
+	// The arms we handle, in order.
+	Arms []*MatchArm
+}
+
+func (me *MatchExpression) expressionNode() {}
+
+// TokenLiteral returns the literal token.
+func (me *MatchExpression) TokenLiteral() string { return me.Token.Literal }
+
+// String returns this object as a string.
+func (me *MatchExpression) String() string {
+	if me == nil {
+		return ""
+	}
+
+	var out bytes.Buffer
+	out.WriteString("\nmatch ")
+	out.WriteString(me.Value.String())
+	out.WriteString("\n{\n")
+
+	for _, tmp := range me.Arms {
+		if tmp != nil {
+			out.WriteString(tmp.String())
+		}
+	}
+	out.WriteString("}\n")
+
+	return out.String()
+}
@@ -12,6 +12,12 @@ type PostfixExpression struct {
 	Token token.Token
 	// Operator holds the postfix token, e.g. ++
 	Operator string
+
+	// Target holds the element we're operating upon, if we're
+	// modifying an array/hash member rather than a variable.
+	//
+	// For example `counts["x"]++`.
+	Target Expression
 }
 
 func (pe *PostfixExpression) expressionNode() {}
@@ -27,7 +33,11 @@ func (pe *PostfixExpression) String() string {
 
 	var out bytes.Buffer
 	out.WriteString("(")
-	out.WriteString(pe.Token.Literal)
+	if pe.Target != nil {
+		out.WriteString(pe.Target.String())
+	} else {
+		out.WriteString(pe.Token.Literal)
+	}
 	out.WriteString(pe.Operator)
 	out.WriteString(")")
 	return out.String()
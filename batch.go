@@ -0,0 +1,57 @@
+// This file contains RunAll, and ExecuteAll, which run a script against
+// many objects at once.
+//
+// Running a script against a single object takes and releases our
+// mutex, and resets the state of the machine, each time.  When a script
+// is run against a batch of objects the mutex is held for the batch, and
+// the maps the machine uses to convert each object are reused.
+
+package evalfilter
+
+import (
+	"github.com/skx/evalfilter/v2/object"
+)
+
+// ExecuteAll runs the script against each of the given objects, in turn,
+// and returns the objects that the script finished with.
+//
+// If the script fails then the results of the objects before the one
+// which failed are returned, along with a *BatchError which identifies
+// it.
+func (e *Eval) ExecuteAll(objs []interface{}) ([]object.Object, error) {
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	out := make([]object.Object, 0, len(objs))
+	for i, obj := range objs {
+		res, err := e.Execute(obj)
+		if err != nil {
+			return out, &BatchError{Index: i, Err: err}
+		}
+		out = append(out, res)
+	}
+	return out, nil
+}
+
+// RunAll runs the script against each of the given objects, in turn, and
+// returns whether the result of each was true.
+//
+// If the script fails then the results of the objects before the one
+// which failed are returned, along with a *BatchError which identifies
+// it.
+func (e *Eval) RunAll(objs []interface{}) ([]bool, error) {
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	out := make([]bool, 0, len(objs))
+	for i, obj := range objs {
+		res, err := e.Execute(obj)
+		if err != nil {
+			return out, &BatchError{Index: i, Err: err}
+		}
+		out = append(out, e.machine.True(res))
+	}
+	return out, nil
+}
@@ -0,0 +1,70 @@
+package evalfilter
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// TestRunAll tests running a script against many objects.
+func TestRunAll(t *testing.T) {
+
+	type Event struct {
+		Name  string
+		Value int
+	}
+
+	eval := New(`return Value > 10;`)
+	err := eval.Prepare()
+	if err != nil {
+		t.Fatalf("failed to compile: %s", err)
+	}
+
+	objs := []interface{}{
+		Event{Name: "a", Value: 5},
+		Event{Name: "b", Value: 50},
+		map[string]interface{}{"Value": 11},
+		&Event{Name: "c", Value: 10},
+	}
+
+	res, err := eval.RunAll(objs)
+	if err != nil {
+		t.Fatalf("failed to run: %s", err)
+	}
+	if !reflect.DeepEqual(res, []bool{false, true, true, false}) {
+		t.Fatalf("unexpected results %v", res)
+	}
+
+	out, err := eval.ExecuteAll(objs)
+	if err != nil {
+		t.Fatalf("failed to run: %s", err)
+	}
+	if len(out) != 4 || out[1].Inspect() != "true" || out[3].Inspect() != "false" {
+		t.Fatalf("unexpected results %v", out)
+	}
+
+	// Nothing to do.
+	res, err = eval.RunAll(nil)
+	if err != nil || len(res) != 0 {
+		t.Fatalf("unexpected results %v %v", res, err)
+	}
+
+	// A failure identifies the object which failed.
+	eval = New(`return 10 / Value > 1;`)
+	err = eval.Prepare()
+	if err != nil {
+		t.Fatalf("failed to compile: %s", err)
+	}
+	res, err = eval.RunAll([]interface{}{Event{Value: 2}, Event{Value: 20}, Event{Value: 0}, Event{Value: 1}})
+	var berr *BatchError
+	if !errors.As(err, &berr) || berr.Index != 2 {
+		t.Fatalf("expected a batch error for the third object, got %T %v", err, err)
+	}
+	var rerr *RuntimeError
+	if !errors.As(err, &rerr) {
+		t.Fatalf("expected a runtime error, got %T %v", err, err)
+	}
+	if !reflect.DeepEqual(res, []bool{true, false}) {
+		t.Fatalf("unexpected results %v", res)
+	}
+}
@@ -216,3 +216,45 @@ return ( result == 3628800 );`)
 		b.Fail()
 	}
 }
+
+// Benchmark_evalfilter_run_all - This runs a script against a batch of
+// objects at once.
+func Benchmark_evalfilter_run_all(b *testing.B) {
+
+	//
+	// Prepare the script
+	//
+	eval := New(`return Origin == "MOW" && Value > 50;`)
+	err := eval.Prepare()
+	if err != nil {
+		fmt.Printf("Failed to compile: %s\n", err.Error())
+		return
+	}
+
+	//
+	// Create the objects we'll test against.
+	//
+	type Event struct {
+		Origin string
+		Value  int
+	}
+	objs := make([]interface{}, 1000)
+	for i := range objs {
+		objs[i] = Event{Origin: "MOW", Value: i % 100}
+	}
+
+	var ret []bool
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		ret, err = eval.RunAll(objs)
+	}
+	b.StopTimer()
+
+	if err != nil {
+		b.Fatal(err)
+	}
+	if len(ret) != len(objs) {
+		b.Fail()
+	}
+}
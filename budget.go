@@ -0,0 +1,90 @@
+// This file contains the code which allows host functions to be given
+// an execution budget.
+//
+// A host function might perform a slow operation, such as a lookup in
+// an external database.  Wrapping such a function with a budget ensures
+// that a single slow call cannot stall the whole evaluation.
+
+package evalfilter
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/skx/evalfilter/v2/object"
+)
+
+// BudgetPolicy describes what happens when a host function exceeds
+// its execution budget.
+type BudgetPolicy int
+
+// The policies which may be passed to AddFunctionWithBudget.
+const (
+	// BudgetReturnError causes the function to return an error
+	// object to the script, which may decide how to handle it.
+	BudgetReturnError BudgetPolicy = iota
+
+	// BudgetAbort causes the script to terminate with an error.
+	BudgetAbort
+)
+
+// AddFunctionWithBudget exposes a golang function from your host
+// application to the scripting environment, as AddFunction does, but
+// limits the time each call of the function may take.
+//
+// If a call takes longer than the given budget the script continues
+// without waiting for it to complete, and the given policy decides
+// whether the script receives an error object or is aborted.
+//
+// Note that the function itself is not interrupted, so it should
+// still terminate in a timely fashion.
+func (e *Eval) AddFunctionWithBudget(name string, fun func(args []object.Object) object.Object, budget time.Duration, policy BudgetPolicy) {
+	e.environment.SetFunction(name, withBudget(name, fun, budget, policy))
+}
+
+// withBudget wraps the given function such that it may only execute
+// for the given duration.
+func withBudget(name string, fun func(args []object.Object) object.Object, budget time.Duration, policy BudgetPolicy) func(args []object.Object) object.Object {
+
+	// The result of a call, or the reason it failed.
+	type result struct {
+		out   object.Object
+		fault interface{}
+	}
+
+	return func(args []object.Object) object.Object {
+
+		// Buffered so that a call which completes after we've
+		// given up upon it doesn't block forever.
+		ch := make(chan result, 1)
+
+		go func() {
+			// A panic must be propagated to the caller, rather
+			// than terminating the host application.
+			defer func() {
+				if r := recover(); r != nil {
+					ch <- result{fault: r}
+				}
+			}()
+			ch <- result{out: fun(args)}
+		}()
+
+		timer := time.NewTimer(budget)
+		defer timer.Stop()
+
+		select {
+		case res := <-ch:
+			if res.fault != nil {
+				panic(res.fault)
+			}
+			return res.out
+		case <-timer.C:
+		}
+
+		msg := fmt.Sprintf("function %s exceeded its budget of %s", name, budget)
+		if policy == BudgetAbort {
+			panic(msg)
+		}
+		return &object.Error{Message: msg}
+	}
+}
@@ -0,0 +1,179 @@
+// This file contains the CompileCache, which allows services that are
+// given the same scripts repeatedly to avoid compiling them each time.
+
+package evalfilter
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sync"
+)
+
+// CompileCache holds prepared scripts, keyed by the checksum of their
+// source, and discards the least recently used once it is full.
+//
+// Each caller is given its own clone of the cached script, which shares
+// the compiled program but has its own variables, and virtual machine, so
+// callers may run their scripts concurrently, and set variables or change
+// their settings, without affecting each other.
+//
+// A CompileCache may be used from several goroutines concurrently.
+type CompileCache struct {
+
+	// size holds the number of scripts we'll store.
+	size int
+
+	// setup configures each script before it is prepared.
+	setup func(*Eval) error
+
+	// entries maps checksums to their position in order.
+	entries map[string]*list.Element
+
+	// order holds our entries, the most recently used first.
+	order *list.List
+
+	// stats holds our counts.
+	stats CacheStats
+
+	// mutex protects our state.
+	mutex sync.Mutex
+}
+
+// cacheEntry is a single prepared script within a CompileCache.
+type cacheEntry struct {
+	key  string
+	eval *Eval
+}
+
+// CacheStats holds the counts of a CompileCache's lookups.
+type CacheStats struct {
+
+	// Hits holds the number of scripts which were found in the cache.
+	Hits uint64
+
+	// Misses holds the number of scripts which had to be compiled.
+	Misses uint64
+
+	// Evictions holds the number of scripts which were discarded
+	// to make room for others.
+	Evictions uint64
+
+	// Entries holds the number of scripts currently cached.
+	Entries int
+}
+
+// NewCompileCache creates a cache which holds up to the given number of
+// prepared scripts.
+//
+// The setup function, which may be nil, is invoked for each script
+// before it is prepared, so that functions may be added and packages
+// enabled.
+func NewCompileCache(size int, setup func(*Eval) error) *CompileCache {
+
+	if size < 1 {
+		size = 1
+	}
+
+	return &CompileCache{
+		size:    size,
+		setup:   setup,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// checksum returns the key under which the given script, prepared with
+// the given flags, is stored.
+//
+// Each field is preceded by its length, so that no two combinations of
+// script and flags share a key.
+func checksum(script string, flags [][]byte) string {
+
+	h := sha256.New()
+	for _, field := range append([][]byte{[]byte(script)}, flags...) {
+		binary.Write(h, binary.BigEndian, uint64(len(field)))
+		h.Write(field)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Get returns the given script, prepared with the given flags.
+//
+// If the script has been prepared before a clone of it is returned from
+// the cache, otherwise it is compiled and stored, and a clone of that is
+// returned.  Scripts which fail to compile are not stored.
+func (c *CompileCache) Get(script string, flags ...[]byte) (*Eval, error) {
+
+	key := checksum(script, flags)
+
+	c.mutex.Lock()
+	if el, ok := c.entries[key]; ok {
+		c.order.MoveToFront(el)
+		c.stats.Hits++
+		eval := el.Value.(*cacheEntry).eval
+		c.mutex.Unlock()
+		return eval.Clone(), nil
+	}
+	c.stats.Misses++
+	c.mutex.Unlock()
+
+	//
+	// Compile the script without holding our lock, so that lookups
+	// of other scripts aren't delayed.
+	//
+	eval := New(script)
+	if c.setup != nil {
+		if err := c.setup(eval); err != nil {
+			return nil, fmt.Errorf("failed to setup script: %s", err)
+		}
+	}
+	if err := eval.Prepare(flags...); err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	//
+	// Another caller might have compiled the same script whilst we
+	// were, in which case we return a clone of the script they stored
+	// so that all callers share its program.
+	//
+	if el, ok := c.entries[key]; ok {
+		c.order.MoveToFront(el)
+		return el.Value.(*cacheEntry).eval.Clone(), nil
+	}
+
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, eval: eval})
+	for c.order.Len() > c.size {
+		old := c.order.Back()
+		c.order.Remove(old)
+		delete(c.entries, old.Value.(*cacheEntry).key)
+		c.stats.Evictions++
+	}
+	return eval.Clone(), nil
+}
+
+// Stats returns the counts of the cache's lookups.
+func (c *CompileCache) Stats() CacheStats {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	stats := c.stats
+	stats.Entries = c.order.Len()
+	return stats
+}
+
+// Purge discards all the cached scripts, leaving the counts alone.
+func (c *CompileCache) Purge() {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.entries = make(map[string]*list.Element)
+	c.order.Init()
+}
@@ -0,0 +1,205 @@
+package evalfilter
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/skx/evalfilter/v2/object"
+)
+
+// TestCompileCache tests that scripts are only compiled once.
+func TestCompileCache(t *testing.T) {
+
+	compiled := 0
+	cache := NewCompileCache(2, func(e *Eval) error {
+		compiled++
+		e.AddFunction("double", func(args []object.Object) object.Object {
+			return &object.Integer{Value: args[0].(*object.Integer).Value * 2}
+		})
+		return nil
+	})
+
+	a, err := cache.Get(`return double(age) == 36;`)
+	if err != nil {
+		t.Fatalf("failed to compile: %s", err)
+	}
+	b, err := cache.Get(`return double(age) == 36;`)
+	if err != nil {
+		t.Fatalf("failed to compile: %s", err)
+	}
+	if compiled != 1 {
+		t.Fatalf("the script was compiled twice")
+	}
+	if a == b {
+		t.Fatalf("callers were given the same script")
+	}
+
+	ok, err := b.Run(map[string]interface{}{"age": 18})
+	if err != nil || !ok {
+		t.Fatalf("unexpected result: %v %v", ok, err)
+	}
+
+	// The flags are part of the key.
+	c, err := cache.Get(`return double(age) == 36;`, []byte{NoOptimize})
+	if err != nil {
+		t.Fatalf("failed to compile: %s", err)
+	}
+	if c == a {
+		t.Fatalf("the flags were ignored")
+	}
+
+	// Scripts and flags don't run into each other.
+	keys := []struct {
+		script string
+		flags  [][]byte
+	}{
+		{"a", [][]byte{[]byte("b\x00c")}},
+		{"a", [][]byte{[]byte("b"), []byte("c")}},
+		{"a\x00b", nil},
+		{"a", [][]byte{[]byte("b")}},
+		{"a", [][]byte{{}}},
+		{"a", nil},
+	}
+	seen := make(map[string]int)
+	for i, k := range keys {
+		key := checksum(k.script, k.flags)
+		if j, ok := seen[key]; ok {
+			t.Fatalf("%q %q shares the key of %q %q", k.script, k.flags, keys[j].script, keys[j].flags)
+		}
+		seen[key] = i
+	}
+
+	// a is now the least recently used, so adding another script
+	// evicts it.
+	if _, err = cache.Get(`return true;`); err != nil {
+		t.Fatalf("failed to compile: %s", err)
+	}
+
+	stats := cache.Stats()
+	if stats.Hits != 1 || stats.Misses != 3 || stats.Evictions != 1 || stats.Entries != 2 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	if _, err = cache.Get(`return double(age) == 36;`); err != nil {
+		t.Fatalf("failed to compile: %s", err)
+	}
+	if compiled != 4 {
+		t.Fatalf("the evicted script wasn't compiled again")
+	}
+
+	cache.Purge()
+	if cache.Stats().Entries != 0 {
+		t.Fatalf("the cache wasn't purged")
+	}
+}
+
+// TestCompileCacheErrors tests that failures aren't cached.
+func TestCompileCacheErrors(t *testing.T) {
+
+	cache := NewCompileCache(10, nil)
+	for i := 0; i < 2; i++ {
+		_, err := cache.Get(`return 1 +;`)
+		if err == nil {
+			t.Fatalf("expected an error")
+		}
+	}
+	if stats := cache.Stats(); stats.Misses != 2 || stats.Entries != 0 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	cache = NewCompileCache(10, func(e *Eval) error {
+		return e.EnablePackage("bogus")
+	})
+	_, err := cache.Get(`return true;`)
+	if err == nil || !strings.Contains(err.Error(), "failed to setup") {
+		t.Fatalf("expected an error, got %v", err)
+	}
+}
+
+// TestCompileCacheConcurrent tests the cache may be used concurrently.
+func TestCompileCacheConcurrent(t *testing.T) {
+
+	cache := NewCompileCache(4, nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				eval, err := cache.Get(fmt.Sprintf(`return age == %d;`, j%6))
+				if err != nil {
+					t.Errorf("failed to compile: %s", err)
+					return
+				}
+				ok, err := eval.Run(map[string]interface{}{"age": j % 6})
+				if err != nil || !ok {
+					t.Errorf("unexpected result: %v %v", ok, err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	stats := cache.Stats()
+	if stats.Hits+stats.Misses != 400 || stats.Entries > 4 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+// TestCompileCacheIsolated tests that the callers of the cache don't
+// share the variables of their scripts.
+func TestCompileCacheIsolated(t *testing.T) {
+
+	cache := NewCompileCache(4, nil)
+
+	a, err := cache.Get(`return limit == age;`)
+	if err != nil {
+		t.Fatalf("failed to compile: %s", err)
+	}
+	a.SetVariable("limit", &object.Integer{Value: 18})
+
+	b, err := cache.Get(`return limit == age;`)
+	if err != nil {
+		t.Fatalf("failed to compile: %s", err)
+	}
+	if b.GetVariable("limit") != object.Nil {
+		t.Fatalf("the variable of one caller was seen by another")
+	}
+	b.SetVariable("limit", &object.Integer{Value: 21})
+
+	ok, err := a.Run(map[string]interface{}{"age": 18})
+	if err != nil || !ok {
+		t.Fatalf("the variable of one caller was changed by another: %v %v", ok, err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			eval, err := cache.Get(`return limit == age;`)
+			if err != nil {
+				t.Errorf("failed to compile: %s", err)
+				return
+			}
+			eval.SetVariable("limit", &object.Integer{Value: int64(i)})
+			for j := 0; j < 50; j++ {
+				ok, err := eval.Run(map[string]interface{}{"age": i})
+				if err != nil || !ok {
+					t.Errorf("caller %d saw another's variable: %v %v", i, ok, err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	stats := cache.Stats()
+	if stats.Entries != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
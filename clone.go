@@ -0,0 +1,73 @@
+// This file contains Clone, which copies an Eval so that the copy may be
+// run by another goroutine.
+//
+// The runs of an Eval are serialized, as it holds the state used whilst
+// it runs.  A clone shares the compiled script of the original, which is
+// never modified once it has been prepared, but has its own virtual
+// machine, stack, and variables - so the original and its clones may run
+// at the same time.
+
+package evalfilter
+
+// Clone returns a copy of the evaluator, which shares the bytecode and
+// constants of the script but none of the state used to run it, so that
+// the copy may be run concurrently with the original.
+//
+// The copy begins with its own copy of the variables, and the settings
+// and functions of the original.  Recorders and tracers aren't copied, as
+// they can't be shared, whilst the counts kept by SetClauseStatistics
+// begin again from zero.
+//
+// Unlike a Program the copy may be configured, and prepared, as the
+// original might be.
+func (e *Eval) Clone() *Eval {
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	c := &Eval{
+		Script:          e.Script,
+		environment:     e.environment.Copy(),
+		constants:       e.constants,
+		instructions:    e.instructions,
+		sources:         e.sources,
+		context:         e.context,
+		mode:            e.mode,
+		memory:          e.memory,
+		maxInstructions: e.maxInstructions,
+		loops:           e.loops,
+		iterations:      e.iterations,
+		pragmas:         e.pragmas,
+		prefix:          e.prefix,
+		stringerFields:  e.stringerFields,
+		fieldTag:        e.fieldTag,
+		language:        e.language,
+		schema:          e.schema,
+		fields:          e.fields,
+		arena:           e.arena,
+		functions:       e.functions,
+		warnings:        e.warnings,
+		warned:          e.warned,
+		loaded:          e.loaded,
+		explain:         e.explain,
+		explained:       e.explained,
+		statistics:      e.statistics,
+		reorder:         e.reorder,
+		hints:           e.hints,
+		sql:             e.sql,
+	}
+
+	// Each clone counts its own clauses.
+	for _, clause := range e.clauses {
+		var cc *ClauseStatistic
+		if clause != nil {
+			cc = &ClauseStatistic{Line: clause.Line, Column: clause.Column, Expression: clause.Expression}
+		}
+		c.clauses = append(c.clauses, cc)
+	}
+
+	if e.machine != nil {
+		c.machine = e.machine.Clone(c.environment)
+	}
+	return c
+}
@@ -0,0 +1,89 @@
+package evalfilter
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/skx/evalfilter/v2/object"
+)
+
+// TestClone tests that clones may be run concurrently, without seeing
+// each other's variables.
+func TestClone(t *testing.T) {
+
+	eval := New(`
+total = 0;
+foreach item in Items { total = total + item; }
+Runs++;
+return double(total) == Limit;
+`)
+	eval.AddFunction("double", func(args []object.Object) object.Object {
+		return &object.Integer{Value: args[0].(*object.Integer).Value * 2}
+	})
+	eval.SetVariableInt("Runs", 0)
+	eval.SetClauseStatistics(true)
+	err := eval.Prepare()
+	if err != nil {
+		t.Fatalf("failed to compile: %s", err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+
+		c := eval.Clone()
+		c.SetVariableInt("Limit", int64(2*(i+1)*(i+2)))
+
+		go func(i int, c *Eval) {
+			defer wg.Done()
+
+			items := make([]int, i+1)
+			for k := range items {
+				items[k] = 2 * (k + 1)
+			}
+			for j := 0; j < 100; j++ {
+				ok, err := c.Run(map[string]interface{}{"Items": items})
+				if err != nil {
+					errs <- err
+					return
+				}
+				if !ok {
+					errs <- fmt.Errorf("unexpected result for clone %d, run %d", i, j)
+					return
+				}
+			}
+			if c.GetVariable("Runs").Inspect() != "100" {
+				errs <- fmt.Errorf("unexpected runs %s for clone %d", c.GetVariable("Runs").Inspect(), i)
+			}
+			stats := c.ClauseStatistics()
+			if len(stats) != 1 || stats[0].True != 100 {
+				errs <- fmt.Errorf("unexpected statistics for clone %d: %v", i, stats)
+			}
+		}(i, c)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	// The original is untouched.
+	if eval.GetVariable("Runs").Inspect() != "0" {
+		t.Fatalf("unexpected runs %s", eval.GetVariable("Runs").Inspect())
+	}
+	if stats := eval.ClauseStatistics(); len(stats) != 1 || stats[0].True != 0 {
+		t.Fatalf("unexpected statistics %v", stats)
+	}
+
+	// A clone of a script which hasn't been prepared may be
+	// prepared itself.
+	c := New(`return Value == 3;`).Clone()
+	if err = c.Prepare(); err != nil {
+		t.Fatalf("failed to compile the clone: %s", err)
+	}
+	if ok, err := c.Run(map[string]int{"Value": 3}); err != nil || !ok {
+		t.Fatalf("unexpected result %t %v", ok, err)
+	}
+}
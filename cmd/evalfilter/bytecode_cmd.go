@@ -12,6 +12,18 @@ import (
 type bytecodeCmd struct {
 	// Disable the bytecode optimizer
 	raw bool
+
+	// The format to show the bytecode in.
+	output string
+}
+
+// bytecodeReport is the JSON form of our output, for a single script.
+type bytecodeReport struct {
+	File     string
+	Warnings []string
+	Error    string
+
+	*evalfilter.Disassembly
 }
 
 // Info returns the name of this subcommand.
@@ -28,6 +40,7 @@ Example:
 
   $ evalfilter bytecode -no-optimizer script.in
   $ evalfilter bytecode script.in
+  $ evalfilter bytecode -output json script.in
 
 `
 }
@@ -35,7 +48,39 @@ Example:
 // Arguments adds per-command args to the object.
 func (b *bytecodeCmd) Arguments(f *flag.FlagSet) {
 	f.BoolVar(&b.raw, "no-optimizer", false, "Disable the bytecode optimizer")
+	outputFlag(f, &b.output)
+}
+
+// Report compiles the given script, and returns the JSON form of
+// its bytecode.
+func (b *bytecodeCmd) Report(file string) bytecodeReport {
+
+	report := bytecodeReport{File: file, Warnings: []string{}}
+
+	dat, err := ioutil.ReadFile(file)
+	if err != nil {
+		report.Error = fmt.Sprintf("error reading file %s - %s", file, err.Error())
+		return report
+	}
+
+	eval := evalfilter.New(string(dat))
+
+	var flags []byte
+	if b.raw {
+		flags = append(flags, evalfilter.NoOptimize)
+	}
+	err = eval.Prepare(flags)
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	report.Warnings = append(report.Warnings, eval.Warnings()...)
 
+	report.Disassembly, err = eval.Disassemble()
+	if err != nil {
+		report.Error = err.Error()
+	}
+	return report
 }
 
 // Show the bytecode of the given script.
@@ -69,6 +114,9 @@ func (b *bytecodeCmd) Run(file string) {
 		fmt.Printf("Error compiling:%s\n", err.Error())
 		return
 	}
+	for _, w := range eval.Warnings() {
+		fmt.Printf("Warning: %s\n", w)
+	}
 
 	//
 	// Show the bytecode
@@ -84,6 +132,28 @@ func (b *bytecodeCmd) Run(file string) {
 // Execute is invoked if the user specifies `bytecode` as the subcommand.
 func (b *bytecodeCmd) Execute(args []string) int {
 
+	if !validOutput(b.output) {
+		return 1
+	}
+
+	//
+	// JSON output is a single array, holding a report for each
+	// of the files.
+	//
+	if b.output == "json" {
+		reports := []bytecodeReport{}
+		status := 0
+		for _, file := range args {
+			report := b.Report(file)
+			if report.Error != "" {
+				status = 1
+			}
+			reports = append(reports, report)
+		}
+		printJSON(reports)
+		return status
+	}
+
 	//
 	// For each file we've been passed; run it.
 	//
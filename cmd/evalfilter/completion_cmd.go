@@ -0,0 +1,169 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/skx/subcommands"
+)
+
+// Structure for our options and state.
+type completionCmd struct {
+
+	// We embed the NoFlags option, because we accept no command-line flags.
+	subcommands.NoFlags
+
+	// The subcommands we're to complete.
+	commands []subcommands.Subcommand
+}
+
+// completion describes a subcommand which may be completed.
+type completion struct {
+
+	// Name holds the name of the subcommand.
+	Name string
+
+	// Synopsis holds the first line of its help-text.
+	Synopsis string
+
+	// Flags holds the flags it accepts, along with their usage.
+	Flags []*flag.Flag
+}
+
+// Info returns the name of this subcommand.
+func (c *completionCmd) Info() (string, string) {
+	return "completion", `Generate a shell completion-script.
+
+This sub-command outputs a script which completes the names of our
+subcommands, and their flags, for the bash, zsh, or fish shells.  For
+bash it is the same as the bash-completion sub-command.
+
+Example:
+
+  $ source <(evalfilter completion bash)
+  $ evalfilter completion zsh > ~/.zsh/completion/_evalfilter
+  $ evalfilter completion fish > ~/.config/fish/completions/evalfilter.fish
+`
+}
+
+// completions returns the subcommands, and their flags, sorted by name.
+func (c *completionCmd) completions() []completion {
+
+	var out []completion
+	for _, cmd := range c.commands {
+		name, help := cmd.Info()
+
+		// Collect the flags the command would accept.
+		f := flag.NewFlagSet(name, flag.ContinueOnError)
+		cmd.Arguments(f)
+
+		var flags []*flag.Flag
+		f.VisitAll(func(fl *flag.Flag) {
+			flags = append(flags, fl)
+		})
+
+		out = append(out, completion{
+			Name:     name,
+			Synopsis: strings.SplitN(help, "\n", 2)[0],
+			Flags:    flags,
+		})
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
+
+// quote escapes the given text for use within a single-quoted string,
+// for all the shells we support.
+func quote(text string) string {
+	return "'" + strings.ReplaceAll(text, "'", `'"'"'`) + "'"
+}
+
+// zsh returns the completion-script for zsh.
+func (c *completionCmd) zsh() string {
+
+	var commands strings.Builder
+	var cases strings.Builder
+	for _, cmp := range c.completions() {
+		fmt.Fprintf(&commands, "        %s\n", quote(cmp.Name+":"+cmp.Synopsis))
+
+		if len(cmp.Flags) == 0 {
+			continue
+		}
+		fmt.Fprintf(&cases, "        %s)\n            _arguments \\\n", cmp.Name)
+		for _, f := range cmp.Flags {
+			fmt.Fprintf(&cases, "                %s \\\n", quote("-"+f.Name+"["+strings.NewReplacer("[", `\[`, "]", `\]`).Replace(f.Usage)+"]"))
+		}
+		fmt.Fprintf(&cases, "                '*:file:_files'\n            ;;\n")
+	}
+
+	return fmt.Sprintf(`#compdef evalfilter
+
+_evalfilter() {
+    local -a commands
+    commands=(
+%s    )
+
+    # The first argument is one of the available sub-commands.
+    if (( CURRENT == 2 )); then
+        _describe 'command' commands
+        return
+    fi
+
+    # Otherwise complete the flags of the sub-command, and files.
+    case "${words[2]}" in
+%s        *)
+            _files
+            ;;
+    esac
+}
+
+_evalfilter "$@"
+`, commands.String(), cases.String())
+}
+
+// fish returns the completion-script for fish.
+func (c *completionCmd) fish() string {
+
+	var out strings.Builder
+	out.WriteString("complete -c evalfilter -f\n")
+	for _, cmp := range c.completions() {
+		fmt.Fprintf(&out, "complete -c evalfilter -n __fish_use_subcommand -a %s -d %s\n", cmp.Name, quote(cmp.Synopsis))
+	}
+	for _, cmp := range c.completions() {
+		fmt.Fprintf(&out, "complete -c evalfilter -n %s -F\n", quote("__fish_seen_subcommand_from "+cmp.Name))
+		for _, f := range cmp.Flags {
+			fmt.Fprintf(&out, "complete -c evalfilter -n %s -o %s -d %s\n", quote("__fish_seen_subcommand_from "+cmp.Name), f.Name, quote(f.Usage))
+		}
+	}
+	return out.String()
+}
+
+// Execute is invoked if the user specifies `completion` as the subcommand.
+func (c *completionCmd) Execute(args []string) int {
+
+	if len(args) != 1 {
+		fmt.Printf("Usage: completion bash|zsh|fish\n")
+		return 1
+	}
+
+	switch args[0] {
+	case "bash":
+		// The script of the bash-completion subcommand, which
+		// every user of our subcommands has, already completes
+		// subcommands and flags.
+		return (&subcommands.BashCompletion{}).Execute(nil)
+	case "zsh":
+		fmt.Print(c.zsh())
+	case "fish":
+		fmt.Print(c.fish())
+	default:
+		fmt.Printf("Unknown shell %s, valid choices are 'bash', 'zsh', and 'fish'\n", args[0])
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/skx/evalfilter/v2"
+	"github.com/skx/evalfilter/v2/stream"
+)
+
+// Structure for our options and state.
+type consumeCmd struct {
+
+	// The file to read messages from.
+	input string
+
+	// The file to write matching messages to.
+	out string
+
+	// The directory to write the matches of each rule to.
+	split string
+
+	// Disable the bytecode optimizer
+	noOptimizer bool
+
+	// Optional packages to enable, comma-separated.
+	packages string
+
+	// The format to show the count of messages in.
+	output string
+
+	// The files we've created, which must be closed.
+	files []io.Closer
+}
+
+// consumeReport is the JSON form of the count of messages.
+type consumeReport struct {
+	Received int
+	Matched  int
+	Rules    map[string]int
+	Error    string
+}
+
+// Info returns the name of this subcommand.
+func (c *consumeCmd) Info() (string, string) {
+	return "consume", `Filter a stream of JSON messages with a set of rules.
+
+This sub-command reads newline-delimited JSON messages, and runs each of
+the specified scripts against them.  Each script is a rule, named after
+its file, and every message which matches at least one rule is output.
+
+Messages are read from STDIN by default, so the output of a broker's
+command-line consumer may be filtered directly.
+
+Example:
+
+  $ kcat -C -b localhost -t logs | evalfilter consume errors.in slow.in
+  $ nsq_tail -topic logs | evalfilter consume -split out/ errors.in slow.in
+
+Using -split writes the messages matching each rule to a file of the
+same name in the given directory, so a message matching several rules
+is written to each of their files.
+
+`
+}
+
+// Arguments adds per-command args to the object.
+func (c *consumeCmd) Arguments(f *flag.FlagSet) {
+	f.StringVar(&c.input, "input", "-", "The file to read messages from, or '-' for STDIN.")
+	f.StringVar(&c.out, "out", "-", "The file to write matching messages to, or '-' for STDOUT.")
+	f.StringVar(&c.split, "split", "", "Write the messages matching each rule to a file in the specified directory, instead of to the output.")
+	f.BoolVar(&c.noOptimizer, "no-optimizer", false, "Disable the bytecode optimizer.")
+	f.StringVar(&c.packages, "packages", "", "Enable the specified comma-separated packages of functions, e.g. 'strings,net'.")
+	outputFlag(f, &c.output)
+}
+
+// rules loads each of the scripts into a RuleSet, naming them after their
+// files.
+func (c *consumeCmd) rules(files []string) (*evalfilter.RuleSet, error) {
+
+	var flags []byte
+	if c.noOptimizer {
+		flags = append(flags, evalfilter.NoOptimize)
+	}
+
+	set := evalfilter.NewRuleSet()
+	for _, file := range files {
+
+		dat, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("reading file %s - %s", file, err.Error())
+		}
+
+		eval := evalfilter.New(string(dat))
+		if c.packages != "" {
+			for _, name := range strings.Split(c.packages, ",") {
+				err = eval.EnablePackage(strings.TrimSpace(name))
+				if err != nil {
+					return nil, fmt.Errorf("enabling package: %s", err.Error())
+				}
+			}
+		}
+
+		err = eval.Prepare(flags)
+		if err != nil {
+			return nil, fmt.Errorf("compiling %s: %s", file, err.Error())
+		}
+
+		name := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+		err = set.AddRule(name, eval)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return set, nil
+}
+
+// sink returns the sink matching messages should be sent to.
+func (c *consumeCmd) sink() (stream.Sink, error) {
+
+	if c.split != "" {
+		err := os.MkdirAll(c.split, 0755)
+		if err != nil {
+			return nil, err
+		}
+
+		sink := stream.FanOut(func(rule string) (stream.Sink, error) {
+			handle, err := os.Create(filepath.Join(c.split, rule+".json"))
+			if err != nil {
+				return nil, err
+			}
+			c.files = append(c.files, handle)
+			return stream.Writer(handle), nil
+		})
+		return sink, nil
+	}
+
+	if c.out == "-" {
+		return stream.Writer(os.Stdout), nil
+	}
+
+	handle, err := os.Create(c.out)
+	if err != nil {
+		return nil, err
+	}
+	c.files = append(c.files, handle)
+	return stream.Writer(handle), nil
+}
+
+// Consume reads the messages, and filters them with the given rules,
+// returning the number received and matched.
+func (c *consumeCmd) Consume(files []string) (stream.Stats, error) {
+
+	var stats stream.Stats
+
+	set, err := c.rules(files)
+	if err != nil {
+		return stats, err
+	}
+
+	var in io.Reader = os.Stdin
+	if c.input != "-" {
+		handle, err := os.Open(c.input)
+		if err != nil {
+			return stats, fmt.Errorf("opening file %s - %s", c.input, err.Error())
+		}
+		defer handle.Close()
+		in = handle
+	}
+
+	sink, err := c.sink()
+	if err != nil {
+		return stats, fmt.Errorf("creating output - %s", err.Error())
+	}
+	defer func() {
+		for _, f := range c.files {
+			f.Close()
+		}
+	}()
+
+	return stream.Consume(stream.Lines(in), set, sink)
+}
+
+// Execute is invoked if the user specifies `consume` as the subcommand.
+func (c *consumeCmd) Execute(args []string) int {
+
+	if !validOutput(c.output) {
+		return 1
+	}
+
+	if len(args) < 1 {
+		fmt.Fprintf(os.Stderr, "Usage: evalfilter consume [flags] rule.in [rule.in..]\n")
+		return 1
+	}
+
+	stats, err := c.Consume(args)
+
+	// The count is shown upon STDERR, as the messages may be shown
+	// upon STDOUT.
+	if c.output == "json" {
+		report := consumeReport{Received: stats.Received, Matched: stats.Matched, Rules: stats.Rules}
+		if err != nil {
+			report.Error = err.Error()
+		}
+		writeJSON(os.Stderr, report)
+	} else {
+		if stats.Received > 0 || err == nil {
+			fmt.Fprintf(os.Stderr, "Received %d messages, %d matched.\n", stats.Received, stats.Matched)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error %s\n", err.Error())
+		}
+	}
+	if err != nil {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/skx/evalfilter/v2/convert"
+	"github.com/skx/evalfilter/v2/sigma"
+	"github.com/skx/evalfilter/v2/sqlwhere"
+)
+
+// Structure for our options and state.
+type convertCmd struct {
+
+	// The language the expressions are written in.
+	from string
+
+	// The format to show the scripts in.
+	output string
+}
+
+// convertReport is the JSON form of our output, for a single expression.
+type convertReport struct {
+	File      string
+	Script    string
+	Error     string
+	Construct string
+}
+
+// converters maps the languages we can convert from to the functions
+// which convert them.
+var converters = map[string]func(string) (string, error){
+	"cel":   convert.FromCEL,
+	"jq":    convert.FromJQ,
+	"sigma": sigmaScripts,
+	"sql":   sqlwhere.Translate,
+}
+
+// sigmaScripts compiles the Sigma rules in the given file, and returns
+// their scripts, each preceded by a comment holding its title.
+func sigmaScripts(text string) (string, error) {
+
+	rules, err := sigma.Compile([]byte(text))
+	if err != nil {
+		return "", err
+	}
+
+	var out []string
+	for _, rule := range rules {
+		script := "// " + rule.Title + "\n" + rule.Script
+		if len(rule.Packages) > 0 {
+			script = "// requires the packages " + strings.Join(rule.Packages, ", ") + "\n" + script
+		}
+		out = append(out, script)
+	}
+	return strings.Join(out, "\n\n"), nil
+}
+
+// Info returns the name of this subcommand.
+func (c *convertCmd) Info() (string, string) {
+	return "convert", `Convert the expressions of other filter languages into scripts.
+
+This sub-command translates the filter expressions of CEL, jq, or the
+WHERE clauses of SQL, into evalfilter scripts.  Each of the files given
+holds a single expression - or a YAML stream of Sigma detection rules.
+Constructs which have no equivalent, such as CEL's macros or jq's
+iteration, are reported rather than translated.
+
+Example:
+
+  $ evalfilter convert -from cel rule.cel
+  $ evalfilter convert -from jq -output json filter.jq
+`
+}
+
+// Arguments adds per-command args to the object.
+func (c *convertCmd) Arguments(f *flag.FlagSet) {
+	f.StringVar(&c.from, "from", "", "The language the expressions are written in, one of 'cel', 'jq', 'sigma', or 'sql'.")
+	outputFlag(f, &c.output)
+}
+
+// Report converts the expression within the given file, and returns the
+// JSON form of the script it became.
+func (c *convertCmd) Report(file string) convertReport {
+
+	report := convertReport{File: file}
+
+	dat, err := ioutil.ReadFile(file)
+	if err != nil {
+		report.Error = fmt.Sprintf("error reading file %s - %s", file, err.Error())
+		return report
+	}
+
+	script, err := converters[c.from](strings.TrimSpace(string(dat)))
+	if err != nil {
+		report.Error = err.Error()
+		if cerr, ok := err.(*convert.Error); ok {
+			report.Construct = cerr.Construct
+		}
+		return report
+	}
+	report.Script = script
+	return report
+}
+
+// Execute is invoked if the user specifies `convert` as the subcommand.
+func (c *convertCmd) Execute(args []string) int {
+
+	if !validOutput(c.output) {
+		return 1
+	}
+	if _, ok := converters[c.from]; !ok {
+		fmt.Printf("Unknown language '%s', valid choices are 'cel', 'jq', 'sigma', and 'sql'\n", c.from)
+		return 1
+	}
+
+	status := 0
+	reports := []convertReport{}
+	for _, file := range args {
+		report := c.Report(file)
+		if report.Error != "" {
+			status = 1
+		}
+		reports = append(reports, report)
+	}
+
+	if c.output == "json" {
+		printJSON(reports)
+		return status
+	}
+
+	for _, report := range reports {
+		if len(reports) > 1 {
+			fmt.Printf("// %s\n", report.File)
+		}
+		if report.Error != "" {
+			fmt.Printf("Error converting %s - %s\n", report.File, report.Error)
+			continue
+		}
+		fmt.Printf("%s\n", report.Script)
+	}
+	return status
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/skx/evalfilter/v2"
+	"github.com/skx/evalfilter/v2/object"
+	"github.com/skx/evalfilter/v2/vm"
+)
+
+// Structure for our options and state.
+type diffCmd struct {
+
+	// Disable the bytecode optimizer
+	raw bool
+
+	// The JSON file holding the object of the first run.
+	left string
+
+	// The JSON file holding the object of the second run.
+	right string
+
+	// The number of steps to show, after the traces diverge.
+	steps int
+
+	// The format to show the differences in.
+	output string
+}
+
+// diffReport is the JSON form of our output.
+type diffReport struct {
+	Error string
+	Runs  []diffRun
+
+	// Same, and Diverged, hold the number of steps which were the
+	// same, and which executed the same instructions, as in
+	// vm.TraceDiff.
+	Identical bool
+	Same      int
+	Diverged  int
+
+	// Left, and Right, hold the steps of each trace from the first
+	// which differed, limited by -steps after they diverge.
+	Left  []string
+	Right []string
+
+	// diff holds the differences, for our text output.
+	diff *vm.TraceDiff
+}
+
+// diffRun is the JSON form of the result of a single run.
+type diffRun struct {
+	File  string
+	Input string
+	Type  object.Type
+	Value string
+	True  bool
+	Error string
+}
+
+// Info returns the name of this subcommand.
+func (d *diffCmd) Info() (string, string) {
+	return "diff", `Compare the traces of two runs of a script.
+
+This sub-command runs a script twice, recording each instruction which
+is executed along with the contents of the stack, and then shows the
+point at which the two runs differed.
+
+The runs may be of the same script against two objects, which explains
+why one object matched when the other didn't, or of two versions of a
+script against the same object.
+
+Example:
+
+  $ evalfilter diff -left a.json -right b.json script.in
+  $ evalfilter diff -left a.json old.in new.in
+
+`
+}
+
+// Arguments adds per-command args to the object.
+func (d *diffCmd) Arguments(f *flag.FlagSet) {
+	f.StringVar(&d.left, "left", "", "Run the first script against the object contained within the specified JSON file.")
+	f.StringVar(&d.right, "right", "", "Run the second script against the object contained within the specified JSON file, by default the same as -left.")
+	f.BoolVar(&d.raw, "no-optimizer", false, "Disable the bytecode optimizer.")
+	f.IntVar(&d.steps, "steps", 10, "The number of steps of each trace to show, after they differ.")
+	outputFlag(f, &d.output)
+}
+
+// load returns the object contained within the given JSON file, or an
+// empty object if no file was specified.
+func (d *diffCmd) load(file string) (map[string]interface{}, error) {
+
+	obj := make(map[string]interface{})
+	if file == "" {
+		return obj, nil
+	}
+
+	dat, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(dat, &obj)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing JSON %s - %s", file, err.Error())
+	}
+	return obj, nil
+}
+
+// trace runs the given script against the object, and returns the steps
+// it took along with the result of the run.
+func (d *diffCmd) trace(file string, obj interface{}) ([]vm.Step, diffRun, error) {
+
+	run := diffRun{File: file}
+
+	dat, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, run, err
+	}
+
+	eval := evalfilter.New(string(dat))
+
+	var flags []byte
+	if d.raw {
+		flags = append(flags, evalfilter.NoOptimize)
+	}
+	err = eval.Prepare(flags)
+	if err != nil {
+		return nil, run, fmt.Errorf("error compiling %s - %s", file, err.Error())
+	}
+
+	tracer := vm.NewTracer()
+	eval.SetTracer(tracer)
+
+	ret, err := eval.Execute(obj)
+	if err != nil {
+		run.Error = err.Error()
+	} else {
+		run.Type = ret.Type()
+		run.Value = ret.Inspect()
+		run.True = eval.True(ret)
+	}
+
+	return tracer.Steps, run, nil
+}
+
+// Report runs the scripts, and compares their traces.
+func (d *diffCmd) Report(scripts []string) diffReport {
+
+	report := diffReport{Runs: []diffRun{}}
+
+	var traces [2][]vm.Step
+	for i, file := range []string{d.left, d.right} {
+
+		obj, err := d.load(file)
+		if err != nil {
+			report.Error = fmt.Sprintf("error reading file %s - %s", file, err.Error())
+			return report
+		}
+
+		var run diffRun
+		traces[i], run, err = d.trace(scripts[i], obj)
+		if err != nil {
+			report.Error = err.Error()
+			return report
+		}
+		run.Input = file
+		report.Runs = append(report.Runs, run)
+	}
+
+	diff := vm.DiffTraces(traces[0], traces[1])
+	report.Identical = diff.Identical()
+	report.Same = diff.Same
+	report.Diverged = diff.Diverged
+	report.Left = d.limit(diff.Left, diff.Diverged-diff.Same)
+	report.Right = d.limit(diff.Right, diff.Diverged-diff.Same)
+	report.diff = diff
+	return report
+}
+
+// limit returns the given steps, as text, stopping once the given number
+// which ran the same instructions are followed by -steps others.
+func (d *diffCmd) limit(steps []vm.Step, same int) []string {
+
+	out := []string{}
+	for i, s := range steps {
+		if i >= same+d.steps {
+			break
+		}
+		out = append(out, s.String())
+	}
+	return out
+}
+
+// Execute is invoked if the user specifies `diff` as the subcommand.
+func (d *diffCmd) Execute(args []string) int {
+
+	if !validOutput(d.output) {
+		return 1
+	}
+	if len(args) < 1 || len(args) > 2 {
+		fmt.Printf("Usage: diff [-left a.json] [-right b.json] script.in [other.in]\n")
+		return 1
+	}
+
+	// The same script may be run twice.
+	scripts := []string{args[0], args[0]}
+	if len(args) == 2 {
+		scripts[1] = args[1]
+	}
+
+	// As may the same object.
+	if d.right == "" {
+		d.right = d.left
+	}
+
+	report := d.Report(scripts)
+	status := 0
+	if report.Error != "" {
+		status = 1
+	}
+
+	if d.output == "json" {
+		printJSON(report)
+		return status
+	}
+
+	for _, run := range report.Runs {
+		if run.Error != "" {
+			fmt.Printf("%s failed: %s\n", run.File, run.Error)
+		} else {
+			fmt.Printf("%s gave result type:%s value:%s\n", run.File, run.Type, run.Value)
+		}
+	}
+	if report.Error != "" {
+		fmt.Printf("%s\n", report.Error)
+		return status
+	}
+
+	fmt.Printf("\n%s", report.diff.Describe(d.steps))
+	return status
+}
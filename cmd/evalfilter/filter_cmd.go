@@ -0,0 +1,206 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/skx/evalfilter/v2"
+	"github.com/skx/evalfilter/v2/csvadapter"
+)
+
+// Structure for our options and state.
+type filterCmd struct {
+
+	// The CSV file to filter.
+	csvFile string
+
+	// The delimiter between fields.
+	delimiter string
+
+	// Present every field as a string.
+	raw bool
+
+	// Disable the bytecode optimizer
+	noOptimizer bool
+
+	// Optional packages to enable, comma-separated.
+	packages string
+
+	// The format to show the matching rows in.
+	output string
+}
+
+// filterReport is the JSON form of our output.
+type filterReport struct {
+	Error   string
+	Matched int
+	Rows    []map[string]interface{}
+}
+
+// Info returns the name of this subcommand.
+func (f *filterCmd) Info() (string, string) {
+	return "filter", `Filter the rows of a CSV file with a script.
+
+This sub-command runs the specified evalfilter-script against each row
+of a CSV file, and outputs those rows for which the script returned
+true.
+
+The first row of the file must be a header, which names the columns,
+and the values of each row are available to the script via those names.
+
+Example:
+
+  $ evalfilter filter -csv data.csv script.in
+  $ cat data.csv | evalfilter filter -csv - script.in
+  $ evalfilter filter -output json -csv data.csv script.in
+
+With '-output json' the matching rows are shown as JSON objects, keyed
+by the names of the columns, rather than as CSV.
+
+`
+}
+
+// Arguments adds per-command args to the object.
+func (f *filterCmd) Arguments(fs *flag.FlagSet) {
+	fs.StringVar(&f.csvFile, "csv", "", "The CSV file to filter, or '-' to read from STDIN.")
+	fs.StringVar(&f.delimiter, "delimiter", ",", "The character which separates fields, '\\t' may be used for tabs.")
+	fs.BoolVar(&f.raw, "raw", false, "Present every field to the script as a string.")
+	fs.BoolVar(&f.noOptimizer, "no-optimizer", false, "Disable the bytecode optimizer.")
+	fs.StringVar(&f.packages, "packages", "", "Enable the specified comma-separated packages of functions, e.g. 'strings,net'.")
+	outputFlag(fs, &f.output)
+}
+
+// Filter the CSV data with the given script.
+//
+// As the matching rows are written to STDOUT any errors are reported
+// upon STDERR.
+func (f *filterCmd) Filter(file string) int {
+
+	//
+	// Read the script contents.
+	//
+	dat, err := ioutil.ReadFile(file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading file %s - %s\n", file, err.Error())
+		return 1
+	}
+
+	//
+	// Create the evaluator, and enable any packages we've been
+	// asked to.
+	//
+	eval := evalfilter.New(string(dat))
+	if f.packages != "" {
+		for _, name := range strings.Split(f.packages, ",") {
+			err = eval.EnablePackage(strings.TrimSpace(name))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error enabling package: %s\n", err.Error())
+				return 1
+			}
+		}
+	}
+
+	var flags []byte
+	if f.noOptimizer {
+		flags = append(flags, evalfilter.NoOptimize)
+	}
+	err = eval.Prepare(flags)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error compiling:%s\n", err.Error())
+		return 1
+	}
+
+	//
+	// Open the data.
+	//
+	var in io.Reader = os.Stdin
+	if f.csvFile != "-" {
+		handle, err := os.Open(f.csvFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening file %s - %s\n", f.csvFile, err.Error())
+			return 1
+		}
+		defer handle.Close()
+		in = handle
+	}
+
+	reader := csvadapter.New(in)
+	reader.Comma, _ = utf8.DecodeRuneInString(f.delimiter)
+	reader.Raw = f.raw
+
+	if f.output == "json" {
+		report := f.Report(eval, reader)
+		printJSON(report)
+		if report.Error != "" {
+			return 1
+		}
+		return 0
+	}
+
+	_, err = reader.Filter(eval, os.Stdout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error filtering CSV - %s\n", err.Error())
+		return 1
+	}
+	return 0
+}
+
+// Report runs the script against each row of the CSV data, and returns
+// the JSON form of those which matched.
+func (f *filterCmd) Report(eval *evalfilter.Eval, reader *csvadapter.Reader) filterReport {
+
+	report := filterReport{Rows: []map[string]interface{}{}}
+	for {
+		row, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			report.Error = err.Error()
+			break
+		}
+
+		match, err := eval.Run(row)
+		if err != nil {
+			report.Error = fmt.Sprintf("row %d: %s", reader.Row(), err)
+			break
+		}
+		if match {
+			report.Matched++
+			report.Rows = append(report.Rows, row)
+		}
+	}
+	return report
+}
+
+// Execute is invoked if the user specifies `filter` as the subcommand.
+func (f *filterCmd) Execute(args []string) int {
+
+	if !validOutput(f.output) {
+		return 1
+	}
+
+	if f.csvFile == "" {
+		fmt.Fprintf(os.Stderr, "Usage: evalfilter filter -csv data.csv script.in\n")
+		return 1
+	}
+	// Allow tabs to be specified without shell-quoting.
+	if f.delimiter == "\\t" {
+		f.delimiter = "\t"
+	}
+	if utf8.RuneCountInString(f.delimiter) != 1 {
+		fmt.Fprintf(os.Stderr, "The delimiter must be a single character\n")
+		return 1
+	}
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: evalfilter filter -csv data.csv script.in\n")
+		return 1
+	}
+
+	return f.Filter(args[0])
+}
@@ -1,18 +1,26 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"io/ioutil"
 
 	"github.com/skx/evalfilter/v2/lexer"
-	"github.com/skx/subcommands"
+	"github.com/skx/evalfilter/v2/token"
 )
 
 // Structure for our options and state.
 type lexCmd struct {
 
-	// We embed the NoFlags option, because we accept no command-line flags.
-	subcommands.NoFlags
+	// The format to show the tokens in.
+	output string
+}
+
+// lexReport is the JSON form of our output, for a single script.
+type lexReport struct {
+	File   string
+	Tokens []token.Token
+	Error  string
 }
 
 // Info returns the name of this subcommand.
@@ -24,10 +32,43 @@ split into tokens by our lexer.
 
 Example:
 
-  $ evalfilter run script.in
+  $ evalfilter lex script.in
+  $ evalfilter lex -output json script.in
 `
 }
 
+// Arguments adds per-command args to the object.
+func (l *lexCmd) Arguments(f *flag.FlagSet) {
+	outputFlag(f, &l.output)
+}
+
+// Report lexes the specified file, and returns the JSON form of the
+// tokens that were produced.
+func (l *lexCmd) Report(file string) lexReport {
+
+	report := lexReport{File: file, Tokens: []token.Token{}}
+
+	dat, err := ioutil.ReadFile(file)
+	if err != nil {
+		report.Error = fmt.Sprintf("error reading file %s - %s", file, err.Error())
+		return report
+	}
+
+	lex := lexer.New(string(dat))
+	for {
+		tok := lex.NextToken()
+		report.Tokens = append(report.Tokens, tok)
+		if tok.Type == token.EOF {
+			break
+		}
+		if tok.Type == token.ILLEGAL {
+			report.Error = fmt.Sprintf("line %d, column %d: %s", tok.Line, tok.Column, tok.Literal)
+			break
+		}
+	}
+	return report
+}
+
 // Lex actually lexes the specified file, and shows the tokens that
 // were produced.
 func (l *lexCmd) Lex(file string) {
@@ -61,6 +102,28 @@ func (l *lexCmd) Lex(file string) {
 // Execute is invoked if the user specifies `lex` as the subcommand.
 func (l *lexCmd) Execute(args []string) int {
 
+	if !validOutput(l.output) {
+		return 1
+	}
+
+	//
+	// JSON output is a single array, holding a report for each
+	// of the files.
+	//
+	if l.output == "json" {
+		reports := []lexReport{}
+		status := 0
+		for _, file := range args {
+			report := l.Report(file)
+			if report.Error != "" {
+				status = 1
+			}
+			reports = append(reports, report)
+		}
+		printJSON(reports)
+		return status
+	}
+
 	//
 	// For each file we've been passed.
 	//
@@ -22,10 +22,30 @@ func main() {
 		}
 	}()
 
-	subcommands.Register(&lexCmd{})
-	subcommands.Register(&bytecodeCmd{})
-	subcommands.Register(&parseCmd{})
-	subcommands.Register(&runCmd{})
+	commands := []subcommands.Subcommand{
+		&lexCmd{},
+		&bytecodeCmd{},
+		&consumeCmd{},
+		&convertCmd{},
+		&diffCmd{},
+		&filterCmd{},
+		&parseCmd{},
+		&runCmd{},
+		&schemaCmd{},
+		&watchCmd{},
+	}
+
+	//
+	// The completion command needs to know about the others, and
+	// completes itself too.
+	//
+	completion := &completionCmd{}
+	commands = append(commands, completion)
+	completion.commands = commands
+
+	for _, cmd := range commands {
+		subcommands.Register(cmd)
+	}
 
 	os.Exit(subcommands.Execute())
 }
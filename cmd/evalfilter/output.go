@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// outputFlag adds the `-output` flag, which selects the format the
+// results of a subcommand are shown in, to the given flags.
+func outputFlag(f *flag.FlagSet, format *string) {
+	f.StringVar(format, "output", "text", "The format of the output, either 'text' or 'json'.")
+}
+
+// validOutput reports whether the given output format is one we know,
+// showing an error if it isn't.
+func validOutput(format string) bool {
+	if format == "text" || format == "json" {
+		return true
+	}
+	fmt.Printf("Unknown output format %s, valid choices are 'text' and 'json'\n", format)
+	return false
+}
+
+// printJSON shows the given value as JSON, upon STDOUT.
+func printJSON(v interface{}) {
+	writeJSON(os.Stdout, v)
+}
+
+// writeJSON writes the given value as JSON to the given writer.
+//
+// We don't escape HTML, as scripts are full of `<`, `>`, and `&`.
+func writeJSON(w io.Writer, v interface{}) {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	err := enc.Encode(v)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error converting output to JSON - %s\n", err.Error())
+	}
+}
+
+// capture runs the given function, and returns whatever it wrote to
+// STDOUT, such that the output of a script may be included within
+// our JSON rather than corrupting it.
+func capture(fn func()) string {
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		fn()
+		return ""
+	}
+
+	// Read the output as it is written, to avoid filling the pipe.
+	var buf bytes.Buffer
+	done := make(chan struct{})
+	go func() {
+		io.Copy(&buf, r)
+		close(done)
+	}()
+
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() {
+		os.Stdout = stdout
+	}()
+
+	fn()
+
+	w.Close()
+	<-done
+	r.Close()
+	return buf.String()
+}
@@ -1,19 +1,26 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"io/ioutil"
 
 	"github.com/skx/evalfilter/v2/lexer"
 	"github.com/skx/evalfilter/v2/parser"
-	"github.com/skx/subcommands"
 )
 
 // Structure for our options and state.
 type parseCmd struct {
 
-	// We embed the NoFlags option, because we accept no command-line flags.
-	subcommands.NoFlags
+	// The format to show the program in.
+	output string
+}
+
+// parseReport is the JSON form of our output, for a single script.
+type parseReport struct {
+	File    string
+	Program string
+	Error   string
 }
 
 // Info returns the name of this subcommand.
@@ -26,9 +33,36 @@ parsed, which can be useful if you're receiving syntax-errors.
 Example:
 
   $ evalfilter parse script.in
+  $ evalfilter parse -output json script.in
 `
 }
 
+// Arguments adds per-command args to the object.
+func (p *parseCmd) Arguments(f *flag.FlagSet) {
+	outputFlag(f, &p.output)
+}
+
+// Report parses the given file, and returns the JSON form of the
+// program which resulted from it.
+func (p *parseCmd) Report(file string) parseReport {
+
+	report := parseReport{File: file}
+
+	dat, err := ioutil.ReadFile(file)
+	if err != nil {
+		report.Error = fmt.Sprintf("error reading file %s - %s", file, err.Error())
+		return report
+	}
+
+	program, err := parser.New(lexer.New(string(dat))).Parse()
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	report.Program = program.String()
+	return report
+}
+
 // Parse parses the given file, and dumps the AST which resulted from it.
 func (p *parseCmd) Parse(file string) {
 
@@ -70,6 +104,28 @@ func (p *parseCmd) Parse(file string) {
 // Execute is invoked if the user specifies `lex` as the subcommand.
 func (p *parseCmd) Execute(args []string) int {
 
+	if !validOutput(p.output) {
+		return 1
+	}
+
+	//
+	// JSON output is a single array, holding a report for each
+	// of the files.
+	//
+	if p.output == "json" {
+		reports := []parseReport{}
+		status := 0
+		for _, file := range args {
+			report := p.Report(file)
+			if report.Error != "" {
+				status = 1
+			}
+			reports = append(reports, report)
+		}
+		printJSON(reports)
+		return status
+	}
+
 	//
 	// For each file we've been passed.
 	//
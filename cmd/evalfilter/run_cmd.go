@@ -6,10 +6,12 @@ import (
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"strings"
 	"time"
 
 	"github.com/skx/evalfilter/v2"
 	"github.com/skx/evalfilter/v2/object"
+	"github.com/skx/evalfilter/v2/yaml"
 )
 
 // Structure for our options and state.
@@ -21,11 +23,62 @@ type runCmd struct {
 	// Disable the bytecode optimizer
 	raw bool
 
+	// Run with, and without, the optimizer and compare the results
+	verify bool
+
+	// Explain the comparisons made by the script
+	explain bool
+
 	// The user may specify a JSON file.
 	jsonFile string
 
+	// The user may specify a YAML file, of one or more documents.
+	yamlFile string
+
+	// Optional packages to enable, comma-separated.
+	packages string
+
 	// Maximum execution duration for the script.
 	timeout time.Duration
+
+	// Maximum number of instructions the script may execute.
+	maxInstructions int
+
+	// Recognize keywords regardless of case, along with AND, OR, and NOT.
+	sql bool
+
+	// The format to show the results in.
+	output string
+}
+
+// runReport is the JSON form of our output, for a single script.
+type runReport struct {
+	File     string
+	Warnings []string
+	Error    string
+	Results  []runResult
+}
+
+// runResult is the JSON form of the result of running a script against
+// a single object.
+type runResult struct {
+
+	// Output holds anything the script printed.
+	Output string
+
+	// Explanation holds the explanation of the run, if one was
+	// requested.
+	Explanation string
+
+	// Type and Value hold the value the script returned.
+	Type  object.Type
+	Value string
+
+	// True holds the truthiness of that value.
+	True bool
+
+	// Error holds the error which stopped the script, if any.
+	Error string
 }
 
 // Info returns the name of this subcommand.
@@ -35,10 +88,15 @@ func (r *runCmd) Info() (string, string) {
 This sub-command allows executing the specified evalfilter-script,
 optionally you may specify a JSON object to run the script against.
 
+Alternatively you may specify a YAML file, in which case the script
+is executed against each of the documents it contains.
+
 Example:
 
   $ evalfilter run script.in
   $ evalfilter run -json /path/to/obj.json script.in
+  $ evalfilter run -yaml /path/to/manifests.yaml script.in
+  $ evalfilter run -output json -json /path/to/obj.json script.in
 
 `
 }
@@ -46,18 +104,24 @@ Example:
 // Arguments adds per-command args to the object.
 func (r *runCmd) Arguments(f *flag.FlagSet) {
 	f.StringVar(&r.jsonFile, "json", "", "Run the script with the object contained within the specified JSON file as input.")
+	f.StringVar(&r.yamlFile, "yaml", "", "Run the script against each of the documents contained within the specified YAML file.")
 	f.BoolVar(&r.raw, "no-optimizer", false, "Disable the bytecode optimizer.")
+	f.BoolVar(&r.verify, "verify-opt", false, "Run the script with, and without, the bytecode optimizer and report an error if the results differ.")
+	f.BoolVar(&r.explain, "explain", false, "Explain the comparisons the script made, and their results.")
+	f.StringVar(&r.packages, "packages", "", "Enable the specified comma-separated packages of functions, e.g. 'strings,net'.")
 	f.BoolVar(&r.debug, "debug", false, "Show instructions and the stack at ever step.")
 	f.DurationVar(&r.timeout, "timeout", 0, "Specify the maximum execution time to allow for the script(s).")
+	f.IntVar(&r.maxInstructions, "max-instructions", 0, "Specify the maximum number of instructions the script(s) may execute.")
+	f.BoolVar(&r.sql, "sql", false, "Recognize keywords regardless of their case, and allow AND, OR, and NOT in place of &&, ||, and !.")
+	outputFlag(f, &r.output)
 }
 
-// Run the given script.
-func (r *runCmd) Run(file string) {
+// inputs returns the objects the script should be run against.
+func (r *runCmd) inputs() ([]interface{}, error) {
 
-	//
-	// The thing the script will run against.
-	//
-	obj := make(map[string]interface{})
+	if r.jsonFile != "" && r.yamlFile != "" {
+		return nil, fmt.Errorf("only one of -json and -yaml may be specified")
+	}
 
 	//
 	// If we have a JSON file then populate our object.
@@ -69,27 +133,60 @@ func (r *runCmd) Run(file string) {
 		//
 		dat, err := ioutil.ReadFile(r.jsonFile)
 		if err != nil {
-			fmt.Printf("Error reading file %s - %s\n", r.jsonFile, err.Error())
-			return
+			return nil, fmt.Errorf("error reading file %s - %s", r.jsonFile, err.Error())
 		}
 
 		//
 		// Parse the JSON
 		//
+		obj := make(map[string]interface{})
 		err = json.Unmarshal(dat, &obj)
 		if err != nil {
-			fmt.Printf("Error parsing JSON %s\n", err.Error())
-			return
+			return nil, fmt.Errorf("error parsing JSON %s", err.Error())
+		}
+		return []interface{}{obj}, nil
+	}
+
+	//
+	// If we have a YAML file then each document is an object.
+	//
+	if r.yamlFile != "" {
+
+		dat, err := ioutil.ReadFile(r.yamlFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading file %s - %s", r.yamlFile, err.Error())
+		}
+
+		docs, err := yaml.Parse(dat)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing YAML %s", err.Error())
 		}
+		for i, doc := range docs {
+			if _, ok := doc.(map[string]interface{}); !ok {
+				return nil, fmt.Errorf("error parsing YAML: document %d is not a mapping", i+1)
+			}
+		}
+		return docs, nil
 	}
 
+	//
+	// Otherwise the script runs against an empty object.
+	//
+	return []interface{}{make(map[string]interface{})}, nil
+}
+
+// setup reads, and prepares, the given script - returning a function
+// which is to be invoked once it has finished running.
+func (r *runCmd) setup(file string) (*evalfilter.Eval, func(), error) {
+
+	done := func() {}
+
 	//
 	// Read the script contents.
 	//
 	dat, err := ioutil.ReadFile(file)
 	if err != nil {
-		fmt.Printf("Error reading file %s - %s\n", file, err.Error())
-		return
+		return nil, done, fmt.Errorf("error reading file %s - %s", file, err.Error())
 	}
 
 	//
@@ -97,15 +194,39 @@ func (r *runCmd) Run(file string) {
 	//
 	eval := evalfilter.New(string(dat))
 
+	//
+	// Enable any packages we've been asked to.
+	//
+	if r.packages != "" {
+		for _, name := range strings.Split(r.packages, ",") {
+			err = eval.EnablePackage(strings.TrimSpace(name))
+			if err != nil {
+				return nil, done, fmt.Errorf("error enabling package: %s", err.Error())
+			}
+		}
+	}
+
 	//
 	// If we've been given a timeout period then set it here.
 	//
 	if r.timeout != 0 {
 		ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
-		defer cancel()
+		done = cancel
 		eval.SetContext(ctx)
 	}
 
+	//
+	// Similarly the number of instructions.
+	//
+	if r.maxInstructions != 0 {
+		eval.SetMaxInstructions(r.maxInstructions)
+	}
+
+	//
+	// And the style of keywords.
+	//
+	eval.SetSQLKeywords(r.sql)
+
 	//
 	// Flags to pass to the preparation function.
 	//
@@ -124,18 +245,135 @@ func (r *runCmd) Run(file string) {
 	}
 
 	//
-	// Prepare
+	// Prepare, unless we're verifying the optimizer, which
+	// handles the preparation itself.
 	//
-	err = eval.Prepare(flags)
+	if !r.verify {
+		err = eval.Prepare(flags)
+		if err != nil {
+			return nil, done, fmt.Errorf("error compiling: %s", err.Error())
+		}
+	}
+	return eval, done, nil
+}
+
+// Report runs the given script, and returns the JSON form of the results.
+func (r *runCmd) Report(file string) runReport {
+
+	report := runReport{File: file, Warnings: []string{}, Results: []runResult{}}
+
+	objs, err := r.inputs()
 	if err != nil {
-		fmt.Printf("Error compiling:%s\n", err.Error())
+		report.Error = err.Error()
+		return report
+	}
+
+	eval, done, err := r.setup(file)
+	defer done()
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	report.Warnings = append(report.Warnings, eval.Warnings()...)
+
+	for _, obj := range objs {
+
+		var result runResult
+		var ret object.Object
+
+		//
+		// Capture anything the script prints, so that it
+		// doesn't corrupt our output.
+		//
+		result.Output = capture(func() {
+			if r.explain {
+				var x *evalfilter.Explanation
+				x, err = eval.Explain(obj)
+				if x != nil {
+					result.Explanation = x.String()
+				}
+				if err != nil {
+					return
+				}
+			}
+			if r.verify {
+				ret, err = eval.VerifyOptimizer(obj)
+			} else {
+				ret, err = eval.Execute(obj)
+			}
+		})
+
+		if err != nil {
+			result.Error = err.Error()
+		} else {
+			result.Type = ret.Type()
+			result.Value = ret.Inspect()
+			result.True = eval.True(ret)
+		}
+		report.Results = append(report.Results, result)
+	}
+	return report
+}
+
+// Run the given script.
+func (r *runCmd) Run(file string) {
+
+	//
+	// The things the script will run against.
+	//
+	objs, err := r.inputs()
+	if err != nil {
+		fmt.Printf("%s\n", err.Error())
 		return
 	}
 
+	eval, done, err := r.setup(file)
+	defer done()
+	if err != nil {
+		fmt.Printf("%s\n", err.Error())
+		return
+	}
+	for _, w := range eval.Warnings() {
+		fmt.Printf("Warning: %s\n", w)
+	}
+
+	for i, obj := range objs {
+
+		// Identify the document, if there are several.
+		if len(objs) > 1 {
+			fmt.Printf("Document %d:\n", i+1)
+		}
+
+		r.execute(eval, obj)
+	}
+}
+
+// execute runs the prepared script against the given object, and shows
+// the result.
+func (r *runCmd) execute(eval *evalfilter.Eval, obj interface{}) {
+
 	//
-	// Run the script.
+	// If we're verifying the optimizer then run the script both
+	// with and without it.
 	//
-	ret, err := eval.Execute(obj)
+	var ret object.Object
+	var err error
+	if r.explain {
+		var x *evalfilter.Explanation
+		x, err = eval.Explain(obj)
+		if x != nil {
+			fmt.Printf("%s", x)
+		}
+		if err != nil {
+			fmt.Printf("Failed to run script: %s\n", err.Error())
+			return
+		}
+	}
+	if r.verify {
+		ret, err = eval.VerifyOptimizer(obj)
+	} else {
+		ret, err = eval.Execute(obj)
+	}
 	if err != nil {
 		fmt.Printf("Failed to run script: %s\n", err.Error())
 		return
@@ -146,7 +384,7 @@ func (r *runCmd) Run(file string) {
 	// truthiness of the result.
 	//
 	fmt.Printf("Script gave result type:%s value:%s - which is '%t'.\n",
-		ret.Type(), ret.Inspect(), ret.True())
+		ret.Type(), ret.Inspect(), eval.True(ret))
 
 	// Now show as JSON, if we can.
 	helper, ok := ret.(object.JSONAble)
@@ -166,6 +404,33 @@ func (r *runCmd) Run(file string) {
 // Execute is invoked if the user specifies `run` as the subcommand.
 func (r *runCmd) Execute(args []string) int {
 
+	if !validOutput(r.output) {
+		return 1
+	}
+
+	//
+	// JSON output is a single array, holding a report for each
+	// of the files.
+	//
+	if r.output == "json" {
+		reports := []runReport{}
+		status := 0
+		for _, file := range args {
+			report := r.Report(file)
+			if report.Error != "" {
+				status = 1
+			}
+			for _, result := range report.Results {
+				if result.Error != "" {
+					status = 1
+				}
+			}
+			reports = append(reports, report)
+		}
+		printJSON(reports)
+		return status
+	}
+
 	//
 	// For each file we've been passed; run it.
 	//
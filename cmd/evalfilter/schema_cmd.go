@@ -0,0 +1,175 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/skx/evalfilter/v2"
+	"github.com/skx/evalfilter/v2/object"
+)
+
+// Structure for our options and state.
+type schemaCmd struct {
+
+	// The file to read the sample events from.
+	input string
+
+	// The format to show the schema in.
+	output string
+}
+
+// schemaReport is the JSON form of our output.
+type schemaReport struct {
+	Error   string
+	Samples int
+	Fields  []evalfilter.SchemaField
+	Types   map[string]object.Type
+	Scripts []schemaScript
+}
+
+// schemaScript is the JSON form of the fields a single script uses.
+type schemaScript struct {
+	File    string
+	Error   string
+	Fields  []string
+	Missing []string
+}
+
+// Info returns the name of this subcommand.
+func (s *schemaCmd) Info() (string, string) {
+	return "schema", `Infer the schema of a stream of JSON events.
+
+This sub-command reads newline-delimited JSON events, and shows the
+fields they contain, along with the type of each and examples of their
+values.  The JSON form of the output may be given to an editor, to
+complete the names of fields, and its Types may be given to BindSchema.
+
+Any scripts given are compiled, and the fields each uses which aren't
+found within the events are reported - such as misspellings of them.
+
+Example:
+
+  $ evalfilter schema -input events.json
+  $ evalfilter schema -input events.json errors.in slow.in
+  $ kcat -C -b localhost -t logs -c 100 | evalfilter schema -output json
+
+`
+}
+
+// Arguments adds per-command args to the object.
+func (s *schemaCmd) Arguments(f *flag.FlagSet) {
+	f.StringVar(&s.input, "input", "-", "The file to read the sample events from, or '-' for STDIN.")
+	outputFlag(f, &s.output)
+}
+
+// Report infers the schema of our events and finds the fields of the
+// given scripts which they don't contain.
+func (s *schemaCmd) Report(files []string) schemaReport {
+
+	report := schemaReport{Scripts: []schemaScript{}}
+
+	var in io.Reader = os.Stdin
+	if s.input != "-" {
+		handle, err := os.Open(s.input)
+		if err != nil {
+			report.Error = fmt.Sprintf("error opening file %s - %s", s.input, err.Error())
+			return report
+		}
+		defer handle.Close()
+		in = handle
+	}
+
+	schema, err := evalfilter.InferSchema(in)
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	report.Samples = schema.Samples
+	report.Fields = schema.Fields
+	report.Types = schema.Types()
+
+	for _, file := range files {
+		script := schemaScript{File: file}
+
+		dat, err := ioutil.ReadFile(file)
+		if err != nil {
+			script.Error = fmt.Sprintf("error reading file %s - %s", file, err.Error())
+			report.Scripts = append(report.Scripts, script)
+			continue
+		}
+
+		eval := evalfilter.New(string(dat))
+		err = eval.Prepare()
+		if err != nil {
+			script.Error = err.Error()
+			report.Scripts = append(report.Scripts, script)
+			continue
+		}
+		script.Fields = eval.Fields()
+		script.Missing = schema.Missing(script.Fields)
+		report.Scripts = append(report.Scripts, script)
+	}
+	return report
+}
+
+// Execute is invoked if the user specifies `schema` as the subcommand.
+func (s *schemaCmd) Execute(args []string) int {
+
+	if !validOutput(s.output) {
+		return 1
+	}
+
+	report := s.Report(args)
+
+	// We fail if any script uses a field the events don't contain.
+	status := 0
+	if report.Error != "" {
+		status = 1
+	}
+	for _, script := range report.Scripts {
+		if script.Error != "" || len(script.Missing) > 0 {
+			status = 1
+		}
+	}
+
+	if s.output == "json" {
+		printJSON(report)
+		return status
+	}
+
+	if report.Error != "" {
+		fmt.Printf("Error reading events - %s\n", report.Error)
+		return status
+	}
+
+	fmt.Printf("Read %d events, holding %d fields.\n\n", report.Samples, len(report.Fields))
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "Field\tType\tSeen\tExamples\n")
+	for _, field := range report.Fields {
+		t := strings.ToLower(string(field.Type))
+		if len(field.Types) > 1 && field.Type == object.NULL {
+			t = "mixed"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d/%d\t%s\n", field.Name, t, field.Count, report.Samples, strings.Join(field.Examples, ", "))
+	}
+	w.Flush()
+
+	for _, script := range report.Scripts {
+		fmt.Printf("\n")
+		if script.Error != "" {
+			fmt.Printf("Error compiling %s - %s\n", script.File, script.Error)
+			continue
+		}
+		if len(script.Missing) == 0 {
+			fmt.Printf("%s uses %d fields, all of which were found.\n", script.File, len(script.Fields))
+			continue
+		}
+		fmt.Printf("%s uses fields which weren't found: %s\n", script.File, strings.Join(script.Missing, ", "))
+	}
+	return status
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/skx/evalfilter/v2"
+	"github.com/skx/evalfilter/v2/object"
+)
+
+// Structure for our options and state.
+type watchCmd struct {
+
+	// Disable the bytecode optimizer
+	raw bool
+
+	// The JSON file holding the object to run against.
+	input string
+
+	// How often to look for changes.
+	interval time.Duration
+
+	// The format to show the results in.
+	output string
+}
+
+// watchReport is the JSON form of our output, for a single run.
+type watchReport struct {
+	Time     string
+	File     string
+	Warnings []string
+	Error    string
+	Type     object.Type
+	Value    string
+	True     bool
+}
+
+// Info returns the name of this subcommand.
+func (w *watchCmd) Info() (string, string) {
+	return "watch", `Run a script each time it changes.
+
+This sub-command runs a script against a sample JSON object, and then
+runs it again whenever the script, or the object, is changed - showing
+any warnings, the value the script returned, and whether that means it
+matched.
+
+Example:
+
+  $ evalfilter watch -input sample.json script.in
+  $ evalfilter watch -output json -input sample.json script.in
+
+With '-output json' a JSON object describing each run is shown.
+
+`
+}
+
+// Arguments adds per-command args to the object.
+func (w *watchCmd) Arguments(f *flag.FlagSet) {
+	f.StringVar(&w.input, "input", "", "Run the script against the object contained within the specified JSON file.")
+	f.BoolVar(&w.raw, "no-optimizer", false, "Disable the bytecode optimizer.")
+	f.DurationVar(&w.interval, "interval", 500*time.Millisecond, "How often to look for changes.")
+	outputFlag(f, &w.output)
+}
+
+// modified returns a description of the time, and size, at which the
+// given file was last modified - or of the error that prevented us from
+// finding out.
+func modified(file string) string {
+	if file == "" {
+		return ""
+	}
+	st, err := os.Stat(file)
+	if err != nil {
+		return err.Error()
+	}
+	return fmt.Sprintf("%s %d", st.ModTime(), st.Size())
+}
+
+// Report runs the script, and returns the JSON form of the result.
+func (w *watchCmd) Report(file string) watchReport {
+
+	report := watchReport{Time: time.Now().Format("15:04:05"), File: file, Warnings: []string{}}
+
+	obj := make(map[string]interface{})
+	if w.input != "" {
+		dat, err := ioutil.ReadFile(w.input)
+		if err != nil {
+			report.Error = fmt.Sprintf("Error reading file %s - %s", w.input, err.Error())
+			return report
+		}
+		err = json.Unmarshal(dat, &obj)
+		if err != nil {
+			report.Error = fmt.Sprintf("Error parsing JSON %s - %s", w.input, err.Error())
+			return report
+		}
+	}
+
+	dat, err := ioutil.ReadFile(file)
+	if err != nil {
+		report.Error = fmt.Sprintf("Error reading file %s - %s", file, err.Error())
+		return report
+	}
+
+	eval := evalfilter.New(string(dat))
+
+	var flags []byte
+	if w.raw {
+		flags = append(flags, evalfilter.NoOptimize)
+	}
+	err = eval.Prepare(flags)
+	if err != nil {
+		report.Error = fmt.Sprintf("Error compiling: %s", err.Error())
+		return report
+	}
+	report.Warnings = append(report.Warnings, eval.Warnings()...)
+
+	ret, err := eval.Execute(obj)
+	if err != nil {
+		report.Error = fmt.Sprintf("Failed to run script: %s", err.Error())
+		return report
+	}
+	report.Type = ret.Type()
+	report.Value = ret.Inspect()
+	report.True = eval.True(ret)
+	return report
+}
+
+// run runs the script, and shows the result.
+func (w *watchCmd) run(file string) {
+
+	report := w.Report(file)
+	if w.output == "json" {
+		printJSON(report)
+		return
+	}
+
+	fmt.Printf("=== %s %s\n", report.Time, report.File)
+	for _, warning := range report.Warnings {
+		fmt.Printf("Warning: %s\n", warning)
+	}
+	if report.Error != "" {
+		fmt.Printf("%s\n", report.Error)
+		return
+	}
+
+	fmt.Printf("Output: %s %s\n", report.Type, report.Value)
+	if report.True {
+		fmt.Printf("Verdict: match\n")
+	} else {
+		fmt.Printf("Verdict: no match\n")
+	}
+}
+
+// Execute is invoked if the user specifies `watch` as the subcommand.
+func (w *watchCmd) Execute(args []string) int {
+
+	if !validOutput(w.output) {
+		return 1
+	}
+	if len(args) != 1 {
+		fmt.Printf("Usage: watch [-input sample.json] script.in\n")
+		return 1
+	}
+	file := args[0]
+
+	//
+	// We poll for changes, rather than depending upon notifications
+	// from the operating system, which is simple, and good enough
+	// for a file which is being edited by hand.
+	//
+	last := ""
+	for {
+		now := modified(file) + "\n" + modified(w.input)
+		if now != last {
+			last = now
+			w.run(file)
+		}
+		time.Sleep(w.interval)
+	}
+}
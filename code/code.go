@@ -161,9 +161,12 @@ const (
 	// push TRUE, else push FALSE
 	OpArrayIn
 
-	// OpIterationReset resets the state of a given object,
-	// such that the same object can be iterated upon multiple
-	// times.
+	// OpIterationReset pops an object from the stack, and pushes
+	// a new iterator for it in its place.
+	//
+	// The iterator holds the state of the loop, rather than the
+	// object, such that the same object can be iterated upon by
+	// nested loops.
 	OpIterationReset
 
 	// OpIterationNext is used for walking over items in an array.
@@ -172,11 +175,11 @@ const (
 	// knowledge on the back-end with the fake-code generated
 	// on the front-end.
 	//
-	// Assuming an array is on the stack (!):
+	// Assuming an iterator is on the stack (!):
 	//
-	//  1. We pop the array FROM the stack.
+	//  1. We pop the iterator FROM the stack.
 	//
-	//  2. We push the array back, after bumping the count-field.
+	//  2. We push the iterator back, after bumping its position.
 	//
 	//  3. We then push the next item.
 	//
@@ -190,9 +193,126 @@ const (
 	//
 	OpIterationNext
 
+	// OpIterationEnd pops the iterator of a foreach loop which is
+	// being terminated early, via `break`, and discards the scope
+	// which holds the loop's variables.
+	OpIterationEnd
+
 	// Given two integer values produce an array holding
 	// items between them.
 	OpRange
+
+	// OpSetIndex stores a value into an array/hash element.
+	//
+	// Three values are popped from the stack; the value to store,
+	// the index, and the collection to modify.
+	//
+	// The 16-bit argument is the opcode of a binary operation which
+	// should be applied to the current value of the element before
+	// it is stored (as in `a[1] += 3`, or `x["y"]++`).  An argument
+	// of OpNop means the value is stored as-is.
+	OpSetIndex
+
+	// Push a copy of the value at the top of the stack.
+	OpDup
+
+	// Pop a value from the stack, and discard it.
+	OpPop
+
+	// OpMember is used to test the members of a hash by our match
+	// expressions.
+	//
+	// Pop a key, and a hash, from the stack and push the value of
+	// the given key.  If the hash doesn't contain the key, or the
+	// object isn't a hash at all, then VOID is pushed instead.
+	OpMember
+
+	// OpJumpTable is used to dispatch a switch-statement whose
+	// cases are all string literals.
+	//
+	// Pop a value from the stack, and look it up in the hash held in
+	// the constant with the 16-bit offset.  If the value is found then
+	// jump to the offset it maps to, otherwise proceed to the next
+	// instruction.
+	OpJumpTable
+
+	// OpLoopEnter is emitted before each while and foreach loop, and
+	// resets the count of the iterations the loop has made.
+	//
+	// The 16-bit argument identifies the loop.
+	OpLoopEnter
+
+	// OpLoopCount is emitted at the start of the body of each loop,
+	// and counts the iterations the loop has made.  If the count
+	// exceeds the limit for the loop then execution is aborted.
+	//
+	// The 16-bit argument identifies the loop.
+	OpLoopCount
+
+	// OpSlice pops the end, the start, and a collection from the
+	// stack, and pushes the slice of the collection between them.
+	//
+	// Either bound may be void, which means the slice begins at the
+	// start of the collection, or ends at its end.
+	OpSlice
+
+	// OpContains pops a needle and a haystack from the stack, and
+	// pushes the result of the `contains` function.
+	//
+	// It is emitted in place of calls to that function when the
+	// needle is a constant.
+	OpContains
+
+	// OpStoreSlot pops a value from the stack, and stores it in the
+	// slot with the 16-bit index.
+	//
+	// It is emitted by the optimizer, before a loop, to hold the
+	// value of a variable, or field, which the loop doesn't change.
+	OpStoreSlot
+
+	// OpLoadSlot pushes the value held in the slot with the 16-bit
+	// index.
+	//
+	// It is emitted by the optimizer in place of the OpLookup
+	// instructions within a loop whose value was stored before it.
+	OpLoadSlot
+
+	// OpClosure pushes a new function, created from the function held
+	// in the constant-pool at the 16-bit index, which captures the
+	// values of the local variables the function refers to.
+	OpClosure
+
+	// OpExplain records the value at the top of the stack, which is
+	// left in place, as the value of the expression with the 16-bit
+	// identifier.
+	//
+	// It is only emitted when a script is compiled to explain a run.
+	OpExplain
+
+	// OpLookupPath pushes the value of a path of fields, such as
+	// `Request.Header.Host`, whose name is held in the constant-pool
+	// at the 16-bit index.
+	//
+	// It is emitted in place of looking up the first field, and then
+	// indexing the result with the rest, so that the nested values
+	// may be found without converting those which contain them.
+	OpLookupPath
+
+	// OpField pops a name, and a value, from the stack and pushes
+	// the field of the value with that name, as OpIndex does -
+	// except that the field of null is null.
+	//
+	// It is emitted for `a.b`, so that paths through the missing
+	// members of documents are null rather than an error.
+	OpField
+
+	// OpLookupField pushes the value of the field whose name is held
+	// in the constant-pool at the 16-bit index, as OpLookup does.
+	//
+	// It is emitted for the fields of a schema bound to the script,
+	// which the script never changes, so the value it finds may be
+	// remembered by the index for the rest of the run.
+	OpLookupField
 )
 
 // OpCodeNames allows mapping opcodes to their names.
@@ -204,25 +324,38 @@ var OpCodeNames = [...]string{
 	OpBang:           "OpBang",
 	OpCall:           "OpCall",
 	OpCase:           "OpCase",
+	OpClosure:        "OpClosure",
 	OpConstant:       "OpConstant",
+	OpContains:       "OpContains",
 	OpDec:            "OpDec",
 	OpDiv:            "OpDiv",
+	OpDup:            "OpDup",
 	OpEqual:          "OpEqual",
+	OpExplain:        "OpExplain",
 	OpFalse:          "OpFalse",
 	OpGreater:        "OpGreater",
 	OpGreaterEqual:   "OpGreaterEqual",
 	OpHash:           "OpHash",
 	OpInc:            "OpInc",
 	OpIndex:          "OpIndex",
+	OpIterationEnd:   "OpIterationEnd",
 	OpIterationNext:  "OpIterationNext",
 	OpIterationReset: "OpIterationReset",
 	OpJump:           "OpJump",
 	OpJumpIfFalse:    "OpJumpIfFalse",
+	OpJumpTable:      "OpJumpTable",
 	OpLess:           "OpLess",
+	OpLoadSlot:       "OpLoadSlot",
 	OpLessEqual:      "OpLessEqual",
 	OpLocal:          "OpLocal",
 	OpLookup:         "OpLookup",
+	OpLookupField:    "OpLookupField",
+	OpLookupPath:     "OpLookupPath",
+	OpField:          "OpField",
+	OpLoopCount:      "OpLoopCount",
+	OpLoopEnter:      "OpLoopEnter",
 	OpMatches:        "OpMatches",
+	OpMember:         "OpMember",
 	OpMinus:          "OpMinus",
 	OpMod:            "OpMod",
 	OpMul:            "OpMul",
@@ -231,12 +364,16 @@ var OpCodeNames = [...]string{
 	OpNotMatches:     "OpNotMatches",
 	OpOr:             "OpOr",
 	OpPlaceholder:    "OpPlaceholder",
+	OpPop:            "OpPop",
 	OpPower:          "OpPower",
 	OpPush:           "OpPush",
 	OpRange:          "OpRange",
 	OpReturn:         "OpReturn",
 	OpSet:            "OpSet",
+	OpSetIndex:       "OpSetIndex",
+	OpSlice:          "OpSlice",
 	OpSquareRoot:     "OpSquareRoot",
+	OpStoreSlot:      "OpStoreSlot",
 	OpSub:            "OpSub",
 	OpTrue:           "OpTrue",
 	OpVoid:           "OpVoid",
@@ -266,14 +403,22 @@ func Length(op Opcode) int {
 		return 3
 	case OpDec:
 		return 3
-	case OpJump, OpJumpIfFalse:
+	case OpJump, OpJumpIfFalse, OpJumpTable:
 		return 3
 	case OpInc:
 		return 3
-	case OpLookup:
+	case OpLookup, OpLookupField, OpLookupPath:
+		return 3
+	case OpLoopCount, OpLoopEnter:
 		return 3
 	case OpPush:
 		return 3
+	case OpSetIndex:
+		return 3
+	case OpLoadSlot, OpStoreSlot:
+		return 3
+	case OpClosure, OpExplain:
+		return 3
 	}
 
 	return 1
@@ -292,3 +437,67 @@ func String(op Opcode) string {
 
 	return OpCodeNames[op]
 }
+
+// Verify tests that the given instructions are well-formed, given the
+// number of constants in the constant-pool they refer to.
+//
+// Each opcode must be valid, and the final instruction must not be
+// truncated.  Constants which are referred to must exist, and jumps
+// must arrive at the start of an instruction, or the end of the
+// program.
+//
+// We don't know the types of the constants, so the contents of
+// jump-tables aren't tested.
+func Verify(ins Instructions, constants int) error {
+
+	// The offsets at which each instruction begins.
+	starts := make(map[int]bool)
+
+	// The offsets of the jumps we've seen.
+	var jumps []int
+
+	ip := 0
+	for ip < len(ins) {
+
+		op := Opcode(ins[ip])
+		if int(op) >= len(OpCodeNames) || OpCodeNames[op] == "" {
+			return fmt.Errorf("invalid opcode 0x%02X at offset %04d", ins[ip], ip)
+		}
+
+		opLen := Length(op)
+		if ip+opLen > len(ins) {
+			return fmt.Errorf("truncated instruction %s at offset %04d", String(op), ip)
+		}
+		starts[ip] = true
+
+		if opLen > 1 {
+			arg := int(ins[ip+1])<<8 | int(ins[ip+2])
+
+			switch op {
+			case OpConstant, OpLookup, OpLookupField, OpLookupPath, OpInc, OpDec, OpJumpTable, OpClosure:
+				if arg >= constants {
+					return fmt.Errorf("%s at offset %04d refers to constant %d, which doesn't exist", String(op), ip, arg)
+				}
+			case OpJump, OpJumpIfFalse:
+				jumps = append(jumps, ip)
+			case OpSetIndex:
+				if arg >= len(OpCodeNames) || OpCodeNames[arg] == "" {
+					return fmt.Errorf("invalid operation 0x%02X of OpSetIndex at offset %04d", arg, ip)
+				}
+			}
+		}
+
+		ip += opLen
+	}
+
+	// Now we know where each instruction begins we can test the
+	// destinations of the jumps.
+	for _, ip := range jumps {
+		dst := int(ins[ip+1])<<8 | int(ins[ip+2])
+		if dst != len(ins) && !starts[dst] {
+			return fmt.Errorf("%s at offset %04d has the invalid destination %04d", String(Opcode(ins[ip])), ip, dst)
+		}
+	}
+
+	return nil
+}
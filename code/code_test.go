@@ -49,9 +49,19 @@ func TestOpcodeLengths(t *testing.T) {
 				c != OpJump &&
 				c != OpJumpIfFalse &&
 				c != OpLookup &&
+				c != OpLookupPath &&
+				c != OpLookupField &&
 				c != OpInc &&
 				c != OpDec &&
-				c != OpPush {
+				c != OpPush &&
+				c != OpSetIndex &&
+				c != OpJumpTable &&
+				c != OpLoopEnter &&
+				c != OpLoopCount &&
+				c != OpLoadSlot &&
+				c != OpStoreSlot &&
+				c != OpClosure &&
+				c != OpExplain {
 
 				t.Errorf("found opcode which requires an argument %s", x)
 			}
@@ -70,3 +80,60 @@ func TestInvalidCode(t *testing.T) {
 		t.Fatalf("unknown opcodes returned something unexpected:%s", name)
 	}
 }
+
+// TestVerify ensures that invalid instructions are rejected.
+func TestVerify(t *testing.T) {
+
+	valid := Instructions{
+		byte(OpConstant), 0, 0,
+		byte(OpJumpIfFalse), 0, 8,
+		byte(OpTrue),
+		byte(OpReturn),
+		byte(OpSetIndex), 0, byte(OpAdd),
+		byte(OpJump), 0, 14,
+	}
+	err := Verify(valid, 1)
+	if err != nil {
+		t.Fatalf("unexpected error verifying valid code: %s", err)
+	}
+	err = Verify(Instructions{}, 0)
+	if err != nil {
+		t.Fatalf("unexpected error verifying empty code: %s", err)
+	}
+
+	tests := []struct {
+		ins   Instructions
+		error string
+	}{
+		{Instructions{244}, "invalid opcode 0xF4 at offset 0000"},
+		{Instructions{byte(OpTrue), byte(OpPush), 0}, "truncated instruction OpPush at offset 0001"},
+		{Instructions{byte(OpConstant), 0, 1}, "OpConstant at offset 0000 refers to constant 1, which doesn't exist"},
+		{Instructions{byte(OpLookup), 0, 3}, "refers to constant 3"},
+		{Instructions{byte(OpJump), 0, 4}, "OpJump at offset 0000 has the invalid destination 0004"},
+		{Instructions{byte(OpJumpIfFalse), 0, 1, byte(OpTrue)}, "OpJumpIfFalse at offset 0000 has the invalid destination 0001"},
+		{Instructions{byte(OpSetIndex), 0, 244}, "invalid operation 0xF4 of OpSetIndex"},
+	}
+	for _, test := range tests {
+		err = Verify(test.ins, 1)
+		if err == nil || !strings.Contains(err.Error(), test.error) {
+			t.Fatalf("expected error '%s', got %v", test.error, err)
+		}
+	}
+}
+
+// TestSourceMap tests that the positions of instructions may be relocated.
+func TestSourceMap(t *testing.T) {
+
+	s := SourceMap{0: {1, 3}, 3: {2, 7}, 4: {2, 9}}
+
+	// The instruction at offset 3 is removed.
+	out := s.Relocate(map[int]int{0: 0, 4: 3})
+	if len(out) != 2 || out[0].String() != "line 1, column 3" || out[3].String() != "line 2, column 9" {
+		t.Fatalf("unexpected relocation %v", out)
+	}
+
+	var empty SourceMap
+	if empty.Relocate(map[int]int{0: 1}) != nil {
+		t.Fatalf("relocating an empty map gave positions")
+	}
+}
@@ -0,0 +1,44 @@
+package code
+
+import "fmt"
+
+// Position holds the location, within a script, of the source of an
+// instruction.
+type Position struct {
+
+	// Line holds the line of the script, starting from one.
+	Line int
+
+	// Column holds the column of the line.
+	Column int
+}
+
+// String returns the position as a string, such as "line 3, column 7".
+func (p Position) String() string {
+	return fmt.Sprintf("line %d, column %d", p.Line, p.Column)
+}
+
+// SourceMap holds the positions of the sources of a set of instructions,
+// keyed by the offset of each instruction.
+//
+// Instructions which the compiler generated for its own purposes may
+// have no position.
+type SourceMap map[int]Position
+
+// Relocate returns a copy of the source map, for instructions which have
+// been moved.  The given map holds the new offset of each instruction,
+// keyed by the old, and those which aren't present have been removed.
+func (s SourceMap) Relocate(moved map[int]int) SourceMap {
+
+	if s == nil {
+		return nil
+	}
+
+	out := make(SourceMap)
+	for old, pos := range s {
+		if n, ok := moved[old]; ok {
+			out[n] = pos
+		}
+	}
+	return out
+}
@@ -8,15 +8,28 @@ import (
 	"encoding/binary"
 	"fmt"
 	"sort"
+	"strings"
+	"unicode"
 
 	"github.com/skx/evalfilter/v2/ast"
 	"github.com/skx/evalfilter/v2/code"
 	"github.com/skx/evalfilter/v2/environment"
 	"github.com/skx/evalfilter/v2/object"
+	"github.com/skx/evalfilter/v2/vm"
 )
 
 // compile is core-code for converting the AST into a series of bytecodes.
-func (e *Eval) compile(node ast.Node) error {
+func (e *Eval) compile(node ast.Node) (err error) {
+
+	// The instructions we emit come from this node, and so do
+	// any errors.
+	restore := e.at(node)
+	defer func() {
+		if err != nil {
+			err = compileError(node, err)
+		}
+		restore()
+	}()
 
 	switch node := node.(type) {
 
@@ -26,6 +39,9 @@ func (e *Eval) compile(node ast.Node) error {
 			if err != nil {
 				return err
 			}
+			if e.known != nil {
+				e.propagate(s)
+			}
 		}
 
 	case *ast.BlockStatement:
@@ -131,6 +147,24 @@ func (e *Eval) compile(node ast.Node) error {
 		}
 		e.emit(code.OpReturn)
 
+	case *ast.BreakStatement:
+		if len(e.targets) == 0 {
+			return fmt.Errorf("break outside of a loop around %s", node.Token.Position())
+		}
+		t := e.targets[len(e.targets)-1]
+
+		// A foreach loop keeps its iterator on the stack.
+		if t.foreach {
+			e.emit(code.OpIterationEnd)
+		}
+		t.breaks = append(t.breaks, e.emit(code.OpJump, 9999))
+
+	case *ast.ContinueStatement:
+		if len(e.targets) == 0 {
+			return fmt.Errorf("continue outside of a loop around %s", node.Token.Position())
+		}
+		e.emit(code.OpJump, e.targets[len(e.targets)-1].next)
+
 	case *ast.ExpressionStatement:
 		err := e.compile(node.Expression)
 		if err != nil {
@@ -138,15 +172,74 @@ func (e *Eval) compile(node ast.Node) error {
 		}
 
 	case *ast.InfixExpression:
+
+		// Simplify boolean logic, when optimizing.
+		if e.simplified != nil {
+			if s := e.simplify(node); s != ast.Expression(node) {
+				return e.compile(s)
+			}
+		}
+
+		// Evaluate the clauses of `&&` in the best order, if asked.
+		if e.reorder && node.Operator == "&&" {
+			if done, err := e.compileReordered(node); done {
+				return err
+			}
+		}
+
+		// Updating an array/hash member?
+		//
+		//    foo[1] += 3;
+		//    -> foo
+		//    -> 1
+		//    -> 3
+		//    OpSetIndex OpAdd
+		//
+		if op, ok := mutators[node.Operator]; ok && ast.IsIndexTarget(node.Left) {
+			err := e.compileIndexTarget(node.Left)
+			if err != nil {
+				return err
+			}
+			err = e.compile(node.Right)
+			if err != nil {
+				return err
+			}
+			e.emit(code.OpSetIndex, int(op))
+			return nil
+		}
+
+		// Using a field of the schema with the wrong type of value?
+		if err := e.checkOperands(node); err != nil {
+			return err
+		}
+
+		// Record the values involved, if we're explaining.
+		id := e.explainStart(node)
+		defer e.explainEnd(id)
+
+		// Looking up a path of fields, such as `a.b.c`, at once?
+		if path := e.memberPath(node); path != "" {
+			e.reference(path)
+			if err := e.checkPath(path); err != nil {
+				return err
+			}
+			str := &object.String{Value: path}
+			e.emit(code.OpLookupPath, e.addConstant(str))
+			e.explainResult(id)
+			return nil
+		}
+
 		err := e.compile(node.Left)
 		if err != nil {
 			return err
 		}
+		e.explainOperand(id, node.Left)
 
 		err = e.compile(node.Right)
 		if err != nil {
 			return err
 		}
+		e.explainOperand(id, node.Right)
 
 		switch node.Operator {
 
@@ -220,7 +313,7 @@ func (e *Eval) compile(node ast.Node) error {
 			e.emit(code.OpArrayIn)
 
 		case ".":
-			e.emit(code.OpIndex)
+			e.emit(code.OpField)
 
 			// misc
 		case "..":
@@ -234,12 +327,25 @@ func (e *Eval) compile(node ast.Node) error {
 		default:
 			return fmt.Errorf("unknown operator %s", node.Operator)
 		}
+		e.explainResult(id)
 
 	case *ast.PrefixExpression:
+
+		// Simplify boolean logic, when optimizing.
+		if e.simplified != nil {
+			if s := e.simplify(node); s != ast.Expression(node) {
+				return e.compile(s)
+			}
+		}
+
+		id := e.explainStart(node)
+		defer e.explainEnd(id)
+
 		err := e.compile(node.Right)
 		if err != nil {
 			return err
 		}
+		e.explainOperand(id, node.Right)
 
 		switch node.Operator {
 		case "!":
@@ -251,9 +357,35 @@ func (e *Eval) compile(node ast.Node) error {
 		default:
 			return fmt.Errorf("unknown operator %s", node.Operator)
 		}
+		e.explainResult(id)
 
 	case *ast.PostfixExpression:
 
+		// Incrementing an array/hash member is handled by pushing
+		// the collection, index, and the value 1 to the stack, then
+		// updating the member via addition, or subtraction.
+		if node.Target != nil {
+			err := e.compileIndexTarget(node.Target)
+			if err != nil {
+				return err
+			}
+			e.emit(code.OpPush, 1)
+
+			if node.Operator == "++" {
+				e.emit(code.OpSetIndex, int(code.OpAdd))
+			} else if node.Operator == "--" {
+				e.emit(code.OpSetIndex, int(code.OpSub))
+			} else {
+				return fmt.Errorf("unknown postfix operator %s", node.Operator)
+			}
+			return nil
+		}
+
+		// Otherwise we're modifying a variable, OpInc and OpDec
+		// expect to find its current value upon the stack.
+		str := &object.String{Value: node.Token.Literal}
+		e.emit(code.OpLookup, e.addConstant(str))
+
 		if node.Operator == "++" {
 			name := &object.String{Value: node.Token.Literal}
 			e.emit(code.OpInc, e.addConstant(name))
@@ -283,6 +415,10 @@ func (e *Eval) compile(node ast.Node) error {
 		// over in the post.
 		e.emit(code.OpIterationReset)
 
+		// Reset the count of our iterations.
+		loop := e.addLoop("foreach", node.Token.Line)
+		e.emit(code.OpLoopEnter, loop)
+
 		// Now we're at the start of our loop,
 		// we'll jump back to this point each
 		// time round.
@@ -303,8 +439,14 @@ func (e *Eval) compile(node ast.Node) error {
 		// jump end
 		end := e.emit(code.OpJumpIfFalse, 9999)
 
-		// Output the body
+		// Count this iteration
+		e.emit(code.OpLoopCount, loop)
+
+		// Output the body, where `continue` fetches the next
+		// item.
+		targets := e.enterLoop(true, start)
 		err = e.compile(node.Body)
+		e.leaveLoop()
 		if err != nil {
 			return nil
 		}
@@ -312,8 +454,11 @@ func (e *Eval) compile(node ast.Node) error {
 		// repeat
 		e.emit(code.OpJump, start)
 
-		// back-patch
+		// back-patch, including any `break` statements.
 		e.changeOperand(end, len(e.instructions))
+		for _, pos := range targets.breaks {
+			e.changeOperand(pos, len(e.instructions))
+		}
 
 		// Finally add a "Nop" instruction, one that will not
 		// be optimized away.
@@ -358,50 +503,15 @@ func (e *Eval) compile(node ast.Node) error {
 		// sequentially, and nothing else will mess with
 		// vm.instructions behind our back.
 		//
-		before := e.instructions
-		e.instructions = code.Instructions{}
-
-		// Compile the body of the function
-		err := e.compile(node.Body)
+		body, sources, err := e.compileBody(node.Body)
 		if err != nil {
-
-			// reset our instructions if we
-			// have an error.
-			//
-			// This is not required as errors
-			// will cause termination of our
-			// compiler-function but it feels
-			// like a neat thing to do.
-			e.instructions = before
 			return err
 		}
 
-		//
-		// Ensure that every function will return something.
-		//
-		// We're doing this because we'll be executing the
-		// compiled functions in (essentially) a child-VM.
-		//
-		// Our VM will terminate execution when it hits a
-		// return-statement - so this guarantees that will
-		// happen even in the case of a function like:
-		//
-		//    function alive() { printf("We're alive now\n" ); }
-		//
-		// Without an explicit return there is .. no return
-		// value, and no clean termination.  Instead we'd walk
-		// off the end of our bytecode array.
-		//
-		if len(e.instructions) == 0 ||
-			code.Opcode(e.instructions[len(e.instructions)-1]) != code.OpReturn {
-			e.emit(code.OpVoid)
-			e.emit(code.OpReturn)
-		}
-
 		// Save the bytecode away, remember we generated
 		// in our "internal" instruction space, which we
 		// swapped out for safety.
-		x := environment.UserFunction{Bytecode: e.instructions}
+		x := environment.UserFunction{Bytecode: body, Sources: sources}
 
 		// Copy the function-arguments.
 		for _, nm := range node.Parameters {
@@ -411,12 +521,34 @@ func (e *Eval) compile(node ast.Node) error {
 		// And save this function-reference by name.
 		e.functions[node.Token.Literal] = x
 
-		// Now we can restore our bytecode to what it was
-		// before we started to deal with the body.
-		e.instructions = before
+	case *ast.FunctionLiteral:
+
+		// The body is compiled into its own bytecode, just as
+		// it would be for a function definition, and held in
+		// the constant-pool.
+		body, sources, err := e.compileBody(node.Body)
+		if err != nil {
+			return err
+		}
+		fn := &object.Function{Bytecode: body, Sources: sources}
+		for _, nm := range node.Parameters {
+			fn.Arguments = append(fn.Arguments, nm.Value)
+		}
+		fn.Free = e.freeVariables(fn)
+
+		// Each time the expression is evaluated a new function
+		// is created, which captures the values it refers to.
+		e.emit(code.OpClosure, e.addConstant(fn))
 
 	case *ast.IfExpression:
 
+		// Warn if the condition is always the same.
+		rest := ""
+		if node.Alternative != nil {
+			rest = "else branch"
+		}
+		e.checkCondition(node.Token, node.Condition, rest)
+
 		// Compile the expression.
 		err := e.compile(node.Condition)
 		if err != nil {
@@ -558,6 +690,11 @@ func (e *Eval) compile(node ast.Node) error {
 		//  END:
 		//
 
+		//
+		// Warn if COND is always the same.
+		//
+		e.checkCondition(node.Token, node.Condition, "false branch")
+
 		//
 		// Compile COND
 		//
@@ -608,6 +745,12 @@ func (e *Eval) compile(node ast.Node) error {
 
 	case *ast.SwitchExpression:
 
+		// A switch over a lot of string constants can be
+		// dispatched via a single hash-lookup.
+		if useJumpTable(node) {
+			return e.compileJumpTable(node)
+		}
+
 		//
 		// So a switch statement will look like this:
 		//
@@ -747,13 +890,31 @@ func (e *Eval) compile(node ast.Node) error {
 		// doesn't exist otherwise
 		e.emit(code.OpPlaceholder)
 
+	case *ast.MatchExpression:
+		return e.compileMatch(node)
+
 	case *ast.WhileStatement:
 
+		//
+		// Reset the count of our iterations.
+		//
+		loop := e.addLoop("while", node.Token.Line)
+		e.emit(code.OpLoopEnter, loop)
+
 		//
 		// Record our starting position
 		//
 		cur := len(e.instructions)
 
+		//
+		// Warn if the body can never run.  A condition which is
+		// always true is fine, as the loop may be ended via
+		// `break` or `return`.
+		//
+		if val, ok := e.always(node.Condition); ok && !val {
+			e.warn(node.Token, "condition is always false; loop body unreachable")
+		}
+
 		//
 		// Compile the condition.
 		//
@@ -792,9 +953,17 @@ func (e *Eval) compile(node ast.Node) error {
 		jumpNotTruthyPos := e.emit(code.OpJumpIfFalse, 9999)
 
 		//
-		// Compile the code in the body
+		// Count this iteration.
+		//
+		e.emit(code.OpLoopCount, loop)
+
+		//
+		// Compile the code in the body, where `continue`
+		// retests the condition.
 		//
+		targets := e.enterLoop(false, cur)
 		err = e.compile(node.Body)
+		e.leaveLoop()
 		if err != nil {
 			return err
 		}
@@ -806,9 +975,12 @@ func (e *Eval) compile(node ast.Node) error {
 
 		//
 		// Change the jump to skip the block if the condition
-		// was false.
+		// was false, as do any `break` statements.
 		//
 		e.changeOperand(jumpNotTruthyPos, len(e.instructions))
+		for _, pos := range targets.breaks {
+			e.changeOperand(pos, len(e.instructions))
+		}
 
 		// Finally add a "Nop" instruction, one that will not
 		// be optimized away.
@@ -819,6 +991,26 @@ func (e *Eval) compile(node ast.Node) error {
 
 	case *ast.AssignStatement:
 
+		// Assigning several variables at once?
+		switch target := node.Target.(type) {
+		case *ast.ArrayLiteral, *ast.HashPattern:
+			return e.compileDestructure(target, node.Value)
+		}
+
+		// Assigning to an array/hash member?
+		if node.Target != nil {
+			err := e.compileIndexTarget(node.Target)
+			if err != nil {
+				return err
+			}
+			err = e.compile(node.Value)
+			if err != nil {
+				return err
+			}
+			e.emit(code.OpSetIndex, int(code.OpNop))
+			return nil
+		}
+
 		// Get the value
 		err := e.compile(node.Value)
 		if err != nil {
@@ -833,7 +1025,28 @@ func (e *Eval) compile(node ast.Node) error {
 		e.emit(code.OpSet)
 
 	case *ast.Identifier:
+
+		// Is this a parameter of a function being inlined?
+		if sub, ok := e.substitutions[node.Value]; ok {
+			return e.compileSubstitution(sub)
+		}
+
+		// Is this a variable with a constant value?
+		if val, ok := e.known[variable(node.Value)]; ok {
+			return e.compile(val)
+		}
+
+		e.reference(node.Value)
+		if err := e.checkName(node.Value); err != nil {
+			return err
+		}
+
+		// The fields of a schema are remembered once they're found.
 		str := &object.String{Value: node.Value}
+		if _, ok := e.schemaField(node.Value); ok {
+			e.emit(code.OpLookupField, e.addConstant(str))
+			return nil
+		}
 		e.emit(code.OpLookup, e.addConstant(str))
 
 	case *ast.CallExpression:
@@ -856,6 +1069,27 @@ func (e *Eval) compile(node ast.Node) error {
 		// emit `OpCall NN` where NN is the number of arguments
 		// to pop and invoke the function with.
 		//
+		// Unless the function is one we can inline, in which
+		// case we compile its body in place of the call.
+		//
+		e.checkDeprecated(node)
+		if fn, ok := e.canInline(node); ok {
+			return e.compileInline(node, fn)
+		}
+
+		// Searching for a constant needle is common enough that
+		// we avoid the overhead of the function-call.
+		if e.constantContains(node) {
+			for _, a := range node.Arguments {
+				err := e.compile(a)
+				if err != nil {
+					return err
+				}
+			}
+			e.emit(code.OpContains)
+			return nil
+		}
+
 		args := len(node.Arguments)
 		for _, a := range node.Arguments {
 
@@ -865,9 +1099,18 @@ func (e *Eval) compile(node ast.Node) error {
 			}
 		}
 
-		// call - has the string on the stack
-		str := &object.String{Value: node.Function.String()}
-		e.emit(code.OpConstant, e.addConstant(str))
+		// call - has the string on the stack, unless we're calling
+		// a function which is the result of an expression.
+		switch node.Function.(type) {
+		case *ast.FunctionLiteral, *ast.CallExpression, *ast.IndexExpression:
+			err := e.compile(node.Function)
+			if err != nil {
+				return err
+			}
+		default:
+			str := &object.String{Value: node.Function.String()}
+			e.emit(code.OpConstant, e.addConstant(str))
+		}
 
 		// then a call instruction with the number of args.
 		e.emit(code.OpCall, args)
@@ -885,18 +1128,635 @@ func (e *Eval) compile(node ast.Node) error {
 
 		e.emit(code.OpIndex)
 
+	case *ast.SliceExpression:
+		err := e.compile(node.Left)
+		if err != nil {
+			return err
+		}
+
+		// Omitted bounds are represented by void.
+		for _, bound := range []ast.Expression{node.Start, node.End} {
+			if bound == nil {
+				e.emit(code.OpVoid)
+				continue
+			}
+			err = e.compile(bound)
+			if err != nil {
+				return err
+			}
+		}
+
+		e.emit(code.OpSlice)
+
 	default:
 		return fmt.Errorf("unknown node type %T %v", node, node)
 	}
 	return nil
 }
 
+// jumpTableMinimum is the number of cases a switch-statement must have
+// before we consider dispatching it via a jump-table.
+//
+// For a small number of cases the sequential tests are just as fast.
+const jumpTableMinimum = 4
+
+// useJumpTable returns true if the given switch-statement compares
+// against nothing but string literals, and has enough of them to be
+// worth dispatching via OpJumpTable.
+func useJumpTable(node *ast.SwitchExpression) bool {
+	count := 0
+	for _, opt := range node.Choices {
+		if opt.Default {
+			continue
+		}
+		for _, val := range opt.Expr {
+			if _, ok := val.(*ast.StringLiteral); !ok {
+				return false
+			}
+			count++
+		}
+	}
+	return count >= jumpTableMinimum
+}
+
+// compileBody compiles the body of a function, and returns the bytecode
+// which was generated.
+//
+// Each function has its own bytecode, which starts from offset zero, so
+// we compile the body into a fresh set of instructions.  That is safe,
+// because we only compile one function at a time.
+func (e *Eval) compileBody(node *ast.BlockStatement) (code.Instructions, code.SourceMap, error) {
+
+	before, sources := e.instructions, e.sources
+	e.instructions = code.Instructions{}
+	e.sources = make(code.SourceMap)
+
+	// Compile the body of the function, without propagating
+	// any constants, as it might be called before they're set.
+	known := e.known
+	e.known = nil
+	err := e.compile(node)
+	e.known = known
+	if err != nil {
+
+		// reset our instructions if we
+		// have an error.
+		//
+		// This is not required as errors
+		// will cause termination of our
+		// compiler-function but it feels
+		// like a neat thing to do.
+		e.instructions, e.sources = before, sources
+		return nil, nil, err
+	}
+
+	//
+	// Ensure that every function will return something.
+	//
+	// We're doing this because we'll be executing the
+	// compiled functions in (essentially) a child-VM.
+	//
+	// Our VM will terminate execution when it hits a
+	// return-statement - so this guarantees that will
+	// happen even in the case of a function like:
+	//
+	//    function alive() { printf("We're alive now\n" ); }
+	//
+	// Without an explicit return there is .. no return
+	// value, and no clean termination.  Instead we'd walk
+	// off the end of our bytecode array.
+	//
+	if len(e.instructions) == 0 ||
+		code.Opcode(e.instructions[len(e.instructions)-1]) != code.OpReturn {
+		e.emit(code.OpVoid)
+		e.emit(code.OpReturn)
+	}
+
+	// Now we can restore our bytecode to what it was
+	// before we started to deal with the body.
+	body, positions := e.instructions, e.sources
+	e.instructions, e.sources = before, sources
+	return body, positions, nil
+}
+
+// freeVariables returns the names of the variables which the body of an
+// anonymous function looks up, other than its own parameters, including
+// those which any functions it creates will capture.
+func (e *Eval) freeVariables(fn *object.Function) []string {
+
+	seen := make(map[string]bool)
+	for _, name := range fn.Arguments {
+		seen[name] = true
+	}
+
+	var free []string
+	add := func(name string) {
+		name = strings.TrimPrefix(name, "$")
+		if !seen[name] {
+			seen[name] = true
+			free = append(free, name)
+		}
+	}
+
+	for ip := 0; ip < len(fn.Bytecode); ip += code.Length(code.Opcode(fn.Bytecode[ip])) {
+		op := code.Opcode(fn.Bytecode[ip])
+		if op != code.OpLookup && op != code.OpLookupField && op != code.OpLookupPath && op != code.OpClosure {
+			continue
+		}
+		arg := int(binary.BigEndian.Uint16(fn.Bytecode[ip+1 : ip+3]))
+		switch c := e.constants[arg].(type) {
+		case *object.String:
+			// A path captures the variable it begins with.
+			if op == code.OpLookupPath {
+				add(strings.SplitN(c.Value, ".", 2)[0])
+				continue
+			}
+			add(c.Value)
+		case *object.Function:
+			for _, name := range c.Free {
+				add(name)
+			}
+		}
+	}
+	return free
+}
+
+// compileJumpTable compiles a switch-statement whose cases are all
+// string literals into a single hash-lookup:
+//
+//	value
+//	jumptable N     (constant N maps each string to its block)
+//	  default_code
+//	  jmp END
+//	one:
+//	  one_code
+//	  jmp END
+//	two:
+//	  ..
+//	END:
+//
+// The value is compiled only once, rather than once per case, and the
+// time taken to find the block to run doesn't depend upon the number
+// of cases.  As with our sequential tests only the first case with a
+// given value will ever run, and only string values will match.
+func (e *Eval) compileJumpTable(node *ast.SwitchExpression) error {
+
+	err := e.compile(node.Value)
+	if err != nil {
+		return err
+	}
+
+	// The table's offsets are only known once the blocks have
+	// been compiled, so we update it in-place afterwards.  That
+	// means it must not be shared with any other constant.
+	table := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
+	e.constants = append(e.constants, table)
+	e.emit(code.OpJumpTable, len(e.constants)-1)
+
+	patches := []int{}
+
+	// If nothing matched we'll run the default-block, if any.
+	for _, opt := range node.Choices {
+		if opt.Default {
+			err = e.compile(opt.Block)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	patches = append(patches, e.emit(code.OpJump, 9999))
+
+	for _, opt := range node.Choices {
+		if opt.Default {
+			continue
+		}
+
+		offset := &object.Integer{Value: int64(len(e.instructions))}
+		for _, val := range opt.Expr {
+			key := &object.String{Value: val.(*ast.StringLiteral).Value}
+
+			// The first case wins.
+			if _, ok := table.Pairs[key.HashKey()]; !ok {
+				table.Pairs[key.HashKey()] = object.HashPair{Key: key, Value: offset}
+			}
+		}
+
+		err = e.compile(opt.Block)
+		if err != nil {
+			return err
+		}
+		patches = append(patches, e.emit(code.OpJump, 9999))
+	}
+
+	for _, offset := range patches {
+		e.changeOperand(offset, len(e.instructions))
+	}
+
+	// The jumps need an instruction to land upon.
+	e.emit(code.OpPlaceholder)
+	return nil
+}
+
+// mutators maps the compound-assignment operators to the opcode which
+// implements the underlying operation.
+var mutators = map[string]code.Opcode{
+	"+=": code.OpAdd,
+	"-=": code.OpSub,
+	"*=": code.OpMul,
+	"/=": code.OpDiv,
+}
+
+// memberPath returns the path of the fields which the given expression
+// refers to, such as "Request.Header.Host" for `Request.Header.Host`, or
+// the empty string if it isn't a path of two or more names which begins
+// with a field, or variable.
+func (e *Eval) memberPath(node *ast.InfixExpression) string {
+
+	var names []string
+
+	var cur ast.Expression = node
+	for {
+		n, ok := cur.(*ast.InfixExpression)
+		if !ok {
+			break
+		}
+		if n.Operator != "." {
+			return ""
+		}
+		str, ok := n.Right.(*ast.StringLiteral)
+		if !ok || !memberName(str.Value) {
+			return ""
+		}
+		names = append(names, str.Value)
+		cur = n.Left
+	}
+
+	root, ok := cur.(*ast.Identifier)
+	if !ok || !memberName(strings.TrimPrefix(root.Value, "$")) {
+		return ""
+	}
+
+	// Parameters being inlined, and variables with a constant value,
+	// aren't looked up at all.
+	if _, ok := e.substitutions[root.Value]; ok {
+		return ""
+	}
+	if _, ok := e.known[variable(root.Value)]; ok {
+		return ""
+	}
+	names = append(names, root.Value)
+
+	// The names were found from the last to the first.
+	for i, j := 0, len(names)-1; i < j; i, j = i+1, j-1 {
+		names[i], names[j] = names[j], names[i]
+	}
+	return strings.Join(names, ".")
+}
+
+// memberName returns true if the given name may be part of a path of
+// fields - it must be made of letters, digits, and underscores, and not
+// begin with a digit.
+func memberName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, c := range name {
+		if c != '_' && !unicode.IsLetter(c) && (i == 0 || !unicode.IsDigit(c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// compileIndexTarget pushes the collection, and index, of an array/hash
+// member onto the stack - ready for OpSetIndex to update it.
+func (e *Eval) compileIndexTarget(node ast.Expression) error {
+	switch n := node.(type) {
+	case *ast.IndexExpression:
+		err := e.compile(n.Left)
+		if err != nil {
+			return err
+		}
+		return e.compile(n.Index)
+	case *ast.InfixExpression:
+		err := e.compile(n.Left)
+		if err != nil {
+			return err
+		}
+		return e.compile(n.Right)
+	}
+	return fmt.Errorf("cannot assign to %s", node.String())
+}
+
+// compileDestructure compiles an assignment which sets several variables
+// at once, from the members of an array or hash:
+//
+//	[a, b] = split(line, ",");
+//	{user, ip} = event.details;
+//
+// The value is evaluated once, and each variable is set from a copy of it:
+//
+//	value
+//	  dup, index, OpIndex, "name", set
+//	  ..
+//	pop
+//
+// Members which don't exist will result in the variable being set to null.
+func (e *Eval) compileDestructure(target ast.Expression, value ast.Expression) error {
+
+	// Find the variables to set, and the index of each.
+	var names []ast.Expression
+	var indexes []ast.Expression
+
+	switch node := target.(type) {
+	case *ast.ArrayLiteral:
+		for i, el := range node.Elements {
+			names = append(names, el)
+			indexes = append(indexes, &ast.IntegerLiteral{Token: node.Token, Value: int64(i)})
+		}
+	case *ast.HashPattern:
+		names = node.Values
+		indexes = node.Keys
+	}
+
+	err := e.compile(value)
+	if err != nil {
+		return err
+	}
+
+	for i, name := range names {
+
+		ident, ok := name.(*ast.Identifier)
+		if !ok {
+			return fmt.Errorf("cannot assign to %s", name.String())
+		}
+
+		// `_` may be used to skip a member.
+		if ident.Value == "_" {
+			continue
+		}
+
+		e.emit(code.OpDup)
+		err = e.compile(indexes[i])
+		if err != nil {
+			return err
+		}
+		e.emit(code.OpIndex)
+
+		str := &object.String{Value: ident.Value}
+		e.emit(code.OpConstant, e.addConstant(str))
+		e.emit(code.OpSet)
+	}
+
+	e.emit(code.OpPop)
+	return nil
+}
+
+// matchBinding records a variable which should be set by a match arm.
+type matchBinding struct {
+	// name is the variable to set.
+	name string
+
+	// path holds the keys which lead from the matched value to
+	// the value the variable should be set to.
+	path []ast.Expression
+}
+
+// compileMatch compiles a match expression.
+//
+// The value being matched is evaluated once, and is kept upon the stack
+// while the patterns of each arm are tested.  Each test duplicates it,
+// walks to the member being tested via OpMember, and compares against
+// the pattern via OpCase - jumping to the next arm upon failure:
+//
+//	value
+//	  dup, [key, member, ..], literal, case
+//	  jmpIfFalse next
+//	  ..
+//	  dup, [key, member, ..], "name", set    (bindings)
+//	  pop
+//	  body
+//	  jmp END
+//	next:
+//	  ..
+//	pop
+//	END:
+//
+// Bindings are only made once all of an arm's tests have succeeded, so a
+// failed arm never changes any variables.
+func (e *Eval) compileMatch(node *ast.MatchExpression) error {
+
+	err := e.compile(node.Value)
+	if err != nil {
+		return err
+	}
+
+	patches := []int{}
+
+	for _, arm := range node.Arms {
+
+		fails := []int{}
+		binds := []matchBinding{}
+
+		err = e.compilePattern(arm.Pattern, nil, &fails, &binds)
+		if err != nil {
+			return err
+		}
+
+		// All the tests passed; set any variables.
+		for _, b := range binds {
+			err = e.compileMatchPath(b.path)
+			if err != nil {
+				return err
+			}
+			str := &object.String{Value: b.name}
+			e.emit(code.OpConstant, e.addConstant(str))
+			e.emit(code.OpSet)
+		}
+
+		// Discard the value, and run the body.
+		e.emit(code.OpPop)
+		err = e.compile(arm.Block)
+		if err != nil {
+			return err
+		}
+		patches = append(patches, e.emit(code.OpJump, 9999))
+
+		// Failed tests resume with the next arm.
+		for _, pos := range fails {
+			e.changeOperand(pos, len(e.instructions))
+		}
+	}
+
+	// No arm matched, so discard the value ourselves.
+	e.emit(code.OpPop)
+
+	for _, pos := range patches {
+		e.changeOperand(pos, len(e.instructions))
+	}
+
+	// As with switch we need an instruction which won't be
+	// optimized away for our jumps to land upon.
+	e.emit(code.OpPlaceholder)
+	return nil
+}
+
+// compileMatchPath pushes a copy of the matched value, and then walks
+// down the given keys to reach the member of interest.
+func (e *Eval) compileMatchPath(path []ast.Expression) error {
+	e.emit(code.OpDup)
+	for _, key := range path {
+		err := e.compile(key)
+		if err != nil {
+			return err
+		}
+		e.emit(code.OpMember)
+	}
+	return nil
+}
+
+// compileMatchPresent emits a test that the hash-member reached by the
+// given path exists, if there is a path.
+func (e *Eval) compileMatchPresent(path []ast.Expression, fails *[]int) error {
+	if len(path) == 0 {
+		return nil
+	}
+	err := e.compileMatchPath(path)
+	if err != nil {
+		return err
+	}
+	e.emit(code.OpVoid)
+	e.emit(code.OpCase)
+	e.emit(code.OpBang)
+	*fails = append(*fails, e.emit(code.OpJumpIfFalse, 9999))
+	return nil
+}
+
+// compilePattern emits the tests for the given pattern, recording the
+// position of each conditional jump which must be taken upon failure
+// and any variables which should be bound upon success.
+func (e *Eval) compilePattern(pattern ast.Expression, path []ast.Expression, fails *[]int, binds *[]matchBinding) error {
+
+	switch node := pattern.(type) {
+
+	case *ast.Identifier:
+
+		// A wildcard, or binding, matches anything.  But
+		// within a hash-pattern the key must be present.
+		err := e.compileMatchPresent(path, fails)
+		if err != nil {
+			return err
+		}
+
+		if node.Value != "_" {
+			*binds = append(*binds, matchBinding{name: node.Value, path: path})
+		}
+
+	case *ast.HashPattern:
+
+		// Each member must match its own pattern.
+		for i, key := range node.Keys {
+
+			sub := make([]ast.Expression, len(path), len(path)+1)
+			copy(sub, path)
+			sub = append(sub, key)
+
+			err := e.compilePattern(node.Values[i], sub, fails, binds)
+			if err != nil {
+				return err
+			}
+		}
+
+	default:
+
+		// A missing key would be stringified to match a
+		// regular expression, so test for its presence first.
+		if _, ok := node.(*ast.RegexpLiteral); ok {
+			err := e.compileMatchPresent(path, fails)
+			if err != nil {
+				return err
+			}
+		}
+
+		// A literal value, or regular expression.
+		err := e.compileMatchPath(path)
+		if err != nil {
+			return err
+		}
+		err = e.compile(node)
+		if err != nil {
+			return err
+		}
+		e.emit(code.OpCase)
+		*fails = append(*fails, e.emit(code.OpJumpIfFalse, 9999))
+	}
+
+	return nil
+}
+
+// constantContains returns true if the given call is to our in-built
+// `contains` function, with a constant needle.
+func (e *Eval) constantContains(node *ast.CallExpression) bool {
+
+	if node.Function.String() != "contains" || len(node.Arguments) != 2 {
+		return false
+	}
+	if !e.environment.IsBuiltin("contains") {
+		return false
+	}
+
+	switch node.Arguments[1].(type) {
+	case *ast.StringLiteral, *ast.IntegerLiteral, *ast.FloatLiteral, *ast.BooleanLiteral:
+		return true
+	}
+	return false
+}
+
+// addLoop records a loop which begins upon the given line, and returns
+// the identifier which refers to it.
+func (e *Eval) addLoop(kind string, line int) int {
+	e.loops = append(e.loops, vm.Loop{Kind: kind, Line: line, Limit: e.loopLimit(line)})
+	return len(e.loops) - 1
+}
+
+// loopTargets holds the destinations of the break, and continue,
+// statements within a loop.
+type loopTargets struct {
+	// foreach is true if the loop is a foreach loop.
+	foreach bool
+
+	// next is the offset continue jumps to.
+	next int
+
+	// breaks holds the offsets of the jumps emitted for break, which
+	// are patched once the end of the loop is known.
+	breaks []int
+}
+
+// enterLoop records that we're compiling the body of a loop.
+func (e *Eval) enterLoop(foreach bool, next int) *loopTargets {
+	t := &loopTargets{foreach: foreach, next: next}
+	e.targets = append(e.targets, t)
+	return t
+}
+
+// leaveLoop records that we've finished compiling the body of a loop.
+func (e *Eval) leaveLoop() {
+	e.targets = e.targets[:len(e.targets)-1]
+}
+
 // addConstant adds a constant to the pool
 func (e *Eval) addConstant(obj object.Object) int {
 
 	//
-	// Look to see if the constant is present already
+	// Look to see if the constant is present already, unless it
+	// is a function which would be distinct even if it did look
+	// the same.
 	//
+	if _, ok := obj.(*object.Function); ok {
+		e.constants = append(e.constants, obj)
+		return len(e.constants) - 1
+	}
 	for i, c := range e.constants {
 
 		//
@@ -936,6 +1796,10 @@ func (e *Eval) emit(op code.Opcode, operands ...int) int {
 	posNewInstruction := len(e.instructions)
 	e.instructions = append(e.instructions, ins...)
 
+	if e.position.Line > 0 {
+		e.sources[posNewInstruction] = e.position
+	}
+
 	return posNewInstruction
 }
 
@@ -0,0 +1,512 @@
+// This file contains the translation of CEL expressions.
+//
+// CEL's syntax is close to our own, so most expressions translate
+// directly.  The differences are in its functions, which are often
+// invoked as methods - `name.size()` rather than `len( name )` - and in
+// its macros, such as `items.exists(i, i > 3)`, which have no equivalent
+// and are reported as such.
+
+package convert
+
+import (
+	"fmt"
+	"strings"
+)
+
+// celParser translates a CEL expression.
+type celParser struct {
+	parser
+}
+
+// celMacros contains the macros of CEL, which we can't translate.
+var celMacros = map[string]bool{
+	"all":        true,
+	"exists":     true,
+	"exists_one": true,
+	"filter":     true,
+	"map":        true,
+}
+
+// celFunctions maps the functions of CEL, which take a single argument,
+// to our own.
+var celFunctions = map[string]string{
+	"double":     "float",
+	"int":        "int",
+	"lowerAscii": "lower",
+	"size":       "len",
+	"string":     "string",
+	"trim":       "trim",
+	"upperAscii": "upper",
+}
+
+// FromCEL translates the given CEL expression into a script, such as:
+//
+//	user.age >= 18 && user.name.startsWith("S")
+//
+// If the expression uses a construct which can't be translated then an
+// *Error is returned which names it.
+func FromCEL(expr string) (string, error) {
+
+	toks, err := lex(expr, true)
+	if err != nil {
+		return "", err
+	}
+
+	p := &celParser{parser{tokens: toks}}
+	out, err := p.parseExpression()
+	if err != nil {
+		return "", err
+	}
+	if err := p.value(out, p.peek()); err != nil {
+		return "", err
+	}
+	return p.finish(out.text)
+}
+
+// celValue is the translation of part of an expression.
+type celValue struct {
+
+	// text holds the translation.
+	text string
+
+	// null is true if the value is the `null` literal, which may only
+	// be compared against.
+	null bool
+
+	// literal holds the contents of a string literal, and isString is
+	// true if the value is one.
+	literal  string
+	isString bool
+}
+
+// value returns an error if the given value can't be used as a value,
+// as it is the `null` literal.
+func (p *celParser) value(v celValue, tok item) error {
+	if v.null {
+		return unsupported(tok, "the use of null, other than in a comparison,")
+	}
+	return nil
+}
+
+// parseExpression parses a ternary expression, which binds least tightly.
+func (p *celParser) parseExpression() (celValue, error) {
+
+	tok := p.peek()
+	cond, err := p.parseBinary(0)
+	if err != nil {
+		return celValue{}, err
+	}
+	if !p.accept("?") {
+		return cond, nil
+	}
+	if err := p.value(cond, tok); err != nil {
+		return celValue{}, err
+	}
+
+	tok = p.peek()
+	yes, err := p.parseExpression()
+	if err != nil {
+		return celValue{}, err
+	}
+	if err := p.value(yes, tok); err != nil {
+		return celValue{}, err
+	}
+	if err := p.expect(":"); err != nil {
+		return celValue{}, err
+	}
+	tok = p.peek()
+	no, err := p.parseExpression()
+	if err != nil {
+		return celValue{}, err
+	}
+	if err := p.value(no, tok); err != nil {
+		return celValue{}, err
+	}
+	return celValue{text: "( " + cond.text + " ? " + yes.text + " : " + no.text + " )"}, nil
+}
+
+// celLevels holds the binary operators of each level of precedence, from
+// the lowest to the highest.
+var celLevels = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!=", "<", "<=", ">", ">=", "in"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+// parseBinary parses the binary operators of the given level of
+// precedence, and those above it.
+func (p *celParser) parseBinary(level int) (celValue, error) {
+
+	if level == len(celLevels) {
+		return p.parseUnary()
+	}
+
+	ltok := p.peek()
+	left, err := p.parseBinary(level + 1)
+	if err != nil {
+		return celValue{}, err
+	}
+
+	for {
+		op := ""
+		for _, o := range celLevels[level] {
+			if p.is(o) {
+				op = o
+			}
+		}
+		if op == "" {
+			return left, nil
+		}
+		optok := p.next()
+
+		rtok := p.peek()
+		right, err := p.parseBinary(level + 1)
+		if err != nil {
+			return celValue{}, err
+		}
+
+		// Comparisons against null test the type of the value.
+		if left.null || right.null {
+			if op != "==" && op != "!=" {
+				return celValue{}, unsupported(optok, fmt.Sprintf("the comparison of null with '%s'", op))
+			}
+			if left.null && right.null {
+				return celValue{}, unsupported(ltok, "the comparison of null with itself")
+			}
+			other := left
+			if left.null {
+				other = right
+			}
+			left = celValue{text: nullTest(other.text, op)}
+			continue
+		}
+		if err := p.value(left, ltok); err != nil {
+			return celValue{}, err
+		}
+		if err := p.value(right, rtok); err != nil {
+			return celValue{}, err
+		}
+
+		// Membership works upon lists, and the keys of maps, just
+		// as `contains` does.
+		if op == "in" {
+			left = celValue{text: "contains( " + right.text + ", " + left.text + " )"}
+			continue
+		}
+		left = celValue{text: "( " + left.text + " " + op + " " + right.text + " )"}
+	}
+}
+
+// parseUnary parses the `!`, and `-`, prefix operators.
+func (p *celParser) parseUnary() (celValue, error) {
+
+	for _, op := range []string{"!", "-"} {
+		if p.is(op) {
+			p.next()
+			tok := p.peek()
+			val, err := p.parseUnary()
+			if err != nil {
+				return celValue{}, err
+			}
+			if err := p.value(val, tok); err != nil {
+				return celValue{}, err
+			}
+			return celValue{text: op + val.text}, nil
+		}
+	}
+	return p.parseMember()
+}
+
+// parseMember parses the fields, indexes, and methods of a value.
+func (p *celParser) parseMember() (celValue, error) {
+
+	tok := p.peek()
+	val, err := p.parsePrimary()
+	if err != nil {
+		return celValue{}, err
+	}
+
+	for {
+		switch {
+		case p.is("."):
+			p.next()
+			name := p.next()
+			if name.kind != ident {
+				p.pos--
+				return celValue{}, p.errorf("expected a field, found %s", name)
+			}
+			if err := p.value(val, tok); err != nil {
+				return celValue{}, err
+			}
+
+			// A method.
+			if p.is("(") {
+				val, err = p.parseMethod(val, name)
+				if err != nil {
+					return celValue{}, err
+				}
+				continue
+			}
+
+			if !validName(name.text) {
+				return celValue{}, unsupported(name, fmt.Sprintf("the field '%s'", name.text))
+			}
+			val = celValue{text: val.text + "." + name.text}
+
+		case p.is("["):
+			p.next()
+			if err := p.value(val, tok); err != nil {
+				return celValue{}, err
+			}
+			itok := p.peek()
+			index, err := p.parseExpression()
+			if err != nil {
+				return celValue{}, err
+			}
+			if err := p.value(index, itok); err != nil {
+				return celValue{}, err
+			}
+			if err := p.expect("]"); err != nil {
+				return celValue{}, err
+			}
+			val = celValue{text: val.text + "[" + index.text + "]"}
+
+		default:
+			return val, nil
+		}
+	}
+}
+
+// parseMethod parses the invocation of a method upon the given value.
+func (p *celParser) parseMethod(recv celValue, name item) (celValue, error) {
+
+	if celMacros[name.text] {
+		return celValue{}, unsupported(name, fmt.Sprintf("the CEL macro '%s'", name.text))
+	}
+
+	args, err := p.parseArguments()
+	if err != nil {
+		return celValue{}, err
+	}
+
+	switch name.text {
+	case "contains":
+		if len(args) == 1 {
+			return celValue{text: "contains( " + recv.text + ", " + args[0].text + " )"}, nil
+		}
+	case "startsWith", "endsWith", "matches":
+		if len(args) == 1 {
+			return p.stringTest(name, recv, args[0])
+		}
+	default:
+		if fn, ok := celFunctions[name.text]; ok && len(args) == 0 {
+			return celValue{text: fn + "( " + recv.text + " )"}, nil
+		}
+	}
+	return celValue{}, unsupported(name, fmt.Sprintf("the CEL function '%s'", name.text))
+}
+
+// stringTest translates the `startsWith`, `endsWith`, and `matches`,
+// functions, whose argument must be a string literal as it becomes a
+// regular expression.
+func (p *celParser) stringTest(name item, recv celValue, arg celValue) (celValue, error) {
+
+	if !arg.isString {
+		return celValue{}, unsupported(name, fmt.Sprintf("the CEL function '%s', without a string literal,", name.text))
+	}
+
+	switch name.text {
+	case "startsWith":
+		return celValue{text: prefix(recv.text, arg.literal)}, nil
+	case "endsWith":
+		return celValue{text: suffix(recv.text, arg.literal)}, nil
+	}
+	return celValue{text: "( " + recv.text + " ~= " + regexpLiteral(arg.literal) + " )"}, nil
+}
+
+// parseArguments parses the arguments of a function, or method.
+func (p *celParser) parseArguments() ([]celValue, error) {
+
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+
+	var args []celValue
+	if p.accept(")") {
+		return args, nil
+	}
+	for {
+		tok := p.peek()
+		arg, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.value(arg, tok); err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+
+		if p.accept(")") {
+			return args, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// parsePrimary parses a literal, a field, a function, or an expression
+// within parentheses.
+func (p *celParser) parsePrimary() (celValue, error) {
+
+	tok := p.peek()
+	switch tok.kind {
+	case number:
+		p.next()
+		return celValue{text: tok.text}, nil
+	case str:
+		p.next()
+		return celValue{text: quote(tok.text), literal: tok.text, isString: true}, nil
+	case ident:
+		p.next()
+		switch tok.text {
+		case "true", "false":
+			return celValue{text: tok.text}, nil
+		case "null":
+			return celValue{text: "null", null: true}, nil
+		}
+		if p.is("(") {
+			return p.parseFunction(tok)
+		}
+		if !validName(tok.text) {
+			return celValue{}, unsupported(tok, fmt.Sprintf("the field '%s'", tok.text))
+		}
+		return celValue{text: tok.text}, nil
+	case punct:
+		switch tok.text {
+		case "(":
+			p.next()
+			val, err := p.parseExpression()
+			if err != nil {
+				return celValue{}, err
+			}
+			if err := p.expect(")"); err != nil {
+				return celValue{}, err
+			}
+			if val.null {
+				return val, nil
+			}
+			return celValue{text: "( " + val.text + " )", literal: val.literal, isString: val.isString}, nil
+		case "[":
+			return p.parseList()
+		case "{":
+			return p.parseMap()
+		}
+	}
+	return celValue{}, p.errorf("unexpected %s", tok)
+}
+
+// parseFunction parses the invocation of a global function.
+func (p *celParser) parseFunction(name item) (celValue, error) {
+
+	if celMacros[name.text] {
+		return celValue{}, unsupported(name, fmt.Sprintf("the CEL macro '%s'", name.text))
+	}
+
+	// Testing for the presence of a field means testing whether
+	// it is null, as missing fields are.
+	if name.text == "has" {
+		p.next()
+		tok := p.peek()
+		arg, err := p.parseMember()
+		if err != nil {
+			return celValue{}, err
+		}
+		if !strings.Contains(arg.text, ".") {
+			return celValue{}, &Error{Column: tok.column, Message: "has() requires a field"}
+		}
+		if err := p.expect(")"); err != nil {
+			return celValue{}, err
+		}
+		return celValue{text: nullTest(arg.text, "!=")}, nil
+	}
+
+	args, err := p.parseArguments()
+	if err != nil {
+		return celValue{}, err
+	}
+
+	if name.text == "matches" && len(args) == 2 {
+		return p.stringTest(name, args[0], args[1])
+	}
+	if name.text == "dyn" && len(args) == 1 {
+		return args[0], nil
+	}
+	if fn, ok := celFunctions[name.text]; ok && len(args) == 1 {
+		return celValue{text: fn + "( " + args[0].text + " )"}, nil
+	}
+	return celValue{}, unsupported(name, fmt.Sprintf("the CEL function '%s'", name.text))
+}
+
+// parseList parses a list literal.
+func (p *celParser) parseList() (celValue, error) {
+
+	p.next()
+	var vals []string
+	for !p.accept("]") {
+		tok := p.peek()
+		val, err := p.parseExpression()
+		if err != nil {
+			return celValue{}, err
+		}
+		if err := p.value(val, tok); err != nil {
+			return celValue{}, err
+		}
+		vals = append(vals, val.text)
+
+		if !p.is("]") {
+			if err := p.expect(","); err != nil {
+				return celValue{}, err
+			}
+		}
+	}
+	return celValue{text: "[ " + strings.Join(vals, ", ") + " ]"}, nil
+}
+
+// parseMap parses a map literal.
+func (p *celParser) parseMap() (celValue, error) {
+
+	p.next()
+	var pairs []string
+	for !p.accept("}") {
+		tok := p.peek()
+		key, err := p.parseExpression()
+		if err != nil {
+			return celValue{}, err
+		}
+		if err := p.value(key, tok); err != nil {
+			return celValue{}, err
+		}
+		if err := p.expect(":"); err != nil {
+			return celValue{}, err
+		}
+		tok = p.peek()
+		val, err := p.parseExpression()
+		if err != nil {
+			return celValue{}, err
+		}
+		if err := p.value(val, tok); err != nil {
+			return celValue{}, err
+		}
+		pairs = append(pairs, key.text+": "+val.text)
+
+		if !p.is("}") {
+			if err := p.expect(","); err != nil {
+				return celValue{}, err
+			}
+		}
+	}
+	return celValue{text: "{ " + strings.Join(pairs, ", ") + " }"}, nil
+}
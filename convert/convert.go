@@ -0,0 +1,159 @@
+// Package convert translates the filter expressions of other languages,
+// Google's CEL and jq, into evalfilter scripts.
+//
+// Only the parts of those languages which have an equivalent here can be
+// translated: comparisons, boolean logic, arithmetic, the fields of the
+// object, and the common functions upon strings and arrays.  Constructs
+// which have no equivalent, such as CEL's macros or jq's iteration, are
+// reported with an *Error which names them, rather than being translated
+// into a script which behaves differently.
+//
+// The scripts produced are a single `return` statement, so that the
+// result of the expression is the result of the script:
+//
+//	script, err := convert.FromCEL(`size(user.name) > 3 && user.age >= 18`)
+//	// return ( ( len( user.name ) > 3 ) && ( user.age >= 18 ) );
+package convert
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/skx/evalfilter/v2/token"
+)
+
+// Error is the error returned if an expression can't be translated.
+type Error struct {
+
+	// Column holds the column, within the expression, at which the
+	// error was found.  Columns start at one.
+	Column int
+
+	// Construct holds the construct which can't be translated, such
+	// as "the CEL macro 'exists'", or is empty if the expression is
+	// invalid rather than untranslatable.
+	Construct string
+
+	// Message holds the description of the error.
+	Message string
+}
+
+// Error returns the description of the error.
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s at column %d", e.Message, e.Column)
+}
+
+// parser holds the state which is common to the translation of each
+// language.
+type parser struct {
+
+	// tokens holds the tokens of the expression.
+	tokens []item
+
+	// pos holds the index of the next token.
+	pos int
+}
+
+// peek returns the next token, without consuming it.
+func (p *parser) peek() item {
+	return p.tokens[p.pos]
+}
+
+// next consumes, and returns, the next token.
+func (p *parser) next() item {
+	tok := p.tokens[p.pos]
+	if tok.kind != eof {
+		p.pos++
+	}
+	return tok
+}
+
+// is returns true if the next token is the given symbol, or word.
+func (p *parser) is(text string) bool {
+	tok := p.peek()
+	return (tok.kind == punct || tok.kind == ident) && tok.text == text
+}
+
+// accept consumes the next token if it is the given symbol, or word.
+func (p *parser) accept(text string) bool {
+	if p.is(text) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// expect consumes the given symbol, or word, or returns an error.
+func (p *parser) expect(text string) error {
+	if !p.accept(text) {
+		return p.errorf("expected '%s', found %s", text, p.peek())
+	}
+	return nil
+}
+
+// errorf returns an error at the next token.
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return &Error{Column: p.peek().column, Message: fmt.Sprintf(format, args...)}
+}
+
+// unsupported returns an error reporting that the given construct, found
+// at the given token, can't be translated.
+func unsupported(tok item, construct string) error {
+	return &Error{
+		Column:    tok.column,
+		Construct: construct,
+		Message:   fmt.Sprintf("%s can't be translated", construct),
+	}
+}
+
+// finish returns the script which returns the given expression, once
+// the whole of the input has been translated.
+func (p *parser) finish(expr string) (string, error) {
+	if p.peek().kind != eof {
+		return "", p.errorf("unexpected %s", p.peek())
+	}
+	return "return " + expr + ";", nil
+}
+
+// identifier matches the names which are valid identifiers in our
+// scripts.
+var identifier = regexp.MustCompile(`^[\pL_$][\pL\pN_$]*$`)
+
+// validName returns true if the given name may be used as the name of a
+// field within a script.
+func validName(name string) bool {
+	return identifier.MatchString(name) && token.LookupIdentifier(name) == token.IDENT
+}
+
+// quote returns the given string as a literal within a script.
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`).Replace(s) + `"`
+}
+
+// regexpLiteral returns the given regular expression as a literal within
+// a script.
+//
+// Our lexer treats a backslash as escaping the character which follows
+// it, so both it and the slash need escaping.
+func regexpLiteral(re string) string {
+	return "/" + strings.NewReplacer(`\`, `\\`, `/`, `\/`).Replace(re) + "/"
+}
+
+// prefix returns the test that the given expression begins with the
+// given literal string.
+func prefix(expr string, lit string) string {
+	return "( " + expr + " ~= " + regexpLiteral("^"+regexp.QuoteMeta(lit)) + " )"
+}
+
+// suffix returns the test that the given expression ends with the given
+// literal string.
+func suffix(expr string, lit string) string {
+	return "( " + expr + " ~= " + regexpLiteral(regexp.QuoteMeta(lit)+"$") + " )"
+}
+
+// nullTest returns the test of whether the given expression is, or isn't,
+// null - which we can't compare against directly.
+func nullTest(expr string, op string) string {
+	return "( type( " + expr + " ) " + op + " \"null\" )"
+}
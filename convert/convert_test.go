@@ -0,0 +1,159 @@
+package convert
+
+import (
+	"testing"
+
+	"github.com/skx/evalfilter/v2"
+)
+
+// TestCEL tests the translation of CEL expressions.
+func TestCEL(t *testing.T) {
+
+	tests := []struct {
+		Input  string
+		Output string
+	}{
+		{`a == 1`, `return ( a == 1 );`},
+		{`a.b != "x" || !c`, `return ( ( a.b != "x" ) || !c );`},
+		{`size(name) > 3 && age >= 18`, `return ( ( len( name ) > 3 ) && ( age >= 18 ) );`},
+		{`"admin" in roles`, `return contains( roles, "admin" );`},
+		{`name.startsWith("S")`, `return ( name ~= /^S/ );`},
+		{`name.endsWith("a/b")`, `return ( name ~= /a\/b$/ );`},
+		{`name.matches("^[a-z]+\\d$")`, `return ( name ~= /^[a-z]+\\d$/ );`},
+		{`has(user.email)`, `return ( type( user.email ) != "null" );`},
+		{`email == null`, `return ( type( email ) == "null" );`},
+		{`x[0] + 2 * y`, `return ( x[0] + ( 2 * y ) );`},
+		{`a ? 1 : 2`, `return ( a ? 1 : 2 );`},
+	}
+
+	for _, tst := range tests {
+		out, err := FromCEL(tst.Input)
+		if err != nil {
+			t.Errorf("unexpected error translating %s: %s", tst.Input, err)
+			continue
+		}
+		if out != tst.Output {
+			t.Errorf("translating %s gave %s, expected %s", tst.Input, out, tst.Output)
+		}
+	}
+}
+
+// TestJQ tests the translation of jq filters.
+func TestJQ(t *testing.T) {
+
+	tests := []struct {
+		Input  string
+		Output string
+	}{
+		{`.a == 1`, `return ( a == 1 );`},
+		{`.a.b["c"] > 3 and .d`, `return ( ( a.b["c"] > 3 ) && d );`},
+		{`select(.age >= 18 or .admin)`, `return ( ( age >= 18 ) || admin );`},
+		{`.name | ascii_downcase | startswith("s")`, `return ( lower( name ) ~= /^s/ );`},
+		{`.tags | length > 2`, `return ( len( tags ) > 2 );`},
+		{`has("email") | not`, `return !( type( email ) != "null" );`},
+		{`.email != null`, `return ( type( email ) != "null" );`},
+		{`.name | test("^a"; "i")`, `return ( name ~= /(?i)^a/ );`},
+		{`if .a then 1 elif .b then 2 else 3 end`, `return ( a ? 1 : ( b ? 2 : 3 ) );`},
+	}
+
+	for _, tst := range tests {
+		out, err := FromJQ(tst.Input)
+		if err != nil {
+			t.Errorf("unexpected error translating %s: %s", tst.Input, err)
+			continue
+		}
+		if out != tst.Output {
+			t.Errorf("translating %s gave %s, expected %s", tst.Input, out, tst.Output)
+		}
+	}
+}
+
+// TestUnsupported tests that untranslatable constructs are reported.
+func TestUnsupported(t *testing.T) {
+
+	tests := []struct {
+		Language  string
+		Input     string
+		Construct string
+		Column    int
+	}{
+		{"cel", `items.exists(x, x > 3)`, "the CEL macro 'exists'", 7},
+		{"cel", `a +`, "", 4},
+		{"jq", `.[] | .x`, "the jq '.[]' iterator", 2},
+		{"jq", `.a, .b`, "", 3},
+		{"jq", `map(.x)`, "the jq function 'map'", 1},
+		{"jq", `"\(.a)"`, "string interpolation", 2},
+	}
+
+	for _, tst := range tests {
+		var err error
+		if tst.Language == "cel" {
+			_, err = FromCEL(tst.Input)
+		} else {
+			_, err = FromJQ(tst.Input)
+		}
+		if err == nil {
+			t.Errorf("expected an error translating %s", tst.Input)
+			continue
+		}
+		cerr, ok := err.(*Error)
+		if !ok {
+			t.Errorf("translating %s gave the wrong kind of error: %T", tst.Input, err)
+			continue
+		}
+		if tst.Construct != "" && cerr.Construct != tst.Construct {
+			t.Errorf("translating %s reported %q, expected %q", tst.Input, cerr.Construct, tst.Construct)
+		}
+		if cerr.Column != tst.Column {
+			t.Errorf("translating %s reported column %d, expected %d", tst.Input, cerr.Column, tst.Column)
+		}
+	}
+}
+
+// TestScripts tests that the scripts we produce run, and give the result
+// of the expression they were translated from.
+func TestScripts(t *testing.T) {
+
+	type User struct {
+		Name  string
+		Age   int
+		Roles []string
+	}
+
+	tests := []struct {
+		Language string
+		Input    string
+		Result   bool
+	}{
+		{"cel", `Name.startsWith("St") && Age >= 18`, true},
+		{"cel", `"admin" in Roles`, true},
+		{"cel", `size(Roles) > 2`, false},
+		{"jq", `.Name | ascii_downcase | endswith("ve")`, true},
+		{"jq", `select(.Age < 18)`, false},
+	}
+
+	for _, tst := range tests {
+		var script string
+		var err error
+		if tst.Language == "cel" {
+			script, err = FromCEL(tst.Input)
+		} else {
+			script, err = FromJQ(tst.Input)
+		}
+		if err != nil {
+			t.Fatalf("unexpected error translating %s: %s", tst.Input, err)
+		}
+
+		eval := evalfilter.New(script)
+		if err = eval.Prepare(); err != nil {
+			t.Fatalf("failed to prepare %s: %s", script, err)
+		}
+		out, err := eval.Run(User{Name: "Steve", Age: 43, Roles: []string{"admin", "user"}})
+		if err != nil {
+			t.Fatalf("failed to run %s: %s", script, err)
+		}
+		if out != tst.Result {
+			t.Errorf("%s gave %v, expected %v", script, out, tst.Result)
+		}
+	}
+}
@@ -0,0 +1,614 @@
+// This file contains the translation of jq filters.
+//
+// A jq filter transforms its input, which is `.`, so `.age > 18` is the
+// comparison of the age field of the input.  The input of each stage of
+// a pipeline is the output of the stage before it, so we translate the
+// stages in turn, giving each the translation of the one before it as its
+// input:
+//
+//	.name | ascii_downcase | startswith("s")
+//
+// becomes `( lower( name ) ~= /^s/ )`.  `select(f)` is translated as its
+// condition, so that a filter which selects the objects it matches
+// becomes a script which is true for them.
+//
+// Filters which produce many outputs, such as `.[]` and `,`, have no
+// equivalent, nor do variables, reductions, and definitions.
+
+package convert
+
+import (
+	"fmt"
+	"strings"
+)
+
+// jqParser translates a jq filter.
+type jqParser struct {
+	parser
+
+	// selected is true if the stage of the pipeline we've just
+	// translated was `select(f)`, whose output is its input rather
+	// than its condition.
+	selected bool
+}
+
+// jqFunctions maps the functions of jq, which take no arguments and
+// operate upon their input, to our own.
+var jqFunctions = map[string]string{
+	"ascii_downcase": "lower",
+	"ascii_upcase":   "upper",
+	"keys":           "keys",
+	"length":         "len",
+	"tonumber":       "float",
+	"tostring":       "string",
+}
+
+// jqUnsupported contains the functions, and keywords, of jq which we know
+// can't be translated, so that they may be reported by name rather than
+// as unknown.
+var jqUnsupported = map[string]string{
+	"add":     "the jq function 'add'",
+	"all":     "the jq function 'all'",
+	"any":     "the jq function 'any'",
+	"def":     "jq definitions",
+	"empty":   "the jq function 'empty'",
+	"error":   "the jq function 'error'",
+	"map":     "the jq function 'map'",
+	"reduce":  "the jq 'reduce' expression",
+	"foreach": "the jq 'foreach' expression",
+	"try":     "the jq 'try' expression",
+	"type":    "the jq function 'type'",
+}
+
+// FromJQ translates the given jq filter into a script, such as:
+//
+//	select(.age >= 18 and (.name | startswith("S")))
+//
+// If the filter uses a construct which can't be translated then an
+// *Error is returned which names it.
+func FromJQ(filter string) (string, error) {
+
+	toks, err := lex(filter, false)
+	if err != nil {
+		return "", err
+	}
+
+	p := &jqParser{parser: parser{tokens: toks}}
+	out, err := p.parsePipe("")
+	if err != nil {
+		return "", err
+	}
+	return p.finish(out)
+}
+
+// parsePipe parses a pipeline, whose first stage has the given input.
+//
+// An empty input is the object the script is run against, which has no
+// name of its own.
+func (p *jqParser) parsePipe(input string) (string, error) {
+
+	p.selected = false
+	out, err := p.parseOr(input)
+	if err != nil {
+		return "", err
+	}
+	for p.is("|") {
+		if p.selected {
+			return "", unsupported(p.peek(), "a pipeline which continues after 'select'")
+		}
+		p.next()
+		out, err = p.parseOr(out)
+		if err != nil {
+			return "", err
+		}
+	}
+	if p.is(",") {
+		return "", unsupported(p.peek(), "the jq ',' operator")
+	}
+	if p.is("as") {
+		return "", unsupported(p.peek(), "jq variables")
+	}
+	if p.is("//") {
+		return "", unsupported(p.peek(), "the jq '//' operator")
+	}
+	return out, nil
+}
+
+// parseOr parses filters joined by `or`.
+func (p *jqParser) parseOr(input string) (string, error) {
+	left, err := p.parseAnd(input)
+	if err != nil {
+		return "", err
+	}
+	for p.accept("or") {
+		right, err := p.parseAnd(input)
+		if err != nil {
+			return "", err
+		}
+		left = "( " + left + " || " + right + " )"
+	}
+	return left, nil
+}
+
+// parseAnd parses filters joined by `and`.
+func (p *jqParser) parseAnd(input string) (string, error) {
+	left, err := p.parseComparison(input)
+	if err != nil {
+		return "", err
+	}
+	for p.accept("and") {
+		right, err := p.parseComparison(input)
+		if err != nil {
+			return "", err
+		}
+		left = "( " + left + " && " + right + " )"
+	}
+	return left, nil
+}
+
+// parseComparison parses a comparison, which can't be chained.
+func (p *jqParser) parseComparison(input string) (string, error) {
+
+	left, lnull, err := p.parseOperand(input)
+	if err != nil {
+		return "", err
+	}
+
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if !p.is(op) {
+			continue
+		}
+		optok := p.next()
+		right, rnull, err := p.parseOperand(input)
+		if err != nil {
+			return "", err
+		}
+
+		// Comparisons against null test the type of the value.
+		if lnull || rnull {
+			if (op != "==" && op != "!=") || (lnull && rnull) {
+				return "", unsupported(optok, fmt.Sprintf("the comparison of null with '%s'", op))
+			}
+			if lnull {
+				return nullTest(right, op), nil
+			}
+			return nullTest(left, op), nil
+		}
+		return "( " + left + " " + op + " " + right + " )", nil
+	}
+
+	if lnull {
+		return "", unsupported(p.peek(), "the use of null, other than in a comparison,")
+	}
+	return left, nil
+}
+
+// parseOperand parses the operand of a comparison, and returns whether
+// it is the `null` literal.
+func (p *jqParser) parseOperand(input string) (string, bool, error) {
+	if p.is("null") {
+		p.next()
+		return "", true, nil
+	}
+	out, err := p.parseArithmetic(input, 0)
+	return out, false, err
+}
+
+// jqLevels holds the arithmetic operators of each level of precedence,
+// from the lowest to the highest.
+var jqLevels = [][]string{
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+// parseArithmetic parses the arithmetic operators of the given level of
+// precedence, and those above it.
+func (p *jqParser) parseArithmetic(input string, level int) (string, error) {
+
+	if level == len(jqLevels) {
+		return p.parsePostfix(input)
+	}
+
+	left, err := p.parseArithmetic(input, level+1)
+	if err != nil {
+		return "", err
+	}
+	for {
+		op := ""
+		for _, o := range jqLevels[level] {
+			if p.is(o) {
+				op = o
+			}
+		}
+		if op == "" {
+			return left, nil
+		}
+		p.next()
+		right, err := p.parseArithmetic(input, level+1)
+		if err != nil {
+			return "", err
+		}
+		left = "( " + left + " " + op + " " + right + " )"
+	}
+}
+
+// parsePostfix parses a term, followed by any fields, or indexes, of it.
+func (p *jqParser) parsePostfix(input string) (string, error) {
+
+	out, err := p.parseTerm(input)
+	if err != nil {
+		return "", err
+	}
+	return p.parsePath(out)
+}
+
+// parsePath parses the fields, and indexes, which follow the given
+// value - as in `.a.b["c"][0]`.
+func (p *jqParser) parsePath(val string) (string, error) {
+
+	for {
+		switch {
+		case p.is("."):
+			dot := p.next()
+			name := p.peek()
+			if name.kind == str {
+				p.next()
+				val = p.index(val, quote(name.text))
+				if val == "" {
+					return "", unsupported(name, "the field "+name.String()+" of the input")
+				}
+				continue
+			}
+			if name.kind != ident || name.column != dot.column+1 {
+				return "", unsupported(dot, "the jq identity filter, within a path,")
+			}
+			p.next()
+			if !validName(name.text) {
+				return "", unsupported(name, fmt.Sprintf("the field '%s'", name.text))
+			}
+			if val == "" {
+				val = name.text
+			} else {
+				val = val + "." + name.text
+			}
+
+		case p.is("["):
+			open := p.next()
+			if p.is("]") {
+				return "", unsupported(open, "the jq '.[]' iterator")
+			}
+			index, err := p.parsePipe("")
+			if err != nil {
+				return "", err
+			}
+			if p.is(":") {
+				return "", unsupported(p.peek(), "jq slices")
+			}
+			if err := p.expect("]"); err != nil {
+				return "", err
+			}
+			if val == "" {
+				return "", unsupported(open, "the index of the input")
+			}
+			val = val + "[" + index + "]"
+
+		case p.is("?"):
+			return "", unsupported(p.peek(), "the jq '?' operator")
+
+		default:
+			return val, nil
+		}
+	}
+}
+
+// index returns the given index of the value, or an empty string if the
+// value is the input, which can't be indexed.
+func (p *jqParser) index(val string, index string) string {
+	if val == "" {
+		return ""
+	}
+	return val + "[" + index + "]"
+}
+
+// parseTerm parses a single term of a filter.
+func (p *jqParser) parseTerm(input string) (string, error) {
+
+	tok := p.peek()
+	switch tok.kind {
+	case number:
+		p.next()
+		return tok.text, nil
+
+	case str:
+		p.next()
+		return quote(tok.text), nil
+
+	case ident:
+		return p.parseFunction(input)
+
+	case punct:
+		switch tok.text {
+		case ".":
+			// The input itself, which is followed by the path
+			// of its fields.
+			next := p.tokens[p.pos+1]
+			if (next.kind == ident || next.kind == str) && next.column == tok.column+1 {
+				return input, nil
+			}
+			if next.kind == punct && next.text == "[" && next.column == tok.column+1 {
+				p.next()
+				return input, nil
+			}
+			p.next()
+			if input == "" {
+				return "", unsupported(tok, "the jq identity filter, of the whole input,")
+			}
+			return input, nil
+
+		case "..":
+			return "", unsupported(tok, "the jq '..' recursive descent")
+
+		case "$", "@":
+			return "", unsupported(tok, fmt.Sprintf("the jq '%s' syntax", tok.text))
+
+		case "-":
+			p.next()
+			val, err := p.parsePostfix(input)
+			if err != nil {
+				return "", err
+			}
+			return "-" + val, nil
+
+		case "(":
+			p.next()
+			val, err := p.parsePipe(input)
+			if err != nil {
+				return "", err
+			}
+			if err := p.expect(")"); err != nil {
+				return "", err
+			}
+			return "( " + val + " )", nil
+
+		case "[":
+			return p.parseArray(input)
+
+		case "{":
+			return "", unsupported(tok, "jq object construction")
+		}
+	}
+	return "", p.errorf("unexpected %s", tok)
+}
+
+// parseArray parses the construction of an array, from the values of the
+// filters separated by commas.
+func (p *jqParser) parseArray(input string) (string, error) {
+
+	p.next()
+	var vals []string
+	for !p.accept("]") {
+		val, err := p.parseOr(input)
+		if err != nil {
+			return "", err
+		}
+		vals = append(vals, val)
+		if !p.is("]") {
+			if err := p.expect(","); err != nil {
+				return "", err
+			}
+		}
+	}
+	return "[ " + strings.Join(vals, ", ") + " ]", nil
+}
+
+// parseFunction parses a keyword, or the invocation of a function upon
+// the input.
+func (p *jqParser) parseFunction(input string) (string, error) {
+
+	name := p.next()
+	switch name.text {
+	case "true", "false":
+		return name.text, nil
+
+	case "not":
+		if input == "" {
+			return "", unsupported(name, "'not', of the whole input,")
+		}
+		return "!" + input, nil
+
+	case "if":
+		return p.parseIf(input)
+
+	case "select":
+		args, err := p.parseArguments(input, name, 1)
+		if err != nil {
+			return "", err
+		}
+		p.selected = true
+		return args[0], nil
+	}
+
+	if construct, ok := jqUnsupported[name.text]; ok {
+		return "", unsupported(name, construct)
+	}
+
+	// Testing whether the input has a field means testing whether
+	// it is null, as missing fields are.
+	if name.text == "has" && input == "" {
+		lit, err := p.parseLiteral(name)
+		if err != nil {
+			return "", err
+		}
+		if !validName(lit) {
+			return "", unsupported(name, fmt.Sprintf("the field %q", lit))
+		}
+		return nullTest(lit, "!="), nil
+	}
+
+	// The remaining functions operate upon the input.
+	if input == "" {
+		return "", unsupported(name, fmt.Sprintf("the jq function '%s', of the whole input,", name.text))
+	}
+
+	if fn, ok := jqFunctions[name.text]; ok {
+		if p.is("(") {
+			return "", p.errorf("the jq function '%s' takes no arguments", name.text)
+		}
+		return fn + "( " + input + " )", nil
+	}
+
+	switch name.text {
+	case "startswith", "endswith":
+		lit, err := p.parseLiteral(name)
+		if err != nil {
+			return "", err
+		}
+		if name.text == "startswith" {
+			return prefix(input, lit), nil
+		}
+		return suffix(input, lit), nil
+
+	case "test":
+		return p.parseTest(input, name)
+
+	case "contains", "has", "inside":
+		// jq's contains tests whether each member of an array
+		// is contained, rather than the array itself, so we only
+		// translate tests of strings, and keys.
+		lit, err := p.parseLiteral(name)
+		if err != nil {
+			return "", err
+		}
+		if name.text == "inside" {
+			return "contains( " + quote(lit) + ", " + input + " )", nil
+		}
+		return "contains( " + input + ", " + quote(lit) + " )", nil
+	}
+
+	return "", unsupported(name, fmt.Sprintf("the jq function '%s'", name.text))
+}
+
+// parseArguments parses the given number of arguments of a function,
+// which are separated by semicolons.  Each has the same input as the
+// function.
+func (p *jqParser) parseArguments(input string, name item, count int) ([]string, error) {
+
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+
+	var args []string
+	for {
+		arg, err := p.parsePipe(input)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if !p.accept(";") {
+			break
+		}
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	if len(args) != count {
+		return nil, &Error{Column: name.column, Message: fmt.Sprintf("the jq function '%s' requires %d argument(s)", name.text, count)}
+	}
+	return args, nil
+}
+
+// parseLiteral parses the argument of a function, which must be a string
+// literal.
+func (p *jqParser) parseLiteral(name item) (string, error) {
+
+	if err := p.expect("("); err != nil {
+		return "", err
+	}
+	tok := p.peek()
+	if tok.kind != str {
+		return "", unsupported(name, fmt.Sprintf("the jq function '%s', without a string literal,", name.text))
+	}
+	p.next()
+	if err := p.expect(")"); err != nil {
+		return "", err
+	}
+	return tok.text, nil
+}
+
+// parseTest parses `test(re)`, or `test(re; flags)`, whose arguments
+// must be string literals as they become a regular expression.
+func (p *jqParser) parseTest(input string, name item) (string, error) {
+
+	if err := p.expect("("); err != nil {
+		return "", err
+	}
+
+	tok := p.peek()
+	if tok.kind != str {
+		return "", unsupported(name, "the jq function 'test', without a string literal,")
+	}
+	p.next()
+	re := tok.text
+
+	if p.accept(";") {
+		flags := p.peek()
+		if flags.kind != str {
+			return "", unsupported(name, "the jq function 'test', without a string literal,")
+		}
+		p.next()
+		for _, c := range flags.text {
+			if c != 'i' {
+				return "", unsupported(flags, fmt.Sprintf("the regular expression flag '%c'", c))
+			}
+		}
+		if flags.text != "" {
+			re = "(?i)" + re
+		}
+	}
+	if err := p.expect(")"); err != nil {
+		return "", err
+	}
+	return "( " + input + " ~= " + regexpLiteral(re) + " )", nil
+}
+
+// parseIf parses `if c then a elif c then a else b end`, which becomes a
+// chain of ternary expressions.
+func (p *jqParser) parseIf(input string) (string, error) {
+
+	cond, err := p.parsePipe(input)
+	if err != nil {
+		return "", err
+	}
+	if err := p.expect("then"); err != nil {
+		return "", err
+	}
+	yes, err := p.parsePipe(input)
+	if err != nil {
+		return "", err
+	}
+
+	var no string
+	switch {
+	case p.accept("elif"):
+		no, err = p.parseIf(input)
+		if err != nil {
+			return "", err
+		}
+		return "( " + cond + " ? " + yes + " : " + no + " )", nil
+	case p.accept("else"):
+		no, err = p.parsePipe(input)
+		if err != nil {
+			return "", err
+		}
+	default:
+		// Without an else the input is the result.
+		if input == "" {
+			return "", unsupported(p.peek(), "'if' without 'else', of the whole input,")
+		}
+		no = input
+	}
+	if err := p.expect("end"); err != nil {
+		return "", err
+	}
+	return "( " + cond + " ? " + yes + " : " + no + " )", nil
+}
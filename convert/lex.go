@@ -0,0 +1,156 @@
+// This file contains the lexer of the expressions we translate, which is
+// shared by each language as their tokens are so alike.
+
+package convert
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// kind is the type of a token.
+type kind int
+
+const (
+	eof kind = iota
+	ident
+	number
+	punct
+	str
+)
+
+// item is a single token of an expression.
+type item struct {
+
+	// kind holds the type of the token.
+	kind kind
+
+	// text holds the text of the token, with the quotes of strings
+	// removed and their escapes replaced.
+	text string
+
+	// column holds the column at which the token began.
+	column int
+}
+
+// String describes the token, for use in errors.
+func (i item) String() string {
+	switch i.kind {
+	case eof:
+		return "the end of the expression"
+	case str:
+		return strconv.Quote(i.text)
+	}
+	return "'" + i.text + "'"
+}
+
+// symbols contains the symbols of more than one character, which are
+// matched before those of a single character.
+var symbols = []string{"==", "!=", "<=", ">=", "&&", "||", "//", ".."}
+
+// lex returns the tokens of the given expression, ending with an eof
+// token.  Strings may be quoted with either kind of quote if single is
+// true, otherwise only double-quotes are allowed.
+func lex(expr string, single bool) ([]item, error) {
+
+	var out []item
+
+	chars := []rune(expr)
+	i := 0
+	for i < len(chars) {
+
+		c := chars[i]
+		start := i + 1
+
+		switch {
+		case unicode.IsSpace(c):
+			i++
+
+		case c == '"' || (single && c == '\''):
+			text, n, err := readString(chars[i:], start)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, item{kind: str, text: text, column: start})
+			i += n
+
+		case unicode.IsDigit(c):
+			for i < len(chars) && (unicode.IsDigit(chars[i]) || chars[i] == '.' || chars[i] == 'e' || chars[i] == 'E') {
+				i++
+			}
+			text := string(chars[start-1 : i])
+			if _, err := strconv.ParseFloat(text, 64); err != nil {
+				return nil, &Error{Column: start, Message: fmt.Sprintf("the number %s is invalid", text)}
+			}
+			out = append(out, item{kind: number, text: text, column: start})
+
+		case unicode.IsLetter(c) || c == '_':
+			for i < len(chars) && (unicode.IsLetter(chars[i]) || unicode.IsDigit(chars[i]) || chars[i] == '_') {
+				i++
+			}
+			out = append(out, item{kind: ident, text: string(chars[start-1 : i]), column: start})
+
+		default:
+			text := string(c)
+			for _, sym := range symbols {
+				if strings.HasPrefix(string(chars[i:]), sym) {
+					text = sym
+					break
+				}
+			}
+			if len(text) == 1 && !strings.ContainsRune("<>=+-*/%!?:.,()[]{}|;$@", c) {
+				return nil, &Error{Column: start, Message: fmt.Sprintf("unexpected character '%c'", c)}
+			}
+			out = append(out, item{kind: punct, text: text, column: start})
+			i += len([]rune(text))
+		}
+	}
+
+	return append(out, item{kind: eof, column: len(chars) + 1}), nil
+}
+
+// readString reads the string at the start of the given characters, and
+// returns its contents along with the number of characters it occupied.
+func readString(chars []rune, column int) (string, int, error) {
+
+	quote := chars[0]
+	out := ""
+
+	i := 1
+	for {
+		if i >= len(chars) {
+			return "", 0, &Error{Column: column, Message: "unterminated string"}
+		}
+
+		c := chars[i]
+		if c == quote {
+			return out, i + 1, nil
+		}
+		if c != '\\' {
+			out += string(c)
+			i++
+			continue
+		}
+
+		// jq interpolates expressions with `\(..)`.
+		if i+1 < len(chars) && chars[i+1] == '(' {
+			return "", 0, unsupported(item{column: column + i}, "string interpolation")
+		}
+
+		// As JSON allows the slash to be escaped, so does jq.
+		if i+1 < len(chars) && chars[i+1] == '/' {
+			out += "/"
+			i += 2
+			continue
+		}
+
+		val, _, tail, err := strconv.UnquoteChar(string(chars[i:]), byte(quote))
+		if err != nil {
+			return "", 0, &Error{Column: column + i, Message: "invalid escape in string"}
+		}
+		out += string(val)
+		i = len(chars) - len([]rune(tail))
+	}
+}
@@ -0,0 +1,220 @@
+// Package csvadapter allows CSV data to be used as the input to our
+// scripts.
+//
+// The first row of the data is a header, which names the columns, and
+// each subsequent row is presented to the script as a hash, keyed by
+// those names.  For example a script could filter this data:
+//
+//	name,age,admin
+//	steve,45,true
+//	bob,17,false
+//
+// with a script such as `return age >= 18 && admin;`.
+//
+// Values which look like integers, floating-point numbers, or booleans
+// are converted to the appropriate type, unless you ask for raw strings.
+package csvadapter
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/skx/evalfilter/v2"
+)
+
+// Reader reads the rows of CSV data, as hashes.
+type Reader struct {
+
+	// Comma is the field delimiter, which defaults to a comma.
+	//
+	// It must be set before the first row is read.
+	Comma rune
+
+	// Raw disables the conversion of values, so that every field is
+	// presented to the script as a string.
+	Raw bool
+
+	// csv is the reader we use to parse the data.
+	csv *csv.Reader
+
+	// header holds the names of our columns.
+	header []string
+
+	// record holds the fields of the most recent row.
+	record []string
+
+	// row holds the number of the most recent row.
+	row int
+}
+
+// New creates a reader for the CSV data within the given input.
+func New(in io.Reader) *Reader {
+	return &Reader{Comma: ',', csv: csv.NewReader(in)}
+}
+
+// Header returns the names of the columns, reading the header row if
+// it hasn't been read already.
+func (r *Reader) Header() ([]string, error) {
+
+	if r.header != nil {
+		return r.header, nil
+	}
+
+	r.csv.Comma = r.Comma
+
+	header, err := r.csv.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("there is no header row")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The names must be unique, otherwise one column would hide
+	// another.
+	seen := make(map[string]bool)
+	for i, name := range header {
+
+		// Spreadsheets often begin their exports with a
+		// byte-order mark.
+		if i == 0 {
+			name = strings.TrimPrefix(name, "\uFEFF")
+		}
+
+		name = strings.TrimSpace(name)
+		if name == "" {
+			return nil, fmt.Errorf("column %d of the header has no name", i+1)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("the header contains the column %s more than once", name)
+		}
+		seen[name] = true
+		header[i] = name
+	}
+
+	r.header = header
+	return r.header, nil
+}
+
+// Next returns the next row, as a map which may be passed to Run, or
+// io.EOF if there are no further rows.
+//
+// Every row must have the same number of fields as the header.
+func (r *Reader) Next() (map[string]interface{}, error) {
+
+	header, err := r.Header()
+	if err != nil {
+		return nil, err
+	}
+
+	record, err := r.csv.Read()
+	if err != nil {
+		return nil, err
+	}
+	r.record = record
+	r.row++
+
+	row := make(map[string]interface{}, len(header))
+	for i, name := range header {
+		if r.Raw {
+			row[name] = record[i]
+		} else {
+			row[name] = convert(record[i])
+		}
+	}
+	return row, nil
+}
+
+// Record returns the fields of the row most recently returned by Next,
+// exactly as they were read.
+func (r *Reader) Record() []string {
+	return r.record
+}
+
+// Row returns the number of the row most recently returned by Next,
+// for use in error messages.  The first row after the header is row 1.
+func (r *Reader) Row() int {
+	return r.row
+}
+
+// convert converts a field to an integer, float, or boolean, if it looks
+// like one - otherwise the string is returned unchanged.
+func convert(field string) interface{} {
+
+	str := strings.TrimSpace(field)
+
+	if i, err := strconv.ParseInt(str, 10, 64); err == nil {
+		return i
+	}
+
+	// We don't want to treat "NaN" or "Inf" as numbers.
+	if strings.ContainsAny(str, "0123456789") {
+		if f, err := strconv.ParseFloat(str, 64); err == nil {
+			return f
+		}
+	}
+
+	switch strings.ToLower(str) {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+
+	return field
+}
+
+// Filter runs the script against each row of the CSV data, and writes
+// the header, along with each row for which the script returned true, to
+// the output.
+//
+// The script must have been prepared already.  The number of rows which
+// matched is returned, along with the first error, if any.
+func (r *Reader) Filter(eval *evalfilter.Eval, out io.Writer) (int, error) {
+
+	header, err := r.Header()
+	if err != nil {
+		return 0, err
+	}
+
+	// Rows which matched before any error should still be output.
+	w := csv.NewWriter(out)
+	w.Comma = r.Comma
+	defer w.Flush()
+
+	err = w.Write(header)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for {
+		row, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return count, err
+		}
+
+		match, err := eval.Run(row)
+		if err != nil {
+			return count, fmt.Errorf("row %d: %s", r.Row(), err)
+		}
+		if !match {
+			continue
+		}
+
+		count++
+		err = w.Write(r.Record())
+		if err != nil {
+			return count, err
+		}
+	}
+
+	w.Flush()
+	return count, w.Error()
+}
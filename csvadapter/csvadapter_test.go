@@ -0,0 +1,142 @@
+package csvadapter
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/skx/evalfilter/v2"
+)
+
+// TestNext tests reading rows.
+func TestNext(t *testing.T) {
+
+	input := "\uFEFFname, age ,score,admin,note\n" +
+		"steve,45,9.5,true,\"quoted, with comma\"\n" +
+		"bob,-3,1e2,FALSE,\n" +
+		"\"multi\nline\",007,NaN,yes,Inf\n"
+
+	r := New(strings.NewReader(input))
+
+	header, err := r.Header()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if strings.Join(header, "|") != "name|age|score|admin|note" {
+		t.Fatalf("wrong header: %v", header)
+	}
+
+	expected := []string{
+		"map[admin:true age:45 name:steve note:quoted, with comma score:9.5]",
+		"map[admin:false age:-3 name:bob note: score:100]",
+		"map[admin:yes age:7 name:multi\nline note:Inf score:NaN]",
+	}
+	types := []string{
+		"bool int64 string string float64",
+		"bool int64 string string float64",
+		"string int64 string string string",
+	}
+
+	for i, exp := range expected {
+		row, err := r.Next()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if fmt.Sprintf("%v", row) != exp {
+			t.Fatalf("wrong row %d, got %v expected %s", i+1, row, exp)
+		}
+		got := fmt.Sprintf("%T %T %T %T %T", row["admin"], row["age"], row["name"], row["note"], row["score"])
+		if got != types[i] {
+			t.Fatalf("wrong types for row %d, got %s expected %s", i+1, got, types[i])
+		}
+		if r.Row() != i+1 {
+			t.Fatalf("wrong row number %d", r.Row())
+		}
+	}
+
+	_, err = r.Next()
+	if err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+
+	// Raw mode, with a different delimiter.
+	r = New(strings.NewReader("a;b\n1;true\n"))
+	r.Comma = ';'
+	r.Raw = true
+	row, err := r.Next()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if fmt.Sprintf("%T %T", row["a"], row["b"]) != "string string" {
+		t.Fatalf("raw mode converted values: %v", row)
+	}
+}
+
+// TestErrors tests that bad data is rejected.
+func TestErrors(t *testing.T) {
+
+	tests := []struct {
+		input string
+		error string
+	}{
+		{"", "there is no header row"},
+		{"a,,b\n", "column 2 of the header has no name"},
+		{"a,b,a\n", "the header contains the column a more than once"},
+		{"a,b\n1,2,3\n", "wrong number of fields"},
+		{"a,b\n\"1,2\n", "extraneous or missing \" in quoted-field"},
+	}
+
+	for _, test := range tests {
+		r := New(strings.NewReader(test.input))
+		_, err := r.Next()
+		if err == nil {
+			t.Fatalf("expected an error for %q, got none", test.input)
+		}
+		if !strings.Contains(err.Error(), test.error) {
+			t.Fatalf("wrong error for %q, got '%s' expected '%s'", test.input, err, test.error)
+		}
+	}
+}
+
+// TestFilter tests filtering rows with a script.
+func TestFilter(t *testing.T) {
+
+	input := `name,age,admin,email
+steve,45,true,steve@example.com
+bob,17,true,"bob, jr@example.com"
+alice,30,false,alice@example.org
+`
+
+	eval := evalfilter.New(`return age >= 18 || email ~= /,/;`)
+	err := eval.Prepare()
+	if err != nil {
+		t.Fatalf("failed to compile: %s", err)
+	}
+
+	var out bytes.Buffer
+	count, err := New(strings.NewReader(input)).Filter(eval, &out)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if count != 3 {
+		t.Fatalf("expected three matches, got %d", count)
+	}
+	if out.String() != input {
+		t.Fatalf("wrong output, got:\n%s", out.String())
+	}
+
+	// Errors identify the row, and earlier matches are output.
+	eval = evalfilter.New(`if ( name == "bob" ) { return 1 / 0; } return true;`)
+	eval.Prepare([]byte{evalfilter.NoOptimize})
+
+	out.Reset()
+	count, err = New(strings.NewReader(input)).Filter(eval, &out)
+	if err == nil || !strings.HasPrefix(err.Error(), "row 2: ") {
+		t.Fatalf("expected an error for row 2, got %v", err)
+	}
+	if count != 1 || out.String() != "name,age,admin,email\nsteve,45,true,steve@example.com\n" {
+		t.Fatalf("wrong output after error, %d rows:\n%s", count, out.String())
+	}
+}
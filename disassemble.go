@@ -0,0 +1,181 @@
+// This file contains the disassembly of a prepared script.
+//
+// `Dump` shows the bytecode of a script to a human, whilst `Disassemble`
+// returns the same information as values - which may be processed by
+// other tools, or written out as JSON.
+
+package evalfilter
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/skx/evalfilter/v2/code"
+	"github.com/skx/evalfilter/v2/object"
+)
+
+// Instruction describes a single instruction of a disassembled script.
+type Instruction struct {
+
+	// Offset holds the offset of the instruction, within the
+	// bytecode.
+	Offset int
+
+	// Opcode holds the name of the instruction, such as "OpLookup".
+	Opcode string
+
+	// Arg holds the argument of the instruction, if it has one.
+	Arg *int
+
+	// Comment describes the instruction, if it needs describing.
+	Comment string
+}
+
+// Constant describes a value in the constant-pool of a script.
+type Constant struct {
+
+	// Index holds the index of the constant.
+	Index int
+
+	// Type holds the type of the constant.
+	Type object.Type
+
+	// Value holds the value of the constant.
+	Value string
+}
+
+// FunctionCode describes a function, along with its bytecode.
+type FunctionCode struct {
+
+	// Name holds the name of the function, or is empty if the
+	// function is anonymous.
+	Name string
+
+	// Constant holds the index of an anonymous function within
+	// the constant-pool.
+	Constant int
+
+	// Arguments holds the names of the arguments of the function.
+	Arguments []string
+
+	// Bytecode holds the instructions of the function.
+	Bytecode []Instruction
+}
+
+// Disassembly describes the bytecode of a prepared script.
+type Disassembly struct {
+
+	// Bytecode holds the instructions of the main program.
+	Bytecode []Instruction
+
+	// Constants holds the constant-pool.
+	Constants []Constant
+
+	// Functions holds the functions the script defined, sorted by
+	// name, followed by its anonymous functions.
+	Functions []FunctionCode
+}
+
+// Disassemble returns the bytecode of the script, which must have been
+// prepared.
+func (e *Eval) Disassemble() (*Disassembly, error) {
+
+	if e.machine == nil {
+		return nil, fmt.Errorf("the script has not been prepared")
+	}
+
+	out := &Disassembly{}
+
+	// collect returns a visitor which appends to the given
+	// instructions.
+	collect := func(ins *[]Instruction) func(int, code.Opcode, interface{}) (bool, error) {
+		return func(offset int, op code.Opcode, arg interface{}) (bool, error) {
+			i := Instruction{Offset: offset, Opcode: code.String(op)}
+			if arg != nil {
+				val := arg.(int)
+				i.Arg = &val
+			}
+			i.Comment = e.comment(op, arg)
+			*ins = append(*ins, i)
+			return true, nil
+		}
+	}
+
+	err := e.machine.WalkBytecode(collect(&out.Bytecode))
+	if err != nil {
+		return nil, err
+	}
+
+	for i, c := range e.constants {
+		out.Constants = append(out.Constants, Constant{Index: i, Type: c.Type(), Value: c.Inspect()})
+	}
+
+	var names []string
+	for name := range e.functions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f := FunctionCode{Name: name, Arguments: e.functions[name].Arguments}
+		err = e.machine.WalkFunctionBytecode(name, collect(&f.Bytecode))
+		if err != nil {
+			return nil, err
+		}
+		out.Functions = append(out.Functions, f)
+	}
+
+	for i, c := range e.constants {
+		fn, ok := c.(*object.Function)
+		if !ok {
+			continue
+		}
+		f := FunctionCode{Constant: i, Arguments: fn.Arguments}
+		err = e.machine.WalkClosureBytecode(i, collect(&f.Bytecode))
+		if err != nil {
+			return nil, err
+		}
+		out.Functions = append(out.Functions, f)
+	}
+
+	return out, nil
+}
+
+// comment returns a description of the given instruction, if it benefits
+// from one.
+func (e *Eval) comment(op code.Opcode, arg interface{}) string {
+
+	// escape escapes the whitespace of a value.
+	escape := func(s string) string {
+		s = strings.ReplaceAll(s, "\n", "\\n")
+		s = strings.ReplaceAll(s, "\r", "\\r")
+		return strings.ReplaceAll(s, "\t", "\\t")
+	}
+
+	switch op {
+	case code.OpConstant:
+		return fmt.Sprintf("push constant onto stack: \"%s\"", escape(e.constants[arg.(int)].Inspect()))
+	case code.OpLookup:
+		return fmt.Sprintf("lookup field/variable: %s", escape(e.constants[arg.(int)].Inspect()))
+	case code.OpLookupField:
+		return fmt.Sprintf("lookup field of schema: %s", escape(e.constants[arg.(int)].Inspect()))
+	case code.OpLookupPath:
+		return fmt.Sprintf("lookup field path: %s", escape(e.constants[arg.(int)].Inspect()))
+	case code.OpCall:
+		return fmt.Sprintf("call function with %d arg(s)", arg.(int))
+	case code.OpClosure:
+		return fmt.Sprintf("create function from constant %d", arg.(int))
+	case code.OpPush:
+		return fmt.Sprintf("Push %d to stack", arg.(int))
+	case code.OpJumpTable:
+		if table, ok := e.constants[arg.(int)].(*object.Hash); ok {
+			return fmt.Sprintf("jump-table with %d entries", len(table.Pairs))
+		}
+	case code.OpSetIndex:
+		if code.Opcode(arg.(int)) == code.OpNop {
+			return "store member"
+		}
+		return fmt.Sprintf("update member via %s", code.String(code.Opcode(arg.(int))))
+	}
+	return ""
+}
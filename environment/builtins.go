@@ -30,13 +30,13 @@ func fnBetween(args []object.Object) object.Object {
 
 	// We expect three items "the value", and the lower/upper bounds.
 	if len(args) != 3 {
-		return &object.Null{}
+		return object.Nil
 	}
 
 	// All arguments must be numbers
 	for _, obj := range args {
 		if obj.Type() != object.FLOAT && obj.Type() != object.INTEGER {
-			return &object.Null{}
+			return object.Nil
 		}
 	}
 
@@ -50,7 +50,7 @@ func fnBetween(args []object.Object) object.Object {
 	if lower == val {
 
 		if val.Inspect() != min.Inspect() {
-			return &object.Boolean{Value: false}
+			return object.False
 		}
 	}
 
@@ -58,11 +58,81 @@ func fnBetween(args []object.Object) object.Object {
 	upper := fnMax([]object.Object{val, max})
 	if upper == val {
 		if val.Inspect() != max.Inspect() {
-			return &object.Boolean{Value: false}
+			return object.False
 		}
 	}
 
-	return &object.Boolean{Value: true}
+	return object.True
+}
+
+// fnContains is the implementation of our `contains` function.
+func fnContains(args []object.Object) object.Object {
+
+	// We expect two arguments, the haystack and the needle.
+	if len(args) != 2 {
+		return object.Nil
+	}
+
+	return Contains(args[0], args[1])
+}
+
+// Contains tests whether the haystack contains the needle, returning a
+// boolean, or null if the haystack cannot contain things.
+//
+// For strings this tests for a substring, for arrays this tests whether
+// the needle is a member, and for hashes this tests whether the needle
+// is a key.
+//
+// It is used by the `contains` function, and by the virtual machine
+// when the needle is a constant.
+func Contains(haystack object.Object, needle object.Object) object.Object {
+
+	switch h := haystack.(type) {
+
+	case *object.String:
+		str, ok := needle.(*object.String)
+		if !ok {
+			return object.Nil
+		}
+		return &object.Boolean{Value: strings.Contains(h.Value, str.Value)}
+
+	case *object.Array:
+		for _, entry := range h.Elements {
+			if equal(entry, needle) {
+				return object.True
+			}
+		}
+		return object.False
+
+	case *object.Hash:
+		key, ok := needle.(object.Hashable)
+		if !ok {
+			return object.False
+		}
+		_, ok = h.Pairs[key.HashKey()]
+		return object.Bool(ok)
+	}
+
+	return object.Nil
+}
+
+// equal tests whether two objects are equal, in the same way that the
+// `in` operator does.
+//
+// Strings and integers are compared directly, as they are the most
+// common needles, rather than via the description of their values.
+func equal(a object.Object, b object.Object) bool {
+
+	switch x := a.(type) {
+	case *object.String:
+		y, ok := b.(*object.String)
+		return ok && x.Value == y.Value
+	case *object.Integer:
+		y, ok := b.(*object.Integer)
+		return ok && x.Value == y.Value
+	}
+
+	return a.Type() == b.Type() && a.Inspect() == b.Inspect()
 }
 
 // fnFloat is the implementation of the `float` function.
@@ -74,7 +144,12 @@ func fnFloat(args []object.Object) object.Object {
 
 	// We expect one argument
 	if len(args) != 1 {
-		return &object.Null{}
+		return object.Nil
+	}
+
+	// Times are converted to seconds past the epoch.
+	if ts, ok := args[0].(*object.Time); ok {
+		return &object.Float{Value: float64(ts.Value.UnixNano()) / float64(time.Second)}
 	}
 
 	// Stringify
@@ -82,7 +157,7 @@ func fnFloat(args []object.Object) object.Object {
 
 	i, err := strconv.ParseFloat(str, 64)
 	if err != nil {
-		return &object.Null{}
+		return object.Nil
 	}
 
 	return &object.Float{Value: i}
@@ -93,7 +168,7 @@ func fnGetenv(args []object.Object) object.Object {
 
 	// We expect one argument
 	if len(args) != 1 {
-		return &object.Null{}
+		return object.Nil
 	}
 
 	// Stringify
@@ -112,7 +187,12 @@ func fnInt(args []object.Object) object.Object {
 
 	// We expect one argument
 	if len(args) != 1 {
-		return &object.Null{}
+		return object.Nil
+	}
+
+	// Times are converted to seconds past the epoch.
+	if ts, ok := args[0].(*object.Time); ok {
+		return object.Int(ts.Value.Unix())
 	}
 
 	// Stringify
@@ -120,10 +200,10 @@ func fnInt(args []object.Object) object.Object {
 
 	i, err := strconv.ParseInt(str, 10, 64)
 	if err != nil {
-		return &object.Null{}
+		return object.Nil
 	}
 
-	return &object.Integer{Value: i}
+	return object.Int(i)
 }
 
 
@@ -132,15 +212,15 @@ func fnJoin(args []object.Object) object.Object {
 
 	// We expect two arguments
 	if len(args) != 2 {
-		return &object.Null{}
+		return object.Nil
 	}
 
 	// The first argument must be an array
 	if args[0].Type() != object.ARRAY {
-		return &object.Null{}
+		return object.Nil
 	}
 	if args[1].Type() != object.STRING {
-		return &object.Null{}
+		return object.Nil
 	}
 
 	// Do the join
@@ -162,12 +242,12 @@ func fnKeys(args []object.Object) object.Object {
 
 	// We expect a single argument
 	if len(args) != 1 {
-		return &object.Null{}
+		return object.Nil
 	}
 
 	// The argument must be a hash
 	if args[0].Type() != object.HASH {
-		return &object.Null{}
+		return object.Nil
 	}
 
 	// The object we're working with
@@ -186,6 +266,62 @@ func fnKeys(args []object.Object) object.Object {
 	return &object.Array{Elements: array}
 }
 
+// fnLazySplit is the implementation of our `lazysplit` primitive.
+//
+// This behaves like `split`, however rather than returning an array it
+// returns an iterator which produces each piece on-demand.  That means
+// huge strings may be processed via `foreach` without building an array
+// holding all the pieces at once.
+func fnLazySplit(args []object.Object) object.Object {
+
+	// We expect two arguments
+	if len(args) != 2 {
+		return object.Nil
+	}
+
+	// Typecheck
+	if args[0].Type() != object.STRING ||
+		args[1].Type() != object.STRING {
+		return object.Nil
+	}
+
+	input := args[0].(*object.String).Value
+	split := args[1].(*object.String).Value
+	done := false
+
+	return object.NewIterator(func() (object.Object, bool) {
+
+		if done {
+			return nil, false
+		}
+
+		// An empty separator splits after each character,
+		// as `strings.Split` would.
+		if split == "" {
+			if input == "" {
+				done = true
+				return nil, false
+			}
+			_, size := utf8.DecodeRuneInString(input)
+			piece := input[:size]
+			input = input[size:]
+			return &object.String{Value: piece}, true
+		}
+
+		// Find the next separator, if there isn't one
+		// then the remainder is our final piece.
+		i := strings.Index(input, split)
+		if i < 0 {
+			done = true
+			return &object.String{Value: input}, true
+		}
+
+		piece := input[:i]
+		input = input[i+len(split):]
+		return &object.String{Value: piece}, true
+	})
+}
+
 // fnLen is the implementation of our `len` function.
 //
 // Interestingly this function doesn't just count the length of string
@@ -199,19 +335,26 @@ func fnKeys(args []object.Object) object.Object {
 // So `len(false)` is 5, len(3) is 1, and `len(0.123)` is 5, and arrays
 // work as expected: len([]) is zero, and len(["steve", "kemp"]) is two.
 //
+// Iterators produce their contents lazily, so we cannot know their length
+// without consuming them.  For those we return null.
+//
 func fnLen(args []object.Object) object.Object {
 
 	// We expect one argument
 	if len(args) != 1 {
-		return &object.Null{}
+		return object.Nil
 	}
 
 	// array is handled differently
 	switch arg := args[0].(type) {
 	case *object.Array:
-		return &object.Integer{Value: int64(len(arg.Elements))}
+		return object.Int(int64(len(arg.Elements)))
 	case *object.Hash:
-		return &object.Integer{Value: int64(len(arg.Pairs))}
+		return object.Int(int64(len(arg.Pairs)))
+	case *object.Bytes:
+		return object.Int(int64(len(arg.Value)))
+	case *object.Iterator:
+		return object.Nil
 	}
 
 	// Stringify
@@ -219,7 +362,7 @@ func fnLen(args []object.Object) object.Object {
 	sum := utf8.RuneCountInString(str)
 
 	// return
-	return &object.Integer{Value: int64(sum)}
+	return object.Int(int64(sum))
 }
 
 // fnLower is the implementation of our `lower` function.
@@ -230,7 +373,7 @@ func fnLower(args []object.Object) object.Object {
 
 	// We expect one argument
 	if len(args) != 1 {
-		return &object.Null{}
+		return object.Nil
 	}
 
 	// Stringify and lower-case
@@ -246,7 +389,7 @@ func fnMatch(args []object.Object) object.Object {
 
 	// We expect two arguments
 	if len(args) != 2 {
-		return &object.Boolean{Value: false}
+		return object.False
 	}
 
 	str := args[0].Inspect()
@@ -263,7 +406,7 @@ func fnMatch(args []object.Object) object.Object {
 		// Ensure it compiled
 		if err != nil {
 			fmt.Printf("Invalid regular expression %s %s", reg, err.Error())
-			return &object.Boolean{Value: false}
+			return object.False
 		}
 
 		// store in the cache for next time
@@ -278,58 +421,10 @@ func fnMatch(args []object.Object) object.Object {
 
 		// Test if it matched
 		if r.MatchString(s) {
-			return &object.Boolean{Value: true}
+			return object.True
 		}
 	}
-	return &object.Boolean{Value: false}
-}
-
-// fnMax is the implementation of our `max` function.
-func fnMax(args []object.Object) object.Object {
-
-	// We expect two arguments
-	if len(args) != 2 {
-		return &object.Null{}
-	}
-
-	// Create an array.  Yeah.
-	elements := make([]object.Object, 2)
-	elements[0] = args[0]
-	elements[1] = args[1]
-
-	// Construct an actual array.
-	arr := &object.Array{Elements: elements}
-
-	// sort it
-	out := fnSort([]object.Object{arr})
-
-	// max
-	return (out.(*object.Array).Elements[1])
-
-}
-
-// fnMin is the implementation of our `min` function.
-func fnMin(args []object.Object) object.Object {
-
-	// We expect two arguments
-	if len(args) != 2 {
-		return &object.Null{}
-	}
-
-	// Create an array.  Yeah.
-	elements := make([]object.Object, 2)
-	elements[0] = args[0]
-	elements[1] = args[1]
-
-	// Construct an actual array.
-	arr := &object.Array{Elements: elements}
-
-	// sort it
-	out := fnSort([]object.Object{arr})
-
-	// max
-	return (out.(*object.Array).Elements[0])
-
+	return object.False
 }
 
 // fnNow is the implementation of our `now` function.
@@ -350,7 +445,7 @@ func fnNow(args []object.Object) object.Object {
 		now = now.In(loc)
 	}
 
-	return &object.Integer{Value: now.Unix()}
+	return &object.Time{Value: now}
 }
 
 // fnSplit is the implementation of our `split` primitive.
@@ -358,7 +453,7 @@ func fnSplit(args []object.Object) object.Object {
 
 	// We expect two arguments
 	if len(args) != 2 {
-		return &object.Null{}
+		return object.Nil
 	}
 
 	// String to split
@@ -370,7 +465,7 @@ func fnSplit(args []object.Object) object.Object {
 	// Typecheck
 	if input.Type() != object.STRING ||
 		split.Type() != object.STRING {
-		return &object.Null{}
+		return object.Nil
 	}
 
 	// Perform the split
@@ -392,7 +487,7 @@ func fnString(args []object.Object) object.Object {
 
 	// We expect one argument
 	if len(args) != 1 {
-		return &object.Null{}
+		return object.Nil
 	}
 
 	str := args[0].Inspect()
@@ -404,7 +499,7 @@ func fnTrim(args []object.Object) object.Object {
 
 	// We expect one argument
 	if len(args) != 1 {
-		return &object.Null{}
+		return object.Nil
 	}
 
 	arg := args[0]
@@ -418,7 +513,7 @@ func fnType(args []object.Object) object.Object {
 
 	// We expect one argument
 	if len(args) != 1 {
-		return &object.Null{}
+		return object.Nil
 	}
 
 	// Get the arg
@@ -473,12 +568,12 @@ func fnSort(args []object.Object) object.Object {
 	// We expect either one or two arguments
 	//    sort([array], bool)
 	if len(args) != 1 && len(args) != 2 {
-		return &object.Null{}
+		return object.Nil
 	}
 
 	// Type-check the first argument
 	if args[0].Type() != object.ARRAY {
-		return &object.Null{}
+		return object.Nil
 	}
 
 	// Default to not lower-casing items
@@ -489,7 +584,7 @@ func fnSort(args []object.Object) object.Object {
 
 		// Type-check second argument
 		if args[1].Type() != object.BOOLEAN {
-			return &object.Null{}
+			return object.Nil
 		}
 
 		// Copy value.
@@ -505,7 +600,7 @@ func fnReplace(args []object.Object) object.Object {
 
 	// We expect two arguments
 	if len(args) != 3 {
-		return &object.Null{}
+		return object.Nil
 	}
 
 	str := args[0].Inspect()
@@ -524,7 +619,7 @@ func fnReplace(args []object.Object) object.Object {
 		// Ensure it compiled
 		if err != nil {
 			fmt.Printf("Invalid regular expression %s %s", reg, err.Error())
-			return &object.Boolean{Value: false}
+			return object.False
 		}
 
 		// store in the cache for next time
@@ -541,12 +636,12 @@ func fnReverse(args []object.Object) object.Object {
 	// We expect either one or two arguments
 	//    reverse([array], bool)
 	if len(args) != 1 && len(args) != 2 {
-		return &object.Null{}
+		return object.Nil
 	}
 
 	// Type-check the first argument
 	if args[0].Type() != object.ARRAY {
-		return &object.Null{}
+		return object.Nil
 	}
 
 	// Default to not lower-casing items
@@ -557,7 +652,7 @@ func fnReverse(args []object.Object) object.Object {
 
 		// Type-check second argument
 		if args[1].Type() != object.BOOLEAN {
-			return &object.Null{}
+			return object.Nil
 		}
 
 		// Copy value.
@@ -641,12 +736,12 @@ func fnSprintf(args []object.Object) object.Object {
 
 	// We expect 1+ arguments
 	if len(args) < 1 {
-		return &object.Null{}
+		return object.Nil
 	}
 
 	// Type-check
 	if args[0].Type() != object.STRING {
-		return &object.Null{}
+		return object.Nil
 	}
 
 	// Get the format-string.
@@ -676,7 +771,7 @@ func fnSprintf(args []object.Object) object.Object {
 func fnUpper(args []object.Object) object.Object {
 	// We expect one argument
 	if len(args) != 1 {
-		return &object.Null{}
+		return object.Nil
 	}
 
 	// Stringify and upper-case
@@ -689,32 +784,16 @@ func fnUpper(args []object.Object) object.Object {
 
 // getTimeField handles returning a time-related field from an object
 // which is assumed to contain a time in the Unix Epoch format.
+//
+// The fields are those seen in the timezone specified by $TZ, which
+// defaults to UTC, unless a timezone is given as the second argument, or
+// the time was returned by `in_tz`.
 func getTimeField(args []object.Object, val string) object.Object {
 
-	// We expect one argument
-	if len(args) != 1 {
-		return &object.Null{}
-	}
-
-	// It must be an integer
-	if args[0].Type() != object.INTEGER {
-		return &object.Null{}
-	}
-
-	// Convert that to a time
-	ts := time.Unix(args[0].(*object.Integer).Value, 0)
-
-	// Handle timezones, by reading $TZ, and if not set
-	// defaulting to UTC.
-	env := os.Getenv("TZ")
-	if env == "" {
-		env = "UTC"
-	}
-
-	// Ensure we set that timezone.
-	loc, err := time.LoadLocation(env)
-	if err == nil {
-		ts = ts.In(loc)
+	// We expect a time, and an optional timezone.
+	ts, ok := timeArgs(args, 1)
+	if !ok {
+		return object.Nil
 	}
 
 	// Now get the fields
@@ -724,23 +803,23 @@ func getTimeField(args []object.Object, val string) object.Object {
 	// And return the one we should
 	switch val {
 	case "hour":
-		return &object.Integer{Value: int64(hr)}
+		return object.Int(int64(hr))
 	case "minute":
-		return &object.Integer{Value: int64(min)}
+		return object.Int(int64(min))
 	case "seconds":
-		return &object.Integer{Value: int64(sec)}
+		return object.Int(int64(sec))
 	case "day":
-		return &object.Integer{Value: int64(day)}
+		return object.Int(int64(day))
 	case "month":
-		return &object.Integer{Value: int64(month)}
+		return object.Int(int64(month))
 	case "year":
-		return &object.Integer{Value: int64(year)}
+		return object.Int(int64(year))
 	case "weekday":
 		return &object.String{Value: ts.Weekday().String()}
 	}
 
 	// Unknown field: can't happen?
-	return &object.Null{}
+	return object.Nil
 }
 
 // fnHour returns the hour of the given time-object.
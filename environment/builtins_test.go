@@ -1,7 +1,9 @@
 package environment
 
 import (
+	"math"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -145,6 +147,57 @@ func TestBetween(t *testing.T) {
 	}
 }
 
+// Test our contains function, for each kind of haystack.
+func TestContains(t *testing.T) {
+
+	str := func(s string) object.Object { return &object.String{Value: s} }
+	num := func(i int64) object.Object { return &object.Integer{Value: i} }
+
+	arr := &object.Array{Elements: []object.Object{str("Steve"), num(3), &object.Float{Value: 1.5}}}
+
+	hash := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
+	hash.Pairs[str("Name").(object.Hashable).HashKey()] = object.HashPair{Key: str("Name"), Value: str("Steve")}
+	hash.Pairs[num(7).(object.Hashable).HashKey()] = object.HashPair{Key: num(7), Value: str("Seven")}
+
+	type TestCase struct {
+		Args   []object.Object
+		Result string
+	}
+
+	tests := []TestCase{
+
+		// strings
+		{Args: []object.Object{str("Steve"), str("ev")}, Result: "true"},
+		{Args: []object.Object{str("Steve"), str("x")}, Result: "false"},
+		{Args: []object.Object{str("Steve"), num(1)}, Result: "null"},
+
+		// arrays
+		{Args: []object.Object{arr, str("Steve")}, Result: "true"},
+		{Args: []object.Object{arr, str("steve")}, Result: "false"},
+		{Args: []object.Object{arr, num(3)}, Result: "true"},
+		{Args: []object.Object{arr, str("3")}, Result: "false"},
+		{Args: []object.Object{arr, &object.Float{Value: 1.5}}, Result: "true"},
+
+		// hashes
+		{Args: []object.Object{hash, str("Name")}, Result: "true"},
+		{Args: []object.Object{hash, str("Steve")}, Result: "false"},
+		{Args: []object.Object{hash, num(7)}, Result: "true"},
+		{Args: []object.Object{hash, arr}, Result: "false"},
+
+		// other things
+		{Args: []object.Object{num(3), num(3)}, Result: "null"},
+		{Args: []object.Object{&object.Null{}, str("x")}, Result: "null"},
+		{Args: []object.Object{str("Steve")}, Result: "null"},
+	}
+
+	for _, test := range tests {
+		out := fnContains(test.Args)
+		if out.Inspect() != test.Result {
+			t.Errorf("wrong result for contains(%v), got %s expected %s", test.Args, out.Inspect(), test.Result)
+		}
+	}
+}
+
 // Test float-conversion.
 func TestFloat(t *testing.T) {
 
@@ -383,6 +436,65 @@ func TestSplit(t *testing.T) {
 	}
 }
 
+// Test lazy-splitting.
+func TestLazySplit(t *testing.T) {
+
+	// Two string arguments are required
+	bogus := [][]object.Object{
+		{},
+		{&object.String{Value: "a"}},
+		{&object.String{Value: "a"}, &object.Null{}},
+	}
+	for _, args := range bogus {
+		out := fnLazySplit(args)
+		if out.Type() != object.NULL {
+			t.Errorf("bogus arguments returned a weird result")
+		}
+	}
+
+	type TestCase struct {
+		Input  string
+		Split  string
+		Result []string
+	}
+
+	tests := []TestCase{
+		{Input: "Steve\nKemp", Split: "\n", Result: []string{"Steve", "Kemp"}},
+		{Input: "a,,b,", Split: ",", Result: []string{"a", "", "b", ""}},
+		{Input: "", Split: ",", Result: []string{""}},
+		{Input: "狐犬", Split: "", Result: []string{"狐", "犬"}},
+		{Input: "", Split: "", Result: []string{}},
+	}
+
+	for _, test := range tests {
+
+		out := fnLazySplit([]object.Object{&object.String{Value: test.Input}, &object.String{Value: test.Split}})
+		if out.Type() != object.ITERATOR {
+			t.Fatalf("didn't get an iterator back, got %s", out.Type())
+		}
+
+		res := []string{}
+		iter := out.(*object.Iterator)
+		for {
+			piece, _, more := iter.Next()
+			if !more {
+				break
+			}
+			res = append(res, piece.Inspect())
+		}
+
+		if strings.Join(res, "|") != strings.Join(test.Result, "|") || len(res) != len(test.Result) {
+			t.Fatalf("unexpected result splitting %q: %q", test.Input, res)
+		}
+	}
+
+	// The length of an iterator is unknown.
+	out := fnLen([]object.Object{fnLazySplit([]object.Object{&object.String{Value: "a b"}, &object.String{Value: " "}})})
+	if out.Type() != object.NULL {
+		t.Fatalf("expected null length for an iterator")
+	}
+}
+
 // Test string-conversion.
 func TestString(t *testing.T) {
 
@@ -900,12 +1012,12 @@ func TestNow(t *testing.T) {
 	out := fnNow(empty)
 
 	// type-check
-	if out.Type() != object.INTEGER {
-		t.Errorf("output of `now` was not an integer")
+	if out.Type() != object.TIME {
+		t.Fatalf("output of `now` was not a time")
 	}
 
 	// get the value
-	val := out.(*object.Integer).Value
+	val := out.(*object.Time).Value.Unix()
 
 	// diff
 	diff := val - now.Unix()
@@ -1301,3 +1413,206 @@ func TestJoin(t *testing.T) {
 		t.Errorf("wrong result for join: %s != Steve-Kemp", out.Inspect())
 	}
 }
+
+// TestTimeZones tests retrieving the fields of times in other timezones.
+func TestTimeZones(t *testing.T) {
+
+	str := func(s string) object.Object { return &object.String{Value: s} }
+
+	// 2023-11-14 22:13:20 UTC, a Tuesday.
+	ts := &object.Integer{Value: 1700000000}
+
+	if fnHour([]object.Object{ts, str("Europe/Berlin")}).Inspect() != "23" {
+		t.Errorf("wrong hour in Berlin")
+	}
+	if fnWeekday([]object.Object{ts, str("Asia/Tokyo")}).Inspect() != "Wednesday" {
+		t.Errorf("wrong weekday in Tokyo")
+	}
+	if fnDay([]object.Object{ts, str("Asia/Tokyo")}).Inspect() != "15" {
+		t.Errorf("wrong day in Tokyo")
+	}
+
+	// Unknown timezones are an error.
+	if fnHour([]object.Object{ts, str("Moon/Base")}).Type() != object.NULL {
+		t.Errorf("unexpected result for unknown timezone")
+	}
+	if fnHour([]object.Object{ts, &object.Integer{Value: 3}}).Type() != object.NULL {
+		t.Errorf("unexpected result for bogus timezone")
+	}
+
+	// The result of in_tz may be used in place of a time.
+	zoned := fnInTZ([]object.Object{ts, str("America/New_York")})
+	hash, ok := zoned.(*object.Hash)
+	if !ok {
+		t.Fatalf("in_tz didn't return a hash: %s", zoned.Inspect())
+	}
+	exp := `{abbreviation: EST, day: 14, hour: 17, minute: 13, month: 11, offset: -18000, seconds: 20, time: 1700000000, weekday: Tuesday, year: 2023, zone: America/New_York}`
+	if hash.Inspect() != exp {
+		t.Errorf("unexpected result from in_tz: %s", hash.Inspect())
+	}
+	if fnHour([]object.Object{hash}).Inspect() != "17" {
+		t.Errorf("wrong hour from in_tz")
+	}
+	if fnHourOf([]object.Object{hash, str("UTC")}).Inspect() != "22" {
+		t.Errorf("wrong hour converting in_tz result")
+	}
+	if fnFormatTime([]object.Object{hash, str("15:04 MST")}).Inspect() != "17:13 EST" {
+		t.Errorf("wrong result formatting in_tz result")
+	}
+	if fnFormatTime([]object.Object{ts, str("2006-01-02 15:04"), str("Asia/Tokyo")}).Inspect() != "2023-11-15 07:13" {
+		t.Errorf("wrong result formatting in timezone")
+	}
+
+	if fnInTZ([]object.Object{ts, str("Moon/Base")}).Type() != object.NULL {
+		t.Errorf("unexpected result for unknown timezone")
+	}
+	if fnInTZ([]object.Object{str("now"), str("UTC")}).Type() != object.NULL {
+		t.Errorf("unexpected result for bogus time")
+	}
+}
+
+func TestTimeFunctions(t *testing.T) {
+
+	str := func(s string) object.Object { return &object.String{Value: s} }
+	num := func(i int64) object.Object { return &object.Integer{Value: i} }
+
+	// 2023-11-14 22:13:20.5 UTC
+	ts := &object.Time{Value: time.Unix(1700000000, 500000000).UTC()}
+
+	type TestCase struct {
+		Fn     func([]object.Object) object.Object
+		Args   []object.Object
+		Result string
+	}
+
+	tests := []TestCase{
+		{Fn: fnFormatTime, Args: []object.Object{num(0)}, Result: "1970-01-01T00:00:00Z"},
+		{Fn: fnFormatTime, Args: []object.Object{num(0), str("2006")}, Result: "1970"},
+		{Fn: fnFormatTime, Args: []object.Object{ts, str("2006-01-02 15:04:05.000")}, Result: "2023-11-14 22:13:20.500"},
+		{Fn: fnFormatTime, Args: []object.Object{ts, str("RFC1123")}, Result: "Tue, 14 Nov 2023 22:13:20 UTC"},
+		{Fn: fnFormatTime, Args: []object.Object{ts, str("Mon")}, Result: "Tue"},
+		{Fn: fnFormatTime, Args: []object.Object{ts, str("today")}, Result: `the layout "today" contains no elements of a time`},
+		{Fn: fnFormatTime, Args: []object.Object{ts, num(3)}, Result: "null"},
+		{Fn: fnFormatTime, Args: []object.Object{str("0")}, Result: "null"},
+		{Fn: fnParseTime, Args: []object.Object{str("1970-01-01T00:01:00Z")}, Result: "1970-01-01T00:01:00Z"},
+		{Fn: fnParseTime, Args: []object.Object{str("2006-01-02 15:04"), str("2023-11-14 22:13")}, Result: "2023-11-14T22:13:00Z"},
+		{Fn: fnParseTime, Args: []object.Object{str("UnixDate"), str("Thu Jan  1 00:00:10 UTC 1970")}, Result: "1970-01-01T00:00:10Z"},
+		{Fn: fnParseTime, Args: []object.Object{str("Thu Jan  1 00:00:10 UTC 1970"), str("UnixDate")}, Result: "1970-01-01T00:00:10Z"},
+		{Fn: fnParseTime, Args: []object.Object{str("yesterday")}, Result: `the time "yesterday" doesn't match the layout "2006-01-02T15:04:05Z07:00"`},
+		{Fn: fnParseTime, Args: []object.Object{str("2006-01-02"), str("14/11/2023")}, Result: `the time "14/11/2023" doesn't match the layout "2006-01-02"`},
+		{Fn: fnParseTime, Args: []object.Object{str("today"), str("today")}, Result: `the layout "today" contains no elements of a time`},
+		{Fn: fnParseTime, Args: []object.Object{num(1), str("today")}, Result: "null"},
+		{Fn: fnParseTime, Args: []object.Object{}, Result: "null"},
+		{Fn: fnUnix, Args: []object.Object{ts}, Result: "1700000000"},
+		{Fn: fnUnix, Args: []object.Object{num(17)}, Result: "17"},
+		{Fn: fnUnix, Args: []object.Object{str("now")}, Result: "null"},
+		{Fn: fnUnix, Args: []object.Object{}, Result: "null"},
+		{Fn: fnInt, Args: []object.Object{ts}, Result: "1700000000"},
+		{Fn: fnFloat, Args: []object.Object{ts}, Result: "1700000000.5"},
+	}
+
+	// Ensure the tests of time-formatting are stable.
+	old := os.Getenv("TZ")
+	os.Setenv("TZ", "UTC")
+	defer os.Setenv("TZ", old)
+
+	for i, test := range tests {
+		out := test.Fn(test.Args)
+		if out.Inspect() != test.Result {
+			t.Errorf("test %d: expected %s, got %s", i, test.Result, out.Inspect())
+		}
+	}
+
+	// Errors are error objects, rather than panics.
+	if fnParseTime([]object.Object{str("x")}).Type() != object.ERROR {
+		t.Errorf("expected an error object")
+	}
+	if fnParseTime([]object.Object{str("2023-11-14T22:13:20Z")}).Type() != object.TIME {
+		t.Errorf("expected a time")
+	}
+}
+
+func TestMath(t *testing.T) {
+
+	num := func(i int64) object.Object { return &object.Integer{Value: i} }
+	flt := func(f float64) object.Object { return &object.Float{Value: f} }
+	str := func(s string) object.Object { return &object.String{Value: s} }
+	arr := func(elements ...object.Object) object.Object { return &object.Array{Elements: elements} }
+
+	type TestCase struct {
+		Fn     func([]object.Object) object.Object
+		Args   []object.Object
+		Type   object.Type
+		Result string
+	}
+
+	tests := []TestCase{
+		{Fn: fnAbs, Args: []object.Object{num(-3)}, Type: object.INTEGER, Result: "3"},
+		{Fn: fnAbs, Args: []object.Object{num(3)}, Type: object.INTEGER, Result: "3"},
+		{Fn: fnAbs, Args: []object.Object{flt(-2.5)}, Type: object.FLOAT, Result: "2.5"},
+		{Fn: fnAbs, Args: []object.Object{num(math.MinInt64)}, Type: object.ERROR, Result: "the absolute value of -9223372036854775808 overflows an integer"},
+		{Fn: fnAbs, Args: []object.Object{str("-3")}, Type: object.NULL, Result: "null"},
+		{Fn: fnAbs, Args: []object.Object{}, Type: object.NULL, Result: "null"},
+
+		{Fn: fnFloor, Args: []object.Object{flt(2.7)}, Type: object.INTEGER, Result: "2"},
+		{Fn: fnFloor, Args: []object.Object{flt(-2.2)}, Type: object.INTEGER, Result: "-3"},
+		{Fn: fnFloor, Args: []object.Object{num(7)}, Type: object.INTEGER, Result: "7"},
+		{Fn: fnFloor, Args: []object.Object{flt(1e300)}, Type: object.ERROR, Result: "1" + strings.Repeat("0", 300) + " can't be represented as an integer"},
+		{Fn: fnCeil, Args: []object.Object{flt(2.1)}, Type: object.INTEGER, Result: "3"},
+		{Fn: fnCeil, Args: []object.Object{flt(-2.7)}, Type: object.INTEGER, Result: "-2"},
+		{Fn: fnCeil, Args: []object.Object{flt(math.NaN())}, Type: object.ERROR, Result: "NaN can't be represented as an integer"},
+		{Fn: fnCeil, Args: []object.Object{str("2")}, Type: object.NULL, Result: "null"},
+
+		{Fn: fnRound, Args: []object.Object{flt(2.5)}, Type: object.INTEGER, Result: "3"},
+		{Fn: fnRound, Args: []object.Object{flt(-2.5)}, Type: object.INTEGER, Result: "-3"},
+		{Fn: fnRound, Args: []object.Object{flt(2.49)}, Type: object.INTEGER, Result: "2"},
+		{Fn: fnRound, Args: []object.Object{num(9)}, Type: object.INTEGER, Result: "9"},
+		{Fn: fnRound, Args: []object.Object{flt(3.14159), num(2)}, Type: object.FLOAT, Result: "3.14"},
+		{Fn: fnRound, Args: []object.Object{flt(1234.5), num(-2)}, Type: object.FLOAT, Result: "1200"},
+		{Fn: fnRound, Args: []object.Object{num(1250), num(-2)}, Type: object.INTEGER, Result: "1300"},
+		{Fn: fnRound, Args: []object.Object{num(1250), num(2)}, Type: object.INTEGER, Result: "1250"},
+		{Fn: fnRound, Args: []object.Object{flt(1e300), num(18)}, Type: object.FLOAT, Result: "1" + strings.Repeat("0", 300)},
+		{Fn: fnRound, Args: []object.Object{flt(1.5), num(19)}, Type: object.NULL, Result: "null"},
+		{Fn: fnRound, Args: []object.Object{flt(1.5), flt(1)}, Type: object.NULL, Result: "null"},
+
+		{Fn: fnPow, Args: []object.Object{num(2), num(10)}, Type: object.INTEGER, Result: "1024"},
+		{Fn: fnPow, Args: []object.Object{num(-3), num(3)}, Type: object.INTEGER, Result: "-27"},
+		{Fn: fnPow, Args: []object.Object{num(3), num(0)}, Type: object.INTEGER, Result: "1"},
+		{Fn: fnPow, Args: []object.Object{num(2), num(62)}, Type: object.INTEGER, Result: "4611686018427387904"},
+		{Fn: fnPow, Args: []object.Object{num(-2), num(63)}, Type: object.INTEGER, Result: "-9223372036854775808"},
+		{Fn: fnPow, Args: []object.Object{num(2), num(63)}, Type: object.ERROR, Result: "2 ** 63 overflows an integer"},
+		{Fn: fnPow, Args: []object.Object{num(10), num(100)}, Type: object.ERROR, Result: "10 ** 100 overflows an integer"},
+		{Fn: fnPow, Args: []object.Object{num(2), num(-1)}, Type: object.FLOAT, Result: "0.5"},
+		{Fn: fnPow, Args: []object.Object{flt(2.5), num(2)}, Type: object.FLOAT, Result: "6.25"},
+		{Fn: fnPow, Args: []object.Object{num(9), flt(0.5)}, Type: object.FLOAT, Result: "3"},
+		{Fn: fnPow, Args: []object.Object{num(-8), flt(0.5)}, Type: object.ERROR, Result: "-8 ** 0.5 isn't a real number"},
+		{Fn: fnPow, Args: []object.Object{num(0), num(-1)}, Type: object.ERROR, Result: "0 ** -1 is infinite"},
+		{Fn: fnPow, Args: []object.Object{num(2), str("2")}, Type: object.NULL, Result: "null"},
+
+		{Fn: fnSqrt, Args: []object.Object{num(16)}, Type: object.FLOAT, Result: "4"},
+		{Fn: fnSqrt, Args: []object.Object{flt(2.25)}, Type: object.FLOAT, Result: "1.5"},
+		{Fn: fnSqrt, Args: []object.Object{num(0)}, Type: object.FLOAT, Result: "0"},
+		{Fn: fnSqrt, Args: []object.Object{num(-4)}, Type: object.ERROR, Result: "the square root of -4 isn't a real number"},
+		{Fn: fnSqrt, Args: []object.Object{flt(-0.5)}, Type: object.ERROR, Result: "the square root of -0.5 isn't a real number"},
+		{Fn: fnSqrt, Args: []object.Object{str("4")}, Type: object.NULL, Result: "null"},
+
+		{Fn: fnMin, Args: []object.Object{num(3), flt(2.5), num(7)}, Type: object.FLOAT, Result: "2.5"},
+		{Fn: fnMax, Args: []object.Object{num(3), flt(2.5), num(7)}, Type: object.INTEGER, Result: "7"},
+		{Fn: fnMin, Args: []object.Object{num(2), flt(2)}, Type: object.INTEGER, Result: "2"},
+		{Fn: fnMax, Args: []object.Object{flt(2), num(2)}, Type: object.FLOAT, Result: "2"},
+		{Fn: fnMin, Args: []object.Object{num(9223372036854775807), num(9223372036854775806)}, Type: object.INTEGER, Result: "9223372036854775806"},
+		{Fn: fnMax, Args: []object.Object{arr(num(1), num(5), flt(-3))}, Type: object.INTEGER, Result: "5"},
+		{Fn: fnMin, Args: []object.Object{arr(num(1), num(5), flt(-3))}, Type: object.FLOAT, Result: "-3"},
+		{Fn: fnMin, Args: []object.Object{num(4)}, Type: object.INTEGER, Result: "4"},
+		{Fn: fnMin, Args: []object.Object{arr()}, Type: object.NULL, Result: "null"},
+		{Fn: fnMax, Args: []object.Object{str("apple"), str("pear"), str("fig")}, Type: object.STRING, Result: "pear"},
+		{Fn: fnMin, Args: []object.Object{str("apple"), str("pear"), str("fig")}, Type: object.STRING, Result: "apple"},
+	}
+
+	for i, test := range tests {
+		out := test.Fn(test.Args)
+		if out.Type() != test.Type || out.Inspect() != test.Result {
+			t.Errorf("test %d: expected %s %s, got %s %s", i, test.Type, test.Result, out.Type(), out.Inspect())
+		}
+	}
+}
@@ -0,0 +1,263 @@
+// cron.go contains the parsing of cron-expressions, such as
+// "0 9-17 * * MON-FRI", which allows scripts to test whether a time
+// falls within a schedule.
+//
+// The expressions have the usual five fields: the minute, hour, day of
+// the month, month, and day of the week.  Each field may contain a list
+// of values, ranges, and steps, such as "1,15", "9-17", or "*/15", and
+// months and days may be named.  As with cron itself, if both the day of
+// the month and the day of the week are restricted a time matches if it
+// matches either of them.
+
+package environment
+
+import (
+	"container/list"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/skx/evalfilter/v2/object"
+)
+
+// cronSchedule holds a parsed cron-expression, as a set of bits for
+// each field.
+type cronSchedule struct {
+	minute uint64
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+
+	// domStar and dowStar record whether those fields were "*",
+	// which affects how they're combined.
+	domStar bool
+	dowStar bool
+}
+
+// cronField describes the values one field of an expression may hold.
+type cronField struct {
+	name  string
+	min   int
+	max   int
+	names []string
+}
+
+// cronFields describes the fields of an expression, in order.
+var cronFields = []cronField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of the month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, names: []string{"", "JAN", "FEB", "MAR", "APR", "MAY", "JUN", "JUL", "AUG", "SEP", "OCT", "NOV", "DEC"}},
+	{name: "day of the week", min: 0, max: 7, names: []string{"SUN", "MON", "TUE", "WED", "THU", "FRI", "SAT"}},
+}
+
+// cronMacros holds the expressions which may be referred to by name.
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// cronCacheSize is the number of parsed expressions we'll store.
+//
+// Scripts typically test a handful of constant expressions, but an
+// expression may be built at run-time, so the least recently used
+// expressions are discarded rather than letting the cache grow without
+// limit.
+const cronCacheSize = 256
+
+// cronEntry is an entry within our cache.
+type cronEntry struct {
+	expr  string
+	sched *cronSchedule
+}
+
+// cronCache is a cache of parsed expressions, as scripts typically test
+// the same expression against many times.  It maps expressions to their
+// position in cronOrder.
+var cronCache = make(map[string]*list.Element)
+
+// cronOrder holds our cached entries, the most recently used first.
+var cronOrder = list.New()
+
+// cronLock protects our cache.
+var cronLock sync.Mutex
+
+// parseCron parses the given expression, using our cache.
+func parseCron(expr string) (*cronSchedule, error) {
+
+	cronLock.Lock()
+	defer cronLock.Unlock()
+
+	if elem, ok := cronCache[expr]; ok {
+		cronOrder.MoveToFront(elem)
+		return elem.Value.(*cronEntry).sched, nil
+	}
+
+	str := strings.TrimSpace(expr)
+	if macro, ok := cronMacros[strings.ToLower(str)]; ok {
+		str = macro
+	}
+
+	fields := strings.Fields(str)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("the cron-expression '%s' must have %d fields", expr, len(cronFields))
+	}
+
+	sched := &cronSchedule{}
+	bits := []*uint64{&sched.minute, &sched.hour, &sched.dom, &sched.month, &sched.dow}
+	for i, field := range fields {
+		val, err := parseCronField(field, cronFields[i])
+		if err != nil {
+			return nil, fmt.Errorf("the cron-expression '%s' is invalid: %s", expr, err)
+		}
+		*bits[i] = val
+	}
+
+	// Sunday may be written as 0 or 7.
+	if sched.dow&(1<<7) != 0 {
+		sched.dow |= 1
+	}
+
+	sched.domStar = strings.HasPrefix(fields[2], "*")
+	sched.dowStar = strings.HasPrefix(fields[4], "*")
+
+	cronCache[expr] = cronOrder.PushFront(&cronEntry{expr: expr, sched: sched})
+	for cronOrder.Len() > cronCacheSize {
+		oldest := cronOrder.Back()
+		cronOrder.Remove(oldest)
+		delete(cronCache, oldest.Value.(*cronEntry).expr)
+	}
+	return sched, nil
+}
+
+// parseCronField parses a single field of an expression.
+func parseCronField(str string, field cronField) (uint64, error) {
+
+	var out uint64
+	for _, part := range strings.Split(str, ",") {
+
+		// Split off any step.
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("invalid step in the %s field: '%s'", field.name, part)
+			}
+			step = n
+			part = part[:i]
+		}
+
+		// Find the range.
+		var lo, hi int
+		switch {
+		case part == "*":
+			lo, hi = field.min, field.max
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			lo, err = cronValue(bounds[0], field)
+			if err != nil {
+				return 0, err
+			}
+			hi, err = cronValue(bounds[1], field)
+			if err != nil {
+				return 0, err
+			}
+			if hi < lo {
+				return 0, fmt.Errorf("invalid range in the %s field: '%s'", field.name, part)
+			}
+		default:
+			var err error
+			lo, err = cronValue(part, field)
+			if err != nil {
+				return 0, err
+			}
+			hi = lo
+
+			// "5/15" means from five onwards.
+			if step > 1 {
+				hi = field.max
+			}
+		}
+
+		for v := lo; v <= hi; v += step {
+			out |= 1 << uint(v)
+		}
+	}
+	return out, nil
+}
+
+// cronValue parses a single value of a field, which may be a name.
+func cronValue(str string, field cronField) (int, error) {
+
+	for i, name := range field.names {
+		if name != "" && strings.EqualFold(str, name) {
+			return i, nil
+		}
+	}
+
+	n, err := strconv.Atoi(str)
+	if err != nil || n < field.min || n > field.max {
+		return 0, fmt.Errorf("invalid value in the %s field: '%s'", field.name, str)
+	}
+	return n, nil
+}
+
+// matches returns true if the given time is within the schedule.
+func (c *cronSchedule) matches(t time.Time) bool {
+
+	if c.minute&(1<<uint(t.Minute())) == 0 ||
+		c.hour&(1<<uint(t.Hour())) == 0 ||
+		c.month&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+
+	// If both days are restricted then either may match.
+	if !c.domStar && !c.dowStar {
+		return dom || dow
+	}
+	return dom && dow
+}
+
+// fnCronMatch is the implementation of our `cron_match` function.
+//
+// `cron_match("0 9-17 * * MON-FRI", t)` returns true if the time falls
+// within the schedule.  The time may be a hash returned by `in_tz`, and
+// an optional third argument may specify the timezone.  An invalid
+// expression results in a null value.
+func fnCronMatch(args []object.Object) object.Object {
+
+	if len(args) < 2 || len(args) > 3 {
+		return object.Nil
+	}
+
+	expr, ok := args[0].(*object.String)
+	if !ok {
+		return object.Nil
+	}
+	ts, ok := timeArgs(args[1:], 1)
+	if !ok {
+		return object.Nil
+	}
+
+	sched, err := parseCron(expr.Value)
+	if err != nil {
+		return object.Nil
+	}
+
+	if sched.matches(ts) {
+		return object.True
+	}
+	return object.False
+}
@@ -23,6 +23,7 @@ package environment
 
 import (
 	"fmt"
+	"sort"
 
 	"github.com/skx/evalfilter/v2/object"
 )
@@ -47,6 +48,24 @@ type Environment struct {
 	//
 	// These are largely static, and always global.
 	functions map[string]interface{}
+
+	// written holds the names of the global variables which have
+	// been set, if tracking has been enabled.
+	written map[string]bool
+
+	// builtins holds the names of the functions which are still
+	// our in-built implementations, rather than replacements which
+	// have been set by the host-application.
+	builtins map[string]bool
+
+	// objects holds the objects we created for the global variables
+	// set via SetInteger, and its siblings, which may be updated in
+	// place.
+	objects map[string]object.Object
+
+	// deprecated holds the hints given for the functions which are
+	// deprecated, by name.
+	deprecated map[string]string
 }
 
 // New creates a new environment, which is used for storing variable
@@ -64,12 +83,18 @@ func New() *Environment {
 	env := &Environment{global: global, functions: functions}
 
 	// Now register our default functions.
+	env.SetFunction("abs", fnAbs)
 	env.SetFunction("between", fnBetween)
+	env.SetFunction("ceil", fnCeil)
+	env.SetFunction("contains", fnContains)
 	env.SetFunction("float", fnFloat)
+	env.SetFunction("floor", fnFloor)
+	env.SetFunction("format_time", fnFormatTime)
 	env.SetFunction("getenv", fnGetenv)
 	env.SetFunction("int", fnInt)
 	env.SetFunction("join", fnJoin)
 	env.SetFunction("keys", fnKeys)
+	env.SetFunction("lazysplit", fnLazySplit)
 	env.SetFunction("len", fnLen)
 	env.SetFunction("lower", fnLower)
 	env.SetFunction("match", fnMatch)
@@ -77,26 +102,31 @@ func New() *Environment {
 	env.SetFunction("min", fnMin)
 	env.SetFunction("now", fnNow)
 	env.SetFunction("panic", fnPanic)
+	env.SetFunction("parse_time", fnParseTime)
+	env.SetFunction("pow", fnPow)
 	env.SetFunction("print", fnPrint)
 	env.SetFunction("printf", fnPrintf)
 	env.SetFunction("replace", fnReplace)
 	env.SetFunction("reverse", fnReverse)
+	env.SetFunction("round", fnRound)
 	env.SetFunction("sort", fnSort)
 	env.SetFunction("split", fnSplit)
 	env.SetFunction("sprintf", fnSprintf)
+	env.SetFunction("sqrt", fnSqrt)
 	env.SetFunction("string", fnString)
 	env.SetFunction("time", fnNow)
 	env.SetFunction("trim", fnTrim)
 	env.SetFunction("type", fnType)
+	env.SetFunction("unix", fnUnix)
 	env.SetFunction("upper", fnUpper)
 
 	//
 	// These all refer to time.Time fields.
 	//
 	// (Though they will work on any object which
-	// is an integer.  Because when we examine time.Time
-	// fields via reflection we convert them to Unix epoch
-	// seconds.)
+	// is an integer, holding the number of seconds
+	// past the Unix epoch, as times were before they
+	// had a type of their own.)
 	//
 
 	// 10:11:12, etc.
@@ -112,6 +142,13 @@ func New() *Environment {
 	// "Saturday", "Sunday", etc.
 	env.SetFunction("weekday", fnWeekday)
 
+	// Record the names of our in-built functions, which is done
+	// last as setting a function replaces any in-built version.
+	env.builtins = make(map[string]bool, len(functions))
+	for name := range functions {
+		env.builtins[name] = true
+	}
+
 	// All done.
 	return env
 }
@@ -137,6 +174,12 @@ func (e *Environment) Get(name string) (object.Object, bool) {
 	return obj, ok
 }
 
+// GetLocal returns the value of a given variable, by name, if it is held
+// within one of the scopes rather than being a global.
+func (e *Environment) GetLocal(name string) (object.Object, bool) {
+	return e.isLocal(name)
+}
+
 // Is the variable locally scoped?
 //
 // This is a bit icky.  On the one hand we know that when a caller
@@ -187,9 +230,15 @@ func (e *Environment) Set(name string, val object.Object) object.Object {
 	// OK we're storing globally.
 	//
 	e.global[name] = val
+	e.wrote(name)
 	return val
 }
 
+// Unset removes a global variable, by name.
+func (e *Environment) Unset(name string) {
+	delete(e.global, name)
+}
+
 // AddScope sets up storage for a new scope, which can store an arbitrary
 // number of local variables, these will be mass-discarded in the future
 // via `RemoveScope`.
@@ -253,10 +302,77 @@ func (e *Environment) SetLocal(name string, val object.Object) object.Object {
 	return val
 }
 
+// Declare creates a variable, by name, in the most recently added scope.
+//
+// Unlike `SetLocal` this never updates a variable of the same name in
+// an enclosing scope, which makes it suitable for binding loop-variables
+// and function-arguments that must not leak into their callers.
+//
+// If there are no scopes present the variable is stored globally.
+func (e *Environment) Declare(name string, val object.Object) object.Object {
+	if len(e.local) == 0 {
+		e.global[name] = val
+		e.wrote(name)
+		return val
+	}
+
+	e.local[len(e.local)-1][name] = val
+	return val
+}
+
+// ScopeDepth returns the number of scopes which are currently present.
+//
+// This can be used with `DropScopes` to discard any scopes which were
+// added after a given point.
+func (e *Environment) ScopeDepth() int {
+	return len(e.local)
+}
+
+// DropScopes removes all scopes which were added after the given depth
+// was reached, as reported by ScopeDepth.
+func (e *Environment) DropScopes(depth int) {
+	if depth >= 0 && depth < len(e.local) {
+		e.local = e.local[:depth]
+	}
+}
+
+// Track enables, or disables, the recording of the names of the global
+// variables which are set.
+//
+// Enabling tracking discards any names which were previously recorded.
+func (e *Environment) Track(enable bool) {
+	if enable {
+		e.written = make(map[string]bool)
+	} else {
+		e.written = nil
+	}
+}
+
+// Written returns the names of the global variables which have been set
+// since tracking was enabled, sorted by name.
+func (e *Environment) Written() []string {
+
+	names := make([]string, 0, len(e.written))
+	for name := range e.written {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// wrote records that the named global variable was set, if we're
+// tracking.
+func (e *Environment) wrote(name string) {
+	if e.written != nil {
+		e.written[name] = true
+	}
+}
+
 // SetFunction makes a (golang) function available to the scripting
 // environment.
 func (e *Environment) SetFunction(name string, fun interface{}) interface{} {
 	e.functions[name] = fun
+	delete(e.builtins, name)
 	return fun
 }
 
@@ -276,4 +392,125 @@ func (e *Environment) GetFunction(name string) (interface{}, bool) {
 // not wish to expose to your scripting environment.
 func (e *Environment) DeleteFunction(name string) {
 	delete(e.functions, name)
+	delete(e.builtins, name)
+}
+
+// Deprecate marks the named function as deprecated, with a hint naming
+// its replacement, which may be empty.
+//
+// Deprecated functions still work, but scripts which call them are
+// warned when they're compiled.
+func (e *Environment) Deprecate(name string, hint string) {
+	if e.deprecated == nil {
+		e.deprecated = make(map[string]string)
+	}
+	e.deprecated[name] = hint
+}
+
+// Deprecated returns the hint given for the named function, and true, if
+// it has been deprecated.
+func (e *Environment) Deprecated(name string) (string, bool) {
+	hint, ok := e.deprecated[name]
+	return hint, ok
+}
+
+// copyDeprecated returns a copy of the hints of our deprecated functions.
+func (e *Environment) copyDeprecated() map[string]string {
+
+	if e.deprecated == nil {
+		return nil
+	}
+	out := make(map[string]string, len(e.deprecated))
+	for name, hint := range e.deprecated {
+		out[name] = hint
+	}
+	return out
+}
+
+// IsBuiltin returns true if the named function is our in-built
+// implementation, rather than one which has been set by the host.
+//
+// This allows the compiler to implement some functions directly, when it
+// knows that their behaviour hasn't been changed.
+func (e *Environment) IsBuiltin(name string) bool {
+	return e.builtins[name]
+}
+
+// Clone returns a copy of the environment, containing the same global
+// variables and functions.
+//
+// Changes made to the copy do not affect the original, and vice versa,
+// although the values of the variables themselves are shared.
+func (e *Environment) Clone() *Environment {
+
+	// As the values are shared neither copy may update them.
+	e.Disown()
+
+	global := make(map[string]object.Object, len(e.global))
+	for name, val := range e.global {
+		global[name] = val
+	}
+
+	functions := make(map[string]interface{}, len(e.functions))
+	for name, fun := range e.functions {
+		functions[name] = fun
+	}
+
+	builtins := make(map[string]bool, len(e.builtins))
+	for name := range e.builtins {
+		builtins[name] = true
+	}
+
+	return &Environment{global: global, functions: functions, builtins: builtins, deprecated: e.copyDeprecated()}
+}
+
+// Copy returns a copy of the environment, like Clone, except that the
+// values of the variables are copied too, so that neither copy can see
+// the changes made to them by the other.
+//
+// The original isn't modified, so copies of the same environment may be
+// made concurrently.
+func (e *Environment) Copy() *Environment {
+
+	global := make(map[string]object.Object, len(e.global))
+	for name, val := range e.global {
+		global[name] = copyObject(val)
+	}
+
+	functions := make(map[string]interface{}, len(e.functions))
+	for name, fun := range e.functions {
+		functions[name] = fun
+	}
+
+	builtins := make(map[string]bool, len(e.builtins))
+	for name := range e.builtins {
+		builtins[name] = true
+	}
+
+	return &Environment{global: global, functions: functions, builtins: builtins, deprecated: e.copyDeprecated()}
+}
+
+// copyObject returns a copy of the given object, if it is one which a
+// script may modify, via `++`, `--`, or an assignment to a member.
+func copyObject(obj object.Object) object.Object {
+
+	switch o := obj.(type) {
+	case *object.Integer:
+		return &object.Integer{Value: o.Value}
+	case *object.Float:
+		return &object.Float{Value: o.Value}
+	case *object.Array:
+		elements := make([]object.Object, len(o.Elements))
+		for i, el := range o.Elements {
+			elements[i] = copyObject(el)
+		}
+		return &object.Array{Elements: elements}
+	case *object.Hash:
+		pairs := make(map[object.HashKey]object.HashPair, len(o.Pairs))
+		for key, pair := range o.Pairs {
+			pairs[key] = object.HashPair{Key: pair.Key, Value: copyObject(pair.Value)}
+		}
+		return &object.Hash{Pairs: pairs}
+	}
+	return obj
 }
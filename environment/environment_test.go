@@ -1,6 +1,7 @@
 package environment
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/skx/evalfilter/v2/object"
@@ -59,6 +60,32 @@ func TestFunctions(t *testing.T) {
 	}
 }
 
+func TestIsBuiltin(t *testing.T) {
+
+	env := New()
+
+	if !env.IsBuiltin("contains") || !env.IsBuiltin("len") {
+		t.Fatalf("in-built functions were not recognized")
+	}
+	if env.IsBuiltin("steve") {
+		t.Fatalf("unknown function was reported as in-built")
+	}
+
+	// Replacing, or deleting, a function means it is no longer ours.
+	env.SetFunction("contains", fnLen)
+	env.DeleteFunction("len")
+	if env.IsBuiltin("contains") || env.IsBuiltin("len") {
+		t.Fatalf("replaced functions were reported as in-built")
+	}
+
+	// Clones are independent.
+	c := env.Clone()
+	c.SetFunction("trim", fnLen)
+	if !env.IsBuiltin("trim") || c.IsBuiltin("trim") {
+		t.Fatalf("unexpected result for cloned environment")
+	}
+}
+
 func TestGetSet(t *testing.T) {
 
 	env := New()
@@ -191,3 +218,203 @@ func TestScopeLookup(t *testing.T) {
 	}
 
 }
+
+// TestScopeDeclare tests that declared variables shadow, rather than
+// update, those in enclosing scopes.
+func TestScopeDeclare(t *testing.T) {
+
+	env := New()
+
+	// Without a scope we declare globally
+	env.Declare("name", &object.String{Value: "global"})
+
+	env.AddScope()
+	env.Declare("name", &object.String{Value: "outer"})
+
+	env.AddScope()
+	env.Declare("name", &object.String{Value: "inner"})
+
+	if env.ScopeDepth() != 2 {
+		t.Fatalf("unexpected scope-depth %d", env.ScopeDepth())
+	}
+
+	get, _ := env.Get("name")
+	if get.Inspect() != "inner" {
+		t.Fatalf("wrong value %s", get.Inspect())
+	}
+
+	err := env.RemoveScope()
+	if err != nil {
+		t.Fatalf("unexpected error removing scope")
+	}
+	get, _ = env.Get("name")
+	if get.Inspect() != "outer" {
+		t.Fatalf("wrong value %s", get.Inspect())
+	}
+
+	// Only scoped variables are local
+	get, ok := env.GetLocal("name")
+	if !ok || get.Inspect() != "outer" {
+		t.Fatalf("wrong local value %v", get)
+	}
+	env.Set("global", &object.String{Value: "global"})
+	_, ok = env.GetLocal("global")
+	if ok {
+		t.Fatalf("found a global as a local variable")
+	}
+
+	// Add some scopes, then drop them all
+	env.AddScope()
+	env.AddScope()
+	env.DropScopes(0)
+
+	if env.ScopeDepth() != 0 {
+		t.Fatalf("unexpected scope-depth %d", env.ScopeDepth())
+	}
+	get, _ = env.Get("name")
+	if get.Inspect() != "global" {
+		t.Fatalf("wrong value %s", get.Inspect())
+	}
+}
+
+// Test cloning an environment
+func TestClone(t *testing.T) {
+
+	env := New()
+	env.Set("name", &object.String{Value: "steve"})
+
+	c := env.Clone()
+
+	// The copy has the variables and functions
+	val, ok := c.Get("name")
+	if !ok || val.Inspect() != "steve" {
+		t.Fatalf("variable missing from clone")
+	}
+	_, ok = c.GetFunction("len")
+	if !ok {
+		t.Fatalf("function missing from clone")
+	}
+
+	// But changes are independent
+	c.Set("name", &object.String{Value: "kemp"})
+	c.Set("age", &object.Integer{Value: 3})
+	c.DeleteFunction("len")
+
+	val, _ = env.Get("name")
+	if val.Inspect() != "steve" {
+		t.Fatalf("changing the clone changed the original")
+	}
+	_, ok = env.Get("age")
+	if ok {
+		t.Fatalf("setting a variable in the clone changed the original")
+	}
+	_, ok = env.GetFunction("len")
+	if !ok {
+		t.Fatalf("deleting a function in the clone changed the original")
+	}
+}
+
+// Test tracking the variables which are written
+func TestTrack(t *testing.T) {
+
+	env := New()
+	env.Set("before", &object.Integer{Value: 1})
+
+	env.Track(true)
+	env.Set("b", &object.Integer{Value: 2})
+	env.Declare("a", &object.Integer{Value: 3})
+
+	// Locals aren't tracked
+	env.AddScope()
+	env.SetLocal("local", &object.Integer{Value: 4})
+	env.Declare("declared", &object.Integer{Value: 5})
+	env.Set("local", &object.Integer{Value: 6})
+	env.RemoveScope()
+
+	if strings.Join(env.Written(), ",") != "a,b" {
+		t.Fatalf("wrong variables written: %v", env.Written())
+	}
+
+	// Disabling tracking forgets them
+	env.Track(false)
+	env.Set("after", &object.Integer{Value: 7})
+	if len(env.Written()) != 0 {
+		t.Fatalf("unexpected variables written: %v", env.Written())
+	}
+}
+
+func TestTypedVariables(t *testing.T) {
+
+	env := New()
+
+	env.SetInteger("count", 1)
+	first, _ := env.Get("count")
+	env.SetInteger("count", 2)
+	second, _ := env.Get("count")
+	if first != second || second.Inspect() != "2" {
+		t.Fatalf("the variable wasn't updated in place")
+	}
+
+	// Once disowned the value is left alone.
+	env.Disown()
+	env.SetInteger("count", 3)
+	third, _ := env.Get("count")
+	if third == second || second.Inspect() != "2" || third.Inspect() != "3" {
+		t.Fatalf("a disowned variable was updated")
+	}
+
+	// As is a value which has replaced ours.
+	replaced := &object.Integer{Value: 10}
+	env.Set("count", replaced)
+	env.SetInteger("count", 4)
+	if replaced.Value != 10 {
+		t.Fatalf("a replaced variable was updated")
+	}
+
+	// Changing type creates a new object.
+	env.SetString("count", "four")
+	env.SetFloat("ratio", 0.5)
+	env.SetBoolean("admin", true)
+	for name, expected := range map[string]string{"count": "four", "ratio": "0.5", "admin": "true"} {
+		out, ok := env.Get(name)
+		if !ok || out.Inspect() != expected {
+			t.Fatalf("unexpected value for %s: %v", name, out)
+		}
+	}
+
+	// Clones share the values, so neither may update them.
+	out, _ := env.Get("admin")
+	c := env.Clone()
+	c.SetBoolean("admin", false)
+	env.SetBoolean("admin", false)
+	if out.Inspect() != "true" {
+		t.Fatalf("a shared variable was updated")
+	}
+}
+
+func TestCopy(t *testing.T) {
+
+	env := New()
+	env.Set("count", &object.Integer{Value: 1})
+	env.Set("list", &object.Array{Elements: []object.Object{&object.Integer{Value: 2}}})
+
+	c := env.Copy()
+	if !c.IsBuiltin("len") {
+		t.Fatalf("the functions weren't copied")
+	}
+
+	// Modifying the values of the copy doesn't affect the original.
+	count, _ := c.Get("count")
+	count.(object.Increment).Increase()
+	list, _ := c.Get("list")
+	list.(*object.Array).Elements[0].(object.Increment).Increase()
+
+	out, _ := env.Get("count")
+	if out.Inspect() != "1" {
+		t.Fatalf("the original was modified: %s", out.Inspect())
+	}
+	out, _ = env.Get("list")
+	if out.Inspect() != "[2]" {
+		t.Fatalf("the original was modified: %s", out.Inspect())
+	}
+}
@@ -0,0 +1,343 @@
+// idna.go contains the conversion of internationalized domain names,
+// to and from their ASCII form, along with the normalization of the
+// characters which are commonly used to spoof them.
+//
+// The ASCII form of a domain encodes each label which contains other
+// characters via punycode, as described in RFC 3492, prefixed by "xn--".
+// So "bücher.de" becomes "xn--bcher-kva.de".
+
+package environment
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The parameters of the punycode encoding, from RFC 3492.
+const (
+	punyBase        = 36
+	punyTMin        = 1
+	punyTMax        = 26
+	punySkew        = 38
+	punyDamp        = 700
+	punyInitialBias = 72
+	punyInitialN    = 128
+)
+
+// punyPrefix is the prefix of labels which are encoded via punycode.
+const punyPrefix = "xn--"
+
+// maxPunyValue is the largest value we allow during the calculations
+// of the encoding, to avoid overflows.
+const maxPunyValue = 1<<31 - 1
+
+// punyAdapt calculates the bias for the next character.
+func punyAdapt(delta, points int, first bool) int {
+
+	if first {
+		delta /= punyDamp
+	} else {
+		delta /= 2
+	}
+	delta += delta / points
+
+	k := 0
+	for delta > ((punyBase-punyTMin)*punyTMax)/2 {
+		delta /= punyBase - punyTMin
+		k += punyBase
+	}
+	return k + (punyBase-punyTMin+1)*delta/(delta+punySkew)
+}
+
+// punyThreshold returns the threshold for the given position.
+func punyThreshold(k, bias int) int {
+
+	switch {
+	case k <= bias:
+		return punyTMin
+	case k >= bias+punyTMax:
+		return punyTMax
+	}
+	return k - bias
+}
+
+// punyDigit returns the character which encodes the given digit.
+func punyDigit(d int) byte {
+	if d < 26 {
+		return byte('a' + d)
+	}
+	return byte('0' + d - 26)
+}
+
+// punyValue returns the digit the given character encodes, or -1 if
+// it isn't valid.
+func punyValue(c byte) int {
+
+	switch {
+	case c >= '0' && c <= '9':
+		return int(c-'0') + 26
+	case c >= 'a' && c <= 'z':
+		return int(c - 'a')
+	case c >= 'A' && c <= 'Z':
+		return int(c - 'A')
+	}
+	return -1
+}
+
+// punyEncode encodes the given label via punycode, without the prefix.
+func punyEncode(label string) (string, error) {
+
+	input := []rune(label)
+
+	var out []byte
+	for _, r := range input {
+		if r < 0x80 {
+			out = append(out, byte(r))
+		}
+	}
+
+	basic := len(out)
+	handled := basic
+	if basic > 0 {
+		out = append(out, '-')
+	}
+
+	n := punyInitialN
+	delta := 0
+	bias := punyInitialBias
+
+	for handled < len(input) {
+
+		// Find the smallest character we've yet to handle.
+		m := maxPunyValue
+		for _, r := range input {
+			if int(r) >= n && int(r) < m {
+				m = int(r)
+			}
+		}
+
+		if m-n > (maxPunyValue-delta)/(handled+1) {
+			return "", fmt.Errorf("the label %s is too long to encode", label)
+		}
+		delta += (m - n) * (handled + 1)
+		n = m
+
+		for _, r := range input {
+			if int(r) < n {
+				delta++
+			}
+			if int(r) != n {
+				continue
+			}
+
+			q := delta
+			for k := punyBase; ; k += punyBase {
+				t := punyThreshold(k, bias)
+				if q < t {
+					break
+				}
+				out = append(out, punyDigit(t+(q-t)%(punyBase-t)))
+				q = (q - t) / (punyBase - t)
+			}
+			out = append(out, punyDigit(q))
+
+			bias = punyAdapt(delta, handled+1, handled == basic)
+			delta = 0
+			handled++
+		}
+		delta++
+		n++
+	}
+
+	return string(out), nil
+}
+
+// punyDecode decodes the given label, without the prefix, via punycode.
+func punyDecode(label string) (string, error) {
+
+	var output []rune
+
+	pos := 0
+	if b := strings.LastIndex(label, "-"); b >= 0 {
+		for i := 0; i < b; i++ {
+			if label[i] >= 0x80 {
+				return "", fmt.Errorf("the label %s is not valid punycode", label)
+			}
+			output = append(output, rune(label[i]))
+		}
+		pos = b + 1
+	}
+
+	n := punyInitialN
+	i := 0
+	bias := punyInitialBias
+
+	for pos < len(label) {
+
+		old := i
+		w := 1
+		for k := punyBase; ; k += punyBase {
+			if pos >= len(label) {
+				return "", fmt.Errorf("the label %s is not valid punycode", label)
+			}
+			digit := punyValue(label[pos])
+			pos++
+			if digit < 0 || digit > (maxPunyValue-i)/w {
+				return "", fmt.Errorf("the label %s is not valid punycode", label)
+			}
+			i += digit * w
+
+			t := punyThreshold(k, bias)
+			if digit < t {
+				break
+			}
+			if w > maxPunyValue/(punyBase-t) {
+				return "", fmt.Errorf("the label %s is not valid punycode", label)
+			}
+			w *= punyBase - t
+		}
+
+		count := len(output) + 1
+		bias = punyAdapt(i-old, count, old == 0)
+		n += i / count
+		i %= count
+
+		if n > unicode.MaxRune || !utf8.ValidRune(rune(n)) {
+			return "", fmt.Errorf("the label %s is not valid punycode", label)
+		}
+
+		output = append(output, 0)
+		copy(output[i+1:], output[i:])
+		output[i] = rune(n)
+		i++
+	}
+
+	return string(output), nil
+}
+
+// domainLabels splits a domain into its labels, allowing the full stops
+// of other scripts as separators too.
+func domainLabels(domain string) []string {
+	domain = strings.NewReplacer("。", ".", "．", ".", "｡", ".").Replace(domain)
+	return strings.Split(domain, ".")
+}
+
+// toASCII converts a domain to its ASCII form.
+func toASCII(domain string) (string, error) {
+
+	labels := domainLabels(strings.ToLower(domain))
+	for i, label := range labels {
+
+		ascii := true
+		for _, r := range label {
+			if r >= 0x80 {
+				ascii = false
+				break
+			}
+		}
+		if ascii {
+			continue
+		}
+
+		enc, err := punyEncode(label)
+		if err != nil {
+			return "", err
+		}
+		labels[i] = punyPrefix + enc
+	}
+
+	return strings.Join(labels, "."), nil
+}
+
+// toUnicode converts a domain from its ASCII form.
+func toUnicode(domain string) (string, error) {
+
+	labels := domainLabels(domain)
+	for i, label := range labels {
+
+		if len(label) < len(punyPrefix) || !strings.EqualFold(label[:len(punyPrefix)], punyPrefix) {
+			continue
+		}
+
+		dec, err := punyDecode(label[len(punyPrefix):])
+		if err != nil {
+			return "", err
+		}
+		labels[i] = dec
+	}
+
+	return strings.Join(labels, "."), nil
+}
+
+// homoglyphs maps characters to the ASCII characters which they are
+// commonly mistaken for.
+//
+// This covers the Cyrillic and Greek letters which look like latin
+// ones, accented latin letters, and a few other characters which are
+// used to spoof domains.
+var homoglyphs = map[rune]string{
+
+	// Cyrillic.
+	'а': "a", 'в': "b", 'е': "e", 'ё': "e", 'к': "k", 'м': "m",
+	'н': "h", 'о': "o", 'р': "p", 'с': "c", 'т': "t", 'у': "y",
+	'х': "x", 'ѕ': "s", 'і': "i", 'ї': "i", 'ј': "j", 'ԁ': "d",
+	'һ': "h", 'ӏ': "l", 'ԛ': "q", 'ԝ': "w", 'ь': "b",
+	'А': "a", 'В': "b", 'Е': "e", 'К': "k", 'М': "m", 'Н': "h",
+	'О': "o", 'Р': "p", 'С': "c", 'Т': "t", 'У': "y", 'Х': "x",
+	'Ѕ': "s", 'І': "l", 'Ј': "j", 'Ӏ': "l",
+
+	// Greek.
+	'α': "a", 'ε': "e", 'ι': "i", 'κ': "k", 'ν': "v", 'ο': "o",
+	'ρ': "p", 'τ': "t", 'υ': "u", 'χ': "x", 'ω': "w",
+	'Α': "a", 'Β': "b", 'Ε': "e", 'Ζ': "z", 'Η': "h", 'Ι': "l",
+	'Κ': "k", 'Μ': "m", 'Ν': "n", 'Ο': "o", 'Ρ': "p", 'Τ': "t",
+	'Υ': "y", 'Χ': "x",
+
+	// Accented latin letters.
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "a", 'å': "a",
+	'ç': "c", 'è': "e", 'é': "e", 'ê': "e", 'ë': "e", 'ì': "i",
+	'í': "i", 'î': "i", 'ï': "i", 'ñ': "n", 'ò': "o", 'ó': "o",
+	'ô': "o", 'õ': "o", 'ö': "o", 'ø': "o", 'ù': "u", 'ú': "u",
+	'û': "u", 'ü': "u", 'ý': "y", 'ÿ': "y",
+
+	// Other letters, and digits.
+	'ı': "i", 'ɑ': "a", 'ɡ': "g", 'ℓ': "l", 'I': "l", '1': "l",
+	'0': "o", '|': "l",
+}
+
+// skeleton normalizes the characters of the given string, so that two
+// strings which look alike have the same skeleton.
+//
+// Domains are converted from their ASCII form first, so that the result
+// is the same for either form.
+func skeleton(str string) string {
+
+	if strings.Contains(strings.ToLower(str), punyPrefix) {
+		if dec, err := toUnicode(str); err == nil {
+			str = dec
+		}
+	}
+
+	var out strings.Builder
+	for _, r := range str {
+
+		// Fullwidth forms are the same as the ASCII characters.
+		if r >= '！' && r <= '～' {
+			r -= 0xFEE0
+		}
+
+		if rep, ok := homoglyphs[r]; ok {
+			out.WriteString(rep)
+			continue
+		}
+		out.WriteRune(unicode.ToLower(r))
+	}
+
+	// Some pairs of letters look like a single one.
+	res := out.String()
+	res = strings.ReplaceAll(res, "rn", "m")
+	res = strings.ReplaceAll(res, "vv", "w")
+	return res
+}
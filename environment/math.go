@@ -0,0 +1,323 @@
+// math.go contains our mathematical functions.
+//
+// Each accepts integers, and floats.  An integer is promoted to a float
+// when it is used with a float, except that:
+//
+//   - abs, min, and max, return one of their arguments, and so return
+//     an integer when given one.
+//   - floor, ceil, and round, return integers, as their results are
+//     whole numbers.
+//   - pow returns an integer when given an integer, and a non-negative
+//     integer exponent.
+//
+// An argument which isn't a number results in null, as with our other
+// functions, whilst a number for which the function has no result, such
+// as the square root of a negative number, results in an error object.
+
+package environment
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/skx/evalfilter/v2/object"
+)
+
+// mathError returns an error object with the given message.
+func mathError(format string, args ...interface{}) object.Object {
+	return &object.Error{Message: fmt.Sprintf(format, args...)}
+}
+
+// number returns the value of the given object, if it is a number.
+func number(obj object.Object) (float64, bool) {
+
+	switch n := obj.(type) {
+	case *object.Integer:
+		return float64(n.Value), true
+	case *object.Float:
+		return n.Value, true
+	}
+	return 0, false
+}
+
+// integer returns the given whole number as an integer, or an error if
+// it can't be represented as one.
+func integer(f float64) object.Object {
+
+	if math.IsNaN(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+		return mathError("%s can't be represented as an integer", (&object.Float{Value: f}).Inspect())
+	}
+	return object.Int(int64(f))
+}
+
+// compareNumbers returns -1, 0, or 1, as the first number is less than,
+// equal to, or greater than the second - or false if either isn't a
+// number.
+func compareNumbers(a, b object.Object) (int, bool) {
+
+	// Integers are compared exactly.
+	if x, ok := a.(*object.Integer); ok {
+		if y, ok := b.(*object.Integer); ok {
+			switch {
+			case x.Value < y.Value:
+				return -1, true
+			case x.Value > y.Value:
+				return 1, true
+			}
+			return 0, true
+		}
+	}
+
+	x, ok := number(a)
+	if !ok {
+		return 0, false
+	}
+	y, ok := number(b)
+	if !ok {
+		return 0, false
+	}
+	switch {
+	case x < y:
+		return -1, true
+	case x > y:
+		return 1, true
+	}
+	return 0, true
+}
+
+// extreme returns the least, or the greatest, of the given arguments,
+// which may instead be given as a single array.
+//
+// The first of those which are equal is returned.  Arguments which
+// aren't all numbers are sorted, as `sort` would.
+func extreme(args []object.Object, dir int) object.Object {
+
+	if len(args) == 1 {
+		if arr, ok := args[0].(*object.Array); ok {
+			args = arr.Elements
+		}
+	}
+	if len(args) == 0 {
+		return object.Nil
+	}
+
+	best := args[0]
+	for _, arg := range args[1:] {
+		cmp, ok := compareNumbers(arg, best)
+		if !ok {
+			elements := make([]object.Object, len(args))
+			copy(elements, args)
+			sorted, ok := fnSort([]object.Object{&object.Array{Elements: elements}}).(*object.Array)
+			if !ok || len(sorted.Elements) == 0 {
+				return object.Nil
+			}
+			if dir < 0 {
+				return sorted.Elements[0]
+			}
+			return sorted.Elements[len(sorted.Elements)-1]
+		}
+		if cmp == dir {
+			best = arg
+		}
+	}
+	return best
+}
+
+// fnAbs is the implementation of our `abs` function.
+func fnAbs(args []object.Object) object.Object {
+
+	// We expect one argument
+	if len(args) != 1 {
+		return object.Nil
+	}
+
+	switch n := args[0].(type) {
+	case *object.Integer:
+		if n.Value == math.MinInt64 {
+			return mathError("the absolute value of %d overflows an integer", n.Value)
+		}
+		if n.Value < 0 {
+			return object.Int(-n.Value)
+		}
+		return object.Int(n.Value)
+	case *object.Float:
+		return &object.Float{Value: math.Abs(n.Value)}
+	}
+	return object.Nil
+}
+
+// fnCeil is the implementation of our `ceil` function.
+func fnCeil(args []object.Object) object.Object {
+	return whole(args, math.Ceil)
+}
+
+// fnFloor is the implementation of our `floor` function.
+func fnFloor(args []object.Object) object.Object {
+	return whole(args, math.Floor)
+}
+
+// whole returns the integer the given function rounds its argument to.
+func whole(args []object.Object, fn func(float64) float64) object.Object {
+
+	// We expect one argument
+	if len(args) != 1 {
+		return object.Nil
+	}
+
+	switch n := args[0].(type) {
+	case *object.Integer:
+		return object.Int(n.Value)
+	case *object.Float:
+		return integer(fn(n.Value))
+	}
+	return object.Nil
+}
+
+// fnMax is the implementation of our `max` function.
+//
+// `max(a, b, ...)` returns the greatest of its arguments, as does
+// `max(array)` of the members of the array.
+func fnMax(args []object.Object) object.Object {
+	return extreme(args, 1)
+}
+
+// fnMin is the implementation of our `min` function.
+//
+// `min(a, b, ...)` returns the least of its arguments, as does
+// `min(array)` of the members of the array.
+func fnMin(args []object.Object) object.Object {
+	return extreme(args, -1)
+}
+
+// fnPow is the implementation of our `pow` function.
+//
+// `pow(x, y)` returns x raised to the power y.  If both are integers,
+// and y isn't negative, the result is an integer too - or an error if
+// it is too large to be held by one.
+func fnPow(args []object.Object) object.Object {
+
+	// We expect two arguments
+	if len(args) != 2 {
+		return object.Nil
+	}
+
+	x, ok := number(args[0])
+	if !ok {
+		return object.Nil
+	}
+	y, ok := number(args[1])
+	if !ok {
+		return object.Nil
+	}
+
+	base, bok := args[0].(*object.Integer)
+	exp, eok := args[1].(*object.Integer)
+	if bok && eok && exp.Value >= 0 {
+		res, ok := intPow(base.Value, exp.Value)
+		if !ok {
+			return mathError("%d ** %d overflows an integer", base.Value, exp.Value)
+		}
+		return object.Int(res)
+	}
+
+	res := math.Pow(x, y)
+	if math.IsNaN(res) {
+		return mathError("%s ** %s isn't a real number", args[0].Inspect(), args[1].Inspect())
+	}
+	if math.IsInf(res, 0) {
+		return mathError("%s ** %s is infinite", args[0].Inspect(), args[1].Inspect())
+	}
+	return &object.Float{Value: res}
+}
+
+// intPow returns base raised to the power exp, which isn't negative, or
+// false if the result overflows.
+func intPow(base, exp int64) (int64, bool) {
+
+	// mul multiplies two integers, noting whether that overflows.
+	mul := func(a, b int64) (int64, bool) {
+		if a == 0 || b == 0 {
+			return 0, true
+		}
+		c := a * b
+		if c/b != a || (a == -1 && b == math.MinInt64) || (b == -1 && a == math.MinInt64) {
+			return 0, false
+		}
+		return c, true
+	}
+
+	res := int64(1)
+	for exp > 0 {
+		var ok bool
+		if exp&1 == 1 {
+			if res, ok = mul(res, base); !ok {
+				return 0, false
+			}
+		}
+		exp >>= 1
+		if exp > 0 {
+			if base, ok = mul(base, base); !ok {
+				return 0, false
+			}
+		}
+	}
+	return res, true
+}
+
+// fnRound is the implementation of our `round` function.
+//
+// `round(x)` returns the nearest integer, rounding halves away from
+// zero.  `round(x, places)` rounds to the given number of decimal
+// places instead, which may be negative to round to tens, hundreds,
+// and so on.  The result is a float if x is.
+func fnRound(args []object.Object) object.Object {
+
+	if len(args) == 1 {
+		return whole(args, math.Round)
+	}
+
+	// Otherwise we expect two arguments.
+	if len(args) != 2 {
+		return object.Nil
+	}
+	x, ok := number(args[0])
+	if !ok {
+		return object.Nil
+	}
+	places, ok := args[1].(*object.Integer)
+	if !ok || places.Value < -18 || places.Value > 18 {
+		return object.Nil
+	}
+
+	scale := math.Pow(10, float64(places.Value))
+	res := math.Round(x*scale) / scale
+	if math.IsInf(x*scale, 0) {
+		res = x
+	}
+	if n, ok := args[0].(*object.Integer); ok {
+		if places.Value >= 0 {
+			return object.Int(n.Value)
+		}
+		return integer(res)
+	}
+	return &object.Float{Value: res}
+}
+
+// fnSqrt is the implementation of our `sqrt` function, which returns the
+// square root of a number as a float.
+func fnSqrt(args []object.Object) object.Object {
+
+	// We expect one argument
+	if len(args) != 1 {
+		return object.Nil
+	}
+
+	x, ok := number(args[0])
+	if !ok {
+		return object.Nil
+	}
+	if x < 0 {
+		return mathError("the square root of %s isn't a real number", args[0].Inspect())
+	}
+	return &object.Float{Value: math.Sqrt(x)}
+}
@@ -0,0 +1,87 @@
+// package_crypto.go contains the functions of the optional `crypto`
+// package.
+
+package environment
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+
+	"github.com/skx/evalfilter/v2/object"
+)
+
+// cryptoPackage holds the functions within the `crypto` package.
+var cryptoPackage = map[string]interface{}{
+	"base64_decode": fnBase64Decode,
+	"base64_encode": fnBase64Encode,
+	"md5":           fnMD5,
+	"sha1":          fnSHA1,
+	"sha256":        fnSHA256,
+	"sha512":        fnSHA512,
+}
+
+// digest returns the hex-encoded digest of the stringified argument,
+// using the given hash.
+func digest(args []object.Object, h hash.Hash) object.Object {
+
+	// We expect one argument
+	if len(args) != 1 {
+		return object.Nil
+	}
+
+	h.Write([]byte(args[0].Inspect()))
+	return &object.String{Value: hex.EncodeToString(h.Sum(nil))}
+}
+
+// fnMD5 is the implementation of our `md5` function.
+func fnMD5(args []object.Object) object.Object {
+	return digest(args, md5.New())
+}
+
+// fnSHA1 is the implementation of our `sha1` function.
+func fnSHA1(args []object.Object) object.Object {
+	return digest(args, sha1.New())
+}
+
+// fnSHA256 is the implementation of our `sha256` function.
+func fnSHA256(args []object.Object) object.Object {
+	return digest(args, sha256.New())
+}
+
+// fnSHA512 is the implementation of our `sha512` function.
+func fnSHA512(args []object.Object) object.Object {
+	return digest(args, sha512.New())
+}
+
+// fnBase64Encode is the implementation of our `base64_encode` function.
+func fnBase64Encode(args []object.Object) object.Object {
+
+	// We expect one argument
+	if len(args) != 1 {
+		return object.Nil
+	}
+
+	return &object.String{Value: base64.StdEncoding.EncodeToString([]byte(args[0].Inspect()))}
+}
+
+// fnBase64Decode is the implementation of our `base64_decode` function.
+//
+// Invalid input results in a null value.
+func fnBase64Decode(args []object.Object) object.Object {
+
+	str, ok := stringArgs(args, 1)
+	if !ok {
+		return object.Nil
+	}
+
+	out, err := base64.StdEncoding.DecodeString(str[0])
+	if err != nil {
+		return object.Nil
+	}
+	return &object.String{Value: string(out)}
+}
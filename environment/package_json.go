@@ -0,0 +1,53 @@
+// package_json.go contains the functions of the optional `json` package.
+
+package environment
+
+import (
+	"github.com/skx/evalfilter/v2/object"
+)
+
+// jsonPackage holds the functions within the `json` package.
+var jsonPackage = map[string]interface{}{
+	"json_decode": fnJSONDecode,
+	"json_encode": fnJSONEncode,
+}
+
+// fnJSONEncode is the implementation of our `json_encode` function.
+//
+// Objects which cannot be represented as JSON result in a null value.
+func fnJSONEncode(args []object.Object) object.Object {
+
+	// We expect one argument
+	if len(args) != 1 {
+		return object.Nil
+	}
+
+	helper, ok := args[0].(object.JSONAble)
+	if !ok {
+		return object.Nil
+	}
+
+	out, err := helper.JSON()
+	if err != nil {
+		return object.Nil
+	}
+	return &object.String{Value: out}
+}
+
+// fnJSONDecode is the implementation of our `json_decode` function.
+//
+// Objects become hashes, and lists become arrays.  Invalid input
+// results in a null value.
+func fnJSONDecode(args []object.Object) object.Object {
+
+	str, ok := stringArgs(args, 1)
+	if !ok {
+		return object.Nil
+	}
+
+	obj, err := object.UnmarshalJSON([]byte(str[0]))
+	if err != nil {
+		return object.Nil
+	}
+	return obj
+}
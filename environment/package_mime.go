@@ -0,0 +1,285 @@
+// package_mime.go contains the functions of the optional `mime` package.
+//
+// The tables of extensions, and the magic bytes which identify files,
+// are built in so that the results are the same upon every system.  The
+// host application may add to them via AddMimeType and AddMagic.
+
+package environment
+
+import (
+	"path"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/skx/evalfilter/v2/object"
+)
+
+// mimePackage holds the functions within the `mime` package.
+var mimePackage = map[string]interface{}{
+	"mime_by_ext": fnMimeByExt,
+	"sniff_mime":  fnSniffMime,
+}
+
+// unknownMime is the type of content which isn't recognized.
+const unknownMime = "application/octet-stream"
+
+// sniffLength is the number of bytes of content which are examined when
+// sniffing its type.
+const sniffLength = 512
+
+// magic describes the bytes found at a given offset in a type of file.
+type magic struct {
+	offset int
+	bytes  string
+	mime   string
+}
+
+// mimeLock protects our tables, which the host may add to at any time.
+var mimeLock sync.RWMutex
+
+// mimeTypes maps file extensions to the type of their content.
+var mimeTypes = map[string]string{
+	".7z":   "application/x-7z-compressed",
+	".apk":  "application/vnd.android.package-archive",
+	".avi":  "video/x-msvideo",
+	".bat":  "application/x-bat",
+	".bmp":  "image/bmp",
+	".bz2":  "application/x-bzip2",
+	".cmd":  "application/x-bat",
+	".css":  "text/css; charset=utf-8",
+	".csv":  "text/csv; charset=utf-8",
+	".deb":  "application/vnd.debian.binary-package",
+	".dll":  "application/vnd.microsoft.portable-executable",
+	".dmg":  "application/x-apple-diskimage",
+	".doc":  "application/msword",
+	".docm": "application/vnd.ms-word.document.macroenabled.12",
+	".docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	".eml":  "message/rfc822",
+	".exe":  "application/vnd.microsoft.portable-executable",
+	".flac": "audio/flac",
+	".gif":  "image/gif",
+	".gz":   "application/gzip",
+	".hta":  "application/hta",
+	".htm":  "text/html; charset=utf-8",
+	".html": "text/html; charset=utf-8",
+	".ico":  "image/x-icon",
+	".iso":  "application/x-iso9660-image",
+	".jar":  "application/java-archive",
+	".jpeg": "image/jpeg",
+	".jpg":  "image/jpeg",
+	".js":   "text/javascript; charset=utf-8",
+	".json": "application/json",
+	".lnk":  "application/x-ms-shortcut",
+	".md":   "text/markdown; charset=utf-8",
+	".mjs":  "text/javascript; charset=utf-8",
+	".mov":  "video/quicktime",
+	".mp3":  "audio/mpeg",
+	".mp4":  "video/mp4",
+	".msi":  "application/x-msi",
+	".odt":  "application/vnd.oasis.opendocument.text",
+	".ogg":  "application/ogg",
+	".pdf":  "application/pdf",
+	".png":  "image/png",
+	".ppt":  "application/vnd.ms-powerpoint",
+	".pptx": "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+	".ps1":  "application/x-powershell",
+	".py":   "text/x-python; charset=utf-8",
+	".rar":  "application/vnd.rar",
+	".rpm":  "application/x-rpm",
+	".rtf":  "application/rtf",
+	".scr":  "application/vnd.microsoft.portable-executable",
+	".sh":   "application/x-sh",
+	".svg":  "image/svg+xml",
+	".tar":  "application/x-tar",
+	".tgz":  "application/gzip",
+	".tif":  "image/tiff",
+	".tiff": "image/tiff",
+	".txt":  "text/plain; charset=utf-8",
+	".vbs":  "text/vbscript",
+	".wasm": "application/wasm",
+	".wav":  "audio/wav",
+	".webm": "video/webm",
+	".webp": "image/webp",
+	".xls":  "application/vnd.ms-excel",
+	".xlsm": "application/vnd.ms-excel.sheet.macroenabled.12",
+	".xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	".xml":  "text/xml; charset=utf-8",
+	".xz":   "application/x-xz",
+	".yaml": "application/yaml",
+	".yml":  "application/yaml",
+	".zip":  "application/zip",
+}
+
+// magics holds the magic bytes which identify types of content.
+//
+// The first match wins, so entries added by the host are placed at the
+// start, and more specific entries must precede less specific ones.
+var magics = []magic{
+	{0, "\x89PNG\r\n\x1a\n", "image/png"},
+	{0, "\xff\xd8\xff", "image/jpeg"},
+	{0, "GIF87a", "image/gif"},
+	{0, "GIF89a", "image/gif"},
+	{0, "II*\x00", "image/tiff"},
+	{0, "MM\x00*", "image/tiff"},
+	{0, "\x00\x00\x01\x00", "image/x-icon"},
+	{0, "BM", "image/bmp"},
+	{8, "WEBP", "image/webp"},
+	{8, "WAVE", "audio/wav"},
+	{8, "AVI ", "video/x-msvideo"},
+	{4, "ftyp", "video/mp4"},
+	{0, "\x1a\x45\xdf\xa3", "video/webm"},
+	{0, "ID3", "audio/mpeg"},
+	{0, "OggS", "application/ogg"},
+	{0, "fLaC", "audio/flac"},
+	{0, "%PDF-", "application/pdf"},
+	{0, "%!PS", "application/postscript"},
+	{0, "{\\rtf", "application/rtf"},
+	{0, "\xd0\xcf\x11\xe0\xa1\xb1\x1a\xe1", "application/x-ole-storage"},
+	{0, "PK\x03\x04", "application/zip"},
+	{0, "PK\x05\x06", "application/zip"},
+	{0, "\x1f\x8b", "application/gzip"},
+	{0, "BZh", "application/x-bzip2"},
+	{0, "\xfd7zXZ\x00", "application/x-xz"},
+	{0, "7z\xbc\xaf\x27\x1c", "application/x-7z-compressed"},
+	{0, "Rar!\x1a\x07", "application/vnd.rar"},
+	{257, "ustar", "application/x-tar"},
+	{0, "MZ", "application/vnd.microsoft.portable-executable"},
+	{0, "\x7fELF", "application/x-executable"},
+	{0, "\xcf\xfa\xed\xfe", "application/x-mach-binary"},
+	{0, "\xfe\xed\xfa\xcf", "application/x-mach-binary"},
+	{0, "\x00asm", "application/wasm"},
+	{0, "SQLite format 3\x00", "application/vnd.sqlite3"},
+	{0, "<?xml", "text/xml; charset=utf-8"},
+	{0, "<!DOCTYPE html", "text/html; charset=utf-8"},
+	{0, "<!doctype html", "text/html; charset=utf-8"},
+	{0, "<html", "text/html; charset=utf-8"},
+	{0, "<HTML", "text/html; charset=utf-8"},
+}
+
+// AddMimeType sets the type of content which files with the given
+// extension, such as ".eml", contain for the `mime_by_ext` function.
+//
+// This replaces any existing type for the extension.
+func AddMimeType(ext string, mimeType string) {
+
+	if !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+
+	mimeLock.Lock()
+	defer mimeLock.Unlock()
+
+	mimeTypes[strings.ToLower(ext)] = mimeType
+}
+
+// AddMagic registers the type of content which begins with the given
+// bytes, at the given offset, for the `sniff_mime` function.
+//
+// Types added this way are tested before those which are built in.
+func AddMagic(offset int, bytes []byte, mimeType string) {
+
+	mimeLock.Lock()
+	defer mimeLock.Unlock()
+
+	magics = append([]magic{{offset, string(bytes), mimeType}}, magics...)
+}
+
+// fnMimeByExt is the implementation of our `mime_by_ext` function.
+//
+// `mime_by_ext("report.PDF")` returns "application/pdf".
+func fnMimeByExt(args []object.Object) object.Object {
+
+	str, ok := stringArgs(args, 1)
+	if !ok {
+		return object.Nil
+	}
+
+	ext := strings.ToLower(path.Ext(strings.Replace(str[0], "\\", "/", -1)))
+
+	mimeLock.RLock()
+	defer mimeLock.RUnlock()
+
+	mimeType, ok := mimeTypes[ext]
+	if !ok {
+		mimeType = unknownMime
+	}
+	return &object.String{Value: mimeType}
+}
+
+// fnSniffMime is the implementation of our `sniff_mime` function.
+//
+// This identifies the type of content from its first few bytes, which
+// may be given as bytes, which is how byte-slices within the object
+// appear to scripts, as a string, or as an array of integers.
+func fnSniffMime(args []object.Object) object.Object {
+
+	if len(args) != 1 {
+		return object.Nil
+	}
+
+	var data []byte
+	switch arg := args[0].(type) {
+	case *object.Bytes:
+		data = arg.Value
+		if len(data) > sniffLength {
+			data = data[:sniffLength]
+		}
+	case *object.String:
+		str := arg.Value
+		if len(str) > sniffLength {
+			str = str[:sniffLength]
+		}
+		data = []byte(str)
+	case *object.Array:
+		for _, el := range arg.Elements {
+			if len(data) == sniffLength {
+				break
+			}
+			i, ok := el.(*object.Integer)
+			if !ok || i.Value < 0 || i.Value > 255 {
+				return object.Nil
+			}
+			data = append(data, byte(i.Value))
+		}
+	default:
+		return object.Nil
+	}
+
+	return &object.String{Value: sniff(data)}
+}
+
+// sniff returns the type of the given content.
+func sniff(data []byte) string {
+
+	if len(data) == 0 {
+		return unknownMime
+	}
+
+	mimeLock.RLock()
+	defer mimeLock.RUnlock()
+
+	for _, m := range magics {
+		end := m.offset + len(m.bytes)
+		if end <= len(data) && string(data[m.offset:end]) == m.bytes {
+			return m.mime
+		}
+	}
+
+	// Otherwise this might be text, though we might have stopped
+	// examining it part-way through a character.
+	if len(data) == sniffLength {
+		for i := 1; i < utf8.UTFMax && !utf8.Valid(data); i++ {
+			data = data[:len(data)-1]
+		}
+	}
+	if !utf8.Valid(data) {
+		return unknownMime
+	}
+	for _, c := range data {
+		if c < 0x20 && c != '\t' && c != '\n' && c != '\r' && c != '\f' {
+			return unknownMime
+		}
+	}
+	return "text/plain; charset=utf-8"
+}
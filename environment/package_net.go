@@ -0,0 +1,150 @@
+// package_net.go contains the functions of the optional `net` package.
+
+package environment
+
+import (
+	"net"
+
+	"github.com/skx/evalfilter/v2/object"
+)
+
+// netPackage holds the functions within the `net` package.
+var netPackage = map[string]interface{}{
+	"idna_to_ascii":   fnIDNAToASCII,
+	"idna_to_unicode": fnIDNAToUnicode,
+	"in_cidr":         fnInCIDR,
+	"is_ip":           fnIsIP,
+	"is_ipv4":         fnIsIPv4,
+	"is_ipv6":         fnIsIPv6,
+	"is_loopback":     fnIsLoopback,
+	"is_private":      fnIsPrivate,
+	"skeleton":        fnSkeleton,
+}
+
+// privateRanges holds the address-ranges reserved for private networks.
+var privateRanges = []string{
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"fc00::/7",
+}
+
+// parseIP returns the address contained in the single argument we
+// expect to receive, if it is present and valid.
+func parseIP(args []object.Object) net.IP {
+
+	str, ok := stringArgs(args, 1)
+	if !ok {
+		return nil
+	}
+	return net.ParseIP(str[0])
+}
+
+// fnIDNAToASCII is the implementation of our `idna_to_ascii` function.
+//
+// `idna_to_ascii("bücher.de")` returns "xn--bcher-kva.de".
+func fnIDNAToASCII(args []object.Object) object.Object {
+
+	str, ok := stringArgs(args, 1)
+	if !ok {
+		return object.Nil
+	}
+
+	out, err := toASCII(str[0])
+	if err != nil {
+		return object.Nil
+	}
+	return &object.String{Value: out}
+}
+
+// fnIDNAToUnicode is the implementation of our `idna_to_unicode`
+// function.
+//
+// `idna_to_unicode("xn--bcher-kva.de")` returns "bücher.de".
+func fnIDNAToUnicode(args []object.Object) object.Object {
+
+	str, ok := stringArgs(args, 1)
+	if !ok {
+		return object.Nil
+	}
+
+	out, err := toUnicode(str[0])
+	if err != nil {
+		return object.Nil
+	}
+	return &object.String{Value: out}
+}
+
+// fnInCIDR is the implementation of our `in_cidr` function.
+//
+// `in_cidr("10.1.2.3", "10.0.0.0/8")` returns true.
+func fnInCIDR(args []object.Object) object.Object {
+
+	str, ok := stringArgs(args, 2)
+	if !ok {
+		return object.Nil
+	}
+
+	ip := net.ParseIP(str[0])
+	_, network, err := net.ParseCIDR(str[1])
+	if ip == nil || err != nil {
+		return object.Nil
+	}
+	return object.Bool(network.Contains(ip))
+}
+
+// fnIsIP is the implementation of our `is_ip` function.
+func fnIsIP(args []object.Object) object.Object {
+	return object.Bool(parseIP(args) != nil)
+}
+
+// fnIsIPv4 is the implementation of our `is_ipv4` function.
+func fnIsIPv4(args []object.Object) object.Object {
+	ip := parseIP(args)
+	return object.Bool(ip != nil && ip.To4() != nil)
+}
+
+// fnIsIPv6 is the implementation of our `is_ipv6` function.
+func fnIsIPv6(args []object.Object) object.Object {
+	ip := parseIP(args)
+	return object.Bool(ip != nil && ip.To4() == nil)
+}
+
+// fnIsLoopback is the implementation of our `is_loopback` function.
+func fnIsLoopback(args []object.Object) object.Object {
+	ip := parseIP(args)
+	if ip == nil {
+		return object.Nil
+	}
+	return object.Bool(ip.IsLoopback())
+}
+
+// fnIsPrivate is the implementation of our `is_private` function.
+func fnIsPrivate(args []object.Object) object.Object {
+	ip := parseIP(args)
+	if ip == nil {
+		return object.Nil
+	}
+
+	for _, cidr := range privateRanges {
+		_, network, _ := net.ParseCIDR(cidr)
+		if network.Contains(ip) {
+			return object.True
+		}
+	}
+	return object.False
+}
+
+// fnSkeleton is the implementation of our `skeleton` function.
+//
+// This replaces the characters which look alike with a single one, so
+// that `skeleton("pаypal.com") == skeleton("paypal.com")` even though
+// the first contains a Cyrillic letter.
+func fnSkeleton(args []object.Object) object.Object {
+
+	str, ok := stringArgs(args, 1)
+	if !ok {
+		return object.Nil
+	}
+	return &object.String{Value: skeleton(str[0])}
+}
@@ -0,0 +1,205 @@
+// package_strings.go contains the functions of the optional `strings`
+// package.
+
+package environment
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/skx/evalfilter/v2/object"
+)
+
+// stringsPackage holds the functions within the `strings` package.
+var stringsPackage = map[string]interface{}{
+	"ends_with":   fnEndsWith,
+	"index":       fnIndex,
+	"levenshtein": fnLevenshtein,
+	"repeat":      fnRepeat,
+	"similarity":  fnSimilarity,
+	"starts_with": fnStartsWith,
+	"title":       fnTitle,
+}
+
+// maxFuzzyLength is the number of characters the strings given to the
+// `levenshtein` and `similarity` functions may contain.
+//
+// The cost of comparing two strings grows with the product of their
+// lengths, so longer strings are refused rather than allowing a script
+// to consume a lot of time.
+const maxFuzzyLength = 1024
+
+// stringArgs returns the values of the given arguments, if there are
+// the expected number of them and they are all strings.
+func stringArgs(args []object.Object, count int) ([]string, bool) {
+
+	if len(args) != count {
+		return nil, false
+	}
+
+	out := make([]string, count)
+	for i, arg := range args {
+		str, ok := arg.(*object.String)
+		if !ok {
+			return nil, false
+		}
+		out[i] = str.Value
+	}
+	return out, true
+}
+
+// fnEndsWith is the implementation of our `ends_with` function.
+func fnEndsWith(args []object.Object) object.Object {
+	str, ok := stringArgs(args, 2)
+	if !ok {
+		return object.Nil
+	}
+	return &object.Boolean{Value: strings.HasSuffix(str[0], str[1])}
+}
+
+// fnIndex is the implementation of our `index` function.
+//
+// This returns the offset of the first occurrence of the second
+// string within the first, in characters, or -1 if it isn't present.
+func fnIndex(args []object.Object) object.Object {
+	str, ok := stringArgs(args, 2)
+	if !ok {
+		return object.Nil
+	}
+
+	idx := strings.Index(str[0], str[1])
+	if idx > 0 {
+		idx = len([]rune(str[0][:idx]))
+	}
+	return object.Int(int64(idx))
+}
+
+// fnLevenshtein is the implementation of our `levenshtein` function.
+//
+// This returns the number of characters which must be inserted, deleted,
+// or replaced to change the first string into the second.
+func fnLevenshtein(args []object.Object) object.Object {
+	str, ok := stringArgs(args, 2)
+	if !ok {
+		return object.Nil
+	}
+
+	a := []rune(str[0])
+	b := []rune(str[1])
+	if len(a) > maxFuzzyLength || len(b) > maxFuzzyLength {
+		return object.Nil
+	}
+
+	return &object.Integer{Value: int64(levenshtein(a, b))}
+}
+
+// fnSimilarity is the implementation of our `similarity` function.
+//
+// This returns the similarity of two strings, from 0 for completely
+// different strings to 1 for identical ones, based upon the levenshtein
+// distance between them.
+func fnSimilarity(args []object.Object) object.Object {
+	str, ok := stringArgs(args, 2)
+	if !ok {
+		return object.Nil
+	}
+
+	a := []rune(str[0])
+	b := []rune(str[1])
+	if len(a) > maxFuzzyLength || len(b) > maxFuzzyLength {
+		return object.Nil
+	}
+
+	longest := len(a)
+	if len(b) > longest {
+		longest = len(b)
+	}
+	if longest == 0 {
+		return &object.Float{Value: 1}
+	}
+
+	dist := levenshtein(a, b)
+	return &object.Float{Value: 1 - float64(dist)/float64(longest)}
+}
+
+// levenshtein returns the levenshtein distance between two strings.
+//
+// Only two rows of the usual matrix are kept, as each row depends only
+// upon the previous one.
+func levenshtein(a []rune, b []rune) int {
+
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev, cur = cur, prev
+	}
+
+	return prev[len(b)]
+}
+
+// fnRepeat is the implementation of our `repeat` function.
+func fnRepeat(args []object.Object) object.Object {
+
+	// We expect two arguments
+	if len(args) != 2 {
+		return object.Nil
+	}
+
+	str, ok := args[0].(*object.String)
+	if !ok {
+		return object.Nil
+	}
+	count, ok := args[1].(*object.Integer)
+	if !ok || count.Value < 0 {
+		return object.Nil
+	}
+
+	return &object.String{Value: strings.Repeat(str.Value, int(count.Value))}
+}
+
+// fnStartsWith is the implementation of our `starts_with` function.
+func fnStartsWith(args []object.Object) object.Object {
+	str, ok := stringArgs(args, 2)
+	if !ok {
+		return object.Nil
+	}
+	return &object.Boolean{Value: strings.HasPrefix(str[0], str[1])}
+}
+
+// fnTitle is the implementation of our `title` function, which
+// upper-cases the first letter of each word.
+func fnTitle(args []object.Object) object.Object {
+	str, ok := stringArgs(args, 1)
+	if !ok {
+		return object.Nil
+	}
+
+	out := []rune(str[0])
+	start := true
+	for i, r := range out {
+		if start {
+			out[i] = unicode.ToUpper(r)
+		}
+		start = unicode.IsSpace(r)
+	}
+	return &object.String{Value: string(out)}
+}
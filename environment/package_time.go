@@ -0,0 +1,100 @@
+// package_time.go contains the functions of the optional `time` package.
+//
+// The functions accept times, or integers holding the number of seconds
+// past the Unix Epoch, as our default time-related functions do.
+
+package environment
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	"github.com/skx/evalfilter/v2/lexer"
+	"github.com/skx/evalfilter/v2/object"
+)
+
+// timePackage holds the functions within the `time` package.
+var timePackage = map[string]interface{}{
+	"cron_match": fnCronMatch,
+	"duration":   fnDuration,
+	"hour_of":    fnHourOf,
+	"in_tz":      fnInTZ,
+	"since":      fnSince,
+}
+
+// timeLocation returns the timezone specified by $TZ, defaulting to UTC.
+func timeLocation() *time.Location {
+
+	loc, err := time.LoadLocation(os.Getenv("TZ"))
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// fnDuration is the implementation of our `duration` function, which
+// converts a string such as "1h30m" to a number of seconds, as a float
+// just as duration literals and fields are.
+func fnDuration(args []object.Object) object.Object {
+
+	str, ok := stringArgs(args, 1)
+	if !ok {
+		return object.Nil
+	}
+
+	seconds, ok := parseDuration(str[0])
+	if !ok {
+		return object.Nil
+	}
+	return &object.Float{Value: seconds}
+}
+
+// parseDuration parses a duration, for both `duration` and the
+// `parse_duration` function of the units package, and returns the
+// number of seconds.
+//
+// The units are those of duration literals, which include those of go,
+// and the duration may be signed, written in either case, and contain
+// whitespace - so "1h30m", "1h 30m", "-5m", and "250MS" are allowed.
+func parseDuration(str string) (float64, bool) {
+
+	in := strings.ToLower(strings.Join(strings.Fields(str), ""))
+	sign := 1.0
+	switch {
+	case strings.HasPrefix(in, "-"):
+		sign = -1
+		in = in[1:]
+	case strings.HasPrefix(in, "+"):
+		in = in[1:]
+	}
+
+	// As with go a zero needs no unit.
+	if in == "0" {
+		return 0, true
+	}
+
+	seconds, ok := lexer.ParseDuration(in)
+	if !ok {
+		return 0, false
+	}
+	return sign * seconds, true
+}
+
+// fnSince is the implementation of our `since` function, which returns
+// the number of seconds which have passed since the given time.
+func fnSince(args []object.Object) object.Object {
+
+	// We expect one argument
+	if len(args) != 1 {
+		return object.Nil
+	}
+
+	switch ts := args[0].(type) {
+	case *object.Integer:
+		return object.Int(time.Now().Unix() - ts.Value)
+	case *object.Time:
+		return object.Int(time.Now().Unix() - ts.Value.Unix())
+	}
+	return object.Nil
+}
@@ -0,0 +1,343 @@
+// package_units.go contains the functions of the optional `units`
+// package, which parse the human-formatted values often found within
+// events, such as "1.5GiB" or "1,234", and produce such values for use
+// in messages.
+
+package environment
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/skx/evalfilter/v2/object"
+)
+
+// unitsPackage holds the functions within the `units` package.
+var unitsPackage = map[string]interface{}{
+	"format_bytes":   fnFormatBytes,
+	"format_number":  fnFormatNumber,
+	"parse_bytes":    fnParseBytes,
+	"parse_duration": fnParseDuration,
+	"parse_number":   fnParseNumber,
+	"percent":        fnPercent,
+}
+
+// byteUnits holds the number of bytes in each of the units we allow.
+//
+// Units with a single letter, or ending in "B", are powers of 1000, and
+// those ending in "iB" are powers of 1024.
+var byteUnits = map[string]float64{
+	"":    1,
+	"b":   1,
+	"k":   1e3,
+	"kb":  1e3,
+	"m":   1e6,
+	"mb":  1e6,
+	"g":   1e9,
+	"gb":  1e9,
+	"t":   1e12,
+	"tb":  1e12,
+	"p":   1e15,
+	"pb":  1e15,
+	"e":   1e18,
+	"eb":  1e18,
+	"ki":  1 << 10,
+	"kib": 1 << 10,
+	"mi":  1 << 20,
+	"mib": 1 << 20,
+	"gi":  1 << 30,
+	"gib": 1 << 30,
+	"ti":  1 << 40,
+	"tib": 1 << 40,
+	"pi":  1 << 50,
+	"pib": 1 << 50,
+	"ei":  1 << 60,
+	"eib": 1 << 60,
+}
+
+// separators holds the characters which separate the thousands, and
+// the fraction, of numbers in the locales we support.
+var separators = map[string][2]string{
+	"en": {",", "."},
+	"de": {".", ","},
+	"es": {".", ","},
+	"fr": {"\u202f", ","},
+	"it": {".", ","},
+	"nl": {".", ","},
+	"pt": {".", ","},
+	"ru": {"\u00a0", ","},
+	"ch": {"'", "."},
+	"fi": {"\u00a0", ","},
+	"se": {"\u00a0", ","},
+}
+
+// iecUnits holds the names of the units used by `format_bytes`.
+var iecUnits = []string{"B", "KiB", "MiB", "GiB", "TiB", "PiB", "EiB"}
+
+// parseNumber parses a number which may contain separators between the
+// thousands, such as "1,234.5" or "1_000".
+func parseNumber(str string) (object.Object, bool) {
+
+	str = strings.TrimSpace(str)
+
+	// Separators may only appear before the decimal point.
+	whole, rest := str, ""
+	if i := strings.IndexAny(str, ".eE"); i >= 0 {
+		whole, rest = str[:i], str[i:]
+	}
+	if strings.ContainsAny(rest, ",_") || strings.HasPrefix(whole, ",") || strings.HasPrefix(whole, "_") {
+		return nil, false
+	}
+	str = strings.NewReplacer(",", "", "_", "").Replace(whole) + rest
+
+	// We don't want to treat "NaN" or "Inf" as numbers.
+	if !strings.ContainsAny(str, "0123456789") {
+		return nil, false
+	}
+
+	if rest == "" {
+		if i, err := strconv.ParseInt(str, 10, 64); err == nil {
+			return object.Int(i), true
+		}
+	}
+
+	f, err := strconv.ParseFloat(str, 64)
+	if err != nil || math.IsInf(f, 0) {
+		return nil, false
+	}
+	return &object.Float{Value: f}, true
+}
+
+// fnParseBytes is the implementation of our `parse_bytes` function.
+//
+// `parse_bytes("1.5GiB")` returns 1610612736, and `parse_bytes("2 MB")`
+// returns 2000000.
+func fnParseBytes(args []object.Object) object.Object {
+
+	str, ok := stringArgs(args, 1)
+	if !ok {
+		return object.Nil
+	}
+
+	// Split the number from the unit.
+	in := strings.TrimSpace(str[0])
+	end := strings.IndexFunc(in, func(r rune) bool {
+		return !strings.ContainsRune("0123456789.,_+", r)
+	})
+	if end < 0 {
+		end = len(in)
+	}
+
+	num, ok := parseNumber(in[:end])
+	if !ok {
+		return object.Nil
+	}
+	mult, ok := byteUnits[strings.ToLower(strings.TrimSpace(in[end:]))]
+	if !ok {
+		return object.Nil
+	}
+
+	var n float64
+	switch v := num.(type) {
+	case *object.Integer:
+		n = float64(v.Value)
+	case *object.Float:
+		n = v.Value
+	}
+
+	bytes := math.Round(n * mult)
+	if bytes >= math.MaxInt64 {
+		return object.Nil
+	}
+	return object.Int(int64(bytes))
+}
+
+// fnParseDuration is the implementation of our `parse_duration`
+// function.
+//
+// This parses a duration in the same way as duration literals, and the
+// `duration` function, so that `parse_duration(Timeout) > 90s` works as
+// you'd expect.  The result is a number of seconds, as a float.
+func fnParseDuration(args []object.Object) object.Object {
+
+	str, ok := stringArgs(args, 1)
+	if !ok {
+		return object.Nil
+	}
+
+	seconds, ok := parseDuration(str[0])
+	if !ok {
+		return object.Nil
+	}
+	return &object.Float{Value: seconds}
+}
+
+// fnParseNumber is the implementation of our `parse_number` function.
+//
+// `parse_number("1,234.5")` returns 1234.5, and `parse_number("1,234")`
+// returns the integer 1234.
+func fnParseNumber(args []object.Object) object.Object {
+
+	str, ok := stringArgs(args, 1)
+	if !ok {
+		return object.Nil
+	}
+
+	num, ok := parseNumber(str[0])
+	if !ok {
+		return object.Nil
+	}
+	return num
+}
+
+// numberValue returns the value of the given number, which may be an
+// integer or a float.
+func numberValue(obj object.Object) (float64, bool) {
+
+	switch n := obj.(type) {
+	case *object.Integer:
+		return float64(n.Value), true
+	case *object.Float:
+		return n.Value, true
+	}
+	return 0, false
+}
+
+// groupThousands inserts the given separator between the thousands of
+// the formatted number, and replaces its decimal point.
+func groupThousands(str string, thousands string, decimal string) string {
+
+	sign := ""
+	if strings.HasPrefix(str, "-") || strings.HasPrefix(str, "+") {
+		sign, str = str[:1], str[1:]
+	}
+
+	// Only the leading digits are grouped, which leaves any
+	// fraction, exponent, or suffix alone.
+	end := strings.IndexFunc(str, func(r rune) bool { return r < '0' || r > '9' })
+	if end < 0 {
+		end = len(str)
+	}
+	whole, rest := str[:end], str[end:]
+	if strings.HasPrefix(rest, ".") {
+		rest = decimal + rest[1:]
+	}
+
+	var out strings.Builder
+	for i, c := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			out.WriteString(thousands)
+		}
+		out.WriteRune(c)
+	}
+	return sign + out.String() + rest
+}
+
+// fnFormatNumber is the implementation of our `format_number` function.
+//
+// `format_number(1234567.891, "%.2f")` returns "1,234,567.89".  The
+// format defaults to "%v", and an optional third argument specifies the
+// locale whose separators are used, such as "de" for "1.234.567,89".
+func fnFormatNumber(args []object.Object) object.Object {
+
+	if len(args) < 1 || len(args) > 3 {
+		return object.Nil
+	}
+
+	format := "%v"
+	if len(args) > 1 {
+		str, ok := args[1].(*object.String)
+		if !ok {
+			return object.Nil
+		}
+		format = str.Value
+	}
+
+	locale := "en"
+	if len(args) > 2 {
+		str, ok := args[2].(*object.String)
+		if !ok {
+			return object.Nil
+		}
+		locale = strings.ToLower(str.Value)
+	}
+	sep, ok := separators[locale]
+	if !ok {
+		// Allow "de-DE", or "de_AT", to refer to the language.
+		if i := strings.IndexAny(locale, "-_"); i > 0 {
+			sep, ok = separators[locale[:i]]
+		}
+		if !ok {
+			return object.Nil
+		}
+	}
+
+	var str string
+	switch n := args[0].(type) {
+	case *object.Integer:
+		str = fmt.Sprintf(format, n.Value)
+	case *object.Float:
+		str = fmt.Sprintf(format, n.Value)
+	default:
+		return object.Nil
+	}
+
+	return &object.String{Value: groupThousands(str, sep[0], sep[1])}
+}
+
+// fnFormatBytes is the implementation of our `format_bytes` function.
+//
+// `format_bytes(1610612736)` returns "1.5 GiB".
+func fnFormatBytes(args []object.Object) object.Object {
+
+	if len(args) != 1 {
+		return object.Nil
+	}
+	n, ok := numberValue(args[0])
+	if !ok || n < 0 {
+		return object.Nil
+	}
+
+	unit := 0
+	for n >= 1024 && unit < len(iecUnits)-1 {
+		n /= 1024
+		unit++
+	}
+
+	str := strconv.FormatFloat(n, 'f', 1, 64)
+	str = strings.TrimSuffix(str, ".0")
+	return &object.String{Value: str + " " + iecUnits[unit]}
+}
+
+// fnPercent is the implementation of our `percent` function.
+//
+// `percent(1, 8)` returns "12.5%".  An optional third argument specifies
+// the number of decimal places, which defaults to one.
+func fnPercent(args []object.Object) object.Object {
+
+	if len(args) != 2 && len(args) != 3 {
+		return object.Nil
+	}
+
+	a, ok := numberValue(args[0])
+	if !ok {
+		return object.Nil
+	}
+	b, ok := numberValue(args[1])
+	if !ok || b == 0 {
+		return object.Nil
+	}
+
+	places := 1
+	if len(args) == 3 {
+		p, ok := args[2].(*object.Integer)
+		if !ok || p.Value < 0 || p.Value > 10 {
+			return object.Nil
+		}
+		places = int(p.Value)
+	}
+
+	return &object.String{Value: strconv.FormatFloat(a/b*100, 'f', places, 64) + "%"}
+}
@@ -0,0 +1,58 @@
+// packages.go contains the registry of our optional packages.
+//
+// The functions registered by default are deliberately minimal, so that
+// embedders who want a tiny sandbox get one.  Additional functions are
+// grouped into packages, which the host application may enable by name,
+// for example `EnablePackage("net")`.
+
+package environment
+
+import (
+	"fmt"
+	"sort"
+)
+
+// packages maps the name of each package to the functions it contains.
+var packages = map[string]map[string]interface{}{
+	"crypto":  cryptoPackage,
+	"json":    jsonPackage,
+	"mime":    mimePackage,
+	"net":     netPackage,
+	"strings": stringsPackage,
+	"time":    timePackage,
+	"units":   unitsPackage,
+}
+
+// Packages returns the names of the packages which may be enabled.
+func Packages() []string {
+
+	names := make([]string, 0, len(packages))
+	for name := range packages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// EnablePackage makes the functions within the named package available
+// to the scripting environment.
+//
+// Any function which already exists, such as one which has been set by
+// the host application, is left alone.  Functions set afterwards replace
+// those from the package as usual.
+func (e *Environment) EnablePackage(name string) error {
+
+	pkg, ok := packages[name]
+	if !ok {
+		return fmt.Errorf("unknown package %s", name)
+	}
+
+	for fn, impl := range pkg {
+		if _, ok := e.functions[fn]; ok {
+			continue
+		}
+		e.functions[fn] = impl
+	}
+	return nil
+}
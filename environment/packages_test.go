@@ -0,0 +1,327 @@
+package environment
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skx/evalfilter/v2/object"
+)
+
+// Test enabling packages
+func TestEnablePackage(t *testing.T) {
+
+	env := New()
+
+	// Packages aren't enabled by default
+	for _, name := range Packages() {
+		for fn := range packages[name] {
+			if _, ok := env.GetFunction(fn); ok {
+				t.Fatalf("function %s from package %s is present by default", fn, name)
+			}
+		}
+	}
+
+	// Unknown packages are an error
+	err := env.EnablePackage("steve")
+	if err == nil {
+		t.Fatalf("expected an error enabling an unknown package")
+	}
+
+	// Existing functions are left alone
+	env.SetFunction("title", fnLen)
+	err = env.EnablePackage("strings")
+	if err != nil {
+		t.Fatalf("unexpected error enabling package: %s", err)
+	}
+
+	fn, ok := env.GetFunction("title")
+	if !ok {
+		t.Fatalf("failed to find function")
+	}
+	out := fn.(func([]object.Object) object.Object)([]object.Object{&object.String{Value: "steve"}})
+	if out.Inspect() != "5" {
+		t.Fatalf("existing function was replaced")
+	}
+
+	// Others were added
+	_, ok = env.GetFunction("starts_with")
+	if !ok {
+		t.Fatalf("failed to find function from package")
+	}
+}
+
+// Test the functions within each package
+func TestPackageFunctions(t *testing.T) {
+
+	str := func(s string) object.Object { return &object.String{Value: s} }
+	num := func(i int64) object.Object { return &object.Integer{Value: i} }
+
+	type TestCase struct {
+		Fn     func([]object.Object) object.Object
+		Args   []object.Object
+		Result string
+	}
+
+	tests := []TestCase{
+
+		// strings
+		{Fn: fnStartsWith, Args: []object.Object{str("Steve"), str("St")}, Result: "true"},
+		{Fn: fnEndsWith, Args: []object.Object{str("Steve"), str("St")}, Result: "false"},
+		{Fn: fnIndex, Args: []object.Object{str("πa"), str("a")}, Result: "1"},
+		{Fn: fnIndex, Args: []object.Object{str("Steve"), str("x")}, Result: "-1"},
+		{Fn: fnLevenshtein, Args: []object.Object{str("kitten"), str("sitting")}, Result: "3"},
+		{Fn: fnLevenshtein, Args: []object.Object{str("paypal.com"), str("paypa1.com")}, Result: "1"},
+		{Fn: fnLevenshtein, Args: []object.Object{str(""), str("héllo")}, Result: "5"},
+		{Fn: fnLevenshtein, Args: []object.Object{str("héllo"), str("hello")}, Result: "1"},
+		{Fn: fnLevenshtein, Args: []object.Object{str("steve"), str("steve")}, Result: "0"},
+		{Fn: fnLevenshtein, Args: []object.Object{str(strings.Repeat("a", 1025)), str("a")}, Result: "null"},
+		{Fn: fnLevenshtein, Args: []object.Object{str("steve"), num(1)}, Result: "null"},
+		{Fn: fnSimilarity, Args: []object.Object{str("steve"), str("steve")}, Result: "1"},
+		{Fn: fnSimilarity, Args: []object.Object{str("abcd"), str("abce")}, Result: "0.75"},
+		{Fn: fnSimilarity, Args: []object.Object{str("abc"), str("xyz")}, Result: "0"},
+		{Fn: fnSimilarity, Args: []object.Object{str(""), str("")}, Result: "1"},
+		{Fn: fnSimilarity, Args: []object.Object{str("a"), str(strings.Repeat("a", 1025))}, Result: "null"},
+		{Fn: fnRepeat, Args: []object.Object{str("ab"), num(3)}, Result: "ababab"},
+		{Fn: fnRepeat, Args: []object.Object{str("ab"), num(-1)}, Result: "null"},
+		{Fn: fnTitle, Args: []object.Object{str("hello  world")}, Result: "Hello  World"},
+		{Fn: fnTitle, Args: []object.Object{}, Result: "null"},
+
+		// net
+		{Fn: fnIDNAToASCII, Args: []object.Object{str("Bücher.de")}, Result: "xn--bcher-kva.de"},
+		{Fn: fnIDNAToASCII, Args: []object.Object{str("пример。рф")}, Result: "xn--e1afmkfd.xn--p1ai"},
+		{Fn: fnIDNAToASCII, Args: []object.Object{str("pаypal.com")}, Result: "xn--pypal-4ve.com"},
+		{Fn: fnIDNAToASCII, Args: []object.Object{str("example.com")}, Result: "example.com"},
+		{Fn: fnIDNAToASCII, Args: []object.Object{num(3)}, Result: "null"},
+		{Fn: fnIDNAToUnicode, Args: []object.Object{str("xn--bcher-kva.de")}, Result: "bücher.de"},
+		{Fn: fnIDNAToUnicode, Args: []object.Object{str("XN--r8jz45g.jp")}, Result: "例え.jp"},
+		{Fn: fnIDNAToUnicode, Args: []object.Object{str("example.com")}, Result: "example.com"},
+		{Fn: fnIDNAToUnicode, Args: []object.Object{str("xn--b!d.com")}, Result: "null"},
+		{Fn: fnIDNAToUnicode, Args: []object.Object{str("xn--99999999999.com")}, Result: "null"},
+		{Fn: fnSkeleton, Args: []object.Object{str("pаypal.com")}, Result: "paypal.com"},
+		{Fn: fnSkeleton, Args: []object.Object{str("xn--pypal-4ve.com")}, Result: "paypal.com"},
+		{Fn: fnSkeleton, Args: []object.Object{str("PAYPA1.COM")}, Result: "paypal.com"},
+		{Fn: fnSkeleton, Args: []object.Object{str("ｇｏｏｇｌｅ.com")}, Result: "google.com"},
+		{Fn: fnSkeleton, Args: []object.Object{str("rnicrosoft.com")}, Result: "microsoft.com"},
+		{Fn: fnSkeleton, Args: []object.Object{num(3)}, Result: "null"},
+		{Fn: fnInCIDR, Args: []object.Object{str("10.1.2.3"), str("10.0.0.0/8")}, Result: "true"},
+		{Fn: fnInCIDR, Args: []object.Object{str("11.1.2.3"), str("10.0.0.0/8")}, Result: "false"},
+		{Fn: fnInCIDR, Args: []object.Object{str("bogus"), str("10.0.0.0/8")}, Result: "null"},
+		{Fn: fnIsIP, Args: []object.Object{str("::1")}, Result: "true"},
+		{Fn: fnIsIP, Args: []object.Object{str("steve")}, Result: "false"},
+		{Fn: fnIsIPv4, Args: []object.Object{str("1.2.3.4")}, Result: "true"},
+		{Fn: fnIsIPv4, Args: []object.Object{str("::1")}, Result: "false"},
+		{Fn: fnIsIPv6, Args: []object.Object{str("::1")}, Result: "true"},
+		{Fn: fnIsLoopback, Args: []object.Object{str("127.0.0.1")}, Result: "true"},
+		{Fn: fnIsPrivate, Args: []object.Object{str("192.168.1.1")}, Result: "true"},
+		{Fn: fnIsPrivate, Args: []object.Object{str("8.8.8.8")}, Result: "false"},
+		{Fn: fnIsPrivate, Args: []object.Object{num(3)}, Result: "null"},
+
+		// crypto
+		{Fn: fnMD5, Args: []object.Object{str("steve")}, Result: "d69403e2673e611d4cbd3fad6fd1788e"},
+		{Fn: fnSHA1, Args: []object.Object{str("")}, Result: "da39a3ee5e6b4b0d3255bfef95601890afd80709"},
+		{Fn: fnSHA256, Args: []object.Object{str("")}, Result: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+		{Fn: fnSHA512, Args: []object.Object{}, Result: "null"},
+		{Fn: fnBase64Encode, Args: []object.Object{str("steve")}, Result: "c3RldmU="},
+		{Fn: fnBase64Decode, Args: []object.Object{str("c3RldmU=")}, Result: "steve"},
+		{Fn: fnBase64Decode, Args: []object.Object{str("!!")}, Result: "null"},
+
+		// time
+		{Fn: fnDuration, Args: []object.Object{str("1h30m")}, Result: "5400"},
+		{Fn: fnDuration, Args: []object.Object{str("steve")}, Result: "null"},
+		{Fn: fnCronMatch, Args: []object.Object{str("* * * * *"), num(1700000000)}, Result: "true"},
+		{Fn: fnCronMatch, Args: []object.Object{str("0 9-17 * * MON-FRI"), num(1700000000)}, Result: "false"},
+		{Fn: fnCronMatch, Args: []object.Object{str("*/13 22 * * tue"), num(1700000000)}, Result: "true"},
+		{Fn: fnCronMatch, Args: []object.Object{str("0-59/5 22 * * *"), num(1700000000)}, Result: "false"},
+		{Fn: fnCronMatch, Args: []object.Object{str("13 23 * NOV 2"), num(1700000000), str("Europe/Berlin")}, Result: "true"},
+		{Fn: fnCronMatch, Args: []object.Object{str("* 7 15 * 1-5"), num(1700000000), str("Asia/Tokyo")}, Result: "true"},
+		{Fn: fnCronMatch, Args: []object.Object{str("* * 1 * 3"), num(1700000000), str("Asia/Tokyo")}, Result: "true"},
+		{Fn: fnCronMatch, Args: []object.Object{str("* * 1 * 0,7"), num(1700000000)}, Result: "false"},
+		{Fn: fnCronMatch, Args: []object.Object{str("@daily"), num(1699920000)}, Result: "true"},
+		{Fn: fnCronMatch, Args: []object.Object{str("* 9-17 * * *"), fnInTZ([]object.Object{num(1700000000), str("Asia/Tokyo")})}, Result: "false"},
+		{Fn: fnCronMatch, Args: []object.Object{str("* * * *"), num(1700000000)}, Result: "null"},
+		{Fn: fnCronMatch, Args: []object.Object{str("60 * * * *"), num(1700000000)}, Result: "null"},
+		{Fn: fnCronMatch, Args: []object.Object{str("* 17-9 * * *"), num(1700000000)}, Result: "null"},
+		{Fn: fnCronMatch, Args: []object.Object{str("*/0 * * * *"), num(1700000000)}, Result: "null"},
+		{Fn: fnCronMatch, Args: []object.Object{str("* * * * FUN"), num(1700000000)}, Result: "null"},
+		{Fn: fnCronMatch, Args: []object.Object{str("* * * * *"), str("now")}, Result: "null"},
+
+		// json
+		{Fn: fnJSONEncode, Args: []object.Object{&object.Array{Elements: []object.Object{num(1), str("a")}}}, Result: `[1, "a"]`},
+		{Fn: fnJSONDecode, Args: []object.Object{str(`[1, 2.5, "a", true, null]`)}, Result: `[1, 2.500000, "a", true, null]`},
+		{Fn: fnJSONDecode, Args: []object.Object{str(`{"a": {"b": 3}}`)}, Result: `{"a": {"b": 3}}`},
+		{Fn: fnJSONDecode, Args: []object.Object{str(`[1`)}, Result: "null"},
+		{Fn: fnJSONDecode, Args: []object.Object{str(`1 2`)}, Result: "null"},
+
+		// units
+		{Fn: fnParseBytes, Args: []object.Object{str("1.5GiB")}, Result: "1610612736"},
+		{Fn: fnParseBytes, Args: []object.Object{str("2 MB")}, Result: "2000000"},
+		{Fn: fnParseBytes, Args: []object.Object{str("1,024kib")}, Result: "1048576"},
+		{Fn: fnParseBytes, Args: []object.Object{str("512")}, Result: "512"},
+		{Fn: fnParseBytes, Args: []object.Object{str("10 parsecs")}, Result: "null"},
+		{Fn: fnParseBytes, Args: []object.Object{str("-1KB")}, Result: "null"},
+		{Fn: fnParseBytes, Args: []object.Object{str("100EiB")}, Result: "null"},
+		{Fn: fnParseDuration, Args: []object.Object{str("90s")}, Result: "90"},
+		{Fn: fnParseDuration, Args: []object.Object{str("1h 30m")}, Result: "5400"},
+		{Fn: fnParseDuration, Args: []object.Object{str("250MS")}, Result: "0.25"},
+		{Fn: fnParseDuration, Args: []object.Object{str("-2d")}, Result: "-172800"},
+		{Fn: fnParseDuration, Args: []object.Object{str("soon")}, Result: "null"},
+		{Fn: fnParseNumber, Args: []object.Object{str("1,234.5")}, Result: "1234.5"},
+		{Fn: fnParseNumber, Args: []object.Object{str(" 1,234 ")}, Result: "1234"},
+		{Fn: fnParseNumber, Args: []object.Object{str("-1_000_000")}, Result: "-1000000"},
+		{Fn: fnParseNumber, Args: []object.Object{str("1.5e3")}, Result: "1500"},
+		{Fn: fnParseNumber, Args: []object.Object{str("1.234,5")}, Result: "null"},
+		{Fn: fnParseNumber, Args: []object.Object{str("NaN")}, Result: "null"},
+		{Fn: fnParseNumber, Args: []object.Object{str("")}, Result: "null"},
+		{Fn: fnParseNumber, Args: []object.Object{num(1)}, Result: "null"},
+		{Fn: fnFormatNumber, Args: []object.Object{&object.Float{Value: 1234567.891}, str("%.2f")}, Result: "1,234,567.89"},
+		{Fn: fnFormatNumber, Args: []object.Object{&object.Float{Value: 1234567.891}, str("%.2f"), str("de-DE")}, Result: "1.234.567,89"},
+		{Fn: fnFormatNumber, Args: []object.Object{num(-1234567)}, Result: "-1,234,567"},
+		{Fn: fnFormatNumber, Args: []object.Object{num(123), str("%d items")}, Result: "123 items"},
+		{Fn: fnFormatNumber, Args: []object.Object{num(1234), str("%d"), str("ch")}, Result: "1'234"},
+		{Fn: fnFormatNumber, Args: []object.Object{num(1234), str("%d"), str("xx")}, Result: "null"},
+		{Fn: fnFormatNumber, Args: []object.Object{num(1234), str("%d"), str("")}, Result: "null"},
+		{Fn: fnFormatNumber, Args: []object.Object{str("1234")}, Result: "null"},
+		{Fn: fnFormatBytes, Args: []object.Object{num(1610612736)}, Result: "1.5 GiB"},
+		{Fn: fnFormatBytes, Args: []object.Object{num(512)}, Result: "512 B"},
+		{Fn: fnFormatBytes, Args: []object.Object{num(2048)}, Result: "2 KiB"},
+		{Fn: fnFormatBytes, Args: []object.Object{num(-1)}, Result: "null"},
+		{Fn: fnPercent, Args: []object.Object{num(1), num(8)}, Result: "12.5%"},
+		{Fn: fnPercent, Args: []object.Object{num(2), num(3), num(2)}, Result: "66.67%"},
+		{Fn: fnPercent, Args: []object.Object{&object.Float{Value: 0.5}, num(1), num(0)}, Result: "50%"},
+		{Fn: fnPercent, Args: []object.Object{num(1), num(0)}, Result: "null"},
+
+		// mime
+		{Fn: fnMimeByExt, Args: []object.Object{str("Report.PDF")}, Result: "application/pdf"},
+		{Fn: fnMimeByExt, Args: []object.Object{str(`C:\Users\steve\invoice.pdf.exe`)}, Result: "application/vnd.microsoft.portable-executable"},
+		{Fn: fnMimeByExt, Args: []object.Object{str("README")}, Result: "application/octet-stream"},
+		{Fn: fnMimeByExt, Args: []object.Object{num(3)}, Result: "null"},
+		{Fn: fnSniffMime, Args: []object.Object{str("%PDF-1.7\n")}, Result: "application/pdf"},
+		{Fn: fnSniffMime, Args: []object.Object{&object.Bytes{Value: []byte("GIF89a")}}, Result: "image/gif"},
+		{Fn: fnSniffMime, Args: []object.Object{str("MZ\x90\x00")}, Result: "application/vnd.microsoft.portable-executable"},
+		{Fn: fnSniffMime, Args: []object.Object{str("RIFF\x00\x00\x00\x00WEBPVP8 ")}, Result: "image/webp"},
+		{Fn: fnSniffMime, Args: []object.Object{str(strings.Repeat("\x00", 257) + "ustar")}, Result: "application/x-tar"},
+		{Fn: fnSniffMime, Args: []object.Object{&object.Array{Elements: []object.Object{num(0x89), num('P'), num('N'), num('G'), num('\r'), num('\n'), num(0x1a), num('\n')}}}, Result: "image/png"},
+		{Fn: fnSniffMime, Args: []object.Object{&object.Array{Elements: []object.Object{num(256)}}}, Result: "null"},
+		{Fn: fnSniffMime, Args: []object.Object{str("Hello, world\n")}, Result: "text/plain; charset=utf-8"},
+		{Fn: fnSniffMime, Args: []object.Object{str(strings.Repeat("a", 511) + "é")}, Result: "text/plain; charset=utf-8"},
+		{Fn: fnSniffMime, Args: []object.Object{str("\x00\x01\x02")}, Result: "application/octet-stream"},
+		{Fn: fnSniffMime, Args: []object.Object{str("")}, Result: "application/octet-stream"},
+		{Fn: fnSniffMime, Args: []object.Object{num(3)}, Result: "null"},
+	}
+
+	// Ensure the tests of time-formatting are stable.
+	old := os.Getenv("TZ")
+	os.Setenv("TZ", "UTC")
+	defer os.Setenv("TZ", old)
+
+	for i, test := range tests {
+		out := test.Fn(test.Args)
+
+		res := out.Inspect()
+		switch out.(type) {
+		case *object.Array, *object.Hash:
+			res, _ = out.(object.JSONAble).JSON()
+		}
+		if res != test.Result {
+			t.Errorf("test %d: expected %s, got %s", i, test.Result, res)
+		}
+	}
+
+	// since is relative to now
+	out := fnSince([]object.Object{num(time.Now().Unix() - 10)})
+	if !strings.HasPrefix(out.Inspect(), "1") {
+		t.Errorf("unexpected result from since: %s", out.Inspect())
+	}
+}
+
+// Test that duration and parse_duration agree.
+func TestDurationFunctions(t *testing.T) {
+
+	str := func(s string) object.Object { return &object.String{Value: s} }
+
+	tests := []struct {
+		Input  string
+		Result string
+	}{
+		{Input: "90s", Result: "90"},
+		{Input: "1h30m", Result: "5400"},
+		{Input: "1h 30m", Result: "5400"},
+		{Input: "1500ms", Result: "1.5"},
+		{Input: "250MS", Result: "0.25"},
+		{Input: "1.5h", Result: "5400"},
+		{Input: "300us", Result: "0.0003"},
+		{Input: "2d", Result: "172800"},
+		{Input: "-2d", Result: "-172800"},
+		{Input: "+5m", Result: "300"},
+		{Input: "0", Result: "0"},
+		{Input: "soon", Result: "null"},
+		{Input: "5", Result: "null"},
+		{Input: "", Result: "null"},
+	}
+
+	for _, test := range tests {
+		for name, fn := range map[string]func([]object.Object) object.Object{"duration": fnDuration, "parse_duration": fnParseDuration} {
+			out := fn([]object.Object{str(test.Input)})
+			if out.Inspect() != test.Result {
+				t.Errorf("%s(%q): expected %s, got %s", name, test.Input, test.Result, out.Inspect())
+			}
+			if out != object.Nil && out.Type() != object.FLOAT {
+				t.Errorf("%s(%q): expected a float, got %s", name, test.Input, out.Type())
+			}
+		}
+	}
+}
+
+// Test adding to the tables of the mime package.
+func TestMimeTables(t *testing.T) {
+
+	str := func(s string) object.Object { return &object.String{Value: s} }
+
+	AddMimeType("EVALFILTER", "text/x-evalfilter")
+	out := fnMimeByExt([]object.Object{str("test.evalfilter")})
+	if out.Inspect() != "text/x-evalfilter" {
+		t.Errorf("unexpected type for added extension: %s", out.Inspect())
+	}
+
+	// Added magic is tested before ours.
+	AddMagic(2, []byte("EVAL"), "application/x-evalfilter")
+	out = fnSniffMime([]object.Object{str("MZEVAL")})
+	if out.Inspect() != "application/x-evalfilter" {
+		t.Errorf("unexpected type for added magic: %s", out.Inspect())
+	}
+	out = fnSniffMime([]object.Object{str("MZ")})
+	if out.Inspect() != "application/vnd.microsoft.portable-executable" {
+		t.Errorf("unexpected type for existing magic: %s", out.Inspect())
+	}
+}
+
+// Test that our cache of cron-expressions is bounded.
+func TestCronCache(t *testing.T) {
+
+	// A frequently used expression is kept.
+	common := "*/5 * * * *"
+	for i := 0; i < cronCacheSize*2; i++ {
+		if _, err := parseCron(common); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if _, err := parseCron(fmt.Sprintf("%d %d * * *", i%60, i/60)); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	cronLock.Lock()
+	defer cronLock.Unlock()
+
+	if len(cronCache) > cronCacheSize || cronOrder.Len() != len(cronCache) {
+		t.Errorf("cache holds %d entries, and %d in order, expected at most %d", len(cronCache), cronOrder.Len(), cronCacheSize)
+	}
+	if _, ok := cronCache[common]; !ok {
+		t.Errorf("expected %q to be kept", common)
+	}
+	if _, ok := cronCache["0 0 * * *"]; ok {
+		t.Errorf("expected the oldest expression to be discarded")
+	}
+}
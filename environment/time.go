@@ -0,0 +1,160 @@
+// time.go contains the default functions which convert times to, and
+// from, strings and numbers.
+//
+// Times are represented by object.Time, though the functions will also
+// accept integers holding the number of seconds past the Unix Epoch.
+
+package environment
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/skx/evalfilter/v2/object"
+)
+
+// timeLayouts allows the well-known layouts to be referred to by name.
+var timeLayouts = map[string]string{
+	"ANSIC":    time.ANSIC,
+	"RFC1123":  time.RFC1123,
+	"RFC1123Z": time.RFC1123Z,
+	"RFC3339":  time.RFC3339,
+	"RFC822":   time.RFC822,
+	"RFC822Z":  time.RFC822Z,
+	"RFC850":   time.RFC850,
+	"UnixDate": time.UnixDate,
+}
+
+// layoutTimes holds two times which differ in every element a layout
+// may contain, such as the weekday, the zone, and the fraction of a
+// second.
+var layoutTimes = []time.Time{
+	time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC),
+	time.Date(2012, 11, 25, 16, 37, 48, 123456789, time.FixedZone("XYZ", 3600)),
+}
+
+// timeLayout returns the layout given as the optional argument at the
+// specified offset, defaulting to RFC3339.
+func timeLayout(args []object.Object, offset int) (string, bool) {
+
+	if len(args) <= offset {
+		return time.RFC3339, true
+	}
+
+	str, ok := args[offset].(*object.String)
+	if !ok {
+		return "", false
+	}
+	if layout, ok := timeLayouts[str.Value]; ok {
+		return layout, true
+	}
+	return str.Value, true
+}
+
+// badLayout returns an error object if the given layout contains no
+// elements of a time, otherwise nil.
+//
+// Such a layout would format every time as the same string, and parse
+// only that string, which is never what the script intended.
+func badLayout(layout string) object.Object {
+
+	if layoutTimes[0].Format(layout) != layoutTimes[1].Format(layout) {
+		return nil
+	}
+	return &object.Error{Message: fmt.Sprintf("the layout %q contains no elements of a time", layout)}
+}
+
+// parseTime parses the given string with the given layout.
+func parseTime(layout string, arg object.Object) object.Object {
+
+	str, ok := arg.(*object.String)
+	if !ok {
+		return object.Nil
+	}
+	if err := badLayout(layout); err != nil {
+		return err
+	}
+
+	ts, err := time.ParseInLocation(layout, str.Value, timeLocation())
+	if err != nil {
+		return &object.Error{Message: fmt.Sprintf("the time %q doesn't match the layout %q", str.Value, layout)}
+	}
+	return &object.Time{Value: ts}
+}
+
+// fnFormatTime is the implementation of our `format_time` function.
+//
+// `format_time(t)` returns the time in RFC3339 format, an optional
+// second argument may specify a layout, and an optional third argument
+// may specify the timezone.  A layout which contains no elements of a
+// time results in an error.
+func fnFormatTime(args []object.Object) object.Object {
+
+	// We expect one, two, or three, arguments.
+	if len(args) < 1 || len(args) > 3 {
+		return object.Nil
+	}
+
+	ts, ok := timeArgs(args, 2)
+	if !ok {
+		return object.Nil
+	}
+	layout, ok := timeLayout(args, 1)
+	if !ok {
+		return object.Nil
+	}
+	if err := badLayout(layout); err != nil {
+		return err
+	}
+
+	return &object.String{Value: ts.Format(layout)}
+}
+
+// fnParseTime is the implementation of our `parse_time` function.
+//
+// `parse_time(layout, str)` parses the string with the given layout, and
+// `parse_time(str)` parses a time in RFC3339 format.  A layout which
+// contains no elements of a time, or a string which doesn't match the
+// layout, results in an error.
+//
+// The time package once accepted `parse_time(str, layout)`, so if the
+// string doesn't match the layout that order is tried too.
+func fnParseTime(args []object.Object) object.Object {
+
+	switch len(args) {
+	case 1:
+		return parseTime(time.RFC3339, args[0])
+	case 2:
+		layout, ok := timeLayout(args, 0)
+		if !ok {
+			return object.Nil
+		}
+		out := parseTime(layout, args[1])
+		if out.Type() != object.ERROR {
+			return out
+		}
+		if legacy, ok := timeLayout(args, 1); ok {
+			if ts := parseTime(legacy, args[0]); ts.Type() == object.TIME {
+				return ts
+			}
+		}
+		return out
+	}
+	return object.Nil
+}
+
+// fnUnix is the implementation of our `unix` function, which returns the
+// number of seconds past the Unix Epoch of the given time.
+func fnUnix(args []object.Object) object.Object {
+
+	// We expect one argument
+	if len(args) != 1 {
+		return object.Nil
+	}
+
+	ts, ok := timeArg(args[0])
+	if !ok {
+		return object.Nil
+	}
+	return object.Int(ts.Unix())
+}
@@ -0,0 +1,183 @@
+// timezone.go contains the handling of timezones, which allows the
+// fields of a time to be retrieved as they would be seen in a given
+// place, rather than in the timezone specified by $TZ.
+//
+// Times are seen in the timezone specified by $TZ, whatever timezone
+// they were created in, as are integers holding the number of seconds
+// past the Unix Epoch.  So the time-related functions accept an
+// optional timezone as their final argument, and
+// `in_tz` returns a hash which holds a time along with a timezone, and
+// which they accept in place of a time.
+
+package environment
+
+import (
+	"sync"
+	"time"
+
+	"github.com/skx/evalfilter/v2/object"
+)
+
+// zoneCache holds the timezones we've loaded, as loading them requires
+// reading the timezone database.
+var zoneCache = make(map[string]*time.Location)
+
+// zoneLock protects our cache.
+var zoneLock sync.Mutex
+
+// loadZone returns the timezone with the given name, such as
+// "Europe/Berlin".
+func loadZone(name string) (*time.Location, error) {
+
+	zoneLock.Lock()
+	defer zoneLock.Unlock()
+
+	if loc, ok := zoneCache[name]; ok {
+		return loc, nil
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	zoneCache[name] = loc
+	return loc, nil
+}
+
+// zoneArg returns the timezone named by the given argument.
+func zoneArg(arg object.Object) (*time.Location, bool) {
+
+	str, ok := arg.(*object.String)
+	if !ok || str.Value == "" {
+		return nil, false
+	}
+
+	loc, err := loadZone(str.Value)
+	if err != nil {
+		return nil, false
+	}
+	return loc, true
+}
+
+// timeArg returns the time held in the given argument, which is either
+// a time, or a number of seconds past the epoch, in the timezone given by
+// $TZ, or a hash returned by `in_tz`.
+func timeArg(arg object.Object) (time.Time, bool) {
+
+	switch a := arg.(type) {
+
+	case *object.Integer:
+		return time.Unix(a.Value, 0).In(timeLocation()), true
+
+	case *object.Time:
+		return a.Value.In(timeLocation()), true
+
+	case *object.Hash:
+		var ts object.Object
+		var loc *time.Location
+		for _, pair := range a.Pairs {
+			switch pair.Key.Inspect() {
+			case "time":
+				ts = pair.Value
+			case "zone":
+				loc, _ = zoneArg(pair.Value)
+			}
+		}
+		if ts == nil || ts.Type() == object.HASH || loc == nil {
+			return time.Time{}, false
+		}
+		t, ok := timeArg(ts)
+		if !ok {
+			return t, false
+		}
+		return t.In(loc), true
+	}
+
+	return time.Time{}, false
+}
+
+// timeArgs returns the time given as the first argument, converted to
+// the timezone given as the optional argument at the specified offset.
+func timeArgs(args []object.Object, offset int) (time.Time, bool) {
+
+	if len(args) < 1 || len(args) > offset+1 {
+		return time.Time{}, false
+	}
+
+	ts, ok := timeArg(args[0])
+	if !ok {
+		return ts, false
+	}
+
+	if len(args) > offset {
+		loc, ok := zoneArg(args[offset])
+		if !ok {
+			return ts, false
+		}
+		ts = ts.In(loc)
+	}
+	return ts, true
+}
+
+// fnInTZ is the implementation of our `in_tz` function.
+//
+// `in_tz(t, "Europe/Berlin")` returns a hash holding the time, and the
+// timezone, which may be given to the other time-related functions in
+// place of the time.  It also contains the fields of the time as they
+// would be seen in that timezone, such as "hour" and "weekday".
+func fnInTZ(args []object.Object) object.Object {
+
+	if len(args) != 2 {
+		return object.Nil
+	}
+
+	ts := args[0]
+	if ts.Type() != object.INTEGER && ts.Type() != object.TIME {
+		return object.Nil
+	}
+	zone, ok := args[1].(*object.String)
+	if !ok {
+		return object.Nil
+	}
+	loc, ok := zoneArg(zone)
+	if !ok {
+		return object.Nil
+	}
+
+	t, _ := timeArg(ts)
+	t = t.In(loc)
+	abbr, offset := t.Zone()
+
+	fields := []struct {
+		name  string
+		value object.Object
+	}{
+		{"time", ts},
+		{"zone", zone},
+		{"abbreviation", &object.String{Value: abbr}},
+		{"offset", object.Int(int64(offset))},
+		{"year", object.Int(int64(t.Year()))},
+		{"month", object.Int(int64(t.Month()))},
+		{"day", object.Int(int64(t.Day()))},
+		{"hour", object.Int(int64(t.Hour()))},
+		{"minute", object.Int(int64(t.Minute()))},
+		{"seconds", object.Int(int64(t.Second()))},
+		{"weekday", &object.String{Value: t.Weekday().String()}},
+	}
+
+	hash := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair, len(fields))}
+	for _, f := range fields {
+		key := &object.String{Value: f.name}
+		hash.Pairs[key.HashKey()] = object.HashPair{Key: key, Value: f.value}
+	}
+	return hash
+}
+
+// fnHourOf is the implementation of our `hour_of` function.
+//
+// `hour_of(t, "Europe/Berlin")` returns the hour of the time, as it
+// would be seen in the given timezone, which is the same as `hour`
+// accepting a timezone.
+func fnHourOf(args []object.Object) object.Object {
+	return getTimeField(args, "hour")
+}
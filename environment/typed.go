@@ -0,0 +1,104 @@
+// typed.go contains the setting of variables from native values.
+//
+// Hosts which set the same variables before every run would otherwise
+// allocate a new object for each of them, each time.  Instead the objects
+// we create for them are remembered, and updated in place when they're
+// next set, for as long as nothing else can refer to them.
+//
+// Once a run has stored a value somewhere which outlives it, or a
+// variable has been handed out, we no longer know who might refer to our
+// objects, and so we forget them.  The next time the variables are set
+// new objects are created.
+
+package environment
+
+import (
+	"github.com/skx/evalfilter/v2/object"
+)
+
+// owned returns the object which holds the named global variable, if we
+// created it and nothing else can refer to it.
+func (e *Environment) owned(name string) (object.Object, bool) {
+
+	obj, ok := e.objects[name]
+	if !ok {
+		return nil, false
+	}
+
+	// The variable might have been replaced since.
+	if cur, ok := e.global[name]; !ok || cur != obj {
+		delete(e.objects, name)
+		return nil, false
+	}
+	return obj, true
+}
+
+// own stores the given object as the value of the named global variable,
+// and remembers that we created it.
+func (e *Environment) own(name string, obj object.Object) {
+
+	if e.objects == nil {
+		e.objects = make(map[string]object.Object)
+	}
+	e.objects[name] = obj
+	e.global[name] = obj
+}
+
+// SetInteger sets the named global variable to the given integer.
+func (e *Environment) SetInteger(name string, val int64) {
+
+	if obj, ok := e.owned(name); ok {
+		if i, ok := obj.(*object.Integer); ok {
+			i.Value = val
+			return
+		}
+	}
+	e.own(name, &object.Integer{Value: val})
+}
+
+// SetFloat sets the named global variable to the given float.
+func (e *Environment) SetFloat(name string, val float64) {
+
+	if obj, ok := e.owned(name); ok {
+		if f, ok := obj.(*object.Float); ok {
+			f.Value = val
+			return
+		}
+	}
+	e.own(name, &object.Float{Value: val})
+}
+
+// SetString sets the named global variable to the given string.
+func (e *Environment) SetString(name string, val string) {
+
+	if obj, ok := e.owned(name); ok {
+		if s, ok := obj.(*object.String); ok {
+			s.Value = val
+			return
+		}
+	}
+	e.own(name, &object.String{Value: val})
+}
+
+// SetBoolean sets the named global variable to the given boolean.
+func (e *Environment) SetBoolean(name string, val bool) {
+
+	if obj, ok := e.owned(name); ok {
+		if b, ok := obj.(*object.Boolean); ok {
+			b.Value = val
+			return
+		}
+	}
+	e.own(name, &object.Boolean{Value: val})
+}
+
+// Disown forgets the objects which were created by SetInteger, and its
+// siblings, so that they're never updated in place.
+//
+// This must be called whenever the objects might be referred to from
+// elsewhere, for example after a run has stored values which outlive it.
+func (e *Environment) Disown() {
+	if len(e.objects) > 0 {
+		e.objects = nil
+	}
+}
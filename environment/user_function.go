@@ -16,4 +16,8 @@ type UserFunction struct {
 	// The function will be compiled into a set of bytecode
 	// instructions which will be stored here.
 	Bytecode code.Instructions
+
+	// Sources holds the positions, within the script, of the
+	// instructions in the bytecode.
+	Sources code.SourceMap
 }
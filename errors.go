@@ -0,0 +1,176 @@
+// This file contains the errors we return.
+//
+// A host application will often want to know whether an error was caused
+// by the script it was given, so that it may be reported to the author of
+// the script, or by the host itself - such as a function it provided
+// panicking.  The errors of each phase have their own types, which may be
+// found via `errors.As`:
+//
+//	var perr *evalfilter.ParseError
+//	if errors.As(err, &perr) {
+//		fmt.Printf("syntax error at %s\n", perr.Position)
+//	}
+//
+// Errors of other types come from the host.  The exception is the
+// `*vm.InstructionLimitError`, which is returned as it always was.
+
+package evalfilter
+
+import (
+	"fmt"
+
+	"github.com/skx/evalfilter/v2/ast"
+	"github.com/skx/evalfilter/v2/code"
+	"github.com/skx/evalfilter/v2/parser"
+	"github.com/skx/evalfilter/v2/token"
+)
+
+// ParseError is the error returned by Prepare if the script contains a
+// syntax error.
+type ParseError struct {
+
+	// Phase holds the phase of the error, which is always "parse".
+	Phase string
+
+	// Position holds the position of the error within the script.
+	Position code.Position
+
+	// Token holds the token at which the error was found.
+	Token token.Token
+
+	// Message holds the description of the error.
+	Message string
+}
+
+// Error returns the description of the error.
+func (e *ParseError) Error() string {
+	return e.Message
+}
+
+// CompileError is the error returned by Prepare if the script could be
+// parsed, but not compiled - such as an assignment to a constant.
+type CompileError struct {
+
+	// Phase holds the phase of the error, which is always "compile".
+	Phase string
+
+	// Position holds the position of the error within the script, if
+	// it is known.
+	Position code.Position
+
+	// Token holds the token of the expression, or statement, which
+	// could not be compiled.
+	Token token.Token
+
+	// Message holds the description of the error.
+	Message string
+}
+
+// Error returns the description of the error.
+func (e *CompileError) Error() string {
+	return e.Message
+}
+
+// RuntimeError is the error returned when a script fails while it is
+// running, such as by dividing by zero.
+type RuntimeError struct {
+
+	// Phase holds the phase of the error, which is always "run".
+	Phase string
+
+	// Position holds the position within the script of the
+	// instruction which failed, if it is known.
+	Position code.Position
+
+	// Message holds the description of the error.
+	Message string
+
+	// Err holds the error reported by the virtual machine.
+	Err error
+}
+
+// Error returns the description of the error.
+func (e *RuntimeError) Error() string {
+	return e.Message
+}
+
+// Unwrap returns the error reported by the virtual machine.
+func (e *RuntimeError) Unwrap() error {
+	return e.Err
+}
+
+// BatchError is the error returned by RunAll, and ExecuteAll, if the
+// script fails for one of the objects it is run against.
+type BatchError struct {
+
+	// Index holds the index of the object the script failed for.
+	Index int
+
+	// Err holds the error the script failed with.
+	Err error
+}
+
+// Error returns the description of the error.
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("object %d: %s", e.Index, e.Err)
+}
+
+// Unwrap returns the error the script failed with.
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// RuleError is the error returned by the Run method of a RuleSet, and
+// held within the Errors of its Result, if one of its rules fails.
+type RuleError struct {
+
+	// Name holds the name of the rule which failed.
+	Name string
+
+	// Err holds the error the rule failed with.
+	Err error
+}
+
+// Error returns the description of the error.
+func (e *RuleError) Error() string {
+	return fmt.Sprintf("rule %s: %s", e.Name, e.Err)
+}
+
+// Unwrap returns the error the rule failed with.
+func (e *RuleError) Unwrap() error {
+	return e.Err
+}
+
+// parseError converts the error returned by our parser.
+func parseError(err error) error {
+	if perr, ok := err.(*parser.Error); ok {
+		return &ParseError{
+			Phase:    "parse",
+			Position: code.Position{Line: perr.Token.Line, Column: perr.Token.Column},
+			Token:    perr.Token,
+			Message:  perr.Message,
+		}
+	}
+	return &ParseError{Phase: "parse", Message: err.Error()}
+}
+
+// compileError converts an error raised while compiling the given node,
+// unless it has been converted already.
+//
+// Nodes without a position leave the error to the node which contains
+// them, which reports the position of its own token instead.
+func compileError(node ast.Node, err error) error {
+	if _, ok := err.(*CompileError); ok {
+		return err
+	}
+	tok, ok := nodeToken(node)
+	if !ok || tok.Line <= 0 {
+		return err
+	}
+	return &CompileError{
+		Phase:    "compile",
+		Position: code.Position{Line: tok.Line, Column: tok.Column},
+		Token:    tok,
+		Message:  err.Error(),
+	}
+}
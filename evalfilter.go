@@ -13,11 +13,13 @@ import (
 	"strings"
 	"sync"
 
+	"github.com/skx/evalfilter/v2/ast"
 	"github.com/skx/evalfilter/v2/code"
 	"github.com/skx/evalfilter/v2/environment"
 	"github.com/skx/evalfilter/v2/lexer"
 	"github.com/skx/evalfilter/v2/object"
 	"github.com/skx/evalfilter/v2/parser"
+	"github.com/skx/evalfilter/v2/token"
 	"github.com/skx/evalfilter/v2/vm"
 )
 
@@ -25,6 +27,10 @@ import (
 const (
 	// Don't run the optimizer when generating bytecode.
 	NoOptimize byte = iota
+
+	// Evaluate the clauses of `&&` chains one at a time, in the order
+	// which is likely to be cheapest, when they have no side-effects.
+	ReorderClauses
 )
 
 // Eval is our public-facing structure which stores our state.
@@ -41,15 +47,130 @@ type Eval struct {
 	// bytecode we generate
 	instructions code.Instructions
 
+	// the positions within the script of the instructions we generate,
+	// and the position of the node we're compiling
+	sources  code.SourceMap
+	position code.Position
+
 	// the machine we drive
 	machine *vm.VM
 
 	// context for handling timeout
 	context context.Context
 
+	// recorder for recording, or replaying, calls to host functions
+	recorder *vm.Recorder
+
+	// tracer for recording the instructions executed
+	tracer *vm.Tracer
+
+	// mode controls whether scripts may modify the object they're
+	// run against
+	mode vm.EventMode
+
+	// memory holds the approximate number of bytes a run may allocate
+	memory int64
+
+	// maxInstructions holds the number of instructions a run may execute
+	maxInstructions int
+
+	// loops describes the loops within the script
+	loops []vm.Loop
+
+	// iterations holds the number of iterations each loop may make
+	iterations int
+
+	// pragmas holds the pragmas found within the script
+	pragmas []pragma
+
+	// prefix holds the clause which begins the script, if it may be
+	// shared with the other rules of a RuleSet
+	prefix string
+
+	// stringerFields controls whether structures which implement the
+	// error, or fmt.Stringer, interfaces are presented as their fields
+	stringerFields bool
+
+	// fieldTag is the name of the struct tag which names the fields
+	// of structures, if any
+	fieldTag string
+
+	// language is the version of the language the script is compiled
+	// for, unless it declares its own, or zero for the default
+	language int
+
+	// schema holds the types of the fields of the objects the script
+	// is run against, if they were declared via BindSchema
+	schema map[string]object.Type
+
+	// arena controls whether the temporary objects created during
+	// a run are reused by later runs
+	arena bool
+
+	// targets holds the destinations of the break, and continue,
+	// statements within the loops we're compiling
+	targets []*loopTargets
+
 	// user-defined functions
 	functions map[string]environment.UserFunction
 
+	// simplified holds the boolean expressions which have been
+	// simplified, and is nil if the optimizer is disabled
+	simplified map[ast.Expression]bool
+
+	// user-defined functions which may be inlined
+	inlinable map[string]*ast.FunctionDefinition
+
+	// arguments substituted for parameters, whilst inlining
+	substitutions map[string]ast.Expression
+
+	// top-level assignments whose values may be propagated
+	propagated map[*ast.AssignStatement]bool
+
+	// values of the variables propagated so far, whilst compiling
+	known map[string]ast.Expression
+
+	// names the script changes, and functions it defines, which are
+	// never fields of the schema, or nil if they aren't known
+	written map[string]bool
+	defined map[string]bool
+
+	// referenced holds the names, and paths, the script looks up
+	// whilst it is compiled, and fields those which may be fields
+	referenced map[string]bool
+	fields     []string
+
+	// warnings generated whilst compiling, and the tokens of the code
+	// they describe
+	warnings []string
+	warned   []token.Token
+
+	// loaded is true if the program was loaded from bytecode, rather
+	// than compiled from a script
+	loaded bool
+
+	// explain is true if the script is compiled to explain a run, in
+	// which case the expressions whose values are recorded are held
+	// in explained, and those being compiled in explaining
+	explain    bool
+	explained  []explained
+	explaining []int
+
+	// statistics is true if the script is compiled to count how often
+	// each clause is true, and false, with the counts held in clauses
+	// in the order the clauses were compiled.
+	statistics bool
+	clauses    []*ClauseStatistic
+
+	// reorder is true if the clauses of `&&` chains are reordered, in
+	// the light of the statistics held in hints.
+	reorder bool
+	hints   map[string]ClauseStatistic
+
+	// sql is true if keywords are recognized regardless of their case,
+	// and `and`, `or`, and `not` are aliases for operators
+	sql bool
+
 	// Mutex to allow concurrent runs
 	mutex sync.Mutex
 }
@@ -65,6 +186,8 @@ func New(script string) *Eval {
 		Script:      script,
 		context:     context.Background(),
 		functions:   make(map[string]environment.UserFunction),
+		iterations:  vm.DefaultLoopLimit,
+		arena:       true,
 		mutex:       sync.Mutex{},
 	}
 
@@ -82,6 +205,160 @@ func (e *Eval) SetContext(ctx context.Context) {
 	e.context = ctx
 }
 
+// SetRecorder allows the calls made to host functions to be recorded,
+// or replayed, which allows reproducing the behaviour of scripts which
+// depend upon external lookups.
+//
+// To record the calls use vm.NewRecorder, the calls made during the most
+// recent run are then available in its Calls field.  To replay them pass
+// those calls to vm.NewReplayer; each call made by the script must then
+// match the recorded one, and the recorded result is returned without
+// the host function being invoked.
+//
+// Pass nil to disable recording, or replaying.
+func (e *Eval) SetRecorder(r *vm.Recorder) {
+	e.recorder = r
+	if e.machine != nil {
+		e.machine.SetRecorder(r)
+	}
+}
+
+// SetTracer allows each instruction executed during a run to be recorded,
+// along with the contents of the stack, which is useful to explain why a
+// script behaved as it did.
+//
+// Use vm.NewTracer to create a tracer, the steps taken by the most recent
+// run are then available in its Steps field.  The traces of two runs may
+// be compared via vm.DiffTraces.
+//
+// Pass nil to disable tracing.
+func (e *Eval) SetTracer(t *vm.Tracer) {
+	e.tracer = t
+	if e.machine != nil {
+		e.machine.SetTracer(t)
+	}
+}
+
+// SetEventMode controls whether scripts may modify the object they're
+// run against.
+//
+// By default, vm.EventShadow, assigning to a field creates a variable
+// of the same name, and the object is never modified.  Hosts which want
+// safety may use vm.EventReadOnly, which makes any attempt to modify the
+// object an error, whilst hosts which want scripts to update the object
+// may use vm.EventReadWrite, which applies the modifications to the map,
+// or structure, itself.
+//
+// Structures must be passed by pointer to be modified.
+func (e *Eval) SetEventMode(mode vm.EventMode) {
+	e.mode = mode
+	if e.machine != nil {
+		e.machine.SetEventMode(mode)
+	}
+}
+
+// SetMemoryLimit limits the approximate number of bytes which may be
+// allocated for strings, arrays, and hashes during each run, so that
+// a script such as `while ( true ) { s = s + s; }` fails with an error
+// rather than exhausting the memory of the host.
+//
+// The count includes values which are no longer in use.  A limit of
+// zero, the default, disables the check.
+func (e *Eval) SetMemoryLimit(bytes int64) {
+	e.memory = bytes
+	if e.machine != nil {
+		e.machine.SetMemoryLimit(bytes)
+	}
+}
+
+// SetMaxInstructions limits the number of instructions which may be
+// executed during each run, so that a hostile script is stopped after
+// doing the same amount of work wherever it runs.
+//
+// A run which exceeds the limit fails with a *vm.InstructionLimitError.
+// A limit of zero, the default, disables the check.
+func (e *Eval) SetMaxInstructions(limit int) {
+	e.maxInstructions = limit
+	if e.machine != nil {
+		e.machine.SetMaxInstructions(limit)
+	}
+}
+
+// SetLoopLimit sets the number of iterations each while, or foreach,
+// loop may make before the script is aborted with an error which
+// identifies the loop.  The default is vm.DefaultLoopLimit.
+//
+// Scripts may override the limit for their loops with a pragma, such
+// as `// pragma loop-limit 5000`, which applies to the loops that
+// follow it.  A limit of zero disables the check.
+func (e *Eval) SetLoopLimit(limit int) {
+	e.iterations = limit
+	if e.machine != nil {
+		e.machine.SetLoopLimit(limit)
+	}
+}
+
+// SetStringerFields controls how the fields of objects which implement
+// the error, or fmt.Stringer, interfaces are presented to scripts.
+//
+// By default such fields are presented as the string they produce, so
+// that values such as net.IP addresses and errors may be compared with
+// string literals.  If enabled structures are presented as hashes of
+// their fields instead, with the string they produce available as their
+// `Error`, or `String`, member.
+func (e *Eval) SetStringerFields(enable bool) {
+	e.stringerFields = enable
+	if e.machine != nil {
+		e.machine.SetStringerFields(enable)
+	}
+}
+
+// SetFieldTag sets the name of the struct tag, such as "json" or
+// "evalfilter", which gives the names scripts use for the fields of
+// structures.  For example with the json tag a script refers to this
+// field as `user_id`:
+//
+//	UserID int `json:"user_id"`
+//
+// Fields without the tag keep their own names, whilst those tagged "-"
+// are hidden from scripts.  By default tags are ignored, which is also
+// the case if the empty string is given.
+func (e *Eval) SetFieldTag(tag string) {
+	e.fieldTag = tag
+	if e.machine != nil {
+		e.machine.SetFieldTag(tag)
+	}
+}
+
+// SetArena controls whether the integers, floats, and strings, which
+// are created as temporaries while a script is running are allocated
+// from an arena, and reused by later runs, which reduces the pressure
+// upon the garbage collector.
+//
+// The arena is enabled by default, and only reuses objects which can't
+// be referred to once the run is over, so disabling it should make no
+// difference to the results.  It may be disabled when debugging.
+func (e *Eval) SetArena(enable bool) {
+	e.arena = enable
+	if e.machine != nil {
+		e.machine.SetArena(enable)
+	}
+}
+
+// SetSQLKeywords controls whether the keywords of scripts are recognized
+// regardless of their case, and whether `AND`, `OR`, and `NOT` may be used
+// in place of `&&`, `||`, and `!`, which eases the migration of rules from
+// SQL-like filters:
+//
+//	IF ( Age > 18 AND NOT Banned ) { RETURN TRUE; }
+//
+// Fields and variables whose names match a keyword, or one of the three
+// aliases, can't be used when this is enabled.  It must be set before the
+// script is prepared.
+func (e *Eval) SetSQLKeywords(enable bool) {
+	e.sql = enable
+}
+
 // Prepare is the second function the caller must invoke, it compiles
 // the user-supplied program to its final-form.
 //
@@ -92,39 +369,116 @@ func (e *Eval) Prepare(flags ...[]byte) error {
 	e.mutex.Lock()
 	defer e.mutex.Unlock()
 
+	return e.prepare(options(flags))
+}
+
+// prepare compiles the user-supplied program with the given options.
+func (e *Eval) prepare(opts PrepareOptions) error {
+
 	//
-	// Default to optimizing the bytecode.
+	// A program which was loaded has no script to compile.
 	//
-	optimize := true
+	if e.loaded {
+		return fmt.Errorf("the program was loaded from bytecode, and can't be prepared")
+	}
 
 	//
-	// But let flags change our behaviour.
+	// Default to optimizing the bytecode, but let the options
+	// change our behaviour.
 	//
-	for _, arg := range flags {
-		for _, val := range arg {
-			if val == NoOptimize {
-				optimize = false
-			}
+	optimize := opts.Optimization != OptimizeNone
+	reorder := opts.Optimization == OptimizeReorder
+
+	if opts.MaxInstructions > 0 {
+		e.maxInstructions = opts.MaxInstructions
+	}
+	if opts.Language != 0 {
+		if err := e.SetLanguage(opts.Language); err != nil {
+			return err
 		}
 	}
 
+	// Counting the clauses of the script means seeing them as they
+	// were written.
+	if e.statistics {
+		optimize = false
+	}
+
+	// Clauses are only reordered by the optimizer.
+	e.reorder = reorder && optimize
+
+	// Forget the warnings, and clauses, of any previous compilation.
+	e.warnings = nil
+	e.warned = nil
+	e.explained = nil
+	e.explaining = nil
+
 	//
 	// Create a lexer.
 	//
 	l := lexer.New(e.Script)
+	l.SetSQLKeywords(e.sql)
 
 	//
 	// Create a parser using the lexer.
 	//
 	p := parser.New(l)
+	p.SetMaxNesting(opts.MaxNesting)
 
 	//
 	// Parse the program into an AST.
 	//
 	program, err := p.Parse()
+	if err != nil {
+		return parseError(err)
+	}
+
+	//
+	// Find any pragmas, which affect the compilation.
+	//
+	e.pragmas, err = parsePragmas(e.Script)
 	if err != nil {
 		return err
 	}
+	e.loops = nil
+	e.targets = nil
+
+	e.prefix = ""
+	if clause := prefixOf(program); clause != nil {
+		e.prefix = clause.String()
+	}
+
+	//
+	// A script may be prepared more than once, with different flags,
+	// so forget the bytecode of any previous compilation.
+	//
+	e.constants = nil
+	e.instructions = nil
+	e.sources = make(code.SourceMap)
+	e.position = code.Position{}
+	e.functions = make(map[string]environment.UserFunction)
+
+	//
+	// If a schema was bound then find the names which can't be its
+	// fields, so the names the script looks up may be checked.
+	//
+	e.bindSchema(program)
+	e.referenced = make(map[string]bool)
+	e.fields = nil
+
+	//
+	// If we're optimizing then find the user-defined functions
+	// which are small enough to be inlined at their call-sites.
+	//
+	e.inlinable = nil
+	e.simplified = nil
+	e.propagated = nil
+	e.known = nil
+	if optimize {
+		e.findInlinable(program)
+		e.findConstants(program)
+		e.simplified = make(map[ast.Expression]bool)
+	}
 
 	//
 	// Compile the program to bytecode
@@ -135,8 +489,19 @@ func (e *Eval) Prepare(flags ...[]byte) error {
 	// If there were errors then return them.
 	//
 	if err != nil {
+		if _, ok := err.(*CompileError); !ok {
+			err = &CompileError{Phase: "compile", Message: err.Error()}
+		}
 		return err
 	}
+	e.fields = e.findFields(program)
+	e.referenced = nil
+	if opts.Strict {
+		if err = e.strict(); err != nil {
+			return err
+		}
+	}
+	e.prepareClauses()
 
 	//
 	// If we've got the optimizer enabled then set the environment
@@ -144,7 +509,7 @@ func (e *Eval) Prepare(flags ...[]byte) error {
 	// run a series of optimizations.
 	//
 	if optimize {
-		e.environment.Set("OPTIMIZE", &object.Boolean{Value: true})
+		e.environment.Set("OPTIMIZE", object.True)
 	}
 
 	//
@@ -155,13 +520,60 @@ func (e *Eval) Prepare(flags ...[]byte) error {
 	// The optimization will happen at this step, so that it is complete
 	// before Execute/Run are invoked - and we only take the speed hit
 	// once.
-	e.machine = vm.New(e.constants, e.instructions, e.functions, e.environment)
+	e.machine = vm.NewWithSources(e.constants, e.instructions, e.sources, e.functions, e.environment)
 
 	//
 	// Setup our context
 	//
 	e.machine.SetContext(e.context)
 
+	//
+	// And any recorder.
+	//
+	e.machine.SetRecorder(e.recorder)
+
+	//
+	// And any tracer.
+	//
+	e.machine.SetTracer(e.tracer)
+
+	//
+	// And the event mode.
+	//
+	e.machine.SetEventMode(e.mode)
+
+	//
+	// And the memory limit.
+	//
+	e.machine.SetMemoryLimit(e.memory)
+
+	//
+	// And the instruction limit.
+	//
+	e.machine.SetMaxInstructions(e.maxInstructions)
+
+	//
+	// And the version of the language.
+	//
+	e.machine.SetLanguage(e.languageOf())
+
+	//
+	// And the loops, along with their limits.
+	//
+	e.machine.SetLoops(e.loops)
+	e.machine.SetLoopLimit(e.iterations)
+
+	//
+	// And how fields which describe themselves are presented.
+	//
+	e.machine.SetStringerFields(e.stringerFields)
+	e.machine.SetFieldTag(e.fieldTag)
+
+	//
+	// And whether temporaries are reused.
+	//
+	e.machine.SetArena(e.arena)
+
 	//
 	// All done; no errors.
 	//
@@ -180,25 +592,8 @@ func (e *Eval) dumper(offset int, opCode code.Opcode, opArg interface{}) (bool,
 	}
 
 	// Some opcodes benefit from inline comments
-	if code.Opcode(opCode) == code.OpConstant {
-		v := e.constants[opArg.(int)]
-		s := strings.ReplaceAll(v.Inspect(), "\n", "\\n")
-		s = strings.ReplaceAll(s, "\r", "\\r")
-		s = strings.ReplaceAll(s, "\t", "\\t")
-		fmt.Printf("\t// push constant onto stack: \"%s\"", s)
-	}
-	if code.Opcode(opCode) == code.OpLookup {
-		v := e.constants[opArg.(int)]
-		s := strings.ReplaceAll(v.Inspect(), "\n", "\\n")
-		s = strings.ReplaceAll(s, "\r", "\\r")
-		s = strings.ReplaceAll(s, "\t", "\\t")
-		fmt.Printf("\t// lookup field/variable: %s", s)
-	}
-	if code.Opcode(opCode) == code.OpCall {
-		fmt.Printf("\t// call function with %d arg(s)", opArg.(int))
-	}
-	if code.Opcode(opCode) == code.OpPush {
-		fmt.Printf("\t// Push %d to stack", opArg.(int))
+	if comment := e.comment(opCode, opArg); comment != "" {
+		fmt.Printf("\t// %s", comment)
 	}
 	fmt.Printf("\n")
 
@@ -253,6 +648,18 @@ func (e *Eval) Dump() error {
 		count++
 	}
 
+	// And the anonymous functions, which are constants.
+	for i, c := range consts {
+		if _, ok := c.(*object.Function); !ok {
+			continue
+		}
+		fmt.Printf("\nAnonymous function %04d: %s\n", i, c.Inspect())
+		err := e.machine.WalkClosureBytecode(i, e.dumper)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -266,11 +673,22 @@ func (e *Eval) Dump() error {
 // Use of this method allows you to receive the `3` that a script
 // such as `return 1 + 2;` would return.
 func (e *Eval) Execute(obj interface{}) (out object.Object, error error) {
+	out, err := execute(e.machine, e.machine.Run, obj)
+	e.countClauses()
+	return out, err
+}
+
+// execute runs a machine against the object, via the given function, and
+// returns the object that the script finished with.
+//
+// The errors the script causes are returned as a *RuntimeError, whilst
+// those which come from the limits the host placed upon it are not.
+func execute(machine *vm.VM, run func(interface{}) (object.Object, error), obj interface{}) (out object.Object, error error) {
 
 	// Catch errors when we're executing.
 	defer func() {
 		if r := recover(); r != nil {
-			out = &object.Null{}
+			out = object.Nil
 			error = fmt.Errorf("error during Run: %s", r)
 		}
 	}()
@@ -278,13 +696,21 @@ func (e *Eval) Execute(obj interface{}) (out object.Object, error error) {
 	//
 	// Launch the program in the VM.
 	//
-	out, err := e.machine.Run(obj)
+	out, err := run(obj)
 
 	//
 	// Error executing?  Report that.
 	//
 	if err != nil {
-		return &object.Null{}, err
+		if _, budget := err.(*vm.InstructionLimitError); budget {
+			return object.Nil, err
+		}
+		return object.Nil, &RuntimeError{
+			Phase:    "run",
+			Position: machine.ErrorPosition(),
+			Message:  err.Error(),
+			Err:      err,
+		}
 	}
 
 	//
@@ -324,7 +750,115 @@ func (e *Eval) Run(obj interface{}) (bool, error) {
 	// Otherwise case the resulting object into
 	// a boolean and pass that back to the caller.
 	//
-	return out.True(), nil
+	return e.machine.True(out), nil
+}
+
+// RunContext executes the program, as Run does, aborting with an error if
+// the given context is cancelled, or its deadline passes, first.
+//
+// The context is used for this run alone, in place of any which was set
+// via SetContext.
+func (e *Eval) RunContext(ctx context.Context, obj interface{}) (bool, error) {
+
+	e.mutex.Lock()
+	out, err := execute(e.machine, func(obj interface{}) (object.Object, error) {
+		return e.machine.RunContext(ctx, obj)
+	}, obj)
+	e.countClauses()
+	e.mutex.Unlock()
+
+	if err != nil {
+		return false, err
+	}
+	return e.machine.True(out), nil
+}
+
+// RunBool executes the program, and returns the boolean it returned.
+//
+// Unlike Run, which treats any value as true or false, an error is
+// returned if the script returns anything other than a boolean.
+func (e *Eval) RunBool(obj interface{}) (bool, error) {
+
+	out, err := e.runTyped(obj, object.BOOLEAN)
+	if err != nil {
+		return false, err
+	}
+	return out.(*object.Boolean).Value, nil
+}
+
+// RunFloat executes the program, and returns the number it returned.
+//
+// Integers are converted to floats, any other type of value results in
+// an error.
+func (e *Eval) RunFloat(obj interface{}) (float64, error) {
+
+	out, err := e.runTyped(obj, object.FLOAT, object.INTEGER)
+	if err != nil {
+		return 0, err
+	}
+	if i, ok := out.(*object.Integer); ok {
+		return float64(i.Value), nil
+	}
+	return out.(*object.Float).Value, nil
+}
+
+// RunInt executes the program, and returns the integer it returned.
+//
+// Floats are accepted if they hold a whole number, any other type of value
+// results in an error.
+func (e *Eval) RunInt(obj interface{}) (int64, error) {
+
+	out, err := e.runTyped(obj, object.INTEGER, object.FLOAT)
+	if err != nil {
+		return 0, err
+	}
+	if f, ok := out.(*object.Float); ok {
+		i := int64(f.Value)
+		if float64(i) != f.Value {
+			return 0, fmt.Errorf("the script returned %s, which is not a whole number", f.Inspect())
+		}
+		return i, nil
+	}
+	return out.(*object.Integer).Value, nil
+}
+
+// RunString executes the program, and returns the string it returned.
+//
+// An error is returned if the script returns anything other than a
+// string.
+func (e *Eval) RunString(obj interface{}) (string, error) {
+
+	out, err := e.runTyped(obj, object.STRING)
+	if err != nil {
+		return "", err
+	}
+	return out.(*object.String).Value, nil
+}
+
+// runTyped executes the program, and ensures that the object it returned
+// is of one of the given types - the first of which is the type we'd
+// ideally like.
+func (e *Eval) runTyped(obj interface{}, types ...object.Type) (object.Object, error) {
+
+	e.mutex.Lock()
+	out, err := e.Execute(obj)
+	e.mutex.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range types {
+		if out.Type() == t {
+			return out, nil
+		}
+	}
+
+	// Quote strings, so that "3" isn't mistaken for 3.
+	val := out.Inspect()
+	if str, ok := out.(*object.String); ok {
+		val = fmt.Sprintf("%q", str.Value)
+	}
+	return nil, fmt.Errorf("the script returned %s %s, rather than %s", out.Type(), val, types[0])
 }
 
 // AddFunction exposes a golang function from your host application
@@ -335,20 +869,61 @@ func (e *Eval) AddFunction(name string, fun interface{}) {
 	e.environment.SetFunction(name, fun)
 }
 
+// EnablePackage makes the functions within the named package available
+// to the filter script.
+//
+// By default only a minimal set of functions is available, additional
+// packages such as "strings", "net", "crypto", "time", "json", "mime", and
+// "units" must be enabled explicitly.  This should be done before Prepare is invoked.
+//
+// An error is returned if the package is unknown.
+func (e *Eval) EnablePackage(name string) error {
+	return e.environment.EnablePackage(name)
+}
+
 // SetVariable adds, or updates a variable which will be available
 // to the filter script.
 func (e *Eval) SetVariable(name string, value object.Object) {
 	e.environment.Set(name, value)
 }
 
+// SetVariableInt sets the value of a variable to the given integer.
+//
+// Hosts which set variables before each run should prefer this, and its
+// siblings, to SetVariable, as the object which holds the value is
+// reused when that is safe, rather than a new one being allocated each
+// time.
+func (e *Eval) SetVariableInt(name string, value int64) {
+	e.environment.SetInteger(name, value)
+}
+
+// SetVariableFloat sets the value of a variable to the given float.
+func (e *Eval) SetVariableFloat(name string, value float64) {
+	e.environment.SetFloat(name, value)
+}
+
+// SetVariableString sets the value of a variable to the given string.
+func (e *Eval) SetVariableString(name string, value string) {
+	e.environment.SetString(name, value)
+}
+
+// SetVariableBool sets the value of a variable to the given boolean.
+func (e *Eval) SetVariableBool(name string, value bool) {
+	e.environment.SetBoolean(name, value)
+}
+
 // GetVariable retrieves the contents of a variable which has been
 // set within a user-script.
 //
 // If the variable hasn't been set then the null-value will be returned.
 func (e *Eval) GetVariable(name string) object.Object {
+	// The caller might keep the value, so it may no longer be
+	// updated in place.
+	e.environment.Disown()
+
 	value, ok := e.environment.Get(name)
 	if ok {
 		return value
 	}
-	return &object.Null{}
+	return object.Nil
 }
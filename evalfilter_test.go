@@ -2,13 +2,25 @@ package evalfilter
 
 import (
 	"context"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"net"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
+	"github.com/skx/evalfilter/v2/code"
 	"github.com/skx/evalfilter/v2/object"
+	"github.com/skx/evalfilter/v2/object/objecttest"
+	"github.com/skx/evalfilter/v2/vm"
 )
 
 // TestLess tests uses `>` and `>=`.
@@ -889,6 +901,140 @@ func TestArrayObject(t *testing.T) {
 
 // TestArrayMap tests that using reflection to get array values works
 // for basic types.
+// TestHostCollections ensures we can iterate over arbitrary slices, arrays,
+// and maps belonging to the object we're filtering.
+func TestHostCollections(t *testing.T) {
+
+	type Event struct {
+		Ports    []int32
+		Sizes    [3]uint16
+		Tags     map[string]int
+		Codes    map[int]string
+		Nested   [][]string
+		Children []map[string]interface{}
+		Any      []interface{}
+	}
+
+	event := Event{
+		Ports:  []int32{22, 80, 443},
+		Sizes:  [3]uint16{1, 2, 3},
+		Tags:   map[string]int{"b": 2, "a": 1},
+		Codes:  map[int]string{404: "Not Found", 200: "OK"},
+		Nested: [][]string{{"a", "b"}, {"c"}},
+		Children: []map[string]interface{}{
+			{"Name": "Bart", "Age": 10},
+			{"Name": "Lisa", "Age": 8},
+		},
+		Any: []interface{}{1, "two", []int{3}, nil},
+	}
+
+	tests := []string{
+		`sum = 0; foreach port in Ports { sum += port; } return sum == 545;`,
+		`sum = 0; foreach size in Sizes { sum += size; } return sum == 6;`,
+		`out = ""; foreach k, v in Tags { out = out + k + string(v); } return out == "a1b2";`,
+		`return Codes[404] == "Not Found" && len(keys(Codes)) == 2;`,
+		`count = 0; foreach row in Nested { foreach cell in row { count++; } } return count == 3;`,
+		`ages = 0; foreach child in Children { ages += child.Age; } return ages == 18 && Children[1].Name == "Lisa";`,
+		`return len(Any) == 4 && Any[1] == "two" && Any[2][0] == 3 && type(Any[3]) == "null";`,
+	}
+
+	for _, src := range tests {
+
+		obj := New(src)
+
+		err := obj.Prepare()
+		if err != nil {
+			t.Fatalf("Failed to compile %s: %s", src, err.Error())
+		}
+
+		for _, input := range []interface{}{event, &event} {
+			ret, err := obj.Run(input)
+			if err != nil {
+				t.Fatalf("Found unexpected error running %s: %s", src, err.Error())
+			}
+			if !ret {
+				t.Fatalf("Found unexpected result running %s", src)
+			}
+		}
+	}
+}
+
+// TestLazyIteration tests iterating over values which are produced
+// on-demand, rather than held in an array.
+func TestLazyIteration(t *testing.T) {
+
+	// rows returns an iterator producing the given number of rows,
+	// and records the largest row which has been produced.
+	produced := 0
+	rows := func(count int) *object.Iterator {
+		n := 0
+		return object.NewIterator(func() (object.Object, bool) {
+			if n >= count {
+				return nil, false
+			}
+			n++
+			produced = n
+			return &object.Integer{Value: int64(n)}, true
+		})
+	}
+
+	type Input struct {
+		Queue <-chan string
+		Rows  *object.Iterator
+		None  chan int
+	}
+
+	tests := []struct {
+		Input  string
+		Result bool
+	}{
+		{Input: `count = 0; foreach piece in lazysplit("a,b,,c", ",") { count++; } return count == 4;`, Result: true},
+		{Input: `out = ""; foreach i, piece in lazysplit("a-b-c", "-") { out = out + string(i) + piece; } return out == "0a1b2c";`, Result: true},
+		{Input: `out = ""; foreach c in lazysplit("äbc", "") { out = out + c + "."; } return out == "ä.b.c.";`, Result: true},
+		{Input: `x = lazysplit("a b", " "); return type(x) == "iterator" && !len(x);`, Result: true},
+		{Input: `out = ""; foreach msg in Queue { out = out + msg; } return out == "onetwothree";`, Result: true},
+		{Input: `sum = 0; foreach row in Rows { sum += row; if ( row == 3 ) { return sum == 6; } } return false;`, Result: true},
+		{Input: `count = 0; foreach x in None { count++; } return count == 0;`, Result: false},
+	}
+
+	for _, tst := range tests {
+
+		obj := New(tst.Input)
+
+		err := obj.Prepare()
+		if err != nil {
+			t.Fatalf("Failed to compile %s: %s", tst.Input, err.Error())
+		}
+
+		queue := make(chan string, 3)
+		queue <- "one"
+		queue <- "two"
+		queue <- "three"
+		close(queue)
+
+		produced = 0
+		in := Input{Queue: queue, Rows: rows(1000)}
+
+		ret, err := obj.Run(in)
+		if tst.Result {
+			if err != nil {
+				t.Fatalf("Found unexpected error running %s: %s", tst.Input, err.Error())
+			}
+			if !ret {
+				t.Fatalf("Found unexpected result running %s", tst.Input)
+			}
+		} else if err == nil {
+			t.Fatalf("Expected an error running %s", tst.Input)
+		}
+
+		// Returning from within the loop means we never
+		// produced the remaining rows.
+		if produced > 3 {
+			t.Fatalf("rows were not produced lazily: %d", produced)
+		}
+	}
+}
+
 func TestArrayMap(t *testing.T) {
 
 	// string-test
@@ -1158,6 +1304,79 @@ foreach index,name in [ "Bob", "Chris" ] {
 if ( name != "Steve") {    print( "Test failed: name is changed\n");  return false; }
 if ( index ) {    print( "Test FAILED: index is set: ", index, "\n"); return false; }
 return true;
+`,
+			Result: true},
+
+		// hashes yield key/value in key-order
+		{Input: `
+out = "";
+foreach key, value in { "c": 3, "a": 1, "b": 2 } {
+   out = out + key + "=" + string(value) + ";";
+}
+return out == "a=1;b=2;c=3;";
+`,
+			Result: true},
+
+		// hashes yield values in key-order
+		{Input: `
+sum = "";
+foreach value in { "c": "3", "a": "1", "b": "2" } { sum += value; }
+return sum == "123";
+`,
+			Result: true},
+
+		// updating a hash whilst iterating over it is fine
+		{Input: `
+h = { "a": 1, "b": 2, "c": 3 };
+count = 0;
+foreach key, value in h {
+   h[key] = value * 10;
+   h[key + key] = value;
+   count++;
+}
+return count == 3 && h["b"] == 20 && h["bb"] == 2;
+`,
+			Result: true},
+
+		// nested loops over the same object are independent
+		{Input: `
+a = [ 1, 2, 3 ];
+count = 0;
+foreach x in a { foreach y in a { count++; } }
+h = { "a": 1, "b": 2 };
+foreach k, v in h { foreach k2, v2 in h { count++; } }
+s = "ab";
+foreach c in s { foreach d in s { count++; } }
+return count == 9 + 4 + 4;
+`,
+			Result: true},
+
+		// inner loop-variables don't alter the outer ones
+		{Input: `
+out = "";
+foreach i, x in [ "a", "b" ] {
+   foreach i, x in [ "c", "d", "e" ] { }
+   out = out + string(i) + x;
+}
+return out == "0a1b";
+`,
+			Result: true},
+
+		// functions don't alter the loop-variables of their caller
+		{Input: `
+function twice( x ) { x = x * 2; return x; }
+out = 0;
+foreach x in [ 1, 2, 3 ] { out = out + twice( x ) + x; }
+return out == 18;
+`,
+			Result: true},
+
+		// arrays and strings both yield index/value
+		{Input: `
+out = "";
+foreach i, v in [ "x", "y" ] { out = out + string(i) + v; }
+foreach i, c in "狐犬" { out = out + string(i) + c; }
+return out == "0x1y0狐1犬";
 `,
 			Result: true},
 	}
@@ -1181,6 +1400,46 @@ return true;
 	}
 }
 
+// TestForeachReturn ensures that returning from inside a loop doesn't
+// leave the loop's variables behind.
+func TestForeachReturn(t *testing.T) {
+
+	obj := New(`
+function find( items ) {
+   foreach item in items { if ( item == "b" ) { return true; } }
+   return false;
+}
+foreach x in [ "a", "b" ] {
+   if ( find( [ x ] ) ) { return true; }
+}
+return false;
+`)
+
+	err := obj.Prepare()
+	if err != nil {
+		t.Fatalf("Failed to compile: %s", err.Error())
+	}
+
+	for i := 0; i < 5; i++ {
+		ret, err := obj.Run(nil)
+		if err != nil {
+			t.Fatalf("Found unexpected error running script: %s", err.Error())
+		}
+		if !ret {
+			t.Fatalf("Found unexpected result running script")
+		}
+	}
+
+	for _, name := range []string{"x", "item", "items"} {
+		if obj.GetVariable(name).Type() != object.NULL {
+			t.Fatalf("variable %s leaked from a loop", name)
+		}
+	}
+	if obj.environment.ScopeDepth() != 0 {
+		t.Fatalf("scopes leaked: %d", obj.environment.ScopeDepth())
+	}
+}
+
 // TestTernary checks our simple ternary expression(s)
 func TestTernary(t *testing.T) {
 
@@ -1363,18 +1622,8 @@ func TestMutators(t *testing.T) {
 	}
 }
 
-// Test identifiers can have underscores.
-func TestUnderscore(t *testing.T) {
-
-	// Dummy structure to test field-access.
-	type Structure struct {
-		//lint:ignore ST1003 This is a test to ensure that underscores are permitted.
-		foo_bar int
-	}
-
-	// Instance of object
-	var object Structure
-	object.foo_bar = 3
+// TestIndexedMutators ensures we can update array/hash members.
+func TestIndexedMutators(t *testing.T) {
 
 	type Test struct {
 		Input  string
@@ -1382,209 +1631,1020 @@ func TestUnderscore(t *testing.T) {
 	}
 
 	tests := []Test{
-		{Input: `if ( foo_bar == 3 ) { return true; } return false;`, Result: true},
+		{Input: `a = [1, 2, 3]; a[0] = 7; return( a[0] == 7 );`, Result: true},
+		{Input: `a = [1, 2, 3]; a[1]++; a[2]--; return( a[1] == 3 && a[2] == 2 );`, Result: true},
+		{Input: `a = [1, 2, 3]; a[0] += 2; a[1] *= 4; a[2] /= 3; return( a[0] == 3 && a[1] == 8 && a[2] == 1 );`, Result: true},
+		{Input: `a = [1.5]; a[0]++; return( a[0] == 2.5 );`, Result: true},
+		{Input: `h = {}; h["x"] = 3; h["x"] -= 1; return( h["x"] == 2 );`, Result: true},
+		{Input: `h = { "a": { "b": 1 } }; h.a.b = 3; h.a.c = 4; return( h.a.b + h["a"].c == 7 );`, Result: true},
+		{Input: `h = { "a": [1, 2] }; h.a[1]++; return( h.a[1] == 3 );`, Result: true},
+		{Input: `h = { "name": "Steve" }; h.name += " Kemp"; return( h.name == "Steve Kemp" );`, Result: true},
+		{Input: `counts = {};
+foreach word in split( "the cat sat on the mat", " " ) {
+  counts[word]++;
+}
+return( counts["the"] == 2 && counts["cat"] == 1 && len(keys(counts)) == 5 );`, Result: true},
+		{Input: `h = {}; h["up"]++; h["down"]--; h["sum"] += 2.5; h["debt"] -= 3; return( h["up"] == 1 && h["down"] == -1 && h["sum"] == 2.5 && h["debt"] == -3 );`, Result: true},
+		{Input: `h = { "a": {} }; h.a.b++; return( h.a.b == 1 );`, Result: true},
 	}
 
 	for _, tst := range tests {
 
 		obj := New(tst.Input)
 
-		p := obj.Prepare([]byte{NoOptimize})
+		p := obj.Prepare()
 		if p != nil {
-			t.Fatalf("Failed to compile")
+			t.Fatalf("Failed to compile '%s': %s", tst.Input, p.Error())
 		}
 
-		ret, err := obj.Run(object)
+		ret, err := obj.Run(nil)
 		if err != nil {
 			t.Fatalf("Found unexpected error running test '%s' - %s\n", tst.Input, err.Error())
 		}
 
 		if ret != tst.Result {
-			t.Fatalf("Found unexpected result running script")
+			t.Fatalf("Found unexpected result running script '%s'", tst.Input)
 		}
 	}
-}
 
-func TestPanic(t *testing.T) {
-	input := `
+	// Now test some error-cases
+	errors := []string{
+		`a = "steve"; a[0] = "S";`,
+		`a = [1, 2]; a[3] = 1;`,
+		`a = [1, 2]; a["x"]++;`,
+		`h = {}; h["count"] *= 2;`,
+		`h = { "count": null }; h["count"]++;`,
+	}
 
-panic("I'm a fish");
-return true ;
-`
+	for _, tst := range errors {
 
-	obj := New(input)
-	err := obj.Prepare()
-	if err != nil {
-		t.Fatalf("Failed to compile")
-	}
+		obj := New(tst)
 
-	_, err = obj.Run(nil)
-	if err == nil {
-		t.Fatalf("expected error, got none")
-	}
-	if !strings.Contains(err.Error(), "panic in Run") {
-		fmt.Printf("Wrong value")
-	}
-	if !strings.Contains(err.Error(), "fish") {
-		fmt.Printf("Wrong value")
-	}
+		p := obj.Prepare()
+		if p != nil {
+			t.Fatalf("Failed to compile '%s': %s", tst, p.Error())
+		}
 
-	_, err = obj.Execute(nil)
-	if err == nil {
-		t.Fatalf("expected error, got none")
-	}
-	if !strings.Contains(err.Error(), "panic in Run") {
-		fmt.Printf("Wrong value")
-	}
-	if !strings.Contains(err.Error(), "fish") {
-		fmt.Printf("Wrong value")
+		_, err := obj.Run(nil)
+		if err == nil {
+			t.Fatalf("Expected error running '%s', got none", tst)
+		}
 	}
-
 }
 
-// Test access under different goroutines
-func TestRace(t *testing.T) {
+// TestMatch tests our match expression.
+func TestMatch(t *testing.T) {
 
-	input := []string{
-		`{"bob": "3", "seventee": 17}`,
-		`{"simon": "3", "name": "kissa"}`,
-		`{"chris": "3", "name": "pizza"}`,
-		`{"homer": "3", "name": "Yes"}`,
-		`{"daryl": "33", "name": "pizza"}`,
-		`{"alicia": "13", "name": "Yes"}`,
-		`{"susan": "443", "name": "pizza"}`,
-		`{"steve": "333", "name": "Yes"}`,
+	type Test struct {
+		Input  string
+		Result bool
 	}
 
-	obj := New(`if ( name) { return true; } return false;`)
+	// classify returns a description of the event held in `e`.
+	classify := `
+function classify(e) {
+  match e {
+    { type: "login", fail: true, user: who } => { return "failed login by " + who; }
+    { type: "login" } => return "login";,
+    { type: "http", status: 404 } => return "missing";,
+    { type: "http", request: { path: /^\/admin/ } } => return "admin";,
+    { type: "http", status: code } => return "http " + string(code);,
+    "ping" => return "pong";,
+    -1 => return "minus one";,
+    3.5 => return "float";,
+    true => return "true";,
+    _ => return "unknown";
+  }
+}
+`
 
-	err := obj.Prepare()
-	if err != nil {
-		t.Fatalf("error preparing")
+	tests := []Test{
+		{Input: `return classify({ "type": "login", "fail": true, "user": "bob" }) == "failed login by bob";`, Result: true},
+		{Input: `return classify({ "type": "login", "fail": false, "user": "bob" }) == "login";`, Result: true},
+		{Input: `return classify({ "type": "login" }) == "login";`, Result: true},
+		{Input: `return classify({ "type": "http", "status": 404 }) == "missing";`, Result: true},
+		{Input: `return classify({ "type": "http", "status": 200, "request": { "path": "/admin/users" } }) == "admin";`, Result: true},
+		{Input: `return classify({ "type": "http", "status": 200, "request": { "path": "/index.html" } }) == "http 200";`, Result: true},
+		{Input: `return classify({ "type": "http", "request": { "method": "GET" } }) == "unknown";`, Result: true},
+		{Input: `return classify({ "type": "http", "request": "/admin" }) == "unknown";`, Result: true},
+		{Input: `return classify("ping") == "pong";`, Result: true},
+		{Input: `return classify(-1) == "minus one";`, Result: true},
+		{Input: `return classify(3.5) == "float";`, Result: true},
+		{Input: `return classify(true) == "true";`, Result: true},
+		{Input: `return classify(false) == "unknown";`, Result: true},
+		{Input: `return classify([1, 2]) == "unknown";`, Result: true},
+
+		// Bindings are only made when an arm matches.
+		{Input: `who = "nobody"; classify({ "type": "login", "user": "bob" }); return who == "nobody";`, Result: true},
+
+		// The value is evaluated only once.
+		{Input: `count = 0;
+function next() { count++; return count; }
+match next() { 1 => { x = "one"; }, 2 => { x = "two"; } }
+return x == "one" && count == 1;`, Result: true},
+
+		// No arm matching is not an error.
+		{Input: `x = 1; match "a" { "b" => x = 2; } return x == 1;`, Result: true},
+
+		// Bare identifiers bind the value itself.
+		{Input: `match 3 + 4 { n => { y = n * 2; } } return y == 14;`, Result: true},
+
+		// Integer keys, and nested patterns.
+		{Input: `h = { 1: { "a": "b" } }; match h { { 1: { a: v } } => return v == "b";, _ => return false; }`, Result: true},
+
+		// match is still our regular-expression function.
+		{Input: `return match("Steve", "^St");`, Result: true},
+
+		// Loops within arms, and matches within loops
+		{Input: `sum = 0;
+foreach e in [ { "n": 1 }, { "n": 2 }, { "m": 3 }, "x" ] {
+  match e {
+    { n: v } => { foreach i in 1..v { sum += i; } }
+    { m: _ } => sum += 100;
+  }
+}
+return sum == 104;`, Result: true},
 	}
 
-	var wg sync.WaitGroup
+	for _, tst := range tests {
 
-	for i := 0; i < len(input); i++ {
-		wg.Add(1)
-		go func(line string) {
+		for _, flags := range [][]byte{nil, {NoOptimize}} {
 
-			// decode the line into JSON
-			jsonMap := make(map[string]interface{})
-			jsonErr := json.Unmarshal([]byte(line), &jsonMap)
-			if jsonErr != nil {
-				t.Errorf("error decoding json")
+			obj := New(classify + tst.Input)
+
+			p := obj.Prepare(flags)
+			if p != nil {
+				t.Fatalf("Failed to compile '%s': %s", tst.Input, p.Error())
 			}
 
-			_, err := obj.Run(jsonMap)
+			ret, err := obj.Run(nil)
 			if err != nil {
-				t.Errorf("Found unexpected error running test %s\n",
-					err.Error())
+				t.Fatalf("Found unexpected error running test '%s' - %s\n", tst.Input, err.Error())
 			}
 
-			wg.Done()
+			if ret != tst.Result {
+				t.Fatalf("Found unexpected result running script '%s'", tst.Input)
+			}
+		}
+	}
 
-		}(input[i])
+	// Now test some parse-errors.
+	errors := []string{
+		`match x { `,
+		`match x { 1 }`,
+		`match x { {} => return 1; }`,
+		`match x { 1 + 2 => return 1; }`,
+		`match x { { a 1 } => return 1; }`,
+		`match x { { [1]: 1 } => return 1; }`,
+	}
 
+	for _, tst := range errors {
+		obj := New(tst)
+		if obj.Prepare() == nil {
+			t.Fatalf("Expected error compiling '%s', got none", tst)
+		}
 	}
+}
 
-	wg.Wait()
+// TestSwitchJumpTable tests switch-statements which are dispatched via
+// a jump-table, both with and without the optimizer.
+func TestSwitchJumpTable(t *testing.T) {
+
+	script := `
+// Some maths the optimizer will fold, changing our offsets.
+offset = 1 + 2 * 3;
+
+function kind( name ) {
+  result = "none";
+  if ( 1 == 1 ) { result = "unknown"; }
+  switch( name ) {
+    case "login", "logout" { result = "auth"; }
+    case "get", "put", "post" { result = "http"; }
+    case "get" { result = "never"; }
+    case "ssh" { result = offset + 3; }
+    default { result = "default"; }
+  }
+  return result;
 }
 
-// Test we can handle null values set in JSON objects
-func TestNullJsonField(t *testing.T) {
-	input := []string{
-		`{"event_count": null, "source": "************"}`,
-		`{"event_count": "332", "source": "************"}`,
+out = "";
+switch( Event ) {
+  case "a" { out = "A"; }
+  case "b" { out = "B"; }
+  case "c" { out = "C"; }
+  case "d" { out = "D"; }
+}
+
+return string(kind(Name)) + ":" + string(out);
+`
+
+	type Input struct {
+		Name  interface{}
+		Event string
 	}
 
-	obj := New(`if ( source == "************") { return true; } return false;`)
+	tests := []struct {
+		Input  Input
+		Result string
+	}{
+		{Input: Input{Name: "login", Event: "a"}, Result: "auth:A"},
+		{Input: Input{Name: "logout", Event: "d"}, Result: "auth:D"},
+		{Input: Input{Name: "get", Event: "b"}, Result: "http:B"},
+		{Input: Input{Name: "post", Event: "c"}, Result: "http:C"},
+		{Input: Input{Name: "ssh", Event: "x"}, Result: "10:"},
+		{Input: Input{Name: "other", Event: ""}, Result: "default:"},
+		{Input: Input{Name: 3, Event: "a"}, Result: "default:A"},
+	}
 
-	for _, line := range input {
+	for _, flags := range [][]byte{nil, {NoOptimize}} {
 
-		err := obj.Prepare()
+		obj := New(script)
+
+		err := obj.Prepare(flags)
 		if err != nil {
-			t.Fatalf("error preparing")
+			t.Fatalf("Failed to compile: %s", err.Error())
 		}
 
-		// decode the line into JSON
-		jsonMap := make(map[string]interface{})
-		jsonErr := json.Unmarshal([]byte(line), &jsonMap)
-		if jsonErr != nil {
-			t.Errorf("error decoding json")
+		// Ensure we actually generated a jump-table.
+		found := false
+		for _, c := range obj.instructions {
+			if code.Opcode(c) == code.OpJumpTable {
+				found = true
+			}
+		}
+		if !found {
+			t.Fatalf("expected a jump-table to be generated")
 		}
 
-		_, err = obj.Run(jsonMap)
-		if err != nil {
-			t.Errorf("Found unexpected error running test %s\n",
-				err.Error())
+		for _, tst := range tests {
+			ret, err := obj.Execute(tst.Input)
+			if err != nil {
+				t.Fatalf("Found unexpected error running with %v: %s", tst.Input, err.Error())
+			}
+			if ret.Inspect() != tst.Result {
+				t.Fatalf("Found unexpected result running with %v: %s != %s", tst.Input, ret.Inspect(), tst.Result)
+			}
 		}
 	}
 }
 
-func TestSplitJoin(t *testing.T) {
-	input := `
-tmp = "XXX";
-out = join( split( tmp, " " ), "-" );
+// TestInline tests that small user-defined functions behave identically
+// whether they are inlined or not.
+func TestInline(t *testing.T) {
 
-return out ;
-`
-	// The value we'll search/replace on
-	name := "This is a file name with spaces / stuff.docx"
+	type Input struct {
+		Name  string
+		User  map[string]string
+		Count int
+	}
 
-	// Replace the string in our template-program
-	input = strings.ReplaceAll(input, "XXX", name )
+	tests := []struct {
+		Script string
+		Result string
+		Inline bool
+	}{
+		// simple helper
+		{Script: `function is_admin(u) { return u.Role == "admin"; }
+return is_admin(User);`, Result: "true", Inline: true},
 
-	// The expected output will be name =~ s/ /-/g
-	nameOut := strings.ReplaceAll(name, " ", "-");
+		// literal arguments
+		{Script: `function double(n) { return n * 2; }
+return double(3) + double(Count);`, Result: "20", Inline: true},
 
-	// parse/prepare
-	obj := New(input)
-	err := obj.Prepare()
-	if err != nil {
-		t.Fatalf("Failed to compile: %s",err)
-	}
+		// helpers calling helpers, and builtins
+		{Script: `function lc(s) { return lower(s); }
+function is(s, v) { return lc(s) == lc(v); }
+return is(Name, "STEVE");`, Result: "true", Inline: true},
 
-	// execute
-	out, err2 := obj.Execute(nil)
-	if err2 != nil {
-		t.Fatalf("unexpected error:%s", err2)
-	}
+		// a parameter of the caller is visible to the callee
+		{Script: `function name() { return who; }
+function greet(who) { return "Hello " + name(); }
+return greet(Name);`, Result: "Hello Steve", Inline: true},
 
-	// confirm
-	if out.Inspect() != nameOut {
-		t.Fatalf("failed split/join test got %s not %s", out.Inspect(), nameOut)
+		// the parameter shadows a global
+		{Script: `n = 10;
+function inc(n) { return n + 1; }
+return inc(1) + n;`, Result: "12", Inline: true},
+
+		// recursion is never inlined
+		{Script: `function fact(n) { return n <= 1 ? 1 : n * fact(n - 1); }
+return fact(5);`, Result: "120", Inline: false},
+
+		// arguments with side-effects are not inlined
+		{Script: `x = 1;
+function bump() { x++; return x; }
+function twice(n) { return n + n; }
+return twice(bump()) + x;`, Result: "6", Inline: false},
+
+		// bodies with more than a return are not inlined
+		{Script: `function f(n) { n = n + 1; return n; }
+return f(1);`, Result: "2", Inline: false},
+
+		// a host-function shadows a user-defined one
+		{Script: `function len(s) { return 99; }
+return len(Name);`, Result: "5", Inline: false},
+	}
+
+	for _, tst := range tests {
+		for _, flags := range [][]byte{nil, {NoOptimize}} {
+
+			obj := New(tst.Script)
+
+			err := obj.Prepare(flags)
+			if err != nil {
+				t.Fatalf("Failed to compile %s: %s", tst.Script, err.Error())
+			}
+
+			ret, err := obj.Execute(Input{Name: "Steve", User: map[string]string{"Role": "admin"}, Count: 7})
+			if err != nil {
+				t.Fatalf("Found unexpected error running %s: %s", tst.Script, err.Error())
+			}
+			if ret.Inspect() != tst.Result {
+				t.Fatalf("Found unexpected result running %s: %s != %s", tst.Script, ret.Inspect(), tst.Result)
+			}
+
+			// Were the calls to user-defined functions removed?
+			calls := false
+			name := ""
+			err = obj.machine.WalkBytecode(func(offset int, opCode code.Opcode, opArg interface{}) (bool, error) {
+				if opCode == code.OpConstant {
+					name = obj.constants[opArg.(int)].Inspect()
+				}
+				if _, ok := obj.functions[name]; ok && opCode == code.OpCall {
+					calls = true
+				}
+				return true, nil
+			})
+			if err != nil {
+				t.Fatalf("unexpected error walking bytecode: %s", err.Error())
+			}
+
+			inlined := flags == nil && tst.Inline
+			if calls == inlined {
+				t.Fatalf("unexpected inlining result for %s with %v: calls:%t", tst.Script, flags, calls)
+			}
+		}
+	}
+
+	// Once inlined the maths can be folded away.
+	obj := New(`function double(n) { return n * 2; } return double(3);`)
+	err := obj.Prepare()
+	if err != nil {
+		t.Fatalf("Failed to compile: %s", err.Error())
+	}
+	err = obj.machine.WalkBytecode(func(offset int, opCode code.Opcode, opArg interface{}) (bool, error) {
+		if opCode == code.OpMul || opCode == code.OpCall {
+			t.Fatalf("found %s, the call should have been inlined and folded", code.String(opCode))
+		}
+		return true, nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error walking bytecode: %s", err.Error())
 	}
 }
 
+// TestCallFrames tests the handling of calls to user-defined functions.
+func TestCallFrames(t *testing.T) {
 
-func TestReplace(t *testing.T) {
-	input := `
+	script := `
+function count(n) {
+  if ( n == 0 ) { return 0; }
+  return 1 + count(n - 1);
+}
+function forever(n) { return forever(n); }
+// Neither of these may be inlined, so they appear in the call-stack.
+function inner(n) { local x; x = n; return x.Missing[1]; }
+function outer(n) { printf(""); return inner(n); }
+
+if ( Mode == "deep" ) { return count(5000); }
+if ( Mode == "forever" ) { return forever(1); }
+if ( Mode == "error" ) { return outer(1); }
+return "ok";
+`
 
-input = "XXX";
-out   = replace(input, /[ \\t]/, "_");
+	type Input struct {
+		Mode string
+	}
 
-return out ;
+	tests := []struct {
+		Mode   string
+		Result string
+		Error  []string
+	}{
+		{Mode: "deep", Result: "5000"},
+		{Mode: "forever", Error: []string{"maximum call-depth"}},
+		{Mode: "error", Error: []string{"in inner:", "<- outer:", "<- main:"}},
+
+		// After an error within a function we can still run.
+		{Mode: "", Result: "ok"},
+	}
+
+	for _, flags := range [][]byte{nil, {NoOptimize}} {
+
+		obj := New(script)
+		err := obj.Prepare(flags)
+		if err != nil {
+			t.Fatalf("Failed to compile: %s", err.Error())
+		}
+
+		for _, tst := range tests {
+			ret, err := obj.Execute(Input{Mode: tst.Mode})
+
+			if len(tst.Error) > 0 {
+				if err == nil {
+					t.Fatalf("expected an error running %s, got none", tst.Mode)
+				}
+				for _, msg := range tst.Error {
+					if !strings.Contains(err.Error(), msg) {
+						t.Fatalf("error '%s' did not contain '%s'", err.Error(), msg)
+					}
+				}
+				continue
+			}
+
+			if err != nil {
+				t.Fatalf("Found unexpected error running %s: %s", tst.Mode, err.Error())
+			}
+			if ret.Inspect() != tst.Result {
+				t.Fatalf("Found unexpected result running %s: %s != %s", tst.Mode, ret.Inspect(), tst.Result)
+			}
+		}
+	}
+}
+
+// TestEnablePackage tests enabling optional packages of functions.
+func TestEnablePackage(t *testing.T) {
+
+	script := `return in_cidr(IP, "10.0.0.0/8") && starts_with(Name, "St");`
+
+	type Input struct {
+		IP   string
+		Name string
+	}
+
+	// Without the packages the functions don't exist.
+	obj := New(script)
+	err := obj.Prepare()
+	if err != nil {
+		t.Fatalf("Failed to compile: %s", err.Error())
+	}
+	_, err = obj.Run(Input{IP: "10.1.1.1", Name: "Steve"})
+	if err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Fatalf("expected an error calling a function from a disabled package, got %v", err)
+	}
+
+	// Unknown packages are an error.
+	obj = New(script)
+	err = obj.EnablePackage("steve")
+	if err == nil {
+		t.Fatalf("expected an error enabling an unknown package")
+	}
+
+	for _, name := range []string{"net", "strings"} {
+		err = obj.EnablePackage(name)
+		if err != nil {
+			t.Fatalf("unexpected error enabling %s: %s", name, err.Error())
+		}
+	}
+	err = obj.Prepare()
+	if err != nil {
+		t.Fatalf("Failed to compile: %s", err.Error())
+	}
+	ret, err := obj.Run(Input{IP: "10.1.1.1", Name: "Steve"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if !ret {
+		t.Fatalf("unexpected result")
+	}
+}
+
+// TestFunctionBudget tests host functions with an execution budget.
+func TestFunctionBudget(t *testing.T) {
+
+	slow := func(args []object.Object) object.Object {
+		time.Sleep(500 * time.Millisecond)
+		return &object.Boolean{Value: true}
+	}
+	fast := func(args []object.Object) object.Object {
+		return &object.String{Value: "fast"}
+	}
+	broken := func(args []object.Object) object.Object {
+		panic("broken")
+	}
+
+	tests := []struct {
+		Script string
+		Policy BudgetPolicy
+		Result string
+		Error  string
+	}{
+		{Script: `return fast();`, Policy: BudgetAbort, Result: "fast"},
+		{Script: `r = slow(); return type(r) + ":" + string(r);`, Policy: BudgetReturnError, Result: "error:function slow exceeded its budget of 10ms"},
+		{Script: `if ( slow() ) { return "yes"; } return "no";`, Policy: BudgetReturnError, Result: "no"},
+		{Script: `slow(); return "reached";`, Policy: BudgetAbort, Error: "function slow exceeded its budget of 10ms"},
+		{Script: `broken(); return "reached";`, Policy: BudgetReturnError, Error: "broken"},
+	}
+
+	for _, tst := range tests {
+
+		obj := New(tst.Script)
+		obj.AddFunctionWithBudget("slow", slow, 10*time.Millisecond, tst.Policy)
+		obj.AddFunctionWithBudget("fast", fast, time.Second, tst.Policy)
+		obj.AddFunctionWithBudget("broken", broken, time.Second, tst.Policy)
+
+		err := obj.Prepare()
+		if err != nil {
+			t.Fatalf("Failed to compile %s: %s", tst.Script, err.Error())
+		}
+
+		ret, err := obj.Execute(nil)
+		if tst.Error != "" {
+			if err == nil || !strings.Contains(err.Error(), tst.Error) {
+				t.Fatalf("expected error '%s' running %s, got %v", tst.Error, tst.Script, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Found unexpected error running %s: %s", tst.Script, err.Error())
+		}
+		if ret.Inspect() != tst.Result {
+			t.Fatalf("Found unexpected result running %s: %s != %s", tst.Script, ret.Inspect(), tst.Result)
+		}
+	}
+}
+
+// TestRecorder tests recording, and replaying, calls to host functions.
+func TestRecorder(t *testing.T) {
+
+	script := `
+if ( lookup(Name) == "admin" ) {
+  return "admin:" + string(len(Name));
+}
+return "user";
 `
-	name := "This is a test\tof 1 2 3";
-	// Replace the string in our template-program
-	input = strings.ReplaceAll(input, "XXX", name )
 
-	// The expected output will be name =~ s/ /_/g
-	nameOut := strings.ReplaceAll(name, " ", "_");
-	nameOut = strings.ReplaceAll(nameOut, "\t", "_");
+	type Input struct {
+		Name string
+	}
+
+	role := "admin"
+	count := 0
+	lookup := func(args []object.Object) object.Object {
+		count++
+		return &object.String{Value: role}
+	}
+
+	// Record a run.
+	rec := vm.NewRecorder()
+
+	obj := New(script)
+	obj.AddFunction("lookup", lookup)
+	obj.SetRecorder(rec)
+	err := obj.Prepare()
+	if err != nil {
+		t.Fatalf("Failed to compile: %s", err.Error())
+	}
+
+	ret, err := obj.Execute(Input{Name: "Steve"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if ret.Inspect() != "admin:5" {
+		t.Fatalf("unexpected result: %s", ret.Inspect())
+	}
+	if len(rec.Calls) != 3 {
+		t.Fatalf("expected three calls to be recorded, got %d", len(rec.Calls))
+	}
+	if rec.Calls[0].String() != `lookup("Steve")` || rec.Calls[0].Result.Inspect() != "admin" {
+		t.Fatalf("unexpected recording: %s -> %s", rec.Calls[0], rec.Calls[0].Result.Inspect())
+	}
+	if rec.Calls[1].String() != `len("Steve")` || rec.Calls[2].String() != `string(5)` {
+		t.Fatalf("unexpected recording: %s, %s", rec.Calls[1], rec.Calls[2])
+	}
+
+	// Now the external state changes, but replaying gives
+	// the same result - without calling the function.
+	role = "user"
+	count = 0
+	obj.SetRecorder(vm.NewReplayer(rec.Calls))
+
+	for i := 0; i < 2; i++ {
+		ret, err = obj.Execute(Input{Name: "Steve"})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+		if ret.Inspect() != "admin:5" {
+			t.Fatalf("unexpected result replaying: %s", ret.Inspect())
+		}
+	}
+	if count != 0 {
+		t.Fatalf("host function was called during replay")
+	}
+
+	// Replaying against a different input fails.
+	_, err = obj.Execute(Input{Name: "Bob"})
+	if err == nil || !strings.Contains(err.Error(), `expected the call lookup("Steve"), but found lookup("Bob")`) {
+		t.Fatalf("expected a replay error, got %v", err)
+	}
+
+	// As does running out of calls.
+	obj.SetRecorder(vm.NewReplayer(rec.Calls[:1]))
+	_, err = obj.Execute(Input{Name: "Steve"})
+	if err == nil || !strings.Contains(err.Error(), "no recorded result") {
+		t.Fatalf("expected a replay error, got %v", err)
+	}
+
+	// Disabling the recorder calls the function again.
+	obj.SetRecorder(nil)
+	ret, err = obj.Execute(Input{Name: "Steve"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if ret.Inspect() != "user" || count != 1 {
+		t.Fatalf("unexpected result: %s", ret.Inspect())
+	}
+}
+
+// TestVerifyOptimizer runs our example scripts, and some tricky cases,
+// with and without the optimizer, to ensure the results are the same.
+func TestVerifyOptimizer(t *testing.T) {
+
+	scripts := []string{
+		`return 10 - 3 - 2;`,
+		`return 100 / 10 / 5;`,
+		`return 2 ** 3 ** 2;`,
+		`return 7 % 4 - 9 / 3;`,
+		`a = 10; return a - 3 * 2 + 1;`,
+		`if ( 1 + 2 == 3 ) { return "yes"; } return "no";`,
+		`if ( 3 - 1 == 1 ) { return "yes"; } return "no";`,
+		`i = 0; while ( i < 5 ) { i++; } return i;`,
+		`return 3 / 0;`,
+
+		// Folding must not span the destination of a jump.
+		`flag = true; return ( flag ? 1 : 2 ) * 3;`,
+		`flag = false; return 3 - ( flag ? 1 : 2 );`,
+		`if ( ( 9.0 ? "ab" : ( 9 == 14 ) ) ) { return 4.1; } return 3;`,
+		`if ( ( 0 ? false : ( 1 == 1 ) ) ) { return 1; } return 2;`,
+	}
+
+	// Add the example scripts, with their JSON input if present.
+	inputs := make(map[string]interface{})
+	files, err := filepath.Glob("_examples/scripts/*.script")
+	if err != nil {
+		t.Fatalf("failed to find examples: %s", err)
+	}
+	for _, file := range files {
+		dat, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatalf("failed to read %s: %s", file, err)
+		}
+		scripts = append(scripts, string(dat))
+
+		obj := make(map[string]interface{})
+		js, err := ioutil.ReadFile(strings.TrimSuffix(file, ".script") + ".json")
+		if err == nil {
+			err = json.Unmarshal(js, &obj)
+			if err != nil {
+				t.Fatalf("failed to parse JSON for %s: %s", file, err)
+			}
+		}
+		inputs[string(dat)] = obj
+	}
+
+	for _, script := range scripts {
+		obj := New(script)
+		_, err := obj.VerifyOptimizer(inputs[script])
+		if err != nil && strings.Contains(err.Error(), "the optimizer changed the result") {
+			t.Fatalf("%s\n%s", err, script)
+		}
+	}
+
+	// Random scripts.
+	g := objecttest.Generator{}
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 500; i++ {
+		script := g.Script(r)
+		_, err := New(script).VerifyOptimizer(nil)
+		if err != nil && strings.Contains(err.Error(), "the optimizer changed the result") {
+			t.Fatalf("%s\n%s", err, script)
+		}
+	}
+
+	// Ensure that differences are detected.
+	calls := 0
+	obj := New(`return count();`)
+	obj.AddFunction("count", func(args []object.Object) object.Object {
+		calls++
+		return &object.Integer{Value: int64(calls)}
+	})
+	_, err = obj.VerifyOptimizer(nil)
+	if err == nil || !strings.Contains(err.Error(), "optimized run returned INTEGER '1', unoptimized run returned INTEGER '2'") {
+		t.Fatalf("expected a difference to be detected, got %v", err)
+	}
+
+	// Changes made by the script aren't visible to the other run.
+	obj = New(`if ( seen ) { return false; } seen = true; return true;`)
+	ret, err := obj.VerifyOptimizer(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !ret.True() {
+		t.Fatalf("unexpected result")
+	}
+}
+
+// TestDestructuring tests assigning several variables at once.
+func TestDestructuring(t *testing.T) {
+
+	type Event struct {
+		Line    string
+		Details map[string]interface{}
+	}
+
+	event := Event{
+		Line:    "bob,10.0.0.1,login",
+		Details: map[string]interface{}{"user": "alice", "ip": "192.168.0.1", "port": 22},
+	}
+
+	tests := []string{
+		`[a, b] = split(Line, ","); return a == "bob" && b == "10.0.0.1";`,
+		`[_, _, c] = split(Line, ","); return c == "login";`,
+		`[a, b, c, d] = split(Line, ","); return c == "login" && !d;`,
+		`[x, y] = "hi"; return x == "h" && y == "i";`,
+		`{user, ip} = Details; return user == "alice" && ip == "192.168.0.1";`,
+		`{ port, missing, } = Details; return port == 22 && !missing;`,
+		`x = 1; [x, y] = [y, x]; return !x && y == 1;`,
+		`count = 0; function f() { count++; return [1, 2]; } [a, b] = f(); return a + b == 3 && count == 1;`,
+		`function f(h) { local user; {user} = h; return user; } u = f(Details); return u == "alice" && type(user) == "null";`,
+		`foreach line in [ "a=1", "b=2" ] { [k, v] = split(line, "="); } return k == "b" && v == "2";`,
+		`match Details { { user, port: 22 } => return user == "alice"; _ => return false; }`,
+	}
+
+	for _, src := range tests {
+
+		obj := New(src)
+
+		err := obj.Prepare()
+		if err != nil {
+			t.Fatalf("Failed to compile %s: %s", src, err.Error())
+		}
+
+		ret, err := obj.Run(event)
+		if err != nil {
+			t.Fatalf("Found unexpected error running %s: %s", src, err.Error())
+		}
+		if !ret {
+			t.Fatalf("Found unexpected result running %s", src)
+		}
+	}
+
+	// Some things which won't parse.
+	bogus := []string{
+		`{a, b};`,
+		`{a, 3} = x;`,
+		`[a, 3] = x;`,
+		`[] = x;`,
+	}
+	for _, src := range bogus {
+		obj := New(src)
+		if obj.Prepare() == nil {
+			t.Fatalf("Expected error compiling '%s', got none", src)
+		}
+	}
+
+	// Destructuring something which isn't indexable is an error.
+	obj := New(`[a, b] = 3; return true;`)
+	if err := obj.Prepare(); err != nil {
+		t.Fatalf("Failed to compile: %s", err)
+	}
+	if _, err := obj.Run(event); err == nil {
+		t.Fatalf("Expected an error destructuring an integer")
+	}
+}
+
+// Test identifiers can have underscores.
+func TestUnderscore(t *testing.T) {
+
+	// Dummy structure to test field-access.
+	type Structure struct {
+		//lint:ignore ST1003 This is a test to ensure that underscores are permitted.
+		foo_bar int
+	}
+
+	// Instance of object
+	var object Structure
+	object.foo_bar = 3
+
+	type Test struct {
+		Input  string
+		Result bool
+	}
+
+	tests := []Test{
+		{Input: `if ( foo_bar == 3 ) { return true; } return false;`, Result: true},
+	}
+
+	for _, tst := range tests {
+
+		obj := New(tst.Input)
+
+		p := obj.Prepare([]byte{NoOptimize})
+		if p != nil {
+			t.Fatalf("Failed to compile")
+		}
+
+		ret, err := obj.Run(object)
+		if err != nil {
+			t.Fatalf("Found unexpected error running test '%s' - %s\n", tst.Input, err.Error())
+		}
+
+		if ret != tst.Result {
+			t.Fatalf("Found unexpected result running script")
+		}
+	}
+}
+
+func TestPanic(t *testing.T) {
+	input := `
+
+panic("I'm a fish");
+return true ;
+`
 
-	// parse/prepare
 	obj := New(input)
 	err := obj.Prepare()
 	if err != nil {
-		t.Fatalf("Failed to compile: %s",err)
+		t.Fatalf("Failed to compile")
 	}
 
-	// execute
-	out, err2 := obj.Execute(nil)
-	if err2 != nil {
-		t.Fatalf("unexpected error:%s", err2)
+	_, err = obj.Run(nil)
+	if err == nil {
+		t.Fatalf("expected error, got none")
+	}
+	if !strings.Contains(err.Error(), "panic in Run") {
+		fmt.Printf("Wrong value")
+	}
+	if !strings.Contains(err.Error(), "fish") {
+		fmt.Printf("Wrong value")
+	}
+
+	_, err = obj.Execute(nil)
+	if err == nil {
+		t.Fatalf("expected error, got none")
+	}
+	if !strings.Contains(err.Error(), "panic in Run") {
+		fmt.Printf("Wrong value")
+	}
+	if !strings.Contains(err.Error(), "fish") {
+		fmt.Printf("Wrong value")
+	}
+
+}
+
+// Test access under different goroutines
+func TestRace(t *testing.T) {
+
+	input := []string{
+		`{"bob": "3", "seventee": 17}`,
+		`{"simon": "3", "name": "kissa"}`,
+		`{"chris": "3", "name": "pizza"}`,
+		`{"homer": "3", "name": "Yes"}`,
+		`{"daryl": "33", "name": "pizza"}`,
+		`{"alicia": "13", "name": "Yes"}`,
+		`{"susan": "443", "name": "pizza"}`,
+		`{"steve": "333", "name": "Yes"}`,
+	}
+
+	obj := New(`if ( name) { return true; } return false;`)
+
+	err := obj.Prepare()
+	if err != nil {
+		t.Fatalf("error preparing")
+	}
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < len(input); i++ {
+		wg.Add(1)
+		go func(line string) {
+
+			// decode the line into JSON
+			jsonMap := make(map[string]interface{})
+			jsonErr := json.Unmarshal([]byte(line), &jsonMap)
+			if jsonErr != nil {
+				t.Errorf("error decoding json")
+			}
+
+			_, err := obj.Run(jsonMap)
+			if err != nil {
+				t.Errorf("Found unexpected error running test %s\n",
+					err.Error())
+			}
+
+			wg.Done()
+
+		}(input[i])
+
+	}
+
+	wg.Wait()
+}
+
+// Test we can handle null values set in JSON objects
+func TestNullJsonField(t *testing.T) {
+	input := []string{
+		`{"event_count": null, "source": "************"}`,
+		`{"event_count": "332", "source": "************"}`,
+	}
+
+	obj := New(`if ( source == "************") { return true; } return false;`)
+
+	for _, line := range input {
+
+		err := obj.Prepare()
+		if err != nil {
+			t.Fatalf("error preparing")
+		}
+
+		// decode the line into JSON
+		jsonMap := make(map[string]interface{})
+		jsonErr := json.Unmarshal([]byte(line), &jsonMap)
+		if jsonErr != nil {
+			t.Errorf("error decoding json")
+		}
+
+		_, err = obj.Run(jsonMap)
+		if err != nil {
+			t.Errorf("Found unexpected error running test %s\n",
+				err.Error())
+		}
+	}
+}
+
+func TestSplitJoin(t *testing.T) {
+	input := `
+tmp = "XXX";
+out = join( split( tmp, " " ), "-" );
+
+return out ;
+`
+	// The value we'll search/replace on
+	name := "This is a file name with spaces / stuff.docx"
+
+	// Replace the string in our template-program
+	input = strings.ReplaceAll(input, "XXX", name )
+
+	// The expected output will be name =~ s/ /-/g
+	nameOut := strings.ReplaceAll(name, " ", "-");
+
+	// parse/prepare
+	obj := New(input)
+	err := obj.Prepare()
+	if err != nil {
+		t.Fatalf("Failed to compile: %s",err)
+	}
+
+	// execute
+	out, err2 := obj.Execute(nil)
+	if err2 != nil {
+		t.Fatalf("unexpected error:%s", err2)
+	}
+
+	// confirm
+	if out.Inspect() != nameOut {
+		t.Fatalf("failed split/join test got %s not %s", out.Inspect(), nameOut)
+	}
+}
+
+
+func TestReplace(t *testing.T) {
+	input := `
+
+input = "XXX";
+out   = replace(input, /[ \\t]/, "_");
+
+return out ;
+`
+	name := "This is a test\tof 1 2 3";
+	// Replace the string in our template-program
+	input = strings.ReplaceAll(input, "XXX", name )
+
+	// The expected output will be name =~ s/ /_/g
+	nameOut := strings.ReplaceAll(name, " ", "_");
+	nameOut = strings.ReplaceAll(nameOut, "\t", "_");
+
+	// parse/prepare
+	obj := New(input)
+	err := obj.Prepare()
+	if err != nil {
+		t.Fatalf("Failed to compile: %s",err)
+	}
+
+	// execute
+	out, err2 := obj.Execute(nil)
+	if err2 != nil {
+		t.Fatalf("unexpected error:%s", err2)
 	}
 
 	// confirm
@@ -1592,3 +2652,3458 @@ return out ;
 		t.Fatalf("failed split/join test got %s not %s", out.Inspect(), nameOut)
 	}
 }
+
+// TestRunYAML tests running a script against YAML documents.
+func TestRunYAML(t *testing.T) {
+
+	manifests := `
+# Two deployments, one of which runs as root.
+kind: Deployment
+metadata:
+  name: web
+  labels:
+spec:
+  replicas: 3
+  securityContext:
+    runAsNonRoot: true
+  containers:
+  - name: nginx
+    image: nginx:1.19
+    ports: [80, 443]
+---
+kind: Deployment
+metadata: {name: worker}
+spec:
+  replicas: 1
+  securityContext:
+    runAsNonRoot: false
+  containers:
+  - name: worker
+    image: worker:latest
+---
+kind: Service
+metadata:
+  name: web
+`
+
+	// Find deployments which run as root, or use the latest tag.
+	obj := New(`
+if ( kind != "Deployment" ) { return false; }
+if ( ! spec.securityContext.runAsNonRoot ) { return true; }
+foreach container in spec.containers {
+  if ( container.image ~= /:latest$/ ) { return true; }
+}
+return false;
+`)
+
+	err := obj.Prepare()
+	if err != nil {
+		t.Fatalf("failed to compile: %s", err)
+	}
+
+	res, err := obj.RunYAML([]byte(manifests))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if fmt.Sprintf("%v", res) != "[false true false]" {
+		t.Fatalf("wrong results: %v", res)
+	}
+
+	// Nested values are available.
+	obj = New(`
+if ( kind != "Deployment" ) { return false; }
+return metadata.name == "web" && spec.replicas == 3 && len(spec.containers[0].ports) == 2;
+`)
+	obj.Prepare()
+	res, err = obj.RunYAML([]byte(manifests))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if fmt.Sprintf("%v", res) != "[true false false]" {
+		t.Fatalf("wrong results: %v", res)
+	}
+
+	// Errors.
+	tests := []struct {
+		input string
+		error string
+	}{
+		{"a: [1", "yaml: line 1"},
+		{"a: [1]\n---\n- 1\n- 2", "document 2 is not a mapping"},
+		{"a: [1]\n---\nb: 2", "document 2: the index operator"},
+	}
+
+	obj = New(`return a[0] == 1;`)
+	obj.Prepare()
+	for _, test := range tests {
+		_, err = obj.RunYAML([]byte(test.input))
+		if err == nil {
+			t.Fatalf("expected an error for %q, got none", test.input)
+		}
+		if !strings.Contains(err.Error(), test.error) {
+			t.Fatalf("wrong error for %q, got '%s' expected '%s'", test.input, err, test.error)
+		}
+	}
+}
+
+// TestRunTyped tests the typed wrappers around Run.
+func TestRunTyped(t *testing.T) {
+
+	tests := []struct {
+		input  string
+		kind   string
+		result interface{}
+		error  string
+	}{
+		{`return true;`, "bool", true, ""},
+		{`return 1 > 2;`, "bool", false, ""},
+		{`return 1;`, "bool", nil, "the script returned INTEGER 1, rather than BOOLEAN"},
+		{`return "steve";`, "string", "steve", ""},
+		{`return 3;`, "string", nil, "the script returned INTEGER 3, rather than STRING"},
+		{`return 7 * 6;`, "int", int64(42), ""},
+		{`return 4.0;`, "int", int64(4), ""},
+		{`return 2.5;`, "int", nil, "the script returned 2.5, which is not a whole number"},
+		{`return "3";`, "int", nil, "the script returned STRING \"3\", rather than INTEGER"},
+		{`return 2.5;`, "float", 2.5, ""},
+		{`return 2;`, "float", 2.0, ""},
+		{`return [1];`, "float", nil, "the script returned ARRAY [1], rather than FLOAT"},
+		{`return 1 / 0;`, "float", nil, "division by zero"},
+	}
+
+	for _, test := range tests {
+
+		obj := New(test.input)
+		err := obj.Prepare()
+		if err != nil {
+			t.Fatalf("failed to compile %s: %s", test.input, err)
+		}
+
+		var res interface{}
+		switch test.kind {
+		case "bool":
+			res, err = obj.RunBool(nil)
+		case "string":
+			res, err = obj.RunString(nil)
+		case "int":
+			res, err = obj.RunInt(nil)
+		case "float":
+			res, err = obj.RunFloat(nil)
+		}
+
+		if test.error != "" {
+			if err == nil || !strings.Contains(err.Error(), test.error) {
+				t.Fatalf("expected an error containing '%s' for %s, got %v", test.error, test.input, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("unexpected error running %s: %s", test.input, err)
+		}
+		if res != test.result {
+			t.Fatalf("wrong result for %s, got %v expected %v", test.input, res, test.result)
+		}
+	}
+}
+
+// TestExtract tests using scripts to transform objects.
+func TestExtract(t *testing.T) {
+
+	type Person struct {
+		Name  string
+		Email string
+		Age   int
+	}
+
+	tests := []struct {
+		input  string
+		result string
+	}{
+		{`domain = lower(split(Email, "@")[1]); adult = Age >= 18; return true;`, `map[adult:true domain:example.com]`},
+		{`total = 0; foreach item in [1, 2, 3] { total = total + item * 2; }`, `map[total:12]`},
+		{`function double(n) { local x; x = n * 2; return x; } out = double(Age);`, `map[out:90]`},
+		{`x = 1; return { "name": upper(Name), "tags": [1, "two", 3.5], "nested": { "a": null } };`, `map[name:STEVE nested:map[a:<nil>] tags:[1 two 3.5]]`},
+		{`if ( Age > 100 ) { ancient = true; } return false;`, `map[]`},
+	}
+
+	for _, test := range tests {
+
+		obj := New(test.input)
+		err := obj.Prepare()
+		if err != nil {
+			t.Fatalf("failed to compile %s: %s", test.input, err)
+		}
+
+		// Run twice, to ensure nothing persists between runs
+		// which shouldn't.
+		for i := 0; i < 2; i++ {
+			res, err := obj.Extract(Person{Name: "Steve", Email: "steve@EXAMPLE.com", Age: 45})
+			if err != nil {
+				t.Fatalf("unexpected error for %s: %s", test.input, err)
+			}
+			if fmt.Sprintf("%v", res) != test.result {
+				t.Fatalf("wrong result for %s, got %v expected %s", test.input, res, test.result)
+			}
+		}
+	}
+
+	// Errors are reported.
+	obj := New(`x = 1 / 0;`)
+	obj.Prepare([]byte{NoOptimize})
+	_, err := obj.Extract(nil)
+	if err == nil || !strings.Contains(err.Error(), "division by zero") {
+		t.Fatalf("expected an error, got %v", err)
+	}
+}
+
+// TestMutate tests modifying a copy of an object.
+func TestMutate(t *testing.T) {
+
+	type Message struct {
+		Author  string
+		Message string
+		Tags    []string
+	}
+
+	tests := []struct {
+		input   string
+		obj     interface{}
+		result  bool
+		event   string
+		changes string
+	}{
+		{`return true;`, Message{Author: "steve"}, true,
+			`map[Author:steve Message: Tags:[]]`, ``},
+		{`if ( Message ~= /panic/ ) { event.priority = "high"; event.Author = upper(Author); } return false;`,
+			Message{Author: "steve", Message: "panic: oops"}, false,
+			`map[Author:STEVE Message:panic: oops Tags:[] priority:high]`,
+			`Author:steve->STEVE priority:<nil>->high`},
+		{`event.Tags = [ "a", "b" ]; event.Author = Author; return true;`,
+			Message{Author: "steve", Tags: []string{"a"}}, true,
+			`map[Author:steve Message: Tags:[a b]]`,
+			`Tags:[a]->[a b]`},
+		{`event.user.name = "bob"; event.user.seen = true; return Author == "steve";`,
+			map[string]interface{}{"Author": "steve", "user": map[string]interface{}{"name": "alice"}}, true,
+			`map[Author:steve user:map[name:bob seen:true]]`,
+			`user.name:alice->bob user.seen:<nil>->true`},
+	}
+
+	for _, test := range tests {
+
+		obj := New(test.input)
+		err := obj.Prepare()
+		if err != nil {
+			t.Fatalf("failed to compile %s: %s", test.input, err)
+		}
+
+		res, err := obj.Mutate(test.obj)
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %s", test.input, err)
+		}
+		if res.Result != test.result {
+			t.Fatalf("wrong result for %s: %t", test.input, res.Result)
+		}
+		if fmt.Sprintf("%v", res.Event) != test.event {
+			t.Fatalf("wrong event for %s, got %v expected %s", test.input, res.Event, test.event)
+		}
+
+		var changes []string
+		for _, c := range res.Changes {
+			changes = append(changes, fmt.Sprintf("%s:%v->%v", c.Path, c.Old, c.New))
+		}
+		if strings.Join(changes, " ") != test.changes {
+			t.Fatalf("wrong changes for %s, got %v expected %s", test.input, changes, test.changes)
+		}
+	}
+
+	// The original object is untouched.
+	user := map[string]interface{}{"name": "alice"}
+	obj := New(`event.user.name = "bob"; return true;`)
+	obj.Prepare()
+	obj.Mutate(map[string]interface{}{"user": user})
+	if user["name"] != "alice" {
+		t.Fatalf("the original object was modified")
+	}
+
+	// The event isn't visible to other runs.
+	obj = New(`return type(event) == "null";`)
+	obj.Prepare()
+	obj.Mutate(nil)
+	ret, err := obj.Run(nil)
+	if err != nil || !ret {
+		t.Fatalf("the event remained set after Mutate: %v %v", ret, err)
+	}
+
+	// Unprepared scripts are an error.
+	_, err = New(`return true;`).Mutate(nil)
+	if err == nil {
+		t.Fatalf("expected an error for an unprepared script")
+	}
+}
+
+// TestEventMode tests the modes which control whether scripts may modify
+// the object they're run against.
+func TestEventMode(t *testing.T) {
+
+	type Message struct {
+		Author string
+		Count  int
+		Score  float64
+		Tags   []string
+	}
+
+	// Read-only objects cannot be modified at all.
+	doc := map[string]interface{}{"Author": "steve", "Tags": []string{"a"}, "user": map[string]interface{}{"name": "alice"}}
+	readonly := []struct {
+		input string
+		obj   interface{}
+		error string
+	}{
+		{`Author = "bob"; return true;`, doc, "the field Author cannot be modified, the object is read-only"},
+		{`Author = "bob"; return true;`, &Message{}, "the field Author cannot be modified, the object is read-only"},
+		{`Count++; return true;`, &Message{}, "the field Count cannot be modified"},
+		{`Tags[0] = "x"; return true;`, doc, "the members of this ARRAY cannot be modified, the object is read-only"},
+		{`x = Tags; x[0] = "x"; return true;`, &Message{Tags: []string{"a"}}, "the members of this ARRAY cannot be modified"},
+		{`user.name = "bob"; return true;`, doc, "the members of this HASH cannot be modified"},
+	}
+	for _, test := range readonly {
+
+		obj := New(test.input)
+		obj.SetEventMode(vm.EventReadOnly)
+		err := obj.Prepare()
+		if err != nil {
+			t.Fatalf("failed to compile %s: %s", test.input, err)
+		}
+
+		_, err = obj.Run(test.obj)
+		if err == nil || !strings.Contains(err.Error(), test.error) {
+			t.Fatalf("expected error '%s' for %s, got %v", test.error, test.input, err)
+		}
+	}
+
+	// Variables, and our own values, may still be modified.
+	obj := New(`a = [ 1 ]; a[0] = Count; total = Count + 1; return total == 2 && a[0] == 1;`)
+	obj.SetEventMode(vm.EventReadOnly)
+	obj.Prepare()
+	ret, err := obj.Run(&Message{Count: 1})
+	if err != nil || !ret {
+		t.Fatalf("unexpected result from read-only script: %v %v", ret, err)
+	}
+
+	// Read-write objects are modified.
+	script := `Author = upper(Author); Count++; Score = Score + 1; Tags[0] = "z"; return true;`
+	obj = New(script)
+	obj.SetEventMode(vm.EventReadWrite)
+	err = obj.Prepare()
+	if err != nil {
+		t.Fatalf("failed to compile %s: %s", script, err)
+	}
+
+	msg := &Message{Author: "steve", Count: 1, Score: 1.5, Tags: []string{"a", "b"}}
+	_, err = obj.Run(msg)
+	if err != nil {
+		t.Fatalf("unexpected error modifying structure: %s", err)
+	}
+	if fmt.Sprintf("%v", *msg) != "{STEVE 2 2.5 [z b]}" {
+		t.Fatalf("the structure was not modified: %v", *msg)
+	}
+
+	doc = map[string]interface{}{"Author": "steve", "Count": 1, "Score": 1.5, "Tags": []interface{}{"a"}}
+	_, err = obj.Run(doc)
+	if err != nil {
+		t.Fatalf("unexpected error modifying map: %s", err)
+	}
+	if fmt.Sprintf("%v", doc) != "map[Author:STEVE Count:2 Score:2.5 Tags:[z]]" {
+		t.Fatalf("the map was not modified: %v", doc)
+	}
+
+	// Later lookups see the modified values, and nested members may be
+	// added.
+	obj = New(`Count = Count * 10; user.seen = true; return Count == 30;`)
+	obj.SetEventMode(vm.EventReadWrite)
+	obj.Prepare()
+	user := map[string]interface{}{"name": "alice"}
+	doc = map[string]interface{}{"Count": 3, "user": user}
+	ret, err = obj.Run(doc)
+	if err != nil || !ret {
+		t.Fatalf("unexpected result: %v %v", ret, err)
+	}
+	if doc["Count"] != int64(30) || user["seen"] != true {
+		t.Fatalf("the map was not modified: %v", doc)
+	}
+
+	// Failures to modify the object are errors.
+	failures := []struct {
+		input string
+		obj   interface{}
+		error string
+	}{
+		{`Author = "bob"; return true;`, Message{}, "the field Author cannot be modified, the object must be passed by pointer"},
+		{`Count = "bob"; return true;`, &Message{}, "the field Count cannot be modified, STRING cannot be stored as int"},
+		{`Tags[0] = 3; return true;`, &Message{Tags: []string{"a"}}, "the member 0 cannot be modified, INTEGER cannot be stored as string"},
+	}
+	for _, test := range failures {
+
+		obj = New(test.input)
+		obj.SetEventMode(vm.EventReadWrite)
+		obj.Prepare()
+		_, err = obj.Run(test.obj)
+		if err == nil || !strings.Contains(err.Error(), test.error) {
+			t.Fatalf("expected error '%s' for %s, got %v", test.error, test.input, err)
+		}
+	}
+
+	// By default assignments create variables, leaving the object
+	// unchanged.
+	obj = New(`Count = 7; return Count == 7;`)
+	obj.Prepare()
+	msg = &Message{Count: 1}
+	ret, err = obj.Run(msg)
+	if err != nil || !ret || msg.Count != 1 {
+		t.Fatalf("the object was modified: %v %v %v", msg, ret, err)
+	}
+}
+
+// TestMemoryLimit tests that scripts cannot allocate unbounded memory.
+func TestMemoryLimit(t *testing.T) {
+
+	tests := []struct {
+		input string
+		ok    bool
+	}{
+		{`s = "x"; while ( true ) { s = s + s; }`, false},
+		{`a = 1..100000000; return true;`, false},
+		{`h = {}; i = 0; while ( true ) { h[i] = i; i++; }`, false},
+		{`s = "x"; while ( true ) { s = sprintf("%s%s", s, s); }`, false},
+		{`s = "x"; i = 0; while ( i < 10 ) { s = s + s; i++; } return len(s) == 1024;`, true},
+		{`a = 1..1000; return len(a) == 1000;`, true},
+	}
+
+	for _, test := range tests {
+
+		obj := New(test.input)
+		obj.SetMemoryLimit(64 * 1024)
+		err := obj.Prepare()
+		if err != nil {
+			t.Fatalf("failed to compile %s: %s", test.input, err)
+		}
+
+		// Each run has its own allowance.
+		for i := 0; i < 3; i++ {
+			ret, err := obj.Run(nil)
+			if test.ok && (err != nil || !ret) {
+				t.Fatalf("unexpected result for %s: %v %v", test.input, ret, err)
+			}
+			if !test.ok && (err == nil || !strings.Contains(err.Error(), "the memory limit of 65536 bytes was exceeded")) {
+				t.Fatalf("expected the memory limit to be exceeded for %s, got %v", test.input, err)
+			}
+		}
+	}
+}
+
+// TestMaxInstructions tests that runs may only execute a limited number
+// of instructions.
+func TestMaxInstructions(t *testing.T) {
+
+	tests := []struct {
+		input string
+		ok    bool
+	}{
+		{`while ( true ) { }`, false},
+		{`function f( n ) { while ( true ) { n++; } } return f( 1 );`, false},
+		{`a = map( 1..1000, function( x ) { return x * 2; } ); return true;`, false},
+		{`i = 0; while ( i < 10 ) { i++; } return i == 10;`, true},
+		{`return Name == "steve" && Count > 3;`, true},
+	}
+
+	for _, test := range tests {
+
+		obj := New(test.input)
+		obj.SetLoopLimit(0)
+		obj.SetMaxInstructions(200)
+		err := obj.Prepare()
+		if err != nil {
+			t.Fatalf("failed to compile %s: %s", test.input, err)
+		}
+
+		// Each run has its own allowance.
+		for i := 0; i < 3; i++ {
+			ret, err := obj.Run(map[string]interface{}{"Name": "steve", "Count": 5})
+			if test.ok && (err != nil || !ret) {
+				t.Fatalf("unexpected result for %s: %v %v", test.input, ret, err)
+			}
+			if !test.ok {
+				limit, ok := err.(*vm.InstructionLimitError)
+				if !ok || limit.Limit != 200 {
+					t.Fatalf("expected the instruction limit to be exceeded for %s, got %v", test.input, err)
+				}
+			}
+		}
+	}
+
+	// A predicate which is too long is stopped too.
+	obj := New(`return A == 1 && B == 2 && C == 3;`)
+	obj.SetMaxInstructions(5)
+	err := obj.Prepare()
+	if err != nil {
+		t.Fatalf("failed to compile: %s", err)
+	}
+	_, err = obj.Run(map[string]interface{}{"A": 1, "B": 2, "C": 3})
+	if err == nil || err.Error() != "the limit of 5 instructions was exceeded" {
+		t.Fatalf("expected the instruction limit to be exceeded, got %v", err)
+	}
+}
+
+// TestLoopLimit tests that loops may only iterate a limited number of
+// times.
+func TestLoopLimit(t *testing.T) {
+
+	tests := []struct {
+		input string
+		limit int
+		error string
+	}{
+		{`while ( true ) { }`, 100, "the while loop on line 1 exceeded the limit of 100 iterations"},
+		{`i = 0;
+while ( i < 100 ) { i++; }
+foreach x in 1..1000 { }
+return true;`, 500, "the foreach loop on line 3 exceeded the limit of 500 iterations"},
+
+		// Nested loops are counted each time they're entered.
+		{`foreach x in 1..50 { foreach y in 1..50 { } } return true;`, 50, ""},
+		{`function spin() { while ( true ) { } }
+spin();`, 10, "the while loop on line 1 exceeded the limit of 10 iterations"},
+
+		// Pragmas override the limit for the loops that follow.
+		{`// pragma loop-limit 10
+foreach x in 1..20 { }`, 100, "the foreach loop on line 2 exceeded the limit of 10 iterations"},
+		{`foreach x in 1..20 { }
+//   pragma   loop-limit 10
+foreach x in 1..5 { }
+return true;`, 100, ""},
+		{`// pragma loop-limit 1000
+foreach x in 1..200 { } return true;`, 100, ""},
+
+		// A limit of zero disables the check.
+		{`foreach x in 1..2000 { } return true;`, 0, ""},
+
+		// Invalid pragmas are errors.
+		{`// pragma loop-limit many`, 100, "line 1: the loop-limit pragma requires a positive integer, not many"},
+		{"\n// pragma unroll", 100, "line 2: unknown pragma unroll"},
+	}
+
+	for _, test := range tests {
+
+		obj := New(test.input)
+		obj.SetLoopLimit(test.limit)
+		err := obj.Prepare()
+		if err == nil {
+			_, err = obj.Run(nil)
+		}
+
+		if test.error == "" {
+			if err != nil {
+				t.Fatalf("unexpected error for %s: %s", test.input, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.error) {
+			t.Fatalf("expected error '%s' for %s, got %v", test.error, test.input, err)
+		}
+	}
+
+	// The default limit applies.
+	obj := New(`while ( true ) { }`)
+	obj.Prepare()
+	_, err := obj.Run(nil)
+	if err == nil || !strings.Contains(err.Error(), fmt.Sprintf("exceeded the limit of %d iterations", vm.DefaultLoopLimit)) {
+		t.Fatalf("expected the default limit to apply, got %v", err)
+	}
+}
+
+// TestPointerFields tests that pointers, nil values, and embedded
+// structures are handled in the objects scripts are run against.
+func TestPointerFields(t *testing.T) {
+
+	type Address struct {
+		City string
+		Zip  *int
+	}
+	type Base struct {
+		ID   int
+		Name string
+	}
+	type Node struct {
+		Value int
+		Next  *Node
+	}
+	type User struct {
+		*Base
+		Name    string
+		Age     *int
+		Home    *Address
+		Work    *Address
+		Extra   interface{}
+		Created *time.Time
+		List    *Node
+	}
+
+	age := 45
+	zip := 1234
+	when := time.Unix(1600000000, 0)
+	list := &Node{Value: 1, Next: &Node{Value: 2}}
+	list.Next.Next = list
+
+	user := &User{
+		Base:    &Base{ID: 7, Name: "base"},
+		Name:    "steve",
+		Age:     &age,
+		Home:    &Address{City: "Helsinki", Zip: &zip},
+		Created: &when,
+		List:    list,
+	}
+
+	tests := []string{
+		`return Name == "steve";`,
+		`return ID == 7;`,
+		`return Base.Name == "base";`,
+		`return Age == 45;`,
+		`return Home.City == "Helsinki" && Home.Zip == 1234;`,
+		`return type(Work) == "null";`,
+		`return type(Extra) == "null";`,
+		`return Created == 1600000000;`,
+		`return List.Value == 1 && List.Next.Value == 2;`,
+		`return type(List.Next.Next) == "null";`,
+	}
+	for _, test := range tests {
+
+		obj := New(test)
+		err := obj.Prepare()
+		if err != nil {
+			t.Fatalf("failed to compile %s: %s", test, err)
+		}
+
+		ret, err := obj.Run(user)
+		if err != nil || !ret {
+			t.Fatalf("unexpected result for %s: %v %v", test, ret, err)
+		}
+	}
+
+	// Nil pointers, and embedded structures, are null.
+	obj := New(`return type(Age) == "null" && type(Base) == "null" && type(ID) == "null";`)
+	obj.Prepare()
+	ret, err := obj.Run(&User{})
+	if err != nil || !ret {
+		t.Fatalf("unexpected result for nil fields: %v %v", ret, err)
+	}
+
+	// A nil object has no fields.
+	ret, err = obj.Run((*User)(nil))
+	if err != nil || !ret {
+		t.Fatalf("unexpected result for nil object: %v %v", ret, err)
+	}
+
+	// Promoted, and nested, fields may be modified.
+	obj = New(`ID = 8; Home.City = "Tampere"; return true;`)
+	obj.SetEventMode(vm.EventReadWrite)
+	obj.Prepare()
+	_, err = obj.Run(user)
+	if err != nil || user.ID != 8 || user.Home.City != "Tampere" {
+		t.Fatalf("unexpected result modifying fields: %v %v", user, err)
+	}
+}
+
+// TestMapShapes tests running scripts against maps and slices of
+// various types.
+func TestMapShapes(t *testing.T) {
+
+	type Item struct {
+		Name  string
+		Price float64
+	}
+	type Level string
+
+	tests := []struct {
+		obj   interface{}
+		input string
+	}{
+		{map[string]string{"name": "steve", "city": "Helsinki"}, `return name == "steve" && city == "Helsinki";`},
+		{map[Level]int{"debug": 1, "error": 4}, `return error == 4 && debug == 1;`},
+		{map[string][]string{"tags": {"a", "b"}}, `return len(tags) == 2 && tags[1] == "b";`},
+		{map[string]map[int]string{"codes": {200: "OK", 404: "Not Found"}}, `return codes[404] == "Not Found";`},
+		{map[string]interface{}{"codes": map[int64]bool{1: true}}, `return codes[1] && type(codes[2]) == "null";`},
+		{map[string][]Item{"items": {{"apple", 1.5}, {"pear", 2}}}, `total = 0; foreach item in items { total = total + item.Price; } return total == 3.5;`},
+		{map[string]*Item{"item": {"apple", 1.5}, "missing": nil}, `return item.Name == "apple" && type(missing) == "null";`},
+		{map[int]string{1: "one"}, `return type(one) == "null";`},
+		{&struct{ Items []Item }{[]Item{{"apple", 1}}}, `return Items[0].Name == "apple";`},
+	}
+
+	for _, test := range tests {
+
+		obj := New(test.input)
+		err := obj.Prepare()
+		if err != nil {
+			t.Fatalf("failed to compile %s: %s", test.input, err)
+		}
+
+		ret, err := obj.Run(test.obj)
+		if err != nil || !ret {
+			t.Fatalf("unexpected result for %s against %v: %v %v", test.input, test.obj, ret, err)
+		}
+	}
+
+	// Maps with integer keys may be modified.
+	doc := map[int]string{1: "one"}
+	obj := New(`codes[2] = "two"; return true;`)
+	obj.SetEventMode(vm.EventReadWrite)
+	obj.Prepare()
+	_, err := obj.Run(map[string]interface{}{"codes": doc})
+	if err != nil || doc[2] != "two" {
+		t.Fatalf("unexpected result modifying map: %v %v", doc, err)
+	}
+}
+
+// level is a type which describes itself, for TestStringerFields.
+type level int
+
+// String implements fmt.Stringer.
+func (l level) String() string {
+	return []string{"debug", "info", "error"}[l]
+}
+
+// failure is an error with fields, for TestStringerFields.
+type failure struct {
+	Code int
+}
+
+// Error implements the error interface, via a pointer.
+func (f *failure) Error() string {
+	return fmt.Sprintf("failed with code %d", f.Code)
+}
+
+// TestStringerFields tests that fields which implement the error, or
+// fmt.Stringer, interfaces are presented as strings.
+func TestStringerFields(t *testing.T) {
+
+	type Event struct {
+		IP      net.IP
+		Level   level
+		Err     error
+		Failure failure
+		None    error
+		Took    time.Duration
+	}
+
+	event := &Event{
+		IP:      net.ParseIP("192.168.1.1"),
+		Level:   2,
+		Err:     errors.New("file not found"),
+		Failure: failure{Code: 3},
+		Took:    time.Second,
+	}
+
+	tests := []string{
+		`return IP == "192.168.1.1";`,
+		`return Level == "error";`,
+		`return Err == "file not found";`,
+		`return Failure == "failed with code 3";`,
+		`return type(None) == "null";`,
+		`return Took == 1;`,
+	}
+	for _, test := range tests {
+
+		obj := New(test)
+		err := obj.Prepare()
+		if err != nil {
+			t.Fatalf("failed to compile %s: %s", test, err)
+		}
+
+		ret, err := obj.Run(event)
+		if err != nil || !ret {
+			t.Fatalf("unexpected result for %s: %v %v", test, ret, err)
+		}
+
+		// Maps work too.
+		ret, err = obj.Run(map[string]interface{}{"IP": event.IP, "Level": event.Level, "Err": event.Err, "Failure": &event.Failure, "Took": event.Took})
+		if err != nil || !ret {
+			t.Fatalf("unexpected result for %s against a map: %v %v", test, ret, err)
+		}
+	}
+
+	// Structures may be presented as their fields instead.
+	obj := New(`return Failure.Code == 3 && Failure.Error == "failed with code 3" && Level == "error";`)
+	obj.SetStringerFields(true)
+	obj.Prepare()
+	ret, err := obj.Run(event)
+	if err != nil || !ret {
+		t.Fatalf("unexpected result with fields: %v %v", ret, err)
+	}
+}
+
+// TestTimeFields tests that times, and durations, may be compared with
+// duration literals.
+func TestTimeFields(t *testing.T) {
+
+	type Request struct {
+		Created time.Time
+		Took    time.Duration
+		Timeout *time.Duration
+	}
+
+	timeout := 30 * time.Second
+	req := &Request{
+		Created: time.Now().Add(-30 * time.Minute),
+		Took:    1500 * time.Millisecond,
+		Timeout: &timeout,
+	}
+
+	tests := []string{
+		`return Created > now() - 1h;`,
+		`return Created < now() - 15m;`,
+		`return Took > 1s && Took < 2s;`,
+		`return Took == 1.5;`,
+		`return Timeout == 30s;`,
+		`return 1h30m == 5400 && 250ms == 0.25;`,
+	}
+	for _, test := range tests {
+
+		obj := New(test)
+		err := obj.Prepare()
+		if err != nil {
+			t.Fatalf("failed to compile %s: %s", test, err)
+		}
+
+		ret, err := obj.Run(req)
+		if err != nil || !ret {
+			t.Fatalf("unexpected result for %s: %v %v", test, ret, err)
+		}
+	}
+
+	// Durations may be modified.
+	obj := New(`Took = 2m; return true;`)
+	obj.SetEventMode(vm.EventReadWrite)
+	obj.Prepare()
+	_, err := obj.Run(req)
+	if err != nil || req.Took != 2*time.Minute {
+		t.Fatalf("unexpected result modifying duration: %v %v", req.Took, err)
+	}
+}
+
+// TestBytesFields tests that byte-slices are presented as bytes.
+func TestBytesFields(t *testing.T) {
+
+	type Upload struct {
+		Body []byte
+		Copy []byte
+		Sums []int
+	}
+
+	up := &Upload{Body: []byte("%PDF-1.7\n"), Sums: []int{1, 2}}
+
+	tests := []string{
+		`return type(Body) == "bytes" && type(Sums) == "array";`,
+		`return len(Body) == 9 && Body[0] == 37 && Body[-1] == 10;`,
+		`return Body == "%PDF-1.7\n" && "%PDF-1.7\n" == Body && Body != "steve";`,
+		`return Body ~= /^%PDF-/ && Body !~ /^MZ/;`,
+		`return sniff_mime(Body) == "application/pdf";`,
+		`total = 0; foreach b in Body { total += b; } return total > 9 * 32;`,
+		`if ( Copy ) { return false; } return Copy == "" && len(Copy) == 0;`,
+	}
+	for _, test := range tests {
+
+		obj := New(test)
+		obj.EnablePackage("mime")
+		err := obj.Prepare()
+		if err != nil {
+			t.Fatalf("failed to compile %s: %s", test, err)
+		}
+
+		ret, err := obj.Run(up)
+		if err != nil || !ret {
+			t.Fatalf("unexpected result for %s: %v %v", test, ret, err)
+		}
+	}
+
+	// Bytes aren't numbers.
+	obj := New(`return Body > 3;`)
+	obj.Prepare()
+	_, err := obj.Run(up)
+	if err == nil || !strings.Contains(err.Error(), "type mismatch: BYTES") {
+		t.Fatalf("expected a type mismatch, got %v", err)
+	}
+
+	// Bytes may be stored in byte-slices.
+	obj = New(`Copy = Body; return true;`)
+	obj.SetEventMode(vm.EventReadWrite)
+	obj.Prepare()
+	_, err = obj.Run(up)
+	if err != nil || string(up.Copy) != "%PDF-1.7\n" {
+		t.Fatalf("unexpected result copying bytes: %q %v", up.Copy, err)
+	}
+}
+
+// TestTimeObjects tests that times are presented as times, which may be
+// compared with each other, and with numbers.
+func TestTimeObjects(t *testing.T) {
+
+	type Login struct {
+		Started  time.Time
+		Finished time.Time
+		Never    time.Time
+	}
+
+	when := time.Date(2020, time.March, 4, 5, 6, 7, 0, time.UTC)
+	login := &Login{Started: when, Finished: when.Add(90 * time.Second)}
+
+	tests := []struct {
+		script string
+		result bool
+		err    string
+	}{
+		{`return type(Started) == "time";`, true, ""},
+		{`return Started < Finished && Finished > Started;`, true, ""},
+		{`return Started <= Started && Started >= Started;`, true, ""},
+		{`return Started == Finished || Started != Started;`, false, ""},
+		{`return Finished - Started == 90;`, true, ""},
+		{`return Started + 90 == Finished && 90 + Started == Finished;`, true, ""},
+		{`return Finished - 1m30s == Started;`, true, ""},
+		{`return Started == 1583298367 && Started > 1583298366.5;`, true, ""},
+		{`return 1583298368 - Started == 1;`, true, ""},
+		{`return string(Started) == "2020-03-04T05:06:07Z";`, true, ""},
+		{`return hour(Finished) == 5 && minute(Finished) == 7;`, true, ""},
+		{`if ( Never ) { return false; } return Started && true;`, true, ""},
+		{`return Started in [ Finished, Started ];`, true, ""},
+		{`return Started == "2020-03-04T05:06:07Z";`, false, "type mismatch: TIME OpEqual STRING"},
+		{`return Started * Finished;`, false, "unknown operator: TIME OpMul TIME"},
+
+		// The time functions.
+		{`return type(now()) == "time" && now() > Finished && now() - 1h < now();`, true, ""},
+		{`return parse_time("2006-01-02 15:04:05", "2020-03-04 05:06:07") == Started;`, true, ""},
+		{`return parse_time("RFC3339", "2020-03-04T05:07:37Z") == Finished;`, true, ""},
+		{`return parse_time("2020-03-04T05:06:07Z") == Started;`, true, ""},
+		{`return format_time(Finished, "15:04:05") == "05:07:37";`, true, ""},
+		{`return format_time(parse_time("02/01/2006", "04/03/2020"), "Monday") == "Wednesday";`, true, ""},
+		{`return unix(Started) == 1583298367 && unix(parse_time("2020-03-04T05:06:08Z")) == 1583298368;`, true, ""},
+		{`return type(parse_time("2006-01-02", "yesterday")) == "error";`, true, ""},
+		{`return type(format_time(Started, "today")) == "error";`, true, ""},
+		{`t = parse_time("today", "today"); if ( t ) { return false; } return string(t) == "the layout \"today\" contains no elements of a time";`, true, ""},
+	}
+	for _, test := range tests {
+
+		obj := New(test.script)
+		err := obj.Prepare()
+		if err != nil {
+			t.Fatalf("failed to compile %s: %s", test.script, err)
+		}
+
+		ret, err := obj.Run(login)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("expected an error for %s, got %v", test.script, err)
+			}
+			continue
+		}
+		if err != nil || ret != test.result {
+			t.Fatalf("unexpected result for %s: %v %v", test.script, ret, err)
+		}
+	}
+
+	// Times may be stored, as times or seconds.
+	for _, script := range []string{`Never = Finished + 60; return true;`, `Never = 1583298517; return true;`} {
+		login.Never = time.Time{}
+		obj := New(script)
+		obj.SetEventMode(vm.EventReadWrite)
+		obj.Prepare()
+		_, err := obj.Run(login)
+		if err != nil || !login.Never.Equal(when.Add(150*time.Second)) {
+			t.Fatalf("unexpected result modifying time: %v %v", login.Never, err)
+		}
+	}
+}
+
+// TestMathFunctions tests the mathematical functions from scripts.
+func TestMathFunctions(t *testing.T) {
+
+	type Reading struct {
+		Temperatures []float64
+		Count        int
+	}
+
+	reading := &Reading{Temperatures: []float64{21.5, -3.25, 17}, Count: -7}
+
+	tests := []string{
+		`return abs(Count) == 7 && type(abs(Count)) == "integer" && abs(-2.5) == 2.5;`,
+		`return floor(2.7) == 2 && ceil(2.1) == 3 && type(floor(2.7)) == "integer";`,
+		`return round(2.5) == 3 && round(-2.5) == -3 && round(3.14159, 2) == 3.14;`,
+		`return round(1250, -2) == 1300 && type(round(2.5, 0)) == "float";`,
+		`return pow(2, 10) == 1024 && type(pow(2, 10)) == "integer" && pow(2, -1) == 0.5;`,
+		`return sqrt(16) == 4 && type(sqrt(16)) == "float";`,
+		`return max(Temperatures) == 21.5 && min(Temperatures) == -3.25;`,
+		`return max(1, 2.5, Count) == 2.5 && min(1, 2.5, Count) == Count;`,
+		`return max(3, 9) == 9 && min(3, 9) == 3;`,
+		`return type(sqrt(-1)) == "error" && type(pow(2, 64)) == "error";`,
+		`r = sqrt(Count); if ( r ) { return false; } return string(r) == "the square root of -7 isn't a real number";`,
+		`return type(abs("three")) == "null" && type(min([])) == "null";`,
+	}
+	for _, test := range tests {
+
+		obj := New(test)
+		err := obj.Prepare()
+		if err != nil {
+			t.Fatalf("failed to compile %s: %s", test, err)
+		}
+
+		ret, err := obj.Run(reading)
+		if err != nil || !ret {
+			t.Fatalf("unexpected result for %s: %v %v", test, ret, err)
+		}
+	}
+}
+
+// TestSlices tests negative indexes, and slices of arrays and strings.
+func TestSlices(t *testing.T) {
+
+	tests := []string{
+		`a = [1, 2, 3]; return a[-1] == 3 && a[-3] == 1;`,
+		`a = [1, 2, 3]; return type(a[-4]) == "null" && type(a[3]) == "null";`,
+		`return "steve"[-1] == "e";`,
+		`a = [1, 2, 3, 4]; return join(a[1:3], ",") == "2,3";`,
+		`a = [1, 2, 3, 4]; return join(a[:2], ",") == "1,2" && join(a[2:], ",") == "3,4";`,
+		`a = [1, 2, 3, 4]; return join(a[-2:], ",") == "3,4" && join(a[:-1], ",") == "1,2,3";`,
+		`a = [1, 2, 3, 4]; return join(a[2:100], ",") == "3,4" && len(a[5:]) == 0 && len(a[3:1]) == 0;`,
+		`a = [1, 2, 3, 4]; return join(a[:], ",") == join(a, ",");`,
+		`return "steve"[1:3] == "te" && "狐犬猫"[1:] == "犬猫";`,
+		`a = [1, 2, 3]; a[-1] = 9; return join(a, ",") == "1,2,9";`,
+		`a = [1, 2, 3]; b = a[0:2]; b[0] = 7; return a[0] == 1;`,
+	}
+	for _, test := range tests {
+
+		obj := New(test)
+		err := obj.Prepare()
+		if err != nil {
+			t.Fatalf("failed to compile %s: %s", test, err)
+		}
+
+		ret, err := obj.Run(nil)
+		if err != nil || !ret {
+			t.Fatalf("unexpected result for %s: %v %v", test, ret, err)
+		}
+	}
+
+	failures := []struct {
+		input string
+		error string
+	}{
+		{`a = {"a": 1}; return a[0:1];`, "slices can only be taken of arrays and strings"},
+		{`a = [1, 2]; return a["a":];`, "the bounds of a slice must be integers"},
+	}
+	for _, test := range failures {
+
+		obj := New(test.input)
+		err := obj.Prepare()
+		if err != nil {
+			t.Fatalf("failed to compile %s: %s", test.input, err)
+		}
+
+		_, err = obj.Run(nil)
+		if err == nil || !strings.Contains(err.Error(), test.error) {
+			t.Fatalf("expected error '%s' for %s, got %v", test.error, test.input, err)
+		}
+	}
+}
+
+// TestContainsFunction tests the contains function, with constant and
+// variable needles.
+func TestContainsFunction(t *testing.T) {
+
+	type Input struct {
+		Title string
+		Tags  []string
+		Ports []int
+		Env   map[string]string
+	}
+	in := Input{
+		Title: "Steve's server",
+		Tags:  []string{"prod", "web"},
+		Ports: []int{22, 443},
+		Env:   map[string]string{"HOME": "/root"},
+	}
+
+	tests := []struct {
+		Input    string
+		Constant bool
+	}{
+		{`return contains(Title, "server");`, true},
+		{`return contains(Tags, "prod") && !contains(Tags, "dev");`, true},
+		{`return contains(Ports, 22) && !contains(Ports, 80);`, true},
+		{`return contains(Env, "HOME") && !contains(Env, "/root");`, true},
+		{`return contains([1.5, true], true) && contains({"a": 1}, "a");`, true},
+		{`n = "web"; return contains(Tags, n) && contains(Title, n[1:2]);`, false},
+		{`return contains(Ports, 400 + 43);`, false},
+		{`return !contains(Missing, "x");`, true},
+	}
+
+	for _, test := range tests {
+		for _, flags := range [][]byte{nil, {NoOptimize}} {
+
+			obj := New(test.Input)
+			err := obj.Prepare(flags)
+			if err != nil {
+				t.Fatalf("failed to compile %s: %s", test.Input, err)
+			}
+
+			// Constant needles avoid the function-call.
+			found := false
+			for _, c := range obj.instructions {
+				if code.Opcode(c) == code.OpContains {
+					found = true
+				}
+			}
+			if found != test.Constant {
+				t.Fatalf("unexpected use of OpContains for %s: %v", test.Input, found)
+			}
+
+			ret, err := obj.Run(in)
+			if err != nil || !ret {
+				t.Fatalf("unexpected result for %s: %v %v", test.Input, ret, err)
+			}
+		}
+	}
+
+	// Host functions replace ours, even for constant needles.
+	obj := New(`return contains(Title, "server");`)
+	obj.AddFunction("contains", func(args []object.Object) object.Object {
+		return &object.Boolean{Value: false}
+	})
+	err := obj.Prepare()
+	if err != nil {
+		t.Fatalf("failed to compile: %s", err)
+	}
+	ret, err := obj.Run(in)
+	if err != nil || ret {
+		t.Fatalf("host function was not called: %v %v", ret, err)
+	}
+}
+
+// TestArena tests that reusing temporaries doesn't affect the values
+// which outlive a run.
+func TestArena(t *testing.T) {
+
+	for _, enable := range []bool{true, false} {
+
+		var kept []object.Object
+
+		obj := New(`
+total = total + n * 2;
+keep( n + 1, name + "!" );
+return n * 3;
+`)
+		obj.SetArena(enable)
+		obj.SetVariable("total", &object.Integer{Value: 0})
+		obj.AddFunction("keep", func(args []object.Object) object.Object {
+			kept = append(kept, args...)
+			return &object.Void{}
+		})
+		err := obj.Prepare()
+		if err != nil {
+			t.Fatalf("failed to compile: %s", err)
+		}
+
+		var results []object.Object
+		for i := 1; i <= 100; i++ {
+			out, err := obj.Execute(map[string]interface{}{"n": i, "name": "steve"})
+			if err != nil {
+				t.Fatalf("failed to run: %s", err)
+			}
+			results = append(results, out)
+		}
+
+		if obj.GetVariable("total").Inspect() != "10100" {
+			t.Fatalf("wrong total: %s", obj.GetVariable("total").Inspect())
+		}
+		for i, out := range results {
+			if out.Inspect() != fmt.Sprintf("%d", (i+1)*3) {
+				t.Fatalf("result %d was modified: %s", i, out.Inspect())
+			}
+			if kept[i*2].Inspect() != fmt.Sprintf("%d", i+2) || kept[i*2+1].Inspect() != "steve!" {
+				t.Fatalf("argument %d was modified: %s", i, kept[i*2].Inspect())
+			}
+		}
+	}
+}
+
+// TestPredicates tests that scripts which only compare fields give the
+// same results when they're evaluated without the machine.
+func TestPredicates(t *testing.T) {
+
+	type Level string
+	type Person struct {
+		Name    string
+		Age     int
+		Score   float64
+		Admin   bool
+		Level   Level
+		Timeout time.Duration
+		Remote  net.IP
+	}
+
+	objects := []interface{}{
+		nil,
+		map[string]interface{}{"Name": "Steve", "Age": 42, "Score": 3.5, "Admin": true, "Level": "high"},
+		map[string]interface{}{"Name": "", "Age": int64(-3), "Score": math.NaN(), "Admin": false, "Tags": []string{"a"}},
+		map[string]interface{}{"Name": 3, "Age": "old", "Admin": nil, "Score": uint8(7)},
+		map[string]string{"Name": "Bob", "Level": "low"},
+		map[int]int{1: 2},
+		Person{Name: "Steve", Age: 17, Score: 0.5, Level: "low", Timeout: 90 * time.Second, Remote: net.ParseIP("10.0.0.1")},
+		&Person{Name: "Alice", Age: 18, Admin: true},
+	}
+
+	tests := []struct {
+		script    string
+		predicate bool
+	}{
+		{`return Name == "Steve";`, true},
+		{`return Age >= 18 && Admin;`, true},
+		{`return Age > 17.5 || Score < 1;`, true},
+		{`return !Admin && Name != "Bob";`, true},
+		{`return Level == "high" || Level == "low";`, true},
+		{`return Timeout > 60;`, true},
+		{`return Remote == "10.0.0.1";`, true},
+		{`return Missing == "x";`, true},
+		{`return !Missing;`, true},
+		{`return Admin == true;`, true},
+		{`return Admin > false;`, true},
+		{`return Name && Age;`, true},
+		{`return Age && Score;`, true},
+		{`return Tags || Admin;`, true},
+		{`return 1 == 1;`, true},
+		{`return true;`, true},
+		{`return $Name == "Steve";`, true},
+		{`return Age;`, false},
+		{`return Age + 1 > 18;`, false},
+		{`return Name ~= /steve/i;`, false},
+		{`if ( Age > 18 ) { return true; } return false;`, false},
+	}
+
+	for _, test := range tests {
+
+		eval := New(test.script)
+		err := eval.Prepare()
+		if err != nil {
+			t.Fatalf("failed to compile %s: %s", test.script, err)
+		}
+		if eval.machine.IsPredicate() != test.predicate {
+			t.Fatalf("unexpected predicate for %s", test.script)
+		}
+
+		plain := New(test.script)
+		err = plain.Prepare()
+		if err != nil {
+			t.Fatalf("failed to compile %s: %s", test.script, err)
+		}
+		plain.machine.SetPredicate(false)
+
+		for i, obj := range objects {
+			a, errA := eval.Execute(obj)
+			b, errB := plain.Execute(obj)
+			if fmt.Sprintf("%v", errA) != fmt.Sprintf("%v", errB) {
+				t.Fatalf("%s: object %d gave different errors: %v %v", test.script, i, errA, errB)
+			}
+			if a.Inspect() != b.Inspect() {
+				t.Fatalf("%s: object %d gave different results: %s %s", test.script, i, a.Inspect(), b.Inspect())
+			}
+		}
+	}
+
+	// Variables take precedence over fields.
+	eval := New(`return Name == "Bob";`)
+	eval.SetVariable("Name", &object.String{Value: "Bob"})
+	err := eval.Prepare()
+	if err != nil {
+		t.Fatalf("failed to compile: %s", err)
+	}
+	ok, err := eval.Run(map[string]interface{}{"Name": "Steve"})
+	if err != nil || !ok {
+		t.Fatalf("the variable was ignored: %v %v", ok, err)
+	}
+
+	// Predicates don't allocate.
+	eval = New(`return Name == "Steve" && ( Age >= 18 || Admin ) && !Banned;`)
+	err = eval.Prepare()
+	if err != nil {
+		t.Fatalf("failed to compile: %s", err)
+	}
+	obj := map[string]interface{}{"Name": "Steve", "Age": 42, "Admin": false}
+	allocs := testing.AllocsPerRun(100, func() {
+		ok, err := eval.Run(obj)
+		if err != nil || !ok {
+			t.Fatalf("unexpected result: %v %v", ok, err)
+		}
+	})
+	if allocs != 0 {
+		t.Fatalf("the predicate made %v allocations", allocs)
+	}
+}
+
+// provided supplies its fields via the FieldProvider interface.
+type provided struct {
+	name string
+	age  int64
+}
+
+// Field implements vm.FieldProvider.
+func (p *provided) Field(name string) (vm.Field, bool) {
+	switch name {
+	case "Name":
+		return vm.StringField(p.name), true
+	case "Age":
+		return vm.IntField(p.age), true
+	case "Ratio":
+		return vm.FloatField(0.5), true
+	case "Admin":
+		return vm.BoolField(p.name == "root"), true
+	case "Nothing":
+		return vm.NullField(), true
+	}
+	return vm.Field{}, false
+}
+
+// TestTypedVariables tests setting variables from native values, and
+// supplying fields via the FieldProvider interface.
+func TestTypedVariables(t *testing.T) {
+
+	eval := New(`return Count > 3 && Label == "x" && Ratio < 1.0 && !Debug;`)
+	err := eval.Prepare()
+	if err != nil {
+		t.Fatalf("failed to compile: %s", err)
+	}
+
+	i := int64(0)
+	allocs := testing.AllocsPerRun(100, func() {
+		i++
+		eval.SetVariableInt("Count", i)
+		eval.SetVariableString("Label", "x")
+		eval.SetVariableFloat("Ratio", 0.25)
+		eval.SetVariableBool("Debug", false)
+		ok, err := eval.Run(nil)
+		if err != nil || ok != (i > 3) {
+			t.Fatalf("unexpected result: %v %v", ok, err)
+		}
+	})
+	if allocs != 0 {
+		t.Fatalf("setting the variables made %v allocations", allocs)
+	}
+
+	// Values the script keeps, or returns, are left alone.
+	eval = New(`saved = Count; return Count;`)
+	err = eval.Prepare()
+	if err != nil {
+		t.Fatalf("failed to compile: %s", err)
+	}
+	eval.SetVariableInt("Count", 1)
+	out, err := eval.Execute(nil)
+	if err != nil {
+		t.Fatalf("failed to run: %s", err)
+	}
+	eval.SetVariableInt("Count", 2)
+	if out.Inspect() != "1" || eval.GetVariable("saved").Inspect() != "1" || eval.GetVariable("Count").Inspect() != "2" {
+		t.Fatalf("a kept value was modified")
+	}
+
+	// As are the variables returned to the host.
+	count := eval.GetVariable("Count")
+	eval.SetVariableInt("Count", 3)
+	if count.Inspect() != "2" {
+		t.Fatalf("a returned variable was modified")
+	}
+
+	// Fields may be supplied without reflection.
+	scripts := []struct {
+		script string
+		result bool
+	}{
+		{`return Name == "steve" && Age >= 18;`, true},
+		{`return Admin || Ratio > 1.0;`, false},
+		{`return Age + 1 == 43 && type(Nothing) == "null" && type(Missing) == "null";`, true},
+		{`return upper(Name) == "STEVE";`, true},
+	}
+	p := &provided{name: "steve", age: 42}
+	for _, test := range scripts {
+		eval = New(test.script)
+		err = eval.Prepare()
+		if err != nil {
+			t.Fatalf("failed to compile %s: %s", test.script, err)
+		}
+		ok, err := eval.Run(p)
+		if err != nil || ok != test.result {
+			t.Fatalf("unexpected result for %s: %v %v", test.script, ok, err)
+		}
+	}
+
+	eval = New(`return Name == "steve" && Age >= 18;`)
+	err = eval.Prepare()
+	if err != nil {
+		t.Fatalf("failed to compile: %s", err)
+	}
+	allocs = testing.AllocsPerRun(100, func() {
+		eval.Run(p)
+	})
+	if allocs != 0 {
+		t.Fatalf("the provider made %v allocations", allocs)
+	}
+
+	// Their fields can't be modified.
+	eval = New(`Age = 3; return true;`)
+	eval.SetEventMode(vm.EventReadWrite)
+	err = eval.Prepare()
+	if err != nil {
+		t.Fatalf("failed to compile: %s", err)
+	}
+	_, err = eval.Run(p)
+	if err == nil || !strings.Contains(err.Error(), "FieldProvider") {
+		t.Fatalf("expected an error, got %v", err)
+	}
+}
+
+// TestHoisting tests that the lookups which loops don't change are moved
+// out of them, without changing the results.
+func TestHoisting(t *testing.T) {
+
+	input := map[string]interface{}{
+		"Name":  "steve",
+		"Items": []string{"bob", "steve", "steve", "alice"},
+		"Limit": 2,
+	}
+
+	tests := []struct {
+		script  string
+		result  string
+		hoisted bool
+	}{
+		{`count = 0; foreach item in Items { if ( item == Name ) { count++; } } return count;`, "2", true},
+		{`count = 0; foreach item in Items { if ( item == $Name ) { count++; } } return count >= Limit;`, "true", true},
+
+		// The loop sets the variable.
+		{`i = 0; while ( i < Limit ) { i++; } return i;`, "2", true},
+		{`n = 0; foreach item in Items { n = n + len(item); Name = item; } return Name;`, "alice", false},
+		{`foreach item in Items { if ( item == $Name ) { Name = "x"; } } return $Name;`, "x", false},
+
+		// Nested loops, where only the outer loop sets the variable.
+		{`total = 0; foreach a in Items { x = len(a); foreach b in Items { total = total + x; } } return total;`, "72", true},
+
+		// A function which sets the variable.
+		{`function change() { Name = "bob"; return true; }
+count = 0; foreach item in Items { if ( item == Name ) { count++; ok = change(); } } return count;`, "1", false},
+
+		// Jumps to the loop must arrive before the hoisted lookups.
+		{`if ( Limit > 1 ) { x = 1; } else { x = 2; } foreach item in Items { x = x + Limit; } return x;`, "9", true},
+		{`switch ( Name ) { case "steve" { n = 0; foreach item in Items { n = n + Limit; } return n; } } return 0;`, "8", true},
+	}
+
+	for _, test := range tests {
+
+		eval := New(test.script)
+		out, err := eval.VerifyOptimizer(input)
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %s", test.script, err)
+		}
+		if out.Inspect() != test.result {
+			t.Fatalf("unexpected result for %s: %s", test.script, out.Inspect())
+		}
+
+		err = eval.Prepare()
+		if err != nil {
+			t.Fatalf("failed to compile %s: %s", test.script, err)
+		}
+		hoisted := false
+		eval.machine.WalkBytecode(func(offset int, opCode code.Opcode, opArg interface{}) (bool, error) {
+			if opCode == code.OpLoadSlot {
+				hoisted = true
+			}
+			return true, nil
+		})
+		if hoisted != test.hoisted {
+			t.Fatalf("expected hoisting to be %v for %s", test.hoisted, test.script)
+		}
+
+		// Each run begins afresh.
+		for i := 0; i < 2; i++ {
+			out, err = eval.Execute(input)
+			if err != nil || out.Inspect() != test.result {
+				t.Fatalf("unexpected result for %s: %v %v", test.script, out, err)
+			}
+		}
+	}
+}
+
+// TestBreakContinue tests terminating loops early, and skipping to their
+// next iteration.
+func TestBreakContinue(t *testing.T) {
+
+	tests := []struct {
+		script string
+		result string
+	}{
+		{`n = 0; while ( true ) { n++; if ( n == 5 ) { break; } } return n;`, "5"},
+		{`n = 0; i = 0; while ( i < 10 ) { i++; if ( i % 2 == 0 ) { continue; } n = n + i; } return n;`, "25"},
+		{`n = 0; foreach x in 1..10 { if ( x == 3 ) { continue; } if ( x > 5 ) { break; } n = n + x; } return n;`, "12"},
+		{`n = 0; foreach x in 1..10 { switch ( x ) { case 3 { continue; } case 5 { break; } } n = n + x; } return n;`, "7"},
+
+		// Only the innermost loop is affected.
+		{`n = 0; foreach x in 1..3 { foreach y in 1..3 { if ( y == 2 ) { break; } n++; } n = n + 10; } return n;`, "33"},
+		{`n = 0; foreach x in 1..3 { i = 0; while ( i < 3 ) { i++; if ( i == 2 ) { continue; } n++; } } return n;`, "6"},
+
+		// The loop's variables are discarded.
+		{`x = "outer"; foreach x in [ 1, 2 ] { break; } return x;`, "outer"},
+
+		// Within functions.
+		{`function first( items ) { found = null; foreach x in items { if ( x > 2 ) { found = x; break; } } return found; }
+return first( [ 1, 2, 3, 4 ] );`, "3"},
+	}
+
+	for _, test := range tests {
+		out, err := New(test.script).VerifyOptimizer(nil)
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %s", test.script, err)
+		}
+		if out.Inspect() != test.result {
+			t.Fatalf("unexpected result for %s: %s", test.script, out.Inspect())
+		}
+	}
+
+	// break and continue may only be used within loops.
+	for _, script := range []string{`break;`, `if ( true ) { continue; }`} {
+		err := New(script).Prepare()
+		if err == nil || !strings.Contains(err.Error(), "may only be used inside a loop") {
+			t.Fatalf("expected an error for %s, got %v", script, err)
+		}
+	}
+}
+
+// TestSimplify tests the simplification of boolean expressions.
+func TestSimplify(t *testing.T) {
+
+	// The bytecode generated for the given script, when optimized.
+	compiled := func(script string) string {
+		e := New(script)
+		err := e.Prepare()
+		if err != nil {
+			t.Fatalf("failed to compile %s: %s", script, err)
+		}
+		var out []string
+		e.machine.WalkBytecode(func(offset int, opCode code.Opcode, opArg interface{}) (bool, error) {
+			arg := ""
+			if opArg != nil {
+				arg = fmt.Sprintf("%d", opArg.(int))
+				if opCode == code.OpConstant || opCode == code.OpLookup {
+					arg = e.constants[opArg.(int)].Inspect()
+				}
+			}
+			out = append(out, code.String(opCode)+" "+arg)
+			return true, nil
+		})
+		return strings.Join(out, "\n")
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`return !!( a == b );`, `return a == b;`},
+		{`return !!!a;`, `return !a;`},
+		{`return a == 1 && true;`, `return a == 1;`},
+		{`return false || a < 3;`, `return a < 3;`},
+		{`return a == 1 && true && b ~= /x/;`, `return a == 1 && b ~= /x/;`},
+		{`return true && true;`, `return true;`},
+		{`return a && false;`, `return false;`},
+		{`return b || true || c;`, `return true;`},
+		{`return a == 1 && b == 2 && a == 1 && ( b == 2 );`, `return a == 1 && b == 2;`},
+		{`return !( a == b || c != d );`, `return a != b && c == d;`},
+		{`return !( a ~= /x/ && !( b > 3 ) );`, `return a !~ /x/ || b > 3;`},
+		{`if ( !!( a == 1 ) && true ) { return 1; } return 2;`, `if ( a == 1 ) { return 1; } return 2;`},
+
+		// These can't be simplified.
+		{`return !!a;`, `return !(!a);`},
+		{`return a && true;`, `return a && true;`},
+		{`return a == 1 && b && a == 1;`, `return a == 1 && b && a == 1;`},
+		{`return a < 3 && false;`, `return a < 3 && false;`},
+		{`return a && b && false;`, `return a && b && false;`},
+		{`return f() == 1 && f() == 1;`, `return f() == 1 && f() == 1;`},
+		{`return !( a < b );`, `return !( a < b );`},
+	}
+
+	for _, test := range tests {
+		out := compiled(test.input)
+		if out != compiled(test.expected) {
+			t.Fatalf("unexpected bytecode for %s:\n%s\nexpected:\n%s", test.input, out, compiled(test.expected))
+		}
+	}
+
+	// The results must be unchanged, including the errors, for values
+	// of each type.
+	atoms := []string{"i", "z", "s", "e", "t", "f", "n", "true", "false",
+		"i == 1", "s == \"a\"", "i < s", "s ~= /a/", "t != f", "( i && z )", "( t && i )"}
+	r := rand.New(rand.NewSource(1))
+	var expr func(depth int) string
+	expr = func(depth int) string {
+		if depth == 0 || r.Intn(4) == 0 {
+			return atoms[r.Intn(len(atoms))]
+		}
+		switch r.Intn(4) {
+		case 0:
+			return "!" + expr(depth-1)
+		case 1:
+			return "( " + expr(depth-1) + " && " + expr(depth-1) + " )"
+		case 2:
+			return "( " + expr(depth-1) + " || " + expr(depth-1) + " )"
+		}
+		return "!( " + expr(depth-1) + " )"
+	}
+	input := map[string]interface{}{"i": 1, "z": 0, "s": "a", "e": "", "t": true, "f": false}
+	for i := 0; i < 2000; i++ {
+		script := "return " + expr(4) + ";"
+		_, err := New(script).VerifyOptimizer(input)
+		if err != nil && strings.Contains(err.Error(), "the optimizer changed the result") {
+			t.Fatalf("%s\n%s", err, script)
+		}
+	}
+}
+
+// TestPropagation tests that variables which are assigned constants are
+// propagated into the expressions which use them.
+func TestPropagation(t *testing.T) {
+
+	// The bytecode generated for the given script, when optimized.
+	compiled := func(script string, mode vm.EventMode) string {
+		e := New(script)
+		e.SetEventMode(mode)
+		err := e.Prepare()
+		if err != nil {
+			t.Fatalf("failed to compile %s: %s", script, err)
+		}
+		var out []string
+		e.machine.WalkBytecode(func(offset int, opCode code.Opcode, opArg interface{}) (bool, error) {
+			arg := ""
+			if opArg != nil {
+				arg = fmt.Sprintf("%d", opArg.(int))
+				if opCode == code.OpConstant || opCode == code.OpLookup {
+					arg = e.constants[opArg.(int)].Inspect()
+				}
+			}
+			out = append(out, code.String(opCode)+" "+arg)
+			return true, nil
+		})
+		return strings.Join(out, "\n")
+	}
+
+	tests := []struct {
+		input    string
+		inline   string
+		expected bool
+	}{
+		{`threshold = 100; if ( Count > threshold ) { return true; } return false;`,
+			`threshold = 100; if ( Count > 100 ) { return true; } return false;`, true},
+		{`limit = 3; if ( limit * 2 == 6 ) { return Count; } return 0;`,
+			`limit = 3; if ( true ) { return Count; } return 0;`, true},
+		{`name = "steve"; return Name == name;`,
+			`name = "steve"; return Name == "steve";`, true},
+		{`min = -5; max = 2.5; return Count > min && Count < max;`,
+			`min = -5; max = 2.5; return Count > -5 && Count < 2.5;`, true},
+		{`limit = 3; return Count < $limit;`,
+			`limit = 3; return Count < 3;`, true},
+		{`limit = 3; foreach item in [1, 2] { if ( item == limit ) { return true; } } return false;`,
+			`limit = 3; foreach item in [1, 2] { if ( item == 3 ) { return true; } } return false;`, true},
+		{`limit = 3; function under( n ) { return n < limit; } return under( Count );`,
+			`limit = 3; function under( n ) { return n < limit; } return Count < 3;`, true},
+
+		// These can't be propagated.
+		{`limit = 3; limit = 4; return Count < limit;`,
+			`limit = 3; limit = 4; return Count < 4;`, false},
+		{`limit = 3; limit++; return Count < limit;`,
+			`limit = 3; limit++; return Count < 3;`, false},
+		{`limit = 3; limit += 1; return Count < limit;`,
+			`limit = 3; limit += 1; return Count < 3;`, false},
+		{`limit = 3; if ( Count > 1 ) { $limit = 1; } return Count < limit;`,
+			`limit = 3; if ( Count > 1 ) { $limit = 1; } return Count < 3;`, false},
+		{`limit = 3; foreach limit in [1, 2] { } return Count < limit;`,
+			`limit = 3; foreach limit in [1, 2] { } return Count < 3;`, false},
+		{`limit = 3; [limit, other] = [1, 2]; return Count < limit;`,
+			`limit = 3; [limit, other] = [1, 2]; return Count < 3;`, false},
+		{`limit = 3; function f( limit ) { other = limit; } f( 1 ); return Count < limit;`,
+			`limit = 3; function f( limit ) { other = limit; } f( 1 ); return Count < 3;`, false},
+		{`$limit = 3; return Count < limit;`,
+			`$limit = 3; return Count < 3;`, false},
+		{`limit = Count; return Count == limit;`,
+			`limit = Count; return Count == Count;`, false},
+	}
+
+	for _, test := range tests {
+		out := compiled(test.input, vm.EventShadow)
+		if (out == compiled(test.inline, vm.EventShadow)) != test.expected {
+			t.Fatalf("unexpected bytecode for %s:\n%s", test.input, out)
+		}
+
+		// The result must be unchanged.
+		obj := &struct {
+			Count int
+			Name  string
+		}{Count: 2, Name: "steve"}
+		_, err := New(test.input).VerifyOptimizer(obj)
+		if err != nil && strings.Contains(err.Error(), "the optimizer changed the result") {
+			t.Fatalf("%s\n%s", err, test.input)
+		}
+	}
+
+	// References which come before the assignment see the old value.
+	script := `if ( limit == 3 ) { return false; } limit = 3; return limit == 3;`
+	out := compiled(script, vm.EventShadow)
+	if out == compiled(`if ( 3 == 3 ) { return false; } limit = 3; return limit == 3;`, vm.EventShadow) {
+		t.Fatalf("propagated a reference before its assignment:\n%s", out)
+	}
+	if out != compiled(`if ( limit == 3 ) { return false; } limit = 3; return true;`, vm.EventShadow) {
+		t.Fatalf("unexpected bytecode for %s:\n%s", script, out)
+	}
+
+	// As do references within functions, which might be called first.
+	script = `function get() { other = 1; return limit; } first = get(); limit = 3; return first;`
+	ret, err := New(script).VerifyOptimizer(nil)
+	if err != nil {
+		t.Fatalf("unexpected error running %s: %s", script, err)
+	}
+	if ret.Type() != object.NULL {
+		t.Fatalf("propagated a reference within a function: %v", ret)
+	}
+
+	// Assignments to fields may change the object when it's writable.
+	script = `Count = 3; return Count == 3;`
+	if compiled(script, vm.EventReadWrite) == compiled(`Count = 3; return true;`, vm.EventReadWrite) {
+		t.Fatalf("propagated an assignment to a writable object")
+	}
+}
+
+// TestFunctionValues tests functions which are values, and closures.
+func TestFunctionValues(t *testing.T) {
+
+	tests := []struct {
+		script string
+		result string
+	}{
+		{`f = function( x ) { return x * 2; }; return map( [ 1, 2, 3 ], f );`, "[2, 4, 6]"},
+		{`return filter( [ 1, 2, 3, 4 ], function( x ) { return x % 2 == 0; } );`, "[2, 4]"},
+		{`return ( function( x ) { return x + 1; } )( 41 );`, "42"},
+		{`fns = [ function() { return 1; }, function() { return 2; } ]; return fns[1]();`, "2"},
+		{`return type( function() { } );`, "function"},
+
+		// Named functions are values too.
+		{`function double( x ) { return x * 2; } return map( [ 1, 2 ], double );`, "[2, 4]"},
+		{`function apply( f, x ) { return f( x ); } return apply( function( x ) { return x - 1; }, 3 );`, "2"},
+
+		// Closures capture the local variables they refer to.
+		{`function scale( items, factor ) { return map( items, function( x ) { return x * factor; } ); }
+return scale( [ 1, 2 ], 10 );`, "[10, 20]"},
+		{`function adder( n ) { return function( x ) { return x + n; }; } add5 = adder( 5 ); n = 100; return add5( 1 );`, "6"},
+		{`function adder( n ) { return function( x ) { return function( y ) { return n + x + y; }; }; } return adder( 1 )( 2 )( 3 );`, "6"},
+		{`foreach x in [ 1, 2, 3 ] { if ( x == 2 ) { f = function() { return x * 7; }; } } x = 100; return f();`, "14"},
+
+		// Whilst global variables are seen as they are when called.
+		{`count = 0; f = function() { count++; return count; }; f(); return f();`, "2"},
+		{`f = function() { return limit; }; limit = 3; return f();`, "3"},
+	}
+
+	for _, test := range tests {
+		out, err := New(test.script).VerifyOptimizer(nil)
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %s", test.script, err)
+		}
+		if out.Inspect() != test.result {
+			t.Fatalf("unexpected result for %s: %s", test.script, out.Inspect())
+		}
+	}
+
+	// Errors within functions called by map are reported with the
+	// calls which led to them.
+	errors := []struct {
+		script string
+		error  string
+	}{
+		{`return map( [ 1 ], function( x ) { return x / 0; } );`, "attempted division by zero: 1 / 0 at line 1, column 45 - in anonymous:0006 <- main:0"},
+		{`return map( [ 1 ], function( a, b ) { return a; } );`, "mismatch in argument-counts for anonymous, expected 2 but got 1"},
+		{`return map( [ 1 ], 3 );`, "the second argument to map must be a function, not INTEGER"},
+		{`return filter( 3, function( x ) { return x; } );`, "the first argument to filter must be an array, not INTEGER"},
+		{`f = 3; return f( 1 );`, "the function f does not exist"},
+	}
+	for _, test := range errors {
+		obj := New(test.script)
+		err := obj.Prepare()
+		if err != nil {
+			t.Fatalf("failed to compile %s: %s", test.script, err)
+		}
+		_, err = obj.Execute(nil)
+		if err == nil || !strings.Contains(err.Error(), test.error) {
+			t.Fatalf("expected error '%s' for %s, got %v", test.error, test.script, err)
+		}
+	}
+
+	// Hosts may give scripts functions of their own.
+	obj := New(`return map( [ "a", "b" ], shout );`)
+	obj.SetVariable("shout", &object.Function{Name: "shout", Builtin: func(args []object.Object) object.Object {
+		return &object.String{Value: strings.ToUpper(args[0].Inspect()) + "!"}
+	}})
+	err := obj.Prepare()
+	if err != nil {
+		t.Fatalf("failed to compile: %s", err)
+	}
+	out, err := obj.Execute(nil)
+	if err != nil || out.Inspect() != "[A!, B!]" {
+		t.Fatalf("unexpected result from host function: %v %v", out, err)
+	}
+}
+
+// TestWarnings tests that conditions which always have the same result
+// are reported.
+func TestWarnings(t *testing.T) {
+
+	tests := []struct {
+		script   string
+		warnings []string
+	}{
+		{`if ( Count > 3 ) { return true; } return false;`, nil},
+		{`while ( true ) { break; } return false;`, nil},
+		{`if ( false ) { return true; } return false;`,
+			[]string{"line 1, column 3: condition is always false; branch unreachable"}},
+		{`limit = 10;
+if ( limit > 100 ) { return true; } else { return false; }`,
+			[]string{"line 2, column 3: condition is always false; branch unreachable"}},
+		{`name = "steve";
+if ( name == "steve" && true ) { return true; } else { return false; }`,
+			[]string{"line 2, column 3: condition is always true; else branch unreachable"}},
+		{`return !!true ? 1 : 2;`,
+			[]string{"line 1, column 15: condition is always true; false branch unreachable"}},
+		{`if ( 1 + 2 == 3 ) { return true; } return false;`,
+			[]string{"line 1, column 3: condition is always true"}},
+		{`while ( 1 > 2 ) { print( "never" ); } return false;`,
+			[]string{"line 1, column 6: condition is always false; loop body unreachable"}},
+	}
+
+	for _, test := range tests {
+		obj := New(test.script)
+		err := obj.Prepare()
+		if err != nil {
+			t.Fatalf("failed to compile %s: %s", test.script, err)
+		}
+		if !reflect.DeepEqual(obj.Warnings(), test.warnings) {
+			t.Fatalf("unexpected warnings for %s: %v", test.script, obj.Warnings())
+		}
+
+		// No warnings are generated without the optimizer.
+		err = obj.Prepare([]byte{NoOptimize})
+		if err != nil {
+			t.Fatalf("failed to compile %s: %s", test.script, err)
+		}
+		if len(obj.Warnings()) != 0 {
+			t.Fatalf("unexpected warnings for %s: %v", test.script, obj.Warnings())
+		}
+	}
+}
+
+// TestDeprecatedFunctions tests that calls to deprecated functions are
+// reported as warnings.
+func TestDeprecatedFunctions(t *testing.T) {
+
+	tests := []struct {
+		script   string
+		warnings []string
+	}{
+		{`return lower( "A" ) == "a";`, nil},
+		{`return old( 1 ) == 1;`,
+			[]string{"line 1, column 11: the function old is deprecated; use new instead"}},
+		{`return old( old( 1 ) ) == 1 || len( "x" ) == 1;`,
+			[]string{"line 1, column 11: the function old is deprecated; use new instead",
+				"line 1, column 16: the function old is deprecated; use new instead",
+				"line 1, column 35: the function len is deprecated"}},
+		{`function old( x ) { return x; }
+return old( 1 ) == 1;`,
+			[]string{"line 2, column 11: the function old is deprecated; use new instead"}},
+	}
+
+	for _, test := range tests {
+		for _, flags := range [][]byte{nil, {NoOptimize}} {
+			obj := New(test.script)
+			obj.AddFunction("old", func(args []object.Object) object.Object {
+				return args[0]
+			})
+			obj.DeprecateFunction("old", "new")
+			obj.DeprecateFunction("len", "")
+			err := obj.Prepare(flags)
+			if err != nil {
+				t.Fatalf("failed to compile %s: %s", test.script, err)
+			}
+			if !reflect.DeepEqual(obj.Warnings(), test.warnings) {
+				t.Fatalf("unexpected warnings for %s: %v", test.script, obj.Warnings())
+			}
+
+			// Deprecated functions still work.
+			ok, err := obj.Run(nil)
+			if err != nil || !ok {
+				t.Fatalf("unexpected result for %s: %v %v", test.script, ok, err)
+			}
+
+			// Clones inherit the deprecations.
+			clone := obj.Clone()
+			err = clone.Prepare(flags)
+			if err != nil {
+				t.Fatalf("failed to compile %s: %s", test.script, err)
+			}
+			if !reflect.DeepEqual(clone.Warnings(), test.warnings) {
+				t.Fatalf("unexpected warnings for clone of %s: %v", test.script, clone.Warnings())
+			}
+		}
+	}
+
+	// Strict mode rejects them.
+	obj := New(`return len( "x" ) == 1;`)
+	obj.DeprecateFunction("len", "size")
+	err := obj.PrepareWithOptions(PrepareOptions{Strict: true})
+	if err == nil || !strings.Contains(err.Error(), "strict mode: line 1, column 11: the function len is deprecated; use size instead") {
+		t.Fatalf("expected an error, got %v", err)
+	}
+}
+
+// TestTracer tests that runs may be traced, and their traces compared.
+func TestTracer(t *testing.T) {
+
+	type Input struct {
+		Age  int
+		Name string
+	}
+
+	obj := New(`if ( Age > 18 ) { return Name == "steve"; } return false;`)
+	tracer := vm.NewTracer()
+	obj.SetTracer(tracer)
+	err := obj.Prepare()
+	if err != nil {
+		t.Fatalf("Failed to compile: %s", err.Error())
+	}
+
+	var traces [2][]vm.Step
+	for i, age := range []int{40, 10} {
+		_, err = obj.Execute(Input{Age: age, Name: "steve"})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+		traces[i] = tracer.Steps
+	}
+
+	// The predicate isn't used when tracing, so we see each step.
+	if len(traces[0]) == 0 || traces[0][0].Function != "main" || traces[0][0].Offset != 0 {
+		t.Fatalf("unexpected trace %v", traces[0])
+	}
+
+	diff := vm.DiffTraces(traces[0], traces[1])
+	if diff.Identical() {
+		t.Fatalf("expected the traces to differ")
+	}
+	if diff.Same != 1 {
+		t.Fatalf("expected only the lookup of Age to be the same, got %d", diff.Same)
+	}
+
+	// The runs differ at the jump, after the comparison.
+	jump := diff.Left[diff.Diverged-diff.Same-1]
+	if jump.Opcode != code.OpJumpIfFalse || jump.Stack[len(jump.Stack)-1] != "true" {
+		t.Fatalf("unexpected step before divergence %s", jump)
+	}
+	if !strings.Contains(diff.Describe(10), "The traces diverge after 4 steps:\n- main:0010 OpLookup") {
+		t.Fatalf("unexpected description: %s", diff.Describe(10))
+	}
+
+	// Identical runs have identical traces.
+	same := vm.DiffTraces(traces[0], traces[0])
+	if !same.Identical() || !strings.Contains(same.Describe(10), "identical") {
+		t.Fatalf("expected identical traces")
+	}
+
+	// The number of steps may be limited.
+	tracer.Limit = 2
+	_, err = obj.Execute(Input{Age: 40})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(tracer.Steps) != 2 || !tracer.Truncated {
+		t.Fatalf("expected the trace to be truncated")
+	}
+}
+
+// TestExplain tests that the comparisons made by a script are explained.
+func TestExplain(t *testing.T) {
+
+	type Input struct {
+		Count int
+		Name  string
+	}
+
+	eval := New(`return Count > 5 && Name in [ "bob", "steve", "alice", "eve" ];`)
+	err := eval.Prepare()
+	if err != nil {
+		t.Fatalf("failed to compile: %s", err)
+	}
+
+	x, err := eval.Explain(Input{Count: 10, Name: "steve"})
+	if err != nil {
+		t.Fatalf("failed to explain: %s", err)
+	}
+	if x.Expression != "script" || x.Value != "true" {
+		t.Fatalf("unexpected root %s → %s", x.Expression, x.Value)
+	}
+	if len(x.Children) != 1 || x.Children[0].Expression != "(Count > 5) && (Name in [..])" {
+		t.Fatalf("unexpected children %v", x.Children)
+	}
+
+	expected := `script → true
+  (Count > 5) && (Name in [..]) → true
+    Count > 5 → true
+      Count → 10
+    Name in [..] → true
+      Name → steve
+`
+	if x.String() != expected {
+		t.Fatalf("unexpected explanation:\n%s", x)
+	}
+
+	// The comparison which failed is shown.
+	x, err = eval.Explain(Input{Count: 1, Name: "steve"})
+	if err != nil {
+		t.Fatalf("failed to explain: %s", err)
+	}
+	if x.Value != "false" || !strings.Contains(x.String(), "Count > 5 → false") {
+		t.Fatalf("unexpected explanation:\n%s", x)
+	}
+
+	// A failure explains what happened before it.
+	eval = New(`if ( Count == 1 ) { return Foo( ); } return false;`)
+	err = eval.Prepare()
+	if err != nil {
+		t.Fatalf("failed to compile: %s", err)
+	}
+	x, err = eval.Explain(Input{Count: 1})
+	if err == nil {
+		t.Fatalf("expected an error calling an unknown function")
+	}
+	if x == nil || x.Value != "error" || !strings.Contains(x.String(), "Count == 1 → true") {
+		t.Fatalf("unexpected explanation:\n%s", x)
+	}
+
+	// Loaded programs have no script to explain.
+	loaded, err := NewFromBytecode(mustSave(t, `return true;`))
+	if err != nil {
+		t.Fatalf("failed to load: %s", err)
+	}
+	_, err = loaded.Explain(nil)
+	if err == nil || !strings.Contains(err.Error(), "can't be explained") {
+		t.Fatalf("expected an error explaining a loaded program, got %v", err)
+	}
+}
+
+// TestRunContext tests that a script may be cancelled via a context.
+func TestRunContext(t *testing.T) {
+
+	eval := New(`foreach x in Items { while ( true ) { } } return true;`)
+	eval.SetLoopLimit(0)
+	err := eval.Prepare()
+	if err != nil {
+		t.Fatalf("failed to compile: %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err = eval.RunContext(ctx, map[string]interface{}{"Items": []int{1}})
+	if err == nil || !strings.Contains(err.Error(), "timeout during execution") {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the error of the context, got %v", err)
+	}
+
+	// Waiting upon a channel stops too.
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	start := time.Now()
+	_, err = eval.RunContext(ctx, map[string]interface{}{"Items": make(chan int)})
+	if !errors.Is(err, context.Canceled) || !strings.Contains(err.Error(), "execution cancelled") {
+		t.Fatalf("expected a cancellation, got %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatalf("the run wasn't cancelled whilst waiting upon the channel")
+	}
+
+	// Without the loop the script finishes.
+	ok, err := eval.RunContext(context.Background(), map[string]interface{}{"Items": []int{}})
+	if err != nil || !ok {
+		t.Fatalf("unexpected result %v %v", ok, err)
+	}
+}
+
+// TestClauseStatistics tests that the clauses of a script are counted.
+func TestClauseStatistics(t *testing.T) {
+
+	type Input struct {
+		Count int
+		Name  string
+	}
+
+	eval := New(`if ( Count > 5 && Name != "" ) {
+  return true;
+}
+return !( Count < 0 );`)
+	eval.SetClauseStatistics(true)
+	err := eval.Prepare()
+	if err != nil {
+		t.Fatalf("failed to compile: %s", err)
+	}
+
+	for _, obj := range []Input{{10, "steve"}, {1, "bob"}, {20, ""}} {
+		_, err = eval.Run(obj)
+		if err != nil {
+			t.Fatalf("failed to run: %s", err)
+		}
+	}
+
+	expected := []string{
+		"line 1, column 12: Count > 5 - true 2, false 1",
+		"line 1, column 17: (Count > 5) && (Name != \"\") - true 1, false 2",
+		"line 1, column 25: Name != \"\" - true 2, false 1",
+		"line 4, column 8: !(Count < 0) - true 2, false 0",
+		"line 4, column 17: Count < 0 - true 0, false 2",
+	}
+	stats := eval.ClauseStatistics()
+	if len(stats) != len(expected) {
+		t.Fatalf("unexpected statistics %v", stats)
+	}
+	for i, s := range stats {
+		if s.String() != expected[i] {
+			t.Fatalf("unexpected statistic %d: %s != %s", i, s, expected[i])
+		}
+	}
+	if stats[0].Dead() || !stats[4].Dead() {
+		t.Fatalf("unexpected dead clauses")
+	}
+
+	eval.ResetClauseStatistics()
+	for _, s := range eval.ClauseStatistics() {
+		if s.True != 0 || s.False != 0 {
+			t.Fatalf("the statistics weren't reset: %s", s)
+		}
+	}
+
+	// Without statistics there's nothing to count.
+	eval.SetClauseStatistics(false)
+	err = eval.Prepare()
+	if err != nil {
+		t.Fatalf("failed to compile: %s", err)
+	}
+	eval.Run(Input{})
+	if len(eval.ClauseStatistics()) != 0 {
+		t.Fatalf("unexpected statistics")
+	}
+}
+
+// TestReorderClauses tests that the clauses of `&&` chains are reordered,
+// without changing the results.
+func TestReorderClauses(t *testing.T) {
+
+	type Input struct {
+		A       int
+		B       int
+		Level   string
+		Message string
+	}
+
+	// first returns the name of the first variable the script looks up.
+	first := func(e *Eval) string {
+		for i := 0; i < len(e.instructions); i += code.Length(code.Opcode(e.instructions[i])) {
+			if code.Opcode(e.instructions[i]) == code.OpLookup {
+				idx := int(binary.BigEndian.Uint16(e.instructions[i+1:]))
+				return e.constants[idx].Inspect()
+			}
+		}
+		return ""
+	}
+
+	scripts := []string{
+		`return Message ~= /panic/i && Level == "error";`,
+		`return A == 1 && B == 2 && Level != "";`,
+		`return A > 0 && !( B < 2 ) && Message in [ "a", "b" ];`,
+	}
+	inputs := []Input{
+		{1, 2, "error", "panic"},
+		{1, 3, "error", "a"},
+		{0, 2, "", "PANIC"},
+		{1, 2, "info", "b"},
+	}
+
+	for _, script := range scripts {
+
+		plain := New(script)
+		err := plain.Prepare()
+		if err != nil {
+			t.Fatalf("failed to compile %s: %s", script, err)
+		}
+		reordered := New(script)
+		err = reordered.Prepare([]byte{ReorderClauses})
+		if err != nil {
+			t.Fatalf("failed to compile %s: %s", script, err)
+		}
+
+		for _, obj := range inputs {
+			a, err := plain.Run(obj)
+			if err != nil {
+				t.Fatalf("failed to run %s: %s", script, err)
+			}
+			b, err := reordered.Run(obj)
+			if err != nil {
+				t.Fatalf("failed to run reordered %s: %s", script, err)
+			}
+			if a != b {
+				t.Fatalf("reordering %s changed the result for %v", script, obj)
+			}
+		}
+	}
+
+	// The regular expression is tested last.
+	eval := New(scripts[0])
+	err := eval.Prepare([]byte{ReorderClauses})
+	if err != nil {
+		t.Fatalf("failed to compile: %s", err)
+	}
+	if first(eval) != "Level" {
+		t.Fatalf("the clauses weren't reordered")
+	}
+
+	// Statistics decide which of two equal clauses is first.
+	eval = New(`return A == 1 && B == 2;`)
+	eval.SetClauseStatistics(true)
+	err = eval.Prepare()
+	if err != nil {
+		t.Fatalf("failed to compile: %s", err)
+	}
+	for i := 0; i < 10; i++ {
+		eval.Run(Input{A: 1, B: i})
+	}
+	eval.SetClauseStatistics(false)
+	eval.SetClauseHints(eval.ClauseStatistics())
+	err = eval.Prepare([]byte{ReorderClauses})
+	if err != nil {
+		t.Fatalf("failed to compile: %s", err)
+	}
+	if first(eval) != "B" {
+		t.Fatalf("the clauses weren't reordered by their statistics")
+	}
+
+	// Clauses with side-effects are left alone.
+	eval = New(`function f() { return true; } return Message ~= /x/ && f();`)
+	err = eval.Prepare([]byte{ReorderClauses})
+	if err != nil {
+		t.Fatalf("failed to compile: %s", err)
+	}
+	if first(eval) != "Message" {
+		t.Fatalf("the clauses were reordered")
+	}
+}
+
+// TestErrorPositions tests that runtime errors report where they occurred.
+func TestErrorPositions(t *testing.T) {
+
+	tests := []struct {
+		input string
+		error string
+	}{
+		{`a = 0;
+return 3 / a;`, "attempted division by zero: 3 / 0 at line 2, column 10"},
+		{`x = 1 + 2 * 3;
+if ( x == 7 ) {
+  return Name / 2;
+}`, "at line 3, column 15"},
+		{`function f( n ) {
+  local x;
+  x = n / 0;
+  return x;
+}
+return f( 3 );`, "attempted division by zero: 3 / 0 at line 3, column 9 - in f:"},
+		{`i = 0;
+while ( i < 3 ) {
+  i++;
+  Name[i] = Name;
+}`, "at line 4, column 11"},
+	}
+
+	for _, test := range tests {
+		for _, flags := range [][]byte{nil, {NoOptimize}} {
+
+			eval := New(test.input)
+			err := eval.Prepare(flags)
+			if err != nil {
+				t.Fatalf("failed to compile %s: %s", test.input, err)
+			}
+
+			_, err = eval.Run(map[string]interface{}{"Name": "steve"})
+			if err == nil || !strings.Contains(err.Error(), test.error) {
+				t.Fatalf("expected error '%s' for %s, got %v", test.error, test.input, err)
+			}
+		}
+	}
+}
+
+// TestDisassemble ensures the bytecode of a script may be retrieved.
+func TestDisassemble(t *testing.T) {
+
+	eval := New(`function f( n ) { print( n ); return true; }
+return f( Name ) && ( function( x ) { print( x ); return x; } )( true );`)
+
+	_, err := eval.Disassemble()
+	if err == nil {
+		t.Fatalf("expected an error before preparation")
+	}
+
+	err = eval.Prepare([]byte{NoOptimize})
+	if err != nil {
+		t.Fatalf("failed to compile: %s", err)
+	}
+
+	d, err := eval.Disassemble()
+	if err != nil {
+		t.Fatalf("failed to disassemble: %s", err)
+	}
+
+	if d.Bytecode[0].Opcode != "OpLookup" || d.Bytecode[0].Arg == nil || d.Bytecode[0].Comment != "lookup field/variable: Name" {
+		t.Fatalf("unexpected first instruction %v", d.Bytecode[0])
+	}
+	last := d.Bytecode[len(d.Bytecode)-1]
+	if last.Opcode != "OpReturn" || last.Arg != nil {
+		t.Fatalf("unexpected last instruction %v", last)
+	}
+	if len(d.Constants) != len(eval.constants) {
+		t.Fatalf("expected %d constants, got %d", len(eval.constants), len(d.Constants))
+	}
+	if len(d.Functions) != 2 || d.Functions[0].Name != "f" || d.Functions[1].Name != "" {
+		t.Fatalf("unexpected functions %v", d.Functions)
+	}
+	if len(d.Functions[1].Bytecode) == 0 || d.Functions[1].Arguments[0] != "x" {
+		t.Fatalf("unexpected anonymous function %v", d.Functions[1])
+	}
+}
+
+// TestErrorTypes ensures the errors of each phase have their own types.
+func TestErrorTypes(t *testing.T) {
+
+	// A syntax error.
+	eval := New("x = 1;\nreturn 1 +;")
+	err := eval.Prepare()
+	var perr *ParseError
+	if !errors.As(err, &perr) {
+		t.Fatalf("expected a parse error, got %T %v", err, err)
+	}
+	if perr.Phase != "parse" || perr.Position.Line != 2 || perr.Position.Column != 11 || perr.Token.Literal != ";" {
+		t.Fatalf("unexpected parse error %+v", perr)
+	}
+
+	// As is a broken pragma.
+	eval = New("// pragma loop-limit\nreturn true;")
+	err = eval.Prepare()
+	if !errors.As(err, &perr) || perr.Position.Line != 1 {
+		t.Fatalf("expected a parse error for the pragma, got %T %v", err, err)
+	}
+
+	// A script which parses, but doesn't compile.
+	eval = New("x = 1;\na[1]() += 3;")
+	err = eval.Prepare()
+	var cerr *CompileError
+	if !errors.As(err, &cerr) {
+		t.Fatalf("expected a compile error, got %T %v", err, err)
+	}
+	if cerr.Phase != "compile" || cerr.Position.Line != 2 || cerr.Error() != "left-most operand for += must be an identifier" {
+		t.Fatalf("unexpected compile error %+v", cerr)
+	}
+
+	// A script which fails as it runs.
+	eval = New("x = 1;\nreturn x / 0;")
+	err = eval.Prepare([]byte{NoOptimize})
+	if err != nil {
+		t.Fatalf("failed to compile: %s", err)
+	}
+	_, err = eval.Run(nil)
+	var rerr *RuntimeError
+	if !errors.As(err, &rerr) {
+		t.Fatalf("expected a runtime error, got %T %v", err, err)
+	}
+	if rerr.Phase != "run" || rerr.Position.Line != 2 || rerr.Unwrap() == nil {
+		t.Fatalf("unexpected runtime error %+v", rerr)
+	}
+
+	// Whilst the instruction limit is reported as it always was.
+	eval = New("while ( true ) { }")
+	eval.SetMaxInstructions(100)
+	err = eval.Prepare()
+	if err != nil {
+		t.Fatalf("failed to compile: %s", err)
+	}
+	_, err = eval.Run(nil)
+	if _, ok := err.(*vm.InstructionLimitError); !ok {
+		t.Fatalf("expected the instruction limit, got %T %v", err, err)
+	}
+}
+
+// TestOptionalSemicolons ensures that scripts which omit semicolons at the
+// ends of lines may be run.
+func TestOptionalSemicolons(t *testing.T) {
+
+	tests := []string{
+		"x = 1\ny = 2\nreturn x + y == 3\n",
+		"i = 0\nwhile ( i < 3 ) {\n  i++\n  if ( i == 2 ) { break }\n}\nreturn i == 2",
+		"function f( a,\n  b ) {\n  local c\n  c = a +\n    b\n  return c\n}\nreturn f( 1, 2 ) == 3",
+		"e = { \"type\": \"login\",\n  \"user\": \"steve\" }\nr = match e {\n  { type: \"login\" } => {\n    return true\n  }\n  _ => return false\n}\nreturn r",
+		"x = [ 1,\n  2 ]\nreturn len( x ) == 2\n  ? true\n  : false",
+	}
+
+	for _, test := range tests {
+
+		eval := New(test)
+		err := eval.Prepare()
+		if err != nil {
+			t.Fatalf("failed to compile %s: %s", test, err)
+		}
+		res, err := eval.Run(nil)
+		if err != nil || !res {
+			t.Fatalf("expected %s to return true, got %t %v", test, res, err)
+		}
+	}
+}
+
+// TestPrepareOptions tests the options which may be given when a script
+// is prepared.
+func TestPrepareOptions(t *testing.T) {
+
+	script := `if ( false ) { return false; } return ( ( ( 1 + 2 ) ) ) == 3;`
+
+	// The defaults are the same as Prepare.
+	eval := New(script)
+	err := eval.PrepareWithOptions(PrepareOptions{})
+	if err != nil {
+		t.Fatalf("failed to compile: %s", err)
+	}
+	if len(eval.Warnings()) != 1 {
+		t.Fatalf("expected a warning, got %v", eval.Warnings())
+	}
+	res, err := eval.Run(nil)
+	if err != nil || !res {
+		t.Fatalf("expected true, got %t %v", res, err)
+	}
+
+	// Without the optimizer there are no warnings.
+	err = eval.PrepareWithOptions(PrepareOptions{Optimization: OptimizeNone})
+	if err != nil {
+		t.Fatalf("failed to compile: %s", err)
+	}
+	if len(eval.Warnings()) != 0 {
+		t.Fatalf("unexpected warnings %v", eval.Warnings())
+	}
+
+	// In strict mode the warning is an error, with its position.
+	err = eval.PrepareWithOptions(PrepareOptions{Strict: true})
+	var cerr *CompileError
+	if !errors.As(err, &cerr) {
+		t.Fatalf("expected a compile error, got %T %v", err, err)
+	}
+	if cerr.Position.Line != 1 || cerr.Position.Column != 3 || !strings.Contains(cerr.Message, "condition is always false") {
+		t.Fatalf("unexpected error %v at %s", cerr, cerr.Position)
+	}
+
+	// Nesting may be limited.
+	err = eval.PrepareWithOptions(PrepareOptions{MaxNesting: 4})
+	if err != nil {
+		t.Fatalf("failed to compile: %s", err)
+	}
+	err = eval.PrepareWithOptions(PrepareOptions{MaxNesting: 2})
+	var perr *ParseError
+	if !errors.As(err, &perr) || !strings.Contains(perr.Message, "nested more than 2 levels deep") {
+		t.Fatalf("expected a nesting error, got %T %v", err, err)
+	}
+
+	// As may the number of instructions.
+	eval = New("i = 0; while ( i < 100 ) { i++; } return true;")
+	err = eval.PrepareWithOptions(PrepareOptions{MaxInstructions: 50})
+	if err != nil {
+		t.Fatalf("failed to compile: %s", err)
+	}
+	_, err = eval.Run(nil)
+	if _, ok := err.(*vm.InstructionLimitError); !ok {
+		t.Fatalf("expected the instruction limit, got %T %v", err, err)
+	}
+
+	// The flags are converted to options.
+	tests := []struct {
+		flags    [][]byte
+		expected OptimizationLevel
+	}{
+		{nil, OptimizeDefault},
+		{[][]byte{{NoOptimize}}, OptimizeNone},
+		{[][]byte{{ReorderClauses}}, OptimizeReorder},
+		{[][]byte{{ReorderClauses, NoOptimize}}, OptimizeNone},
+		{[][]byte{{NoOptimize}, {ReorderClauses}}, OptimizeNone},
+	}
+	for _, test := range tests {
+		if opts := options(test.flags); opts.Optimization != test.expected {
+			t.Errorf("expected %v to give %d, got %d", test.flags, test.expected, opts.Optimization)
+		}
+	}
+}
+
+// TestSQLKeywords tests that keywords may be written in any case, and that
+// `AND`, `OR`, and `NOT` may be used in place of operators, when enabled.
+func TestSQLKeywords(t *testing.T) {
+
+	type Input struct {
+		Age    int
+		Banned bool
+		Name   string
+	}
+
+	tests := []struct {
+		script string
+		input  Input
+		result bool
+	}{
+		{`IF ( Age > 18 AND NOT Banned ) { RETURN TRUE; } RETURN FALSE;`, Input{Age: 20}, true},
+		{`IF ( Age > 18 AND NOT Banned ) { RETURN TRUE; } RETURN FALSE;`, Input{Age: 20, Banned: true}, false},
+		{`return Name == "steve" or Age < 10 Or Age > 90;`, Input{Age: 5}, true},
+		{`If ( Name In [ "bob", "steve" ] ) { Return True; } Else { Return False; }`, Input{Name: "bob"}, true},
+		{`return not ( Age == 1 and Banned );`, Input{Age: 1, Banned: true}, false},
+	}
+
+	for _, test := range tests {
+
+		eval := New(test.script)
+		eval.SetSQLKeywords(true)
+		err := eval.Prepare()
+		if err != nil {
+			t.Fatalf("failed to compile %s: %s", test.script, err)
+		}
+		res, err := eval.Run(test.input)
+		if err != nil {
+			t.Fatalf("failed to run %s: %s", test.script, err)
+		}
+		if res != test.result {
+			t.Fatalf("expected %s to return %t", test.script, test.result)
+		}
+
+		// The explanation sees the same script.
+		if _, err = eval.Explain(test.input); err != nil {
+			t.Fatalf("failed to explain %s: %s", test.script, err)
+		}
+	}
+
+	// Without the option the keywords are unknown.
+	eval := New(`IF ( Age > 18 AND NOT Banned ) { RETURN TRUE; }`)
+	if err := eval.Prepare(); err == nil {
+		t.Fatalf("expected an error without the option")
+	}
+}
+
+// TestRunAllocations tests that running a simple script repeatedly doesn't
+// allocate, and that the values which outlive a run are left alone.
+func TestRunAllocations(t *testing.T) {
+
+	type Input struct {
+		Origin string
+		Value  int
+		Ratio  float64
+		Active bool
+	}
+
+	script := `if ( ( Origin == "MOW" || Value >= 100 ) && Ratio < 1.0 && Active ) { return true; } else { return false; }`
+
+	objects := []interface{}{
+		&Input{Origin: "MOW", Value: 99, Ratio: 0.5, Active: true},
+		map[string]interface{}{"Origin": "MOW", "Value": 99, "Ratio": 0.5, "Active": true},
+	}
+
+	for _, obj := range objects {
+		eval := New(script)
+		err := eval.Prepare()
+		if err != nil {
+			t.Fatalf("failed to compile: %s", err)
+		}
+		allocs := testing.AllocsPerRun(100, func() {
+			ok, err := eval.Run(obj)
+			if err != nil || !ok {
+				t.Fatalf("unexpected result: %v %v", ok, err)
+			}
+		})
+		if allocs != 0 {
+			t.Fatalf("running against %T made %v allocations", obj, allocs)
+		}
+	}
+
+	// The fields which are returned, or stored, survive later runs.
+	eval := New(`saved = Name; return Name;`)
+	err := eval.Prepare()
+	if err != nil {
+		t.Fatalf("failed to compile: %s", err)
+	}
+	first, err := eval.Execute(map[string]interface{}{"Name": "Steve"})
+	if err != nil {
+		t.Fatalf("failed to run: %s", err)
+	}
+	_, err = eval.Execute(map[string]interface{}{"Name": "Bob"})
+	if err != nil {
+		t.Fatalf("failed to run: %s", err)
+	}
+	if first.Inspect() != "Steve" || eval.GetVariable("saved").Inspect() != "Bob" {
+		t.Fatalf("a field was modified by a later run: %s %s", first.Inspect(), eval.GetVariable("saved").Inspect())
+	}
+
+	// As do the arrays which hold them.
+	eval = New(`return Names;`)
+	err = eval.Prepare()
+	if err != nil {
+		t.Fatalf("failed to compile: %s", err)
+	}
+	names, err := eval.Execute(map[string]interface{}{"Names": []string{"Steve"}})
+	if err != nil {
+		t.Fatalf("failed to run: %s", err)
+	}
+	_, err = eval.Execute(map[string]interface{}{"Names": []string{"Bob"}})
+	if err != nil {
+		t.Fatalf("failed to run: %s", err)
+	}
+	if names.Inspect() != "[Steve]" {
+		t.Fatalf("an array was modified by a later run: %s", names.Inspect())
+	}
+}
+
+// TestIncrementShared tests that incrementing a variable doesn't modify the
+// objects it might share with constants, or other variables.
+func TestIncrementShared(t *testing.T) {
+
+	tests := []struct {
+		script string
+		result string
+	}{
+		{`i = 70000; i++; return i;`, "70001"},
+		{`i = 7.5; i--; return i;`, "6.5"},
+		{`a = 70000; b = a; a++; return b;`, "70000"},
+		{`a = len("abc"); a++; return len("xyz");`, "3"},
+		{`i = 1024; i++; i++; return i;`, "1026"},
+	}
+
+	for _, test := range tests {
+		eval := New(test.script)
+		err := eval.Prepare()
+		if err != nil {
+			t.Fatalf("failed to compile %s: %s", test.script, err)
+		}
+
+		// Each run gives the same result.
+		for i := 0; i < 3; i++ {
+			out, err := eval.Execute(nil)
+			if err != nil {
+				t.Fatalf("failed to run %s: %s", test.script, err)
+			}
+			if out.Inspect() != test.result {
+				t.Fatalf("run %d of %s gave %s, expected %s", i, test.script, out.Inspect(), test.result)
+			}
+		}
+	}
+}
+
+// TestMemberPaths tests looking up the nested fields of structures, and
+// maps, via paths such as `Request.Header.Host`.
+func TestMemberPaths(t *testing.T) {
+
+	type Header struct {
+		Host string
+		Port int
+	}
+	type Request struct {
+		Header  Header
+		Proxy   *Header
+		Headers map[string]interface{}
+	}
+	type Input struct {
+		Request *Request
+		Name    string
+	}
+
+	input := func() *Input {
+		return &Input{
+			Request: &Request{
+				Header:  Header{Host: "example.com", Port: 80},
+				Headers: map[string]interface{}{"Accept": map[string]interface{}{"Type": "text/html"}},
+			},
+			Name: "steve",
+		}
+	}
+
+	tests := []struct {
+		script string
+		result string
+	}{
+		{`return Request.Header.Host;`, "example.com"},
+		{`return Request.Header.Port + 1;`, "81"},
+		{`return Request.Headers.Accept.Type;`, "text/html"},
+		{`return $Request.Header.Host;`, "example.com"},
+
+		// Missing, and nil, members are null.
+		{`return type(Request.Proxy.Host);`, "null"},
+		{`return type(Request.Missing.Host);`, "null"},
+		{`return type(Request.Headers.Missing.Type);`, "null"},
+		{`return type(Missing.Header.Host);`, "null"},
+
+		// Structures, and maps, are converted.
+		{`return Request.Header;`, "{Host: example.com, Port: 80}"},
+
+		// Modifications are seen by later lookups.
+		{`h = Request.Header; h.Host = "a"; return Request.Header.Host;`, "a"},
+		{`Request.Header.Host = "b"; return Request.Header.Host;`, "b"},
+		{`Request.Header.Port++; return Request.Header.Port;`, "81"},
+
+		// Paths may begin with a variable.
+		{`x = { "a": { "b": 7 } }; return x.a.b;`, "7"},
+		{`x = null; return type(x.a.b);`, "null"},
+		{`function f(q) { return q.a.b; } return f({ "a": { "b": 9 } });`, "9"},
+		{`Request = { "Header": { "Host": "c" } }; return Request.Header.Host;`, "c"},
+		{`function make( h ) { return function() { return h.a.b; }; } g = make( { "a": { "b": 3 } } ); h = null; return g();`, "3"},
+	}
+
+	for _, test := range tests {
+		eval := New(test.script)
+		err := eval.Prepare()
+		if err != nil {
+			t.Fatalf("failed to compile %s: %s", test.script, err)
+		}
+
+		// The input isn't modified, so repeated runs agree.
+		obj := input()
+		for i := 0; i < 2; i++ {
+			out, err := eval.Execute(obj)
+			if err != nil {
+				t.Fatalf("failed to run %s: %s", test.script, err)
+			}
+			if out.Inspect() != test.result {
+				t.Fatalf("run %d of %s gave %s, expected %s", i, test.script, out.Inspect(), test.result)
+			}
+		}
+		if obj.Request.Header.Host != "example.com" {
+			t.Fatalf("running %s modified the input", test.script)
+		}
+	}
+
+	// Indexing something other than a structure, or map, still fails.
+	eval := New(`return Name.Length;`)
+	eval.Prepare()
+	_, err := eval.Execute(input())
+	if err == nil {
+		t.Fatalf("expected an error indexing a string")
+	}
+
+	// The input may be modified via a path.
+	eval = New(`Request.Header.Host = "d"; return Request.Header.Host == "d";`)
+	eval.SetEventMode(vm.EventReadWrite)
+	eval.Prepare()
+	obj := input()
+	ret, err := eval.Run(obj)
+	if err != nil || !ret || obj.Request.Header.Host != "d" {
+		t.Fatalf("unexpected result modifying a path: %v %v %s", ret, err, obj.Request.Header.Host)
+	}
+
+	// Predicates may use paths, without allocating.
+	eval = New(`return Request.Header.Host == "example.com" && Request.Headers.Accept.Type == "text/html";`)
+	eval.Prepare()
+	if !eval.machine.IsPredicate() {
+		t.Fatalf("expected a predicate")
+	}
+	obj = input()
+	allocs := testing.AllocsPerRun(100, func() {
+		ret, err := eval.Run(obj)
+		if err != nil || !ret {
+			t.Fatalf("unexpected result: %v %v", ret, err)
+		}
+	})
+	if allocs != 0 {
+		t.Fatalf("looking up paths made %v allocations", allocs)
+	}
+
+	// As may the machine.
+	eval = New(`if ( Request.Header.Port > 10 ) { return Request.Header.Host == "example.com"; } return false;`)
+	eval.Prepare()
+	allocs = testing.AllocsPerRun(100, func() {
+		ret, err := eval.Run(obj)
+		if err != nil || !ret {
+			t.Fatalf("unexpected result: %v %v", ret, err)
+		}
+	})
+	if allocs != 0 {
+		t.Fatalf("looking up paths made %v allocations", allocs)
+	}
+}
+
+// TestDocuments tests running scripts against decoded JSON documents,
+// which nest maps and slices within each other.
+func TestDocuments(t *testing.T) {
+
+	input := `{
+  "user": { "name": "steve", "address": { "city": "Helsinki" }, "roles": [ "admin", "dev" ] },
+  "items": [ { "name": "a", "qty": 2, "tags": [ "x" ] }, { "name": "b", "qty": 3, "tags": [] } ],
+  "matrix": [ [ 1, 2 ], [ 3, 4 ] ],
+  "count": 3,
+  "ratio": 0.5,
+  "nothing": null
+}`
+
+	tests := []struct {
+		script string
+		result string
+	}{
+		{`return user.address.city;`, "Helsinki"},
+		{`return user["address"]["city"];`, "Helsinki"},
+		{`return user.roles[1];`, "dev"},
+		{`return "admin" in user.roles;`, "true"},
+		{`return items[1].name;`, "b"},
+		{`return items[0].tags[0];`, "x"},
+		{`return matrix[1][0] == 3;`, "true"},
+		{`return len(items);`, "2"},
+		{`total = 0; foreach item in items { total = total + item.qty; } return total == 5;`, "true"},
+		{`return count == 3 && ratio < 1;`, "true"},
+		{`return keys(user);`, "[address, name, roles]"},
+
+		// The members of missing values are null.
+		{`return type(items[5].name);`, "null"},
+		{`return type(items[0].missing.name);`, "null"},
+		{`return type(nothing.name);`, "null"},
+		{`return type(user.missing.name);`, "null"},
+	}
+
+	for _, numbers := range []bool{false, true} {
+
+		var doc map[string]interface{}
+		dec := json.NewDecoder(strings.NewReader(input))
+		if numbers {
+			dec.UseNumber()
+		}
+		err := dec.Decode(&doc)
+		if err != nil {
+			t.Fatalf("failed to decode: %s", err)
+		}
+
+		for _, test := range tests {
+			eval := New(test.script)
+			err := eval.Prepare()
+			if err != nil {
+				t.Fatalf("failed to compile %s: %s", test.script, err)
+			}
+			out, err := eval.Execute(doc)
+			if err != nil {
+				t.Fatalf("failed to run %s: %s", test.script, err)
+			}
+			if out.Inspect() != test.result {
+				t.Fatalf("%s gave %s, expected %s", test.script, out.Inspect(), test.result)
+			}
+		}
+	}
+
+	// Indexing null is still an error.
+	eval := New(`return nothing["name"];`)
+	eval.Prepare()
+	_, err := eval.Execute(map[string]interface{}{"nothing": nil})
+	if err == nil {
+		t.Fatalf("expected an error indexing null")
+	}
+
+	// Numbers decoded as json.Number are integers where possible.
+	tests = []struct {
+		script string
+		result string
+	}{
+		{`return type(Count);`, "integer"},
+		{`return type(Ratio);`, "float"},
+		{`return Count + 1;`, "4"},
+		{`return Values[0] * 2;`, "14"},
+	}
+	doc := map[string]interface{}{
+		"Count":  json.Number("3"),
+		"Ratio":  json.Number("0.5"),
+		"Values": []interface{}{json.Number("7")},
+	}
+	for _, test := range tests {
+		eval := New(test.script)
+		err := eval.Prepare()
+		if err != nil {
+			t.Fatalf("failed to compile %s: %s", test.script, err)
+		}
+		out, err := eval.Execute(doc)
+		if err != nil || out.Inspect() != test.result {
+			t.Fatalf("%s gave %v %v, expected %s", test.script, out, err, test.result)
+		}
+	}
+
+	// And may be modified.
+	type Counter struct {
+		N json.Number
+	}
+	counter := &Counter{N: "4"}
+	eval = New(`N++; return true;`)
+	eval.SetEventMode(vm.EventReadWrite)
+	eval.Prepare()
+	_, err = eval.Run(counter)
+	if err != nil || counter.N != "5" {
+		t.Fatalf("unexpected result modifying a number: %v %s", err, counter.N)
+	}
+}
+
+// TestRunJSON tests running scripts against raw JSON documents.
+func TestRunJSON(t *testing.T) {
+
+	input := []byte(`{
+  "user": { "name": "steve", "address": { "city": "Helsinki" }, "roles": [ "admin", "dev" ] },
+  "id": 9007199254740993,
+  "ratio": 0.5,
+  "quoted": "a \"quoted\" é",
+  "active": true,
+  "nothing": null
+}`)
+
+	tests := []struct {
+		script string
+		result bool
+	}{
+		{`return user.name == "steve" && user.address.city == "Helsinki";`, true},
+		{`return "admin" in user.roles && len(user.roles) == 2;`, true},
+		{`return id == 9007199254740993;`, true},
+		{`return ratio > 0.25 && type(ratio) == "float";`, true},
+		{`return quoted == "a \"quoted\" é";`, true},
+		{`return active && type(nothing) == "null";`, true},
+		{`return type(nothing.name) == "null" && type(missing) == "null";`, true},
+		{`return keys(user.address)[0] == "city";`, true},
+		{`return active == false;`, false},
+	}
+
+	for _, test := range tests {
+		eval := New(test.script)
+		err := eval.Prepare()
+		if err != nil {
+			t.Fatalf("failed to compile %s: %s", test.script, err)
+		}
+		res, err := eval.RunJSON(input)
+		if err != nil {
+			t.Fatalf("failed to run %s: %s", test.script, err)
+		}
+		if res != test.result {
+			t.Fatalf("%s gave %t, expected %t", test.script, res, test.result)
+		}
+	}
+
+	// Documents must be objects, which may be parsed.
+	errors := []struct {
+		input string
+		err   string
+	}{
+		{`[ 1, 2 ]`, "not an object"},
+		{`null`, "not an object"},
+		{``, "not an object"},
+		{`{ "a": `, "failed to parse"},
+		{`{ "a": 1 } { "b": 2 }`, "failed to parse"},
+	}
+	eval := New(`return true;`)
+	eval.Prepare()
+	for _, test := range errors {
+		_, err := eval.RunJSON([]byte(test.input))
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Fatalf("expected an error containing '%s' for %s, got %v", test.err, test.input, err)
+		}
+	}
+}
+
+// TestFieldTags tests naming the fields of structures via their tags.
+func TestFieldTags(t *testing.T) {
+
+	type Address struct {
+		City string `json:"city" evalfilter:"town"`
+	}
+	type Base struct {
+		Source string `json:"source"`
+	}
+	type Event struct {
+		Base
+		UserID  int     `json:"user_id,omitempty"`
+		Name    string  `json:"-"`
+		Admin   bool    `json:",omitempty"`
+		Address Address `json:"address"`
+		Meta    Base    `json:"meta"`
+		Score   float64
+	}
+
+	event := &Event{
+		Base:    Base{Source: "web"},
+		UserID:  42,
+		Name:    "steve",
+		Admin:   true,
+		Address: Address{City: "Helsinki"},
+		Meta:    Base{Source: "api"},
+		Score:   2.5,
+	}
+
+	tests := []struct {
+		tag    string
+		script string
+		result string
+	}{
+		{"json", `return user_id == 42;`, "true"},
+		{"json", `return user_id;`, "42"},
+		{"json", `return Admin && source == "web";`, "true"},
+		{"json", `return Score;`, "2.5"},
+		{"json", `return address.city;`, "Helsinki"},
+		{"json", `return address["city"];`, "Helsinki"},
+		{"json", `return meta.source;`, "api"},
+		{"json", `return type(Name);`, "null"},
+		{"json", `return type(UserID);`, "null"},
+		{"json", `return keys(address);`, "[city]"},
+		{"evalfilter", `return Address.town;`, "Helsinki"},
+		{"evalfilter", `return UserID;`, "42"},
+
+		// Without a tag the names are those of go.
+		{"", `return UserID == 42 && Address.City == "Helsinki";`, "true"},
+		{"", `return Name;`, "steve"},
+		{"", `return type(user_id);`, "null"},
+	}
+
+	for _, test := range tests {
+		eval := New(test.script)
+		eval.SetFieldTag(test.tag)
+		err := eval.Prepare()
+		if err != nil {
+			t.Fatalf("failed to compile %s: %s", test.script, err)
+		}
+		out, err := eval.Execute(event)
+		if err != nil {
+			t.Fatalf("failed to run %s: %s", test.script, err)
+		}
+		if out.Inspect() != test.result {
+			t.Fatalf("%s with tag '%s' gave %s, expected %s", test.script, test.tag, out.Inspect(), test.result)
+		}
+
+		// Clones use the same names.
+		out, err = eval.Clone().Execute(event)
+		if err != nil || out.Inspect() != test.result {
+			t.Fatalf("clone of %s gave %v %v, expected %s", test.script, out, err, test.result)
+		}
+	}
+
+	// Tagged fields may be modified.
+	eval := New(`user_id = 7; address.city = "Oulu"; return true;`)
+	eval.SetFieldTag("json")
+	eval.SetEventMode(vm.EventReadWrite)
+	err := eval.Prepare()
+	if err != nil {
+		t.Fatalf("failed to compile: %s", err)
+	}
+	_, err = eval.Run(event)
+	if err != nil {
+		t.Fatalf("failed to run: %s", err)
+	}
+	if event.UserID != 7 || event.Address.City != "Oulu" {
+		t.Fatalf("the event wasn't modified: %+v", event)
+	}
+}
+
+// TestLanguage tests that scripts are run by the version of the language
+// they're compiled for.
+func TestLanguage(t *testing.T) {
+
+	type Input struct {
+		Count int
+		Delta float64
+		Name  string
+	}
+	in := Input{Count: 0, Delta: -1.5, Name: ""}
+
+	tests := []struct {
+		script string
+		v1     bool
+		v2     bool
+	}{
+		{`return Delta;`, false, true},
+		{`if ( Delta ) { return true; } return false;`, false, true},
+		{`return !Count;`, false, true},
+		{`return !Name;`, false, true},
+		{`return !Delta;`, false, false},
+		{`return !true || !!false;`, false, false},
+		{`return Delta || false;`, false, true},
+		{`return len( filter( [ -1, 0, 1 ], function( x ) { return x; } ) ) == 2;`, false, true},
+		{`return -3;`, false, true},
+	}
+
+	for _, test := range tests {
+		for _, flags := range [][]byte{nil, {NoOptimize}} {
+
+			// By default, and via the options.
+			for version, expected := range map[int]bool{0: test.v1, 1: test.v1, 2: test.v2} {
+				obj := New(test.script)
+				err := obj.PrepareWithOptions(PrepareOptions{Language: version, Optimization: options([][]byte{flags}).Optimization})
+				if err != nil {
+					t.Fatalf("failed to compile %s: %s", test.script, err)
+				}
+				ret, err := obj.Run(in)
+				if err != nil || ret != expected {
+					t.Fatalf("unexpected result for %s in version %d: %v %v", test.script, version, ret, err)
+				}
+			}
+
+			// Via the pragma, which overrides the host.
+			obj := New("// pragma lang 2\n" + test.script)
+			obj.SetLanguage(vm.Language1)
+			err := obj.Prepare(flags)
+			if err != nil {
+				t.Fatalf("failed to compile %s: %s", test.script, err)
+			}
+			if obj.Language() != vm.Language2 {
+				t.Fatalf("wrong language %d", obj.Language())
+			}
+			ret, err := obj.Run(in)
+			if err != nil || ret != test.v2 {
+				t.Fatalf("unexpected result for %s with the pragma: %v %v", test.script, ret, err)
+			}
+
+			// Clones, and saved programs, keep the version.
+			ret, err = obj.Clone().Run(in)
+			if err != nil || ret != test.v2 {
+				t.Fatalf("unexpected result for clone of %s: %v %v", test.script, ret, err)
+			}
+			data, err := obj.MarshalBytecode()
+			if err != nil {
+				t.Fatalf("failed to save %s: %s", test.script, err)
+			}
+			loaded, err := NewFromBytecode(data)
+			if err != nil {
+				t.Fatalf("failed to load %s: %s", test.script, err)
+			}
+			ret, err = loaded.Run(in)
+			if err != nil || ret != test.v2 || loaded.Language() != vm.Language2 {
+				t.Fatalf("unexpected result for loaded %s: %v %v", test.script, ret, err)
+			}
+		}
+	}
+
+	// Invalid versions are rejected.
+	errors := []struct {
+		script string
+		error  string
+	}{
+		{"// pragma lang 3\nreturn true;", "line 1: the lang pragma requires a version between 1 and 2, not 3"},
+		{"// pragma lang two\nreturn true;", "not two"},
+		{"// pragma lang\nreturn true;", "requires a single argument"},
+		{"// pragma lang 1\n// pragma lang 2\nreturn true;", "line 2: the script already declares its language, upon line 1"},
+	}
+	for _, test := range errors {
+		err := New(test.script).Prepare()
+		if err == nil || !strings.Contains(err.Error(), test.error) {
+			t.Fatalf("expected an error for %s, got %v", test.script, err)
+		}
+	}
+
+	obj := New(`return true;`)
+	err := obj.SetLanguage(0)
+	if err == nil || !strings.Contains(err.Error(), "unknown language version 0") {
+		t.Fatalf("expected an error, got %v", err)
+	}
+	err = obj.PrepareWithOptions(PrepareOptions{Language: vm.LatestLanguage + 1})
+	if err == nil || !strings.Contains(err.Error(), "unknown language version") {
+		t.Fatalf("expected an error, got %v", err)
+	}
+}
+
+// TestBindSchema tests that the fields of a schema are checked when a
+// script is prepared.
+func TestBindSchema(t *testing.T) {
+
+	type Request struct {
+		Host string
+	}
+	type Event struct {
+		Count int
+		Name  string
+		Ratio float64
+		Tags  []string
+		When  time.Time
+		Req   Request
+	}
+
+	schema := map[string]object.Type{
+		"Count": object.INTEGER,
+		"Name":  object.STRING,
+		"Ratio": object.FLOAT,
+		"Tags":  object.ARRAY,
+		"When":  object.TIME,
+		"Req":   object.HASH,
+	}
+
+	when := time.Date(2020, time.March, 4, 5, 6, 7, 0, time.UTC)
+	events := []Event{
+		{Count: 4, Name: "Steve", Ratio: 0.5, Tags: []string{"a", "b"}, When: when, Req: Request{Host: "example.com"}},
+		{Count: 1, Name: "Bob", Ratio: 2, When: when.Add(time.Hour)},
+	}
+
+	valid := []struct {
+		script string
+		result []bool
+	}{
+		{`return Count > 3;`, []bool{true, false}},
+		{`return Count > 3 && Name == "Steve" && Ratio < 1;`, []bool{true, false}},
+		{`return Count + Ratio > 2.5;`, []bool{true, true}},
+		{`return Name ~= /^s/i && len(Tags) == 2;`, []bool{true, false}},
+		{`return Req.Host == "example.com";`, []bool{true, false}},
+		{`return When > 1583298367 && When < now();`, []bool{false, true}},
+		{`x = 3; return Count > x;`, []bool{true, false}},
+		{`Count = "none"; return Count == "none";`, []bool{true, true}},
+		{`function big(n) { return n > 3; } return big(Count);`, []bool{true, false}},
+		{`total = 0; foreach tag in Tags { total++; } return total == Count - 2;`, []bool{true, false}},
+		{`if ( Threshold > Count ) { return false; } return true;`, []bool{true, false}},
+		{`return $Count == 4;`, []bool{true, false}},
+	}
+
+	for _, test := range valid {
+		for _, flags := range [][]byte{nil, {NoOptimize}} {
+			obj := New(test.script)
+			obj.SetVariable("Threshold", &object.Integer{Value: 2})
+			if err := obj.BindSchema(schema); err != nil {
+				t.Fatalf("failed to bind schema: %s", err)
+			}
+			err := obj.Prepare(flags)
+			if err != nil {
+				t.Fatalf("failed to compile %s: %s", test.script, err)
+			}
+
+			// The results are the same for each object, and for
+			// clones, as the fields are found afresh each run.
+			for _, run := range []*Eval{obj, obj.Clone()} {
+				for i, ev := range events {
+					ret, err := run.Run(ev)
+					if err != nil || ret != test.result[i] {
+						t.Fatalf("unexpected result for %s against event %d: %v %v", test.script, i, ret, err)
+					}
+				}
+			}
+		}
+	}
+
+	// The fields are looked up by their index.
+	obj := New(`return Count > 3 && Count < 10;`)
+	obj.BindSchema(schema)
+	obj.Prepare([]byte{NoOptimize})
+	dis, err := obj.Disassemble()
+	if err != nil {
+		t.Fatalf("failed to disassemble: %s", err)
+	}
+	found := 0
+	for _, ins := range dis.Bytecode {
+		if ins.Opcode == "OpLookupField" {
+			found++
+		}
+	}
+	if found != 2 {
+		t.Fatalf("expected two bound lookups, found %d", found)
+	}
+
+	// Which may be saved, and loaded.
+	data, err := obj.MarshalBytecode()
+	if err != nil {
+		t.Fatalf("failed to save: %s", err)
+	}
+	loaded, err := NewFromBytecode(data)
+	if err != nil {
+		t.Fatalf("failed to load: %s", err)
+	}
+	for i, ev := range events {
+		ret, err := loaded.Run(ev)
+		if err != nil || ret != (i == 0) {
+			t.Fatalf("unexpected result from loaded program: %v %v", ret, err)
+		}
+	}
+
+	// Without a schema nothing is checked.
+	obj = New(`return Cuont > 3;`)
+	obj.BindSchema(schema)
+	obj.BindSchema(nil)
+	if err := obj.Prepare(); err != nil {
+		t.Fatalf("unexpected error without a schema: %s", err)
+	}
+
+	invalid := []struct {
+		script string
+		error  string
+		column int
+	}{
+		{`return Cuont > 3;`, "unknown field Cuont", 13},
+		{`return count > 3;`, "unknown field count, did you mean Count?", 13},
+		{`if ( Count > 3 ) { return Nmae == "Steve"; }`, "unknown field Nmae", 31},
+		{`return Count == "3";`, `type mismatch: the field Count is INTEGER, and "3" is STRING`, 0},
+		{`return Name > Count;`, "type mismatch: the field Name is STRING, and the field Count is INTEGER", 0},
+		{`return Count ~= /3/;`, "type mismatch: the field Count is INTEGER, and /3/ is REGEXP", 0},
+		{`return When == true;`, "type mismatch: the field When is TIME, and true is BOOLEAN", 0},
+		{`return Name.First == "Steve";`, "the field Name is STRING, and has no members", 0},
+		{`return Host.Name == "Steve";`, "unknown field Host", 0},
+	}
+	for _, test := range invalid {
+		obj := New(test.script)
+		obj.BindSchema(schema)
+		err := obj.Prepare()
+		if err == nil || !strings.Contains(err.Error(), test.error) {
+			t.Fatalf("expected an error for %s, got %v", test.script, err)
+		}
+		var cerr *CompileError
+		if !errors.As(err, &cerr) || cerr.Position.Line != 1 {
+			t.Fatalf("expected a compile error for %s, got %T", test.script, err)
+		}
+		if test.column != 0 && cerr.Position.Column != test.column {
+			t.Fatalf("wrong position for %s: %s", test.script, cerr.Position)
+		}
+	}
+
+	// Only the types of objects may be given.
+	err = New(`return true;`).BindSchema(map[string]object.Type{"Count": "INT"})
+	if err == nil || !strings.Contains(err.Error(), "the field Count has the unknown type INT") {
+		t.Fatalf("expected an error, got %v", err)
+	}
+
+	// A field whose type isn't known is only checked by name.
+	for _, script := range []string{`return Count == "3" || Count.Value > 3;`, `return Cuont == 3;`} {
+		obj := New(script)
+		obj.BindSchema(map[string]object.Type{"Count": object.NULL})
+		err := obj.Prepare()
+		if (err != nil) != strings.Contains(script, "Cuont") {
+			t.Fatalf("unexpected result binding %s: %v", script, err)
+		}
+	}
+}
+
+// TestInferSchema tests inferring a schema from sample events, and finding
+// the fields a script uses which the samples don't contain.
+func TestInferSchema(t *testing.T) {
+
+	samples := `{"count": 3, "name": "steve", "ratio": 1, "request": {"host": "example.com", "port": 80}, "tags": ["a"]}
+{"count": 7, "name": "bob", "ratio": 0.5, "request": {"host": "example.org"}, "extra": null, "mixed": "one"}
+{"count": 3, "name": "alice", "ratio": 2, "user": "root", "mixed": 2}
+{"count": 9, "name": "eve", "ratio": 3, "mixed": true}`
+
+	schema, err := InferSchema(strings.NewReader(samples))
+	if err != nil {
+		t.Fatalf("failed to infer schema: %s", err)
+	}
+	if schema.Samples != 4 {
+		t.Fatalf("expected four samples, got %d", schema.Samples)
+	}
+
+	expected := []struct {
+		name     string
+		types    object.Type
+		count    int
+		examples string
+	}{
+		{"count", object.INTEGER, 4, `3 7 9`},
+		{"extra", object.NULL, 1, ``},
+		{"mixed", object.NULL, 3, `"one" 2 true`},
+		{"name", object.STRING, 4, `"steve" "bob" "alice"`},
+		{"ratio", object.FLOAT, 4, `1 0.5 2`},
+		{"request", object.HASH, 2, ``},
+		{"request.host", object.STRING, 2, `"example.com" "example.org"`},
+		{"request.port", object.INTEGER, 1, `80`},
+		{"tags", object.ARRAY, 1, `["a"]`},
+		{"user", object.STRING, 1, `"root"`},
+	}
+	if len(schema.Fields) != len(expected) {
+		t.Fatalf("expected %d fields, got %v", len(expected), schema.Fields)
+	}
+	for i, field := range schema.Fields {
+		exp := expected[i]
+		if field.Name != exp.name || field.Type != exp.types || field.Count != exp.count || strings.Join(field.Examples, " ") != exp.examples {
+			t.Fatalf("unexpected field %d: %+v", i, field)
+		}
+	}
+	if mixed := schema.Fields[2].Types; len(mixed) != 3 || mixed[0] != object.BOOLEAN || mixed[1] != object.INTEGER || mixed[2] != object.STRING {
+		t.Fatalf("unexpected types of a mixed field: %v", mixed)
+	}
+
+	// The schema may be bound, to check scripts.
+	types := schema.Types()
+	if len(types) != 8 || types["request"] != object.HASH || types["ratio"] != object.FLOAT {
+		t.Fatalf("unexpected types: %v", types)
+	}
+	doc := []byte(`{"count": 3, "name": "steve", "ratio": 1.5, "request": {"host": "example.com"}}`)
+	for script, valid := range map[string]bool{
+		`return count > 2 && ratio < 2 && request.host == "example.com" && type(mixed) != "hash";`: true,
+		`return count == "3";`:   false,
+		`return cuont > 2;`:      false,
+		`return name.first > 2;`: false,
+	} {
+		obj := New(script)
+		if err := obj.BindSchema(types); err != nil {
+			t.Fatalf("failed to bind inferred schema: %s", err)
+		}
+		err := obj.Prepare()
+		if valid != (err == nil) {
+			t.Fatalf("unexpected result compiling %s: %v", script, err)
+		}
+		if err != nil {
+			continue
+		}
+		ret, err := obj.RunJSON(doc)
+		if err != nil || !ret {
+			t.Fatalf("unexpected result running %s: %v %v", script, ret, err)
+		}
+	}
+
+	// Finding the fields the samples don't contain.
+	tests := []struct {
+		script  string
+		fields  string
+		missing string
+	}{
+		{`return count > 3 && Cuont < 10;`, "Cuont count", "Cuont"},
+		{`return request.host == "example.com" && request.path == "/";`, "request.host request.path", "request.path"},
+		{`return tags.first == "a" && $user == "root";`, "tags.first user", ""},
+		{`x = count * 2; foreach t in tags { x++; } return x > limit;`, "count limit tags", "limit"},
+		{`function big(n) { return n > 3; } return big(count) && len(name) > 0;`, "count name", ""},
+		{`if ( len(map(tags, upper)) > 0 ) { return Threshold < ratio; }`, "ratio tags", ""},
+	}
+	for _, test := range tests {
+		for _, flags := range [][]byte{nil, {NoOptimize}} {
+			obj := New(test.script)
+			obj.SetVariable("Threshold", &object.Integer{Value: 2})
+			if err := obj.Prepare(flags); err != nil {
+				t.Fatalf("failed to compile %s: %s", test.script, err)
+			}
+			for _, run := range []*Eval{obj, obj.Clone()} {
+				fields := run.Fields()
+				if strings.Join(fields, " ") != test.fields {
+					t.Fatalf("unexpected fields of %s: %v", test.script, fields)
+				}
+				if missing := schema.Missing(fields); strings.Join(missing, " ") != test.missing {
+					t.Fatalf("unexpected missing fields of %s: %v", test.script, missing)
+				}
+			}
+		}
+	}
+
+	// Samples must be objects.
+	for input, msg := range map[string]string{
+		`{"a": 1} [1, 2]`: "sample 2 is not a JSON object",
+		`{"a": 1} {"a": `: "failed to parse sample 2",
+		"":                "",
+		"\n{\"a\": 1}\n":  "",
+	} {
+		_, err := InferSchema(strings.NewReader(input))
+		if msg == "" && err != nil || msg != "" && (err == nil || !strings.Contains(err.Error(), msg)) {
+			t.Fatalf("unexpected result inferring from %q: %v", input, err)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package evalfilter
+
+import (
+	"fmt"
+	"os"
+	"text/template"
+)
+
+// ExampleEval_FuncMap demonstrates using a script as a condition within
+// a template.
+func ExampleEval_FuncMap() {
+
+	//
+	// The script decides which users are shown the admin link.
+	//
+	eval := New(`return admin || name == "Steve";`)
+	err := eval.Prepare()
+	if err != nil {
+		fmt.Printf("Failed to compile the code:%s\n", err.Error())
+		return
+	}
+
+	//
+	// Make it available to the template as `evalfilter`.
+	//
+	tmpl := template.Must(template.New("page").Funcs(eval.FuncMap("evalfilter")).Parse(
+		`{{ .name }}:{{ if evalfilter . }} <a href="/admin">Admin</a>{{ end }}
+`))
+
+	users := []map[string]interface{}{
+		{"name": "Steve", "admin": false},
+		{"name": "Bob", "admin": false},
+		{"name": "Alice", "admin": true},
+	}
+
+	for _, user := range users {
+		err = tmpl.Execute(os.Stdout, user)
+		if err != nil {
+			fmt.Printf("Failed to execute the template:%s\n", err.Error())
+			return
+		}
+	}
+
+	// Output:
+	// Steve: <a href="/admin">Admin</a>
+	// Bob:
+	// Alice: <a href="/admin">Admin</a>
+}
@@ -0,0 +1,281 @@
+// This file contains the explanation of runs.
+//
+// Those who write rules often want to know why a rule matched an event,
+// or didn't, without having to read the bytecode.  `Explain` runs the
+// script against an object whilst recording the result of each of the
+// comparisons, and boolean operations, which it performs - along with
+// the values which were compared.  The results are returned as a tree:
+//
+//    script → true
+//      Count > 5 → true
+//        Count → 10
+//      Name in [..] → true
+//        Name → steve
+//
+// To record the values the script is compiled again, without the
+// optimizer, with an OpExplain instruction after each expression of
+// interest.  So the explanation is always of the script as it was
+// written.
+
+package evalfilter
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/skx/evalfilter/v2/ast"
+	"github.com/skx/evalfilter/v2/code"
+	"github.com/skx/evalfilter/v2/vm"
+)
+
+// Explanation describes the value an expression resulted in, along with
+// the values of the expressions it was made from.
+type Explanation struct {
+
+	// Expression holds the expression, such as `Count > 5`.
+	Expression string
+
+	// Value holds the value of the expression, such as `true`.
+	Value string
+
+	// Children holds the explanations of the operands of the
+	// expression, in the order they were evaluated.
+	Children []*Explanation
+}
+
+// String returns the explanation as a tree, with the operands of each
+// expression indented beneath it.
+func (x *Explanation) String() string {
+	var out strings.Builder
+	x.write(&out, 0)
+	return out.String()
+}
+
+// write writes the explanation, at the given depth.
+func (x *Explanation) write(out *strings.Builder, depth int) {
+	fmt.Fprintf(out, "%s%s → %s\n", strings.Repeat("  ", depth), x.Expression, x.Value)
+	for _, c := range x.Children {
+		c.write(out, depth+1)
+	}
+}
+
+// explained holds an expression whose value is recorded, when a script is
+// compiled to be explained.
+type explained struct {
+
+	// node holds the expression.
+	node ast.Expression
+
+	// parent holds the identifier of the expression this is an
+	// operand of, or -1 if it isn't an operand.
+	parent int
+}
+
+// Explain runs the script against the given object, and returns an
+// explanation of how the script arrived at its result.
+//
+// The root of the explanation is the script itself, along with its
+// result, and its children are the comparisons which it made, in the
+// order they were made.  A comparison which is made more than once, for
+// example within a loop, is explained each time it is made.
+//
+// If the script fails then the explanation of the comparisons made
+// before the failure is returned, along with the error.
+func (e *Eval) Explain(obj interface{}) (*Explanation, error) {
+
+	if e.loaded {
+		return nil, fmt.Errorf("the program was loaded from bytecode, and can't be explained")
+	}
+
+	tmp := New(e.Script)
+	tmp.environment = e.environment.Clone()
+	tmp.context = e.context
+	tmp.mode = e.mode
+	tmp.memory = e.memory
+	tmp.maxInstructions = e.maxInstructions
+	tmp.iterations = e.iterations
+	tmp.stringerFields = e.stringerFields
+	tmp.fieldTag = e.fieldTag
+	tmp.language = e.language
+	tmp.schema = e.schema
+	tmp.sql = e.sql
+	tmp.explain = true
+
+	err := tmp.Prepare([]byte{NoOptimize})
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := tmp.Execute(obj)
+
+	root := tmp.explanation(tmp.machine.Observations())
+	if err == nil {
+		root.Value = out.Inspect()
+	} else {
+		root.Value = "error"
+	}
+	return root, err
+}
+
+// explanation builds the explanation of the given observations.
+//
+// The operands of an expression are always evaluated before it, so when
+// we see the value of an expression its operands are waiting for it.
+func (e *Eval) explanation(observations []vm.Observation) *Explanation {
+
+	type pending struct {
+		id int
+		x  *Explanation
+	}
+	var waiting []pending
+
+	for _, o := range observations {
+		if o.ID >= len(e.explained) {
+			continue
+		}
+
+		x := &Explanation{Expression: describeExpression(e.explained[o.ID].node), Value: o.Value}
+
+		// Claim the operands which are waiting for us.
+		kept := waiting[:0]
+		for _, w := range waiting {
+			if e.explained[w.id].parent == o.ID {
+				x.Children = append(x.Children, w.x)
+			} else {
+				kept = append(kept, w)
+			}
+		}
+		waiting = append(kept, pending{id: o.ID, x: x})
+	}
+
+	root := &Explanation{Expression: "script"}
+	for _, w := range waiting {
+		root.Children = append(root.Children, w.x)
+	}
+	return root
+}
+
+// explainable returns true if the value of the given expression should
+// be recorded, along with those of its operands.
+func explainable(node ast.Expression) bool {
+
+	switch n := node.(type) {
+	case *ast.InfixExpression:
+		switch n.Operator {
+		case "==", "!=", "<", "<=", ">", ">=", "~=", "!~", "in", "&&", "||":
+			return true
+		}
+	case *ast.PrefixExpression:
+		return n.Operator == "!"
+	}
+	return false
+}
+
+// explainStart is invoked before the given expression is compiled, and
+// returns the identifier of the expression if its value is recorded, or
+// -1 otherwise.
+func (e *Eval) explainStart(node ast.Expression) int {
+
+	if !(e.explain || e.statistics) || !explainable(node) {
+		return -1
+	}
+
+	parent := -1
+	if len(e.explaining) > 0 {
+		parent = e.explaining[len(e.explaining)-1]
+	}
+
+	e.explained = append(e.explained, explained{node: node, parent: parent})
+	id := len(e.explained) - 1
+	e.explaining = append(e.explaining, id)
+	return id
+}
+
+// explainEnd is invoked after the expression with the given identifier
+// has been compiled.
+func (e *Eval) explainEnd(id int) {
+	if id >= 0 {
+		e.explaining = e.explaining[:len(e.explaining)-1]
+	}
+}
+
+// explainOperand records the value of the given operand, of the expression
+// with the given identifier, once it has been compiled.
+//
+// Literals are left alone, as their values are obvious, as are operands
+// which record their own values.  When we're only counting the results
+// of clauses no operands are recorded.
+func (e *Eval) explainOperand(id int, node ast.Expression) {
+
+	if id < 0 || !e.explain || explainable(node) {
+		return
+	}
+
+	switch node.(type) {
+	case *ast.BooleanLiteral, *ast.FloatLiteral, *ast.IntegerLiteral,
+		*ast.StringLiteral, *ast.RegexpLiteral, *ast.ArrayLiteral,
+		*ast.HashLiteral:
+		return
+	}
+
+	e.explained = append(e.explained, explained{node: node, parent: id})
+	e.emit(code.OpExplain, len(e.explained)-1)
+}
+
+// explainResult records the value of the expression with the given
+// identifier, once it has been compiled.
+func (e *Eval) explainResult(id int) {
+	if id >= 0 {
+		e.emit(code.OpExplain, id)
+	}
+}
+
+// describeExpression returns a brief description of the given expression.
+func describeExpression(node ast.Expression) string {
+
+	// operand describes an operand, which is parenthesized if it
+	// is itself made of operands.
+	operand := func(n ast.Expression) string {
+		switch n.(type) {
+		case *ast.InfixExpression, *ast.TernaryExpression:
+			return "(" + describeExpression(n) + ")"
+		}
+		return describeExpression(n)
+	}
+
+	switch n := node.(type) {
+	case *ast.Identifier:
+		return n.Value
+	case *ast.StringLiteral:
+		return fmt.Sprintf("%q", n.Value)
+	case *ast.RegexpLiteral:
+		return "/" + n.Value + "/" + n.Flags
+	case *ast.BooleanLiteral, *ast.FloatLiteral, *ast.IntegerLiteral:
+		return n.TokenLiteral()
+	case *ast.ArrayLiteral:
+		if len(n.Elements) > 3 {
+			return "[..]"
+		}
+		var els []string
+		for _, el := range n.Elements {
+			els = append(els, describeExpression(el))
+		}
+		return "[" + strings.Join(els, ", ") + "]"
+	case *ast.InfixExpression:
+		if n.Operator == "." {
+			return operand(n.Left) + "." + operand(n.Right)
+		}
+		return operand(n.Left) + " " + n.Operator + " " + operand(n.Right)
+	case *ast.PrefixExpression:
+		return n.Operator + operand(n.Right)
+	case *ast.IndexExpression:
+		return operand(n.Left) + "[" + describeExpression(n.Index) + "]"
+	case *ast.CallExpression:
+		var args []string
+		for _, a := range n.Arguments {
+			args = append(args, describeExpression(a))
+		}
+		return describeExpression(n.Function) + "(" + strings.Join(args, ", ") + ")"
+	}
+	return strings.TrimSpace(node.String())
+}
@@ -0,0 +1,85 @@
+// This file contains the code which allows scripts to be used to
+// transform objects, rather than filter them.
+
+package evalfilter
+
+import (
+	"github.com/skx/evalfilter/v2/object"
+)
+
+// Extract runs the script against the given object, and returns the
+// values it produced, which allows scripts to be used to transform or
+// enrich objects.
+//
+// If the script returns a hash then its entries are returned, otherwise
+// the global variables the script set during this run are returned.  For
+// example this script:
+//
+//	domain = lower(split(email, "@")[1]);
+//	internal = domain == "example.com";
+//
+// would return a map containing `domain` and `internal`.  Temporary
+// variables within functions should be declared with `local`, so that
+// they are not returned, or the script may return a hash to control
+// exactly what is returned.
+//
+// Integers, floats, strings, and booleans are returned as the equivalent
+// Go types, arrays as slices, hashes as maps, and null as nil.  As with
+// Run you must invoke Prepare before Extract.
+func (e *Eval) Extract(obj interface{}) (map[string]interface{}, error) {
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.environment.Track(true)
+	defer e.environment.Track(false)
+
+	out, err := e.Execute(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	if hash, ok := out.(*object.Hash); ok {
+		return toMap(hash), nil
+	}
+
+	res := make(map[string]interface{})
+	for _, name := range e.environment.Written() {
+		val, _ := e.environment.Get(name)
+		res[name] = toGo(val)
+	}
+	return res, nil
+}
+
+// toMap converts a hash to a map, keyed by the string form of its keys.
+func toMap(hash *object.Hash) map[string]interface{} {
+
+	res := make(map[string]interface{}, len(hash.Pairs))
+	for _, pair := range hash.Pairs {
+
+		key := pair.Key.Inspect()
+		if str, ok := pair.Key.(*object.String); ok {
+			key = str.Value
+		}
+		res[key] = toGo(pair.Value)
+	}
+	return res
+}
+
+// toGo converts one of our objects to the equivalent Go value.
+func toGo(obj object.Object) interface{} {
+
+	switch val := obj.(type) {
+	case nil, *object.Null, *object.Void:
+		return nil
+	case *object.Hash:
+		return toMap(val)
+	case *object.Array:
+		res := make([]interface{}, len(val.Elements))
+		for i, el := range val.Elements {
+			res[i] = toGo(el)
+		}
+		return res
+	}
+	return obj.ToInterface()
+}
@@ -4,8 +4,10 @@
 package evalfilter
 
 import (
+	"context"
 	"strings"
 	"testing"
+	"time"
 )
 
 // FuzzEvaluator runs the fuzz-testing against our evaluation engine
@@ -131,3 +133,34 @@ test(false)
 		}
 	})
 }
+
+// FuzzOptimizer runs scripts with, and without, the bytecode optimizer
+// and reports any case in which the results differ.
+func FuzzOptimizer(f *testing.F) {
+
+	f.Add([]byte(`return 10 - 3 - 2;`))
+	f.Add([]byte(`return 100 / 10 / 5;`))
+	f.Add([]byte(`return 2 * 3 + 4 % 3 - 1;`))
+	f.Add([]byte(`if ( 1 + 2 == 3 ) { return true; } return false;`))
+	f.Add([]byte(`a = 3; while ( a > 0 ) { a--; } return a;`))
+	f.Add([]byte(`switch( "c" ) { case "a" { return 1; } case "b" { return 2; } case "c" { return 3; } case "d" { return 4; } }`))
+	f.Add([]byte(`function double(n) { return n * 2; } return double(3) - 1;`))
+
+	f.Fuzz(func(t *testing.T, input []byte) {
+
+		eval := New(string(input))
+
+		// Scripts might never terminate.
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		eval.SetContext(ctx)
+
+		_, err := eval.VerifyOptimizer(nil)
+
+		// A timeout in one run only isn't a difference.
+		if err != nil && strings.Contains(err.Error(), "the optimizer changed the result") &&
+			!strings.Contains(err.Error(), "timeout") {
+			t.Fatalf("%s\n%s", err, input)
+		}
+	})
+}
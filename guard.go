@@ -0,0 +1,250 @@
+// This file contains the guards of rules, which allow a RuleSet to find
+// the rules which might match an object without running them all.
+//
+// A guard is declared via a pragma, and compares a single field with one
+// or more constants:
+//
+//	// pragma guard event.type == "dns"
+//	// pragma guard event.type in [ "dns", "mdns" ]
+//
+// Within a RuleSet a rule is only run if its guard is true.  The set
+// indexes its rules by the constants their guards compare with, so the
+// value of each field which is guarded is found once for every object,
+// and only the rules guarded by that value are considered.
+
+package evalfilter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/skx/evalfilter/v2/ast"
+	"github.com/skx/evalfilter/v2/lexer"
+	"github.com/skx/evalfilter/v2/object"
+	"github.com/skx/evalfilter/v2/parser"
+)
+
+// guard holds the guard of a rule.
+type guard struct {
+
+	// path is the field, or path of fields, the guard tests.
+	path string
+
+	// values holds the keys of the constants the field may hold, as
+	// returned by guardKey.
+	values []string
+}
+
+// guardIndex allows the rules guarded by one field to be found by the
+// value of that field.
+type guardIndex struct {
+
+	// eval is the script which returns the value of the field.
+	eval *Eval
+
+	// rules holds the indexes of the rules guarded by each value.
+	rules map[string][]int
+}
+
+// parseGuard parses the expression given to a guard pragma.
+func parseGuard(expr string) (*guard, error) {
+
+	program, err := parser.New(lexer.New("return " + expr + ";")).Parse()
+	if err != nil || len(program.Statements) != 1 {
+		return nil, fmt.Errorf("is not a valid expression: %s", expr)
+	}
+	ret, ok := program.Statements[0].(*ast.ReturnStatement)
+	if !ok {
+		return nil, fmt.Errorf("is not a valid expression: %s", expr)
+	}
+
+	cmp, ok := ret.ReturnValue.(*ast.InfixExpression)
+	if !ok || (cmp.Operator != "==" && cmp.Operator != "in") {
+		return nil, fmt.Errorf("must compare a field via == or in, not %s", expr)
+	}
+
+	g := &guard{}
+	switch left := cmp.Left.(type) {
+	case *ast.Identifier:
+		if memberName(left.Value) {
+			g.path = left.Value
+		}
+	case *ast.InfixExpression:
+		g.path = (&Eval{}).memberPath(left)
+	}
+	if g.path == "" {
+		return nil, fmt.Errorf("must compare a field, not %s", cmp.Left)
+	}
+
+	values := []ast.Expression{cmp.Right}
+	if cmp.Operator == "in" {
+		array, ok := cmp.Right.(*ast.ArrayLiteral)
+		if !ok || len(array.Elements) == 0 {
+			return nil, fmt.Errorf("must compare a field with an array of constants, not %s", cmp.Right)
+		}
+		values = array.Elements
+	}
+
+	for _, val := range values {
+		key, ok := literalKey(val)
+		if !ok {
+			return nil, fmt.Errorf("must compare a field with constants, not %s", val)
+		}
+		g.values = append(g.values, key)
+	}
+	return g, nil
+}
+
+// literalKey returns the key of the given constant.
+func literalKey(node ast.Expression) (string, bool) {
+
+	switch n := node.(type) {
+	case *ast.StringLiteral:
+		return guardKey(&object.String{Value: n.Value})
+	case *ast.IntegerLiteral:
+		return guardKey(&object.Integer{Value: n.Value})
+	case *ast.FloatLiteral:
+		return guardKey(&object.Float{Value: n.Value})
+	case *ast.BooleanLiteral:
+		return guardKey(&object.Boolean{Value: n.Value})
+	case *ast.PrefixExpression:
+		if n.Operator != "-" {
+			return "", false
+		}
+		switch num := n.Right.(type) {
+		case *ast.IntegerLiteral:
+			return guardKey(&object.Integer{Value: -num.Value})
+		case *ast.FloatLiteral:
+			return guardKey(&object.Float{Value: -num.Value})
+		}
+	}
+	return "", false
+}
+
+// guardKey returns the key by which the given value is indexed, so that
+// values which are equal have the same key.
+//
+// Integers and floats are equal if they have the same value.
+func guardKey(obj object.Object) (string, bool) {
+
+	switch o := obj.(type) {
+	case *object.String:
+		return "s" + o.Value, true
+	case *object.Integer:
+		return "n" + strconv.FormatFloat(float64(o.Value), 'g', -1, 64), true
+	case *object.Float:
+		return "n" + strconv.FormatFloat(o.Value, 'g', -1, 64), true
+	case *object.Boolean:
+		return "b" + strconv.FormatBool(o.Value), true
+	}
+	return "", false
+}
+
+// guard returns the guard of the script, or nil if it has none.
+func (e *Eval) guard() *guard {
+
+	for _, p := range e.pragmas {
+		if p.guard != nil {
+			return p.guard
+		}
+	}
+	return nil
+}
+
+// indexGuard adds the given rule, at the given index, to the index of
+// the field its guard tests, if it has a guard.
+//
+// The caller must hold the lock of the set.
+func (r *RuleSet) indexGuard(rule *Rule, i int) error {
+
+	g := rule.Eval.guard()
+	if g == nil {
+		return nil
+	}
+
+	root := strings.SplitN(g.path, ".", 2)[0]
+	if _, ok := rule.Eval.environment.Get(root); ok {
+		return fmt.Errorf("the guard of rule %s refers to the variable %s", rule.Name, root)
+	}
+
+	// The way fields are presented changes their value.
+	key := fmt.Sprintf("%t %d %s %s", rule.Eval.stringerFields, rule.Eval.mode, rule.Eval.fieldTag, g.path)
+
+	idx, ok := r.guards[key]
+	if !ok {
+		eval := rule.Eval.Clone()
+		eval.Script = "return " + g.path + ";"
+		err := eval.Prepare()
+		if err != nil {
+			return fmt.Errorf("the guard of rule %s: %s", rule.Name, err)
+		}
+		idx = &guardIndex{eval: eval, rules: make(map[string][]int)}
+		r.guards[key] = idx
+	}
+
+	for _, val := range g.values {
+		idx.rules[val] = append(idx.rules[val], i)
+	}
+	rule.guarded = true
+	return nil
+}
+
+// ownGuard finds whether the guard of the rule, at the given index, is
+// true of the object if the field it tests has become a variable of the
+// rule since it was added - in which case the index of the guard can't
+// be used, as it looks up the field rather than the variable.
+func (rule *Rule) ownGuard(i int, allowed map[int]bool, obj interface{}) {
+
+	g := rule.Eval.guard()
+	root := strings.SplitN(g.path, ".", 2)[0]
+	if !rule.Eval.variables([]string{root}) {
+		return
+	}
+
+	allowed[i] = false
+	eval := rule.Eval.Clone()
+	eval.Script = "return " + g.path + ";"
+	if eval.Prepare() != nil {
+		return
+	}
+	val, err := eval.Execute(obj)
+	if err != nil {
+		return
+	}
+	key, ok := guardKey(val)
+	if !ok {
+		return
+	}
+	for _, v := range g.values {
+		if v == key {
+			allowed[i] = true
+		}
+	}
+}
+
+// guarded returns the indexes of the guarded rules which the object may
+// match, as their guards are true.
+func guarded(guards []*guardIndex, obj interface{}) map[int]bool {
+
+	out := make(map[int]bool)
+	for _, idx := range guards {
+
+		// A field which can't be found satisfies no guard.
+		idx.eval.mutex.Lock()
+		val, err := idx.eval.Execute(obj)
+		idx.eval.mutex.Unlock()
+		if err != nil {
+			continue
+		}
+
+		key, ok := guardKey(val)
+		if !ok {
+			continue
+		}
+		for _, i := range idx.rules[key] {
+			out[i] = true
+		}
+	}
+	return out
+}
@@ -0,0 +1,353 @@
+// Package httpfilter provides net/http middleware which allows, blocks,
+// or annotates requests according to the result of a script.
+//
+// The script sees the request as an object with these fields:
+//
+//	method    The request method, e.g. "GET".
+//	path      The path of the request URL.
+//	url       The complete request URL.
+//	host      The host the request was made to.
+//	proto     The protocol, e.g. "HTTP/1.1".
+//	ip        The IP address of the client.
+//	headers   A hash of the request headers, keyed by their lower-case
+//	          names, with multiple values joined by ", ".
+//	query     A hash of the query parameters, holding the first value
+//	          of each.
+//
+// The result of the script is the verdict upon the request:
+//
+//   - If the script returns false the request is blocked.
+//   - If the script returns a hash it may contain an `allow` value,
+//     default true, and a `status` to respond with if the request is
+//     blocked.  The other entries are annotations, which may be
+//     retrieved by the handlers which follow via Annotations.
+//   - Otherwise the request is allowed.
+//
+// For example:
+//
+//	if ( path ~= /[.][.]/ ) { return false; }
+//	if ( headers["user-agent"] ~= /sqlmap/i ) {
+//	    return { "allow": false, "status": 429 };
+//	}
+//	return { "client": ip };
+//
+// Different scripts may be used for different routes.  Each request is
+// run by a session of its own, so requests are filtered concurrently,
+// and the variables one sets are never seen by another.
+package httpfilter
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/skx/evalfilter/v2"
+	"github.com/skx/evalfilter/v2/object"
+)
+
+// route holds a script, and the requests it applies to.
+type route struct {
+
+	// method holds the request method the route is restricted to, if
+	// any.
+	method string
+
+	// prefix holds the path prefix the route applies to.
+	prefix string
+
+	// program holds the compiled script, from which a session is
+	// created for each request.
+	program *evalfilter.Program
+
+	// err holds the error creating the program, such as the script
+	// not having been prepared, with which each request fails.
+	err error
+}
+
+// Filter is the middleware, which holds the scripts to run for each
+// route.
+type Filter struct {
+
+	// TrustProxy causes the client IP to be taken from the
+	// X-Forwarded-For header, if present.  Only enable this if the
+	// server is behind a proxy which appends to that header.
+	//
+	// The client may send the header too, so its left-most entries
+	// can't be trusted.  The entry used is the one appended by the
+	// outermost of our proxies, which is the right-most unless
+	// ProxyHops says otherwise.
+	TrustProxy bool
+
+	// ProxyHops holds the number of proxies in front of the server
+	// which append to the X-Forwarded-For header, when TrustProxy is
+	// enabled.  Zero is treated as one.
+	ProxyHops int
+
+	// Blocked, if set, handles the requests which are blocked.  The
+	// status the script specified may be retrieved via Status.
+	//
+	// By default a plain-text error is returned.
+	Blocked http.Handler
+
+	// OnError, if set, handles the requests for which the script
+	// failed.  By default an internal server error is returned,
+	// rather than allowing the request.
+	OnError func(w http.ResponseWriter, r *http.Request, err error)
+
+	// routes holds our routes, most-specific first.
+	routes []*route
+
+	// mutex protects our routes.
+	mutex sync.RWMutex
+}
+
+// contextKey is the type of the keys we store within request contexts.
+type contextKey int
+
+const (
+	// annotationsKey is the key for the annotations of a request.
+	annotationsKey contextKey = iota
+
+	// statusKey is the key for the status of a blocked request.
+	statusKey
+)
+
+// New creates a filter which runs the given script against every request
+// which doesn't match a more specific route.
+//
+// The script must already have been prepared, it may be nil if you'd
+// prefer to allow such requests.  A program is created from the script,
+// so changes made to it afterwards don't affect the filter.
+func New(eval *evalfilter.Eval) *Filter {
+	f := &Filter{}
+	if eval != nil {
+		f.Route("/", eval)
+	}
+	return f
+}
+
+// Route uses the given script for the requests matching the pattern.
+//
+// A pattern is a path prefix, such as "/api/", which may be preceded by
+// a method and a space, as in "POST /login".  If several patterns match a
+// request the longest is used, with those specifying a method taking
+// precedence.  Adding a pattern which already exists replaces its script.
+//
+// The script must already have been prepared, otherwise the requests
+// matching the pattern fail.
+func (f *Filter) Route(pattern string, eval *evalfilter.Eval) {
+
+	r := &route{prefix: pattern}
+	r.program, r.err = eval.Program()
+	if i := strings.Index(pattern, " "); i > 0 {
+		r.method = pattern[:i]
+		r.prefix = strings.TrimSpace(pattern[i+1:])
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for i, existing := range f.routes {
+		if existing.method == r.method && existing.prefix == r.prefix {
+			f.routes[i] = r
+			return
+		}
+	}
+
+	f.routes = append(f.routes, r)
+	sort.SliceStable(f.routes, func(i, j int) bool {
+		if len(f.routes[i].prefix) != len(f.routes[j].prefix) {
+			return len(f.routes[i].prefix) > len(f.routes[j].prefix)
+		}
+		return f.routes[i].method > f.routes[j].method
+	})
+}
+
+// route returns the route for the given request, or nil if there are
+// none.
+func (f *Filter) route(r *http.Request) *route {
+
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+
+	for _, rt := range f.routes {
+		if rt.method != "" && rt.method != r.Method {
+			continue
+		}
+		if strings.HasPrefix(r.URL.Path, rt.prefix) {
+			return rt
+		}
+	}
+	return nil
+}
+
+// Handler returns a handler which filters the requests before passing
+// those which were allowed to the next handler.
+func (f *Filter) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		rt := f.route(r)
+		if rt == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if rt.err != nil {
+			f.fail(w, r, rt.err)
+			return
+		}
+
+		session := rt.program.NewSession()
+		out, err := session.Execute(f.Object(r))
+		if err != nil {
+			f.fail(w, r, err)
+			return
+		}
+
+		allow, status, notes, err := verdict(session, out)
+		if err != nil {
+			f.fail(w, r, err)
+			return
+		}
+
+		if !allow {
+			if f.Blocked != nil {
+				ctx := context.WithValue(r.Context(), statusKey, status)
+				f.Blocked.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+
+		if len(notes) > 0 {
+			r = r.WithContext(context.WithValue(r.Context(), annotationsKey, notes))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// fail handles a request for which the script failed.
+func (f *Filter) fail(w http.ResponseWriter, r *http.Request, err error) {
+	if f.OnError != nil {
+		f.OnError(w, r, err)
+		return
+	}
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+}
+
+// Object returns the object the script is run against for the given
+// request.
+func (f *Filter) Object(r *http.Request) map[string]interface{} {
+
+	headers := make(map[string]interface{}, len(r.Header))
+	for name, values := range r.Header {
+		headers[strings.ToLower(name)] = strings.Join(values, ", ")
+	}
+
+	query := make(map[string]interface{})
+	for name, values := range r.URL.Query() {
+		if len(values) > 0 {
+			query[name] = values[0]
+		}
+	}
+
+	return map[string]interface{}{
+		"method":  r.Method,
+		"path":    r.URL.Path,
+		"url":     r.URL.String(),
+		"host":    r.Host,
+		"proto":   r.Proto,
+		"ip":      f.clientIP(r),
+		"headers": headers,
+		"query":   query,
+	}
+}
+
+// clientIP returns the address of the client.
+func (f *Filter) clientIP(r *http.Request) string {
+
+	if f.TrustProxy {
+		var hops []string
+		for _, fwd := range r.Header["X-Forwarded-For"] {
+			hops = append(hops, strings.Split(fwd, ",")...)
+		}
+		if len(hops) > 0 {
+			n := f.ProxyHops
+			if n < 1 {
+				n = 1
+			}
+			if n > len(hops) {
+				n = len(hops)
+			}
+			return strings.TrimSpace(hops[len(hops)-n])
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// verdict interprets the result of the script run by the given session.
+func verdict(session *evalfilter.Session, out object.Object) (bool, int, map[string]string, error) {
+
+	hash, ok := out.(*object.Hash)
+	if !ok {
+		return session.True(out), http.StatusForbidden, nil, nil
+	}
+
+	allow := true
+	status := http.StatusForbidden
+	notes := make(map[string]string)
+
+	for _, pair := range hash.Pairs {
+
+		key := pair.Key.Inspect()
+		if str, ok := pair.Key.(*object.String); ok {
+			key = str.Value
+		}
+
+		switch key {
+		case "allow":
+			allow = session.True(pair.Value)
+		case "status":
+			i, ok := pair.Value.(*object.Integer)
+			if !ok || i.Value < 100 || i.Value > 999 {
+				return false, 0, nil, fmt.Errorf("the status must be an integer HTTP status, not %s", pair.Value.Inspect())
+			}
+			status = int(i.Value)
+		default:
+			val := pair.Value.Inspect()
+			if str, ok := pair.Value.(*object.String); ok {
+				val = str.Value
+			}
+			notes[key] = val
+		}
+	}
+
+	return allow, status, notes, nil
+}
+
+// Annotations returns the annotations the script made upon the request,
+// for use by the handlers which follow the filter.
+func Annotations(r *http.Request) map[string]string {
+	notes, _ := r.Context().Value(annotationsKey).(map[string]string)
+	return notes
+}
+
+// Status returns the status the script specified for a blocked request,
+// for use by the Blocked handler.
+func Status(r *http.Request) int {
+	status, ok := r.Context().Value(statusKey).(int)
+	if !ok {
+		return http.StatusForbidden
+	}
+	return status
+}
@@ -0,0 +1,208 @@
+package httpfilter
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/skx/evalfilter/v2"
+)
+
+// compile returns a prepared script.
+func compile(t *testing.T, script string) *evalfilter.Eval {
+	eval := evalfilter.New(script)
+	err := eval.Prepare()
+	if err != nil {
+		t.Fatalf("failed to compile %s: %s", script, err)
+	}
+	return eval
+}
+
+// echo is a handler which shows the annotations it received.
+var echo = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	notes := Annotations(r)
+	keys := make([]string, 0, len(notes))
+	for k, v := range notes {
+		keys = append(keys, k+"="+v)
+	}
+	fmt.Fprintf(w, "OK %s", strings.Join(keys, ","))
+})
+
+// TestFilter tests allowing, blocking, and annotating requests.
+func TestFilter(t *testing.T) {
+
+	f := New(compile(t, `
+if ( path ~= /[.][.]/ ) { return false; }
+if ( headers["user-agent"] ~= /sqlmap/i ) { return { "allow": false, "status": 429 }; }
+if ( "debug" in keys(query) ) { return { "client": ip }; }
+return true;
+`))
+	f.Route("/admin/", compile(t, `return ip == "10.0.0.1";`))
+	f.Route("POST /admin/login", compile(t, `return method == "POST" && host == "example.com";`))
+
+	tests := []struct {
+		method string
+		target string
+		agent  string
+		code   int
+		body   string
+	}{
+		{"GET", "/index.html", "curl", 200, "OK "},
+		{"GET", "/a/../etc/passwd", "curl", 403, "Forbidden\n"},
+		{"GET", "/", "SQLMap/1.0", 429, "Too Many Requests\n"},
+		{"GET", "/?debug=1", "curl", 200, "OK client=192.0.2.1"},
+		{"GET", "/admin/users", "curl", 403, "Forbidden\n"},
+		{"POST", "/admin/login", "curl", 200, "OK "},
+		{"GET", "/admin/login", "curl", 403, "Forbidden\n"},
+	}
+
+	handler := f.Handler(echo)
+	for _, test := range tests {
+
+		req := httptest.NewRequest(test.method, "http://example.com"+test.target, nil)
+		req.Header.Set("User-Agent", test.agent)
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != test.code || rec.Body.String() != test.body {
+			t.Fatalf("wrong response for %s %s, got %d %q expected %d %q", test.method, test.target, rec.Code, rec.Body.String(), test.code, test.body)
+		}
+	}
+}
+
+// TestClientIP tests finding the address of the client.
+func TestClientIP(t *testing.T) {
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Forwarded-For", "203.0.113.9, 10.0.0.1")
+
+	f := New(nil)
+	if f.Object(req)["ip"] != "192.0.2.1" {
+		t.Fatalf("wrong address: %v", f.Object(req)["ip"])
+	}
+
+	// The client may have sent the header itself, so the entry our
+	// proxy appended is used.
+	f.TrustProxy = true
+	if f.Object(req)["ip"] != "10.0.0.1" {
+		t.Fatalf("wrong address: %v", f.Object(req)["ip"])
+	}
+
+	// Behind two proxies the first appended the client.
+	f.ProxyHops = 2
+	if f.Object(req)["ip"] != "203.0.113.9" {
+		t.Fatalf("wrong address: %v", f.Object(req)["ip"])
+	}
+
+	// The entries of repeated headers are combined.
+	req.Header.Add("X-Forwarded-For", "198.51.100.7")
+	if f.Object(req)["ip"] != "10.0.0.1" {
+		t.Fatalf("wrong address: %v", f.Object(req)["ip"])
+	}
+
+	// With fewer entries than proxies the left-most is used.
+	f.ProxyHops = 5
+	if f.Object(req)["ip"] != "203.0.113.9" {
+		t.Fatalf("wrong address: %v", f.Object(req)["ip"])
+	}
+
+	// Without any routes every request is allowed.
+	rec := httptest.NewRecorder()
+	f.Handler(echo).ServeHTTP(rec, req)
+	if rec.Code != 200 {
+		t.Fatalf("expected the request to be allowed, got %d", rec.Code)
+	}
+}
+
+// TestConcurrent tests that requests are filtered concurrently, without
+// sharing the variables their scripts set.
+func TestConcurrent(t *testing.T) {
+
+	// Each request begins with its own copy of the variable.
+	eval := compile(t, `count++; return count == 1;`)
+	eval.SetVariableInt("count", 0)
+
+	f := New(eval)
+	f.Route("/admin/", compile(t, `return ip == "10.0.0.1";`))
+
+	handler := f.Handler(echo)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				target := fmt.Sprintf("/?id=%d-%d", i, j)
+				code := 200
+				if j%2 == 1 {
+					target = "/admin/users"
+					code = 403
+				}
+
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, httptest.NewRequest("GET", target, nil))
+				if rec.Code != code {
+					t.Errorf("wrong status for %s, got %d expected %d", target, rec.Code, code)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+// TestErrors tests the handling of failing scripts, and the custom
+// handlers.
+func TestErrors(t *testing.T) {
+
+	tests := []struct {
+		script string
+		code   int
+	}{
+		{`return 1 / len(query);`, 500},
+		{`return { "status": "bad" };`, 500},
+		{`return { "allow": false, "status": 451 };`, 451},
+	}
+
+	// Scripts which weren't prepared fail every request.
+	f := New(evalfilter.New(`return true;`))
+	rec := httptest.NewRecorder()
+	f.Handler(echo).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != 500 {
+		t.Fatalf("wrong status for an unprepared script, got %d", rec.Code)
+	}
+
+	for _, test := range tests {
+
+		f := New(compile(t, test.script))
+		rec := httptest.NewRecorder()
+		f.Handler(echo).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		if rec.Code != test.code {
+			t.Fatalf("wrong status for %s, got %d expected %d", test.script, rec.Code, test.code)
+		}
+
+		// Now with custom handlers.
+		f.OnError = func(w http.ResponseWriter, r *http.Request, err error) {
+			w.WriteHeader(502)
+		}
+		f.Blocked = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(Status(r) + 1)
+		})
+
+		exp := 502
+		if test.code != 500 {
+			exp = test.code + 1
+		}
+
+		rec = httptest.NewRecorder()
+		f.Handler(echo).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		if rec.Code != exp {
+			t.Fatalf("wrong status for %s, got %d expected %d", test.script, rec.Code, exp)
+		}
+	}
+}
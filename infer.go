@@ -0,0 +1,293 @@
+// This file contains the inference of a schema from sample events, and
+// the discovery of the fields a script looks up.
+//
+// Writing a schema by hand, for BindSchema, means knowing the name and
+// type of every field an event might contain.  Given a few sample events
+// InferSchema finds them instead, along with examples of their values
+// which may be offered by an editor completing the names of fields.
+//
+// The fields a script looks up, which are found by Fields, may then be
+// compared with those of the samples via Missing - so a rule testing a
+// field which the events never contain, such as a misspelling of one,
+// may be found before it is deployed.
+
+package evalfilter
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/skx/evalfilter/v2/ast"
+	"github.com/skx/evalfilter/v2/object"
+)
+
+// schemaExamples holds the number of distinct examples which are kept
+// of the values of each field.
+const schemaExamples = 3
+
+// SchemaField describes a field found within sample events.
+type SchemaField struct {
+
+	// Name holds the name of the field.  The members of fields which
+	// are hashes are named by their path, such as "request.host".
+	Name string
+
+	// Type holds the type of the field.  A field which held both
+	// integers and floats is a float, whilst one which held values
+	// of other differing types, or only null, is object.NULL.
+	Type object.Type
+
+	// Types holds every type the field held, other than null, in
+	// sorted order.
+	Types []object.Type
+
+	// Count holds the number of samples which contained the field.
+	Count int
+
+	// Examples holds up to three distinct values of the field, as
+	// JSON, in the order they were found.  Hashes have none, as their
+	// members are fields of their own.
+	Examples []string
+}
+
+// Schema describes the fields found within sample events.
+type Schema struct {
+
+	// Samples holds the number of samples read.
+	Samples int
+
+	// Fields holds the fields found within the samples, sorted by
+	// name, so that the members of a hash follow it.
+	Fields []SchemaField
+}
+
+// InferSchema reads a stream of JSON objects, such as newline-delimited
+// events, and returns a description of the fields they contain.
+//
+// Values are presented as RunJSON presents them, so numbers without a
+// fraction are integers, and nested objects are hashes whose members
+// are described too.  An error is returned if a sample isn't a JSON
+// object.
+func InferSchema(r io.Reader) (*Schema, error) {
+
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+
+	fields := make(map[string]*SchemaField)
+	samples := 0
+	for {
+		var doc interface{}
+		err := dec.Decode(&doc)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse sample %d: %s", samples+1, err)
+		}
+		hash, ok := doc.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("sample %d is not a JSON object", samples+1)
+		}
+		samples++
+		inferFields(fields, "", hash)
+	}
+
+	schema := &Schema{Samples: samples}
+	for _, field := range fields {
+		sort.Slice(field.Types, func(i, j int) bool { return field.Types[i] < field.Types[j] })
+		field.Type = inferredType(field.Types)
+		schema.Fields = append(schema.Fields, *field)
+	}
+	sort.Slice(schema.Fields, func(i, j int) bool { return schema.Fields[i].Name < schema.Fields[j].Name })
+	return schema, nil
+}
+
+// inferFields records the members of the given object, whose names begin
+// with the given prefix.
+func inferFields(fields map[string]*SchemaField, prefix string, hash map[string]interface{}) {
+
+	for name, val := range hash {
+
+		path := prefix + name
+		field, ok := fields[path]
+		if !ok {
+			field = &SchemaField{Name: path}
+			fields[path] = field
+		}
+		field.Count++
+
+		t := jsonType(val)
+		if t == object.NULL {
+			continue
+		}
+		found := false
+		for _, seen := range field.Types {
+			found = found || seen == t
+		}
+		if !found {
+			field.Types = append(field.Types, t)
+		}
+
+		if members, ok := val.(map[string]interface{}); ok {
+			inferFields(fields, path+".", members)
+			continue
+		}
+		if len(field.Examples) < schemaExamples {
+			example, err := json.Marshal(val)
+			if err != nil {
+				continue
+			}
+			found = false
+			for _, seen := range field.Examples {
+				found = found || seen == string(example)
+			}
+			if !found {
+				field.Examples = append(field.Examples, string(example))
+			}
+		}
+	}
+}
+
+// jsonType returns the type of object the given decoded JSON value is
+// presented as.
+func jsonType(val interface{}) object.Type {
+
+	switch v := val.(type) {
+	case bool:
+		return object.BOOLEAN
+	case string:
+		return object.STRING
+	case []interface{}:
+		return object.ARRAY
+	case map[string]interface{}:
+		return object.HASH
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return object.INTEGER
+		}
+		return object.FLOAT
+	}
+	return object.NULL
+}
+
+// inferredType returns the type of a field which held values of the
+// given types.
+func inferredType(types []object.Type) object.Type {
+
+	switch {
+	case len(types) == 1:
+		return types[0]
+	case len(types) == 2 && types[0] == object.FLOAT && types[1] == object.INTEGER:
+		return object.FLOAT
+	}
+	return object.NULL
+}
+
+// Types returns the types of the fields of the samples, which aren't
+// members of others, such that they may be given to BindSchema.
+func (s *Schema) Types() map[string]object.Type {
+
+	types := make(map[string]object.Type)
+	for _, field := range s.Fields {
+		if !strings.Contains(field.Name, ".") {
+			types[field.Name] = field.Type
+		}
+	}
+	return types
+}
+
+// Missing returns those of the given fields, such as the ones returned
+// by Fields, which the samples don't contain.
+//
+// A field is only missing if it, or the hash it is a member of, was
+// never found.  The members of fields which held anything other than
+// hashes can't be known, so aren't missing.
+func (s *Schema) Missing(fields []string) []string {
+
+	types := make(map[string]object.Type, len(s.Fields))
+	for _, field := range s.Fields {
+		types[field.Name] = field.Type
+	}
+
+	var missing []string
+	for _, name := range fields {
+		parts := strings.Split(name, ".")
+		for i := range parts {
+			t, ok := types[strings.Join(parts[:i+1], ".")]
+			if !ok {
+				missing = append(missing, name)
+				break
+			}
+			if t != object.HASH {
+				break
+			}
+		}
+	}
+	return missing
+}
+
+// Fields returns the fields the script looks up, such as "Count", or
+// "Request.Host", in sorted order.
+//
+// These are the names the script looks up which it never changes, and
+// which aren't variables or functions - though if the script contains
+// something which can't be analysed none are excluded for being changed.
+// This is only available once the script has been prepared, and not if
+// it was loaded from bytecode.
+func (e *Eval) Fields() []string {
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return e.fields
+}
+
+// reference records the name, or path, which the script looks up.
+func (e *Eval) reference(path string) {
+	if e.referenced != nil {
+		e.referenced[path] = true
+	}
+}
+
+// findFields returns the names, and paths, the given program looked up
+// whilst it was compiled which may be fields.
+func (e *Eval) findFields(program *ast.Program) []string {
+
+	counts := make(map[string]int)
+	if !writes(program, counts) {
+		counts = nil
+	}
+	defined := definedFunctions(program)
+
+	var fields []string
+	for path := range e.referenced {
+		parts := strings.SplitN(path, ".", 2)
+		root := variable(parts[0])
+		if counts[root] > 0 || defined[root] {
+			continue
+		}
+		if _, ok := e.environment.Get(root); ok {
+			continue
+		}
+		if _, ok := e.environment.GetFunction(root); ok {
+			continue
+		}
+		if len(parts) > 1 {
+			root += "." + parts[1]
+		}
+		fields = append(fields, root)
+	}
+	sort.Strings(fields)
+
+	// Remove any duplicates, such as `$Count` and `Count`.
+	var out []string
+	for i, name := range fields {
+		if i == 0 || name != fields[i-1] {
+			out = append(out, name)
+		}
+	}
+	return out
+}
@@ -0,0 +1,237 @@
+// This file contains the code which allows small user-defined functions
+// to be inlined at their call-sites, when the optimizer is enabled.
+//
+// A function is a candidate for inlining if its body consists of nothing
+// more than a single return-statement, and that returned expression is
+// free of side-effects.  For example:
+//
+//    function is_admin( u ) { return u.Role == "admin"; }
+//
+// Calls such as `is_admin(User)` are then compiled as if the user had
+// written `User.Role == "admin"` instead, which avoids the overhead of
+// the function-call entirely.  Because the arguments are substituted
+// directly into the body the usual constant-folding of the optimizer
+// applies to the result too.
+
+package evalfilter
+
+import (
+	"github.com/skx/evalfilter/v2/ast"
+)
+
+// findInlinable examines the top-level function definitions in the
+// given program, and records those which may be inlined.
+func (e *Eval) findInlinable(program *ast.Program) {
+
+	e.inlinable = make(map[string]*ast.FunctionDefinition)
+
+	//
+	// Find the candidates: functions which consist of a single
+	// return-statement.
+	//
+	// A function which has the same name as a host-function is
+	// never called, because the host-function wins, so we must
+	// leave those alone.
+	//
+	candidates := make(map[string]*ast.FunctionDefinition)
+	for _, s := range program.Statements {
+		stmt, ok := s.(*ast.ExpressionStatement)
+		if !ok {
+			continue
+		}
+		fn, ok := stmt.Expression.(*ast.FunctionDefinition)
+		if !ok {
+			continue
+		}
+
+		// A later definition replaces an earlier one.
+		delete(candidates, fn.Token.Literal)
+
+		if inlineBody(fn) == nil {
+			continue
+		}
+		if _, ok := e.environment.GetFunction(fn.Token.Literal); ok {
+			continue
+		}
+		candidates[fn.Token.Literal] = fn
+	}
+
+	//
+	// A function may only be inlined if its body is pure, which
+	// includes only calling other functions which may be inlined.
+	//
+	// So we keep going until we stop finding new functions, which
+	// also ensures that recursive functions are never inlined.
+	//
+	for changed := true; changed; {
+		changed = false
+
+		for name, fn := range candidates {
+			if _, ok := e.inlinable[name]; ok {
+				continue
+			}
+			if e.pure(inlineBody(fn)) {
+				e.inlinable[name] = fn
+				changed = true
+			}
+		}
+	}
+}
+
+// inlineBody returns the expression returned by the given function, if
+// the body of the function consists of only a single return-statement.
+func inlineBody(fn *ast.FunctionDefinition) ast.Expression {
+	if fn.Body == nil || len(fn.Body.Statements) != 1 {
+		return nil
+	}
+	ret, ok := fn.Body.Statements[0].(*ast.ReturnStatement)
+	if !ok {
+		return nil
+	}
+	return ret.ReturnValue
+}
+
+// pure returns true if evaluating the given expression has no
+// side-effects, which means it may be evaluated more than once,
+// or not at all, without changing the behaviour of the script.
+func (e *Eval) pure(node ast.Expression) bool {
+
+	switch node := node.(type) {
+
+	case *ast.BooleanLiteral, *ast.FloatLiteral, *ast.IntegerLiteral,
+		*ast.StringLiteral, *ast.RegexpLiteral, *ast.Identifier:
+		return true
+
+	case *ast.ArrayLiteral:
+		for _, el := range node.Elements {
+			if !e.pure(el) {
+				return false
+			}
+		}
+		return true
+
+	case *ast.HashLiteral:
+		for k, v := range node.Pairs {
+			if !e.pure(k) || !e.pure(v) {
+				return false
+			}
+		}
+		return true
+
+	case *ast.PrefixExpression:
+		return e.pure(node.Right)
+
+	case *ast.InfixExpression:
+		if _, ok := mutators[node.Operator]; ok {
+			return false
+		}
+		return e.pure(node.Left) && e.pure(node.Right)
+
+	case *ast.TernaryExpression:
+		return e.pure(node.Condition) && e.pure(node.IfTrue) && e.pure(node.IfFalse)
+
+	case *ast.IndexExpression:
+		return e.pure(node.Left) && e.pure(node.Index)
+
+	case *ast.SliceExpression:
+		return e.pure(node.Left) &&
+			(node.Start == nil || e.pure(node.Start)) &&
+			(node.End == nil || e.pure(node.End))
+
+	case *ast.CallExpression:
+
+		// Host functions cannot change the variables of the
+		// script, so calling them is fine.
+		if _, ok := e.environment.GetFunction(node.Function.String()); ok {
+			for _, a := range node.Arguments {
+				if !e.pure(a) {
+					return false
+				}
+			}
+			return true
+		}
+
+		// Otherwise we can only call functions we'll inline.
+		_, ok := e.canInline(node)
+		return ok
+	}
+
+	return false
+}
+
+// canInline returns the definition of the function invoked by the given
+// call, if that call may be compiled inline.
+//
+// As an argument might be evaluated several times once substituted into
+// the body of the function we only inline calls where each argument is
+// a literal, or a variable.
+func (e *Eval) canInline(node *ast.CallExpression) (*ast.FunctionDefinition, bool) {
+
+	fn, ok := e.inlinable[node.Function.String()]
+	if !ok {
+		return nil, false
+	}
+
+	// A mismatch in argument-counts is reported at run-time.
+	if len(fn.Parameters) != len(node.Arguments) {
+		return nil, false
+	}
+
+	for _, a := range node.Arguments {
+		switch a.(type) {
+		case *ast.BooleanLiteral, *ast.FloatLiteral, *ast.IntegerLiteral,
+			*ast.StringLiteral, *ast.RegexpLiteral, *ast.Identifier:
+		default:
+			return nil, false
+		}
+	}
+	return fn, true
+}
+
+// compileInline compiles the body of the given function in place of
+// the call to it.
+//
+// Each reference to a parameter within the body is replaced by the
+// corresponding argument.  Variables are dynamically scoped, so the
+// body of a function sees the parameters of the function which called
+// it, which is why any substitutions already in effect are retained.
+func (e *Eval) compileInline(node *ast.CallExpression, fn *ast.FunctionDefinition) error {
+
+	outer := e.substitutions
+
+	subs := make(map[string]ast.Expression)
+	for name, exp := range outer {
+		subs[name] = exp
+	}
+
+	for i, param := range fn.Parameters {
+		arg := node.Arguments[i]
+
+		// The argument might itself be a parameter of the
+		// function we're inlining into.
+		if ident, ok := arg.(*ast.Identifier); ok {
+			if exp, ok := outer[ident.Value]; ok {
+				arg = exp
+			}
+		}
+		subs[param.Value] = arg
+	}
+
+	e.substitutions = subs
+	err := e.compile(inlineBody(fn))
+	e.substitutions = outer
+
+	return err
+}
+
+// compileSubstitution compiles the argument which has been substituted
+// for a parameter, in the scope of the caller.
+func (e *Eval) compileSubstitution(node ast.Expression) error {
+
+	subs := e.substitutions
+	e.substitutions = nil
+	err := e.compile(node)
+	e.substitutions = subs
+
+	return err
+}
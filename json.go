@@ -0,0 +1,38 @@
+// This file contains the code which allows scripts to be run against
+// JSON documents, without first decoding them.
+
+package evalfilter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// RunJSON runs the script against the given JSON document, which must be
+// an object, and returns the result.
+//
+// The document is seen as a hash, so that its fields may be accessed by
+// name just as the fields of an object passed to Run are, and nested
+// objects may be accessed as `request.headers.host`.  Only the members of
+// the document the script reads are decoded, so a script which tests two
+// fields of a large document doesn't pay for decoding the rest.  Numbers are
+// decoded as json.Number, so that large integers aren't rounded.
+//
+// As with Run you must invoke Prepare before RunJSON.  If the document
+// cannot be parsed an error is returned.
+func (e *Eval) RunJSON(data []byte) (bool, error) {
+
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return false, fmt.Errorf("the JSON document is not an object")
+	}
+
+	var doc map[string]json.RawMessage
+	err := json.Unmarshal(trimmed, &doc)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse the JSON document: %s", err)
+	}
+
+	return e.Run(doc)
+}
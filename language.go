@@ -0,0 +1,73 @@
+// This file contains the selection of the version of the language which
+// a script is compiled for.
+//
+// Rules may outlive the semantics of the language they were written in,
+// so each version of the language which changes them has a number, and
+// scripts are run by the first version unless they declare another:
+//
+//	// pragma lang 2
+//	return Count && !Flags;
+//
+// Hosts may change the version of the scripts which don't declare one,
+// via SetLanguage or the Language option of PrepareWithOptions, once
+// their rules have been updated.  The versions are described by the vm
+// package.
+
+package evalfilter
+
+import (
+	"fmt"
+
+	"github.com/skx/evalfilter/v2/object"
+	"github.com/skx/evalfilter/v2/vm"
+)
+
+// SetLanguage sets the version of the language the script is compiled
+// for, unless the script declares its own version via the lang pragma.
+//
+// The version must be between vm.Language1, the default, and
+// vm.LatestLanguage.  This should be done before Prepare is invoked.
+func (e *Eval) SetLanguage(version int) error {
+
+	if version < vm.Language1 || version > vm.LatestLanguage {
+		return fmt.Errorf("unknown language version %d", version)
+	}
+	e.language = version
+	return nil
+}
+
+// Language returns the version of the language the script was compiled
+// for, once it has been prepared.
+func (e *Eval) Language() int {
+
+	if e.machine != nil {
+		return e.machine.Language()
+	}
+	return e.languageOf()
+}
+
+// True returns whether the given object, such as the result of Execute,
+// is true according to the version of the language the script was
+// compiled for.
+func (e *Eval) True(obj object.Object) bool {
+
+	if e.machine != nil {
+		return e.machine.True(obj)
+	}
+	return obj.True()
+}
+
+// languageOf returns the version of the language the script is compiled
+// for, which is that given by its pragmas, or by the host.
+func (e *Eval) languageOf() int {
+
+	for _, p := range e.pragmas {
+		if p.language != 0 {
+			return p.language
+		}
+	}
+	if e.language != 0 {
+		return e.language
+	}
+	return vm.Language1
+}
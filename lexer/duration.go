@@ -0,0 +1,77 @@
+// This file contains the handling of duration literals, such as `1h`,
+// `90s`, or `1h30m`.
+//
+// Durations are represented by a number of seconds, as a float, which
+// allows scripts to write expressions such as `Created > now() - 1h` -
+// times may be compared with, and offset by, numbers of seconds.  The
+// `time.Duration` fields of objects, and the `duration` function, give
+// floats too, so that a duration has the same type however it is found.
+
+package lexer
+
+import (
+	"regexp"
+	"strconv"
+	"unicode"
+
+	"github.com/skx/evalfilter/v2/token"
+)
+
+// durationUnits holds the number of seconds in each unit we allow.
+var durationUnits = map[string]float64{
+	"ns": 1e-9,
+	"us": 1e-6,
+	"µs": 1e-6,
+	"ms": 1e-3,
+	"s":  1,
+	"m":  60,
+	"h":  60 * 60,
+	"d":  24 * 60 * 60,
+}
+
+// durationRegexp matches each component of a duration literal.
+var durationRegexp = regexp.MustCompile(`([0-9]+(?:\.[0-9]+)?)(ns|us|µs|ms|s|m|h|d)`)
+
+// readDuration reads the remainder of a duration literal, the number at
+// the start of which has already been read.
+//
+// The duration is returned as a float holding the number of seconds.
+func (l *Lexer) readDuration(number string) token.Token {
+
+	str := number
+	for isDigit(l.ch) || l.ch == rune('.') || unicode.IsLetter(l.ch) {
+		str += string(l.ch)
+		l.readChar()
+	}
+
+	seconds, ok := ParseDuration(str)
+	if !ok {
+		return token.Token{Type: token.ILLEGAL, Literal: "invalid duration '" + str + "'"}
+	}
+
+	return token.Token{Type: token.FLOAT, Literal: strconv.FormatFloat(seconds, 'f', -1, 64)}
+}
+
+// ParseDuration parses a duration, such as "1h30m", in the same way as
+// duration literals are parsed, and returns the number of seconds.
+//
+// False is returned if the string isn't a valid duration.
+func ParseDuration(str string) (float64, bool) {
+
+	// The components must cover the whole string.
+	matches := durationRegexp.FindAllStringSubmatchIndex(str, -1)
+	seconds := 0.0
+	offset := 0
+	for _, m := range matches {
+		if m[0] != offset {
+			break
+		}
+		n, _ := strconv.ParseFloat(str[m[2]:m[3]], 64)
+		seconds += n * durationUnits[str[m[4]:m[5]]]
+		offset = m[1]
+	}
+	if len(matches) == 0 || offset != len(str) {
+		return 0, false
+	}
+	return seconds, true
+}
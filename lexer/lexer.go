@@ -36,6 +36,18 @@ type Lexer struct {
 
 	// column contains the place within the line where we are.
 	column int
+
+	// skipped is true if we've skipped a newline, since we began
+	// to look for the current token.
+	skipped bool
+
+	// newline is true if a newline came before the last token we
+	// returned.
+	newline bool
+
+	// sql is true if keywords are recognized regardless of their
+	// case, and `and`, `or`, and `not` are aliases for operators.
+	sql bool
 }
 
 // New creates a Lexer instance from the given string
@@ -47,6 +59,16 @@ func New(input string) *Lexer {
 	return l
 }
 
+// SetSQLKeywords controls whether keywords are recognized regardless of
+// their case, and whether `and`, `or`, and `not` may be used in place of
+// the `&&`, `||`, and `!` operators - as they may in SQL-like filters.
+//
+// Identifiers which match a keyword, such as a field named `In`, can't be
+// used when this is enabled.
+func (l *Lexer) SetSQLKeywords(enable bool) {
+	l.sql = enable
+}
+
 // read forward one character.
 func (l *Lexer) readChar() {
 	if l.readPosition >= len(l.characters) {
@@ -69,13 +91,27 @@ func (l *Lexer) readChar() {
 // NextToken reads and returns the next token, skipping any intervening
 // white space, and swallowing any comments, in the process.
 func (l *Lexer) NextToken() token.Token {
+	l.skipped = false
+	tok := l.nextToken()
+	l.newline = l.skipped
+	return tok
+}
+
+// Newline returns true if the last token which was returned began upon
+// a later line than the token before it.
+func (l *Lexer) Newline() bool {
+	return l.newline
+}
+
+// nextToken reads and returns the next token.
+func (l *Lexer) nextToken() token.Token {
 	var tok token.Token
 	l.skipWhitespace()
 
 	// skip single-line comments
 	if l.ch == rune('/') && l.peekChar() == rune('/') {
 		l.skipComment()
-		return (l.NextToken())
+		return (l.nextToken())
 	}
 
 	switch l.ch {
@@ -98,6 +134,10 @@ func (l *Lexer) NextToken() token.Token {
 			ch := l.ch
 			l.readChar()
 			tok = token.Token{Type: token.EQ, Literal: string(ch) + string(l.ch), Line: l.line, Column: l.column}
+		} else if l.peekChar() == rune('>') {
+			ch := l.ch
+			l.readChar()
+			tok = token.Token{Type: token.ARROW, Literal: string(ch) + string(l.ch), Line: l.line, Column: l.column}
 		} else {
 			tok = l.newToken(token.ASSIGN, l.ch)
 		}
@@ -311,7 +351,11 @@ func (l *Lexer) NextToken() token.Token {
 
 		tok.Literal = l.readIdentifier()
 		if len(tok.Literal) > 0 {
-			tok.Type = token.LookupIdentifier(tok.Literal)
+			if l.sql {
+				tok.Type, tok.Literal = token.LookupSQLIdentifier(tok.Literal)
+			} else {
+				tok.Type = token.LookupIdentifier(tok.Literal)
+			}
 			l.prevToken = tok
 			tok.Column = l.column
 			tok.Line = l.line
@@ -354,6 +398,9 @@ func (l *Lexer) readIdentifier() string {
 // skip over any white space.
 func (l *Lexer) skipWhitespace() {
 	for isWhitespace(l.ch) {
+		if l.ch == rune('\n') {
+			l.skipped = true
+		}
 		l.readChar()
 	}
 }
@@ -397,9 +444,21 @@ func (l *Lexer) readDecimal() token.Token {
 
 		// Get the float-component.
 		fraction := l.readNumber()
+
+		// A unit makes this a duration.
+		if unicode.IsLetter(l.ch) {
+			return l.readDuration(integer + "." + fraction)
+		}
 		return token.Token{Type: token.FLOAT, Literal: integer + "." + fraction}
 	}
 
+	//
+	// A unit makes this a duration, such as `1h`.
+	//
+	if unicode.IsLetter(l.ch) {
+		return l.readDuration(integer)
+	}
+
 	//
 	// Just an integer.
 	//
@@ -735,6 +735,35 @@ b--;
 	}
 }
 
+// TestArrow tests the `=>` token used by match-expressions.
+func TestArrow(t *testing.T) {
+	input := `_ => a = >= b;`
+
+	tests := []struct {
+		expectedType    token.Type
+		expectedLiteral string
+	}{
+		{token.IDENT, "_"},
+		{token.ARROW, "=>"},
+		{token.IDENT, "a"},
+		{token.ASSIGN, "="},
+		{token.GTEQUALS, ">="},
+		{token.IDENT, "b"},
+		{token.SEMICOLON, ";"},
+		{token.EOF, ""},
+	}
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong, expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - Literal wrong, expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
 // TestIllegal is designed to catch illegal identifiers
 func TestIllegal(t *testing.T) {
 	input := `#`
@@ -775,3 +804,107 @@ func TestIdentifiers(t *testing.T) {
 		}
 	}
 }
+
+// TestDurations tests that duration literals are converted to seconds,
+// as floats.
+func TestDurations(t *testing.T) {
+	input := `1h 90s 1h30m 2d 500ms 1.5s 1µs 3x 1h5`
+
+	tests := []struct {
+		expectedType    token.Type
+		expectedLiteral string
+	}{
+		{token.FLOAT, "3600"},
+		{token.FLOAT, "90"},
+		{token.FLOAT, "5400"},
+		{token.FLOAT, "172800"},
+		{token.FLOAT, "0.5"},
+		{token.FLOAT, "1.5"},
+		{token.FLOAT, "0.000001"},
+		{token.ILLEGAL, "invalid duration '3x'"},
+		{token.ILLEGAL, "invalid duration '1h5'"},
+		{token.EOF, ""},
+	}
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong, expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - Literal wrong, expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+// TestNewline tests that we report the tokens which begin a line.
+func TestNewline(t *testing.T) {
+	input := "a = \"multi\nline\" // comment\nb\n\n  c d"
+
+	tests := []struct {
+		expectedLiteral string
+		expectedNewline bool
+	}{
+		{"a", false},
+		{"=", false},
+		{"multi\nline", false},
+		{"b", true},
+		{"c", true},
+		{"d", false},
+	}
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong, expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+		if l.Newline() != tt.expectedNewline {
+			t.Fatalf("tests[%d] - newline wrong for %q, expected=%t", i, tok.Literal, tt.expectedNewline)
+		}
+	}
+}
+
+// TestSQLKeywords tests that keywords may be written in any case, and
+// that the aliases for operators are found, when enabled.
+func TestSQLKeywords(t *testing.T) {
+	input := "IF ( Name == 1 AND NOT b Or c ) { RETURN True; }"
+
+	tests := []struct {
+		expectedType    token.Type
+		expectedLiteral string
+	}{
+		{token.IF, "if"},
+		{token.LPAREN, "("},
+		{token.IDENT, "Name"},
+		{token.EQ, "=="},
+		{token.INT, "1"},
+		{token.AND, "&&"},
+		{token.BANG, "!"},
+		{token.IDENT, "b"},
+		{token.OR, "||"},
+		{token.IDENT, "c"},
+		{token.RPAREN, ")"},
+		{token.LBRACE, "{"},
+		{token.RETURN, "return"},
+		{token.TRUE, "true"},
+		{token.SEMICOLON, ";"},
+		{token.RBRACE, "}"},
+		{token.EOF, ""},
+	}
+	l := New(input)
+	l.SetSQLKeywords(true)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - expected %s %q, got %s %q", i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+	}
+
+	// Without the option they're identifiers.
+	l = New("IF AND")
+	for i := 0; i < 2; i++ {
+		if tok := l.NextToken(); tok.Type != token.IDENT {
+			t.Fatalf("expected an identifier, got %v", tok)
+		}
+	}
+}
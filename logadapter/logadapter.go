@@ -0,0 +1,108 @@
+// Package logadapter allows the records of structured loggers to be used
+// as the input to our scripts.
+//
+// Most structured loggers store the fields of a record as a map, or can
+// produce one, so that map may be passed to Fields to convert the values
+// which scripts cannot use directly:
+//
+//   - Durations become the number of seconds, as a float, just as the
+//     duration fields of objects, duration literals such as `1m30s`,
+//     and the `duration` function of the time package present them.
+//   - Errors become their message.
+//   - Times are left alone, so that the time functions may be used upon
+//     them.
+//
+// For logrus the fields are the Data member of an entry, and Record adds
+// the timestamp, level, and message:
+//
+//	obj := logadapter.Record(e.Time, e.Level.String(), e.Message, e.Data)
+//
+// For zap the fields may be collected with a map encoder:
+//
+//	enc := zapcore.NewMapObjectEncoder()
+//	for _, f := range fields {
+//		f.AddTo(enc)
+//	}
+//	obj := logadapter.Record(ent.Time, ent.Level.String(), ent.Message, enc.Fields)
+//
+// Records of the standard library's log/slog package may be converted via
+// Attrs, when built with Go 1.21 or higher.
+package logadapter
+
+import (
+	"time"
+)
+
+// The names of the fields Record adds, which are those used by the JSON
+// output of the common loggers.
+const (
+	// TimeKey is the name of the field holding the time of the record.
+	TimeKey = "time"
+
+	// LevelKey is the name of the field holding the level of the record.
+	LevelKey = "level"
+
+	// MessageKey is the name of the field holding the message.
+	MessageKey = "msg"
+)
+
+// Fields returns a copy of the given fields, with their values converted
+// to types which our scripts can use.
+//
+// Nested maps and slices are converted too.
+func Fields(fields map[string]interface{}) map[string]interface{} {
+
+	out := make(map[string]interface{}, len(fields))
+	for k, v := range fields {
+		out[k] = value(v)
+	}
+	return out
+}
+
+// Record returns the fields of a log record, along with its time, level,
+// and message.
+//
+// If the fields contain keys with the same names as those we add then
+// the fields win, as they were set explicitly.
+func Record(t time.Time, level string, msg string, fields map[string]interface{}) map[string]interface{} {
+
+	out := Fields(fields)
+	for k, v := range map[string]interface{}{
+		TimeKey:    t,
+		LevelKey:   level,
+		MessageKey: msg,
+	} {
+		if _, ok := out[k]; !ok {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// value converts a single value.
+func value(v interface{}) interface{} {
+
+	switch val := v.(type) {
+	case time.Duration:
+		return val.Seconds()
+	case time.Time:
+		return val
+	case error:
+		return val.Error()
+	case map[string]interface{}:
+		return Fields(val)
+	case []interface{}:
+		out := make([]interface{}, len(val))
+		for i, x := range val {
+			out[i] = value(x)
+		}
+		return out
+	case []error:
+		out := make([]interface{}, len(val))
+		for i, x := range val {
+			out[i] = value(x)
+		}
+		return out
+	}
+	return v
+}
@@ -0,0 +1,98 @@
+package logadapter
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/skx/evalfilter/v2"
+)
+
+// TestFields tests converting fields.
+func TestFields(t *testing.T) {
+
+	now := time.Date(2020, time.March, 4, 5, 6, 7, 0, time.UTC)
+
+	in := map[string]interface{}{
+		"latency": 1500 * time.Millisecond,
+		"err":     errors.New("connection refused"),
+		"when":    now,
+		"user":    "steve",
+		"count":   3,
+		"nested": map[string]interface{}{
+			"timeout": 30 * time.Second,
+		},
+		"list":   []interface{}{errors.New("a"), time.Minute},
+		"errors": []error{errors.New("b")},
+	}
+
+	out := Fields(in)
+
+	if out["latency"] != 1.5 {
+		t.Fatalf("wrong latency: %v", out["latency"])
+	}
+	if out["err"] != "connection refused" {
+		t.Fatalf("wrong error: %v", out["err"])
+	}
+	if out["when"] != now || out["user"] != "steve" || out["count"] != 3 {
+		t.Fatalf("values changed: %v", out)
+	}
+	if out["nested"].(map[string]interface{})["timeout"] != 30.0 {
+		t.Fatalf("wrong nested value: %v", out["nested"])
+	}
+	list := out["list"].([]interface{})
+	if list[0] != "a" || list[1] != 60.0 {
+		t.Fatalf("wrong list: %v", list)
+	}
+	if out["errors"].([]interface{})[0] != "b" {
+		t.Fatalf("wrong errors: %v", out["errors"])
+	}
+
+	// The input is unchanged.
+	if in["latency"] != 1500*time.Millisecond {
+		t.Fatalf("input was modified")
+	}
+}
+
+// TestRecord tests running scripts against a record.
+func TestRecord(t *testing.T) {
+
+	now := time.Date(2020, time.March, 4, 5, 6, 7, 0, time.UTC)
+
+	obj := Record(now, "error", "request failed", map[string]interface{}{
+		"latency": 250 * time.Millisecond,
+		"err":     errors.New("i/o timeout"),
+	})
+
+	tests := []struct {
+		input  string
+		result bool
+	}{
+		{`return level == "error" && msg ~= /failed/;`, true},
+		{`return hour(time) == 5 && year(time) == 2020;`, true},
+		{`return latency > 0.2 && latency < 0.3;`, true},
+		{`return err ~= /timeout/;`, true},
+		{`return level == "info";`, false},
+	}
+
+	for _, test := range tests {
+		eval := evalfilter.New(test.input)
+		err := eval.Prepare()
+		if err != nil {
+			t.Fatalf("failed to compile %s: %s", test.input, err)
+		}
+		res, err := eval.Run(obj)
+		if err != nil {
+			t.Fatalf("error running %s: %s", test.input, err)
+		}
+		if res != test.result {
+			t.Fatalf("wrong result for %s, got %t expected %t", test.input, res, test.result)
+		}
+	}
+
+	// Explicit fields win.
+	obj = Record(now, "info", "hello", map[string]interface{}{"level": "custom"})
+	if obj["level"] != "custom" || obj["msg"] != "hello" {
+		t.Fatalf("wrong record: %v", obj)
+	}
+}
@@ -0,0 +1,81 @@
+//go:build go1.21
+// +build go1.21
+
+package logadapter
+
+import (
+	"log/slog"
+)
+
+// Attrs returns the attributes of a slog record, along with its time,
+// level, and message, in the same way as Record.
+//
+// Groups become nested hashes, and values which implement slog.LogValuer
+// are resolved.
+func Attrs(r slog.Record) map[string]interface{} {
+
+	fields := make(map[string]interface{}, r.NumAttrs())
+	r.Attrs(func(a slog.Attr) bool {
+		addAttr(fields, a)
+		return true
+	})
+	return Record(r.Time, r.Level.String(), r.Message, fields)
+}
+
+// addAttr adds an attribute to the given fields.
+func addAttr(fields map[string]interface{}, a slog.Attr) {
+
+	v := a.Value.Resolve()
+
+	if v.Kind() == slog.KindGroup {
+
+		// Empty groups are ignored, and those without a name
+		// are inlined, as they are by the slog handlers.
+		attrs := v.Group()
+		if len(attrs) == 0 {
+			return
+		}
+
+		target := fields
+		if a.Key != "" {
+			group, ok := fields[a.Key].(map[string]interface{})
+			if !ok {
+				group = make(map[string]interface{}, len(attrs))
+				fields[a.Key] = group
+			}
+			target = group
+		}
+
+		for _, attr := range attrs {
+			addAttr(target, attr)
+		}
+		return
+	}
+
+	if a.Key == "" {
+		return
+	}
+	fields[a.Key] = slogValue(v)
+}
+
+// slogValue converts a single, resolved, value.
+func slogValue(v slog.Value) interface{} {
+
+	switch v.Kind() {
+	case slog.KindBool:
+		return v.Bool()
+	case slog.KindDuration:
+		return v.Duration().Seconds()
+	case slog.KindFloat64:
+		return v.Float64()
+	case slog.KindInt64:
+		return v.Int64()
+	case slog.KindString:
+		return v.String()
+	case slog.KindTime:
+		return v.Time()
+	case slog.KindUint64:
+		return v.Uint64()
+	}
+	return value(v.Any())
+}
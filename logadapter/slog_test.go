@@ -0,0 +1,62 @@
+//go:build go1.21
+// +build go1.21
+
+package logadapter
+
+import (
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+// secret implements slog.LogValuer.
+type secret string
+
+// LogValue hides the secret.
+func (s secret) LogValue() slog.Value {
+	return slog.StringValue("redacted")
+}
+
+// TestAttrs tests converting slog records.
+func TestAttrs(t *testing.T) {
+
+	now := time.Date(2020, time.March, 4, 5, 6, 7, 0, time.UTC)
+
+	r := slog.NewRecord(now, slog.LevelWarn, "slow request", 0)
+	r.AddAttrs(
+		slog.Duration("latency", 2*time.Second),
+		slog.Int("status", 500),
+		slog.Uint64("bytes", 42),
+		slog.Float64("ratio", 0.5),
+		slog.Bool("cached", false),
+		slog.Any("err", errors.New("boom")),
+		slog.Any("password", secret("hunter2")),
+		slog.Group("http", slog.String("method", "GET"), slog.Group("req", slog.String("path", "/"))),
+		slog.Group("", slog.String("inlined", "yes")),
+		slog.Group("empty"),
+	)
+
+	out := Attrs(r)
+
+	if out["time"] != now || out["level"] != "WARN" || out["msg"] != "slow request" {
+		t.Fatalf("wrong record fields: %v", out)
+	}
+	if out["latency"] != 2.0 || out["status"] != int64(500) || out["bytes"] != uint64(42) {
+		t.Fatalf("wrong numbers: %v", out)
+	}
+	if out["ratio"] != 0.5 || out["cached"] != false {
+		t.Fatalf("wrong values: %v", out)
+	}
+	if out["err"] != "boom" || out["password"] != "redacted" || out["inlined"] != "yes" {
+		t.Fatalf("wrong values: %v", out)
+	}
+
+	http := out["http"].(map[string]interface{})
+	if http["method"] != "GET" || http["req"].(map[string]interface{})["path"] != "/" {
+		t.Fatalf("wrong group: %v", http)
+	}
+	if _, ok := out["empty"]; ok {
+		t.Fatalf("empty groups should be ignored")
+	}
+}
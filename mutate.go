@@ -0,0 +1,165 @@
+// This file contains the code which allows scripts to modify a copy of
+// the object they're run against.
+
+package evalfilter
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/skx/evalfilter/v2/object"
+)
+
+// EventVariable is the name of the variable which holds the copy of the
+// object a script may modify, when it is run via Mutate.
+const EventVariable = "event"
+
+// Change describes a single modification a script made to an object.
+type Change struct {
+
+	// Path identifies the member which changed, e.g. "user.name".
+	Path string
+
+	// Old holds the previous value, which is nil if the member was
+	// added.
+	Old interface{}
+
+	// New holds the new value, which is nil if the member was removed.
+	New interface{}
+}
+
+// Mutation holds the outcome of running a script via Mutate.
+type Mutation struct {
+
+	// Result holds the result of the script, as Run would return it.
+	Result bool
+
+	// Event holds the modified copy of the object.
+	Event map[string]interface{}
+
+	// Changes holds the modifications the script made, sorted by
+	// their paths.
+	Changes []Change
+}
+
+// Mutate runs the script against the given object, allowing it to modify
+// a copy of the object, and returns that copy along with a list of the
+// changes which were made.
+//
+// The copy is available to the script as a hash named `event`, so a
+// script might look like:
+//
+//	if ( Message ~= /panic/ ) {
+//	    event.priority = "high";
+//	    event.tags = [ "crash" ];
+//	}
+//	return true;
+//
+// The fields of the object may still be read by name, as with Run, but
+// those names hold their original values; only the copy is modified.
+// The original object is never modified.  As with Run you must invoke
+// Prepare before Mutate.
+func (e *Eval) Mutate(obj interface{}) (*Mutation, error) {
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if e.machine == nil {
+		return nil, fmt.Errorf("the script has not been prepared")
+	}
+
+	// We convert the object twice, so that we have a pristine copy to
+	// compare against once the script has finished.
+	before := e.machine.Fields(obj)
+	event := hashOf(e.machine.Fields(obj))
+
+	// Preserve any variable the host has set with our name.
+	saved, ok := e.environment.Get(EventVariable)
+	e.environment.Set(EventVariable, event)
+	defer func() {
+		if ok {
+			e.environment.Set(EventVariable, saved)
+		} else {
+			e.environment.Unset(EventVariable)
+		}
+	}()
+
+	out, err := e.Execute(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &Mutation{
+		Result: e.machine.True(out),
+		Event:  toMap(event),
+	}
+	diff(&res.Changes, "", hashOf(before), event)
+	sort.Slice(res.Changes, func(i, j int) bool {
+		return res.Changes[i].Path < res.Changes[j].Path
+	})
+	return res, nil
+}
+
+// hashOf creates a hash from the given fields.
+func hashOf(fields map[string]object.Object) *object.Hash {
+
+	hash := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair, len(fields))}
+	for name, val := range fields {
+
+		// Values we couldn't convert are treated as null.
+		if val == nil {
+			val = object.Nil
+		}
+
+		key := &object.String{Value: name}
+		hash.Pairs[key.HashKey()] = object.HashPair{Key: key, Value: val}
+	}
+	return hash
+}
+
+// diff records the differences between two hashes, recursing into those
+// members which are hashes themselves.
+func diff(changes *[]Change, prefix string, before, after *object.Hash) {
+
+	path := func(key object.Object) string {
+		name := key.Inspect()
+		if str, ok := key.(*object.String); ok {
+			name = str.Value
+		}
+		if prefix == "" {
+			return name
+		}
+		return prefix + "." + name
+	}
+
+	for k, pair := range after.Pairs {
+
+		prev, ok := before.Pairs[k]
+		if !ok {
+			*changes = append(*changes, Change{Path: path(pair.Key), New: toGo(pair.Value)})
+			continue
+		}
+
+		a, aok := prev.Value.(*object.Hash)
+		b, bok := pair.Value.(*object.Hash)
+		if aok && bok {
+			diff(changes, path(pair.Key), a, b)
+			continue
+		}
+
+		if !same(prev.Value, pair.Value) {
+			*changes = append(*changes, Change{Path: path(pair.Key), Old: toGo(prev.Value), New: toGo(pair.Value)})
+		}
+	}
+
+	for k, pair := range before.Pairs {
+		if _, ok := after.Pairs[k]; !ok {
+			*changes = append(*changes, Change{Path: path(pair.Key), Old: toGo(pair.Value)})
+		}
+	}
+}
+
+// same returns true if the two objects hold the same value.
+func same(a, b object.Object) bool {
+	return a.Type() == b.Type() && a.Inspect() == b.Inspect()
+}
@@ -0,0 +1,80 @@
+// This file contains the namespaces of the rules within a RuleSet.
+//
+// Sets may hold thousands of rules, so their names may be divided into
+// namespaces by slashes, such as "auth/bruteforce" and "auth/spray",
+// which allows the rules of a namespace to be found, and to be enabled
+// or disabled, together.
+
+package evalfilter
+
+import (
+	"strings"
+)
+
+// inNamespace returns true if the given name is within the namespace, or
+// is the name of the rule given.
+//
+// A namespace contains the names which begin with it followed by a slash,
+// so "auth" contains "auth/bruteforce" but not "authz/bruteforce", and
+// the empty namespace contains every name.
+func inNamespace(name string, namespace string) bool {
+
+	namespace = strings.TrimSuffix(namespace, "/")
+	if namespace == "" {
+		return true
+	}
+	return name == namespace || strings.HasPrefix(name, namespace+"/")
+}
+
+// Group returns the rules within the given namespace, in the order they
+// were added, or the rule of that name.
+func (r *RuleSet) Group(namespace string) []*Rule {
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	var out []*Rule
+	for _, rule := range r.rules {
+		if inNamespace(rule.Name, namespace) {
+			out = append(out, rule)
+		}
+	}
+	return out
+}
+
+// Enable enables the rules within the given namespace, or the rule of
+// that name, and returns the number of rules it matched.
+//
+// Rules are enabled when they're added.  Enabling a rule, or a tenant,
+// which exceeded its quota allows it to be run again at once.
+func (r *RuleSet) Enable(namespace string) int {
+	return r.setDisabled(namespace, false)
+}
+
+// Disable disables the rules within the given namespace, or the rule of
+// that name, and returns the number of rules it matched.
+//
+// Disabled rules aren't run, so neither match an object nor suppress
+// other rules, and the rules which require them aren't run either.
+func (r *RuleSet) Disable(namespace string) int {
+	return r.setDisabled(namespace, true)
+}
+
+// setDisabled disables, or enables, the rules within the namespace.
+func (r *RuleSet) setDisabled(namespace string, disabled bool) int {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	count := 0
+	for _, rule := range r.rules {
+		if inNamespace(rule.Name, namespace) {
+			rule.Disabled = disabled
+			count++
+		}
+	}
+	if !disabled {
+		r.resetQuotas(namespace)
+	}
+	return count
+}
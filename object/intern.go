@@ -0,0 +1,58 @@
+// intern.go contains the objects which are shared, rather than being
+// allocated each time they're needed.
+//
+// Booleans, null, and integers are never modified once they've been
+// created, so a single object can represent every occurrence of the same
+// value.  Filters which consist of comparisons create little else, so
+// sharing them saves a lot of work for the garbage collector.
+
+package object
+
+// True is the object which represents every true value.
+var True = &Boolean{Value: true}
+
+// False is the object which represents every false value.
+var False = &Boolean{Value: false}
+
+// Nil is the object which represents every null value.
+var Nil = &Null{}
+
+// The range of the integers which are interned.
+const (
+	minInterned = -128
+	maxInterned = 1024
+)
+
+// interned holds the integers from minInterned to maxInterned, inclusive.
+var interned = func() []Integer {
+	out := make([]Integer, maxInterned-minInterned+1)
+	for i := range out {
+		out[i].Value = int64(i + minInterned)
+	}
+	return out
+}()
+
+// Bool returns the object which represents the given boolean.
+func Bool(val bool) *Boolean {
+	if val {
+		return True
+	}
+	return False
+}
+
+// Interned returns true if integers with the given value are shared.
+func Interned(val int64) bool {
+	return val >= minInterned && val <= maxInterned
+}
+
+// Int returns an integer with the given value.
+//
+// Small integers, from -128 to 1024, are shared, so the object which is
+// returned must not be modified.  Create an Integer yourself if you need
+// one which may be.
+func Int(val int64) *Integer {
+	if Interned(val) {
+		return &interned[val-minInterned]
+	}
+	return &Integer{Value: val}
+}
@@ -0,0 +1,122 @@
+// This file contains the code which converts our objects to, and from,
+// JSON.
+//
+// Each of our JSONAble types implements the json.Marshaler interface,
+// and most implement json.Unmarshaler too.  This allows a host to place
+// the objects returned by a script directly into an API response, and to
+// construct objects from JSON without any intermediate conversion.
+
+package object
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// MarshalJSON converts the given object to JSON, returning an error if
+// the object cannot be exported.
+//
+// Each of our JSONAble types also implements the json.Marshaler
+// interface, via this function, which allows objects to be passed to
+// json.Marshal directly, or embedded within your own structures.
+func MarshalJSON(obj Object) ([]byte, error) {
+
+	helper, ok := obj.(JSONAble)
+	if !ok {
+		return nil, fmt.Errorf("object doesn't implement JSONAble %s", obj.Inspect())
+	}
+
+	out, err := helper.JSON()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(out), nil
+}
+
+// UnmarshalJSON converts the given JSON to one of our objects.
+//
+// Objects become hashes, lists become arrays, and numbers become
+// integers if they have no fractional part, otherwise floats.
+//
+// Since the type of the result depends upon the input you must use
+// this function, rather than json.Unmarshal, to decode values of an
+// unknown type.
+func UnmarshalJSON(data []byte) (Object, error) {
+
+	if !json.Valid(data) {
+		return nil, fmt.Errorf("invalid JSON: %s", data)
+	}
+
+	// Decode numbers as such, so that we can tell integers
+	// from floating-point numbers.
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var val interface{}
+	err := dec.Decode(&val)
+	if err != nil {
+		return nil, err
+	}
+
+	return fromJSON(val), nil
+}
+
+// fromJSON converts a decoded JSON value to one of our objects.
+func fromJSON(val interface{}) Object {
+
+	switch val := val.(type) {
+	case bool:
+		return &Boolean{Value: val}
+	case string:
+		return &String{Value: val}
+	case json.Number:
+		if i, err := val.Int64(); err == nil {
+			return &Integer{Value: i}
+		}
+		f, _ := val.Float64()
+		return &Float{Value: f}
+	case []interface{}:
+		elements := make([]Object, len(val))
+		for i, v := range val {
+			elements[i] = fromJSON(v)
+		}
+		return &Array{Elements: elements}
+	case map[string]interface{}:
+		pairs := make(map[HashKey]HashPair)
+		for k, v := range val {
+			key := &String{Value: k}
+			pairs[key.HashKey()] = HashPair{Key: key, Value: fromJSON(v)}
+		}
+		return &Hash{Pairs: pairs}
+	}
+
+	return &Null{}
+}
+
+// unmarshalAs converts the given JSON to one of our objects, returning
+// an error if the result is not of the expected type.
+//
+// As encoding/json expects, a JSON null leaves the destination alone;
+// this is signalled by returning nil, and no error.
+func unmarshalAs(data []byte, expected Type) (Object, error) {
+
+	obj, err := UnmarshalJSON(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if obj.Type() == NULL && expected != NULL {
+		return nil, nil
+	}
+
+	// Integers are valid floats.
+	if i, ok := obj.(*Integer); ok && expected == FLOAT {
+		return &Float{Value: float64(i.Value)}, nil
+	}
+
+	if obj.Type() != expected {
+		return nil, fmt.Errorf("cannot convert %s to %s", obj.Type(), expected)
+	}
+	return obj, nil
+}
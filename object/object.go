@@ -5,12 +5,17 @@
 //
 // * Arrays.
 // * Boolean values.
+// * Bytes, from the byte-slices of the object a script is run against.
+// * Errors, which are returned by functions which fail.
 // * Floating-point numbers.
+// * Functions, which may be stored in variables and passed to others.
 // * Hashes.
 // * Integer numbers.
+// * Iterators, which produce their contents lazily.
 // * Null
 // * String values.
 // * Regular-expression objects.
+// * Times.
 //
 // To allow these objects to be used interchanagably each kind of object
 // must implement the same simple interface.
@@ -25,15 +30,20 @@ type Type string
 
 // pre-defined object types.
 const (
-	ARRAY   = "ARRAY"
-	BOOLEAN = "BOOLEAN"
-	FLOAT   = "FLOAT"
-	HASH    = "HASH"
-	INTEGER = "INTEGER"
-	NULL    = "NULL"
-	REGEXP  = "REGEXP"
-	STRING  = "STRING"
-	VOID    = "VOID"
+	ARRAY    = "ARRAY"
+	BOOLEAN  = "BOOLEAN"
+	BYTES    = "BYTES"
+	ERROR    = "ERROR"
+	FLOAT    = "FLOAT"
+	FUNCTION = "FUNCTION"
+	HASH     = "HASH"
+	INTEGER  = "INTEGER"
+	ITERATOR = "ITERATOR"
+	NULL     = "NULL"
+	REGEXP   = "REGEXP"
+	STRING   = "STRING"
+	TIME     = "TIME"
+	VOID     = "VOID"
 )
 
 // Object is the interface that all of our various object-types must implement.
@@ -2,6 +2,7 @@ package object
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"strings"
 )
@@ -100,6 +101,26 @@ func (ao *Array) JSON() (string, error) {
 	return out.String(), nil
 }
 
+// MarshalJSON implements the json.Marshaler interface.
+func (ao *Array) MarshalJSON() ([]byte, error) {
+	return MarshalJSON(ao)
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (ao *Array) UnmarshalJSON(data []byte) error {
+	obj, err := unmarshalAs(data, ARRAY)
+	if err != nil {
+		return err
+	}
+	if obj != nil {
+		ao.Elements = obj.(*Array).Elements
+		ao.offset = 0
+	}
+	return nil
+}
+
 // Ensure this object implements the expected interfaces
 var _ Iterable = &Array{}
-var _ JSONAble = &String{}
+var _ JSONAble = &Array{}
+var _ json.Marshaler = &Array{}
+var _ json.Unmarshaler = &Array{}
@@ -1,6 +1,9 @@
 package object
 
-import "fmt"
+import (
+	"encoding/json"
+	"fmt"
+)
 
 // Boolean wraps bool and implements the Object interface.
 type Boolean struct {
@@ -38,5 +41,24 @@ func (b *Boolean) JSON() (string, error) {
 	return fmt.Sprintf("%t", b.Value), nil
 }
 
+// MarshalJSON implements the json.Marshaler interface.
+func (b *Boolean) MarshalJSON() ([]byte, error) {
+	return MarshalJSON(b)
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (b *Boolean) UnmarshalJSON(data []byte) error {
+	obj, err := unmarshalAs(data, BOOLEAN)
+	if err != nil {
+		return err
+	}
+	if obj != nil {
+		b.Value = obj.(*Boolean).Value
+	}
+	return nil
+}
+
 // Ensure this object implements the expected interfaces.
-var _ JSONAble = &Integer{}
+var _ JSONAble = &Boolean{}
+var _ json.Marshaler = &Boolean{}
+var _ json.Unmarshaler = &Boolean{}
@@ -0,0 +1,113 @@
+package object
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"hash/fnv"
+)
+
+// Bytes wraps []byte and implements the Object interface.
+//
+// Byte-slices within the object a script is run against are presented
+// as Bytes, rather than as an array of integers, so that content may be
+// given to functions such as `sniff_mime` without first being copied
+// into an array.  Bytes are used with strings as the string holding the
+// same bytes, whilst their members are integers.
+type Bytes struct {
+	// Value holds the bytes this object wraps.
+	Value []byte
+
+	// offset holds our iteration-offset.
+	offset int
+}
+
+// Type returns the type of this object.
+func (b *Bytes) Type() Type {
+	return BYTES
+}
+
+// Inspect returns a string-representation of the given object, which is
+// the string holding the same bytes.
+func (b *Bytes) Inspect() string {
+	return string(b.Value)
+}
+
+// True returns whether this object wraps a true-like value.
+//
+// Used when this object is the conditional in a comparison, etc.  Bytes
+// are true unless they're empty.
+func (b *Bytes) True() bool {
+	return len(b.Value) > 0
+}
+
+// ToInterface converts this object to a go-interface, which will allow
+// it to be used naturally in our sprintf/printf primitives.
+//
+// It might also be helpful for embedded users.
+func (b *Bytes) ToInterface() interface{} {
+	return b.Value
+}
+
+// Reset implements the Iterable interface, and allows the contents
+// of the bytes to be reset to allow re-iteration.
+func (b *Bytes) Reset() {
+	b.offset = 0
+}
+
+// Next implements the Iterable interface, and allows the contents
+// of our bytes to be iterated over, as integers.
+func (b *Bytes) Next() (Object, Object, bool) {
+	if b.offset < len(b.Value) {
+		b.offset++
+
+		element := Int(int64(b.Value[b.offset-1]))
+		return element, &Integer{Value: int64(b.offset - 1)}, true
+	}
+
+	return nil, &Integer{Value: 0}, false
+}
+
+// HashKey returns a hash key for the given object.
+func (b *Bytes) HashKey() HashKey {
+	h := fnv.New64a()
+	h.Write(b.Value)
+	return HashKey{Type: b.Type(), Value: h.Sum64()}
+}
+
+// JSON converts this object to a JSON string, which holds the bytes in
+// base64, as encoding/json exports []byte.
+func (b *Bytes) JSON() (string, error) {
+	return quoteJSON(base64.StdEncoding.EncodeToString(b.Value)), nil
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (b *Bytes) MarshalJSON() ([]byte, error) {
+	return MarshalJSON(b)
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+//
+// The bytes must be a string holding them in base64.
+func (b *Bytes) UnmarshalJSON(data []byte) error {
+	obj, err := unmarshalAs(data, STRING)
+	if err != nil {
+		return err
+	}
+	if obj == nil {
+		return nil
+	}
+	val, err := base64.StdEncoding.DecodeString(obj.(*String).Value)
+	if err != nil {
+		return err
+	}
+	b.Value = val
+	b.offset = 0
+	return nil
+}
+
+// Ensure this object implements the expected interfaces.
+var _ Hashable = &Bytes{}
+var _ Iterable = &Bytes{}
+var _ JSONAble = &Bytes{}
+var _ json.Marshaler = &Bytes{}
+var _ json.Unmarshaler = &Bytes{}
@@ -0,0 +1,67 @@
+package object
+
+import "encoding/json"
+
+// Error wraps an error which occurred within a function, and implements
+// our Object interface.
+//
+// Errors are returned to the script, rather than causing the script to
+// terminate, so that the script may decide how to handle them.
+type Error struct {
+	// Message holds the description of the error.
+	Message string
+}
+
+// Type returns the type of this object.
+func (e *Error) Type() Type {
+	return ERROR
+}
+
+// Inspect returns a string-representation of the given object.
+func (e *Error) Inspect() string {
+	return e.Message
+}
+
+// True returns whether this object wraps a true-like value.
+//
+// Used when this object is the conditional in a comparison, etc.
+func (e *Error) True() bool {
+	return false
+}
+
+// ToInterface converts this object to a go-interface, which will allow
+// it to be used naturally in our sprintf/printf primitives.
+//
+// It might also be helpful for embedded users.
+func (e *Error) ToInterface() interface{} {
+	return e.Message
+}
+
+// JSON converts this object to a JSON string, containing the message.
+func (e *Error) JSON() (string, error) {
+	return quoteJSON(e.Message), nil
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (e *Error) MarshalJSON() ([]byte, error) {
+	return MarshalJSON(e)
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+//
+// The message is given as a JSON string.
+func (e *Error) UnmarshalJSON(data []byte) error {
+	obj, err := unmarshalAs(data, STRING)
+	if err != nil {
+		return err
+	}
+	if obj != nil {
+		e.Message = obj.(*String).Value
+	}
+	return nil
+}
+
+// Ensure this object implements the expected interfaces.
+var _ JSONAble = &Error{}
+var _ json.Marshaler = &Error{}
+var _ json.Unmarshaler = &Error{}
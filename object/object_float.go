@@ -1,8 +1,10 @@
 package object
 
 import (
+	"encoding/json"
 	"fmt"
 	"hash/fnv"
+	"math"
 	"strconv"
 )
 
@@ -57,12 +59,39 @@ func (f *Float) HashKey() HashKey {
 }
 
 // JSON converts this object to a JSON string.
+//
+// JSON has no representation for infinities, or NaN, so they cannot
+// be converted.
 func (f *Float) JSON() (string, error) {
+	if math.IsInf(f.Value, 0) || math.IsNaN(f.Value) {
+		return "", fmt.Errorf("cannot convert %s to JSON", f.Inspect())
+	}
 	return fmt.Sprintf("%f", f.Value), nil
 }
 
+// MarshalJSON implements the json.Marshaler interface.
+func (f *Float) MarshalJSON() ([]byte, error) {
+	return MarshalJSON(f)
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+//
+// Integers are accepted, and converted.
+func (f *Float) UnmarshalJSON(data []byte) error {
+	obj, err := unmarshalAs(data, FLOAT)
+	if err != nil {
+		return err
+	}
+	if obj != nil {
+		f.Value = obj.(*Float).Value
+	}
+	return nil
+}
+
 // Ensure this object implements the expected interfaces.
 var _ Decrement = &Float{}
 var _ Hashable = &Float{}
 var _ Increment = &Float{}
 var _ JSONAble = &Float{}
+var _ json.Marshaler = &Float{}
+var _ json.Unmarshaler = &Float{}
@@ -0,0 +1,73 @@
+package object
+
+import (
+	"strings"
+
+	"github.com/skx/evalfilter/v2/code"
+)
+
+// Function wraps a function, and implements the Object interface.
+//
+// Functions may be defined by the script, either with a name or as
+// anonymous function-literals, or by the host application.  They may be
+// stored in variables, and passed to other functions, just like any
+// other value.
+type Function struct {
+
+	// Name holds the name of the function, which is empty if the
+	// function is anonymous.
+	Name string
+
+	// Arguments holds the names of the parameters of the function.
+	Arguments []string
+
+	// Bytecode holds the compiled body of a function which was
+	// defined by the script.
+	Bytecode code.Instructions
+
+	// Sources holds the positions, within the script, of the
+	// instructions in the bytecode.
+	Sources code.SourceMap
+
+	// Free holds the names of the variables which the body of an
+	// anonymous function refers to, but doesn't define.
+	Free []string
+
+	// Captured holds the variables which were captured when a
+	// closure was created, which are set whenever it is called.
+	Captured map[string]Object
+
+	// Builtin holds the implementation of a function which was
+	// defined by the host application, in which case there is no
+	// bytecode.
+	Builtin func(args []Object) Object
+}
+
+// Type returns the type of this object.
+func (f *Function) Type() Type {
+	return FUNCTION
+}
+
+// Inspect returns a string-representation of the given object.
+func (f *Function) Inspect() string {
+	name := "function"
+	if f.Name != "" {
+		name += " " + f.Name
+	}
+	return name + "(" + strings.Join(f.Arguments, ", ") + ")"
+}
+
+// True returns whether this object wraps a true-like value.
+//
+// Used when this object is the conditional in a comparison, etc.
+func (f *Function) True() bool {
+	return true
+}
+
+// ToInterface converts this object to a go-interface, which will allow
+// it to be used naturally in our sprintf/printf primitives.
+//
+// It might also be helpful for embedded users.
+func (f *Function) ToInterface() interface{} {
+	return f.Inspect()
+}
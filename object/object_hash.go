@@ -2,6 +2,7 @@ package object
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"sort"
 	"strings"
@@ -28,9 +29,16 @@ type HashPair struct {
 // ByName implements sort.Interface for []HashPair based on the Key field.
 type ByName []HashPair
 
-func (a ByName) Len() int           { return len(a) }
-func (a ByName) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
-func (a ByName) Less(i, j int) bool { return a[i].Key.Inspect() < a[j].Key.Inspect() }
+func (a ByName) Len() int      { return len(a) }
+func (a ByName) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a ByName) Less(i, j int) bool {
+	// Keys such as `1` and `"1"` share a string-representation,
+	// so fall back to the type to keep our ordering stable.
+	if a[i].Key.Inspect() == a[j].Key.Inspect() {
+		return a[i].Key.Type() < a[j].Key.Type()
+	}
+	return a[i].Key.Inspect() < a[j].Key.Inspect()
+}
 
 // Hash wrap map[HashKey]HashPair and implements Object interface.
 type Hash struct {
@@ -39,6 +47,13 @@ type Hash struct {
 
 	// offset holds our iteration-offset.
 	offset int
+
+	// entries holds the sorted entries we're iterating over.
+	//
+	// These are captured when the iteration is reset, so that the
+	// hash may be modified during iteration without the ordering
+	// changing beneath us.
+	entries []HashPair
 }
 
 // Type returns the type of this object.
@@ -96,28 +111,30 @@ func (h *Hash) True() bool {
 // of the array to be reset to allow re-iteration.
 func (h *Hash) Reset() {
 	h.offset = 0
+	h.entries = h.Entries()
 }
 
 // Next implements the Iterable interface, and allows the contents
-// of our array to be iterated over.
+// of our hash to be iterated over.
+//
+// Entries are returned in the order of their keys, with the value
+// being returned as the item and the key being returned as the index.
 func (h *Hash) Next() (Object, Object, bool) {
 
-	// Get the list of entries, sorted by key-name.
-	entries := h.Entries()
+	// Iteration without a reset?
+	if h.entries == nil {
+		h.entries = h.Entries()
+	}
 
 	// Now pick the next entry
-	if h.offset < len(entries) {
-		idx := 0
-
-		for _, pair := range entries {
-			if h.offset == idx {
-				h.offset++
-				return pair.Value, pair.Key, true
-			}
-			idx++
-		}
+	if h.offset < len(h.entries) {
+		pair := h.entries[h.offset]
+		h.offset++
+		return pair.Value, pair.Key, true
 	}
 
+	// Release our snapshot
+	h.entries = nil
 	return nil, &Integer{Value: 0}, false
 }
 
@@ -154,8 +171,8 @@ func (h *Hash) JSON() (string, error) {
 		}
 
 		// Now build up the JSON
-		pairs = append(pairs, fmt.Sprintf("\"%s\": %s",
-			entry.Key.Inspect(), tmp))
+		pairs = append(pairs, fmt.Sprintf("%s: %s",
+			quoteJSON(entry.Key.Inspect()), tmp))
 	}
 	out.WriteString("{")
 	out.WriteString(strings.Join(pairs, ", "))
@@ -164,6 +181,25 @@ func (h *Hash) JSON() (string, error) {
 
 }
 
+// MarshalJSON implements the json.Marshaler interface.
+func (h *Hash) MarshalJSON() ([]byte, error) {
+	return MarshalJSON(h)
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (h *Hash) UnmarshalJSON(data []byte) error {
+	obj, err := unmarshalAs(data, HASH)
+	if err != nil {
+		return err
+	}
+	if obj != nil {
+		h.Pairs = obj.(*Hash).Pairs
+	}
+	return nil
+}
+
 // Ensure this object implements the expected interfaces.
 var _ Iterable = &Hash{}
 var _ JSONAble = &Hash{}
+var _ json.Marshaler = &Hash{}
+var _ json.Unmarshaler = &Hash{}
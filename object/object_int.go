@@ -1,6 +1,9 @@
 package object
 
-import "fmt"
+import (
+	"encoding/json"
+	"fmt"
+)
 
 // Integer wraps int64 and implements the Object interface.
 type Integer struct {
@@ -55,8 +58,27 @@ func (i *Integer) JSON() (string, error) {
 	return fmt.Sprintf("%d", i.Value), nil
 }
 
+// MarshalJSON implements the json.Marshaler interface.
+func (i *Integer) MarshalJSON() ([]byte, error) {
+	return MarshalJSON(i)
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (i *Integer) UnmarshalJSON(data []byte) error {
+	obj, err := unmarshalAs(data, INTEGER)
+	if err != nil {
+		return err
+	}
+	if obj != nil {
+		i.Value = obj.(*Integer).Value
+	}
+	return nil
+}
+
 // Ensure this object implements the expected interfaces.
 var _ Decrement = &Integer{}
 var _ Hashable = &Integer{}
 var _ Increment = &Integer{}
 var _ JSONAble = &Integer{}
+var _ json.Marshaler = &Integer{}
+var _ json.Unmarshaler = &Integer{}
@@ -0,0 +1,99 @@
+package object
+
+// Iterator wraps a generator-function and implements the Object interface.
+//
+// Unlike arrays an iterator doesn't hold its contents in memory, instead
+// each item is produced on-demand as the `foreach` loop asks for it.  This
+// allows huge collections - for example the pieces of a large string, or
+// rows read from a database by the host application - to be processed
+// without materializing them all at once.
+//
+// Because the items are generated lazily an iterator may only be consumed
+// once; iterating over it a second time will produce nothing further.
+type Iterator struct {
+
+	// Source is the function which produces our items.
+	//
+	// It should return the next item, and true, or false once
+	// the items have been exhausted.
+	Source func() (Object, bool)
+
+	// offset holds the index of the next item we produce.
+	offset int
+
+	// done is set once our source has been exhausted.
+	done bool
+}
+
+// NewIterator creates a new iterator, which will produce items by
+// invoking the given function.
+func NewIterator(source func() (Object, bool)) *Iterator {
+	return &Iterator{Source: source}
+}
+
+// Type returns the type of this object.
+func (i *Iterator) Type() Type {
+	return ITERATOR
+}
+
+// Inspect returns a string-representation of the given object.
+//
+// We don't consume our items to display them.
+func (i *Iterator) Inspect() string {
+	return "<iterator>"
+}
+
+// True returns whether this object wraps a true-like value.
+//
+// An iterator is always true, since we cannot know whether it is empty
+// without consuming an item.
+func (i *Iterator) True() bool {
+	return true
+}
+
+// ToInterface converts this object to a go-interface, which will allow
+// it to be used naturally in our sprintf/printf primitives.
+func (i *Iterator) ToInterface() interface{} {
+	return "<iterator>"
+}
+
+// Reset implements the Iterable interface.
+//
+// Our items are generated on-demand, so there is nothing we can rewind.
+func (i *Iterator) Reset() {
+}
+
+// Next implements the Iterable interface, and returns the next item
+// from our source, along with its index.
+func (i *Iterator) Next() (Object, Object, bool) {
+
+	if i.done || i.Source == nil {
+		return nil, &Integer{Value: 0}, false
+	}
+
+	val, ok := i.Source()
+	if !ok {
+		i.done = true
+		return nil, &Integer{Value: 0}, false
+	}
+
+	if val == nil {
+		val = &Null{}
+	}
+
+	idx := i.offset
+	i.offset++
+
+	return val, &Integer{Value: int64(idx)}, true
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+//
+// Our items cannot be exported without consuming them, so this always
+// returns an error.
+func (i *Iterator) MarshalJSON() ([]byte, error) {
+	return MarshalJSON(i)
+}
+
+// Ensure this object implements the expected interfaces.
+var _ Iterable = &Iterator{}
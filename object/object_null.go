@@ -1,5 +1,7 @@
 package object
 
+import "encoding/json"
+
 // Null wraps nothing and implements our Object interface.
 type Null struct{}
 
@@ -33,5 +35,18 @@ func (n *Null) JSON() (string, error) {
 	return "null", nil
 }
 
+// MarshalJSON implements the json.Marshaler interface.
+func (n *Null) MarshalJSON() ([]byte, error) {
+	return MarshalJSON(n)
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (n *Null) UnmarshalJSON(data []byte) error {
+	_, err := unmarshalAs(data, NULL)
+	return err
+}
+
 // Ensure this object implements the expected interfaces.
 var _ JSONAble = &Null{}
+var _ json.Marshaler = &Null{}
+var _ json.Unmarshaler = &Null{}
@@ -1,5 +1,7 @@
 package object
 
+import "encoding/json"
+
 // Regexp wraps string and implements the Object interface.
 type Regexp struct {
 	// Value holds the string value this object wraps.
@@ -32,3 +34,32 @@ func (r *Regexp) True() bool {
 func (r *Regexp) ToInterface() interface{} {
 	return r.Value
 }
+
+// JSON converts this object to a JSON string, containing the pattern.
+func (r *Regexp) JSON() (string, error) {
+	return quoteJSON(r.Value), nil
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (r *Regexp) MarshalJSON() ([]byte, error) {
+	return MarshalJSON(r)
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+//
+// The pattern is given as a JSON string.
+func (r *Regexp) UnmarshalJSON(data []byte) error {
+	obj, err := unmarshalAs(data, STRING)
+	if err != nil {
+		return err
+	}
+	if obj != nil {
+		r.Value = obj.(*String).Value
+	}
+	return nil
+}
+
+// Ensure this object implements the expected interfaces.
+var _ JSONAble = &Regexp{}
+var _ json.Marshaler = &Regexp{}
+var _ json.Unmarshaler = &Regexp{}
@@ -1,9 +1,10 @@
 package object
 
 import (
+	"bytes"
+	"encoding/json"
 	"hash/fnv"
-	"strconv"
-	"unicode/utf8"
+	"strings"
 )
 
 // String wraps string and implements the Object interface.
@@ -13,6 +14,10 @@ type String struct {
 
 	// Offset holds our iteration-offset
 	offset int
+
+	// chars holds the characters we're iterating over, which
+	// saves us from decoding the string upon every step.
+	chars []rune
 }
 
 // Type returns the type of this object.
@@ -44,24 +49,28 @@ func (s *String) ToInterface() interface{} {
 // of the string to be reset to allow re-iteration.
 func (s *String) Reset() {
 	s.offset = 0
+	s.chars = []rune(s.Value)
 }
 
 // Next implements the Iterable interface, and allows the contents
 // of our string to be iterated over.
 func (s *String) Next() (Object, Object, bool) {
 
-	if s.offset < utf8.RuneCountInString(s.Value) {
-		s.offset++
+	// Iteration without a reset?
+	if s.chars == nil {
+		s.chars = []rune(s.Value)
+	}
 
-		// Get the characters as an array of runes
-		chars := []rune(s.Value)
+	if s.offset < len(s.chars) {
+		s.offset++
 
-		// Now index
-		val := String{Value: string(chars[s.offset-1])}
+		val := String{Value: string(s.chars[s.offset-1])}
 
 		return &val, &Integer{Value: int64(s.offset - 1)}, true
 	}
 
+	// Release our snapshot
+	s.chars = nil
 	return nil, &Integer{Value: 0}, false
 }
 
@@ -74,10 +83,45 @@ func (s *String) HashKey() HashKey {
 
 // JSON converts this object to a JSON string.
 func (s *String) JSON() (string, error) {
-	return strconv.Quote(s.Value), nil
+	return quoteJSON(s.Value), nil
+}
+
+// quoteJSON returns the given string as a quoted JSON string.
+//
+// We can't use strconv.Quote, because the escapes it produces for
+// control-characters, such as `\x00`, are not valid JSON.
+func quoteJSON(str string) string {
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+
+	// Encoding a string cannot fail.
+	enc.Encode(str)
+
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (s *String) MarshalJSON() ([]byte, error) {
+	return MarshalJSON(s)
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (s *String) UnmarshalJSON(data []byte) error {
+	obj, err := unmarshalAs(data, STRING)
+	if err != nil {
+		return err
+	}
+	if obj != nil {
+		s.Value = obj.(*String).Value
+	}
+	return nil
 }
 
 // Ensure this object implements the expected interfaces
 var _ Hashable = &String{}
 var _ Iterable = &String{}
 var _ JSONAble = &String{}
+var _ json.Marshaler = &String{}
+var _ json.Unmarshaler = &String{}
@@ -1,9 +1,12 @@
 package object
 
 import (
+	"encoding/json"
 	"fmt"
+	"math"
 	"strings"
 	"testing"
+	"time"
 )
 
 // TestArray tests our Array object a little
@@ -173,6 +176,32 @@ func TestBool(t *testing.T) {
 	}
 }
 
+// TestError tests our Error-object in a basic way.
+func TestError(t *testing.T) {
+
+	v := &Error{Message: "it broke"}
+
+	// Inspect
+	if v.Inspect() != "it broke" {
+		t.Fatalf("Invalid Inspect() value!")
+	}
+
+	// Type
+	if v.Type() != ERROR {
+		t.Fatalf("Wrong type")
+	}
+
+	// True
+	if v.True() {
+		t.Fatalf("error object should never be True")
+	}
+
+	x := v.ToInterface()
+	if x.(string) != "it broke" {
+		t.Fatalf("interface usage failed")
+	}
+}
+
 // TestFloat tests our Float-object in a basic way.
 func TestFloat(t *testing.T) {
 
@@ -232,6 +261,36 @@ func TestFloat(t *testing.T) {
 	}
 }
 
+// TestFunction tests our Function-object in a basic way.
+func TestFunction(t *testing.T) {
+
+	f := &Function{Name: "add", Arguments: []string{"a", "b"}}
+
+	// Inspect
+	if f.Inspect() != "function add(a, b)" {
+		t.Fatalf("Invalid Inspect() value: %s", f.Inspect())
+	}
+	anon := &Function{Arguments: []string{"x"}}
+	if anon.Inspect() != "function(x)" {
+		t.Fatalf("Invalid Inspect() value: %s", anon.Inspect())
+	}
+
+	// Type
+	if f.Type() != FUNCTION {
+		t.Fatalf("Wrong type")
+	}
+
+	// True
+	if !f.True() {
+		t.Fatalf("functions should always be True")
+	}
+
+	x := f.ToInterface()
+	if x != "function add(a, b)" {
+		t.Fatalf("interface usage failed")
+	}
+}
+
 // TestHash tests our hash object in a basic way
 func TestHash(t *testing.T) {
 	tmp := &Hash{}
@@ -305,6 +364,35 @@ func TestHash(t *testing.T) {
 	}
 }
 
+// TestHashIterationOrder ensures keys which share a string-representation
+// are iterated over in a stable order.
+func TestHashIterationOrder(t *testing.T) {
+
+	for i := 0; i < 20; i++ {
+
+		tmp := &Hash{Pairs: make(map[HashKey]HashPair)}
+
+		keys := []Object{&String{Value: "1"}, &Integer{Value: 1}, &String{Value: "0"}}
+		for _, k := range keys {
+			tmp.Pairs[k.(Hashable).HashKey()] = HashPair{Key: k, Value: k}
+		}
+
+		out := ""
+		tmp.Reset()
+		for {
+			_, k, more := tmp.Next()
+			if !more {
+				break
+			}
+			out += string(k.Type()) + ":" + k.Inspect() + " "
+		}
+
+		if out != "STRING:0 INTEGER:1 STRING:1 " {
+			t.Fatalf("unexpected iteration order: %s", out)
+		}
+	}
+}
+
 // TestInt tests our Integer-object in a basic way.
 func TestInt(t *testing.T) {
 
@@ -365,6 +453,191 @@ func TestInt(t *testing.T) {
 	}
 }
 
+// TestTime tests our Time object.
+func TestTime(t *testing.T) {
+
+	when := time.Date(2020, time.March, 4, 5, 6, 7, 0, time.UTC)
+	tmp := &Time{Value: when}
+	nul := &Time{}
+
+	if tmp.Inspect() != "2020-03-04T05:06:07Z" {
+		t.Fatalf("Invalid value: %s", tmp.Inspect())
+	}
+	if tmp.Type() != TIME {
+		t.Fatalf("Wrong type")
+	}
+	if !tmp.True() || nul.True() {
+		t.Fatalf("only the zero time should be false")
+	}
+	if !tmp.ToInterface().(time.Time).Equal(when) {
+		t.Fatalf("interface usage failed")
+	}
+
+	// Seconds are integers, unless there's a fraction.
+	if tmp.Seconds().Inspect() != "1583298367" {
+		t.Fatalf("wrong seconds: %s", tmp.Seconds().Inspect())
+	}
+	half := &Time{Value: when.Add(500 * time.Millisecond)}
+	if half.Seconds().Inspect() != "1583298367.5" {
+		t.Fatalf("wrong seconds: %s", half.Seconds().Inspect())
+	}
+
+	// Equal times hash the same, whatever their location.
+	berlin := &Time{Value: when.In(time.FixedZone("CET", 3600))}
+	if tmp.HashKey() != berlin.HashKey() {
+		t.Fatalf("equal times should have the same hash")
+	}
+	if tmp.HashKey() == half.HashKey() {
+		t.Fatalf("different times should have different hashes")
+	}
+
+	// JSON
+	out, err := json.Marshal(map[string]Object{"when": berlin})
+	if err != nil {
+		t.Fatalf("failed to marshal: %s", err)
+	}
+	if string(out) != `{"when":"2020-03-04T06:06:07+01:00"}` {
+		t.Fatalf("wrong JSON: %s", out)
+	}
+	var back Time
+	err = json.Unmarshal([]byte(`"2020-03-04T06:06:07+01:00"`), &back)
+	if err != nil || !back.Value.Equal(when) {
+		t.Fatalf("failed to unmarshal: %v %s", err, back.Inspect())
+	}
+	err = json.Unmarshal([]byte(`"yesterday"`), &back)
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	err = json.Unmarshal([]byte(`3`), &back)
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+}
+
+// TestBytes tests our Bytes object.
+func TestBytes(t *testing.T) {
+
+	tmp := &Bytes{Value: []byte("hi")}
+	nul := &Bytes{}
+
+	if tmp.Inspect() != "hi" {
+		t.Fatalf("Invalid value: %s", tmp.Inspect())
+	}
+	if tmp.Type() != BYTES {
+		t.Fatalf("Wrong type")
+	}
+	if !tmp.True() || nul.True() {
+		t.Fatalf("only empty bytes should be false")
+	}
+	if string(tmp.ToInterface().([]byte)) != "hi" {
+		t.Fatalf("interface usage failed")
+	}
+
+	// Bytes hash differently to the string holding them.
+	if tmp.HashKey() != (&Bytes{Value: []byte("hi")}).HashKey() {
+		t.Fatalf("equal bytes should have the same hash")
+	}
+	if tmp.HashKey() == (&String{Value: "hi"}).HashKey() {
+		t.Fatalf("bytes should not have the same hash as a string")
+	}
+
+	// Iteration gives integers.
+	tmp.Reset()
+	sum := int64(0)
+	for {
+		val, _, ok := tmp.Next()
+		if !ok {
+			break
+		}
+		sum += val.(*Integer).Value
+	}
+	if sum != 'h'+'i' {
+		t.Fatalf("wrong sum of bytes: %d", sum)
+	}
+
+	// JSON holds base64, as encoding/json does.
+	out, err := json.Marshal(map[string]Object{"data": tmp})
+	if err != nil {
+		t.Fatalf("failed to marshal: %s", err)
+	}
+	if string(out) != `{"data":"aGk="}` {
+		t.Fatalf("wrong JSON: %s", out)
+	}
+	var back Bytes
+	err = json.Unmarshal([]byte(`"aGk="`), &back)
+	if err != nil || string(back.Value) != "hi" {
+		t.Fatalf("failed to unmarshal: %v %s", err, back.Inspect())
+	}
+	err = json.Unmarshal([]byte(`"!!"`), &back)
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+}
+
+// TestIterator tests our lazy Iterator-object.
+func TestIterator(t *testing.T) {
+
+	// generator which produces three values, counting how
+	// many times it has been invoked.
+	calls := 0
+	tmp := NewIterator(func() (Object, bool) {
+		calls++
+		if calls > 3 {
+			return nil, false
+		}
+		return &Integer{Value: int64(calls * 10)}, true
+	})
+
+	if tmp.Type() != ITERATOR {
+		t.Fatalf("Wrong type")
+	}
+	if tmp.Inspect() != "<iterator>" {
+		t.Fatalf("Wrong value")
+	}
+	if !tmp.True() {
+		t.Fatalf("iterators are always true")
+	}
+
+	// Nothing is produced until we ask for it.
+	if calls != 0 {
+		t.Fatalf("iterator wasn't lazy")
+	}
+
+	tmp.Reset()
+	for i := 0; i < 3; i++ {
+		obj, idx, more := tmp.Next()
+		if !more {
+			t.Fatalf("expected more items")
+		}
+		if idx.(*Integer).Value != int64(i) {
+			t.Fatalf("wrong index %s", idx.Inspect())
+		}
+		if obj.Inspect() != fmt.Sprintf("%d", (i+1)*10) {
+			t.Fatalf("wrong value %s", obj.Inspect())
+		}
+		if calls != i+1 {
+			t.Fatalf("iterator wasn't lazy")
+		}
+	}
+
+	// Exhausted - and our source isn't invoked again.
+	for i := 0; i < 2; i++ {
+		_, _, more := tmp.Next()
+		if more {
+			t.Fatalf("expected the iteration to be complete")
+		}
+	}
+	if calls != 4 {
+		t.Fatalf("source called after it was exhausted: %d", calls)
+	}
+
+	// An iterator without a source is empty.
+	empty := &Iterator{}
+	if _, _, more := empty.Next(); more {
+		t.Fatalf("expected an empty iterator")
+	}
+}
+
 // TestNull tests our Null-object in a basic way.
 func TestNull(t *testing.T) {
 
@@ -600,6 +873,13 @@ func TestStringJSON(t *testing.T) {
 	if bj != exp {
 		t.Fatalf("Invalid value for string->JSON, exp:%s\ngot:'%s'\n", exp, bj)
 	}
+
+	// Control-characters must be escaped in a JSON-compatible way.
+	c := &String{Value: "\x00<π>"}
+	cj, _ := c.JSON()
+	if cj != `"\u0000<π>"` {
+		t.Fatalf("Invalid value for string->JSON, got %s", cj)
+	}
 }
 
 // Test converting a hash to JSON
@@ -736,3 +1016,181 @@ func TestArrayJSON(t *testing.T) {
 	}
 
 }
+
+// Test that nested values are escaped correctly, and that our objects
+// may be used with encoding/json.
+func TestMarshalJSON(t *testing.T) {
+
+	key := &String{Value: "a\"b"}
+	hash := &Hash{Pairs: map[HashKey]HashPair{
+		key.HashKey(): {Key: key, Value: &Array{Elements: []Object{
+			&String{Value: "tab\there"},
+			&Regexp{Value: "^\\d+$"},
+			&Error{Message: "it \"failed\""},
+			&Null{},
+		}}},
+	}}
+
+	exp := `{"a\"b": ["tab\there", "^\\d+$", "it \"failed\"", null]}`
+	out, err := hash.JSON()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if out != exp {
+		t.Fatalf("wrong result for hash->JSON, got:%s exp:%s", out, exp)
+	}
+	if !json.Valid([]byte(out)) {
+		t.Fatalf("invalid JSON produced: %s", out)
+	}
+
+	// Objects embedded in a structure.
+	type wrapper struct {
+		Result Object `json:"result"`
+		Count  *Integer
+	}
+	dat, err := json.Marshal(wrapper{Result: hash, Count: &Integer{Value: 3}})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	exp = `{"result":{"a\"b":["tab\there","^\\d+$","it \"failed\"",null]},"Count":3}`
+	if string(dat) != exp {
+		t.Fatalf("wrong result for json.Marshal, got:%s exp:%s", dat, exp)
+	}
+
+	// Values which cannot be converted.
+	bad := []Object{
+		&Void{},
+		NewIterator(nil),
+		&Float{Value: math.NaN()},
+		&Float{Value: math.Inf(-1)},
+		&Array{Elements: []Object{&Float{Value: math.Inf(1)}}},
+	}
+	for _, obj := range bad {
+		_, err = MarshalJSON(obj)
+		if err == nil {
+			t.Fatalf("expected an error converting %s, got none", obj.Inspect())
+		}
+		_, err = json.Marshal([]Object{obj})
+		if err == nil {
+			t.Fatalf("expected an error marshalling %s, got none", obj.Inspect())
+		}
+	}
+}
+
+// Test constructing objects from JSON.
+func TestUnmarshalJSON(t *testing.T) {
+
+	tests := []struct {
+		input string
+		typ   Type
+		exp   string
+	}{
+		{`17`, INTEGER, "17"},
+		{`-3.5`, FLOAT, "-3.5"},
+		{`"a\"b"`, STRING, "a\"b"},
+		{`true`, BOOLEAN, "true"},
+		{`null`, NULL, "null"},
+		{`[1, "two", [3.5]]`, ARRAY, "[1, two, [3.5]]"},
+		{` {"b": 2, "a": {"c": null}} `, HASH, "{a: {c: null}, b: 2}"},
+	}
+
+	for _, test := range tests {
+		obj, err := UnmarshalJSON([]byte(test.input))
+		if err != nil {
+			t.Fatalf("unexpected error decoding %s: %s", test.input, err)
+		}
+		if obj.Type() != test.typ || obj.Inspect() != test.exp {
+			t.Fatalf("wrong result decoding %s, got %s %s", test.input, obj.Type(), obj.Inspect())
+		}
+	}
+
+	for _, input := range []string{``, `[1,`, `1 2`, `{"a": 1}}`} {
+		_, err := UnmarshalJSON([]byte(input))
+		if err == nil {
+			t.Fatalf("expected an error decoding '%s', got none", input)
+		}
+	}
+
+	// Decoding into our types, via encoding/json.
+	var in struct {
+		Count   *Integer
+		Ratio   Float
+		Name    String
+		Pattern Regexp
+		Failure Error
+		Tags    Array
+		Meta    *Hash
+		Missing *Boolean
+		Nothing Null
+	}
+	err := json.Unmarshal([]byte(`{"Count": 3, "Ratio": 2, "Name": "steve", "Pattern": "^[a-z]+$", "Failure": "oops", "Tags": ["x", 1], "Meta": {"k": [true]}, "Missing": null, "Nothing": null}`), &in)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	got := fmt.Sprintf("%d %s %s %s %s %s %s %v", in.Count.Value, in.Ratio.Inspect(), in.Name.Value, in.Pattern.Value, in.Failure.Message, in.Tags.Inspect(), in.Meta.Inspect(), in.Missing)
+	exp := "3 2 steve ^[a-z]+$ oops [x, 1] {k: [true]} <nil>"
+	if got != exp {
+		t.Fatalf("wrong result, got '%s' expected '%s'", got, exp)
+	}
+
+	// Type mismatches are errors.
+	bad := []struct {
+		input string
+		dst   interface{}
+	}{
+		{`"steve"`, &Integer{}},
+		{`1.5`, &Integer{}},
+		{`1`, &String{}},
+		{`[1]`, &Hash{}},
+		{`{}`, &Array{}},
+		{`1`, &Null{}},
+		{`"x"`, &Boolean{}},
+	}
+	for _, test := range bad {
+		err := json.Unmarshal([]byte(test.input), test.dst)
+		if err == nil {
+			t.Fatalf("expected an error decoding %s into %T, got none", test.input, test.dst)
+		}
+	}
+
+	// null leaves the destination alone.
+	val := &Integer{Value: 7}
+	err = json.Unmarshal([]byte(`null`), val)
+	if err != nil || val.Value != 7 {
+		t.Fatalf("null changed an integer, got %d %v", val.Value, err)
+	}
+
+	// Values survive a round-trip.
+	arr := &Array{Elements: []Object{&String{Value: "π\n"}, &Integer{Value: -4}, &Boolean{Value: false}}}
+	dat, err := json.Marshal(arr)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var out Array
+	err = json.Unmarshal(dat, &out)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if out.Inspect() != arr.Inspect() {
+		t.Fatalf("round-trip failed, got %s expected %s", out.Inspect(), arr.Inspect())
+	}
+}
+
+// TestInterned tests that booleans, and small integers, are shared.
+func TestInterned(t *testing.T) {
+
+	if Bool(true) != True || Bool(false) != False || True.Value != true || False.Value {
+		t.Fatalf("booleans weren't shared")
+	}
+
+	for _, val := range []int64{-128, -1, 0, 1, 1024} {
+		if Int(val) != Int(val) || Int(val).Value != val {
+			t.Fatalf("%d wasn't shared", val)
+		}
+	}
+	for _, val := range []int64{-129, 1025, 1 << 40} {
+		if Int(val) == Int(val) || Int(val).Value != val {
+			t.Fatalf("%d was shared", val)
+		}
+	}
+}
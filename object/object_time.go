@@ -0,0 +1,96 @@
+package object
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"time"
+)
+
+// Time wraps time.Time and implements the Object interface.
+type Time struct {
+	// Value holds the time this object wraps.
+	Value time.Time
+}
+
+// Inspect returns a string-representation of the given object, which is
+// the time in RFC 3339 format.
+func (t *Time) Inspect() string {
+	return t.Value.Format(time.RFC3339Nano)
+}
+
+// Type returns the type of this object.
+func (t *Time) Type() Type {
+	return TIME
+}
+
+// True returns whether this object wraps a true-like value.
+//
+// Used when this object is the conditional in a comparison, etc.  Every
+// time is true, except the zero time.
+func (t *Time) True() bool {
+	return !t.Value.IsZero()
+}
+
+// ToInterface converts this object to a go-interface, which will allow
+// it to be used naturally in our sprintf/printf primitives.
+//
+// It might also be helpful for embedded users.
+func (t *Time) ToInterface() interface{} {
+	return t.Value
+}
+
+// Seconds returns the time as the number of seconds past the epoch, as
+// an integer if the time has no fraction of a second, otherwise a float.
+//
+// Times are compared with numbers via these seconds.
+func (t *Time) Seconds() Object {
+	if t.Value.Nanosecond() == 0 {
+		return Int(t.Value.Unix())
+	}
+	return &Float{Value: float64(t.Value.UnixNano()) / float64(time.Second)}
+}
+
+// HashKey returns a hash key for the given object.
+//
+// Equal times have the same key, whatever their location.
+func (t *Time) HashKey() HashKey {
+	h := fnv.New64a()
+	h.Write([]byte(t.Value.UTC().Format(time.RFC3339Nano)))
+	return HashKey{Type: t.Type(), Value: h.Sum64()}
+}
+
+// JSON converts this object to a JSON string, which holds the time in
+// RFC 3339 format as time.Time does.
+func (t *Time) JSON() (string, error) {
+	return quoteJSON(t.Inspect()), nil
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (t *Time) MarshalJSON() ([]byte, error) {
+	return MarshalJSON(t)
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+//
+// The time must be a string in RFC 3339 format.
+func (t *Time) UnmarshalJSON(data []byte) error {
+	obj, err := unmarshalAs(data, STRING)
+	if err != nil {
+		return err
+	}
+	if obj == nil {
+		return nil
+	}
+	val, err := time.Parse(time.RFC3339Nano, obj.(*String).Value)
+	if err != nil {
+		return err
+	}
+	t.Value = val
+	return nil
+}
+
+// Ensure this object implements the expected interfaces.
+var _ Hashable = &Time{}
+var _ JSONAble = &Time{}
+var _ json.Marshaler = &Time{}
+var _ json.Unmarshaler = &Time{}
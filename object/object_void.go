@@ -32,3 +32,10 @@ func (v *Void) True() bool {
 func (v *Void) ToInterface() interface{} {
 	return nil
 }
+
+// MarshalJSON implements the json.Marshaler interface.
+//
+// Void values cannot be exported, so this always returns an error.
+func (v *Void) MarshalJSON() ([]byte, error) {
+	return MarshalJSON(v)
+}
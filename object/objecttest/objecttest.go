@@ -0,0 +1,255 @@
+// Package objecttest provides helpers for property-based testing of
+// objects, and of scripts.
+//
+// A Generator produces random object-graphs, and random scripts, while
+// Check tests the invariants which every object should satisfy.  The
+// Value type allows random objects to be used with `testing/quick`:
+//
+//	err := quick.Check(func(v objecttest.Value) bool {
+//	    return objecttest.Check(v.Object) == nil
+//	}, nil)
+//
+// If you've implemented your own object-types you may add them to the
+// generator, via the Custom field, to test them in the same way.
+package objecttest
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/skx/evalfilter/v2/object"
+)
+
+// Generator produces random objects, and scripts.
+//
+// The zero value is usable, and produces small objects.
+type Generator struct {
+
+	// MaxDepth limits the nesting of arrays and hashes.
+	MaxDepth int
+
+	// MaxLen limits the number of elements within arrays and hashes,
+	// as well as the length of strings.
+	MaxLen int
+
+	// Custom holds functions which produce additional object-types,
+	// each of which is chosen as often as each of the built-in types.
+	Custom []func(r *rand.Rand) object.Object
+}
+
+// limits returns the depth and length limits, applying the defaults.
+func (g Generator) limits() (int, int) {
+	depth, length := g.MaxDepth, g.MaxLen
+	if depth <= 0 {
+		depth = 3
+	}
+	if length <= 0 {
+		length = 5
+	}
+	return depth, length
+}
+
+// Object returns a random object.
+func (g Generator) Object(r *rand.Rand) object.Object {
+	depth, _ := g.limits()
+	return g.object(r, depth)
+}
+
+// object returns a random object, nested no more than depth deep.
+func (g Generator) object(r *rand.Rand, depth int) object.Object {
+
+	_, length := g.limits()
+
+	kinds := 6
+	if depth > 0 {
+		kinds += 2
+	}
+
+	n := r.Intn(kinds + len(g.Custom))
+	if n >= kinds {
+		return g.Custom[n-kinds](r)
+	}
+
+	switch n {
+	case 0:
+		return &object.Null{}
+	case 1:
+		return &object.Boolean{Value: r.Intn(2) == 0}
+	case 2:
+		return &object.Integer{Value: r.Int63n(2000) - 1000}
+	case 3:
+		return &object.Float{Value: float64(r.Int63n(200000)-100000) / 100}
+	case 4, 5:
+		return &object.String{Value: randomString(r, length)}
+	case 6:
+		elements := make([]object.Object, r.Intn(length+1))
+		for i := range elements {
+			elements[i] = g.object(r, depth-1)
+		}
+		return &object.Array{Elements: elements}
+	}
+
+	pairs := make(map[object.HashKey]object.HashPair)
+	for i := r.Intn(length + 1); i > 0; i-- {
+		key := &object.String{Value: randomString(r, length)}
+		pairs[key.HashKey()] = object.HashPair{Key: key, Value: g.object(r, depth-1)}
+	}
+	return &object.Hash{Pairs: pairs}
+}
+
+// randomString returns a random string, which will frequently contain
+// characters which need escaping.
+func randomString(r *rand.Rand, length int) string {
+
+	chars := []rune("abcXYZ019 _-\"\\/\n\t\x00\x1fπ☃")
+
+	out := make([]rune, r.Intn(length+1))
+	for i := range out {
+		out[i] = chars[r.Intn(len(chars))]
+	}
+	return string(out)
+}
+
+// Script returns a random script, consisting of some assignments, and
+// conditionals, followed by a return-statement.
+//
+// The expressions use literals of our basic types, and the usual
+// operators, so the scripts are ideal for testing the compiler and the
+// optimizer.  Scripts might fail at run-time, for example due to a
+// type-mismatch or division by zero.
+func (g Generator) Script(r *rand.Rand) string {
+
+	depth, _ := g.limits()
+
+	var out strings.Builder
+	vars := 0
+
+	for i := r.Intn(4); i > 0; i-- {
+		switch r.Intn(3) {
+		case 0, 1:
+			fmt.Fprintf(&out, "v%d = %s;\n", vars, g.expression(r, depth, vars))
+			vars++
+		case 2:
+			fmt.Fprintf(&out, "if ( %s ) { return %s; }\n", g.expression(r, depth, vars), g.expression(r, depth, vars))
+		}
+	}
+	fmt.Fprintf(&out, "return %s;\n", g.expression(r, depth, vars))
+
+	return out.String()
+}
+
+// expression returns a random expression, nested no more than depth
+// deep, which may refer to the given number of variables.
+func (g Generator) expression(r *rand.Rand, depth int, vars int) string {
+
+	if depth <= 0 || r.Intn(3) == 0 {
+		n := r.Intn(6)
+		switch {
+		case n == 0 && vars > 0:
+			return fmt.Sprintf("v%d", r.Intn(vars))
+		case n == 1:
+			return strconv.Quote(strings.Repeat("ab", r.Intn(3)))
+		case n == 2:
+			return strconv.FormatBool(r.Intn(2) == 0)
+		case n == 3:
+			return fmt.Sprintf("%d.%d", r.Intn(10), r.Intn(10))
+		}
+		return strconv.Itoa(r.Intn(20))
+	}
+
+	switch r.Intn(4) {
+	case 0:
+		return fmt.Sprintf("( %s ? %s : %s )", g.expression(r, depth-1, vars), g.expression(r, depth-1, vars), g.expression(r, depth-1, vars))
+	case 1:
+		return fmt.Sprintf("!%s", g.expression(r, depth-1, vars))
+	}
+
+	ops := []string{"+", "-", "*", "/", "%", "<", "<=", ">", ">=", "==", "!=", "&&", "||"}
+	op := ops[r.Intn(len(ops))]
+	return fmt.Sprintf("( %s %s %s )", g.expression(r, depth-1, vars), op, g.expression(r, depth-1, vars))
+}
+
+// Value wraps a random object, and implements the quick.Generator
+// interface, so that it may be used with `testing/quick`.
+type Value struct {
+	object.Object
+}
+
+// Generate returns a random Value, using a Generator whose limits are
+// derived from the given size.
+func (Value) Generate(r *rand.Rand, size int) reflect.Value {
+	g := Generator{MaxDepth: 1 + size/20, MaxLen: 1 + size/10}
+	return reflect.ValueOf(Value{Object: g.Object(r)})
+}
+
+// Check tests the invariants which every object should satisfy, and
+// returns an error describing the first which does not hold.
+//
+// * The results of Type, Inspect, and True are stable.
+// * If the object is Hashable its HashKey is stable, and has the type of the object.
+// * If the object is JSONAble, and can be converted, the JSON is valid.
+// * Decoding, and re-encoding, that JSON produces the same JSON.
+// * If the decoded object is equal to the original its HashKey is the same.
+func Check(obj object.Object) error {
+
+	if obj.Type() == "" {
+		return fmt.Errorf("%s has an empty type", obj.Inspect())
+	}
+	if obj.Type() != obj.Type() || obj.Inspect() != obj.Inspect() || obj.True() != obj.True() {
+		return fmt.Errorf("%s '%s' is not stable", obj.Type(), obj.Inspect())
+	}
+
+	// Hash-keys must be stable.
+	if h, ok := obj.(object.Hashable); ok {
+		a, b := h.HashKey(), h.HashKey()
+		if a != b {
+			return fmt.Errorf("%s '%s' has an unstable hash-key", obj.Type(), obj.Inspect())
+		}
+		if a.Type != obj.Type() {
+			return fmt.Errorf("%s '%s' has a hash-key of type %s", obj.Type(), obj.Inspect(), a.Type)
+		}
+	}
+
+	helper, ok := obj.(object.JSONAble)
+	if !ok {
+		return nil
+	}
+
+	// Not all objects can be converted to JSON, which is fine.
+	js, err := helper.JSON()
+	if err != nil {
+		return nil
+	}
+	if !json.Valid([]byte(js)) {
+		return fmt.Errorf("%s '%s' produced invalid JSON: %s", obj.Type(), obj.Inspect(), js)
+	}
+
+	// Decode, and re-encode.
+	decoded, err := object.UnmarshalJSON([]byte(js))
+	if err != nil {
+		return fmt.Errorf("%s '%s' produced JSON which could not be decoded: %s", obj.Type(), obj.Inspect(), err)
+	}
+
+	again, err := decoded.(object.JSONAble).JSON()
+	if err != nil {
+		return fmt.Errorf("%s '%s' could not be re-encoded: %s", obj.Type(), obj.Inspect(), err)
+	}
+	if again != js {
+		return fmt.Errorf("%s '%s' did not round-trip via JSON: %s != %s", obj.Type(), obj.Inspect(), js, again)
+	}
+
+	// Equal values must have equal hash-keys.
+	a, ok1 := obj.(object.Hashable)
+	b, ok2 := decoded.(object.Hashable)
+	if ok1 && ok2 && obj.Type() == decoded.Type() && obj.Inspect() == decoded.Inspect() {
+		if a.HashKey() != b.HashKey() {
+			return fmt.Errorf("%s '%s' has a different hash-key to an equal value", obj.Type(), obj.Inspect())
+		}
+	}
+
+	return nil
+}
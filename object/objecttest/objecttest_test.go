@@ -0,0 +1,76 @@
+package objecttest
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+	"testing/quick"
+
+	"github.com/skx/evalfilter/v2/object"
+)
+
+// TestObjects checks the invariants of random objects.
+func TestObjects(t *testing.T) {
+
+	err := quick.Check(func(v Value) bool {
+		err := Check(v.Object)
+		if err != nil {
+			t.Log(err)
+		}
+		return err == nil
+	}, &quick.Config{MaxCount: 2000})
+
+	if err != nil {
+		t.Fatalf("invariant failed: %s", err)
+	}
+}
+
+// broken is an object with an unstable Inspect method.
+type broken struct {
+	object.Null
+	count int
+}
+
+func (b *broken) Inspect() string {
+	b.count++
+	return strings.Repeat("x", b.count)
+}
+
+// TestCustom tests the use of custom objects.
+func TestCustom(t *testing.T) {
+
+	custom := func(r *rand.Rand) object.Object {
+		return &broken{}
+	}
+
+	g := Generator{Custom: []func(r *rand.Rand) object.Object{custom}}
+	r := rand.New(rand.NewSource(1))
+
+	found := false
+	for i := 0; i < 100; i++ {
+		obj := g.Object(r)
+		if _, ok := obj.(*broken); ok {
+			found = true
+			if Check(obj) == nil {
+				t.Fatalf("expected an unstable object to fail")
+			}
+		}
+	}
+	if !found {
+		t.Fatalf("custom generator was never used")
+	}
+}
+
+// TestScript tests that we generate scripts.
+func TestScript(t *testing.T) {
+
+	r := rand.New(rand.NewSource(1))
+	g := Generator{}
+
+	for i := 0; i < 100; i++ {
+		s := g.Script(r)
+		if !strings.Contains(s, "return ") {
+			t.Fatalf("script has no return-statement: %s", s)
+		}
+	}
+}
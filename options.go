@@ -0,0 +1,109 @@
+// This file contains the options which may be given when a script is
+// prepared.
+//
+// Prepare accepts flags, as bytes, which can only switch things on or
+// off.  PrepareWithOptions accepts a structure instead, so that options
+// which need a value may be given too:
+//
+//	err := eval.PrepareWithOptions(evalfilter.PrepareOptions{
+//		Optimization:    evalfilter.OptimizeNone,
+//		Strict:          true,
+//		MaxInstructions: 100000,
+//		MaxNesting:      32,
+//	})
+//
+// The zero value of each option gives the default behaviour, so that
+// `PrepareWithOptions(PrepareOptions{})` is the same as `Prepare()`.
+
+package evalfilter
+
+import (
+	"fmt"
+
+	"github.com/skx/evalfilter/v2/code"
+)
+
+// OptimizationLevel controls how much work the optimizer does.
+type OptimizationLevel int
+
+const (
+	// OptimizeDefault runs the optimizer, which is the default.
+	OptimizeDefault OptimizationLevel = iota
+
+	// OptimizeNone doesn't run the optimizer, which is the same as
+	// the NoOptimize flag.
+	OptimizeNone
+
+	// OptimizeReorder runs the optimizer, which also reorders the
+	// clauses of `&&` chains, which is the same as the ReorderClauses
+	// flag.
+	OptimizeReorder
+)
+
+// PrepareOptions holds the options which may be given to
+// PrepareWithOptions.
+type PrepareOptions struct {
+
+	// Optimization sets how much work the optimizer does.
+	Optimization OptimizationLevel
+
+	// Strict causes the warnings generated by the optimizer, such
+	// as conditions which are always false, and those about calls
+	// to deprecated functions, to be treated as errors.
+	Strict bool
+
+	// MaxInstructions limits the number of instructions which may
+	// be executed during each run, as SetMaxInstructions does.  Zero
+	// leaves the limit as it was.
+	MaxInstructions int
+
+	// MaxNesting limits how deeply brackets, hashes, and blocks may
+	// be nested within the script.  Zero means there is no limit.
+	MaxNesting int
+
+	// Language sets the version of the language the script is
+	// compiled for, unless it declares its own, as SetLanguage does.
+	// Zero leaves the version as it was.
+	Language int
+}
+
+// options converts the flags given to Prepare.
+func options(flags [][]byte) PrepareOptions {
+	opts := PrepareOptions{}
+	for _, arg := range flags {
+		for _, val := range arg {
+			if val == NoOptimize {
+				opts.Optimization = OptimizeNone
+			}
+			if val == ReorderClauses && opts.Optimization != OptimizeNone {
+				opts.Optimization = OptimizeReorder
+			}
+		}
+	}
+	return opts
+}
+
+// PrepareWithOptions compiles the user-supplied program, as Prepare
+// does, with the given options.
+func (e *Eval) PrepareWithOptions(opts PrepareOptions) error {
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return e.prepare(opts)
+}
+
+// strict returns an error for the first warning generated while the
+// script was compiled, if there is one.
+func (e *Eval) strict() error {
+	if len(e.warnings) == 0 {
+		return nil
+	}
+	tok := e.warned[0]
+	return &CompileError{
+		Phase:    "compile",
+		Position: code.Position{Line: tok.Line, Column: tok.Column},
+		Token:    tok,
+		Message:  fmt.Sprintf("strict mode: %s", e.warnings[0]),
+	}
+}
@@ -73,6 +73,8 @@ var precedences = map[token.Type]int{
 	token.LPAREN:         CALL,
 	token.LSQUARE:        INDEX,
 	token.PERIOD:         INDEX,
+	token.PLUSPLUS:       INDEX,
+	token.MINUSMINUS:     INDEX,
 }
 
 // Parser is the object which maintains our parser state.
@@ -97,6 +99,38 @@ type Parser struct {
 	// errors holds parsing-errors.
 	errors []string
 
+	// tokens holds the token we were looking at when each of
+	// our errors was raised.
+	tokens []token.Token
+
+	// recovered holds the number of errors which have been kept
+	// by our recovery from broken statements.
+	recovered int
+
+	// pending holds the token which follows a semicolon we've
+	// inserted, if there is one.
+	pending *token.Token
+
+	// last holds the last token we read.
+	last token.Token
+
+	// nesting holds the tokens which opened the brackets, hashes,
+	// and blocks we're within.
+	nesting []token.Type
+
+	// closed holds the token which opened the brackets, hash, or
+	// block which was closed most recently.
+	closed token.Type
+
+	// starts holds the tokens which began the most recent statements.
+	starts []token.Token
+
+	// maxNesting holds the deepest nesting of brackets, hashes, and
+	// blocks we allow, or zero for no limit - and deep is true once
+	// it has been exceeded.
+	maxNesting int
+	deep       bool
+
 	// prefixParseFns holds a map of parsing methods for
 	// prefix-based syntax.
 	prefixParseFns map[token.Type]prefixParseFn
@@ -117,6 +151,10 @@ type Parser struct {
 
 	// Are we inside a function?
 	function bool
+
+	// loops holds the number of loops we're inside, within the
+	// current function.
+	loops int
 }
 
 // New returns a new parser.
@@ -170,12 +208,14 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.LTEQUALS, p.parseInfixExpression)
 	p.registerInfix(token.MINUS, p.parseInfixExpression)
 	p.registerInfix(token.MINUSEQUALS, p.parseInfixExpression)
+	p.registerInfix(token.MINUSMINUS, p.parsePostfixOperator)
 	p.registerInfix(token.MISSING, p.parseInfixExpression)
 	p.registerInfix(token.MOD, p.parseInfixExpression)
 	p.registerInfix(token.NOTEQ, p.parseInfixExpression)
 	p.registerInfix(token.OR, p.parseInfixExpression)
 	p.registerInfix(token.PLUS, p.parseInfixExpression)
 	p.registerInfix(token.PLUSEQUALS, p.parseInfixExpression)
+	p.registerInfix(token.PLUSPLUS, p.parsePostfixOperator)
 	p.registerInfix(token.POW, p.parseInfixExpression)
 	p.registerInfix(token.QUESTION, p.parseTernaryExpression)
 	p.registerInfix(token.SLASH, p.parseInfixExpression)
@@ -208,17 +248,52 @@ func (p *Parser) Errors() []string {
 	return p.errors
 }
 
+// error records a parsing-error, at the current token, along with any
+// suggestion we have for fixing it.
+func (p *Parser) error(msg string) {
+	if hint := p.suggest(msg); hint != "" {
+		msg += " - " + hint
+	}
+	p.errors = append(p.errors, msg)
+	p.tokens = append(p.tokens, p.curToken)
+}
+
 // peekError raises an error if the next token is not the expected type.
 func (p *Parser) peekError(t token.Type) {
 	msg := fmt.Sprintf("expected next token to be %s, got %s instead around %s", t, p.curToken.Type, p.curToken.Position())
-	p.errors = append(p.errors, msg)
+	p.error(msg)
 }
 
 // nextToken moves to our next token from the lexer.
 func (p *Parser) nextToken() {
 	p.prevToken = p.curToken
 	p.curToken = p.peekToken
-	p.peekToken = p.l.NextToken()
+	p.peekToken = p.readToken()
+}
+
+// Error is the error returned by Parse, which describes the first
+// problem which was found along with the token it was found at.
+type Error struct {
+
+	// Message holds the description of the problem.
+	Message string
+
+	// Token holds the token we were looking at when the problem
+	// was found.
+	Token token.Token
+}
+
+// Error returns the description of the problem.
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// SetMaxNesting limits how deeply brackets, hashes, and blocks, may be
+// nested within the script, so that a hostile script can't exhaust the
+// stack of the parser, or the compiler.  A limit of zero, the default,
+// disables the check.
+func (p *Parser) SetMaxNesting(limit int) {
+	p.maxNesting = limit
 }
 
 // Parse is the main public-facing method to parse an input program.
@@ -238,42 +313,99 @@ func (p *Parser) Parse() (*ast.Program, error) {
 	}
 
 	// Only the first error matters.
-	return a, fmt.Errorf("%s", p.Errors()[0])
+	return a, &Error{Message: p.errors[0], Token: p.tokens[0]}
 }
 
 // ParseProgram used to parse the whole program
+//
+// A statement which can't be parsed doesn't stop us, instead we skip
+// past it and carry on, so that all the errors in the script may be
+// reported at once.  The program holds the statements which could be
+// parsed.
 func (p *Parser) ParseProgram() *ast.Program {
 	program := &ast.Program{}
 	program.Statements = []ast.Statement{}
 	for p.curToken.Type != token.EOF && p.curToken.Type != token.ILLEGAL {
+		seen := len(p.errors)
 		stmt := p.parseStatement()
 		if stmt == nil {
 			msg := fmt.Sprintf("unexpected nil statement around %s", p.curToken.Position())
-			p.errors = append(p.errors, msg)
-			return nil
+			p.error(msg)
+		}
+		if len(p.errors) > seen {
+			p.synchronize(seen)
+
+			// We're no longer inside anything.
+			p.function = false
+			p.loops = 0
+			p.tern = false
+
+			if p.curToken.Type == token.EOF || p.curToken.Type == token.ILLEGAL {
+				break
+			}
+			p.nextToken()
+
+			// The closing braces of a block we skipped into
+			// are part of the broken statement too.
+			for p.curTokenIs(token.RBRACE) {
+				p.nextToken()
+			}
+			continue
 		}
 		program.Statements = append(program.Statements, stmt)
 		p.nextToken()
 	}
 
 	if p.curToken.Type == token.ILLEGAL {
-		p.errors = append(p.errors, p.curToken.Literal)
+		p.error(p.curToken.Literal)
 	}
 	return program
 }
 
+// synchronize recovers from a broken statement, which raised the errors
+// after the given number.
+//
+// The first of those errors is kept, as the others are usually caused by
+// it, and we skip tokens until we reach the `;` or `}` which ends the
+// statement, or block.
+func (p *Parser) synchronize(seen int) {
+
+	// Errors recorded by the recovery of a nested statement are
+	// kept, since they're genuine.
+	keep := seen + 1
+	if p.recovered > keep {
+		keep = p.recovered
+	}
+	if keep < len(p.errors) {
+		p.errors = p.errors[:keep]
+		p.tokens = p.tokens[:keep]
+	}
+	p.recovered = len(p.errors)
+
+	for p.curToken.Type != token.SEMICOLON &&
+		p.curToken.Type != token.RBRACE &&
+		p.curToken.Type != token.EOF &&
+		p.curToken.Type != token.ILLEGAL {
+		p.nextToken()
+	}
+}
+
 // parseStatement parses a single statement.
 func (p *Parser) parseStatement() ast.Statement {
+	p.started(p.curToken)
 	switch p.curToken.Type {
 	case token.RETURN:
 		r := p.parseReturnStatement()
 		if r == nil {
 			msg := fmt.Sprintf("unexpected nil statement around %s", p.curToken.Position())
-			p.errors = append(p.errors, msg)
+			p.error(msg)
 			return nil
 		}
 		return r
 
+	case token.BREAK, token.CONTINUE:
+		return p.parseLoopControl()
+
 	default:
 		return p.parseExpressionStatement()
 	}
@@ -286,7 +418,7 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	stmt.ReturnValue = p.parseExpression(LOWEST)
 	p.nextToken()
 	if p.curToken.Type != token.SEMICOLON {
-		p.errors = append(p.errors, fmt.Sprintf("expected semicolon after return-value; found token '%v'", p.curToken))
+		p.error(fmt.Sprintf("expected semicolon after return-value; found token '%v'", p.curToken))
 		stmt.ReturnValue = nil
 		return nil
 	}
@@ -294,11 +426,33 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	return stmt
 }
 
+// parseLoopControl parses a break, or continue, statement.
+func (p *Parser) parseLoopControl() ast.Statement {
+	tok := p.curToken
+
+	if p.loops == 0 {
+		msg := fmt.Sprintf("'%s' may only be used inside a loop, around %s", tok.Literal, tok.Position())
+		p.error(msg)
+		return nil
+	}
+
+	p.nextToken()
+	if p.curToken.Type != token.SEMICOLON {
+		p.error(fmt.Sprintf("expected semicolon after %s; found token '%v'", tok.Literal, p.curToken))
+		return nil
+	}
+
+	if tok.Type == token.BREAK {
+		return &ast.BreakStatement{Token: tok}
+	}
+	return &ast.ContinueStatement{Token: tok}
+}
+
 // Function called on error if there is no prefix-based parsing method
 // for the given token.
 func (p *Parser) noPrefixParseFnError(t token.Type) {
 	msg := fmt.Sprintf("no prefix parse function for %s found around %s", t, p.curToken.Position())
-	p.errors = append(p.errors, msg)
+	p.error(msg)
 }
 
 // parse Expression Statement
@@ -313,6 +467,13 @@ func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 
 // parse an expression.
 func (p *Parser) parseExpression(precedence int) ast.Expression {
+	if p.maxNesting > 0 && len(p.nesting) > p.maxNesting {
+		if !p.deep {
+			p.deep = true
+			p.error(fmt.Sprintf("the script is nested more than %d levels deep around %s", p.maxNesting, p.curToken.Position()))
+		}
+		return nil
+	}
 	postfix := p.postfixParseFns[p.curToken.Type]
 	if postfix != nil {
 		return (postfix())
@@ -321,7 +482,7 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 	if prefix == nil {
 		p.noPrefixParseFnError(p.curToken.Type)
 		msg := fmt.Sprintf("invalid token '%s' around %s", p.curToken.Literal, p.curToken.Position())
-		p.errors = append(p.errors, msg)
+		p.error(msg)
 		return nil
 	}
 	leftExp := prefix()
@@ -329,15 +490,23 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 	// Look for errors
 	if leftExp == nil {
 		msg := fmt.Sprintf("unexpected nil expression around %s", p.curToken.Position())
-		p.errors = append(p.errors, msg)
+		p.error(msg)
 		return nil
 	}
 
 	for !p.peekTokenIs(token.SEMICOLON) && precedence < p.peekPrecedence() {
+
+		// A statement which ends with a block can't be indexed,
+		// so a following `[` begins a new statement - such as
+		// `[a, b] = ..`.
+		if p.peekTokenIs(token.LSQUARE) && endsWithBlock(leftExp) {
+			return leftExp
+		}
+
 		infix := p.infixParseFns[p.peekToken.Type]
 		if infix == nil {
 			msg := fmt.Sprintf("unexpected nil expression around %s", p.curToken.Position())
-			p.errors = append(p.errors, msg)
+			p.error(msg)
 			return leftExp
 		}
 		p.nextToken()
@@ -346,7 +515,7 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 		// Look for errors
 		if leftExp == nil {
 			msg := fmt.Sprintf("unexpected nil expression around %s", p.curToken.Position())
-			p.errors = append(p.errors, msg)
+			p.error(msg)
 			return nil
 		}
 	}
@@ -358,27 +527,45 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 // This is generally seen with an unterminated string.
 func (p *Parser) parseIllegal() ast.Expression {
 	msg := fmt.Sprintf("illegal token hit parsing program %s around %s", p.curToken.Literal, p.curToken.Position())
-	p.errors = append(p.errors, msg)
+	p.error(msg)
 	return nil
 }
 
 // report an error if we hit an unexpected end of file.
 func (p *Parser) parseEOF() ast.Expression {
-	p.errors = append(p.errors, "unexpected end of file reached")
+	p.error("unexpected end of file reached")
 	return nil
 }
 
 // parseIdentifier parses an identifier.
 func (p *Parser) parseIdentifier() ast.Expression {
+
+	// `match` is not a keyword, because it is also the name of our
+	// regular-expression function.  We only treat it as the start
+	// of a match expression when it is followed by a value.
+	if p.curToken.Literal == "match" && p.peekTokenStartsValue() {
+		return p.parseMatchExpression()
+	}
+
 	return &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 }
 
+// peekTokenStartsValue returns true if the next token can begin the
+// value a match expression operates upon.
+func (p *Parser) peekTokenStartsValue() bool {
+	switch p.peekToken.Type {
+	case token.IDENT, token.STRING, token.INT, token.FLOAT, token.TRUE, token.FALSE, token.LSQUARE:
+		return true
+	}
+	return false
+}
+
 // parseLocal parses something like "local x;"
 func (p *Parser) parseLocalVariable() ast.Expression {
 
 	if !p.function {
 		msg := fmt.Sprintf("'local' may only be used inside a function, around %s", p.curToken.Position())
-		p.errors = append(p.errors, msg)
+		p.error(msg)
 		return nil
 	}
 
@@ -388,7 +575,7 @@ func (p *Parser) parseLocalVariable() ast.Expression {
 	// Ensure we got an ident.
 	if !p.curTokenIs(token.IDENT) {
 		msg := fmt.Sprintf("'local' may only be used with an IDENT, around %s", p.curToken.Position())
-		p.errors = append(p.errors, msg)
+		p.error(msg)
 		return nil
 	}
 
@@ -403,7 +590,7 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 	value, err := strconv.ParseInt(p.curToken.Literal, 10, 64)
 	if err != nil {
 		msg := fmt.Sprintf("could not parse %q as integer around %s", p.curToken.Literal, p.curToken.Position())
-		p.errors = append(p.errors, msg)
+		p.error(msg)
 		return nil
 	}
 	lit.Value = value
@@ -416,7 +603,7 @@ func (p *Parser) parseFloatLiteral() ast.Expression {
 	value, err := strconv.ParseFloat(p.curToken.Literal, 64)
 	if err != nil {
 		msg := fmt.Sprintf("could not parse %q as float around %s", p.curToken.Literal, p.curToken.Position())
-		p.errors = append(p.errors, msg)
+		p.error(msg)
 		return nil
 	}
 	flo.Value = value
@@ -431,7 +618,7 @@ func (p *Parser) parseBracketExpression() ast.Expression {
 	// look for (
 	if !p.expectPeek(token.LPAREN) {
 		msg := fmt.Sprintf("expected ( but got %s around %s", p.curToken.Literal, p.curToken.Position())
-		p.errors = append(p.errors, msg)
+		p.error(msg)
 		return nil
 	}
 	p.nextToken()
@@ -445,7 +632,7 @@ func (p *Parser) parseBracketExpression() ast.Expression {
 	// look for )
 	if !p.expectPeek(token.RPAREN) {
 		msg := fmt.Sprintf("expected ) but got %s around %s", p.curToken.Literal, p.curToken.Position())
-		p.errors = append(p.errors, msg)
+		p.error(msg)
 		return nil
 	}
 
@@ -475,7 +662,7 @@ func (p *Parser) parseSwitchStatement() ast.Expression {
 	for !p.curTokenIs(token.RBRACE) {
 
 		if p.curTokenIs(token.EOF) {
-			p.errors = append(p.errors, "unterminated switch statement")
+			p.error("unterminated switch statement")
 			return nil
 		}
 		tmp := &ast.CaseExpression{Token: p.curToken}
@@ -513,14 +700,14 @@ func (p *Parser) parseSwitchStatement() ast.Expression {
 			}
 		} else {
 			// error - unexpected token
-			p.errors = append(p.errors, fmt.Sprintf("expected case|default, got %s around position %s", p.curToken.Type, p.curToken.Position()))
+			p.error(fmt.Sprintf("expected case|default, got %s around position %s", p.curToken.Type, p.curToken.Position()))
 			return nil
 		}
 
 		if !p.expectPeek(token.LBRACE) {
 
 			msg := fmt.Sprintf("expected token to be '{', got %s instead", p.curToken.Type)
-			p.errors = append(p.errors, msg)
+			p.error(msg)
 			fmt.Printf("error\n")
 			return nil
 		}
@@ -530,7 +717,7 @@ func (p *Parser) parseSwitchStatement() ast.Expression {
 
 		if !p.curTokenIs(token.RBRACE) {
 			msg := fmt.Sprintf("Syntax Error: expected token to be '}', got %s instead", p.curToken.Type)
-			p.errors = append(p.errors, msg)
+			p.error(msg)
 			fmt.Printf("error\n")
 			return nil
 
@@ -551,7 +738,7 @@ func (p *Parser) parseSwitchStatement() ast.Expression {
 	}
 	if count > 1 {
 		msg := "A switch-statement should only have one default block"
-		p.errors = append(p.errors, msg)
+		p.error(msg)
 		return nil
 
 	}
@@ -559,6 +746,173 @@ func (p *Parser) parseSwitchStatement() ast.Expression {
 
 }
 
+// parseMatchExpression handles a match expression
+//
+//	match value {
+//	   { "type": "login", "fail": true } => { .. }
+//	   "literal" => statement;
+//	   _ => { .. }
+//	}
+//
+// Arms may be separated by commas, and the body of each arm is either
+// a block or a single statement.
+func (p *Parser) parseMatchExpression() ast.Expression {
+
+	expression := &ast.MatchExpression{Token: p.curToken}
+
+	// Skip "match" and look for the value.
+	p.nextToken()
+	expression.Value = p.parseExpression(LOWEST)
+	if expression.Value == nil {
+		return nil
+	}
+
+	// Now we have a block containing our arms.
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	p.nextToken()
+
+	for !p.curTokenIs(token.RBRACE) {
+
+		if p.curTokenIs(token.EOF) {
+			p.error("unterminated match expression")
+			return nil
+		}
+
+		arm := &ast.MatchArm{Token: p.curToken}
+
+		arm.Pattern = p.parsePattern()
+		if arm.Pattern == nil {
+			return nil
+		}
+
+		if !p.expectPeek(token.ARROW) {
+			return nil
+		}
+
+		// The body is either a block, or a single statement.
+		if p.peekTokenIs(token.LBRACE) {
+			p.nextToken()
+			arm.Block = p.parseBlockStatement()
+			if arm.Block == nil {
+				return nil
+			}
+		} else {
+			p.nextToken()
+			stmt := p.parseStatement()
+			if stmt == nil {
+				return nil
+			}
+			arm.Block = &ast.BlockStatement{Token: arm.Token, Statements: []ast.Statement{stmt}}
+		}
+
+		// Skip any separating comma.
+		if p.peekTokenIs(token.COMMA) {
+			p.nextToken()
+		}
+		p.nextToken()
+
+		expression.Arms = append(expression.Arms, arm)
+	}
+
+	return expression
+}
+
+// parsePattern parses a single pattern within a match expression.
+//
+// Patterns are literal values, regular expressions, hash-patterns,
+// the wildcard `_`, or identifiers which are bound to the value.
+func (p *Parser) parsePattern() ast.Expression {
+
+	switch p.curToken.Type {
+	case token.IDENT:
+		return &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	case token.STRING:
+		return p.parseStringLiteral()
+	case token.INT:
+		return p.parseIntegerLiteral()
+	case token.FLOAT:
+		return p.parseFloatLiteral()
+	case token.TRUE, token.FALSE:
+		return p.parseBooleanLiteral()
+	case token.REGEXP:
+		return p.parseRegexpLiteral()
+	case token.MINUS:
+		if p.peekTokenIs(token.INT) || p.peekTokenIs(token.FLOAT) {
+			return p.parsePrefixExpression()
+		}
+	case token.LBRACE:
+		return p.parseHashPattern()
+	}
+
+	msg := fmt.Sprintf("unexpected token '%s' in match pattern around %s", p.curToken.Literal, p.curToken.Position())
+	p.error(msg)
+	return nil
+}
+
+// parseHashPattern parses a hash-pattern, such as `{ type: "login" }`.
+//
+// Keys may be strings, integers, or bare identifiers - which are
+// treated as strings.
+func (p *Parser) parseHashPattern() ast.Expression {
+
+	hash := &ast.HashPattern{Token: p.curToken}
+
+	for !p.peekTokenIs(token.RBRACE) {
+		p.nextToken()
+
+		var key ast.Expression
+		switch p.curToken.Type {
+		case token.IDENT, token.STRING:
+			key = &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
+		case token.INT:
+			key = p.parseIntegerLiteral()
+		default:
+			msg := fmt.Sprintf("unexpected key '%s' in hash pattern around %s", p.curToken.Literal, p.curToken.Position())
+			p.error(msg)
+			return nil
+		}
+		if key == nil {
+			return nil
+		}
+
+		// `{ user }` is shorthand for `{ user: user }`.
+		var value ast.Expression
+		if p.curTokenIs(token.IDENT) && (p.peekTokenIs(token.COMMA) || p.peekTokenIs(token.RBRACE)) {
+			value = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		} else {
+			if !p.expectPeek(token.COLON) {
+				return nil
+			}
+			p.nextToken()
+
+			value = p.parsePattern()
+			if value == nil {
+				return nil
+			}
+		}
+
+		hash.Keys = append(hash.Keys, key)
+		hash.Values = append(hash.Values, value)
+
+		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+
+	// An empty pattern would match any value at all.
+	if len(hash.Keys) == 0 {
+		msg := fmt.Sprintf("empty hash pattern around %s", p.curToken.Position())
+		p.error(msg)
+		return nil
+	}
+
+	// we've confirmed the next token is token.RBRACE - skip it
+	p.nextToken()
+	return hash
+}
+
 // parseBoolean parses a boolean token.
 func (p *Parser) parseBooleanLiteral() ast.Expression {
 	return &ast.BooleanLiteral{Token: p.curToken, Value: p.curTokenIs(token.TRUE)}
@@ -577,7 +931,7 @@ func (p *Parser) parsePrefixExpression() ast.Expression {
 	// prefix operation then we must abort.
 	if expression.Right == nil {
 		msg := fmt.Sprintf("unexpected nil expression around %s", p.curToken.Position())
-		p.errors = append(p.errors, msg)
+		p.error(msg)
 		return nil
 	}
 	return expression
@@ -607,7 +961,7 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	// then we must abort.
 	if expression.Right == nil {
 		msg := fmt.Sprintf("unexpected nil expression around %s", p.curToken.Position())
-		p.errors = append(p.errors, msg)
+		p.error(msg)
 		return nil
 	}
 	return expression
@@ -622,11 +976,68 @@ func (p *Parser) parsePostfixExpression() ast.Expression {
 	return expression
 }
 
+// parsePostfixOperator parses a postfix operation which follows an
+// expression we've already parsed, such as `a++`, or `count["x"]--`.
+func (p *Parser) parsePostfixOperator(left ast.Expression) ast.Expression {
+
+	// Incrementing a variable.
+	if ident, ok := left.(*ast.Identifier); ok {
+		return &ast.PostfixExpression{
+			Token:    ident.Token,
+			Operator: p.curToken.Literal,
+		}
+	}
+
+	// Incrementing an array/hash member.
+	if ast.IsIndexTarget(left) {
+		return &ast.PostfixExpression{
+			Token:    p.curToken,
+			Operator: p.curToken.Literal,
+			Target:   left,
+		}
+	}
+
+	msg := fmt.Sprintf("%s may only be applied to a variable, or an array/hash member, around %s", p.curToken.Literal, p.curToken.Position())
+	p.error(msg)
+	return nil
+}
+
+// endsWithBlock returns true if the given expression is terminated by a
+// block, rather than being a value.
+func endsWithBlock(exp ast.Expression) bool {
+	switch exp.(type) {
+	case *ast.FunctionDefinition, *ast.IfExpression, *ast.ForeachStatement,
+		*ast.WhileStatement, *ast.SwitchExpression, *ast.MatchExpression:
+		return true
+	}
+	return false
+}
+
+// isDestructuringTarget returns true if the given expression may be used
+// to assign several variables at once, i.e. `[a, b]`, or `{a, b}`.
+func isDestructuringTarget(exp ast.Expression) bool {
+	switch node := exp.(type) {
+	case *ast.ArrayLiteral:
+		if len(node.Elements) == 0 {
+			return false
+		}
+		for _, e := range node.Elements {
+			if _, ok := e.(*ast.Identifier); !ok {
+				return false
+			}
+		}
+		return true
+	case *ast.HashPattern:
+		return true
+	}
+	return false
+}
+
 // parseTernaryExpression parses a ternary expression
 func (p *Parser) parseTernaryExpression(condition ast.Expression) ast.Expression {
 
 	if p.tern {
-		p.errors = append(p.errors, fmt.Sprintf("nested ternary expressions are illegal around %s", p.curToken.Position()))
+		p.error(fmt.Sprintf("nested ternary expressions are illegal around %s", p.curToken.Position()))
 		return nil
 	}
 
@@ -643,12 +1054,12 @@ func (p *Parser) parseTernaryExpression(condition ast.Expression) ast.Expression
 
 	// error?
 	if expression.IfTrue == nil {
-		p.errors = append(p.errors, fmt.Sprintf("unexpected nil expression around %s", p.curToken.Position()))
+		p.error(fmt.Sprintf("unexpected nil expression around %s", p.curToken.Position()))
 		return nil
 	}
 
 	if !p.expectPeek(token.COLON) { //skip the ":"
-		p.errors = append(p.errors, fmt.Sprintf("missing colon in ternary expression around  %s", p.curToken.Position()))
+		p.error(fmt.Sprintf("missing colon in ternary expression around  %s", p.curToken.Position()))
 		return nil
 	}
 
@@ -659,7 +1070,7 @@ func (p *Parser) parseTernaryExpression(condition ast.Expression) ast.Expression
 	// error?
 	if expression.IfFalse == nil {
 		msg := fmt.Sprintf("unexpected nil expression around %s", p.curToken.Position())
-		p.errors = append(p.errors, msg)
+		p.error(msg)
 		return nil
 	}
 
@@ -673,12 +1084,12 @@ func (p *Parser) parseGroupedExpression() ast.Expression {
 	exp := p.parseExpression(LOWEST)
 	if exp == nil {
 		msg := fmt.Sprintf("unexpected nil expression around %s", p.curToken.Position())
-		p.errors = append(p.errors, msg)
+		p.error(msg)
 		return nil
 	}
 	if !p.expectPeek(token.RPAREN) {
 		msg := fmt.Sprintf("expected ) but got %s around %s", p.curToken.Literal, p.curToken.Position())
-		p.errors = append(p.errors, msg)
+		p.error(msg)
 		return nil
 	}
 	return exp
@@ -697,7 +1108,7 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	// Now "{"
 	if !p.expectPeek(token.LBRACE) {
 		msg := fmt.Sprintf("expected { but got %s around %s", p.curToken.Literal, p.curToken.Position())
-		p.errors = append(p.errors, msg)
+		p.error(msg)
 		return nil
 	}
 
@@ -705,7 +1116,7 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	expression.Consequence = p.parseBlockStatement()
 	if expression.Consequence == nil {
 		msg := fmt.Sprintf("unexpected nil expression around %s", p.curToken.Position())
-		p.errors = append(p.errors, msg)
+		p.error(msg)
 		return nil
 	}
 
@@ -732,13 +1143,13 @@ func (p *Parser) parseIfExpression() ast.Expression {
 		// else { block }
 		if !p.expectPeek(token.LBRACE) {
 			msg := fmt.Sprintf("expected { but got %s around %s", p.curToken.Literal, p.curToken.Position())
-			p.errors = append(p.errors, msg)
+			p.error(msg)
 			return nil
 		}
 		expression.Alternative = p.parseBlockStatement()
 		if expression.Alternative == nil {
 			msg := fmt.Sprintf("unexpected nil expression around %s", p.curToken.Position())
-			p.errors = append(p.errors, msg)
+			p.error(msg)
 			return nil
 		}
 	}
@@ -769,7 +1180,7 @@ func (p *Parser) parseForEach() ast.Expression {
 		p.nextToken()
 
 		if !p.peekTokenIs(token.IDENT) {
-			p.errors = append(p.errors, fmt.Sprintf("second argument to foreach must be ident, got %v", p.peekToken))
+			p.error(fmt.Sprintf("second argument to foreach must be ident, got %v", p.peekToken))
 			return nil
 		}
 		p.nextToken()
@@ -785,7 +1196,7 @@ func (p *Parser) parseForEach() ast.Expression {
 	// The next token, after the ident(s), should be `in`.
 	if !p.expectPeek(token.IN) {
 		msg := fmt.Sprintf("missing 'in' in foreach statement around %s", p.curToken.Position())
-		p.errors = append(p.errors, msg)
+		p.error(msg)
 		return nil
 	}
 	p.nextToken()
@@ -794,13 +1205,15 @@ func (p *Parser) parseForEach() ast.Expression {
 	expression.Value = p.parseExpression(LOWEST)
 	if expression.Value == nil {
 		msg := fmt.Sprintf("unexpected nil expression around %s", p.curToken.Position())
-		p.errors = append(p.errors, msg)
+		p.error(msg)
 		return nil
 	}
 
 	// parse the block
 	p.nextToken()
+	p.loops++
 	expression.Body = p.parseBlockStatement()
+	p.loops--
 
 	return expression
 }
@@ -808,8 +1221,31 @@ func (p *Parser) parseForEach() ast.Expression {
 // parseFunctionDefinition parses the definition of a function.
 func (p *Parser) parseFunctionDefinition() ast.Expression {
 
-	// We're inside a function
+	// We're inside a function, and not yet inside any of its loops,
+	// until we're done - even if the function is broken.
+	inside := p.function
 	p.function = true
+	loops := p.loops
+	p.loops = 0
+	defer func() {
+		p.function = inside
+		p.loops = loops
+	}()
+
+	// An anonymous function has no name.
+	if p.peekTokenIs(token.LPAREN) {
+		lit := &ast.FunctionLiteral{Token: p.curToken}
+		p.nextToken()
+
+		lit.Parameters = p.parseFunctionParameters()
+		if !p.expectPeek(token.LBRACE) {
+			msg := fmt.Sprintf("expected { but got %s around %s", p.curToken.Literal, p.curToken.Position())
+			p.error(msg)
+			return nil
+		}
+		lit.Body = p.parseBlockStatement()
+		return lit
+	}
 
 	// skip the `function` keyword
 	p.nextToken()
@@ -820,7 +1256,7 @@ func (p *Parser) parseFunctionDefinition() ast.Expression {
 	// Expect "("
 	if !p.expectPeek(token.LPAREN) {
 		msg := fmt.Sprintf("expected ( but got %s around %s", p.curToken.Literal, p.curToken.Position())
-		p.errors = append(p.errors, msg)
+		p.error(msg)
 		return nil
 	}
 
@@ -830,7 +1266,7 @@ func (p *Parser) parseFunctionDefinition() ast.Expression {
 	// Now we want "{"
 	if !p.expectPeek(token.LBRACE) {
 		msg := fmt.Sprintf("expected { but got %s around %s", p.curToken.Literal, p.curToken.Position())
-		p.errors = append(p.errors, msg)
+		p.error(msg)
 		return nil
 	}
 
@@ -838,9 +1274,6 @@ func (p *Parser) parseFunctionDefinition() ast.Expression {
 	// closing "}".
 	lit.Body = p.parseBlockStatement()
 
-	// We're no longer inside a function
-	p.function = false
-
 	return lit
 }
 
@@ -863,7 +1296,7 @@ func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 	for !p.curTokenIs(token.RPAREN) {
 
 		if p.curTokenIs(token.EOF) {
-			p.errors = append(p.errors, "unterminated function parameters found end of file")
+			p.error("unterminated function parameters found end of file")
 			return nil
 		}
 
@@ -887,27 +1320,29 @@ func (p *Parser) parseWhileStatement() ast.Expression {
 
 	if !p.expectPeek(token.LPAREN) {
 		msg := fmt.Sprintf("expected ( but got %s around %s", p.curToken.Literal, p.curToken.Position())
-		p.errors = append(p.errors, msg)
+		p.error(msg)
 		return nil
 	}
 	p.nextToken()
 	expression.Condition = p.parseExpression(LOWEST)
 	if expression.Condition == nil {
 		msg := fmt.Sprintf("unexpected nil expression around %s", p.curToken.Position())
-		p.errors = append(p.errors, msg)
+		p.error(msg)
 		return nil
 	}
 	if !p.expectPeek(token.RPAREN) {
 		msg := fmt.Sprintf("expected ) but got %s around %s", p.curToken.Literal, p.curToken.Position())
-		p.errors = append(p.errors, msg)
+		p.error(msg)
 		return nil
 	}
 	if !p.expectPeek(token.LBRACE) {
 		msg := fmt.Sprintf("expected { but got %s around %s", p.curToken.Literal, p.curToken.Position())
-		p.errors = append(p.errors, msg)
+		p.error(msg)
 		return nil
 	}
+	p.loops++
 	expression.Body = p.parseBlockStatement()
+	p.loops--
 	return expression
 }
 
@@ -917,17 +1352,38 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	block.Statements = []ast.Statement{}
 	p.nextToken()
 	for !p.curTokenIs(token.RBRACE) {
+		seen := len(p.errors)
 		stmt := p.parseStatement()
 		if stmt == nil {
 			msg := fmt.Sprintf("unexpected nil statement around %s", p.curToken.Position())
-			p.errors = append(p.errors, msg)
-			return nil
+			p.error(msg)
+		}
+
+		//
+		// If the statement was broken then skip it, and carry
+		// on with the rest of the block.
+		//
+		if len(p.errors) > seen {
+			p.synchronize(seen)
+			if p.curTokenIs(token.RBRACE) {
+				break
+			}
+			if p.curToken.Type == token.EOF || p.curToken.Type == token.ILLEGAL {
+				return nil
+			}
+			p.nextToken()
+			if p.curToken.Type == token.EOF || p.curToken.Type == token.ILLEGAL {
+				p.error("incomplete block statement")
+				return nil
+			}
+			continue
 		}
+
 		block.Statements = append(block.Statements, stmt)
 		p.nextToken()
 
 		if p.curToken.Type == token.EOF || p.curToken.Type == token.ILLEGAL {
-			p.errors = append(p.errors, "incomplete block statement")
+			p.error("incomplete block statement")
 			return nil
 		}
 	}
@@ -979,6 +1435,13 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 	for !p.peekTokenIs(token.RBRACE) {
 		p.nextToken()
 		key := p.parseExpression(LOWEST)
+
+		// `{a, b}` is used to destructure a hash.
+		if ident, ok := key.(*ast.Identifier); ok && len(hash.Pairs) == 0 &&
+			(p.peekTokenIs(token.COMMA) || p.peekTokenIs(token.RBRACE)) {
+			return p.parseHashShorthand(hash.Token, ident)
+		}
+
 		if !p.expectPeek(token.COLON) {
 			return nil
 		}
@@ -995,6 +1458,49 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 	return hash
 }
 
+// parseHashShorthand parses the `{a, b}` form used to assign variables
+// from the members of a hash, which have the same names, at once:
+//
+//	{user, ip} = event.details;
+//
+// The result is a hash-pattern, where each key is bound to the variable
+// of the same name.  The first identifier has already been consumed.
+func (p *Parser) parseHashShorthand(tok token.Token, first *ast.Identifier) ast.Expression {
+
+	hash := &ast.HashPattern{Token: tok}
+
+	ident := first
+	for {
+		key := &ast.StringLiteral{Token: ident.Token, Value: ident.Value}
+		hash.Keys = append(hash.Keys, key)
+		hash.Values = append(hash.Values, ident)
+
+		if p.peekTokenIs(token.RBRACE) {
+			break
+		}
+		if !p.expectPeek(token.COMMA) {
+			return nil
+		}
+		if p.peekTokenIs(token.RBRACE) {
+			break
+		}
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		ident = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	}
+
+	// skip the "}"
+	p.nextToken()
+
+	if !p.peekTokenIs(token.ASSIGN) {
+		msg := fmt.Sprintf("%s may only be used as the target of an assignment, around %s", hash.String(), p.curToken.Position())
+		p.error(msg)
+		return nil
+	}
+	return hash
+}
+
 // parse an array of expressions, as used for function-arguments.
 func (p *Parser) parseExpressionList(end token.Type) []ast.Expression {
 	list := make([]ast.Expression, 0)
@@ -1008,7 +1514,7 @@ func (p *Parser) parseExpressionList(end token.Type) []ast.Expression {
 	first := p.parseExpression(LOWEST)
 	if first == nil {
 		msg := fmt.Sprintf("unexpected nil expression around %s", p.curToken.Position())
-		p.errors = append(p.errors, msg)
+		p.error(msg)
 		return nil
 	}
 	list = append(list, first)
@@ -1021,14 +1527,14 @@ func (p *Parser) parseExpressionList(end token.Type) []ast.Expression {
 		ent := p.parseExpression(LOWEST)
 		if ent == nil {
 			msg := fmt.Sprintf("unexpected nil expression around %s", p.curToken.Position())
-			p.errors = append(p.errors, msg)
+			p.error(msg)
 			return nil
 		}
 		list = append(list, ent)
 	}
 	if !p.expectPeek(end) {
 		msg := fmt.Sprintf("expected %v not found around %s", end, p.curToken.Position())
-		p.errors = append(p.errors, msg)
+		p.error(msg)
 		return nil
 	}
 	return list
@@ -1039,9 +1545,11 @@ func (p *Parser) parseAssignExpression(name ast.Expression) ast.Expression {
 	stmt := &ast.AssignStatement{Token: p.curToken}
 	if n, ok := name.(*ast.Identifier); ok {
 		stmt.Name = n
+	} else if ast.IsIndexTarget(name) || isDestructuringTarget(name) {
+		stmt.Target = name
 	} else {
 		msg := fmt.Sprintf("expected assign token to be IDENT, got %s instead around %s", name.TokenLiteral(), p.curToken.Position())
-		p.errors = append(p.errors, msg)
+		p.error(msg)
 	}
 
 	// Skip over the `=`
@@ -1050,7 +1558,7 @@ func (p *Parser) parseAssignExpression(name ast.Expression) ast.Expression {
 	stmt.Value = p.parseExpression(LOWEST)
 	if stmt.Value == nil {
 		msg := fmt.Sprintf("unexpected nil statement around %s", p.curToken.Position())
-		p.errors = append(p.errors, msg)
+		p.error(msg)
 		return nil
 	}
 	return stmt
@@ -1063,22 +1571,58 @@ func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
 	return exp
 }
 
-// parseIndexExpression parse an array-index expression.
+// parseIndexExpression parse an array-index expression, or a slice.
 func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 	exp := &ast.IndexExpression{Token: p.curToken, Left: left}
 	p.nextToken()
+
+	// A slice might omit the start.
+	if p.curTokenIs(token.COLON) {
+		return p.parseSliceExpression(exp.Token, left, nil)
+	}
+
 	exp.Index = p.parseExpression(LOWEST)
 
+	// A colon after the index makes this a slice.
+	if exp.Index != nil && p.peekTokenIs(token.COLON) {
+		p.nextToken()
+		return p.parseSliceExpression(exp.Token, left, exp.Index)
+	}
+
 	// error?
 	if exp.Index == nil {
 		msg := fmt.Sprintf("unexpected nil expression around %s", p.curToken.Position())
-		p.errors = append(p.errors, msg)
+		p.error(msg)
 		return nil
 	}
 
 	if !p.expectPeek(token.RSQUARE) {
 		msg := fmt.Sprintf("expected ] but got %s around %s", p.curToken.Literal, p.curToken.Position())
-		p.errors = append(p.errors, msg)
+		p.error(msg)
+		return nil
+	}
+	return exp
+}
+
+// parseSliceExpression parses the remainder of a slice-expression, such
+// as `a[1:3]`, once the colon has been reached.
+func (p *Parser) parseSliceExpression(tok token.Token, left, start ast.Expression) ast.Expression {
+	exp := &ast.SliceExpression{Token: tok, Left: left, Start: start}
+
+	// The end might be omitted.
+	if !p.peekTokenIs(token.RSQUARE) {
+		p.nextToken()
+		exp.End = p.parseExpression(LOWEST)
+		if exp.End == nil {
+			msg := fmt.Sprintf("unexpected nil expression around %s", p.curToken.Position())
+			p.error(msg)
+			return nil
+		}
+	}
+
+	if !p.expectPeek(token.RSQUARE) {
+		msg := fmt.Sprintf("expected ] but got %s around %s", p.curToken.Literal, p.curToken.Position())
+		p.error(msg)
 		return nil
 	}
 	return exp
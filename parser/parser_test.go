@@ -401,6 +401,35 @@ func TestIndex(t *testing.T) {
 	}
 }
 
+func TestSlice(t *testing.T) {
+
+	tests := []struct {
+		input  string
+		output string
+	}{
+		{"a[1:3]", "(a[1:3])"},
+		{"a[:2]", "(a[:2])"},
+		{"a[-2:]", "(a[(-2):])"},
+		{"a[:]", "(a[:])"},
+		{"a[1+1:len(a)]", "(a[(1 + 1):len(a)])"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		stmt, _ := program.Statements[0].(*ast.ExpressionStatement)
+		_, ok := stmt.Expression.(*ast.SliceExpression)
+		if !ok {
+			t.Fatalf("exp not *ast.SliceExpression. got=%T", stmt.Expression)
+		}
+		if stmt.Expression.String() != tt.output {
+			t.Fatalf("wrong output for %s, got %s expected %s", tt.input, stmt.Expression.String(), tt.output)
+		}
+	}
+}
+
 func TestParseIllegal(t *testing.T) {
 
 	l := lexer.New("")
@@ -486,6 +515,8 @@ func TestParsePrefixPostfix(t *testing.T) {
 		"if ( !false == true ) { return true; }",
 		"a = 1; a++;",
 		"a = 2; a--;",
+		"a = [1]; a[0]++;",
+		"a = {}; a[\"x\"] = 3; a.x += 2; a.x--;",
 	}
 
 	for _, test := range input {
@@ -500,6 +531,113 @@ func TestParsePrefixPostfix(t *testing.T) {
 	}
 
 }
+
+// TestParsePostfixBogus ensures that postfix operators only apply to
+// variables and array/hash members.
+func TestParsePostfixBogus(t *testing.T) {
+	input := []string{
+		"3++;",
+		"foo()--;",
+		"\"steve\"++;",
+		"len(a) = 3;",
+	}
+
+	for _, test := range input {
+
+		l := lexer.New(test)
+		p := New(l)
+		_ = p.ParseProgram()
+
+		if len(p.errors) == 0 {
+			t.Errorf("expected error parsing %s, got none", test)
+		}
+	}
+}
+
+func TestParseMatch(t *testing.T) {
+
+	type Test struct {
+		Input  string
+		Arms   int
+		Output string
+	}
+
+	tests := []Test{
+		{Input: `match x { "a" => print("A"), _ => { print("?"); } }`, Arms: 2, Output: `match x`},
+		{Input: `match Event { { type: "login", "fail": true, user: who } => return who; }`, Arms: 1, Output: `{"type":"login", "fail":true, "user":who} =>`},
+		{Input: `match x { { user: { name: /^s/i } } => return 1; -3 => return 2; }`, Arms: 2, Output: `{"user":{"name":/^s/i}} =>`},
+	}
+
+	for _, test := range tests {
+
+		l := lexer.New(test.Input)
+		p := New(l)
+		program := p.ParseProgram()
+
+		if len(p.errors) != 0 {
+			t.Fatalf("unexpected error parsing %s: %v", test.Input, p.errors)
+		}
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		exp, ok := stmt.Expression.(*ast.MatchExpression)
+		if !ok {
+			t.Fatalf("expected a match expression, got %T", stmt.Expression)
+		}
+		if len(exp.Arms) != test.Arms {
+			t.Fatalf("expected %d arms, got %d", test.Arms, len(exp.Arms))
+		}
+		if !strings.Contains(exp.String(), test.Output) {
+			t.Fatalf("unexpected output %s", exp.String())
+		}
+	}
+
+	// `match` is still usable as a function, or a variable.
+	for _, test := range []string{`match("a", "b");`, `match = 3;`, `return match;`} {
+
+		l := lexer.New(test)
+		p := New(l)
+		program := p.ParseProgram()
+
+		if len(p.errors) != 0 {
+			t.Fatalf("unexpected error parsing %s: %v", test, p.errors)
+		}
+		if strings.Contains(program.String(), "=>") {
+			t.Fatalf("%s was parsed as a match expression", test)
+		}
+	}
+}
+
+func TestParseDestructuring(t *testing.T) {
+
+	tests := map[string]string{
+		`[a, b] = split(x, ",");`:                   "*ast.ArrayLiteral",
+		`{user, ip} = event.details;`:               "*ast.HashPattern",
+		`if ( x ) { y = 1; } [a, b] = [1, 2];`:      "*ast.ArrayLiteral",
+		`function f() { return 1; } [a] = [f()];`:   "*ast.ArrayLiteral",
+		`foreach i in [1, 2] { print(i); } [a] = z`: "*ast.ArrayLiteral",
+	}
+
+	for input, target := range tests {
+
+		l := lexer.New(input)
+		p := New(l)
+		program := p.ParseProgram()
+
+		if len(p.errors) != 0 {
+			t.Fatalf("unexpected error parsing %s: %v", input, p.errors)
+		}
+
+		last := program.Statements[len(program.Statements)-1].(*ast.ExpressionStatement)
+		assign, ok := last.Expression.(*ast.AssignStatement)
+		if !ok {
+			t.Fatalf("expected an assignment for %s, got %T", input, last.Expression)
+		}
+		if fmt.Sprintf("%T", assign.Target) != target {
+			t.Fatalf("unexpected target for %s: %T", input, assign.Target)
+		}
+	}
+}
+
 func TestParseRegexp(t *testing.T) {
 
 	input := []string{"if ( Content ~= /needle/ ) { true ; }",
@@ -578,6 +716,93 @@ func TestParseWhile(t *testing.T) {
 	}
 }
 
+func TestParseLoopControl(t *testing.T) {
+
+	type TestCase struct {
+		input string
+		error bool
+	}
+
+	for _, test := range []TestCase{{input: "while (1) { break; }", error: false},
+		{input: "foreach x in y { if ( x ) { continue; } break; }", error: false},
+		{input: "while (1) { foreach x in y { break; } continue; }", error: false},
+		{input: "while (1) { break }", error: false},
+		{input: "while (1) { break 3 }", error: true},
+		{input: "while (1) { continue 3; }", error: true},
+		{input: "break;", error: true},
+		{input: "if ( true ) { continue; }", error: true},
+		{input: "while (1) { function foo() { break; } }", error: true},
+		{input: "function foo() { while (1) { break; } continue; }", error: true}} {
+		l := lexer.New(test.input)
+		p := New(l)
+		p.ParseProgram()
+
+		if test.error {
+
+			if len(p.errors) == 0 {
+				t.Fatalf("expected to see an error, but didn't: %s", test.input)
+			}
+		} else {
+
+			if len(p.errors) > 0 {
+				t.Fatalf("shouldn't have seen an error, but did: %s", p.errors[0])
+			}
+		}
+	}
+}
+
+func TestParseFunctionLiteral(t *testing.T) {
+
+	type TestCase struct {
+		input string
+		error bool
+	}
+
+	for _, test := range []TestCase{{input: "f = function( x, y ) { return x + y; };", error: false},
+		{input: "return map( a, function( x ) { return x * 2; } );", error: false},
+		{input: "return ( function() { return 1; } )();", error: false},
+		{input: "function foo() { f = function() { return 1; }; local x; }", error: false},
+		{input: "f = function() { local x; };", error: false},
+		{input: "f = function( x ) return x;", error: true},
+		{input: "while (1) { f = function() { break; }; }", error: true}} {
+		l := lexer.New(test.input)
+		p := New(l)
+		p.ParseProgram()
+
+		if test.error {
+
+			if len(p.errors) == 0 {
+				t.Fatalf("expected to see an error, but didn't: %s", test.input)
+			}
+		} else {
+
+			if len(p.errors) > 0 {
+				t.Fatalf("shouldn't have seen an error, but did: %s", p.errors[0])
+			}
+		}
+	}
+
+	// The function is a value.
+	l := lexer.New("f = function( x, y ) { return x + y; };")
+	p := New(l)
+	program := p.ParseProgram()
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	assign, ok := stmt.Expression.(*ast.AssignStatement)
+	if !ok {
+		t.Fatalf("expected an assignment, got %T", stmt.Expression)
+	}
+	fn, ok := assign.Value.(*ast.FunctionLiteral)
+	if !ok {
+		t.Fatalf("expected a function literal, got %T", assign.Value)
+	}
+	if len(fn.Parameters) != 2 || fn.Parameters[1].Value != "y" {
+		t.Fatalf("unexpected parameters %v", fn.Parameters)
+	}
+	if !strings.HasPrefix(fn.String(), "function(x, y)") {
+		t.Fatalf("unexpected string %s", fn.String())
+	}
+}
+
 func TestParseForeach(t *testing.T) {
 
 	type TestCase struct {
@@ -638,7 +863,7 @@ return "steve";
 		}
 	}
 
-	incomplete := `return true`
+	incomplete := `return true 3`
 	l = lexer.New(incomplete)
 	p = New(l)
 	p.ParseProgram()
@@ -872,3 +1097,195 @@ func TestFuzzerResults(t *testing.T) {
 		}
 	}
 }
+
+// TestParseErrorToken ensures the error returned by Parse identifies the
+// token at which the problem was found.
+func TestParseErrorToken(t *testing.T) {
+
+	p := New(lexer.New("x = 1;\nreturn 1 +;"))
+	_, err := p.Parse()
+
+	perr, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("expected a parse error, got %T %v", err, err)
+	}
+	if perr.Token.Type != token.SEMICOLON || perr.Token.Line != 2 {
+		t.Fatalf("unexpected token %v", perr.Token)
+	}
+	if perr.Error() != perr.Message || !strings.Contains(perr.Message, "no prefix parse function") {
+		t.Fatalf("unexpected message %s", perr.Message)
+	}
+}
+
+// TestErrorRecovery ensures that all the broken statements in a script
+// are reported, rather than just the first.
+func TestErrorRecovery(t *testing.T) {
+
+	tests := []struct {
+		input  string
+		errors []string
+	}{
+		{"x = 1 +;\ny = 3;\nreturn 2 *;\n",
+			[]string{"line 1, column 8", "line 3, column 11"}},
+		{"if ( x ) { a = 1 +; b = 2; c = ( 3; }\nfunction f() { return 1 +; }\nlocal z;\nreturn true;",
+			[]string{"line 1, column 19", "expected next token to be )", "line 2, column 26", "'local' may only be used inside a function"}},
+		{"while ( x { y = 1; }\nbreak;\nreturn 3;",
+			[]string{"expected next token to be )", "'break' may only be used inside a loop"}},
+		{"x = 1;\nreturn x;",
+			[]string{}},
+	}
+
+	for _, test := range tests {
+
+		p := New(lexer.New(test.input))
+		p.Parse()
+
+		errs := p.Errors()
+		if len(errs) != len(test.errors) {
+			t.Fatalf("expected %d errors for %s, got %d: %s", len(test.errors), test.input, len(errs), strings.Join(errs, "\n"))
+		}
+		for i, e := range test.errors {
+			if !strings.Contains(errs[i], e) {
+				t.Fatalf("expected error %d of %s to mention '%s', got '%s'", i, test.input, e, errs[i])
+			}
+		}
+	}
+}
+
+// TestOptionalSemicolons ensures that semicolons may be omitted at the
+// ends of lines, and the ends of blocks.
+func TestOptionalSemicolons(t *testing.T) {
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"x = 1\ny = 2\nreturn x + y", "x = 1; y = 2; return x + y;"},
+		{"if ( a == 1\n  && b == 2 )\n{\n  return true\n}\nelse\n{\n  return false\n}", "if ( a == 1 && b == 2 ) { return true; } else { return false; }"},
+		{"while ( i < 3 ) { i++\n if ( i == 2 ) { break } }", "while ( i < 3 ) { i++; if ( i == 2 ) { break; } }"},
+		{"h = { \"a\":\n  1 }\nreturn h", "h = { \"a\": 1 }; return h;"},
+		{"return { \"a\": 1 }", "return { \"a\": 1 };"},
+		{"x = a +\n  b\ny = c\n  * d", "x = a + b; y = c * d;"},
+		{"return f( 1,\n  2 )\n  ? x\n  : y", "return f( 1, 2 ) ? x : y;"},
+		{"switch ( x ) {\n  case 1 {\n    return 1\n  }\n  default {\n    return 2\n  }\n}", "switch ( x ) { case 1 { return 1; } default { return 2; } }"},
+	}
+
+	for _, test := range tests {
+
+		got, err := New(lexer.New(test.input)).Parse()
+		if err != nil {
+			t.Fatalf("failed to parse %s: %s", test.input, err)
+		}
+		want, err := New(lexer.New(test.expected)).Parse()
+		if err != nil {
+			t.Fatalf("failed to parse %s: %s", test.expected, err)
+		}
+		if got.String() != want.String() {
+			t.Fatalf("parsing %s gave %s, expected %s", test.input, got.String(), want.String())
+		}
+	}
+}
+
+// TestSuggestions ensures that errors suggest the likely fix.
+func TestSuggestions(t *testing.T) {
+
+	tests := []struct {
+		input      string
+		suggestion string
+	}{
+		{"iff ( x ) { return 1; }", "did you mean 'if', rather than 'iff'?"},
+		{"whlie ( x ) { x--; }", "did you mean 'while', rather than 'whlie'?"},
+		{"fucntion foo() { return 1; }", "did you mean 'function', rather than 'fucntion'?"},
+		{"if ( x ) { return 1; } esle { return 2; }", "did you mean 'else', rather than 'esle'?"},
+		{"if ( x == 3 { return 1; }", "is a ')' missing?"},
+		{"x = [1, 2;", "is a ']' missing?"},
+		{"if ( x == 3 ) { return 1;", "is a '}' missing?"},
+		{"if ( x ) { return 1 }}", "is there an extra '}'?"},
+		{"return x y", "is a ';' missing?"},
+		{"return x == = 3;", "did you mean '=='?"},
+		{"h = { \"a\" = 1 };", "did you mean ':'?"},
+	}
+
+	for _, test := range tests {
+
+		p := New(lexer.New(test.input))
+		p.Parse()
+
+		errs := p.Errors()
+		if len(errs) < 1 {
+			t.Fatalf("expected an error for %s", test.input)
+		}
+		if !strings.HasSuffix(errs[0], " - "+test.suggestion) {
+			t.Errorf("expected the error for %s to suggest '%s', got '%s'", test.input, test.suggestion, errs[0])
+		}
+	}
+
+	// Variables which merely look like keywords are left alone.
+	for _, input := range []string{"foo ( x ) { return 1; }", "i = [1, 2;"} {
+		p := New(lexer.New(input))
+		p.Parse()
+
+		errs := p.Errors()
+		if len(errs) < 1 || strings.Contains(errs[0], "did you mean") {
+			t.Errorf("unexpected suggestion for %s: %v", input, errs)
+		}
+	}
+}
+
+// TestDistance tests the edit-distance between two strings.
+func TestDistance(t *testing.T) {
+
+	tests := []struct {
+		a string
+		b string
+		d int
+	}{
+		{"while", "while", 0},
+		{"whlie", "while", 1},
+		{"iff", "if", 1},
+		{"retrun", "return", 1},
+		{"retun", "return", 1},
+		{"kitten", "sitting", 3},
+		{"", "for", 3},
+	}
+
+	for _, test := range tests {
+		if d := distance(test.a, test.b); d != test.d {
+			t.Errorf("expected the distance between %s and %s to be %d, got %d", test.a, test.b, test.d, d)
+		}
+	}
+}
+
+// TestMaxNesting tests that the nesting of brackets may be limited.
+func TestMaxNesting(t *testing.T) {
+
+	tests := []struct {
+		input string
+		limit int
+		error bool
+	}{
+		{"return ( ( ( 1 ) ) );", 0, false},
+		{"return ( ( ( 1 ) ) );", 3, false},
+		{"return ( ( ( 1 ) ) );", 2, true},
+		{"if ( x ) { return [ [ 1 ] ]; }", 2, true},
+		{"if ( x ) { return [ [ 1 ] ]; }", 3, false},
+	}
+
+	for _, test := range tests {
+
+		p := New(lexer.New(test.input))
+		p.SetMaxNesting(test.limit)
+		_, err := p.Parse()
+
+		if test.error {
+			if err == nil || !strings.Contains(err.Error(), "levels deep") {
+				t.Errorf("expected a nesting error for %s with limit %d, got %v", test.input, test.limit, err)
+			}
+			if len(p.Errors()) != 1 {
+				t.Errorf("expected a single error for %s, got %v", test.input, p.Errors())
+			}
+		} else if err != nil {
+			t.Errorf("unexpected error for %s with limit %d: %s", test.input, test.limit, err)
+		}
+	}
+}
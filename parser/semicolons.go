@@ -0,0 +1,170 @@
+// This file contains the automatic insertion of semicolons.
+//
+// Forgetting the semicolon at the end of a statement is the most common
+// mistake made by the authors of scripts, so a statement which ends at
+// the end of a line doesn't need one.  As the tokens come from the lexer
+// we insert a semicolon at each newline, the end of the script, and the
+// `}` which closes a block, which follows a token that may end a statement:
+//
+//	an identifier, number, string, regular expression, or boolean
+//	`)`, `]`, or the `}` which closes a hash
+//	`++`, `--`, `break`, or `continue`
+//
+// There are some exceptions, which allow a statement to be spread over
+// several lines:
+//
+//	No semicolon is inserted within parentheses, square brackets, or
+//	a hash - only within blocks.
+//
+//	No semicolon is inserted after the `}` which closes a block, so
+//	an `else`, or the next `case`, may begin the line after it.
+//
+//	No semicolon is inserted before a line which begins with an
+//	operator that can't begin a statement, such as `&&`, `||`, `+`,
+//	`.`, `?`, or `:`, or which begins with `else` or `{`.
+//
+// The last rule means that a line which begins with `-`, `(`, or `[`
+// starts a new statement, rather than continuing the one before it.
+
+package parser
+
+import "github.com/skx/evalfilter/v2/token"
+
+// ends contains the tokens which may end a statement.
+var ends = map[token.Type]bool{
+	token.BREAK:      true,
+	token.CONTINUE:   true,
+	token.FALSE:      true,
+	token.FLOAT:      true,
+	token.IDENT:      true,
+	token.INT:        true,
+	token.MINUSMINUS: true,
+	token.PLUSPLUS:   true,
+	token.REGEXP:     true,
+	token.RPAREN:     true,
+	token.RSQUARE:    true,
+	token.STRING:     true,
+	token.TRUE:       true,
+}
+
+// continues contains the tokens which, at the start of a line, continue
+// the statement upon the line before.
+var continues = map[token.Type]bool{
+	token.AND:            true,
+	token.ARROW:          true,
+	token.ASSIGN:         true,
+	token.ASTERISK:       true,
+	token.ASTERISKEQUALS: true,
+	token.COLON:          true,
+	token.CONTAINS:       true,
+	token.DOTDOT:         true,
+	token.ELSE:           true,
+	token.EQ:             true,
+	token.GT:             true,
+	token.GTEQUALS:       true,
+	token.IN:             true,
+	token.LBRACE:         true,
+	token.LT:             true,
+	token.LTEQUALS:       true,
+	token.MINUSEQUALS:    true,
+	token.MISSING:        true,
+	token.MOD:            true,
+	token.NOTEQ:          true,
+	token.OR:             true,
+	token.PERIOD:         true,
+	token.PLUS:           true,
+	token.PLUSEQUALS:     true,
+	token.POW:            true,
+	token.QUESTION:       true,
+	token.SLASH:          true,
+	token.SLASHEQUALS:    true,
+}
+
+// blocks contains the tokens which, before a `{`, show that it opens a
+// block rather than a hash - such as the `)` of `if ( x ) {`, or the
+// value of `case 3 {`.
+var blocks = map[token.Type]bool{
+	token.ARROW:   true,
+	token.DEFAULT: true,
+	token.ELSE:    true,
+	token.FALSE:   true,
+	token.FLOAT:   true,
+	token.IDENT:   true,
+	token.INT:     true,
+	token.REGEXP:  true,
+	token.RPAREN:  true,
+	token.RSQUARE: true,
+	token.STRING:  true,
+	token.TRUE:    true,
+}
+
+// block is recorded, in our nesting, for the `{` which opens a block.
+const block token.Type = "BLOCK"
+
+// readToken returns the next token from our lexer, inserting semicolons
+// at the ends of lines.
+func (p *Parser) readToken() token.Token {
+
+	if p.pending != nil {
+		tok := *p.pending
+		p.pending = nil
+		p.track(tok)
+		return tok
+	}
+
+	tok := p.l.NextToken()
+
+	// The end of the file is the end of the last line, and the end
+	// of a block is the end of its last statement.
+	last := p.last
+	newline := p.l.Newline() || tok.Type == token.EOF
+	closing := tok.Type == token.RBRACE && len(p.nesting) > 0 && p.nesting[len(p.nesting)-1] == block
+	if ((newline && !continues[tok.Type]) || closing) && p.insert(last) {
+		p.pending = &tok
+		semi := token.Token{Type: token.SEMICOLON, Literal: ";", Line: last.Line, Column: last.Column + 1}
+		p.last = semi
+		return semi
+	}
+
+	p.track(tok)
+	return tok
+}
+
+// insert returns true if a semicolon should be inserted after the given
+// token, if a newline follows it.
+func (p *Parser) insert(tok token.Token) bool {
+
+	// Not within parentheses, brackets, or hashes.
+	if len(p.nesting) > 0 && p.nesting[len(p.nesting)-1] != block {
+		return false
+	}
+
+	// Only after the `}` of a hash.
+	if tok.Type == token.RBRACE {
+		return p.closed == token.LBRACE
+	}
+	return ends[tok.Type]
+}
+
+// track records the given token, keeping note of the brackets we're
+// within.
+func (p *Parser) track(tok token.Token) {
+
+	switch tok.Type {
+	case token.LPAREN, token.LSQUARE:
+		p.nesting = append(p.nesting, tok.Type)
+	case token.LBRACE:
+		if blocks[p.last.Type] {
+			p.nesting = append(p.nesting, block)
+		} else {
+			p.nesting = append(p.nesting, token.LBRACE)
+		}
+	case token.RPAREN, token.RSQUARE, token.RBRACE:
+		p.closed = ""
+		if len(p.nesting) > 0 {
+			p.closed = p.nesting[len(p.nesting)-1]
+			p.nesting = p.nesting[:len(p.nesting)-1]
+		}
+	}
+	p.last = tok
+}
@@ -0,0 +1,193 @@
+// This file contains the suggestions we make when a script can't be
+// parsed.
+//
+// An error such as "no prefix parse function for RETURN" is accurate,
+// but it doesn't help the author of a script to fix it.  So when we
+// record an error we look at where it was found, and add a suggestion of
+// the likely cause:
+//
+//	whlie ( x ) { ... }     - did you mean 'while'?
+//	if ( x == 3 { ... }     - is a ')' missing?
+//	h = { "a" = 1 };        - did you mean ':'?
+//	return x y              - is a ';' missing?
+
+package parser
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/skx/evalfilter/v2/token"
+)
+
+// statements contains the keywords which begin statements, which are
+// those an author might mistype at the start of one.
+var statements = []string{
+	"break",
+	"continue",
+	"else",
+	"for",
+	"foreach",
+	"function",
+	"if",
+	"local",
+	"return",
+	"switch",
+	"while",
+}
+
+// closers contains the tokens which close brackets, and blocks.
+var closers = map[string]bool{
+	")": true,
+	"]": true,
+	"}": true,
+}
+
+// suggest returns a suggestion for fixing the given error, which has
+// just been found, or an empty string if we have none.
+func (p *Parser) suggest(msg string) string {
+
+	// A mistyped keyword, at the start of a statement, usually leads
+	// to a confusing error later on.  When the keyword is taken to be
+	// a function, in `whlie ( x ) { .. }`, or a variable before one,
+	// in `fucntion foo() { .. }`, the block becomes a statement of its
+	// own - so we look at those before it too.
+	for i := len(p.starts) - 1; i >= 0; i-- {
+		start := p.starts[i]
+		if start.Type != token.IDENT {
+			continue
+		}
+		if keyword := nearest(start.Literal); keyword != "" {
+			return fmt.Sprintf("did you mean '%s', rather than '%s'?", keyword, start.Literal)
+		}
+	}
+
+	// An assignment where it doesn't belong.
+	if p.curToken.Type == token.ASSIGN || p.peekToken.Type == token.ASSIGN {
+		if len(p.nesting) > 0 && p.nesting[len(p.nesting)-1] == token.LBRACE {
+			return "did you mean ':'?"
+		}
+		return "did you mean '=='?"
+	}
+
+	// A bracket, or brace, which wasn't closed.
+	for closer := range closers {
+		if strings.HasPrefix(msg, "expected next token to be "+closer+",") {
+			return fmt.Sprintf("is a '%s' missing?", closer)
+		}
+	}
+	if strings.HasPrefix(msg, "incomplete block statement") ||
+		strings.HasPrefix(msg, "unterminated") ||
+		strings.HasPrefix(msg, "unexpected end of file") {
+		return "is a '}' missing?"
+	}
+
+	// Or one too many.
+	if strings.HasPrefix(msg, "no prefix parse function for") && closers[string(p.curToken.Type)] {
+		return fmt.Sprintf("is there an extra '%s'?", p.curToken.Literal)
+	}
+
+	// Two statements which run together.
+	if strings.HasPrefix(msg, "expected semicolon") {
+		return "is a ';' missing?"
+	}
+
+	return ""
+}
+
+// started records the token which begins a statement, keeping the most
+// recent few.
+func (p *Parser) started(tok token.Token) {
+	p.starts = append(p.starts, tok)
+	if len(p.starts) > 3 {
+		p.starts = p.starts[1:]
+	}
+}
+
+// nearest returns the keyword, which begins a statement, that the given
+// identifier is most likely to be a mistyped version of - if any.
+func nearest(ident string) string {
+
+	if len(ident) < 2 {
+		return ""
+	}
+
+	best := ""
+	min := 0
+	for _, keyword := range statements {
+
+		// Short keywords allow a single mistake, longer ones two.
+		limit := 1
+		if len(keyword) > 4 {
+			limit = 2
+		}
+
+		// Nor may a short keyword have a letter replaced, as
+		// `foo` is far more likely to be a variable than `for`.
+		if len(keyword) <= 3 && len(ident) == len(keyword) && !anagram(ident, keyword) {
+			continue
+		}
+
+		d := distance(ident, keyword)
+		if d <= limit && (best == "" || d < min) {
+			best = keyword
+			min = d
+		}
+	}
+	return best
+}
+
+// distance returns the number of edits needed to change one string into
+// the other, where an edit inserts, deletes, or replaces a character, or
+// swaps two adjacent characters.
+func distance(a, b string) int {
+
+	x := []rune(a)
+	y := []rune(b)
+
+	// d[i][j] holds the distance between the first i characters
+	// of x, and the first j characters of y.
+	d := make([][]int, len(x)+1)
+	for i := range d {
+		d[i] = make([]int, len(y)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+
+	for i := 1; i <= len(x); i++ {
+		for j := 1; j <= len(y); j++ {
+			cost := 1
+			if x[i-1] == y[j-1] {
+				cost = 0
+			}
+			d[i][j] = minimum(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && x[i-1] == y[j-2] && x[i-2] == y[j-1] {
+				d[i][j] = minimum(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(x)][len(y)]
+}
+
+// anagram returns true if the given strings contain the same letters.
+func anagram(a, b string) bool {
+	x := []rune(a)
+	y := []rune(b)
+	sort.Slice(x, func(i, j int) bool { return x[i] < x[j] })
+	sort.Slice(y, func(i, j int) bool { return y[i] < y[j] })
+	return string(x) == string(y)
+}
+
+// minimum returns the smallest of the given integers.
+func minimum(values ...int) int {
+	min := values[0]
+	for _, v := range values[1:] {
+		if v < min {
+			min = v
+		}
+	}
+	return min
+}
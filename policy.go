@@ -0,0 +1,211 @@
+// This file contains the policies of a RuleSet, which control how many
+// of its rules are run against each object.
+//
+// Filtering objects inline often only requires knowing whether any rule
+// matched, so there's no need to run the rules which follow the first
+// match.  Similarly many rules begin with the same test, for example:
+//
+//	return Type == "login" && Failures > 10;
+//	return Type == "login" && User == "root";
+//
+// so when that test fails for one of them it fails for all of them, and
+// none need be run.
+
+package evalfilter
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/skx/evalfilter/v2/ast"
+	"github.com/skx/evalfilter/v2/lexer"
+	"github.com/skx/evalfilter/v2/parser"
+)
+
+// Policy describes how many of the rules of a RuleSet are run against
+// each object.
+type Policy int
+
+const (
+	// PolicyAll runs every rule, which is the default.
+	PolicyAll Policy = iota
+
+	// PolicyFirstMatch stops once a rule has matched.
+	PolicyFirstMatch
+
+	// PolicyMatches stops once the number of rules given to
+	// SetPolicy have matched.
+	PolicyMatches
+)
+
+// SetPolicy controls how many of the rules are run against each object.
+//
+// Rules are run in order, and once the policy is satisfied those which
+// remain are returned in the Skipped field of the Result.  The limit is
+// the number of matches PolicyMatches stops after, and is otherwise
+// ignored.
+func (r *RuleSet) SetPolicy(policy Policy, limit int) error {
+
+	switch policy {
+	case PolicyAll:
+		limit = 0
+	case PolicyFirstMatch:
+		limit = 1
+	case PolicyMatches:
+		if limit < 1 {
+			return fmt.Errorf("the limit of matches must be positive, not %d", limit)
+		}
+	default:
+		return fmt.Errorf("unknown policy %d", policy)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.limit = limit
+	return nil
+}
+
+// ErrorPolicy describes what happens when one of the rules of a RuleSet
+// fails whilst it is run against an object.
+type ErrorPolicy int
+
+const (
+	// ErrorsFail stops the run, and returns the error of the rule,
+	// which is the default.
+	ErrorsFail ErrorPolicy = iota
+
+	// ErrorsContinue records the error of the rule in the Errors
+	// field of the Result, and runs the remaining rules.
+	ErrorsContinue
+)
+
+// SetErrorPolicy controls what happens when a rule fails.
+//
+// When the policy is ErrorsContinue a rule which fails isn't a match,
+// so doesn't suppress other rules, and the rules which require it aren't
+// run.
+func (r *RuleSet) SetErrorPolicy(policy ErrorPolicy) error {
+
+	switch policy {
+	case ErrorsFail, ErrorsContinue:
+	default:
+		return fmt.Errorf("unknown error policy %d", policy)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.errors = policy
+	return nil
+}
+
+// prefixOf returns the first clause of the given program, if it consists
+// of a single return statement whose value is a chain of `&&` operators,
+// and that clause has no side-effects.
+func prefixOf(program *ast.Program) ast.Expression {
+
+	if program == nil || len(program.Statements) != 1 {
+		return nil
+	}
+	ret, ok := program.Statements[0].(*ast.ReturnStatement)
+	if !ok || ret.ReturnValue == nil {
+		return nil
+	}
+
+	clause := flatten(ret.ReturnValue, "&&")[0]
+	if !pure(clause) {
+		return nil
+	}
+	return clause
+}
+
+// prefix returns the key of the clause which begins the given rule, a
+// script which evaluates that clause alone, and the identifiers within
+// it, if the result of the clause may be shared with the other rules
+// which begin with it.
+//
+// A variable may hold a different value for each rule, so the rule must
+// not share the clause if one of the identifiers becomes a variable once
+// it has been added, as found by variables.
+func prefix(eval *Eval) (string, *Eval, []string) {
+
+	if eval.prefix == "" || eval.statistics || eval.explain {
+		return "", nil, nil
+	}
+
+	// The clause must survive being written out as a script, which
+	// isn't true of every string.
+	script := "return " + eval.prefix + ";"
+	l := lexer.New(script)
+	l.SetSQLKeywords(eval.sql)
+	program, err := parser.New(l).Parse()
+	if err != nil {
+		return "", nil, nil
+	}
+	clause := prefixOf(program)
+	if clause == nil || clause.String() != eval.prefix {
+		return "", nil, nil
+	}
+
+	counts := make(map[string]int)
+	prefixNames(clause, counts)
+	var names []string
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if eval.variables(names) {
+		return "", nil, nil
+	}
+
+	// The clause is no longer preceded by any lang pragma, so it
+	// needs to be told which version of the language the rule uses.
+	lang := eval.Language()
+	p := eval.Clone()
+	p.Script = script
+	if p.SetLanguage(lang) != nil || p.Prepare() != nil {
+		return "", nil, nil
+	}
+
+	// The way fields are presented, and the version of the language,
+	// change the result.
+	key := fmt.Sprintf("%t %d %s %d %s", eval.stringerFields, eval.mode, eval.fieldTag, lang, eval.prefix)
+	return key, p, names
+}
+
+// variables returns true if any of the given names is a variable of the
+// script, such as one set via SetVariable.
+func (e *Eval) variables(names []string) bool {
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	for _, name := range names {
+		if _, ok := e.environment.Get(name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// prefixNames counts the identifiers within a clause found by prefixOf.
+func prefixNames(node ast.Expression, names map[string]int) {
+
+	switch n := node.(type) {
+	case *ast.Identifier:
+		names[n.Value]++
+	case *ast.ArrayLiteral:
+		for _, el := range n.Elements {
+			prefixNames(el, names)
+		}
+	case *ast.PrefixExpression:
+		prefixNames(n.Right, names)
+	case *ast.IndexExpression:
+		prefixNames(n.Left, names)
+		prefixNames(n.Index, names)
+	case *ast.InfixExpression:
+		prefixNames(n.Left, names)
+		prefixNames(n.Right, names)
+	}
+}
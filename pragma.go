@@ -0,0 +1,237 @@
+// This file contains the handling of pragmas, which are directives
+// placed within the comments of a script.
+//
+// Pragmas are comments, so scripts which contain them may still be
+// used by older releases.  The loop-limit pragma overrides the number of
+// iterations a loop may make:
+//
+//	// pragma loop-limit 5000
+//	while ( true ) { ... }
+//
+// A pragma applies to the loops which follow it, until it is replaced
+// by another, so placing one at the start of a script sets the limit
+// for the whole script.
+//
+// The requires, and suppresses, pragmas describe how the script relates
+// to the other rules of a RuleSet, wherever they're placed:
+//
+//	// pragma requires "failed-login"
+//	// pragma suppresses "single-failure" "noisy-host"
+//
+// Names which contain spaces must be quoted, whilst others may be.  The
+// guard pragma allows a RuleSet to find the rules which might match an
+// object, as described in guard.go, and the lang pragma declares the
+// version of the language the script was written for, as described in
+// language.go:
+//
+//	// pragma lang 2
+
+package evalfilter
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/skx/evalfilter/v2/code"
+	"github.com/skx/evalfilter/v2/vm"
+	"strings"
+)
+
+// pragmaRegexp matches the comments which contain pragmas.
+var pragmaRegexp = regexp.MustCompile(`^\s*//\s*pragma\s+(.*?)\s*$`)
+
+// pragma holds a single pragma we found within our script.
+type pragma struct {
+
+	// line holds the line upon which the pragma was found.
+	line int
+
+	// loopLimit holds the number of iterations loops may make.
+	loopLimit int
+
+	// requires holds the names of the rules which must match before
+	// the script is run, within a RuleSet.
+	requires []string
+
+	// suppresses holds the names of the rules which aren't run once
+	// the script has matched, within a RuleSet.
+	suppresses []string
+
+	// guard holds the guard of the script, within a RuleSet.
+	guard *guard
+
+	// language holds the version of the language the script was
+	// written for.
+	language int
+}
+
+// pragmaError returns the error for a pragma, upon the given line, which
+// could not be parsed.
+func pragmaError(line int, format string, args ...interface{}) error {
+	return &ParseError{
+		Phase:    "parse",
+		Position: code.Position{Line: line},
+		Message:  fmt.Sprintf("line %d: ", line) + fmt.Sprintf(format, args...),
+	}
+}
+
+// parsePragmas finds the pragmas within the given script.
+func parsePragmas(script string) ([]pragma, error) {
+
+	var out []pragma
+
+	for i, line := range strings.Split(script, "\n") {
+
+		m := pragmaRegexp.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+
+		fields := strings.Fields(m[1])
+		if len(fields) == 0 {
+			return nil, pragmaError(i+1, "the pragma has no name")
+		}
+
+		switch fields[0] {
+		case "requires", "suppresses":
+			names, err := pragmaNames(strings.TrimPrefix(m[1], fields[0]))
+			if err != nil {
+				return nil, pragmaError(i+1, "the %s pragma %s", fields[0], err)
+			}
+			if len(names) == 0 {
+				return nil, pragmaError(i+1, "the %s pragma requires the name of a rule", fields[0])
+			}
+			p := pragma{line: i + 1}
+			if fields[0] == "requires" {
+				p.requires = names
+			} else {
+				p.suppresses = names
+			}
+			out = append(out, p)
+		case "guard":
+			g, err := parseGuard(strings.TrimSpace(strings.TrimPrefix(m[1], fields[0])))
+			if err != nil {
+				return nil, pragmaError(i+1, "the guard pragma %s", err)
+			}
+			for _, p := range out {
+				if p.guard != nil {
+					return nil, pragmaError(i+1, "the script already has a guard, upon line %d", p.line)
+				}
+			}
+			out = append(out, pragma{line: i + 1, guard: g})
+		case "lang":
+			if len(fields) != 2 {
+				return nil, pragmaError(i+1, "the lang pragma requires a single argument")
+			}
+			n, err := strconv.Atoi(fields[1])
+			if err != nil || n < vm.Language1 || n > vm.LatestLanguage {
+				return nil, pragmaError(i+1, "the lang pragma requires a version between %d and %d, not %s", vm.Language1, vm.LatestLanguage, fields[1])
+			}
+			for _, p := range out {
+				if p.language != 0 {
+					return nil, pragmaError(i+1, "the script already declares its language, upon line %d", p.line)
+				}
+			}
+			out = append(out, pragma{line: i + 1, language: n})
+		case "loop-limit":
+			if len(fields) != 2 {
+				return nil, pragmaError(i+1, "the loop-limit pragma requires a single argument")
+			}
+			n, err := strconv.Atoi(fields[1])
+			if err != nil || n < 1 {
+				return nil, pragmaError(i+1, "the loop-limit pragma requires a positive integer, not %s", fields[1])
+			}
+			out = append(out, pragma{line: i + 1, loopLimit: n})
+		default:
+			return nil, pragmaError(i+1, "unknown pragma %s", fields[0])
+		}
+	}
+
+	return out, nil
+}
+
+// pragmaNames returns the names of the rules given to a pragma, which
+// are separated by spaces, and may be quoted.
+func pragmaNames(args string) ([]string, error) {
+
+	var out []string
+
+	for {
+		args = strings.TrimSpace(args)
+		if args == "" {
+			return out, nil
+		}
+
+		if args[0] != '"' {
+			end := strings.IndexAny(args, " \t")
+			if end < 0 {
+				end = len(args)
+			}
+			out = append(out, args[:end])
+			args = args[end:]
+			continue
+		}
+
+		// Find the closing quote, which isn't escaped.
+		end := -1
+		for i := 1; i < len(args); i++ {
+			if args[i] == '\\' {
+				i++
+				continue
+			}
+			if args[i] == '"' {
+				end = i
+				break
+			}
+		}
+		if end < 0 {
+			return nil, fmt.Errorf("has an unterminated name")
+		}
+		name, err := strconv.Unquote(args[:end+1])
+		if err != nil {
+			return nil, fmt.Errorf("has an invalid name %s", args[:end+1])
+		}
+		out = append(out, name)
+		args = args[end+1:]
+	}
+}
+
+// Requires returns the names of the rules which the script's pragmas say
+// must match before it is run, within a RuleSet.
+//
+// This is only available once the script has been prepared.
+func (e *Eval) Requires() []string {
+
+	var out []string
+	for _, p := range e.pragmas {
+		out = append(out, p.requires...)
+	}
+	return out
+}
+
+// Suppresses returns the names of the rules which the script's pragmas
+// say aren't to be run once it has matched, within a RuleSet.
+//
+// This is only available once the script has been prepared.
+func (e *Eval) Suppresses() []string {
+
+	var out []string
+	for _, p := range e.pragmas {
+		out = append(out, p.suppresses...)
+	}
+	return out
+}
+
+// loopLimit returns the limit set by the pragmas for a loop beginning
+// upon the given line, or zero if there is none.
+func (e *Eval) loopLimit(line int) int {
+
+	limit := 0
+	for _, p := range e.pragmas {
+		if p.line < line && p.loopLimit > 0 {
+			limit = p.loopLimit
+		}
+	}
+	return limit
+}
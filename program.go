@@ -0,0 +1,171 @@
+// This file contains the Program and Session types, which allow a script
+// to be compiled once and then run by many goroutines at the same time.
+//
+// An Eval holds both the compiled script and the state used whilst it
+// runs, so its runs are serialized by a mutex.  A Program holds only the
+// former, and can't be modified once it has been created, whilst each
+// Session holds the latter.  Goroutines which each create their own
+// session may run the same program concurrently, without any locking.
+
+package evalfilter
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/skx/evalfilter/v2/environment"
+	"github.com/skx/evalfilter/v2/object"
+	"github.com/skx/evalfilter/v2/vm"
+)
+
+// Program holds a compiled script, along with the settings, functions,
+// and variables, of the Eval it was created from.
+//
+// A Program can't be modified, so it is safe for concurrent use.  To
+// run it create a Session via NewSession.
+type Program struct {
+	// script holds the source of the script.
+	script string
+
+	// environment holds the functions and variables each session
+	// starts with.
+	environment *environment.Environment
+
+	// machine holds the compiled script, and our settings.  It is
+	// never run itself, only cloned.
+	machine *vm.VM
+}
+
+// Session holds the state used to run a Program, such as its variables.
+//
+// A Session must only be used by one goroutine at a time, though any
+// number of sessions may run the same program concurrently.
+type Session struct {
+	// environment holds our functions and variables.
+	environment *environment.Environment
+
+	// machine is the machine which runs the program.
+	machine *vm.VM
+}
+
+// Program returns the compiled script, which may be shared between
+// goroutines, once Prepare has been invoked.
+//
+// The program captures the current settings, functions, and variables;
+// changes made to the Eval afterwards don't affect it.  Recorders aren't
+// captured, as they can't be shared, but may be given to each session.
+func (e *Eval) Program() (*Program, error) {
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if e.machine == nil {
+		return nil, fmt.Errorf("the script must be prepared before a program is created")
+	}
+
+	env := e.environment.Copy()
+	return &Program{
+		script:      e.Script,
+		environment: env,
+		machine:     e.machine.Clone(env),
+	}, nil
+}
+
+// Script returns the source of the script the program was compiled from.
+func (p *Program) Script() string {
+	return p.script
+}
+
+// NewSession returns a new session for running the program.
+//
+// Each session starts with its own copy of the program's variables, so
+// the changes made by one session are never seen by another.
+func (p *Program) NewSession() *Session {
+
+	env := p.environment.Copy()
+	return &Session{
+		environment: env,
+		machine:     p.machine.Clone(env),
+	}
+}
+
+// SetContext allows a context to be used by the session's runs, to setup
+// a timeout/deadline.
+func (s *Session) SetContext(ctx context.Context) {
+	s.machine.SetContext(ctx)
+}
+
+// SetRecorder allows the calls made to host functions by the session's
+// runs to be recorded, or replayed.  See Eval.SetRecorder for details.
+func (s *Session) SetRecorder(r *vm.Recorder) {
+	s.machine.SetRecorder(r)
+}
+
+// SetTracer allows the instructions executed by the session's runs to be
+// recorded.  See Eval.SetTracer for details.
+func (s *Session) SetTracer(t *vm.Tracer) {
+	s.machine.SetTracer(t)
+}
+
+// SetVariable adds, or updates a variable which will be available
+// to the script.
+func (s *Session) SetVariable(name string, value object.Object) {
+	s.environment.Set(name, value)
+}
+
+// SetVariableInt sets the value of a variable to the given integer.
+func (s *Session) SetVariableInt(name string, value int64) {
+	s.environment.SetInteger(name, value)
+}
+
+// SetVariableFloat sets the value of a variable to the given float.
+func (s *Session) SetVariableFloat(name string, value float64) {
+	s.environment.SetFloat(name, value)
+}
+
+// SetVariableString sets the value of a variable to the given string.
+func (s *Session) SetVariableString(name string, value string) {
+	s.environment.SetString(name, value)
+}
+
+// SetVariableBool sets the value of a variable to the given boolean.
+func (s *Session) SetVariableBool(name string, value bool) {
+	s.environment.SetBoolean(name, value)
+}
+
+// GetVariable retrieves the contents of a variable which has been
+// set within the script.
+//
+// If the variable hasn't been set then the null-value will be returned.
+func (s *Session) GetVariable(name string) object.Object {
+	s.environment.Disown()
+
+	value, ok := s.environment.Get(name)
+	if ok {
+		return value
+	}
+	return object.Nil
+}
+
+// Execute runs the program against the given object, and returns the
+// object that the script finished with.
+func (s *Session) Execute(obj interface{}) (object.Object, error) {
+	return execute(s.machine, s.machine.Run, obj)
+}
+
+// True returns whether the given object, such as the result of Execute,
+// is true according to the version of the language the program uses.
+func (s *Session) True(obj object.Object) bool {
+	return s.machine.True(obj)
+}
+
+// Run runs the program against the given object, and returns whether
+// the result of the script was true.
+func (s *Session) Run(obj interface{}) (bool, error) {
+
+	out, err := s.Execute(obj)
+	if err != nil {
+		return false, err
+	}
+	return s.True(out), nil
+}
@@ -0,0 +1,133 @@
+package evalfilter
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/skx/evalfilter/v2/object"
+)
+
+// TestProgram tests that programs may be run by concurrent sessions.
+func TestProgram(t *testing.T) {
+
+	_, err := New(`return true;`).Program()
+	if err == nil || !strings.Contains(err.Error(), "prepared") {
+		t.Fatalf("expected an error, got %v", err)
+	}
+
+	eval := New(`
+total = 0;
+foreach item in Items { total = total + item; }
+Count++;
+Seen[Name] = total;
+return double(total) == Limit && Count == 11;
+`)
+	eval.AddFunction("double", func(args []object.Object) object.Object {
+		return &object.Integer{Value: args[0].(*object.Integer).Value * 2}
+	})
+	eval.SetVariable("Count", &object.Integer{Value: 10})
+	eval.SetVariable("Seen", &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)})
+	err = eval.Prepare()
+	if err != nil {
+		t.Fatalf("failed to compile: %s", err)
+	}
+
+	program, err := eval.Program()
+	if err != nil {
+		t.Fatalf("failed to create the program: %s", err)
+	}
+	if program.Script() != eval.Script {
+		t.Fatalf("unexpected script: %s", program.Script())
+	}
+
+	// Later changes to the Eval don't affect the program.
+	eval.SetVariable("Count", &object.Integer{Value: 100})
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			s := program.NewSession()
+			s.SetVariableInt("Limit", int64(2*(i+1)*(i+2)))
+			for j := 0; j < 100; j++ {
+				items := make([]int, i+1)
+				for k := range items {
+					items[k] = 2 * (k + 1)
+				}
+				ok, err := s.Run(map[string]interface{}{"Items": items, "Name": "test"})
+				if err != nil {
+					errs <- err
+					return
+				}
+
+				// Count is only incremented once, as each
+				// run begins with the session's value.
+				if ok != (j == 0) {
+					errs <- fmt.Errorf("unexpected result for session %d, run %d: %v", i, j, ok)
+					return
+				}
+			}
+			if s.GetVariable("Count").Inspect() != "110" {
+				errs <- fmt.Errorf("unexpected count %s", s.GetVariable("Count").Inspect())
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("%s", err)
+	}
+
+	// The variables of the Eval, and of the program, are untouched.
+	if eval.GetVariable("Count").Inspect() != "100" {
+		t.Fatalf("the Eval's variables were modified")
+	}
+	s := program.NewSession()
+	if s.GetVariable("Count").Inspect() != "10" || s.GetVariable("Seen").Inspect() != "{}" {
+		t.Fatalf("the program's variables were modified: %s %s", s.GetVariable("Count").Inspect(), s.GetVariable("Seen").Inspect())
+	}
+}
+
+// TestProgramPredicate tests that predicates may be run by concurrent
+// sessions.
+func TestProgramPredicate(t *testing.T) {
+
+	eval := New(`return Name == "steve" && Age >= Min;`)
+	err := eval.Prepare()
+	if err != nil {
+		t.Fatalf("failed to compile: %s", err)
+	}
+	program, err := eval.Program()
+	if err != nil {
+		t.Fatalf("failed to create the program: %s", err)
+	}
+
+	var wg sync.WaitGroup
+	failed := make(chan bool, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			s := program.NewSession()
+			s.SetVariableInt("Min", int64(i))
+			for age := 0; age < 100; age++ {
+				ok, err := s.Run(map[string]interface{}{"Name": "steve", "Age": age})
+				if err != nil || ok != (age >= i) {
+					failed <- true
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(failed)
+	if <-failed {
+		t.Fatalf("unexpected result")
+	}
+}
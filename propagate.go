@@ -0,0 +1,293 @@
+// This file contains the propagation of constants, which takes place when
+// the optimizer is enabled.
+//
+// Scripts often give names to the values they use, for example:
+//
+//    threshold = 100;
+//    if ( Count > threshold ) { return true; }
+//
+// If a variable is assigned a literal value by a statement at the top of
+// the script, and is never changed by anything else, then each reference
+// to it which follows that statement is compiled as if the literal itself
+// had been written.  That allows expressions involving the variable to be
+// folded, just as they would be had the value been written inline.
+//
+// References within functions are left alone, as a function might be
+// called before the assignment has been made.
+
+package evalfilter
+
+import (
+	"strings"
+
+	"github.com/skx/evalfilter/v2/ast"
+	"github.com/skx/evalfilter/v2/vm"
+)
+
+// findConstants examines the given program, and records the top-level
+// assignments whose values may be propagated.
+func (e *Eval) findConstants(program *ast.Program) {
+
+	e.propagated = make(map[*ast.AssignStatement]bool)
+	e.known = make(map[string]ast.Expression)
+
+	// When the object may be modified an assignment to one of
+	// its fields updates that field, which might store the value
+	// differently.
+	if e.mode == vm.EventReadWrite {
+		return
+	}
+
+	// Count the number of places each name is changed.  If we find
+	// something we don't understand then we can't be sure, so we
+	// propagate nothing.
+	counts := make(map[string]int)
+	if !writes(program, counts) {
+		return
+	}
+
+	for _, s := range program.Statements {
+		assign := assignment(s)
+		if assign == nil || assign.Name == nil || assign.Target != nil {
+			continue
+		}
+		// The legacy "$" prefix is only removed when variables are
+		// looked up, so `$name = 1` doesn't set `name`.
+		name := assign.Name.Value
+		if name == variable(name) && counts[name] == 1 && literal(assign.Value) {
+			e.propagated[assign] = true
+		}
+	}
+}
+
+// propagate records the value assigned by the given top-level statement,
+// if it is one which is propagated.  References compiled afterwards will
+// use the value directly.
+func (e *Eval) propagate(stmt ast.Statement) {
+
+	if assign := assignment(stmt); assign != nil && e.propagated[assign] {
+		e.known[variable(assign.Name.Value)] = assign.Value
+	}
+}
+
+// assignment returns the assignment made by the given statement, if it
+// is one.
+func assignment(stmt ast.Statement) *ast.AssignStatement {
+
+	if s, ok := stmt.(*ast.ExpressionStatement); ok {
+		if assign, ok := s.Expression.(*ast.AssignStatement); ok {
+			return assign
+		}
+	}
+	return nil
+}
+
+// variable returns the name of the variable the given name refers to.
+func variable(name string) string {
+	return strings.TrimPrefix(name, "$")
+}
+
+// literal returns true if the given expression is a literal number,
+// string, or boolean.
+func literal(node ast.Expression) bool {
+
+	switch n := node.(type) {
+	case *ast.BooleanLiteral, *ast.FloatLiteral, *ast.IntegerLiteral, *ast.StringLiteral:
+		return true
+	case *ast.PrefixExpression:
+		switch n.Right.(type) {
+		case *ast.FloatLiteral, *ast.IntegerLiteral:
+			return n.Operator == "-"
+		}
+	}
+	return false
+}
+
+// writes counts the number of places within the given node which might
+// change each variable.
+//
+// This function returns false if the node contains something which we
+// don't recognize.
+func writes(node ast.Node, counts map[string]int) bool {
+
+	// change counts every identifier within the given expression,
+	// which is the target of a change.
+	change := func(target ast.Node) {
+		identifiers(target, counts)
+	}
+
+	switch n := node.(type) {
+	case nil:
+		return true
+
+	case *ast.Program:
+		for _, s := range n.Statements {
+			if !writes(s, counts) {
+				return false
+			}
+		}
+		return true
+
+	case *ast.BlockStatement:
+		if n == nil {
+			return true
+		}
+		for _, s := range n.Statements {
+			if !writes(s, counts) {
+				return false
+			}
+		}
+		return true
+
+	case *ast.ExpressionStatement:
+		return writes(n.Expression, counts)
+
+	case *ast.ReturnStatement:
+		return writes(n.ReturnValue, counts)
+
+	case *ast.BreakStatement, *ast.ContinueStatement,
+		*ast.BooleanLiteral, *ast.FloatLiteral, *ast.IntegerLiteral,
+		*ast.StringLiteral, *ast.RegexpLiteral, *ast.Identifier:
+		return true
+
+	case *ast.ArrayLiteral:
+		return writesAll(n.Elements, counts)
+
+	case *ast.HashPattern:
+		change(n)
+		return true
+
+	case *ast.HashLiteral:
+		for k, v := range n.Pairs {
+			if !writes(k, counts) || !writes(v, counts) {
+				return false
+			}
+		}
+		return true
+
+	case *ast.IndexExpression:
+		return writes(n.Left, counts) && writes(n.Index, counts)
+
+	case *ast.SliceExpression:
+		return writes(n.Left, counts) && writes(n.Start, counts) && writes(n.End, counts)
+
+	case *ast.PrefixExpression:
+		return writes(n.Right, counts)
+
+	case *ast.InfixExpression:
+		if _, ok := mutators[n.Operator]; ok {
+			change(n.Left)
+		}
+		return writes(n.Left, counts) && writes(n.Right, counts)
+
+	case *ast.PostfixExpression:
+		if n.Target != nil {
+			change(n.Target)
+			return writes(n.Target, counts)
+		}
+		counts[variable(n.Token.Literal)]++
+		return true
+
+	case *ast.LocalVariable:
+		counts[variable(n.Token.Literal)]++
+		return true
+
+	case *ast.AssignStatement:
+		if n.Target != nil {
+			change(n.Target)
+			if !writes(n.Target, counts) {
+				return false
+			}
+		}
+		if n.Name != nil {
+			counts[variable(n.Name.Value)]++
+		}
+		return writes(n.Value, counts)
+
+	case *ast.ForeachStatement:
+		counts[variable(n.Index)]++
+		counts[variable(n.Ident)]++
+		return writes(n.Value, counts) && writes(n.Body, counts)
+
+	case *ast.FunctionDefinition:
+		for _, p := range n.Parameters {
+			counts[variable(p.Value)]++
+		}
+		return writes(n.Body, counts)
+
+	case *ast.FunctionLiteral:
+		for _, p := range n.Parameters {
+			counts[variable(p.Value)]++
+		}
+		return writes(n.Body, counts)
+
+	case *ast.IfExpression:
+		return writes(n.Condition, counts) && writes(n.Consequence, counts) && writes(n.Alternative, counts)
+
+	case *ast.TernaryExpression:
+		return writes(n.Condition, counts) && writes(n.IfTrue, counts) && writes(n.IfFalse, counts)
+
+	case *ast.SwitchExpression:
+		if !writes(n.Value, counts) {
+			return false
+		}
+		for _, c := range n.Choices {
+			if !writesAll(c.Expr, counts) || !writes(c.Block, counts) {
+				return false
+			}
+		}
+		return true
+
+	case *ast.MatchExpression:
+		if !writes(n.Value, counts) {
+			return false
+		}
+		for _, arm := range n.Arms {
+			change(arm.Pattern)
+			if !writes(arm.Block, counts) {
+				return false
+			}
+		}
+		return true
+
+	case *ast.WhileStatement:
+		return writes(n.Condition, counts) && writes(n.Body, counts)
+
+	case *ast.CallExpression:
+		return writes(n.Function, counts) && writesAll(n.Arguments, counts)
+	}
+
+	return false
+}
+
+// writesAll invokes writes upon each of the given expressions.
+func writesAll(nodes []ast.Expression, counts map[string]int) bool {
+	for _, n := range nodes {
+		if !writes(n, counts) {
+			return false
+		}
+	}
+	return true
+}
+
+// identifiers counts each identifier within the given expression, which
+// might be an array, a hash, or a member of either.
+func identifiers(node ast.Node, counts map[string]int) {
+
+	switch n := node.(type) {
+	case *ast.Identifier:
+		counts[variable(n.Value)]++
+	case *ast.ArrayLiteral:
+		for _, el := range n.Elements {
+			identifiers(el, counts)
+		}
+	case *ast.HashPattern:
+		for _, v := range n.Values {
+			identifiers(v, counts)
+		}
+	case *ast.IndexExpression:
+		identifiers(n.Left, counts)
+	case *ast.InfixExpression:
+		identifiers(n.Left, counts)
+	}
+}
@@ -0,0 +1,34 @@
+// Package protoadapter allows protocol buffer messages to be used as the
+// input to our scripts, without converting them to JSON first.
+//
+// The fields of a message are named as they are in its descriptor, i.e.
+// the names used in the `.proto` file rather than those of the generated
+// Go structure, so given the message:
+//
+//	message Request {
+//	  string user_id = 1;
+//	  Status status  = 2;
+//	  repeated string tags = 3;
+//	  map<string, string> labels = 4;
+//	}
+//
+// a script could test `status == "ACTIVE" && user_id != ""`.
+//
+// Nested messages become hashes, repeated fields become arrays, maps
+// become hashes keyed by the string form of their keys, and enumerations
+// are presented by the name of their value.  Bytes fields are presented
+// as strings, and `google.protobuf.Timestamp` messages as time values.
+//
+// All the fields of a message are visible to the script, so that unset
+// fields have their default values, with the exception of unset message
+// fields, which are null, and the unset members of a oneof, which are
+// absent.
+//
+// As this package depends upon google.golang.org/protobuf it is a module
+// of its own, which is only built if the `protobuf` tag is specified, so
+// that users of the main package don't need the dependency.  To use it
+// add the module to your go.mod, and build with:
+//
+//	go get github.com/skx/evalfilter/v2/protoadapter
+//	go build -tags protobuf
+package protoadapter
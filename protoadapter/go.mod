@@ -0,0 +1,10 @@
+module github.com/skx/evalfilter/v2/protoadapter
+
+go 1.23
+
+require (
+	github.com/skx/evalfilter/v2 v2.0.0
+	google.golang.org/protobuf v1.36.12
+)
+
+replace github.com/skx/evalfilter/v2 => ../
@@ -0,0 +1,135 @@
+//go:build protobuf
+// +build protobuf
+
+package protoadapter
+
+import (
+	"time"
+
+	"github.com/skx/evalfilter/v2"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Run runs the prepared script against the given message, and returns
+// the result.
+func Run(eval *evalfilter.Eval, msg proto.Message) (bool, error) {
+	return eval.Run(ToMap(msg))
+}
+
+// ToMap converts the given message to a map, which may be passed to Run,
+// or merged with other fields before doing so.
+func ToMap(msg proto.Message) map[string]interface{} {
+	if msg == nil {
+		return make(map[string]interface{})
+	}
+	return message(msg.ProtoReflect())
+}
+
+// message converts a message to a map, keyed by the names of its fields.
+func message(m protoreflect.Message) map[string]interface{} {
+
+	out := make(map[string]interface{})
+	if !m.IsValid() {
+		return out
+	}
+
+	fields := m.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+
+		// Only the member of a oneof which is set is present.
+		if fd.ContainingOneof() != nil && !m.Has(fd) {
+			continue
+		}
+
+		// Unset messages are null, rather than empty.
+		if fd.Message() != nil && !fd.IsList() && !fd.IsMap() && !m.Has(fd) {
+			out[string(fd.Name())] = nil
+			continue
+		}
+
+		out[string(fd.Name())] = field(fd, m.Get(fd))
+	}
+
+	return out
+}
+
+// field converts the value of a field.
+func field(fd protoreflect.FieldDescriptor, val protoreflect.Value) interface{} {
+
+	if fd.IsList() {
+		list := val.List()
+		out := make([]interface{}, list.Len())
+		for i := 0; i < list.Len(); i++ {
+			out[i] = scalar(fd, list.Get(i))
+		}
+		return out
+	}
+
+	if fd.IsMap() {
+		out := make(map[string]interface{})
+		val.Map().Range(func(k protoreflect.MapKey, v protoreflect.Value) bool {
+			out[k.String()] = scalar(fd.MapValue(), v)
+			return true
+		})
+		return out
+	}
+
+	return scalar(fd, val)
+}
+
+// scalar converts a single value, which might be a member of a list or a
+// map, of the field's kind.
+func scalar(fd protoreflect.FieldDescriptor, val protoreflect.Value) interface{} {
+
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return val.Bool()
+
+	case protoreflect.EnumKind:
+		// Values we don't know the name of are left as numbers.
+		n := val.Enum()
+		if ev := fd.Enum().Values().ByNumber(n); ev != nil {
+			return string(ev.Name())
+		}
+		return int64(n)
+
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return val.Int()
+
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind,
+		protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return val.Uint()
+
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		return val.Float()
+
+	case protoreflect.StringKind:
+		return val.String()
+
+	case protoreflect.BytesKind:
+		return string(val.Bytes())
+
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		m := val.Message()
+		if m.Descriptor().FullName() == "google.protobuf.Timestamp" {
+			return timestamp(m)
+		}
+		return message(m)
+	}
+
+	return val.Interface()
+}
+
+// timestamp converts a google.protobuf.Timestamp message to a time.
+//
+// The fields are read via reflection, so that we don't depend upon the
+// package containing the generated code.
+func timestamp(m protoreflect.Message) time.Time {
+	fields := m.Descriptor().Fields()
+	secs := m.Get(fields.ByName("seconds")).Int()
+	nanos := m.Get(fields.ByName("nanos")).Int()
+	return time.Unix(secs, nanos).UTC()
+}
@@ -0,0 +1,105 @@
+//go:build protobuf
+// +build protobuf
+
+package protoadapter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/skx/evalfilter/v2"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// TestRun tests running scripts against messages.
+//
+// We use the messages which describe `.proto` files, as they contain
+// nested messages, repeated fields, and enumerations, without requiring
+// any generated code of our own.
+func TestRun(t *testing.T) {
+
+	file := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("people.proto"),
+		Dependency: []string{"a.proto", "b.proto"},
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name: proto.String("Person"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					{
+						Name:   proto.String("names"),
+						Number: proto.Int32(1),
+						Label:  descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum(),
+						Type:   descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+					},
+				},
+			},
+		},
+	}
+
+	st, err := structpb.NewStruct(map[string]interface{}{
+		"name": "steve",
+		"age":  42,
+	})
+	if err != nil {
+		t.Fatalf("failed to create struct: %s", err)
+	}
+
+	tests := []struct {
+		msg    proto.Message
+		input  string
+		result bool
+	}{
+		{file, `return name == "people.proto";`, true},
+		{file, `return len(dependency) == 2 && dependency[1] == "b.proto";`, true},
+		{file, `return message_type[0].name == "Person";`, true},
+		{file, `return message_type[0].field[0].number == 1;`, true},
+		{file, `return message_type[0].field[0].label == "LABEL_REPEATED";`, true},
+		{file, `return message_type[0].field[0].type == "TYPE_STRING";`, true},
+
+		// Unset fields have their default values, except messages.
+		{file, `return syntax == "" && len(enum_type) == 0;`, true},
+		{file, `return type(options) == "null";`, true},
+
+		// Maps, and oneofs.
+		{st, `return fields.name.string_value == "steve";`, true},
+		{st, `return fields.age.number_value == 42;`, true},
+		{st, `return type(fields.age.string_value) == "null";`, true},
+		{st, `return len(keys(fields)) == 2;`, true},
+	}
+
+	for _, test := range tests {
+
+		eval := evalfilter.New(test.input)
+		err := eval.Prepare()
+		if err != nil {
+			t.Fatalf("failed to compile %s: %s", test.input, err)
+		}
+
+		res, err := Run(eval, test.msg)
+		if err != nil {
+			t.Fatalf("error running %s: %s", test.input, err)
+		}
+		if res != test.result {
+			t.Fatalf("wrong result for %s, got %t expected %t", test.input, res, test.result)
+		}
+	}
+
+	// A nil message has no fields.
+	if len(ToMap(nil)) != 0 {
+		t.Fatalf("expected no fields for a nil message")
+	}
+}
+
+// TestTimestamp tests that timestamps are converted to times.
+func TestTimestamp(t *testing.T) {
+
+	now := time.Date(2020, time.March, 4, 5, 6, 7, 8, time.UTC)
+
+	out := timestamp(timestamppb.New(now).ProtoReflect())
+	if !out.Equal(now) {
+		t.Fatalf("wrong time, got %s expected %s", out, now)
+	}
+}
@@ -0,0 +1,454 @@
+// This file contains the quotas of the rules within a RuleSet, which
+// limit the resources each rule, and each tenant, may use.
+//
+// A set may hold the rules of many tenants, each within a namespace of
+// their own such as "acme/bruteforce".  A quota limits the instructions
+// a rule may execute, and the time it may take, whenever it is run, and
+// a tenant's quota limits those of all the rules in its namespace when
+// the set is run against an object.  A rule which exceeds a quota isn't
+// a match, and doesn't cause the set to fail, so that one pathological
+// rule can't harm the others.
+//
+// Rules, and tenants, which repeatedly exceed their quotas are tripped,
+// like a circuit breaker, and aren't run again until their cooldown has
+// passed - or until they're enabled again via Enable.
+
+package evalfilter
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/skx/evalfilter/v2/object"
+	"github.com/skx/evalfilter/v2/vm"
+)
+
+// Quota describes the resources a rule, or a tenant, may use.
+type Quota struct {
+
+	// Instructions holds the number of instructions which may be
+	// executed, or zero if there is no limit.
+	Instructions int
+
+	// Time holds the time which may be taken, or zero if there is
+	// no limit.
+	Time time.Duration
+
+	// Failures holds the number of consecutive runs which may exceed
+	// the quota before the rule, or tenant, is tripped.  Zero is the
+	// same as one, so that the first failure trips it.
+	Failures int
+
+	// Cooldown holds the time for which a rule, or tenant, which is
+	// tripped isn't run.  If it is zero it isn't run again until it
+	// is enabled via Enable.
+	Cooldown time.Duration
+}
+
+// Trip describes a rule, or tenant, which was tripped, as it exceeded
+// its quota.
+type Trip struct {
+
+	// Rule holds the name of the rule which was tripped, or the empty
+	// string if it was a tenant.
+	Rule string
+
+	// Tenant holds the namespace of the tenant which was tripped, or
+	// the empty string if it was a rule.
+	Tenant string
+
+	// Err holds the error of the run which exceeded the quota.
+	Err error
+
+	// Until holds the time at which the cooldown ends, which is the
+	// zero time if it lasts until Enable is invoked.
+	Until time.Time
+}
+
+// breaker holds a quota, along with the state of its circuit breaker.
+type breaker struct {
+	quota    Quota
+	failures int
+	tripped  bool
+	until    time.Time
+}
+
+// open returns true if the breaker was tripped, and hasn't cooled down.
+func (b *breaker) open(now time.Time) bool {
+	return b.tripped && (b.until.IsZero() || now.Before(b.until))
+}
+
+// fail records a run which exceeded the quota, and returns true if that
+// trips the breaker.
+func (b *breaker) fail(now time.Time) bool {
+
+	b.failures++
+	if b.failures < b.quota.Failures {
+		return false
+	}
+
+	b.failures = 0
+	b.tripped = true
+	b.until = time.Time{}
+	if b.quota.Cooldown > 0 {
+		b.until = now.Add(b.quota.Cooldown)
+	}
+	return true
+}
+
+// pass records a run which kept within the quota.
+func (b *breaker) pass() {
+	b.failures = 0
+	b.tripped = false
+}
+
+// validQuota returns an error if the given quota is invalid.
+func validQuota(q Quota) error {
+
+	if q.Instructions < 0 || q.Time < 0 || q.Failures < 0 || q.Cooldown < 0 {
+		return fmt.Errorf("a quota may not be negative: %+v", q)
+	}
+	return nil
+}
+
+// SetQuota sets the quota of each rule within the given namespace, or of
+// the rule of that name, which limits each run of the rule.
+//
+// An error is returned if the quota is invalid, or there are no such
+// rules.
+func (r *RuleSet) SetQuota(namespace string, q Quota) error {
+
+	if err := validQuota(q); err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	count := 0
+	for _, rule := range r.rules {
+		if inNamespace(rule.Name, namespace) {
+			r.quotas[rule.Name] = &breaker{quota: q}
+			count++
+		}
+	}
+	if count == 0 {
+		return fmt.Errorf("there are no rules within %s", namespace)
+	}
+	return nil
+}
+
+// SetTenantQuota sets the quota of the tenant whose rules are within the
+// given namespace, which limits the resources used by all of those rules
+// each time the set is run.
+//
+// A rule belongs to the tenant with the longest namespace containing it,
+// including rules added after the quota was set.  Once a tenant's quota
+// is exhausted its remaining rules aren't run against the object.
+func (r *RuleSet) SetTenantQuota(namespace string, q Quota) error {
+
+	if err := validQuota(q); err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.tenants[namespace] = &breaker{quota: q}
+	return nil
+}
+
+// OnTrip sets a function which is invoked whenever a rule, or tenant, is
+// tripped, or removes it if nil.
+//
+// The function is invoked by the goroutine running the set, once the run
+// has completed.
+func (r *RuleSet) OnTrip(fn func(Trip)) {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.onTrip = fn
+}
+
+// resetQuotas closes the breakers of the rules, and tenants, within the
+// given namespace.
+//
+// The caller must hold the lock of the set.
+func (r *RuleSet) resetQuotas(namespace string) {
+
+	for name, b := range r.quotas {
+		if inNamespace(name, namespace) {
+			b.pass()
+		}
+	}
+	for name, b := range r.tenants {
+		if inNamespace(name, namespace) {
+			b.pass()
+		}
+	}
+}
+
+// limits holds the limits of a single run of a rule.
+type limits struct {
+	instructions int
+	timeout      time.Duration
+}
+
+// spend holds the resources used by a single run of a rule.
+type spend struct {
+	executed int
+	elapsed  time.Duration
+
+	// expired is true if the run exceeded its time limit.
+	expired bool
+}
+
+// usage holds the resources a tenant has used during a run of the set.
+type usage struct {
+	instructions int
+	time         time.Duration
+	err          error
+}
+
+// quotaRun tracks the quotas of the rules, and tenants, during a single
+// run of the set.
+type quotaRun struct {
+	now time.Time
+
+	// rules holds the quotas of the rules, and tenants those of the
+	// tenants, by name, as they were when the run began.
+	rules   map[string]breaker
+	tenants map[string]breaker
+
+	// tenant holds the tenant of each rule, by name.
+	tenant map[string]string
+
+	// used holds the resources each tenant used, and failed the
+	// error of each rule which exceeded its quota.
+	used   map[string]*usage
+	failed map[string]error
+}
+
+// startQuotas returns the quotas for a run of the set against the given
+// rules.
+//
+// The caller must hold the lock of the set.
+func (r *RuleSet) startQuotas(rules []Rule) *quotaRun {
+
+	q := &quotaRun{
+		now:     time.Now(),
+		rules:   make(map[string]breaker),
+		tenants: make(map[string]breaker),
+		tenant:  make(map[string]string),
+		used:    make(map[string]*usage),
+		failed:  make(map[string]error),
+	}
+
+	for name, b := range r.quotas {
+		q.rules[name] = *b
+	}
+	for name, b := range r.tenants {
+		q.tenants[name] = *b
+	}
+	if len(q.tenants) == 0 {
+		return q
+	}
+
+	for _, rule := range rules {
+		best := ""
+		found := false
+		for name := range q.tenants {
+			if inNamespace(rule.Name, name) && (!found || len(name) > len(best)) {
+				best, found = name, true
+			}
+		}
+		if found {
+			q.tenant[rule.Name] = best
+		}
+	}
+	return q
+}
+
+// limits returns the limits of a run of the named rule, or false if it
+// mustn't be run, as it, or its tenant, was tripped or its tenant's quota
+// is exhausted.
+func (q *quotaRun) limits(name string) (limits, bool) {
+
+	var lim limits
+
+	if b, ok := q.rules[name]; ok {
+		if b.open(q.now) {
+			return lim, false
+		}
+		lim = limits{instructions: b.quota.Instructions, timeout: b.quota.Time}
+	}
+
+	tenant, ok := q.tenant[name]
+	if !ok {
+		return lim, true
+	}
+	b := q.tenants[tenant]
+	if b.open(q.now) {
+		return lim, false
+	}
+	u := q.used[tenant]
+	if u == nil {
+		u = &usage{}
+		q.used[tenant] = u
+	}
+	if u.err != nil {
+		return lim, false
+	}
+
+	// The tenant's remaining quota may be less than that of the rule.
+	if b.quota.Instructions > 0 {
+		left := b.quota.Instructions - u.instructions
+		if lim.instructions == 0 || left < lim.instructions {
+			lim.instructions = left
+		}
+	}
+	if b.quota.Time > 0 {
+		left := b.quota.Time - u.time
+		if lim.timeout == 0 || left < lim.timeout {
+			lim.timeout = left
+		}
+	}
+	if (b.quota.Instructions > 0 && lim.instructions <= 0) || (b.quota.Time > 0 && lim.timeout <= 0) {
+		u.err = fmt.Errorf("the quota of tenant %s was exhausted", tenant)
+		return lim, false
+	}
+	return lim, true
+}
+
+// spent records the resources used by a run of the named rule, with the
+// given limits, and returns true if the error which stopped it was the
+// result of exceeding a quota.
+func (q *quotaRun) spent(name string, lim limits, s spend, err error) bool {
+
+	exceeded := false
+	if err != nil {
+		if ierr, ok := err.(*vm.InstructionLimitError); ok && lim.instructions > 0 && ierr.Limit == lim.instructions {
+			exceeded = true
+		}
+		if s.expired {
+			exceeded = true
+		}
+	}
+
+	tenant, hasTenant := q.tenant[name]
+	if hasTenant {
+		u := q.used[tenant]
+		u.instructions += s.executed
+		u.time += s.elapsed
+	}
+	if !exceeded {
+		return false
+	}
+
+	// The rule is to blame if the limit was its own.
+	if b, ok := q.rules[name]; ok {
+		own := (b.quota.Instructions > 0 && b.quota.Instructions == lim.instructions) ||
+			(b.quota.Time > 0 && b.quota.Time == lim.timeout)
+		if own || !hasTenant {
+			q.failed[name] = err
+			return true
+		}
+	}
+	if hasTenant {
+		q.used[tenant].err = err
+	}
+	return true
+}
+
+// finishQuotas updates the breakers of the rules, and tenants, following
+// a run of the set, and returns those which were tripped along with the
+// function given to OnTrip.
+//
+// The named rules were run, and so either kept within their quotas, or
+// failed.
+func (r *RuleSet) finishQuotas(q *quotaRun, ran []string) ([]Trip, func(Trip)) {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := time.Now()
+	var trips []Trip
+	for _, name := range ran {
+		b, ok := r.quotas[name]
+		if !ok {
+			continue
+		}
+		err, failed := q.failed[name]
+		if !failed {
+			if !b.open(q.now) {
+				b.pass()
+			}
+			continue
+		}
+		if b.fail(now) {
+			trips = append(trips, Trip{Rule: name, Err: err, Until: b.until})
+		}
+	}
+
+	for tenant, u := range q.used {
+		b, ok := r.tenants[tenant]
+		if !ok {
+			continue
+		}
+		if u.err == nil {
+			if !b.open(q.now) {
+				b.pass()
+			}
+			continue
+		}
+		if b.fail(now) {
+			trips = append(trips, Trip{Tenant: tenant, Err: u.err, Until: b.until})
+		}
+	}
+	return trips, r.onTrip
+}
+
+// runWithin runs the script against the given object, as the named rule,
+// within the given limits, and returns its verdict along with the
+// resources it used.
+func (e *Eval) runWithin(obj interface{}, rule string, lim limits) (Verdict, spend, error) {
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if lim.instructions > 0 && (e.maxInstructions <= 0 || lim.instructions < e.maxInstructions) {
+		e.machine.SetMaxInstructions(lim.instructions)
+		defer e.machine.SetMaxInstructions(e.maxInstructions)
+	}
+
+	parent := e.context
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx := parent
+	if lim.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(parent, lim.timeout)
+		defer cancel()
+	}
+	run := func(obj interface{}) (object.Object, error) {
+		return e.machine.RunContext(ctx, obj)
+	}
+
+	start := time.Now()
+	out, err := execute(e.machine, run, obj)
+	e.countClauses()
+	if e.tracer != nil {
+		e.tracer.Rule = rule
+	}
+
+	// Only our own deadline is a matter for the quota.
+	s := spend{executed: e.machine.Executed(), elapsed: time.Since(start)}
+	s.expired = err != nil && ctx.Err() == context.DeadlineExceeded && parent.Err() == nil
+	if err != nil {
+		return Verdict{}, s, err
+	}
+	return Verdict{Rule: rule, Match: e.machine.True(out), Score: e.machine.Score(), Severity: e.machine.Severity()}, s, nil
+}
@@ -0,0 +1,224 @@
+// This file contains the reordering of the clauses of `&&` chains, which
+// takes place when the optimizer is enabled along with the ReorderClauses
+// flag.
+//
+// Our `&&` operator always evaluates both of its operands, so a chain
+// such as:
+//
+//    Message ~= /panic/i && Level == "error"
+//
+// always tests the regular expression - even for the events which aren't
+// errors.  When the clauses of a chain can't have side-effects we may
+// instead evaluate them one at a time, stopping at the first which is
+// false, and evaluate them in the order which is likely to stop soonest:
+//
+//    Level == "error" && Message ~= /panic/i
+//
+// Each clause is given a cost, which is estimated from the operations it
+// contains, and a chance of being false.  The chances are those observed
+// via ClauseStatistics, if they were given to SetClauseHints, otherwise
+// each clause is assumed to be false half of the time.  The clauses which
+// are cheap, and likely to be false, are evaluated first.
+
+package evalfilter
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/skx/evalfilter/v2/ast"
+	"github.com/skx/evalfilter/v2/code"
+)
+
+// SetClauseHints gives the statistics of previous runs of the script, as
+// returned by ClauseStatistics, which are used to decide the order of the
+// clauses of `&&` chains when the script is prepared with the
+// ReorderClauses flag.
+//
+// Statistics are matched to clauses by their location, and expression,
+// so those of a different version of the script are ignored.
+func (e *Eval) SetClauseHints(stats []ClauseStatistic) {
+	e.hints = make(map[string]ClauseStatistic)
+	for _, s := range stats {
+		e.hints[hintKey(s.Line, s.Column, s.Expression)] = s
+	}
+}
+
+// hintKey returns the key of the statistics of the clause with the given
+// location, and expression.
+func hintKey(line int, column int, expr string) string {
+	return fmt.Sprintf("%d:%d:%s", line, column, expr)
+}
+
+// ordered holds a clause of a chain, along with the order it should be
+// evaluated in.
+type ordered struct {
+	node ast.Expression
+	rank float64
+}
+
+// compileReordered compiles the given chain of `&&` clauses, evaluating
+// them in the best order, and returns false if the chain may not be
+// reordered.
+func (e *Eval) compileReordered(node *ast.InfixExpression) (bool, error) {
+
+	clauses := flatten(node, "&&")
+	if !allBoolean(clauses) {
+		return false, nil
+	}
+	for _, c := range clauses {
+		if !pure(c) {
+			return false, nil
+		}
+	}
+
+	var order []ordered
+	for _, c := range clauses {
+		order = append(order, ordered{node: c, rank: e.rank(c)})
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return order[i].rank < order[j].rank
+	})
+
+	//
+	// We'll emit
+	//
+	//      clause 1
+	//      if ! TRUE jmp FALSE
+	//      clause 2
+	//      if ! TRUE jmp FALSE
+	//      clause 3
+	//      jmp END
+	//  FALSE:
+	//      false
+	//  END:
+	//
+	// Each clause is a boolean, so the last is the result of the
+	// chain when those before it were true.
+	//
+	var jumps []int
+	for i, c := range order {
+		err := e.compile(c.node)
+		if err != nil {
+			return true, err
+		}
+		if i < len(order)-1 {
+			jumps = append(jumps, e.emit(code.OpJumpIfFalse, 9999))
+		}
+	}
+	end := e.emit(code.OpJump, 9999)
+	for _, j := range jumps {
+		e.changeOperand(j, len(e.instructions))
+	}
+	e.emit(code.OpFalse)
+	e.changeOperand(end, len(e.instructions))
+
+	return true, nil
+}
+
+// rank returns the order in which the given clause should be evaluated,
+// with the lowest first.
+//
+// Evaluating the clauses of a chain in the order of their cost divided
+// by the chance that they're false results in the least cost overall,
+// if the clauses are independent of each other.
+func (e *Eval) rank(node ast.Expression) float64 {
+
+	chance := 0.5
+	if s, ok := e.hint(node); ok && s.True+s.False > 0 {
+		chance = float64(s.False) / float64(s.True+s.False)
+	}
+
+	// A clause which is never false never stops the chain.
+	if chance == 0 {
+		chance = 0.001
+	}
+	return float64(cost(node)) / chance
+}
+
+// hint returns the statistics of the given clause, if we were given any.
+func (e *Eval) hint(node ast.Expression) (ClauseStatistic, bool) {
+
+	if e.hints == nil {
+		return ClauseStatistic{}, false
+	}
+
+	var key string
+	switch n := node.(type) {
+	case *ast.InfixExpression:
+		key = hintKey(n.Token.Line, n.Token.Column, describeExpression(n))
+	case *ast.PrefixExpression:
+		key = hintKey(n.Token.Line, n.Token.Column, describeExpression(n))
+	default:
+		return ClauseStatistic{}, false
+	}
+
+	s, ok := e.hints[key]
+	return s, ok
+}
+
+// cost returns an estimate of the cost of evaluating the given expression.
+func cost(node ast.Expression) int {
+
+	switch n := node.(type) {
+	case *ast.BooleanLiteral, *ast.FloatLiteral, *ast.IntegerLiteral,
+		*ast.StringLiteral, *ast.RegexpLiteral:
+		return 1
+	case *ast.Identifier:
+		return 2
+	case *ast.ArrayLiteral:
+		total := 1
+		for _, el := range n.Elements {
+			total += cost(el)
+		}
+		return total
+	case *ast.PrefixExpression:
+		return 1 + cost(n.Right)
+	case *ast.IndexExpression:
+		return 2 + cost(n.Left) + cost(n.Index)
+	case *ast.InfixExpression:
+		total := cost(n.Left) + cost(n.Right)
+		switch n.Operator {
+		case "~=", "!~":
+			return total + 20
+		case "in":
+			// Each member of the array is compared.
+			if a, ok := n.Right.(*ast.ArrayLiteral); ok {
+				return total + len(a.Elements)
+			}
+			return total + 10
+		case ".":
+			return total + 2
+		}
+		return total + 1
+	}
+	return 10
+}
+
+// pure returns true if the given expression has no side-effects, so that
+// it makes no difference when, or whether, it is evaluated.
+func pure(node ast.Expression) bool {
+
+	switch n := node.(type) {
+	case *ast.BooleanLiteral, *ast.FloatLiteral, *ast.IntegerLiteral,
+		*ast.StringLiteral, *ast.RegexpLiteral, *ast.Identifier:
+		return true
+	case *ast.ArrayLiteral:
+		for _, el := range n.Elements {
+			if !pure(el) {
+				return false
+			}
+		}
+		return true
+	case *ast.PrefixExpression:
+		return pure(n.Right)
+	case *ast.IndexExpression:
+		return pure(n.Left) && pure(n.Index)
+	case *ast.InfixExpression:
+		if _, mutator := mutators[n.Operator]; mutator || n.Operator == "=" {
+			return false
+		}
+		return pure(n.Left) && pure(n.Right)
+	}
+	return false
+}
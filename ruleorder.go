@@ -0,0 +1,83 @@
+// This file contains the ordering of the rules within a RuleSet, which
+// follows the requires, and suppresses, pragmas of their scripts.
+//
+// A rule which requires another is only run once that rule has matched,
+// and a rule which suppresses another is run first, so that the other
+// needn't be run at all if it matches.  Otherwise rules are run in the
+// order they were added.
+
+package evalfilter
+
+import (
+	"fmt"
+	"strings"
+)
+
+// order returns the indexes of the given rules in the order they should
+// be run, or an error if a rule refers to one which doesn't exist, or
+// rules depend upon each other.
+func order(rules []*Rule) ([]int, error) {
+
+	index := make(map[string]int)
+	for i, rule := range rules {
+		index[rule.Name] = i
+	}
+
+	// The rules which must be run before each rule.
+	before := make([][]int, len(rules))
+	for i, rule := range rules {
+		for _, name := range rule.Eval.Requires() {
+			j, ok := index[name]
+			if !ok {
+				return nil, fmt.Errorf("rule %s requires %s, which does not exist", rule.Name, name)
+			}
+			before[i] = append(before[i], j)
+		}
+		for _, name := range rule.Eval.Suppresses() {
+			j, ok := index[name]
+			if !ok {
+				return nil, fmt.Errorf("rule %s suppresses %s, which does not exist", rule.Name, name)
+			}
+			before[j] = append(before[j], i)
+		}
+	}
+
+	out := make([]int, 0, len(rules))
+	placed := make([]bool, len(rules))
+
+	// Repeatedly place the first rule whose predecessors have all
+	// been placed, so that rules are otherwise kept in order.
+	for len(out) < len(rules) {
+
+		progress := false
+		for i := range rules {
+			if placed[i] {
+				continue
+			}
+			ready := true
+			for _, j := range before[i] {
+				if !placed[j] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				out = append(out, i)
+				placed[i] = true
+				progress = true
+				break
+			}
+		}
+
+		if !progress {
+			var cycle []string
+			for i, rule := range rules {
+				if !placed[i] {
+					cycle = append(cycle, rule.Name)
+				}
+			}
+			return nil, fmt.Errorf("the rules %s depend upon each other", strings.Join(cycle, ", "))
+		}
+	}
+	return out, nil
+}
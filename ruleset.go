@@ -0,0 +1,453 @@
+// This file contains the RuleSet, which allows several scripts to be run
+// against the same objects.
+
+package evalfilter
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/skx/evalfilter/v2/vm"
+)
+
+// Rule is a single, named, script within a RuleSet.
+type Rule struct {
+
+	// Name is the name the rule was added with.
+	Name string
+
+	// Eval is the prepared script.
+	Eval *Eval
+
+	// Weight is the weight of the rule's score, when the set uses
+	// AggregateWeighted, which is 1 unless SetWeight changed it.
+	Weight float64
+
+	// Severity is the least severity of the rule's matches, which
+	// is vm.SeverityNone unless SetSeverity changed it.
+	Severity vm.Severity
+
+	// Disabled is true if the rule isn't run, as Disable was invoked
+	// upon it, or its namespace.
+	Disabled bool
+
+	// prefix is the key of the clause which begins the rule, if it
+	// may be shared with other rules.
+	prefix string
+
+	// names holds the identifiers within the clause which begins the
+	// rule, which must not be variables of the rule for the clause to
+	// be shared.
+	names []string
+
+	// guarded is true if the rule has a guard, and so is only run if
+	// the index of its guard says so.
+	guarded bool
+}
+
+// Aggregation describes how the scores of the rules which matched an
+// object are combined into the score of the RuleSet's Result.
+type Aggregation int
+
+const (
+	// AggregateMax gives the highest score of the rules which
+	// matched, which is the default.
+	AggregateMax Aggregation = iota
+
+	// AggregateSum gives the sum of the scores of the rules which
+	// matched.
+	AggregateSum
+
+	// AggregateWeighted gives the sum of the scores of the rules
+	// which matched, each multiplied by the weight of its rule.
+	AggregateWeighted
+)
+
+// RuleSet holds a collection of named rules, each of which is a prepared
+// script, and allows them all to be run against an object at once.
+//
+// A RuleSet may be used from several goroutines concurrently, in the same
+// way that a single Eval may be.
+type RuleSet struct {
+
+	// rules holds our rules, in the order they were added.
+	rules []*Rule
+
+	// names allows us to find rules by name.
+	names map[string]*Rule
+
+	// aggregation is the way the scores of our rules are combined.
+	aggregation Aggregation
+
+	// limit is the number of matches after which the remaining rules
+	// are skipped, or zero to run them all.
+	limit int
+
+	// errors is what happens when one of our rules fails.
+	errors ErrorPolicy
+
+	// prefixes holds the scripts which evaluate the clauses that begin
+	// our rules, and shared the number of rules beginning with each.
+	prefixes map[string]*Eval
+	shared   map[string]int
+
+	// guards holds the indexes of our guarded rules, by the field
+	// their guards test.
+	guards map[string]*guardIndex
+
+	// order holds the indexes of our rules in the order they're run,
+	// or orderErr the reason they can't be.
+	order    []int
+	orderErr error
+
+	// quotas holds the quotas of our rules, and tenants those of the
+	// namespaces of our tenants, by name, with onTrip the function
+	// to invoke when one of them is tripped.
+	quotas  map[string]*breaker
+	tenants map[string]*breaker
+	onTrip  func(Trip)
+
+	// mutex protects our rules.
+	mutex sync.RWMutex
+}
+
+// Result holds the outcome of running a RuleSet against an object.
+type Result struct {
+
+	// Matched holds the names of the rules which matched the object,
+	// in the order they were added to the set.
+	Matched []string
+
+	// Suppressed holds the names of the rules which weren't run, as
+	// a rule which suppresses them matched the object.
+	Suppressed []string
+
+	// Skipped holds the names of the rules which weren't run, as the
+	// policy of the set was satisfied before them.
+	Skipped []string
+
+	// Tripped holds the names of the rules which weren't matches, as
+	// they, or their tenants, exceeded their quotas.
+	Tripped []string
+
+	// Errors holds the errors of the rules which failed, by name, when
+	// the set's ErrorPolicy is ErrorsContinue.  Each is a *RuleError.
+	Errors map[string]error
+
+	// Verdicts holds the verdicts of the rules which matched the
+	// object, by name.
+	Verdicts map[string]Verdict
+
+	// Score holds the scores of the rules which matched the object,
+	// combined as the set's Aggregation describes.
+	Score float64
+
+	// Severity holds the highest severity of the rules which matched
+	// the object, or vm.SeverityNone if they gave none.
+	Severity vm.Severity
+}
+
+// NewRuleSet creates a new, empty, RuleSet.
+func NewRuleSet() *RuleSet {
+	return &RuleSet{
+		names:    make(map[string]*Rule),
+		prefixes: make(map[string]*Eval),
+		shared:   make(map[string]int),
+		guards:   make(map[string]*guardIndex),
+		quotas:   make(map[string]*breaker),
+		tenants:  make(map[string]*breaker),
+	}
+}
+
+// AddRule adds a script to the set, under the given name.
+//
+// The script must already have been prepared, and the name must not be
+// in use by another rule.
+func (r *RuleSet) AddRule(name string, eval *Eval) error {
+
+	if name == "" {
+		return fmt.Errorf("a rule must have a name")
+	}
+	if eval == nil || eval.machine == nil {
+		return fmt.Errorf("rule %s has not been prepared", name)
+	}
+
+	key, p, names := prefix(eval)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.names[name]; ok {
+		return fmt.Errorf("the rule %s already exists", name)
+	}
+
+	rule := &Rule{Name: name, Eval: eval, Weight: 1, prefix: key, names: names}
+	err := r.indexGuard(rule, len(r.rules))
+	if err != nil {
+		return err
+	}
+	if key != "" {
+		if _, ok := r.prefixes[key]; !ok {
+			r.prefixes[key] = p
+		}
+		r.shared[key]++
+	}
+	r.rules = append(r.rules, rule)
+	r.names[name] = rule
+
+	// The rules a rule refers to might not have been added yet, so
+	// any error is only reported when the set is run.
+	r.order, r.orderErr = order(r.rules)
+	return nil
+}
+
+// Rules returns the rules within the set, in the order they were added.
+func (r *RuleSet) Rules() []*Rule {
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	out := make([]*Rule, len(r.rules))
+	copy(out, r.rules)
+	return out
+}
+
+// SetAggregation controls how the scores the rules give to the objects
+// they match are combined into the score of the Result.
+func (r *RuleSet) SetAggregation(a Aggregation) {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.aggregation = a
+}
+
+// SetWeight sets the weight of the named rule's score, which is used when
+// the set's scores are combined via AggregateWeighted.
+func (r *RuleSet) SetWeight(name string, weight float64) error {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	rule, ok := r.names[name]
+	if !ok {
+		return fmt.Errorf("the rule %s does not exist", name)
+	}
+	rule.Weight = weight
+	return nil
+}
+
+// SetSeverity sets the least severity of the named rule's matches, which
+// is used when the rule's script gives a lower severity, or none.
+func (r *RuleSet) SetSeverity(name string, severity vm.Severity) error {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	rule, ok := r.names[name]
+	if !ok {
+		return fmt.Errorf("the rule %s does not exist", name)
+	}
+	rule.Severity = severity
+	return nil
+}
+
+// Run runs each of the rules against the given object, and returns the
+// names of those which matched.
+//
+// Rules are run in the order they were added, except that the rules a
+// rule requires, via the requires pragma, are run before it and must
+// have matched for it to be run at all.  Similarly a rule which matches
+// prevents those it suppresses from being run.
+//
+// Rules which are disabled, or whose guard pragma is false, aren't run,
+// and aren't matches.
+//
+// Once the policy given to SetPolicy is satisfied the remaining rules are
+// skipped.  Rules which begin with the same test, the first clause of a
+// script which returns a chain of `&&` operators, aren't run when
+// that test fails for one of them, as none could match.  Such rules are
+// not matches, even if they'd have failed when run.  The test isn't
+// shared by rules which have since been given a variable it refers to.
+//
+// The result is graded by the verdicts of the rules which matched, as
+// described by RunVerdict, whilst the scores and severities given by the
+// rules which didn't match are ignored.
+//
+// Rules which exceed the quotas given to SetQuota, or SetTenantQuota,
+// aren't matches, and are returned in the Tripped field of the Result
+// along with those which weren't run as they, or their tenants, had been
+// tripped.
+//
+// If a rule fails then a *RuleError is returned, identifying the rule,
+// unless SetErrorPolicy was given ErrorsContinue.
+func (r *RuleSet) Run(obj interface{}) (*Result, error) {
+
+	r.mutex.RLock()
+	if r.orderErr != nil {
+		r.mutex.RUnlock()
+		return nil, r.orderErr
+	}
+	rules := make([]Rule, len(r.rules))
+	for i, rule := range r.rules {
+		rules[i] = *rule
+	}
+	run := make([]int, len(r.order))
+	copy(run, r.order)
+	aggregation := r.aggregation
+	limit := r.limit
+	errors := r.errors
+	prefixes := make(map[string]*Eval)
+	for key, n := range r.shared {
+		if n > 1 {
+			prefixes[key] = r.prefixes[key]
+		}
+	}
+	var guards []*guardIndex
+	for _, idx := range r.guards {
+		guards = append(guards, idx)
+	}
+	quotas := r.startQuotas(rules)
+	r.mutex.RUnlock()
+
+	// The guarded rules which might match.
+	allowed := guarded(guards, obj)
+	for i := range rules {
+		if rules[i].guarded {
+			rules[i].ownGuard(i, allowed, obj)
+		}
+	}
+
+	verdicts := make(map[string]Verdict)
+	suppressed := make(map[string]bool)
+	skipped := make(map[string]bool)
+	tripped := make(map[string]bool)
+	failed := make(map[string]error)
+	var ran []string
+
+	// The results of the clauses begun with, by key.
+	held := make(map[string]bool)
+
+	for _, i := range run {
+
+		rule := rules[i]
+		if suppressed[rule.Name] {
+			continue
+		}
+		if limit > 0 && len(verdicts) >= limit {
+			skipped[rule.Name] = true
+			continue
+		}
+		required := true
+		for _, name := range rule.Eval.Requires() {
+			if _, ok := verdicts[name]; !ok {
+				required = false
+			}
+		}
+		if !required || rule.Disabled || (rule.guarded && !allowed[i]) {
+			continue
+		}
+
+		// A rule which now has a variable named by the clause
+		// can't share its result.
+		if p, ok := prefixes[rule.prefix]; ok && !rule.Eval.variables(rule.names) {
+			match, known := held[rule.prefix]
+			if !known {
+				// A failure is left to the rule to report.
+				var err error
+				match, err = p.Run(obj)
+				if err != nil {
+					match = true
+				}
+				held[rule.prefix] = match
+			}
+			if !match {
+				continue
+			}
+		}
+
+		lim, ok := quotas.limits(rule.Name)
+		if !ok {
+			tripped[rule.Name] = true
+			continue
+		}
+
+		v, used, err := rule.Eval.runWithin(obj, rule.Name, lim)
+		ran = append(ran, rule.Name)
+		if quotas.spent(rule.Name, lim, used, err) {
+			tripped[rule.Name] = true
+			continue
+		}
+		if err != nil {
+			err = &RuleError{Name: rule.Name, Err: err}
+			if errors != ErrorsContinue {
+				return nil, err
+			}
+			failed[rule.Name] = err
+			continue
+		}
+		if !v.Match {
+			continue
+		}
+		if rule.Severity > v.Severity {
+			v.Severity = rule.Severity
+		}
+		verdicts[rule.Name] = v
+		for _, name := range rule.Eval.Suppresses() {
+			suppressed[name] = true
+		}
+	}
+
+	trips, onTrip := r.finishQuotas(quotas, ran)
+	if onTrip != nil {
+		for _, trip := range trips {
+			onTrip(trip)
+		}
+	}
+
+	// Report the results in the order the rules were added.
+	res := &Result{}
+	for _, rule := range rules {
+
+		if tripped[rule.Name] {
+			res.Tripped = append(res.Tripped, rule.Name)
+		}
+		if err, ok := failed[rule.Name]; ok {
+			if res.Errors == nil {
+				res.Errors = make(map[string]error)
+			}
+			res.Errors[rule.Name] = err
+		}
+		if suppressed[rule.Name] {
+			res.Suppressed = append(res.Suppressed, rule.Name)
+		}
+		if skipped[rule.Name] {
+			res.Skipped = append(res.Skipped, rule.Name)
+		}
+		v, ok := verdicts[rule.Name]
+		if !ok {
+			continue
+		}
+
+		if res.Verdicts == nil {
+			res.Verdicts = make(map[string]Verdict)
+		}
+		res.Matched = append(res.Matched, rule.Name)
+		res.Verdicts[rule.Name] = v
+
+		switch aggregation {
+		case AggregateSum:
+			res.Score += v.Score
+		case AggregateWeighted:
+			res.Score += v.Score * rule.Weight
+		default:
+			if len(res.Matched) == 1 || v.Score > res.Score {
+				res.Score = v.Score
+			}
+		}
+		if v.Severity > res.Severity {
+			res.Severity = v.Severity
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,884 @@
+package evalfilter
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/skx/evalfilter/v2/object"
+	"github.com/skx/evalfilter/v2/vm"
+)
+
+// TestRuleSet tests running several rules against an object.
+func TestRuleSet(t *testing.T) {
+
+	scripts := []struct {
+		name   string
+		script string
+	}{
+		{"adult", `return age >= 18;`},
+		{"steve", `return name == "Steve";`},
+		{"admin", `return admin;`},
+	}
+
+	set := NewRuleSet()
+	for _, s := range scripts {
+		eval := New(s.script)
+		err := eval.Prepare()
+		if err != nil {
+			t.Fatalf("failed to compile %s: %s", s.name, err)
+		}
+		err = set.AddRule(s.name, eval)
+		if err != nil {
+			t.Fatalf("failed to add %s: %s", s.name, err)
+		}
+	}
+
+	if len(set.Rules()) != 3 || set.Rules()[2].Name != "admin" {
+		t.Fatalf("wrong rules: %v", set.Rules())
+	}
+
+	tests := []struct {
+		obj     map[string]interface{}
+		matched string
+	}{
+		{map[string]interface{}{"age": 45, "name": "Steve", "admin": true}, "adult,steve,admin"},
+		{map[string]interface{}{"age": 17, "name": "Steve", "admin": false}, "steve"},
+		{map[string]interface{}{"age": 17, "name": "Bob", "admin": false}, ""},
+	}
+
+	for _, test := range tests {
+		res, err := set.Run(test.obj)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if strings.Join(res.Matched, ",") != test.matched {
+			t.Fatalf("wrong matches for %v, got %v expected %s", test.obj, res.Matched, test.matched)
+		}
+	}
+
+	// Errors identify the rule.
+	_, err := set.Run(map[string]interface{}{"age": "old"})
+	if err == nil || !strings.HasPrefix(err.Error(), "rule adult: ") {
+		t.Fatalf("expected an error from the adult rule, got %v", err)
+	}
+}
+
+// TestRuleSetLanguagePrefix tests that rules sharing a leading clause
+// share its result only when they use the same version of the language.
+func TestRuleSetLanguagePrefix(t *testing.T) {
+
+	scripts := []struct {
+		name   string
+		script string
+	}{
+		{"name", "// pragma lang 2\nreturn Count && Name == \"x\";"},
+		{"other", "// pragma lang 2\nreturn Count && Other == \"y\";"},
+		{"legacy", `return Count && Name == "x";`},
+	}
+
+	obj := map[string]interface{}{"Count": -1, "Name": "x", "Other": "y"}
+
+	// The legacy rule may be added before, or after, the others.
+	for _, order := range [][]int{{0, 1, 2}, {2, 0, 1}} {
+
+		set := NewRuleSet()
+		for _, i := range order {
+			eval := New(scripts[i].script)
+			err := eval.Prepare()
+			if err != nil {
+				t.Fatalf("failed to compile %s: %s", scripts[i].name, err)
+			}
+
+			// Each rule matches alone, apart from the legacy one,
+			// as negative numbers are only true from version 2.
+			ret, err := eval.Run(obj)
+			if err != nil || ret != (scripts[i].name != "legacy") {
+				t.Fatalf("unexpected result for %s alone: %v %v", scripts[i].name, ret, err)
+			}
+			err = set.AddRule(scripts[i].name, eval)
+			if err != nil {
+				t.Fatalf("failed to add %s: %s", scripts[i].name, err)
+			}
+		}
+
+		res, err := set.Run(obj)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if strings.Join(res.Matched, ",") != "name,other" {
+			t.Fatalf("wrong matches with order %v: %v", order, res.Matched)
+		}
+	}
+}
+
+// TestRuleSetVariablePrefix tests that rules don't share the clause they
+// begin with once it refers to variables, set after they were added.
+func TestRuleSetVariablePrefix(t *testing.T) {
+
+	set := NewRuleSet()
+	rules := []struct {
+		name   string
+		script string
+		kind   string
+	}{
+		{"login", `return Type == kind && Failures > 1;`, "login"},
+		{"logout", `return Type == kind && Failures > 0;`, "logout"},
+	}
+
+	var evals []*Eval
+	for _, r := range rules {
+		eval := New(r.script)
+		err := eval.Prepare()
+		if err != nil {
+			t.Fatalf("failed to compile %s: %s", r.name, err)
+		}
+		err = set.AddRule(r.name, eval)
+		if err != nil {
+			t.Fatalf("failed to add %s: %s", r.name, err)
+		}
+		evals = append(evals, eval)
+	}
+	for i, r := range rules {
+		evals[i].SetVariable("kind", &object.String{Value: r.kind})
+	}
+
+	// The variables hide the field of the same name.
+	obj := map[string]interface{}{"Type": "logout", "Failures": 5, "kind": "login"}
+	for i, expected := range []bool{false, true} {
+		ret, err := evals[i].Run(obj)
+		if err != nil || ret != expected {
+			t.Fatalf("unexpected result for %s alone: %v %v", rules[i].name, ret, err)
+		}
+	}
+
+	res, err := set.Run(obj)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if strings.Join(res.Matched, ",") != "logout" {
+		t.Fatalf("wrong matches: %v", res.Matched)
+	}
+}
+
+// TestRuleSetAdd tests that bad rules are rejected.
+func TestRuleSetAdd(t *testing.T) {
+
+	ok := New(`return true;`)
+	ok.Prepare()
+
+	set := NewRuleSet()
+	err := set.AddRule("ok", ok)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	tests := []struct {
+		name  string
+		eval  *Eval
+		error string
+	}{
+		{"", ok, "a rule must have a name"},
+		{"ok", ok, "the rule ok already exists"},
+		{"unprepared", New(`return true;`), "rule unprepared has not been prepared"},
+		{"nil", nil, "rule nil has not been prepared"},
+	}
+
+	for _, test := range tests {
+		err := set.AddRule(test.name, test.eval)
+		if err == nil || err.Error() != test.error {
+			t.Fatalf("wrong error adding %s, got %v expected %s", test.name, err, test.error)
+		}
+	}
+}
+
+// TestRuleSetFuncMap tests using rules within templates.
+func TestRuleSetFuncMap(t *testing.T) {
+
+	adult := New(`return age >= 18;`)
+	adult.Prepare()
+
+	set := NewRuleSet()
+	set.AddRule("adult", adult)
+
+	tmpl := template.Must(template.New("t").Funcs(set.FuncMap("rule")).Parse(`{{ if rule "adult" . }}yes{{ else }}no{{ end }}`))
+
+	tests := []struct {
+		obj    map[string]interface{}
+		output string
+	}{
+		{map[string]interface{}{"age": 45}, "yes"},
+		{map[string]interface{}{"age": 17}, "no"},
+	}
+
+	for _, test := range tests {
+		var out bytes.Buffer
+		err := tmpl.Execute(&out, test.obj)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if out.String() != test.output {
+			t.Fatalf("wrong output for %v, got %s expected %s", test.obj, out.String(), test.output)
+		}
+	}
+
+	// Errors stop the template.
+	tests2 := []struct {
+		template string
+		error    string
+	}{
+		{`{{ if rule "missing" . }}{{ end }}`, "the rule missing does not exist"},
+		{`{{ if rule "adult" . }}{{ end }}`, "type mismatch"},
+	}
+
+	for _, test := range tests2 {
+		tmpl := template.Must(template.New("t").Funcs(set.FuncMap("rule")).Parse(test.template))
+		err := tmpl.Execute(&bytes.Buffer{}, map[string]interface{}{"age": "old"})
+		if err == nil || !strings.Contains(err.Error(), test.error) {
+			t.Fatalf("expected an error containing %s, got %v", test.error, err)
+		}
+	}
+}
+
+// TestRuleSetDependencies tests the ordering, and suppression, of rules
+// via their pragmas.
+func TestRuleSetDependencies(t *testing.T) {
+
+	// Each rule records that it was run.
+	var ran []string
+
+	build := func(rules [][2]string) *RuleSet {
+		set := NewRuleSet()
+		for _, r := range rules {
+			name := r[0]
+			eval := New(r[1])
+			eval.AddFunction("ran", func(args []object.Object) object.Object {
+				ran = append(ran, name)
+				return &object.Boolean{Value: true}
+			})
+			err := eval.Prepare()
+			if err != nil {
+				t.Fatalf("failed to compile %s: %s", name, err)
+			}
+			err = set.AddRule(name, eval)
+			if err != nil {
+				t.Fatalf("failed to add %s: %s", name, err)
+			}
+		}
+		return set
+	}
+
+	set := build([][2]string{
+		{"escalation", `// pragma requires "failed-login" admin
+ran(); return Failures > 10;`},
+		{"single-failure", `ran(); return Failures == 1;`},
+		{"failed-login", `// pragma suppresses "single-failure"
+ran(); return Failures > 0;`},
+		{"admin", `ran(); return User == "admin";`},
+	})
+
+	tests := []struct {
+		obj        map[string]interface{}
+		ran        string
+		matched    string
+		suppressed string
+	}{
+		{map[string]interface{}{"Failures": 20, "User": "admin"}, "failed-login,admin,escalation", "escalation,failed-login,admin", "single-failure"},
+		{map[string]interface{}{"Failures": 1, "User": "admin"}, "failed-login,admin,escalation", "failed-login,admin", "single-failure"},
+		{map[string]interface{}{"Failures": 20, "User": "bob"}, "failed-login,admin", "failed-login", "single-failure"},
+		{map[string]interface{}{"Failures": 0, "User": "admin"}, "failed-login,single-failure,admin", "admin", ""},
+	}
+
+	for _, test := range tests {
+		ran = nil
+		res, err := set.Run(test.obj)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if strings.Join(ran, ",") != test.ran {
+			t.Fatalf("wrong rules run for %v, got %v expected %s", test.obj, ran, test.ran)
+		}
+		if strings.Join(res.Matched, ",") != test.matched {
+			t.Fatalf("wrong matches for %v, got %v expected %s", test.obj, res.Matched, test.matched)
+		}
+		if strings.Join(res.Suppressed, ",") != test.suppressed {
+			t.Fatalf("wrong suppressions for %v, got %v expected %s", test.obj, res.Suppressed, test.suppressed)
+		}
+	}
+
+	// Rules which refer to missing rules, or each other, are errors.
+	errors := []struct {
+		rules [][2]string
+		err   string
+	}{
+		{[][2]string{{"a", `// pragma requires b
+return true;`}}, "rule a requires b, which does not exist"},
+		{[][2]string{{"a", `// pragma suppresses "b c"
+return true;`}}, "rule a suppresses b c, which does not exist"},
+		{[][2]string{
+			{"a", `// pragma requires b
+return true;`},
+			{"b", `// pragma requires c
+return true;`},
+			{"c", `// pragma requires a
+return true;`},
+			{"d", `return true;`},
+		}, "the rules a, b, c depend upon each other"},
+	}
+
+	for _, test := range errors {
+		_, err := build(test.rules).Run(nil)
+		if err == nil || err.Error() != test.err {
+			t.Fatalf("expected error '%s', got %v", test.err, err)
+		}
+	}
+
+	// Invalid pragmas are reported by Prepare.
+	pragmas := []struct {
+		input string
+		err   string
+	}{
+		{`// pragma requires`, "line 1: the requires pragma requires the name of a rule"},
+		{`// pragma suppresses "noisy`, "line 1: the suppresses pragma has an unterminated name"},
+		{`// pragma requires "\q"`, `line 1: the requires pragma has an invalid name "\q"`},
+	}
+	for _, test := range pragmas {
+		err := New(test.input).Prepare()
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Fatalf("expected error '%s' for %s, got %v", test.err, test.input, err)
+		}
+	}
+}
+
+// TestRuleSetPolicy tests stopping once enough rules have matched, and
+// sharing the clauses rules begin with.
+func TestRuleSetPolicy(t *testing.T) {
+
+	var ran []string
+
+	set := NewRuleSet()
+	rules := [][2]string{
+		{"first", `return ran( "first" ) && Failures > 0;`},
+		{"second", `return ran( "second" ) && Failures > 5;`},
+		{"third", `return ran( "third" ) && Failures > 10;`},
+		{"fourth", `return ran( "fourth" ) && Failures > 15;`},
+		{"login", `return Type == "login" && ran( "login" ) && Failures > 0;`},
+		{"root", `return Type == "login" && ran( "root" ) && User == "root";`},
+	}
+	for _, r := range rules {
+		eval := New(r[1])
+		eval.AddFunction("ran", func(args []object.Object) object.Object {
+			ran = append(ran, args[0].Inspect())
+			return &object.Boolean{Value: true}
+		})
+		err := eval.Prepare()
+		if err != nil {
+			t.Fatalf("failed to compile %s: %s", r[0], err)
+		}
+		err = set.AddRule(r[0], eval)
+		if err != nil {
+			t.Fatalf("failed to add %s: %s", r[0], err)
+		}
+	}
+
+	tests := []struct {
+		policy  Policy
+		limit   int
+		obj     map[string]interface{}
+		ran     string
+		matched string
+		skipped string
+	}{
+		{PolicyAll, 0, map[string]interface{}{"Failures": 12, "Type": "login", "User": "root"},
+			"first,second,third,fourth,login,root", "first,second,third,login,root", ""},
+		{PolicyFirstMatch, 0, map[string]interface{}{"Failures": 12, "Type": "login", "User": "root"},
+			"first", "first", "second,third,fourth,login,root"},
+		{PolicyFirstMatch, 0, map[string]interface{}{"Failures": 0, "Type": "login", "User": "root"},
+			"first,second,third,fourth,login,root", "root", ""},
+		{PolicyMatches, 2, map[string]interface{}{"Failures": 6, "Type": "login", "User": "bob"},
+			"first,second", "first,second", "third,fourth,login,root"},
+		{PolicyMatches, 3, map[string]interface{}{"Failures": 6, "Type": "login", "User": "bob"},
+			"first,second,third,fourth,login", "first,second,login", "root"},
+
+		// Neither login, nor root, are run unless the object is a login.
+		{PolicyAll, 0, map[string]interface{}{"Failures": 12, "Type": "dns", "User": "root"},
+			"first,second,third,fourth", "first,second,third", ""},
+	}
+
+	for _, test := range tests {
+		err := set.SetPolicy(test.policy, test.limit)
+		if err != nil {
+			t.Fatalf("failed to set policy: %s", err)
+		}
+
+		ran = nil
+		res, err := set.Run(test.obj)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if strings.Join(ran, ",") != test.ran {
+			t.Fatalf("wrong rules run for %v, got %v expected %s", test.obj, ran, test.ran)
+		}
+		if strings.Join(res.Matched, ",") != test.matched {
+			t.Fatalf("wrong matches for %v, got %v expected %s", test.obj, res.Matched, test.matched)
+		}
+		if strings.Join(res.Skipped, ",") != test.skipped {
+			t.Fatalf("wrong skipped rules for %v, got %v expected %s", test.obj, res.Skipped, test.skipped)
+		}
+	}
+
+	// Invalid policies are errors.
+	if set.SetPolicy(PolicyMatches, 0) == nil {
+		t.Fatalf("expected an error with no limit")
+	}
+	if set.SetPolicy(Policy(10), 1) == nil {
+		t.Fatalf("expected an error with an unknown policy")
+	}
+}
+
+// TestRuleSetGuards tests that only the rules whose guards are true are
+// run.
+func TestRuleSetGuards(t *testing.T) {
+
+	var ran []string
+
+	set := NewRuleSet()
+	rules := [][2]string{
+		{"dns", `// pragma guard event.type == "dns"
+return ran( "dns" );`},
+		{"resolver", `// pragma guard event.type in [ "dns", "mdns" ]
+return ran( "resolver" ) && event.port == 53;`},
+		{"login", `// pragma guard event.type == "login"
+return ran( "login" );`},
+		{"port", `// pragma guard event.port in [ 22, -1, 8080.0 ]
+return ran( "port" );`},
+		{"any", `return ran( "any" );`},
+	}
+	for _, r := range rules {
+		eval := New(r[1])
+		eval.AddFunction("ran", func(args []object.Object) object.Object {
+			ran = append(ran, args[0].Inspect())
+			return &object.Boolean{Value: true}
+		})
+		err := eval.Prepare()
+		if err != nil {
+			t.Fatalf("failed to compile %s: %s", r[0], err)
+		}
+		err = set.AddRule(r[0], eval)
+		if err != nil {
+			t.Fatalf("failed to add %s: %s", r[0], err)
+		}
+	}
+
+	tests := []struct {
+		obj     map[string]interface{}
+		ran     string
+		matched string
+	}{
+		{map[string]interface{}{"type": "dns", "port": 53}, "dns,resolver,any", "dns,resolver,any"},
+		{map[string]interface{}{"type": "mdns", "port": 5353}, "resolver,any", "any"},
+		{map[string]interface{}{"type": "login", "port": 22}, "login,port,any", "login,port,any"},
+		{map[string]interface{}{"type": "http", "port": 8080}, "port,any", "port,any"},
+		{map[string]interface{}{"type": "http", "port": -1.0}, "port,any", "port,any"},
+		{map[string]interface{}{"type": 3}, "any", "any"},
+	}
+
+	for _, test := range tests {
+		ran = nil
+		res, err := set.Run(map[string]interface{}{"event": test.obj})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if strings.Join(ran, ",") != test.ran {
+			t.Fatalf("wrong rules run for %v, got %v expected %s", test.obj, ran, test.ran)
+		}
+		if strings.Join(res.Matched, ",") != test.matched {
+			t.Fatalf("wrong matches for %v, got %v expected %s", test.obj, res.Matched, test.matched)
+		}
+	}
+
+	// Objects without the field satisfy no guard.
+	ran = nil
+	_, err := set.Run(map[string]interface{}{"other": true})
+	if err != nil || strings.Join(ran, ",") != "any" {
+		t.Fatalf("wrong rules run without the field: %v %v", ran, err)
+	}
+
+	// A guard whose field becomes a variable once the rule has been
+	// added tests the variable, as the rule alone would.
+	eval := New(`// pragma guard kind == "dns"
+return true;`)
+	if err = eval.Prepare(); err != nil {
+		t.Fatalf("failed to compile: %s", err)
+	}
+	vars := NewRuleSet()
+	if err = vars.AddRule("kind", eval); err != nil {
+		t.Fatalf("failed to add: %s", err)
+	}
+	eval.SetVariable("kind", &object.String{Value: "dns"})
+	for _, field := range []string{"dns", "login"} {
+		res, err := vars.Run(map[string]interface{}{"kind": field})
+		if err != nil || strings.Join(res.Matched, ",") != "kind" {
+			t.Fatalf("wrong matches with the variable, for %s: %v %v", field, res, err)
+		}
+	}
+	eval.SetVariable("kind", &object.String{Value: "login"})
+	res, err := vars.Run(map[string]interface{}{"kind": "dns"})
+	if err != nil || len(res.Matched) != 0 {
+		t.Fatalf("wrong matches with the variable: %v %v", res, err)
+	}
+
+	// Guards may not refer to variables.
+	eval = New(`// pragma guard limit == 3
+return true;`)
+	eval.SetVariable("limit", &object.Integer{Value: 3})
+	err = eval.Prepare()
+	if err != nil {
+		t.Fatalf("failed to compile: %s", err)
+	}
+	err = set.AddRule("variable", eval)
+	if err == nil || !strings.Contains(err.Error(), "refers to the variable limit") {
+		t.Fatalf("expected an error with a variable, got %v", err)
+	}
+
+	// Invalid guards are reported by Prepare.
+	pragmas := []struct {
+		input string
+		err   string
+	}{
+		{`// pragma guard`, "line 1: the guard pragma is not a valid expression"},
+		{`// pragma guard type != "dns"`, "must compare a field via == or in"},
+		{`// pragma guard len(type) == 3`, "must compare a field, not"},
+		{`// pragma guard type == other`, "must compare a field with constants, not other"},
+		{`// pragma guard type in other`, "must compare a field with an array of constants"},
+		{`// pragma guard type in [ ]`, "must compare a field with an array of constants"},
+		{"// pragma guard a == 1\n// pragma guard b == 2", "line 2: the script already has a guard, upon line 1"},
+	}
+	for _, test := range pragmas {
+		err := New(test.input).Prepare()
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Fatalf("expected error '%s' for %s, got %v", test.err, test.input, err)
+		}
+	}
+}
+
+// TestRuleSetNamespaces tests grouping, and disabling, rules by their
+// namespaces.
+func TestRuleSetNamespaces(t *testing.T) {
+
+	set := NewRuleSet()
+	names := []string{"auth/bruteforce", "auth/spray", "authz/escalation", "net/dns/tunnel", "net"}
+	for _, name := range names {
+		eval := New(`return true;`)
+		eval.SetTracer(vm.NewTracer())
+		err := eval.Prepare()
+		if err != nil {
+			t.Fatalf("failed to compile %s: %s", name, err)
+		}
+		err = set.AddRule(name, eval)
+		if err != nil {
+			t.Fatalf("failed to add %s: %s", name, err)
+		}
+	}
+
+	groups := []struct {
+		namespace string
+		rules     string
+	}{
+		{"auth", "auth/bruteforce,auth/spray"},
+		{"auth/", "auth/bruteforce,auth/spray"},
+		{"auth/spray", "auth/spray"},
+		{"net", "net/dns/tunnel,net"},
+		{"net/dns", "net/dns/tunnel"},
+		{"missing", ""},
+		{"", strings.Join(names, ",")},
+	}
+	for _, test := range groups {
+		var found []string
+		for _, rule := range set.Group(test.namespace) {
+			found = append(found, rule.Name)
+		}
+		if strings.Join(found, ",") != test.rules {
+			t.Fatalf("wrong rules in %s, got %v expected %s", test.namespace, found, test.rules)
+		}
+	}
+
+	tests := []struct {
+		disable string
+		enable  string
+		count   int
+		matched string
+	}{
+		{"auth", "", 2, "authz/escalation,net/dns/tunnel,net"},
+		{"net/dns", "", 1, "authz/escalation,net"},
+		{"", "net", 2, "authz/escalation,net/dns/tunnel,net"},
+		{"", "", 5, ""},
+		{"", "auth/spray", 1, "auth/spray"},
+	}
+	for _, test := range tests {
+		var count int
+		if test.disable != "" {
+			count = set.Disable(test.disable)
+		} else if test.enable != "" {
+			count = set.Enable(test.enable)
+		} else {
+			count = set.Disable("")
+		}
+		if count != test.count {
+			t.Fatalf("wrong count of rules, got %d expected %d", count, test.count)
+		}
+
+		res, err := set.Run(nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if strings.Join(res.Matched, ",") != test.matched {
+			t.Fatalf("wrong matches, got %v expected %s", res.Matched, test.matched)
+		}
+	}
+
+	// Verdicts, and traces, are named for their rules.
+	res, err := set.Run(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if res.Verdicts["auth/spray"].Rule != "auth/spray" {
+		t.Fatalf("the verdict wasn't named: %+v", res.Verdicts)
+	}
+	rule := set.Group("auth/spray")[0]
+	if rule.Eval.tracer.Rule != "auth/spray" || len(rule.Eval.tracer.Steps) == 0 {
+		t.Fatalf("the trace wasn't named: %+v", rule.Eval.tracer)
+	}
+	rule.Eval.Run(nil)
+	if rule.Eval.tracer.Rule != "" {
+		t.Fatalf("the trace of a run outside the set was named %s", rule.Eval.tracer.Rule)
+	}
+}
+
+// TestRuleSetQuotas tests the quotas of rules, and of tenants.
+func TestRuleSetQuotas(t *testing.T) {
+
+	loop := `i = 0; while ( i < 100000000 ) { i = i + 1; } return true;`
+
+	build := func(scripts map[string]string, names ...string) *RuleSet {
+		set := NewRuleSet()
+		for _, name := range names {
+			eval := New(scripts[name])
+			err := eval.Prepare()
+			if err != nil {
+				t.Fatalf("failed to compile %s: %s", name, err)
+			}
+			err = set.AddRule(name, eval)
+			if err != nil {
+				t.Fatalf("failed to add %s: %s", name, err)
+			}
+		}
+		return set
+	}
+	scripts := map[string]string{
+		"acme/loop": loop,
+		"acme/ok":   `return true;`,
+		"beta/ok":   `return true;`,
+	}
+
+	check := func(set *RuleSet, matched string, tripped string) {
+		t.Helper()
+		res, err := set.Run(nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if strings.Join(res.Matched, ",") != matched {
+			t.Fatalf("wrong matches, got %v expected %s", res.Matched, matched)
+		}
+		if strings.Join(res.Tripped, ",") != tripped {
+			t.Fatalf("wrong trips, got %v expected %s", res.Tripped, tripped)
+		}
+	}
+
+	// A rule is tripped after exceeding its quota twice.
+	set := build(scripts, "acme/loop", "acme/ok", "beta/ok")
+	err := set.SetQuota("acme/loop", Quota{Instructions: 100, Failures: 2})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var trips []Trip
+	set.OnTrip(func(trip Trip) { trips = append(trips, trip) })
+
+	check(set, "acme/ok,beta/ok", "acme/loop")
+	if len(trips) != 0 {
+		t.Fatalf("tripped too soon: %v", trips)
+	}
+	check(set, "acme/ok,beta/ok", "acme/loop")
+	if len(trips) != 1 || trips[0].Rule != "acme/loop" || !trips[0].Until.IsZero() {
+		t.Fatalf("wrong trips: %v", trips)
+	}
+	if _, ok := trips[0].Err.(*vm.InstructionLimitError); !ok {
+		t.Fatalf("wrong error: %v", trips[0].Err)
+	}
+	check(set, "acme/ok,beta/ok", "acme/loop")
+	if len(trips) != 1 {
+		t.Fatalf("tripped again: %v", trips)
+	}
+
+	// Enabling the rule lets it run again, but it still fails.
+	set.Enable("acme")
+	check(set, "acme/ok,beta/ok", "acme/loop")
+	check(set, "acme/ok,beta/ok", "acme/loop")
+	if len(trips) != 2 {
+		t.Fatalf("wrong trips: %v", trips)
+	}
+
+	// A tenant's rules share its quota, and the rules which follow
+	// the one that exhausted it aren't run.
+	set = build(scripts, "acme/loop", "acme/ok", "beta/ok")
+	err = set.SetTenantQuota("acme", Quota{Instructions: 1000, Cooldown: time.Hour})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	trips = nil
+	set.OnTrip(func(trip Trip) { trips = append(trips, trip) })
+
+	check(set, "beta/ok", "acme/loop,acme/ok")
+	if len(trips) != 1 || trips[0].Tenant != "acme" || trips[0].Until.IsZero() {
+		t.Fatalf("wrong trips: %v", trips)
+	}
+	check(set, "beta/ok", "acme/loop,acme/ok")
+
+	// Whilst a tenant whose rules keep within its quota is fine.
+	set = build(scripts, "acme/ok", "beta/ok")
+	err = set.SetTenantQuota("acme", Quota{Instructions: 1000})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	check(set, "acme/ok,beta/ok", "")
+
+	// A predicate is charged for its instructions, even when there's
+	// no limit upon them.
+	pred := New(`return Status == "active" && Age > 18;`)
+	err = pred.Prepare()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !pred.machine.IsPredicate() {
+		t.Fatalf("expected a predicate")
+	}
+	charged := 0
+	for _, lim := range []limits{{}, {instructions: 1000}, {}} {
+		_, s, err := pred.runWithin(map[string]interface{}{"Status": "active", "Age": 21}, "pred", lim)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if s.executed <= 0 || (charged > 0 && s.executed != charged) {
+			t.Fatalf("the predicate was charged %d with %+v", s.executed, lim)
+		}
+		charged = s.executed
+	}
+
+	// A rule may be limited by time, and cool down.
+	for _, cooldown := range []time.Duration{time.Hour, time.Millisecond} {
+		set = build(scripts, "acme/loop", "beta/ok")
+		err = set.SetQuota("acme/loop", Quota{Time: 10 * time.Millisecond, Cooldown: cooldown})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		trips = nil
+		set.OnTrip(func(trip Trip) { trips = append(trips, trip) })
+		check(set, "beta/ok", "acme/loop")
+		if len(trips) != 1 || trips[0].Rule != "acme/loop" {
+			t.Fatalf("wrong trips: %v", trips)
+		}
+
+		time.Sleep(5 * time.Millisecond)
+		check(set, "beta/ok", "acme/loop")
+		if cooldown == time.Hour && len(trips) != 1 {
+			t.Fatalf("tripped whilst cooling down: %v", trips)
+		}
+		if cooldown != time.Hour && len(trips) != 2 {
+			t.Fatalf("not run after cooling down: %v", trips)
+		}
+	}
+
+	// Invalid quotas are rejected.
+	err = set.SetQuota("acme", Quota{Instructions: -1})
+	if err == nil || !strings.Contains(err.Error(), "may not be negative") {
+		t.Fatalf("expected an error, got %v", err)
+	}
+	err = set.SetTenantQuota("acme", Quota{Time: -1})
+	if err == nil || !strings.Contains(err.Error(), "may not be negative") {
+		t.Fatalf("expected an error, got %v", err)
+	}
+	err = set.SetQuota("missing", Quota{Instructions: 1})
+	if err == nil || !strings.Contains(err.Error(), "there are no rules") {
+		t.Fatalf("expected an error, got %v", err)
+	}
+}
+
+// TestRuleSetErrorPolicy tests continuing to run a set when rules fail.
+func TestRuleSetErrorPolicy(t *testing.T) {
+
+	set := NewRuleSet()
+	rules := [][2]string{
+		{"broken", `return Count + "x" == "3x";`},
+		{"after", "// pragma requires broken\nreturn true;"},
+		{"ok", `return Count == 3;`},
+	}
+	for _, r := range rules {
+		eval := New(r[1])
+		err := eval.Prepare()
+		if err != nil {
+			t.Fatalf("failed to compile %s: %s", r[0], err)
+		}
+		err = set.AddRule(r[0], eval)
+		if err != nil {
+			t.Fatalf("failed to add %s: %s", r[0], err)
+		}
+	}
+
+	obj := struct{ Count int }{3}
+
+	// By default a failure stops the run.
+	_, err := set.Run(obj)
+	if err == nil || !strings.Contains(err.Error(), "rule broken:") {
+		t.Fatalf("expected an error, got %v", err)
+	}
+	var rerr *RuleError
+	var runtime *RuntimeError
+	if !errors.As(err, &rerr) || rerr.Name != "broken" || !errors.As(err, &runtime) {
+		t.Fatalf("the error doesn't identify the rule, and its failure: %#v", err)
+	}
+
+	err = set.SetErrorPolicy(ErrorsContinue)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	res, err := set.Run(obj)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if strings.Join(res.Matched, ",") != "ok" {
+		t.Fatalf("wrong matches: %v", res.Matched)
+	}
+	if len(res.Errors) != 1 || !strings.Contains(res.Errors["broken"].Error(), "rule broken:") {
+		t.Fatalf("wrong errors: %v", res.Errors)
+	}
+	if !errors.As(res.Errors["broken"], &runtime) {
+		t.Fatalf("the error of the rule was lost: %#v", res.Errors["broken"])
+	}
+
+	// Whilst another object fails a different rule.
+	res, err = set.Run(struct{ Count string }{"3"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(res.Errors) != 1 || res.Errors["ok"] == nil || strings.Join(res.Matched, ",") != "broken,after" {
+		t.Fatalf("wrong result: %v %v", res.Matched, res.Errors)
+	}
+
+	err = set.SetErrorPolicy(ErrorPolicy(7))
+	if err == nil || !strings.Contains(err.Error(), "unknown error policy") {
+		t.Fatalf("expected an error, got %v", err)
+	}
+}
@@ -0,0 +1,267 @@
+// This file contains the binding of a schema, which declares the fields
+// of the objects a script is run against, and their types.
+//
+// Without a schema we can't tell a field from a typo, as a name which is
+// neither a variable nor a field is simply null.  A rule such as:
+//
+//    if ( Cuont > 3 ) { return true; }
+//
+// compiles happily, and never matches.  Once a schema has been bound the
+// names a script looks up must be fields of the schema, variables, or
+// functions, and the fields may only be compared with, or combined with,
+// values of a compatible type - so both mistakes are reported by Prepare.
+//
+// The fields of the schema are also looked up via OpLookupField, rather
+// than OpLookup, which remembers the value it finds by its index, rather
+// than by name, for the rest of the run.
+
+package evalfilter
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/skx/evalfilter/v2/ast"
+	"github.com/skx/evalfilter/v2/object"
+)
+
+// schemaTypes holds the types the fields of a schema may have.
+var schemaTypes = map[object.Type]bool{
+	object.ARRAY:   true,
+	object.BOOLEAN: true,
+	object.BYTES:   true,
+	object.FLOAT:   true,
+	object.HASH:    true,
+	object.INTEGER: true,
+	object.NULL:    true,
+	object.STRING:  true,
+	object.TIME:    true,
+}
+
+// schemaOperators holds the operators whose operands are checked against
+// the schema, as they fail when given values of incompatible types.
+var schemaOperators = map[string]bool{
+	"==": true, "!=": true,
+	"<": true, "<=": true, ">": true, ">=": true,
+	"+": true, "-": true, "*": true, "/": true, "%": true, "**": true,
+	"~=": true, "!~": true,
+}
+
+// BindSchema declares the fields of the objects the script will be run
+// against, and the type of each, such as object.INTEGER or object.TIME.
+//
+// When the script is prepared any name it looks up which isn't a field
+// of the schema, a variable, or a function, is an error - as is using a
+// field with a value of an incompatible type, such as comparing a field
+// of type object.INTEGER with a string.  Variables set by the host must
+// be set before the script is prepared, to be told from typos.
+//
+// Members of fields, such as `Request.Host`, are only checked for the
+// field they begin with, which must be an array or a hash.  Nested
+// structures are presented as hashes.
+//
+// A field of type object.NULL is one whose type isn't known, such as a
+// field which holds values of differing types, and is only checked by
+// name.
+//
+// An error is returned if a type isn't one a field may have, in which
+// case the schema isn't changed.  A nil schema removes any which was
+// bound before.  This should be done before Prepare is invoked.
+func (e *Eval) BindSchema(schema map[string]object.Type) error {
+
+	bound := make(map[string]object.Type, len(schema))
+	for name, t := range schema {
+		if !schemaTypes[t] {
+			return fmt.Errorf("the field %s has the unknown type %s", name, t)
+		}
+		bound[name] = t
+	}
+
+	e.schema = bound
+	if schema == nil {
+		e.schema = nil
+	}
+	return nil
+}
+
+// bindSchema finds the names the given program changes, and the functions
+// it defines, which are never fields of the schema.
+func (e *Eval) bindSchema(program *ast.Program) {
+
+	e.written = nil
+	e.defined = nil
+	if e.schema == nil {
+		return
+	}
+
+	// If we find something we don't understand then we can't be sure
+	// which names are fields, so we check nothing.
+	counts := make(map[string]int)
+	if !writes(program, counts) {
+		return
+	}
+
+	e.written = make(map[string]bool, len(counts))
+	for name := range counts {
+		e.written[name] = true
+	}
+
+	e.defined = definedFunctions(program)
+}
+
+// definedFunctions returns the names of the functions the given program
+// defines.
+func definedFunctions(program *ast.Program) map[string]bool {
+
+	defined := make(map[string]bool)
+	for _, s := range program.Statements {
+		if stmt, ok := s.(*ast.ExpressionStatement); ok {
+			if fn, ok := stmt.Expression.(*ast.FunctionDefinition); ok {
+				defined[fn.Token.Literal] = true
+			}
+		}
+	}
+	return defined
+}
+
+// schemaField returns the type of the field of the schema which the given
+// name refers to, or false if it isn't one - or we can't be sure.
+func (e *Eval) schemaField(name string) (object.Type, bool) {
+
+	if e.written == nil {
+		return "", false
+	}
+	name = variable(name)
+	t, ok := e.schema[name]
+	if !ok || e.written[name] {
+		return "", false
+	}
+	if _, ok := e.environment.Get(name); ok {
+		return "", false
+	}
+	return t, true
+}
+
+// checkName returns an error if the given name, which the script looks
+// up, isn't a field of the schema, a variable, or a function.
+func (e *Eval) checkName(name string) error {
+
+	if e.written == nil {
+		return nil
+	}
+	name = variable(name)
+	if _, ok := e.schema[name]; ok || e.written[name] || e.defined[name] {
+		return nil
+	}
+	if _, ok := e.environment.Get(name); ok {
+		return nil
+	}
+	if _, ok := e.environment.GetFunction(name); ok {
+		return nil
+	}
+
+	// Suggest the field the author probably meant.
+	var similar []string
+	for field := range e.schema {
+		if strings.EqualFold(field, name) {
+			similar = append(similar, field)
+		}
+	}
+	if len(similar) > 0 {
+		sort.Strings(similar)
+		return fmt.Errorf("unknown field %s, did you mean %s?", name, strings.Join(similar, " or "))
+	}
+	return fmt.Errorf("unknown field %s", name)
+}
+
+// checkPath returns an error if the given path, such as "Request.Host",
+// doesn't begin with a field which may contain others.
+func (e *Eval) checkPath(path string) error {
+
+	root := strings.SplitN(path, ".", 2)[0]
+	if err := e.checkName(root); err != nil {
+		return err
+	}
+	if t, ok := e.schemaField(root); ok && t != object.HASH && t != object.ARRAY && t != object.NULL {
+		return fmt.Errorf("the field %s is %s, and has no members", variable(root), t)
+	}
+	return nil
+}
+
+// checkOperands returns an error if the given expression uses a field of
+// the schema with a value of an incompatible type.
+func (e *Eval) checkOperands(node *ast.InfixExpression) error {
+
+	if !schemaOperators[node.Operator] {
+		return nil
+	}
+	left, lok := e.staticType(node.Left)
+	right, rok := e.staticType(node.Right)
+	if !lok || !rok || compatible(left, right) {
+		return nil
+	}
+
+	// We only complain about the fields of the schema, the
+	// machine reports mistakes with literals as it always has.
+	_, lfield := node.Left.(*ast.Identifier)
+	_, rfield := node.Right.(*ast.Identifier)
+	if !lfield && !rfield {
+		return nil
+	}
+	return fmt.Errorf("type mismatch: %s is %s, and %s is %s", operand(node.Left), left, operand(node.Right), right)
+}
+
+// staticType returns the type of the given expression, if it is a field
+// of the schema or a literal.
+func (e *Eval) staticType(node ast.Expression) (object.Type, bool) {
+
+	switch n := node.(type) {
+	case *ast.Identifier:
+		t, ok := e.schemaField(n.Value)
+		return t, ok && t != object.NULL
+	case *ast.BooleanLiteral:
+		return object.BOOLEAN, true
+	case *ast.FloatLiteral:
+		return object.FLOAT, true
+	case *ast.IntegerLiteral:
+		return object.INTEGER, true
+	case *ast.RegexpLiteral:
+		return object.REGEXP, true
+	case *ast.StringLiteral:
+		return object.STRING, true
+	}
+	return "", false
+}
+
+// compatible returns true if values of the given types may be compared,
+// or combined, with each other.
+func compatible(left, right object.Type) bool {
+
+	number := func(t object.Type) bool {
+		return t == object.INTEGER || t == object.FLOAT
+	}
+
+	switch {
+	case left == right:
+		return true
+	case number(left) && number(right):
+		return true
+	case left == object.STRING && right == object.REGEXP:
+		return true
+	case left == object.TIME && number(right), number(left) && right == object.TIME:
+		return true
+	case left == object.BYTES && (right == object.STRING || right == object.REGEXP), left == object.STRING && right == object.BYTES:
+		return true
+	}
+	return false
+}
+
+// operand returns a description of the given operand, for an error.
+func operand(node ast.Expression) string {
+
+	if ident, ok := node.(*ast.Identifier); ok {
+		return "the field " + variable(ident.Value)
+	}
+	return node.String()
+}
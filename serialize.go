@@ -0,0 +1,531 @@
+// This file contains the serialization of compiled programs.
+//
+// Compiling a script involves lexing, parsing, compiling, and optimizing
+// it.  Applications which run the same scripts in many processes may
+// prefer to do that once, saving the result via `MarshalBytecode`, and
+// later load it via `NewFromBytecode`.
+//
+// The program is saved after it has been optimized, and consists of the
+// constant-pool, the bytecode of the main program and of each function,
+// the descriptions of its loops, and the version of the language it is
+// run by.  It is preceded by a magic number, and the version of the
+// format, and is verified when it is loaded so that invalid bytecode is
+// never executed.
+
+package evalfilter
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/skx/evalfilter/v2/code"
+	"github.com/skx/evalfilter/v2/environment"
+	"github.com/skx/evalfilter/v2/object"
+	"github.com/skx/evalfilter/v2/vm"
+)
+
+// bytecodeMagic is the magic number which begins saved programs.
+const bytecodeMagic = "EVFB"
+
+// BytecodeVersion is the version of the format of the programs saved by
+// MarshalBytecode.  Programs saved in earlier versions may be loaded, but
+// those saved in later versions can't be.
+//
+// Version 1 didn't record the version of the language, as there was only
+// one.
+const BytecodeVersion = 2
+
+// The tags which identify each type of constant.
+const (
+	tagNull     = 'n'
+	tagBoolean  = 'b'
+	tagInteger  = 'i'
+	tagFloat    = 'f'
+	tagString   = 's'
+	tagRegexp   = 'r'
+	tagArray    = 'a'
+	tagHash     = 'h'
+	tagFunction = 'F'
+)
+
+// MarshalBytecode returns the compiled program, which may be saved and
+// later loaded via `NewFromBytecode`.
+//
+// `Prepare` must have been invoked first.
+func (e *Eval) MarshalBytecode() ([]byte, error) {
+
+	if e.machine == nil {
+		return nil, fmt.Errorf("the script has not been prepared")
+	}
+
+	image := e.machine.Image()
+
+	w := &writer{buf: []byte(bytecodeMagic)}
+	w.uint(BytecodeVersion)
+
+	// The constants.
+	w.uint(uint64(len(image.Constants)))
+	for _, c := range image.Constants {
+		err := w.object(c)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The main program.
+	w.bytes(image.Bytecode)
+
+	// The functions, sorted by name so that the same program is
+	// always saved in the same way.
+	var names []string
+	for name := range image.Functions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w.uint(uint64(len(names)))
+	for _, name := range names {
+		fn := image.Functions[name]
+		w.string(name)
+		w.strings(fn.Arguments)
+		w.bytes(fn.Bytecode)
+	}
+
+	// The loops.
+	w.uint(uint64(len(image.Loops)))
+	for _, l := range image.Loops {
+		w.string(l.Kind)
+		w.int(int64(l.Line))
+		w.int(int64(l.Limit))
+	}
+
+	// The lookups which were moved out of loops.
+	w.uint(uint64(image.Slots))
+
+	// The version of the language.
+	w.uint(uint64(image.Language))
+
+	return w.buf, nil
+}
+
+// NewFromBytecode creates a new instance of the evaluator, which runs
+// the program previously returned by `MarshalBytecode`.
+//
+// The evaluator is ready to run, and `Prepare` must not be invoked upon
+// it, as it has no script to compile.
+func NewFromBytecode(data []byte) (*Eval, error) {
+
+	if len(data) < len(bytecodeMagic) || string(data[:len(bytecodeMagic)]) != bytecodeMagic {
+		return nil, fmt.Errorf("failed to load bytecode: the data doesn't contain a program")
+	}
+
+	r := &reader{buf: data, off: len(bytecodeMagic)}
+	image := &vm.Image{Functions: make(map[string]environment.UserFunction)}
+
+	// The version.
+	version := r.uint()
+	if r.err == nil && (version < 1 || version > BytecodeVersion) {
+		return nil, fmt.Errorf("failed to load bytecode: version %d isn't supported, only versions up to %d", version, BytecodeVersion)
+	}
+
+	// The constants.
+	count := r.uint()
+	for i := uint64(0); i < count && r.err == nil; i++ {
+		image.Constants = append(image.Constants, r.object(0))
+	}
+
+	// The main program.
+	image.Bytecode = r.bytes()
+
+	// The functions.
+	count = r.uint()
+	for i := uint64(0); i < count && r.err == nil; i++ {
+		name := r.string()
+		image.Functions[name] = environment.UserFunction{
+			Arguments: r.strings(),
+			Bytecode:  r.bytes(),
+		}
+	}
+
+	// The loops.
+	count = r.uint()
+	for i := uint64(0); i < count && r.err == nil; i++ {
+		image.Loops = append(image.Loops, vm.Loop{
+			Kind:  r.string(),
+			Line:  int(r.int()),
+			Limit: int(r.int()),
+		})
+	}
+
+	// The lookups which were moved out of loops.
+	slots := r.uint()
+	if slots > 0x10000 {
+		r.fail("too many slots, %d", slots)
+	}
+	image.Slots = int(slots)
+
+	// The version of the language.
+	image.Language = vm.Language1
+	if version > 1 {
+		language := r.uint()
+		if language < vm.Language1 || language > vm.LatestLanguage {
+			r.fail("unknown language version %d", language)
+		}
+		image.Language = int(language)
+	}
+
+	if r.err == nil && r.off != len(r.buf) {
+		r.fail("%d unexpected bytes at the end", len(r.buf)-r.off)
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("failed to load bytecode: %s", r.err)
+	}
+
+	// Ensure the program is valid, before we might run it.
+	err := verifyImage(image)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load bytecode: %s", err)
+	}
+
+	e := &Eval{
+		environment:  environment.New(),
+		context:      context.Background(),
+		constants:    image.Constants,
+		instructions: image.Bytecode,
+		functions:    image.Functions,
+		loops:        image.Loops,
+		iterations:   vm.DefaultLoopLimit,
+		arena:        true,
+		loaded:       true,
+		mutex:        sync.Mutex{},
+	}
+	e.machine = vm.Load(image, e.environment)
+	e.machine.SetContext(e.context)
+	e.machine.SetLoopLimit(e.iterations)
+	e.machine.SetArena(e.arena)
+
+	return e, nil
+}
+
+// verifyImage tests that the bytecode of the given program is valid.
+func verifyImage(image *vm.Image) error {
+
+	err := verifyBytecode("main", image.Bytecode, image)
+	if err != nil {
+		return err
+	}
+	for name, fn := range image.Functions {
+		err = verifyBytecode(name, fn.Bytecode, image)
+		if err != nil {
+			return err
+		}
+	}
+	for i, c := range image.Constants {
+		if fn, ok := c.(*object.Function); ok {
+			err = verifyBytecode(fmt.Sprintf("constant %d", i), fn.Bytecode, image)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// verifyBytecode tests that the given bytecode is valid, and that it only
+// refers to the constants, loops, and slots, of the program which exist.
+func verifyBytecode(name string, ins code.Instructions, image *vm.Image) error {
+
+	err := code.Verify(ins, len(image.Constants))
+	if err != nil {
+		return fmt.Errorf("%s: %s", name, err)
+	}
+
+	for ip := 0; ip < len(ins); ip += code.Length(code.Opcode(ins[ip])) {
+
+		op := code.Opcode(ins[ip])
+		if code.Length(op) == 1 {
+			continue
+		}
+		arg := int(binary.BigEndian.Uint16(ins[ip+1 : ip+3]))
+
+		switch op {
+		case code.OpClosure:
+			if _, ok := image.Constants[arg].(*object.Function); !ok {
+				return fmt.Errorf("%s: OpClosure at offset %04d refers to a %s, not a function", name, ip, image.Constants[arg].Type())
+			}
+		case code.OpJumpTable:
+			table, ok := image.Constants[arg].(*object.Hash)
+			if !ok {
+				return fmt.Errorf("%s: OpJumpTable at offset %04d refers to a %s, not a hash", name, ip, image.Constants[arg].Type())
+			}
+			for _, pair := range table.Pairs {
+				dst, ok := pair.Value.(*object.Integer)
+				if !ok || dst.Value < 0 || dst.Value > int64(len(ins)) {
+					return fmt.Errorf("%s: OpJumpTable at offset %04d has the invalid destination %s", name, ip, pair.Value.Inspect())
+				}
+			}
+		case code.OpLoopEnter, code.OpLoopCount:
+			if arg >= len(image.Loops) {
+				return fmt.Errorf("%s: %s at offset %04d refers to loop %d, which doesn't exist", name, code.String(op), ip, arg)
+			}
+		case code.OpLoadSlot, code.OpStoreSlot:
+			if arg >= image.Slots {
+				return fmt.Errorf("%s: %s at offset %04d refers to slot %d, which doesn't exist", name, code.String(op), ip, arg)
+			}
+		}
+	}
+	return nil
+}
+
+// writer appends the values which make up a program to a buffer.
+type writer struct {
+	buf []byte
+}
+
+// uint appends an unsigned integer.
+func (w *writer) uint(val uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], val)
+	w.buf = append(w.buf, tmp[:n]...)
+}
+
+// int appends a signed integer.
+func (w *writer) int(val int64) {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(tmp[:], val)
+	w.buf = append(w.buf, tmp[:n]...)
+}
+
+// bytes appends a series of bytes, prefixed by their length.
+func (w *writer) bytes(val []byte) {
+	w.uint(uint64(len(val)))
+	w.buf = append(w.buf, val...)
+}
+
+// string appends a string, prefixed by its length.
+func (w *writer) string(val string) {
+	w.bytes([]byte(val))
+}
+
+// strings appends a list of strings, prefixed by their count.
+func (w *writer) strings(val []string) {
+	w.uint(uint64(len(val)))
+	for _, s := range val {
+		w.string(s)
+	}
+}
+
+// object appends a constant.
+func (w *writer) object(obj object.Object) error {
+
+	switch o := obj.(type) {
+	case *object.Null:
+		w.buf = append(w.buf, tagNull)
+	case *object.Boolean:
+		w.buf = append(w.buf, tagBoolean)
+		if o.Value {
+			w.uint(1)
+		} else {
+			w.uint(0)
+		}
+	case *object.Integer:
+		w.buf = append(w.buf, tagInteger)
+		w.int(o.Value)
+	case *object.Float:
+		w.buf = append(w.buf, tagFloat)
+		w.uint(math.Float64bits(o.Value))
+	case *object.String:
+		w.buf = append(w.buf, tagString)
+		w.string(o.Value)
+	case *object.Regexp:
+		w.buf = append(w.buf, tagRegexp)
+		w.string(o.Value)
+	case *object.Array:
+		w.buf = append(w.buf, tagArray)
+		w.uint(uint64(len(o.Elements)))
+		for _, el := range o.Elements {
+			err := w.object(el)
+			if err != nil {
+				return err
+			}
+		}
+	case *object.Hash:
+		w.buf = append(w.buf, tagHash)
+		pairs := o.Entries()
+		w.uint(uint64(len(pairs)))
+		for _, p := range pairs {
+			err := w.object(p.Key)
+			if err != nil {
+				return err
+			}
+			err = w.object(p.Value)
+			if err != nil {
+				return err
+			}
+		}
+	case *object.Function:
+		if o.Builtin != nil {
+			return fmt.Errorf("the function %s is provided by the host, and can't be saved", o.Name)
+		}
+		w.buf = append(w.buf, tagFunction)
+		w.string(o.Name)
+		w.strings(o.Arguments)
+		w.bytes(o.Bytecode)
+		w.strings(o.Free)
+	default:
+		return fmt.Errorf("constants of type %s can't be saved", obj.Type())
+	}
+	return nil
+}
+
+// reader reads the values which make up a program from a buffer.
+//
+// The first error encountered is recorded, after which each method
+// returns an empty value.
+type reader struct {
+	buf []byte
+	off int
+	err error
+}
+
+// maxDepth is the deepest nesting of arrays, and hashes, we'll load.
+const maxDepth = 100
+
+// fail records an error, unless one was already recorded.
+func (r *reader) fail(format string, args ...interface{}) {
+	if r.err == nil {
+		r.err = fmt.Errorf(format, args...)
+	}
+}
+
+// uint reads an unsigned integer.
+func (r *reader) uint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	val, n := binary.Uvarint(r.buf[r.off:])
+	if n <= 0 {
+		r.fail("truncated integer at offset %d", r.off)
+		return 0
+	}
+	r.off += n
+	return val
+}
+
+// int reads a signed integer.
+func (r *reader) int() int64 {
+	if r.err != nil {
+		return 0
+	}
+	val, n := binary.Varint(r.buf[r.off:])
+	if n <= 0 {
+		r.fail("truncated integer at offset %d", r.off)
+		return 0
+	}
+	r.off += n
+	return val
+}
+
+// bytes reads a series of bytes, prefixed by their length.
+func (r *reader) bytes() []byte {
+	n := r.uint()
+	if r.err != nil {
+		return nil
+	}
+	if n > uint64(len(r.buf)-r.off) {
+		r.fail("truncated data at offset %d", r.off)
+		return nil
+	}
+	out := make([]byte, n)
+	copy(out, r.buf[r.off:])
+	r.off += int(n)
+	return out
+}
+
+// string reads a string, prefixed by its length.
+func (r *reader) string() string {
+	return string(r.bytes())
+}
+
+// strings reads a list of strings, prefixed by their count.
+func (r *reader) strings() []string {
+	var out []string
+	count := r.uint()
+	for i := uint64(0); i < count && r.err == nil; i++ {
+		out = append(out, r.string())
+	}
+	return out
+}
+
+// object reads a constant, which is nested within the given number of
+// arrays, or hashes.
+func (r *reader) object(depth int) object.Object {
+
+	if r.err != nil {
+		return nil
+	}
+	if r.off >= len(r.buf) {
+		r.fail("truncated constant at offset %d", r.off)
+		return nil
+	}
+	if depth > maxDepth {
+		r.fail("constants nested too deeply at offset %d", r.off)
+		return nil
+	}
+
+	tag := r.buf[r.off]
+	r.off++
+
+	switch tag {
+	case tagNull:
+		return &object.Null{}
+	case tagBoolean:
+		return &object.Boolean{Value: r.uint() != 0}
+	case tagInteger:
+		return &object.Integer{Value: r.int()}
+	case tagFloat:
+		return &object.Float{Value: math.Float64frombits(r.uint())}
+	case tagString:
+		return &object.String{Value: r.string()}
+	case tagRegexp:
+		return &object.Regexp{Value: r.string()}
+	case tagArray:
+		arr := &object.Array{}
+		count := r.uint()
+		for i := uint64(0); i < count && r.err == nil; i++ {
+			arr.Elements = append(arr.Elements, r.object(depth+1))
+		}
+		return arr
+	case tagHash:
+		hash := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
+		count := r.uint()
+		for i := uint64(0); i < count && r.err == nil; i++ {
+			key := r.object(depth + 1)
+			val := r.object(depth + 1)
+			if r.err != nil {
+				break
+			}
+			hashed, ok := key.(object.Hashable)
+			if !ok {
+				r.fail("hash key of type %s at offset %d", key.Type(), r.off)
+				break
+			}
+			hash.Pairs[hashed.HashKey()] = object.HashPair{Key: key, Value: val}
+		}
+		return hash
+	case tagFunction:
+		return &object.Function{
+			Name:      r.string(),
+			Arguments: r.strings(),
+			Bytecode:  code.Instructions(r.bytes()),
+			Free:      r.strings(),
+		}
+	}
+
+	r.fail("unknown constant type 0x%02X at offset %d", tag, r.off-1)
+	return nil
+}
@@ -0,0 +1,183 @@
+package evalfilter
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/skx/evalfilter/v2/code"
+	"github.com/skx/evalfilter/v2/object"
+)
+
+// TestBytecodeRoundTrip tests that programs which are saved, and loaded,
+// behave as the originals did.
+func TestBytecodeRoundTrip(t *testing.T) {
+
+	type Input struct {
+		Name  string
+		Items []int
+	}
+
+	scripts := []string{
+		`return Name == "steve";`,
+		`return 3.5 * 2 == 7.0 && Name ~= /^ste/i;`,
+		`total = 0; foreach item in Items { if ( item > Limit ) { total += item; } } return total;`,
+		`function double( x ) { return x * 2; } function sum( a ) { local t; t = 0; foreach i in a { t += i; } return t; } return sum( map( Items, double ) );`,
+		`function adder( n ) { return function( x ) { return x + n; }; } return adder( 5 )( 1 );`,
+		`switch ( Name ) { case "bob" { return 1; } case "steve" { return 2; } default { return 3; } }`,
+		`h = { "a": [ 1, 2 ], "b": -3 }; return h["a"][1] + h["b"];`,
+		`i = 0; while ( true ) { i++; if ( i > 3 ) { break; } } return i;`,
+	}
+
+	obj := Input{Name: "Steve", Items: []int{1, 5, 10}}
+
+	for _, flags := range [][]byte{nil, {NoOptimize}} {
+		for _, script := range scripts {
+
+			orig := New(script)
+			orig.SetVariable("Limit", &object.Integer{Value: 4})
+			err := orig.Prepare(flags)
+			if err != nil {
+				t.Fatalf("failed to compile %s: %s", script, err)
+			}
+			expected, err := orig.Execute(obj)
+			if err != nil {
+				t.Fatalf("failed to run %s: %s", script, err)
+			}
+
+			data, err := orig.MarshalBytecode()
+			if err != nil {
+				t.Fatalf("failed to save %s: %s", script, err)
+			}
+
+			loaded, err := NewFromBytecode(data)
+			if err != nil {
+				t.Fatalf("failed to load %s: %s", script, err)
+			}
+			loaded.SetVariable("Limit", &object.Integer{Value: 4})
+
+			out, err := loaded.Execute(obj)
+			if err != nil {
+				t.Fatalf("failed to run loaded %s: %s", script, err)
+			}
+			if out.Inspect() != expected.Inspect() {
+				t.Fatalf("unexpected result for %s: %s != %s", script, out.Inspect(), expected.Inspect())
+			}
+
+			// Saving it again gives the same data.
+			again, err := loaded.MarshalBytecode()
+			if err != nil {
+				t.Fatalf("failed to save loaded %s: %s", script, err)
+			}
+			if string(again) != string(data) {
+				t.Fatalf("the program %s changed when it was loaded", script)
+			}
+		}
+	}
+}
+
+// TestBytecodeSettings tests that the settings of a loaded program may be
+// changed.
+func TestBytecodeSettings(t *testing.T) {
+
+	orig := New(`
+// pragma loop-limit 5
+i = 0; while ( true ) { i++; }`)
+	err := orig.Prepare()
+	if err != nil {
+		t.Fatalf("failed to compile: %s", err)
+	}
+	data, err := orig.MarshalBytecode()
+	if err != nil {
+		t.Fatalf("failed to save: %s", err)
+	}
+
+	loaded, err := NewFromBytecode(data)
+	if err != nil {
+		t.Fatalf("failed to load: %s", err)
+	}
+
+	// The limit of the pragma is kept.
+	_, err = loaded.Execute(nil)
+	if err == nil || !strings.Contains(err.Error(), "the while loop on line 3 exceeded the limit of 5 iterations") {
+		t.Fatalf("expected a loop-limit error, got %v", err)
+	}
+
+	// Host functions are found by name.
+	loaded, err = NewFromBytecode(mustSave(t, `return greet( "world" );`))
+	if err != nil {
+		t.Fatalf("failed to load: %s", err)
+	}
+	loaded.AddFunction("greet", func(args []object.Object) object.Object {
+		return &object.String{Value: "hello " + args[0].Inspect()}
+	})
+	out, err := loaded.Execute(nil)
+	if err != nil || out.Inspect() != "hello world" {
+		t.Fatalf("unexpected result %v %v", out, err)
+	}
+
+	// There's nothing to prepare.
+	err = loaded.Prepare()
+	if err == nil || !strings.Contains(err.Error(), "loaded from bytecode") {
+		t.Fatalf("expected an error preparing a loaded program, got %v", err)
+	}
+}
+
+// TestBytecodeInvalid tests that invalid data is rejected.
+func TestBytecodeInvalid(t *testing.T) {
+
+	_, err := New(`return 1;`).MarshalBytecode()
+	if err == nil || !strings.Contains(err.Error(), "not been prepared") {
+		t.Fatalf("expected an error saving an unprepared script, got %v", err)
+	}
+
+	data := mustSave(t, `function f( x ) { return x + "steve"; } return f( 1 ) == "1steve";`)
+
+	// Every truncation of the data is rejected.
+	for i := 0; i < len(data); i++ {
+		_, err = NewFromBytecode(data[:i])
+		if err == nil {
+			t.Fatalf("expected an error loading %d bytes", i)
+		}
+	}
+
+	tests := []struct {
+		data  []byte
+		error string
+	}{
+		{append(append([]byte{}, data...), 0), "unexpected bytes"},
+		{[]byte("steve"), "doesn't contain a program"},
+		{[]byte("EVFB\x03"), "version 3 isn't supported"},
+		{[]byte("EVFB\x00"), "version 0 isn't supported"},
+		{[]byte{'E', 'V', 'F', 'B', 2, 0, 1, byte(code.OpTrue), 0, 0, 0, 9}, "unknown language version 9"},
+		{[]byte("EVFB\x01\x01X"), "unknown constant type 0x58"},
+		{[]byte{'E', 'V', 'F', 'B', 1, 1, tagHash, 1, tagNull, tagNull}, "hash key of type NULL"},
+
+		// Invalid bytecode.
+		{[]byte{'E', 'V', 'F', 'B', 1, 0, 1, 244, 0, 0, 0}, "main: invalid opcode 0xF4"},
+		{[]byte{'E', 'V', 'F', 'B', 1, 0, 3, byte(code.OpJump), 0, 9, 0, 0, 0}, "main: OpJump at offset 0000 has the invalid destination 0009"},
+		{[]byte{'E', 'V', 'F', 'B', 1, 0, 3, byte(code.OpLoopEnter), 0, 0, 0, 0, 0}, "refers to loop 0, which doesn't exist"},
+		{[]byte{'E', 'V', 'F', 'B', 1, 0, 3, byte(code.OpLoadSlot), 0, 0, 0, 0, 0}, "refers to slot 0, which doesn't exist"},
+		{[]byte{'E', 'V', 'F', 'B', 1, 1, tagNull, 3, byte(code.OpClosure), 0, 0, 0, 0, 0}, "refers to a NULL, not a function"},
+		{[]byte{'E', 'V', 'F', 'B', 1, 0, 0, 1, 1, 'f', 0, 1, 244, 0, 0}, "f: invalid opcode"},
+	}
+	for _, test := range tests {
+		_, err = NewFromBytecode(test.data)
+		if err == nil || !strings.Contains(err.Error(), test.error) {
+			t.Fatalf("expected error '%s', got %v", test.error, err)
+		}
+	}
+}
+
+// mustSave compiles the given script, and returns its bytecode.
+func mustSave(t *testing.T, script string) []byte {
+	eval := New(script)
+	err := eval.Prepare()
+	if err != nil {
+		t.Fatalf("failed to compile %s: %s", script, err)
+	}
+	data, err := eval.MarshalBytecode()
+	if err != nil {
+		t.Fatalf("failed to save %s: %s", script, err)
+	}
+	return data
+}
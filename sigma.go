@@ -0,0 +1,56 @@
+// This file contains NewRuleSetFromSigma, which allows Sigma detection
+// rules to be run against events.
+
+package evalfilter
+
+import (
+	"fmt"
+
+	"github.com/skx/evalfilter/v2/sigma"
+	"github.com/skx/evalfilter/v2/vm"
+)
+
+// NewRuleSetFromSigma compiles the Sigma rules within the given YAML
+// stream, one per document, and returns a RuleSet holding them.
+//
+// Each rule is named by its `id`, or by its `title` if it has none, so
+// the names of the rules which match an event are reported by Run, and
+// the level of each rule is the severity of its verdict.  The subset of
+// Sigma which is supported is described by the sigma package, which you
+// may use directly if you need the other fields of the rules, or wish to
+// map the names of their fields.
+func NewRuleSetFromSigma(data []byte) (*RuleSet, error) {
+
+	rules, err := sigma.Compile(data)
+	if err != nil {
+		return nil, err
+	}
+
+	set := NewRuleSet()
+	for _, rule := range rules {
+
+		name := rule.ID
+		if name == "" {
+			name = rule.Title
+		}
+
+		eval := New(rule.Script)
+		for _, pkg := range rule.Packages {
+			if err := eval.EnablePackage(pkg); err != nil {
+				return nil, err
+			}
+		}
+		if err := eval.Prepare(); err != nil {
+			return nil, fmt.Errorf("rule %s: %s", name, err)
+		}
+		if err := set.AddRule(name, eval); err != nil {
+			return nil, err
+		}
+
+		// The level of the rule is the severity of its matches.
+		if level, err := vm.ParseSeverity(rule.Level); err == nil {
+			set.SetSeverity(name, level)
+		}
+	}
+	return set, nil
+}
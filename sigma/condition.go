@@ -0,0 +1,204 @@
+// This file contains the parser of the conditions of rules, such as
+// `selection and not 1 of filter_*`, which combine their selections.
+
+package sigma
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// condParser holds our state as we translate a condition.
+type condParser struct {
+
+	// tokens holds the words, and parentheses, of the condition.
+	tokens []string
+
+	// pos holds the index of the next token.
+	pos int
+
+	// selections holds the expression of each selection, by name.
+	selections map[string]string
+}
+
+// condition translates the given condition, given the expressions of the
+// selections it may refer to.
+func condition(cond string, selections map[string]string) (string, error) {
+
+	// Aggregations, such as `selection | count() > 5`, need
+	// more than a single event.
+	if strings.Contains(cond, "|") {
+		return "", fmt.Errorf("aggregations can't be translated")
+	}
+
+	p := &condParser{tokens: words(cond), selections: selections}
+	expr, err := p.parseOr()
+	if err != nil {
+		return "", err
+	}
+	if p.pos < len(p.tokens) {
+		return "", fmt.Errorf("unexpected '%s'", p.tokens[p.pos])
+	}
+	return expr, nil
+}
+
+// words splits a condition into its tokens.
+func words(cond string) []string {
+
+	var out []string
+	word := ""
+	for _, c := range cond {
+		switch {
+		case c == '(' || c == ')':
+			if word != "" {
+				out = append(out, word)
+				word = ""
+			}
+			out = append(out, string(c))
+		case unicode.IsSpace(c):
+			if word != "" {
+				out = append(out, word)
+				word = ""
+			}
+		default:
+			word += string(c)
+		}
+	}
+	if word != "" {
+		out = append(out, word)
+	}
+	return out
+}
+
+// peek returns the next token, lowercased, or an empty string at the end
+// of the condition.
+func (p *condParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return strings.ToLower(p.tokens[p.pos])
+	}
+	return ""
+}
+
+// parseOr parses conditions joined by `or`, which binds least tightly.
+func (p *condParser) parseOr() (string, error) {
+	exprs, err := p.parseList("or", p.parseAnd)
+	if err != nil {
+		return "", err
+	}
+	return join(exprs, "||"), nil
+}
+
+// parseAnd parses conditions joined by `and`.
+func (p *condParser) parseAnd() (string, error) {
+	exprs, err := p.parseList("and", p.parseNot)
+	if err != nil {
+		return "", err
+	}
+	return join(exprs, "&&"), nil
+}
+
+// parseList parses the conditions which are separated by the given
+// keyword.
+func (p *condParser) parseList(keyword string, parse func() (string, error)) ([]string, error) {
+
+	var exprs []string
+	for {
+		expr, err := parse()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+
+		if p.peek() != keyword {
+			return exprs, nil
+		}
+		p.pos++
+	}
+}
+
+// parseNot parses a condition which might be negated.
+func (p *condParser) parseNot() (string, error) {
+	if p.peek() == "not" {
+		p.pos++
+		expr, err := p.parseNot()
+		if err != nil {
+			return "", err
+		}
+		return "!" + expr, nil
+	}
+	return p.parsePrimary()
+}
+
+// parsePrimary parses a selection, a quantifier such as `1 of filter_*`,
+// or a condition within parentheses.
+func (p *condParser) parsePrimary() (string, error) {
+
+	tok := p.peek()
+	switch tok {
+	case "":
+		return "", fmt.Errorf("unexpected end of condition")
+	case "(":
+		p.pos++
+		expr, err := p.parseOr()
+		if err != nil {
+			return "", err
+		}
+		if p.peek() != ")" {
+			return "", fmt.Errorf("expected ')'")
+		}
+		p.pos++
+		return expr, nil
+	case ")", "and", "or", "of":
+		return "", fmt.Errorf("unexpected '%s'", p.tokens[p.pos])
+	}
+
+	// A quantifier?
+	if p.pos+1 < len(p.tokens) && strings.ToLower(p.tokens[p.pos+1]) == "of" {
+		if tok != "1" && tok != "any" && tok != "all" {
+			return "", fmt.Errorf("the quantifier '%s' can't be translated", p.tokens[p.pos])
+		}
+		if p.pos+2 >= len(p.tokens) {
+			return "", fmt.Errorf("expected the selections after '%s of'", tok)
+		}
+		pattern := p.tokens[p.pos+2]
+		p.pos += 3
+
+		var names []string
+		for name := range p.selections {
+			if strings.ToLower(pattern) == "them" {
+				if !strings.HasPrefix(name, "_") {
+					names = append(names, name)
+				}
+				continue
+			}
+			if ok, _ := path.Match(pattern, name); ok {
+				names = append(names, name)
+			}
+		}
+		if len(names) == 0 {
+			return "", fmt.Errorf("no selections match '%s'", pattern)
+		}
+		sort.Strings(names)
+
+		var exprs []string
+		for _, name := range names {
+			exprs = append(exprs, p.selections[name])
+		}
+		if tok == "all" {
+			return join(exprs, "&&"), nil
+		}
+		return join(exprs, "||"), nil
+	}
+
+	// Otherwise a selection.
+	name := p.tokens[p.pos]
+	expr, ok := p.selections[name]
+	if !ok {
+		return "", fmt.Errorf("the selection '%s' does not exist", name)
+	}
+	p.pos++
+	return expr, nil
+}
@@ -0,0 +1,351 @@
+// This file contains the compilation of the selections of a rule, which
+// match the fields of an event against values.
+
+package sigma
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/skx/evalfilter/v2/token"
+)
+
+// compiler holds our state as we compile the selections of a rule.
+type compiler struct {
+
+	// fields maps the names of fields to those of the events.
+	fields map[string]string
+
+	// packages records the optional packages the script uses.
+	packages map[string]bool
+}
+
+// selection compiles a single selection, which is a map of fields to
+// values, or a list of such maps.
+func (c *compiler) selection(val interface{}) (string, error) {
+
+	switch v := val.(type) {
+	case map[string]interface{}:
+		return c.fieldMap(v)
+	case []interface{}:
+		var exprs []string
+		for _, member := range v {
+			m, ok := member.(map[string]interface{})
+			if !ok {
+				return "", fmt.Errorf("keyword searches can't be translated")
+			}
+			expr, err := c.fieldMap(m)
+			if err != nil {
+				return "", err
+			}
+			exprs = append(exprs, expr)
+		}
+		if len(exprs) == 0 {
+			return "", fmt.Errorf("the selection is empty")
+		}
+		return join(exprs, "||"), nil
+	}
+	return "", fmt.Errorf("keyword searches can't be translated")
+}
+
+// fieldMap compiles a map of fields to values, all of which must match.
+func (c *compiler) fieldMap(m map[string]interface{}) (string, error) {
+
+	if len(m) == 0 {
+		return "", fmt.Errorf("the selection is empty")
+	}
+
+	// Maps are unordered, so we sort them to make our output stable.
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var exprs []string
+	for _, key := range keys {
+		expr, err := c.field(key, m[key])
+		if err != nil {
+			return "", err
+		}
+		exprs = append(exprs, expr)
+	}
+	return join(exprs, "&&"), nil
+}
+
+// modifiers holds the modifiers we support, and whether they change the
+// way in which values are matched.
+var modifiers = map[string]bool{
+	"contains":   true,
+	"startswith": true,
+	"endswith":   true,
+	"re":         true,
+	"cidr":       true,
+	"exists":     true,
+	"fieldref":   true,
+	"lt":         true,
+	"lte":        true,
+	"gt":         true,
+	"gte":        true,
+	"all":        false,
+	"cased":      false,
+	"i":          false,
+	"m":          false,
+	"s":          false,
+}
+
+// field compiles the match of a single field, such as `Image|endswith`,
+// against a value or list of values.
+func (c *compiler) field(key string, val interface{}) (string, error) {
+
+	parts := strings.Split(key, "|")
+	name, err := c.name(parts[0])
+	if err != nil {
+		return "", err
+	}
+
+	// Find the modifiers.
+	mods := make(map[string]bool)
+	kind := ""
+	for _, mod := range parts[1:] {
+		changes, ok := modifiers[mod]
+		if !ok {
+			return "", fmt.Errorf("the modifier '%s' can't be translated", mod)
+		}
+		if changes {
+			if kind != "" {
+				return "", fmt.Errorf("the modifiers '%s' and '%s' can't be combined", kind, mod)
+			}
+			kind = mod
+		}
+		mods[mod] = true
+	}
+
+	// A list of values, any of which may match, unless we're told
+	// that they all must.
+	values, ok := val.([]interface{})
+	if !ok {
+		values = []interface{}{val}
+	}
+	if len(values) == 0 {
+		return "", fmt.Errorf("the field '%s' has no values", parts[0])
+	}
+
+	var exprs []string
+	for _, v := range values {
+		expr, err := c.value(name, kind, mods, v)
+		if err != nil {
+			return "", fmt.Errorf("the field '%s': %s", parts[0], err)
+		}
+		exprs = append(exprs, expr)
+	}
+	if mods["all"] {
+		return join(exprs, "&&"), nil
+	}
+	return join(exprs, "||"), nil
+}
+
+// identifier matches the names which are valid identifiers in our
+// scripts.
+var identifier = regexp.MustCompile(`^[\pL_$][\pL\pN_$]*$`)
+
+// name returns the name by which a script refers to the given field.
+//
+// Names may refer to the members of nested objects, as in `process.name`.
+func (c *compiler) name(field string) (string, error) {
+
+	if field == "" {
+		return "", fmt.Errorf("keyword searches can't be translated")
+	}
+	if mapped, ok := c.fields[field]; ok {
+		field = mapped
+	}
+	for _, part := range strings.Split(field, ".") {
+		if !identifier.MatchString(part) || token.LookupIdentifier(part) != token.IDENT {
+			return "", fmt.Errorf("the field '%s' can't be used in a script, though it may be mapped to another name", field)
+		}
+	}
+	return field, nil
+}
+
+// value compiles the match of a field against a single value.
+func (c *compiler) value(name string, kind string, mods map[string]bool, val interface{}) (string, error) {
+
+	switch kind {
+	case "exists":
+		b, ok := val.(bool)
+		if !ok {
+			return "", fmt.Errorf("exists requires true or false")
+		}
+		if b {
+			return "( type( " + name + " ) != \"null\" )", nil
+		}
+		return "( type( " + name + " ) == \"null\" )", nil
+
+	case "fieldref":
+		other, err := c.name(text(val))
+		if err != nil {
+			return "", err
+		}
+		return "( string( " + name + " ) == string( " + other + " ) )", nil
+
+	case "lt", "lte", "gt", "gte":
+		var num float64
+		switch v := val.(type) {
+		case int64:
+			num = float64(v)
+		case float64:
+			num = v
+		default:
+			return "", fmt.Errorf("%s requires a number", kind)
+		}
+		if math.IsInf(num, 0) || math.IsNaN(num) {
+			return "", fmt.Errorf("%s requires a finite number", kind)
+		}
+		op := map[string]string{"lt": "<", "lte": "<=", "gt": ">", "gte": ">="}[kind]
+		lit := strconv.FormatFloat(num, 'f', -1, 64)
+		if !strings.Contains(lit, ".") {
+			lit += ".0"
+		}
+
+		// Our `&&` evaluates both of its operands, so we use the
+		// ternary operator to avoid comparing anything other than
+		// a number, which would fail.
+		return "( type( float( " + name + " ) ) == \"float\" ? float( " + name + " ) " + op + " " + lit + " : false )", nil
+
+	case "cidr":
+		network, ok := val.(string)
+		if !ok {
+			return "", fmt.Errorf("cidr requires a network")
+		}
+		c.packages["net"] = true
+		return "in_cidr( string( " + name + " ), " + quote(network) + " )", nil
+
+	case "re":
+		re, ok := val.(string)
+		if !ok {
+			return "", fmt.Errorf("re requires a regular expression")
+		}
+		flags := ""
+		for _, f := range []string{"i", "m", "s"} {
+			if mods[f] {
+				flags += f
+			}
+		}
+		if flags != "" {
+			re = "(?" + flags + ")" + re
+		}
+		return match(name, re)
+	}
+
+	// Null matches fields which are missing.
+	if val == nil {
+		return "( type( " + name + " ) == \"null\" )", nil
+	}
+
+	var str string
+	switch v := val.(type) {
+	case string:
+		str = v
+	case int64:
+		str = strconv.FormatInt(v, 10)
+	case float64:
+		str = strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		str = strconv.FormatBool(v)
+	default:
+		return "", fmt.Errorf("the value can't be translated")
+	}
+
+	// Values without wildcards, which needn't be compared without
+	// regard to case, are compared directly.
+	pattern, wild := wildcards(str)
+	cased := mods["cased"] || strings.ToLower(str) == strings.ToUpper(str)
+	if kind == "" && !wild && cased {
+		if str == "null" {
+			return "( type( " + name + " ) != \"null\" && string( " + name + " ) == \"null\" )", nil
+		}
+		return "( string( " + name + " ) == " + quote(str) + " )", nil
+	}
+
+	switch kind {
+	case "contains":
+		pattern = "^.*" + pattern + ".*$"
+	case "startswith":
+		pattern = "^" + pattern + ".*$"
+	case "endswith":
+		pattern = "^.*" + pattern + "$"
+	default:
+		pattern = "^" + pattern + "$"
+	}
+	flags := "(?s)"
+	if !mods["cased"] {
+		flags = "(?is)"
+	}
+	return match(name, flags+pattern)
+}
+
+// wildcards converts a Sigma value into a regular expression, in which
+// `*` matches any characters, `?` matches a single character, and a
+// backslash escapes either of them, or itself.  It also returns whether
+// there were any wildcards.
+func wildcards(val string) (string, bool) {
+
+	out := ""
+	wild := false
+
+	chars := []rune(val)
+	for i := 0; i < len(chars); i++ {
+		switch chars[i] {
+		case '*':
+			out += ".*"
+			wild = true
+		case '?':
+			out += "."
+			wild = true
+		case '\\':
+			// Other backslashes, such as those within
+			// Windows paths, are literal.
+			if i+1 < len(chars) && strings.ContainsRune(`*?\`, chars[i+1]) {
+				i++
+			}
+			out += regexp.QuoteMeta(string(chars[i]))
+		default:
+			out += regexp.QuoteMeta(string(chars[i]))
+		}
+	}
+	return out, wild
+}
+
+// match returns the test that the given field matches the regular
+// expression.
+//
+// Fields are converted to strings, so that numbers may be matched, which
+// means that a field which is missing becomes "null" - so if that would
+// match we test that the field is present too.
+func match(name string, re string) (string, error) {
+
+	compiled, err := regexp.Compile(re)
+	if err != nil {
+		return "", fmt.Errorf("the regular expression is invalid: %s", err)
+	}
+
+	// Our lexer treats a backslash as escaping the character which
+	// follows it, so both it and the slash need escaping.
+	lit := "/" + strings.NewReplacer(`\`, `\\`, `/`, `\/`).Replace(re) + "/"
+
+	expr := "( string( " + name + " ) ~= " + lit + " )"
+	if compiled.MatchString("null") {
+		expr = "( type( " + name + " ) != \"null\" && string( " + name + " ) ~= " + lit + " )"
+	}
+	return expr, nil
+}
+
+// quote returns the given string as a literal within a script.
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`).Replace(s) + `"`
+}
@@ -0,0 +1,230 @@
+// Package sigma compiles Sigma detection rules into evalfilter scripts,
+// so that the rules which are shared by security teams may be run against
+// log events without rewriting them.
+//
+// Sigma rules are YAML documents, whose `detection` section describes the
+// events a rule matches as a number of named selections, and a condition
+// which combines them:
+//
+//	title: Encoded PowerShell
+//	detection:
+//	  selection:
+//	    Image|endswith: '\powershell.exe'
+//	    CommandLine|contains:
+//	      - ' -enc '
+//	      - ' -encodedcommand '
+//	  filter:
+//	    User: SYSTEM
+//	  condition: selection and not filter
+//
+// We support a useful subset of the specification:
+//
+// * Selections which are maps of fields to values, or lists of such maps.
+// The fields of a map must all match, and any of the maps of a list.
+//
+// * Lists of values, any of which may match, or all of them with `|all`.
+//
+// * The `contains`, `startswith`, `endswith`, `re`, `cased`, `cidr`,
+// `exists`, `fieldref`, `lt`, `lte`, `gt`, and `gte`, modifiers.
+//
+// * Conditions using `and`, `or`, `not`, parentheses, and `1 of` or
+// `all of` a pattern of selections, or `them`.
+//
+// As in Sigma strings are matched case-insensitively, unless the `cased`
+// modifier is used, and may contain the `*` and `?` wildcards.  Values are
+// compared with the fields of the event as strings, so `EventID: 4688`
+// matches whether the event holds a number or a string, and fields which
+// are missing never match.
+//
+// Keyword searches, aggregations, and the encoding modifiers such as
+// `base64`, are reported as errors rather than being translated.
+package sigma
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/skx/evalfilter/v2/yaml"
+)
+
+// Rule is a Sigma rule which has been compiled.
+type Rule struct {
+
+	// Title holds the title of the rule.
+	Title string
+
+	// ID holds the identifier of the rule, which might be empty.
+	ID string
+
+	// Status holds the status of the rule, such as "stable".
+	Status string
+
+	// Level holds the severity of the rule, such as "high".
+	Level string
+
+	// Description holds the description of the rule.
+	Description string
+
+	// Tags holds the tags of the rule, such as "attack.execution".
+	Tags []string
+
+	// Script holds the script which matches the same events as the
+	// rule.
+	Script string
+
+	// Packages holds the names of the optional packages the script
+	// uses, which must be enabled before it is prepared.
+	Packages []string
+}
+
+// Error is the error returned if a rule can't be compiled.
+type Error struct {
+
+	// Rule holds the title of the rule, if it is known.
+	Rule string
+
+	// Message holds the description of the error.
+	Message string
+}
+
+// Error returns the description of the error.
+func (e *Error) Error() string {
+	if e.Rule == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("rule '%s': %s", e.Rule, e.Message)
+}
+
+// Options controls the compilation of rules.
+type Options struct {
+
+	// Fields maps the names of the fields used by the rules to the
+	// names of the fields of the events they're run against, such as
+	// "CommandLine" to "process.command_line".
+	//
+	// Fields which aren't present are used as they are.
+	Fields map[string]string
+}
+
+// Compile compiles each of the rules in the given YAML stream, in which
+// each document is a rule.
+func Compile(data []byte) ([]*Rule, error) {
+	return CompileWithOptions(data, Options{})
+}
+
+// CompileWithOptions compiles each of the rules in the given YAML stream,
+// as Compile does, with the given options.
+func CompileWithOptions(data []byte, opts Options) ([]*Rule, error) {
+
+	docs, err := yaml.Parse(data)
+	if err != nil {
+		return nil, &Error{Message: err.Error()}
+	}
+
+	var rules []*Rule
+	for i, doc := range docs {
+
+		fields, ok := doc.(map[string]interface{})
+		if !ok {
+			return nil, &Error{Message: fmt.Sprintf("document %d is not a mapping", i+1)}
+		}
+
+		rule, err := compile(fields, opts)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// compile compiles a single rule.
+func compile(doc map[string]interface{}, opts Options) (*Rule, error) {
+
+	rule := &Rule{
+		Title:       text(doc["title"]),
+		ID:          text(doc["id"]),
+		Status:      text(doc["status"]),
+		Level:       text(doc["level"]),
+		Description: text(doc["description"]),
+	}
+	if tags, ok := doc["tags"].([]interface{}); ok {
+		for _, tag := range tags {
+			rule.Tags = append(rule.Tags, text(tag))
+		}
+	}
+
+	fail := func(format string, args ...interface{}) error {
+		return &Error{Rule: rule.Title, Message: fmt.Sprintf(format, args...)}
+	}
+
+	detection, ok := doc["detection"].(map[string]interface{})
+	if !ok {
+		return nil, fail("there is no detection section")
+	}
+
+	// Compile each of the selections, which are all the members of
+	// the detection other than the condition.
+	c := &compiler{fields: opts.Fields, packages: make(map[string]bool)}
+	selections := make(map[string]string)
+	for name, val := range detection {
+		if name == "condition" || name == "timeframe" {
+			continue
+		}
+		expr, err := c.selection(val)
+		if err != nil {
+			return nil, fail("selection '%s': %s", name, err)
+		}
+		selections[name] = expr
+	}
+
+	// A rule may have several conditions, any of which may match.
+	var conditions []string
+	switch cond := detection["condition"].(type) {
+	case string:
+		conditions = []string{cond}
+	case []interface{}:
+		for _, c := range cond {
+			conditions = append(conditions, text(c))
+		}
+	}
+	if len(conditions) == 0 {
+		return nil, fail("there is no condition")
+	}
+
+	var exprs []string
+	for _, cond := range conditions {
+		expr, err := condition(cond, selections)
+		if err != nil {
+			return nil, fail("condition '%s': %s", cond, err)
+		}
+		exprs = append(exprs, expr)
+	}
+
+	rule.Script = "return " + join(exprs, "||") + ";"
+	for name := range c.packages {
+		rule.Packages = append(rule.Packages, name)
+	}
+	sort.Strings(rule.Packages)
+	return rule, nil
+}
+
+// text returns the given value, from a document, as a string.
+func text(val interface{}) string {
+	if val == nil {
+		return ""
+	}
+	if s, ok := val.(string); ok {
+		return s
+	}
+	return fmt.Sprintf("%v", val)
+}
+
+// join joins the given expressions with the given operator.
+func join(exprs []string, op string) string {
+	if len(exprs) == 1 {
+		return exprs[0]
+	}
+	return "( " + strings.Join(exprs, " "+op+" ") + " )"
+}
@@ -0,0 +1,175 @@
+package sigma
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestCompile tests the scripts which rules compile to.
+func TestCompile(t *testing.T) {
+
+	tests := []struct {
+		Detection string
+		Script    string
+	}{
+		{`
+  selection:
+    EventID: 4688
+  condition: selection`, `return ( string( EventID ) == "4688" );`},
+		{`
+  selection:
+    Image|endswith: '\cmd.exe'
+  condition: selection`, `return ( string( Image ) ~= /(?is)^.*\\\\cmd\\.exe$/ );`},
+		{`
+  selection:
+    CommandLine|contains|all:
+      - '-nop'
+      - '-w hidden'
+  condition: selection`, `return ( ( string( CommandLine ) ~= /(?is)^.*-nop.*$/ ) && ( string( CommandLine ) ~= /(?is)^.*-w hidden.*$/ ) );`},
+		{`
+  selection:
+    User|cased: SYSTEM
+    Path: 'C:\Temp\\*.exe'
+    Host|startswith: 'web?-'
+  condition: selection`, `return ( ( string( Host ) ~= /(?is)^web.-.*$/ ) && ( string( Path ) ~= /(?is)^C:\\\\Temp\\\\.*\\.exe$/ ) && ( string( User ) == "SYSTEM" ) );`},
+		{`
+  selection:
+    Name: 'a\*b'
+  condition: selection`, `return ( string( Name ) ~= /(?is)^a\\*b$/ );`},
+		{`
+  selection:
+    Name|re|i: '^evil/[0-9]+$'
+  condition: selection`, `return ( string( Name ) ~= /(?i)^evil\/[0-9]+$/ );`},
+		{`
+  selection:
+    - Image: a
+    - Image: b
+  condition: selection`, `return ( ( string( Image ) ~= /(?is)^a$/ ) || ( string( Image ) ~= /(?is)^b$/ ) );`},
+		{`
+  selection:
+    Port|lt: 1024
+    Command|exists: false
+    Parent: null
+  condition: selection`, `return ( ( type( Command ) == "null" ) && ( type( Parent ) == "null" ) && ( type( float( Port ) ) == "float" ? float( Port ) < 1024.0 : false ) );`},
+		{`
+  selection:
+    Value: '*'
+  condition: selection`, `return ( type( Value ) != "null" && string( Value ) ~= /(?is)^.*$/ );`},
+		{`
+  selection:
+    user.name|fieldref: target.name
+  condition: selection`, `return ( string( user.name ) == string( target.name ) );`},
+		{`
+  sel_a:
+    A: 1
+  sel_b:
+    B: 2
+  filter:
+    C: 3
+  condition: 1 of sel_* and not filter`, `return ( ( ( string( A ) == "1" ) || ( string( B ) == "2" ) ) && !( string( C ) == "3" ) );`},
+		{`
+  sel_a:
+    A: 1
+  _hidden:
+    B: 2
+  condition:
+    - all of them
+    - (_hidden)`, `return ( ( string( A ) == "1" ) || ( string( B ) == "2" ) );`},
+	}
+
+	for _, test := range tests {
+		rules, err := Compile([]byte("title: test\ndetection:" + test.Detection + "\n"))
+		if err != nil {
+			t.Errorf("unexpected error compiling %s: %s", test.Detection, err)
+			continue
+		}
+		if rules[0].Script != test.Script {
+			t.Errorf("compiling %s gave:\n%s\nexpected:\n%s", test.Detection, rules[0].Script, test.Script)
+		}
+	}
+}
+
+// TestMetadata tests that the fields of rules are returned, along with
+// the packages their scripts need.
+func TestMetadata(t *testing.T) {
+
+	rules, err := CompileWithOptions([]byte(`
+title: Lateral movement
+id: 5b1a2c3d
+status: experimental
+level: high
+description: Connections to the internal network
+tags:
+  - attack.lateral_movement
+detection:
+  selection:
+    DestinationIp|cidr: 10.0.0.0/8
+  condition: selection
+---
+title: Second
+detection:
+  selection:
+    CommandLine: x
+  condition: selection
+`), Options{Fields: map[string]string{"CommandLine": "process.command_line"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(rules) != 2 {
+		t.Fatalf("expected two rules, got %d", len(rules))
+	}
+
+	r := rules[0]
+	if r.Title != "Lateral movement" || r.ID != "5b1a2c3d" || r.Status != "experimental" || r.Level != "high" || r.Description == "" {
+		t.Fatalf("the fields of the rule were wrong: %v", r)
+	}
+	if len(r.Tags) != 1 || r.Tags[0] != "attack.lateral_movement" {
+		t.Fatalf("the tags of the rule were wrong: %v", r.Tags)
+	}
+	if len(r.Packages) != 1 || r.Packages[0] != "net" || r.Script != `return in_cidr( string( DestinationIp ), "10.0.0.0/8" );` {
+		t.Fatalf("the script was wrong: %v %s", r.Packages, r.Script)
+	}
+
+	// Fields are mapped.
+	if rules[1].Script != `return ( string( process.command_line ) ~= /(?is)^x$/ );` {
+		t.Fatalf("the field wasn't mapped: %s", rules[1].Script)
+	}
+}
+
+// TestErrors tests that rules we can't compile are reported.
+func TestErrors(t *testing.T) {
+
+	tests := []struct {
+		Rule  string
+		Error string
+	}{
+		{"title: x\n", "rule 'x': there is no detection section"},
+		{"title: x\ndetection:\n  selection:\n    A: 1\n", "rule 'x': there is no condition"},
+		{"title: x\ndetection:\n  keywords:\n    - evil\n  condition: keywords\n", "keyword searches can't be translated"},
+		{"title: x\ndetection:\n  selection:\n    A|base64: x\n  condition: selection\n", "the modifier 'base64' can't be translated"},
+		{"title: x\ndetection:\n  selection:\n    A|contains|endswith: x\n  condition: selection\n", "can't be combined"},
+		{"title: x\ndetection:\n  selection:\n    A: 1\n  condition: selection | count() > 5\n", "aggregations can't be translated"},
+		{"title: x\ndetection:\n  selection:\n    A: 1\n  condition: selection and other\n", "the selection 'other' does not exist"},
+		{"title: x\ndetection:\n  selection:\n    A: 1\n  condition: 2 of them\n", "the quantifier '2' can't be translated"},
+		{"title: x\ndetection:\n  selection:\n    A: 1\n  condition: 1 of filter*\n", "no selections match 'filter*'"},
+		{"title: x\ndetection:\n  selection:\n    A: 1\n  condition: (selection\n", "expected ')'"},
+		{"title: x\ndetection:\n  selection:\n    Some-Field: 1\n  condition: selection\n", "the field 'Some-Field' can't be used"},
+		{"title: x\ndetection:\n  selection:\n    A|re: '(x'\n  condition: selection\n", "the regular expression is invalid"},
+		{"title: x\ndetection:\n  selection:\n    A|gt: x\n  condition: selection\n", "gt requires a number"},
+		{"- a\n- b\n", "document 1 is not a mapping"},
+	}
+
+	for _, test := range tests {
+		_, err := Compile([]byte(test.Rule))
+		if err == nil {
+			t.Errorf("expected an error compiling %s", test.Rule)
+			continue
+		}
+		if _, ok := err.(*Error); !ok {
+			t.Errorf("compiling %s gave the wrong kind of error: %T", test.Rule, err)
+		}
+		if !strings.Contains(err.Error(), test.Error) {
+			t.Errorf("compiling %s gave '%s', expected '%s'", test.Rule, err, test.Error)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package evalfilter
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/skx/evalfilter/v2/vm"
+)
+
+// TestNewRuleSetFromSigma tests running Sigma rules against events.
+func TestNewRuleSetFromSigma(t *testing.T) {
+
+	rules := `
+title: Encoded PowerShell
+id: powershell-enc
+level: high
+detection:
+  selection:
+    Image|endswith: '\powershell.exe'
+    CommandLine|contains:
+      - ' -enc '
+      - ' -encodedcommand '
+  filter:
+    User: SYSTEM
+  condition: selection and not filter
+---
+title: Internal high ports
+detection:
+  selection:
+    DestinationIp|cidr: 10.0.0.0/8
+    DestinationPort|gte: 1024
+    EventID: 3
+  condition: selection
+`
+
+	set, err := NewRuleSetFromSigma([]byte(rules))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	tests := []struct {
+		event   map[string]interface{}
+		matched string
+	}{
+		{map[string]interface{}{"Image": `C:\Windows\System32\WindowsPowerShell\v1.0\POWERSHELL.EXE`, "CommandLine": "powershell -ENC aQBlAHgA", "User": "bob"}, "powershell-enc"},
+		{map[string]interface{}{"Image": `C:\Windows\powershell.exe`, "CommandLine": "powershell -enc aQBlAHgA", "User": "system"}, ""},
+		{map[string]interface{}{"Image": `C:\Windows\cmd.exe`, "CommandLine": "cmd /c -enc x"}, ""},
+		{map[string]interface{}{"DestinationIp": "10.1.2.3", "DestinationPort": 8080, "EventID": 3}, "Internal high ports"},
+		{map[string]interface{}{"DestinationIp": "10.1.2.3", "DestinationPort": "8080", "EventID": "3"}, "Internal high ports"},
+		{map[string]interface{}{"DestinationIp": "192.168.1.1", "DestinationPort": 8080, "EventID": 3}, ""},
+		{map[string]interface{}{"DestinationIp": "10.1.2.3", "EventID": 3}, ""},
+		{map[string]interface{}{}, ""},
+	}
+
+	for _, test := range tests {
+		res, err := set.Run(test.event)
+		if err != nil {
+			t.Fatalf("unexpected error running against %v: %s", test.event, err)
+		}
+		if strings.Join(res.Matched, ",") != test.matched {
+			t.Errorf("%v matched %v, expected %s", test.event, res.Matched, test.matched)
+		}
+
+		// The level of a rule is the severity of its matches.
+		if test.matched == "powershell-enc" && res.Severity != vm.SeverityHigh {
+			t.Errorf("%v has severity %s, expected high", test.event, res.Severity)
+		}
+	}
+
+	// Errors are reported.
+	_, err = NewRuleSetFromSigma([]byte("title: x\ndetection:\n  keywords:\n    - evil\n  condition: keywords\n"))
+	if err == nil || !strings.Contains(err.Error(), "keyword searches") {
+		t.Fatalf("expected an error, got %v", err)
+	}
+}
@@ -0,0 +1,320 @@
+// This file contains the simplification of boolean expressions, which
+// takes place when the optimizer is enabled.
+//
+// Rules which are generated by other tools often contain redundant logic,
+// such as `!!( Status == "active" )`, `Admin || false`, or the same test
+// repeated several times within a long chain of `&&` clauses.  Before
+// such expressions are compiled we:
+//
+//  * Remove double negation.
+//  * Remove `true` from chains of `&&`, and `false` from chains of `||`.
+//  * Collapse chains containing `false`, or `true`, respectively.
+//  * Remove clauses which duplicate an earlier clause of the same chain.
+//  * Push negation inwards, via De Morgan's laws, when that allows it to
+//    be removed entirely, i.e. `!( a == b || c != d )` is compiled as
+//    `a != b && c == d`.
+//
+// Our `&&` and `||` operators always evaluate both of their operands,
+// either of which might result in an error, and `!` treats values other
+// than booleans differently to the other operators.  So the rules are
+// only applied when we can be sure the result is unchanged, which mostly
+// means that the expressions involved are comparisons, or that they are
+// simple enough they can't fail.
+
+package evalfilter
+
+import (
+	"github.com/skx/evalfilter/v2/ast"
+	"github.com/skx/evalfilter/v2/token"
+)
+
+// simplify returns a simpler version of the given expression, if it is
+// a boolean expression which may be simplified.
+func (e *Eval) simplify(node ast.Expression) ast.Expression {
+
+	if e.simplified[node] {
+		return node
+	}
+
+	out := node
+	switch n := node.(type) {
+	case *ast.PrefixExpression:
+		if n.Operator != "!" {
+			break
+		}
+		right := e.simplify(n.Right)
+		if neg, ok := negate(right); ok {
+			out = neg
+		} else if right != n.Right {
+			out = &ast.PrefixExpression{Token: n.Token, Operator: n.Operator, Right: right}
+		}
+
+	case *ast.InfixExpression:
+		if n.Operator == "&&" || n.Operator == "||" {
+			out = e.simplifyChain(n)
+		}
+	}
+
+	e.mark(out)
+	return out
+}
+
+// mark records that the given expression, and the clauses of any chain
+// it begins, have been simplified.
+func (e *Eval) mark(node ast.Expression) {
+	e.simplified[node] = true
+	if n, ok := node.(*ast.InfixExpression); ok && (n.Operator == "&&" || n.Operator == "||") {
+		e.mark(n.Left)
+		e.mark(n.Right)
+	}
+}
+
+// simplifyChain simplifies a chain of `&&`, or `||`, clauses.
+func (e *Eval) simplifyChain(node *ast.InfixExpression) ast.Expression {
+
+	// `true` may be removed from the clauses of `&&`, whilst `false`
+	// makes the result `false` - and vice versa for `||`.
+	unit := node.Operator == "&&"
+
+	var clauses []ast.Expression
+	changed := false
+	for _, c := range flatten(node, node.Operator) {
+		s := e.simplify(c)
+		if s != c {
+			changed = true
+		}
+		clauses = append(clauses, s)
+	}
+
+	// The simplified chain, with the same structure as the original.
+	tree := node
+	if changed {
+		rest := clauses
+		tree = rebuild(node, node.Operator, &rest).(*ast.InfixExpression)
+	}
+
+	// If the chain can't fail then a clause of `false` decides the
+	// result of `&&`, and `true` the result of `||`.
+	if safeChain(tree, node.Operator) {
+		for _, c := range clauses {
+			if lit, ok := c.(*ast.BooleanLiteral); ok && lit.Value != unit {
+				return boolean(node.Token, !unit)
+			}
+		}
+	}
+
+	// Discard the clauses which make no difference, providing the
+	// remaining clauses are all booleans - in which case the order
+	// in which they're combined doesn't matter either.
+	var kept []ast.Expression
+	for _, c := range clauses {
+		if lit, ok := c.(*ast.BooleanLiteral); ok && lit.Value == unit {
+			continue
+		}
+		duplicate := false
+		for _, k := range kept {
+			if identical(c, k) {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			kept = append(kept, c)
+		}
+	}
+	if len(kept) != len(clauses) && allBoolean(kept) {
+		if len(kept) == 0 {
+			return boolean(node.Token, unit)
+		}
+		return chain(node, kept)
+	}
+
+	return tree
+}
+
+// flatten returns the clauses of the given chain of operators.
+func flatten(node ast.Expression, op string) []ast.Expression {
+	if n, ok := node.(*ast.InfixExpression); ok && n.Operator == op {
+		return append(flatten(n.Left, op), flatten(n.Right, op)...)
+	}
+	return []ast.Expression{node}
+}
+
+// rebuild replaces the clauses of the given chain of operators with the
+// given expressions, keeping the structure of the chain.
+func rebuild(node ast.Expression, op string, clauses *[]ast.Expression) ast.Expression {
+	n, ok := node.(*ast.InfixExpression)
+	if !ok || n.Operator != op {
+		out := (*clauses)[0]
+		*clauses = (*clauses)[1:]
+		return out
+	}
+	left := rebuild(n.Left, op, clauses)
+	right := rebuild(n.Right, op, clauses)
+	return &ast.InfixExpression{Token: n.Token, Left: left, Operator: n.Operator, Right: right}
+}
+
+// chain joins the given clauses with the operator of the given node.
+func chain(node *ast.InfixExpression, clauses []ast.Expression) ast.Expression {
+	out := clauses[0]
+	for _, c := range clauses[1:] {
+		out = &ast.InfixExpression{Token: node.Token, Left: out, Operator: node.Operator, Right: c}
+	}
+	return out
+}
+
+// boolean returns a literal boolean.
+func boolean(tok token.Token, val bool) ast.Expression {
+	tok.Type = token.FALSE
+	tok.Literal = "false"
+	if val {
+		tok.Type = token.TRUE
+		tok.Literal = "true"
+	}
+	return &ast.BooleanLiteral{Token: tok, Value: val}
+}
+
+// negations holds the operators which have an exact opposite.
+var negations = map[string]string{
+	"==": "!=",
+	"!=": "==",
+	"~=": "!~",
+	"!~": "~=",
+}
+
+// negate returns the negation of the given expression, if it may be
+// expressed without using `!`.
+func negate(node ast.Expression) (ast.Expression, bool) {
+
+	switch n := node.(type) {
+	case *ast.BooleanLiteral:
+		return boolean(n.Token, !n.Value), true
+
+	case *ast.PrefixExpression:
+		if n.Operator == "!" && isBoolean(n.Right) {
+			return n.Right, true
+		}
+
+	case *ast.InfixExpression:
+		if op, ok := negations[n.Operator]; ok {
+			return &ast.InfixExpression{Token: n.Token, Left: n.Left, Operator: op, Right: n.Right}, true
+		}
+
+		// De Morgan's laws only hold for booleans, as `!` doesn't
+		// treat other values as `&&` and `||` do.
+		if n.Operator == "&&" || n.Operator == "||" {
+			clauses := flatten(n, n.Operator)
+			if !allBoolean(clauses) {
+				return nil, false
+			}
+			for i, c := range clauses {
+				neg, ok := negate(c)
+				if !ok {
+					return nil, false
+				}
+				clauses[i] = neg
+			}
+
+			op := "&&"
+			if n.Operator == "&&" {
+				op = "||"
+			}
+			return chain(&ast.InfixExpression{Token: n.Token, Operator: op}, clauses), true
+		}
+	}
+	return nil, false
+}
+
+// isBoolean returns true if the given expression always results in a
+// boolean, if it doesn't fail.
+func isBoolean(node ast.Expression) bool {
+
+	switch n := node.(type) {
+	case *ast.BooleanLiteral:
+		return true
+	case *ast.PrefixExpression:
+		return n.Operator == "!"
+	case *ast.InfixExpression:
+		switch n.Operator {
+		case "==", "!=", "<", "<=", ">", ">=", "~=", "!~", "in", "&&", "||":
+			return true
+		}
+	}
+	return false
+}
+
+// allBoolean returns true if each of the given expressions is a boolean.
+func allBoolean(nodes []ast.Expression) bool {
+	for _, n := range nodes {
+		if !isBoolean(n) {
+			return false
+		}
+	}
+	return true
+}
+
+// safe returns true if the given expression can't fail, and has no
+// side-effects.
+func safe(node ast.Expression) bool {
+
+	switch n := node.(type) {
+	case *ast.BooleanLiteral, *ast.FloatLiteral, *ast.IntegerLiteral,
+		*ast.StringLiteral, *ast.RegexpLiteral, *ast.Identifier:
+		return true
+	case *ast.PrefixExpression:
+		return n.Operator == "!" && safe(n.Right)
+	}
+	return false
+}
+
+// safeChain returns true if evaluating the given chain of operators can't
+// fail, and has no side-effects.
+//
+// The operators themselves fail when neither operand is a boolean.
+func safeChain(node ast.Expression, op string) bool {
+	n, ok := node.(*ast.InfixExpression)
+	if !ok || n.Operator != op {
+		return safe(node)
+	}
+	return safeChain(n.Left, op) && safeChain(n.Right, op) &&
+		(isBoolean(n.Left) || isBoolean(n.Right))
+}
+
+// identical returns true if the two expressions are identical, and have no
+// side-effects, so that evaluating one is the same as evaluating the other.
+func identical(a, b ast.Expression) bool {
+
+	switch x := a.(type) {
+	case *ast.Identifier:
+		y, ok := b.(*ast.Identifier)
+		return ok && x.Value == y.Value
+	case *ast.BooleanLiteral:
+		y, ok := b.(*ast.BooleanLiteral)
+		return ok && x.Value == y.Value
+	case *ast.IntegerLiteral:
+		y, ok := b.(*ast.IntegerLiteral)
+		return ok && x.Value == y.Value
+	case *ast.FloatLiteral:
+		y, ok := b.(*ast.FloatLiteral)
+		return ok && x.Token.Literal == y.Token.Literal
+	case *ast.StringLiteral:
+		y, ok := b.(*ast.StringLiteral)
+		return ok && x.Value == y.Value
+	case *ast.RegexpLiteral:
+		y, ok := b.(*ast.RegexpLiteral)
+		return ok && x.Value == y.Value && x.Flags == y.Flags
+	case *ast.PrefixExpression:
+		y, ok := b.(*ast.PrefixExpression)
+		return ok && x.Operator == y.Operator && identical(x.Right, y.Right)
+	case *ast.InfixExpression:
+		y, ok := b.(*ast.InfixExpression)
+		if _, mutator := mutators[x.Operator]; mutator || x.Operator == "=" {
+			return false
+		}
+		return ok && x.Operator == y.Operator && identical(x.Left, y.Left) && identical(x.Right, y.Right)
+	case *ast.IndexExpression:
+		y, ok := b.(*ast.IndexExpression)
+		return ok && identical(x.Left, y.Left) && identical(x.Index, y.Index)
+	}
+	return false
+}
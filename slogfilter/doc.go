@@ -0,0 +1,30 @@
+// Package slogfilter provides a log/slog handler which filters, and
+// annotates, log records with a script.
+//
+// The handler wraps another, and runs the script against each record it
+// is given, which sees the record's message as `msg`, its level as
+// `level`, its time as `time`, and each of its attributes by name, with
+// groups as nested hashes.  The result of the script determines what
+// happens to the record:
+//
+//   - If the script returns false the record is dropped.
+//   - If the script returns a hash the record is kept, and the entries
+//     of the hash are added to it as attributes.
+//   - Otherwise the record is kept.
+//
+// For example this script keeps every warning and error, but only a
+// tenth of the other records, and records why it kept them:
+//
+//	if ( level == "WARN" || level == "ERROR" ) {
+//	    return { "kept": "severity" };
+//	}
+//	return sample(10);
+//
+// where `sample` is a function the host has added.
+//
+// The script may be replaced whilst the handler is in use, which allows
+// the filtering to be changed without restarting a service.
+//
+// As log/slog was added in Go 1.21 this package requires that version,
+// or higher.
+package slogfilter
@@ -0,0 +1,220 @@
+//go:build go1.21
+// +build go1.21
+
+package slogfilter
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"sync"
+
+	"github.com/skx/evalfilter/v2"
+	"github.com/skx/evalfilter/v2/logadapter"
+	"github.com/skx/evalfilter/v2/object"
+)
+
+// ErrorKey is the name of the attribute added to a record if the script
+// fails when run against it.
+//
+// Records are never dropped because of an error, so that a broken script
+// doesn't hide the logs which might help explain it.
+const ErrorKey = "filter_error"
+
+// filter holds the state shared by a handler and those derived from it.
+type filter struct {
+
+	// eval holds the script.
+	eval *evalfilter.Eval
+
+	// mutex serializes our runs, and protects the script.
+	mutex sync.Mutex
+}
+
+// Handler is a slog.Handler which filters records with a script, before
+// passing them to another handler.
+type Handler struct {
+
+	// next is the handler we pass records to.
+	next slog.Handler
+
+	// filter holds our script.
+	filter *filter
+
+	// attrs holds the attributes added via WithAttrs, qualified by
+	// the groups which were open at the time.
+	attrs []slog.Attr
+
+	// groups holds the names of the groups added via WithGroup.
+	groups []string
+}
+
+// New returns a handler which runs the given script against each record,
+// and passes those which it keeps to the next handler.
+//
+// The script must already have been prepared.
+func New(next slog.Handler, eval *evalfilter.Eval) *Handler {
+	return &Handler{next: next, filter: &filter{eval: eval}}
+}
+
+// SetEval replaces the script, for this handler and all those derived
+// from it.
+//
+// The script must already have been prepared.
+func (h *Handler) SetEval(eval *evalfilter.Eval) {
+	h.filter.mutex.Lock()
+	h.filter.eval = eval
+	h.filter.mutex.Unlock()
+}
+
+// Enabled reports whether the next handler handles records at the given
+// level.
+func (h *Handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+// Handle runs the script against the record, and passes it to the next
+// handler unless the script dropped it.
+func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
+
+	out, match, err := h.run(h.fields(r))
+	if err != nil {
+		r = r.Clone()
+		r.AddAttrs(slog.String(ErrorKey, err.Error()))
+		return h.next.Handle(ctx, r)
+	}
+
+	hash, ok := out.(*object.Hash)
+	if ok {
+		r = r.Clone()
+		r.AddAttrs(annotations(hash)...)
+		return h.next.Handle(ctx, r)
+	}
+
+	if !match {
+		return nil
+	}
+	return h.next.Handle(ctx, r)
+}
+
+// WithAttrs returns a handler whose records include the given attributes.
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+
+	if len(attrs) == 0 {
+		return h
+	}
+
+	c := h.clone()
+	c.next = h.next.WithAttrs(attrs)
+	c.attrs = append(c.attrs, grouped(h.groups, attrs))
+	return c
+}
+
+// WithGroup returns a handler whose attributes are within the named group.
+func (h *Handler) WithGroup(name string) slog.Handler {
+
+	if name == "" {
+		return h
+	}
+
+	c := h.clone()
+	c.next = h.next.WithGroup(name)
+	c.groups = append(c.groups, name)
+	return c
+}
+
+// clone returns a copy of the handler, sharing its script.
+func (h *Handler) clone() *Handler {
+	return &Handler{
+		next:   h.next,
+		filter: h.filter,
+		attrs:  append([]slog.Attr(nil), h.attrs...),
+		groups: append([]string(nil), h.groups...),
+	}
+}
+
+// fields returns the object the script is run against, which contains
+// the attributes of both the handler and the record.
+func (h *Handler) fields(r slog.Record) map[string]interface{} {
+
+	var attrs []slog.Attr
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+
+	tmp := slog.NewRecord(r.Time, r.Level, r.Message, r.PC)
+	tmp.AddAttrs(h.attrs...)
+	tmp.AddAttrs(grouped(h.groups, attrs))
+
+	return logadapter.Attrs(tmp)
+}
+
+// run runs the script against the given fields, and returns its result
+// along with whether that was true.
+func (h *Handler) run(fields map[string]interface{}) (object.Object, bool, error) {
+
+	h.filter.mutex.Lock()
+	defer h.filter.mutex.Unlock()
+
+	out, err := h.filter.eval.Execute(fields)
+	if err != nil {
+		return out, false, err
+	}
+	return out, h.filter.eval.True(out), nil
+}
+
+// grouped returns the attributes within the given groups.
+//
+// If there are no groups they are placed within a group without a name,
+// which is inlined.
+func grouped(groups []string, attrs []slog.Attr) slog.Attr {
+
+	attr := slog.Attr{Value: slog.GroupValue(attrs...)}
+	for i := len(groups) - 1; i >= 0; i-- {
+		attr.Key = groups[i]
+		if i > 0 {
+			attr = slog.Attr{Value: slog.GroupValue(attr)}
+		}
+	}
+	return attr
+}
+
+// annotations converts the entries of a hash to attributes, sorted by
+// name.
+func annotations(hash *object.Hash) []slog.Attr {
+
+	var out []slog.Attr
+	for _, pair := range hash.Pairs {
+
+		key := pair.Key.Inspect()
+		if str, ok := pair.Key.(*object.String); ok {
+			key = str.Value
+		}
+
+		out = append(out, slog.Attr{Key: key, Value: value(pair.Value)})
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Key < out[j].Key
+	})
+	return out
+}
+
+// value converts one of our objects to a value for an attribute.
+func value(obj object.Object) slog.Value {
+
+	switch v := obj.(type) {
+	case *object.Boolean:
+		return slog.BoolValue(v.Value)
+	case *object.Float:
+		return slog.Float64Value(v.Value)
+	case *object.Integer:
+		return slog.Int64Value(v.Value)
+	case *object.String:
+		return slog.StringValue(v.Value)
+	case *object.Hash:
+		return slog.GroupValue(annotations(v)...)
+	}
+	return slog.StringValue(obj.Inspect())
+}
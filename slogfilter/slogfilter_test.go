@@ -0,0 +1,113 @@
+//go:build go1.21
+// +build go1.21
+
+package slogfilter
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/skx/evalfilter/v2"
+)
+
+// logger returns a logger using our handler with the given script, and
+// the buffer it writes to.
+func logger(t *testing.T, script string) (*slog.Logger, *Handler, *bytes.Buffer) {
+
+	eval := evalfilter.New(script)
+	err := eval.Prepare()
+	if err != nil {
+		t.Fatalf("failed to compile %s: %s", script, err)
+	}
+
+	buf := &bytes.Buffer{}
+	next := slog.NewTextHandler(buf, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey && len(groups) == 0 {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})
+
+	handler := New(next, eval)
+	return slog.New(handler), handler, buf
+}
+
+// TestHandler tests dropping, keeping, and annotating records.
+func TestHandler(t *testing.T) {
+
+	log, _, buf := logger(t, `
+if ( level == "DEBUG" ) { return false; }
+if ( status >= 500 ) { return { "alert": true, "why": "status " + string(status) }; }
+return true;
+`)
+
+	log.Debug("dropped")
+	log.Info("kept", "status", 200)
+	log.Error("failed", "status", 503)
+
+	exp := `level=INFO msg=kept status=200
+level=ERROR msg=failed status=503 alert=true why="status 503"
+`
+	if buf.String() != exp {
+		t.Fatalf("wrong output, got:\n%s", buf.String())
+	}
+}
+
+// TestHandlerGroups tests that the attributes of derived handlers are
+// visible to the script.
+func TestHandlerGroups(t *testing.T) {
+
+	log, _, buf := logger(t, `return svc == "api" && req.path ~= /^\/admin/ && req.user.id == 3;`)
+
+	log = log.With("svc", "api").WithGroup("req").With(slog.Group("user", "id", 3))
+	log.Info("admin", "path", "/admin/users")
+	log.Info("public", "path", "/index.html")
+
+	exp := `level=INFO msg=admin svc=api req.user.id=3 req.path=/admin/users
+`
+	if buf.String() != exp {
+		t.Fatalf("wrong output, got:\n%s", buf.String())
+	}
+}
+
+// TestHandlerErrors tests that records are kept if the script fails.
+func TestHandlerErrors(t *testing.T) {
+
+	log, _, buf := logger(t, `return 100 / count;`)
+
+	log.Info("broken", "count", 0)
+	if !strings.HasPrefix(buf.String(), "level=INFO msg=broken count=0 filter_error=") {
+		t.Fatalf("wrong output, got:\n%s", buf.String())
+	}
+}
+
+// TestSetEval tests replacing the script.
+func TestSetEval(t *testing.T) {
+
+	log, handler, buf := logger(t, `return false;`)
+
+	derived := log.With("a", 1)
+	derived.Info("dropped")
+
+	eval := evalfilter.New(`return a == 1;`)
+	eval.Prepare()
+	handler.SetEval(eval)
+
+	derived.Info("kept")
+	log.Info("other", "a", 2)
+
+	if buf.String() != "level=INFO msg=kept a=1\n" {
+		t.Fatalf("wrong output, got:\n%s", buf.String())
+	}
+
+	// Enabled is delegated.
+	if !handler.Enabled(context.Background(), slog.LevelDebug) {
+		t.Fatalf("debug should be enabled")
+	}
+}
@@ -0,0 +1,74 @@
+// This file contains the positions of the sources of the instructions
+// we generate.
+//
+// Errors which occur when a script is run are much easier to track down
+// if they say where the problem is, such as "division by zero at line
+// 14, column 7".  As we compile each node of the AST we note the position
+// of its token, and each instruction we emit is recorded, in a source
+// map, as having come from the node currently being compiled.  The
+// virtual machine uses the map to describe the position of errors.
+
+package evalfilter
+
+import (
+	"github.com/skx/evalfilter/v2/ast"
+	"github.com/skx/evalfilter/v2/code"
+	"github.com/skx/evalfilter/v2/token"
+)
+
+// at records that the instructions we emit come from the given node, and
+// returns a function which restores the previous position once the node
+// has been compiled.
+func (e *Eval) at(node ast.Node) func() {
+
+	tok, ok := nodeToken(node)
+	if !ok || tok.Line <= 0 {
+		return func() {}
+	}
+
+	prev := e.position
+	e.position = code.Position{Line: tok.Line, Column: tok.Column}
+	return func() {
+		e.position = prev
+	}
+}
+
+// nodeToken returns the token of the given node, if it has one.
+func nodeToken(node ast.Node) (token.Token, bool) {
+
+	switch n := node.(type) {
+	case *ast.AssignStatement:
+		return n.Token, true
+	case *ast.CallExpression:
+		return n.Token, true
+	case *ast.ExpressionStatement:
+		return n.Token, true
+	case *ast.ForeachStatement:
+		return n.Token, true
+	case *ast.Identifier:
+		return n.Token, true
+	case *ast.IfExpression:
+		return n.Token, true
+	case *ast.IndexExpression:
+		return n.Token, true
+	case *ast.InfixExpression:
+		return n.Token, true
+	case *ast.MatchExpression:
+		return n.Token, true
+	case *ast.PostfixExpression:
+		return n.Token, true
+	case *ast.PrefixExpression:
+		return n.Token, true
+	case *ast.ReturnStatement:
+		return n.Token, true
+	case *ast.SliceExpression:
+		return n.Token, true
+	case *ast.SwitchExpression:
+		return n.Token, true
+	case *ast.TernaryExpression:
+		return n.Token, true
+	case *ast.WhileStatement:
+		return n.Token, true
+	}
+	return token.Token{}, false
+}
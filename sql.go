@@ -0,0 +1,35 @@
+// This file contains NewFromSQLWhere, which allows the WHERE clauses of
+// SQL statements to be used as scripts.
+
+package evalfilter
+
+import (
+	"github.com/skx/evalfilter/v2/code"
+	"github.com/skx/evalfilter/v2/sqlwhere"
+)
+
+// NewFromSQLWhere creates a new instance of the evaluator, whose script is
+// translated from the given SQL WHERE clause, such as:
+//
+//	Age >= 18 AND Name LIKE 'S%' AND Country NOT IN ('RU', 'CN')
+//
+// The grammar accepted is described by the sqlwhere package.  The result
+// is an ordinary evaluator, whose Script holds the translation, so it must
+// be prepared before it is run.  If the clause can't be translated then a
+// *ParseError is returned, describing the column at which the problem was
+// found.
+func NewFromSQLWhere(clause string) (*Eval, error) {
+
+	script, err := sqlwhere.Translate(clause)
+	if err != nil {
+		if serr, ok := err.(*sqlwhere.Error); ok {
+			return nil, &ParseError{
+				Phase:    "parse",
+				Position: code.Position{Line: 1, Column: serr.Column},
+				Message:  serr.Error(),
+			}
+		}
+		return nil, &ParseError{Phase: "parse", Message: err.Error()}
+	}
+	return New(script), nil
+}
@@ -0,0 +1,68 @@
+package evalfilter
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestNewFromSQLWhere tests running the WHERE clauses of SQL statements.
+func TestNewFromSQLWhere(t *testing.T) {
+
+	type Address struct {
+		City string
+	}
+	type Person struct {
+		Name    string
+		Age     int
+		Country string
+		Email   interface{}
+		Home    Address
+	}
+
+	people := []Person{
+		{Name: "Steve", Age: 45, Country: "FI", Home: Address{City: "Helsinki"}},
+		{Name: "sarah", Age: 17, Country: "UK", Email: "s@example.com"},
+		{Name: "Bob/Smith", Age: 70, Country: "US"},
+	}
+
+	tests := []struct {
+		clause  string
+		results []bool
+	}{
+		{"Age >= 18 AND Name LIKE 'S%'", []bool{true, false, false}},
+		{"Name ILIKE 's%'", []bool{true, true, false}},
+		{"Name LIKE 'Bob/%'", []bool{false, false, true}},
+		{"Country NOT IN ('UK', 'US')", []bool{true, false, false}},
+		{"Age BETWEEN 17 AND 45", []bool{true, true, false}},
+		{"Email IS NULL", []bool{true, false, true}},
+		{"Home.City = 'Helsinki' OR NOT (Age < 50)", []bool{true, false, true}},
+	}
+
+	for _, test := range tests {
+
+		eval, err := NewFromSQLWhere(test.clause)
+		if err != nil {
+			t.Fatalf("failed to translate %s: %s", test.clause, err)
+		}
+		err = eval.Prepare()
+		if err != nil {
+			t.Fatalf("failed to compile %s: %s", eval.Script, err)
+		}
+		for i, p := range people {
+			res, err := eval.Run(p)
+			if err != nil {
+				t.Fatalf("failed to run %s: %s", eval.Script, err)
+			}
+			if res != test.results[i] {
+				t.Errorf("expected %s to give %t for %s", test.clause, test.results[i], p.Name)
+			}
+		}
+	}
+
+	// Errors are parse errors.
+	_, err := NewFromSQLWhere("Age > 3 AND")
+	var perr *ParseError
+	if !errors.As(err, &perr) || perr.Position.Column != 12 {
+		t.Fatalf("expected a parse error at column 12, got %T %v", err, err)
+	}
+}
@@ -0,0 +1,144 @@
+// This file contains the lexer of our clauses.
+
+package sqlwhere
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// kind is the type of a token.
+type kind int
+
+const (
+	eof kind = iota
+	column
+	keyword
+	number
+	punct
+	str
+)
+
+// keywords contains the words which are keywords, rather than columns,
+// when they're not quoted.
+var keywords = map[string]bool{
+	"AND":     true,
+	"BETWEEN": true,
+	"FALSE":   true,
+	"ILIKE":   true,
+	"IN":      true,
+	"IS":      true,
+	"LIKE":    true,
+	"NOT":     true,
+	"NULL":    true,
+	"OR":      true,
+	"TRUE":    true,
+	"WHERE":   true,
+}
+
+// item is a single token of a clause.
+type item struct {
+
+	// kind holds the type of the token.
+	kind kind
+
+	// text holds the text of the token, which is upper-case for
+	// keywords, and without quotes for strings and quoted columns.
+	text string
+
+	// column holds the column at which the token began.
+	column int
+}
+
+// isKeyword returns true if the token is the given keyword.
+func (i item) isKeyword(word string) bool {
+	return i.kind == keyword && i.text == word
+}
+
+// String describes the token, for use in errors.
+func (i item) String() string {
+	switch i.kind {
+	case eof:
+		return "the end of the clause"
+	case str:
+		return fmt.Sprintf("'%s'", i.text)
+	}
+	return i.text
+}
+
+// lex returns the tokens of the given clause, ending with an eof token.
+func lex(clause string) ([]item, error) {
+
+	var out []item
+
+	chars := []rune(clause)
+	i := 0
+	for i < len(chars) {
+
+		c := chars[i]
+		start := i + 1
+
+		switch {
+		case unicode.IsSpace(c):
+			i++
+
+		case c == '\'' || c == '"':
+			// Strings, and quoted columns, double their quotes
+			// to include them.
+			text := ""
+			i++
+			for {
+				if i >= len(chars) {
+					return nil, &Error{Column: start, Message: "unterminated string"}
+				}
+				if chars[i] == c {
+					if i+1 < len(chars) && chars[i+1] == c {
+						text += string(c)
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				text += string(chars[i])
+				i++
+			}
+			k := str
+			if c == '"' {
+				k = column
+			}
+			out = append(out, item{kind: k, text: text, column: start})
+
+		case unicode.IsDigit(c):
+			for i < len(chars) && (unicode.IsDigit(chars[i]) || chars[i] == '.') {
+				i++
+			}
+			out = append(out, item{kind: number, text: string(chars[start-1 : i]), column: start})
+
+		case unicode.IsLetter(c) || c == '_':
+			for i < len(chars) && (unicode.IsLetter(chars[i]) || unicode.IsDigit(chars[i]) || chars[i] == '_' || chars[i] == '.') {
+				i++
+			}
+			text := string(chars[start-1 : i])
+			if keywords[strings.ToUpper(text)] {
+				out = append(out, item{kind: keyword, text: strings.ToUpper(text), column: start})
+			} else {
+				out = append(out, item{kind: column, text: text, column: start})
+			}
+
+		case strings.ContainsRune("<>!", c) && i+1 < len(chars) && (chars[i+1] == '=' || (c == '<' && chars[i+1] == '>')):
+			out = append(out, item{kind: punct, text: string(chars[i : i+2]), column: start})
+			i += 2
+
+		case strings.ContainsRune("=<>(),-", c):
+			out = append(out, item{kind: punct, text: string(c), column: start})
+			i++
+
+		default:
+			return nil, &Error{Column: start, Message: fmt.Sprintf("unexpected character '%c'", c)}
+		}
+	}
+
+	return append(out, item{kind: eof, column: len(chars) + 1}), nil
+}
@@ -0,0 +1,405 @@
+// Package sqlwhere translates the WHERE clauses of SQL statements into
+// evalfilter scripts, which allows the filters of systems which accept SQL
+// to be used without rewriting them.
+//
+// We implement a restricted grammar, which covers the conditions that
+// filters are usually made from:
+//
+// * Comparisons with `=`, `<>`, `!=`, `<`, `<=`, `>`, and `>=`.
+//
+// * `AND`, `OR`, `NOT`, and parentheses.
+//
+// * `LIKE`, and `ILIKE`, with the `%` and `_` wildcards.
+//
+// * `IN` and `BETWEEN`, along with their negations.
+//
+// * `IS NULL`, and `IS NOT NULL`.
+//
+// The operands may be columns, which are the fields of the object the
+// script is run against, numbers, 'strings', `TRUE`, and `FALSE`.
+// Columns may be quoted, as in `"name"`, and may refer to the members of
+// nested objects, as in `address.city`.  Keywords are recognized
+// regardless of their case.  Arithmetic, and function calls, are not
+// supported.
+package sqlwhere
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/skx/evalfilter/v2/token"
+)
+
+// Error is the error returned if a clause can't be translated.
+type Error struct {
+
+	// Column holds the column, within the clause, at which the error
+	// was found.  Columns start at one.
+	Column int
+
+	// Message holds the description of the error.
+	Message string
+}
+
+// Error returns the description of the error.
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s at column %d", e.Message, e.Column)
+}
+
+// Translate returns the evalfilter script which is equivalent to the given
+// WHERE clause, such as `a = 3 AND b LIKE 'x%'`.
+//
+// The clause may begin with the `WHERE` keyword, but needn't.
+func Translate(clause string) (string, error) {
+
+	toks, err := lex(clause)
+	if err != nil {
+		return "", err
+	}
+
+	p := &parser{tokens: toks}
+
+	// The keyword is optional.
+	p.accept("WHERE")
+
+	expr, err := p.parseOr()
+	if err != nil {
+		return "", err
+	}
+	if p.peek().kind != eof {
+		return "", p.errorf("unexpected %s", p.peek())
+	}
+	return "return " + expr + ";", nil
+}
+
+// parser holds our state as we translate a clause.
+type parser struct {
+
+	// tokens holds the tokens of the clause.
+	tokens []item
+
+	// pos holds the index of the next token.
+	pos int
+}
+
+// peek returns the next token, without consuming it.
+func (p *parser) peek() item {
+	return p.tokens[p.pos]
+}
+
+// next consumes, and returns, the next token.
+func (p *parser) next() item {
+	tok := p.tokens[p.pos]
+	if tok.kind != eof {
+		p.pos++
+	}
+	return tok
+}
+
+// accept consumes the next token if it is the given keyword.
+func (p *parser) accept(keyword string) bool {
+	if p.peek().isKeyword(keyword) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// acceptAfterNot consumes `NOT` followed by the given keyword, if they're
+// next.
+func (p *parser) acceptAfterNot(keyword string) bool {
+	if p.peek().isKeyword("NOT") && p.tokens[p.pos+1].isKeyword(keyword) {
+		p.pos += 2
+		return true
+	}
+	return false
+}
+
+// expect consumes the given symbol, or returns an error.
+func (p *parser) expect(symbol string) error {
+	tok := p.peek()
+	if tok.kind != punct || tok.text != symbol {
+		return p.errorf("expected '%s', found %s", symbol, tok)
+	}
+	p.pos++
+	return nil
+}
+
+// errorf returns an error at the next token.
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return &Error{Column: p.peek().column, Message: fmt.Sprintf(format, args...)}
+}
+
+// parseOr parses conditions joined by `OR`, which binds least tightly.
+func (p *parser) parseOr() (string, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return "", err
+	}
+	for p.accept("OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return "", err
+		}
+		left = "( " + left + " || " + right + " )"
+	}
+	return left, nil
+}
+
+// parseAnd parses conditions joined by `AND`.
+func (p *parser) parseAnd() (string, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return "", err
+	}
+	for p.accept("AND") {
+		right, err := p.parseNot()
+		if err != nil {
+			return "", err
+		}
+		left = "( " + left + " && " + right + " )"
+	}
+	return left, nil
+}
+
+// parseNot parses a condition which might be negated.
+func (p *parser) parseNot() (string, error) {
+	if p.accept("NOT") {
+		expr, err := p.parseNot()
+		if err != nil {
+			return "", err
+		}
+		return "!" + expr, nil
+	}
+	return p.parseCondition()
+}
+
+// parseCondition parses a single comparison, or a condition within
+// parentheses.
+func (p *parser) parseCondition() (string, error) {
+
+	if tok := p.peek(); tok.kind == punct && tok.text == "(" {
+		p.next()
+		expr, err := p.parseOr()
+		if err != nil {
+			return "", err
+		}
+		if err := p.expect(")"); err != nil {
+			return "", err
+		}
+		return expr, nil
+	}
+
+	left, err := p.parseOperand()
+	if err != nil {
+		return "", err
+	}
+
+	// The comparison operators.
+	if tok := p.peek(); tok.kind == punct {
+		if op, ok := comparisons[tok.text]; ok {
+			p.next()
+			right, err := p.parseOperand()
+			if err != nil {
+				return "", err
+			}
+			return "( " + left + " " + op + " " + right + " )", nil
+		}
+	}
+
+	switch {
+	case p.accept("LIKE"):
+		return p.parseLike(left, false, false)
+	case p.acceptAfterNot("LIKE"):
+		return p.parseLike(left, false, true)
+	case p.accept("ILIKE"):
+		return p.parseLike(left, true, false)
+	case p.acceptAfterNot("ILIKE"):
+		return p.parseLike(left, true, true)
+	case p.accept("IN"):
+		return p.parseIn(left, false)
+	case p.acceptAfterNot("IN"):
+		return p.parseIn(left, true)
+	case p.accept("BETWEEN"):
+		return p.parseBetween(left, false)
+	case p.acceptAfterNot("BETWEEN"):
+		return p.parseBetween(left, true)
+	case p.accept("IS"):
+		negate := p.accept("NOT")
+		if !p.accept("NULL") {
+			return "", p.errorf("expected NULL, found %s", p.peek())
+		}
+		if negate {
+			return "( type( " + left + " ) != \"null\" )", nil
+		}
+		return "( type( " + left + " ) == \"null\" )", nil
+	}
+
+	// A column, or boolean, on its own.
+	return left, nil
+}
+
+// comparisons maps the comparison operators of SQL to our own.
+var comparisons = map[string]string{
+	"=":  "==",
+	"<>": "!=",
+	"!=": "!=",
+	"<":  "<",
+	"<=": "<=",
+	">":  ">",
+	">=": ">=",
+}
+
+// parseLike parses the pattern of a `LIKE`, which becomes a regular
+// expression.
+func (p *parser) parseLike(left string, fold bool, negate bool) (string, error) {
+
+	tok := p.peek()
+	if tok.kind != str {
+		return "", p.errorf("expected a pattern, found %s", tok)
+	}
+	p.next()
+
+	re := "^"
+	for _, c := range tok.text {
+		switch c {
+		case '%':
+			re += ".*"
+		case '_':
+			re += "."
+		default:
+			re += regexp.QuoteMeta(string(c))
+		}
+	}
+	re += "$"
+
+	// Our lexer treats a backslash as escaping the character which
+	// follows it, so both it and the slash need escaping.
+	re = strings.NewReplacer(`\`, `\\`, `/`, `\/`).Replace(re)
+
+	op := "~="
+	if negate {
+		op = "!~"
+	}
+	flags := "s"
+	if fold {
+		flags = "is"
+	}
+	return "( " + left + " " + op + " /(?" + flags + ")" + re + "/ )", nil
+}
+
+// parseIn parses the list of values of an `IN`.
+func (p *parser) parseIn(left string, negate bool) (string, error) {
+
+	if err := p.expect("("); err != nil {
+		return "", err
+	}
+
+	var values []string
+	for {
+		val, err := p.parseOperand()
+		if err != nil {
+			return "", err
+		}
+		values = append(values, val)
+
+		if tok := p.peek(); tok.kind == punct && tok.text == "," {
+			p.next()
+			continue
+		}
+		break
+	}
+	if err := p.expect(")"); err != nil {
+		return "", err
+	}
+
+	expr := "( " + left + " in [ " + strings.Join(values, ", ") + " ] )"
+	if negate {
+		return "!" + expr, nil
+	}
+	return expr, nil
+}
+
+// parseBetween parses the bounds of a `BETWEEN`, which are inclusive.
+func (p *parser) parseBetween(left string, negate bool) (string, error) {
+
+	low, err := p.parseOperand()
+	if err != nil {
+		return "", err
+	}
+	if !p.accept("AND") {
+		return "", p.errorf("expected AND, found %s", p.peek())
+	}
+	high, err := p.parseOperand()
+	if err != nil {
+		return "", err
+	}
+
+	expr := "( " + left + " >= " + low + " && " + left + " <= " + high + " )"
+	if negate {
+		return "!" + expr, nil
+	}
+	return expr, nil
+}
+
+// parseOperand parses a column, or a value.
+func (p *parser) parseOperand() (string, error) {
+
+	tok := p.peek()
+	switch tok.kind {
+	case column:
+		for _, part := range strings.Split(tok.text, ".") {
+			if !validColumn(part) {
+				return "", p.errorf("the column %s can't be used", tok)
+			}
+		}
+		p.next()
+		return tok.text, nil
+	case number:
+		if !numeric.MatchString(tok.text) {
+			return "", p.errorf("the number %s is invalid", tok)
+		}
+		p.next()
+		return tok.text, nil
+	case str:
+		p.next()
+		return quote(tok.text), nil
+	case keyword:
+		switch tok.text {
+		case "TRUE":
+			p.next()
+			return "true", nil
+		case "FALSE":
+			p.next()
+			return "false", nil
+		}
+	case punct:
+		// A negative number.
+		if tok.text == "-" && p.tokens[p.pos+1].kind == number {
+			p.next()
+			val, err := p.parseOperand()
+			return "-" + val, err
+		}
+	}
+
+	return "", p.errorf("expected a column or value, found %s", tok)
+}
+
+// numeric matches the numbers we accept.
+var numeric = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?$`)
+
+// identifier matches the names which are valid identifiers in our
+// scripts.
+var identifier = regexp.MustCompile(`^[\pL_$][\pL\pN_$]*$`)
+
+// validColumn returns true if the given name may be used as the name of
+// a column, or of one of its members, within a script.
+func validColumn(name string) bool {
+	return identifier.MatchString(name) && token.LookupIdentifier(name) == token.IDENT
+}
+
+// quote returns the given string as a literal within a script.
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`).Replace(s) + `"`
+}
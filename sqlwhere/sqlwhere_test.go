@@ -0,0 +1,82 @@
+package sqlwhere
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestTranslate tests the translation of valid clauses.
+func TestTranslate(t *testing.T) {
+
+	tests := []struct {
+		input  string
+		output string
+	}{
+		{"a = 3", "return ( a == 3 );"},
+		{"WHERE a <> 3", "return ( a != 3 );"},
+		{"a != -3.5", "return ( a != -3.5 );"},
+		{"a = 3 AND b LIKE 'x%'", "return ( ( a == 3 ) && ( b ~= /(?s)^x.*$/ ) );"},
+		{"a = 1 or b = 2 and c = 3", "return ( ( a == 1 ) || ( ( b == 2 ) && ( c == 3 ) ) );"},
+		{"(a = 1 OR b = 2) AND c = 3", "return ( ( ( a == 1 ) || ( b == 2 ) ) && ( c == 3 ) );"},
+		{"NOT active", "return !active;"},
+		{"name NOT ILIKE '_a.b/c'", "return ( name !~ /(?is)^.a\\\\.b\\/c$/ );"},
+		{"country IN ('UK', 'FI')", `return ( country in [ "UK", "FI" ] );`},
+		{"country NOT IN ('UK')", `return !( country in [ "UK" ] );`},
+		{"age BETWEEN 18 AND 65", "return ( age >= 18 && age <= 65 );"},
+		{"age NOT BETWEEN 18 AND 65", "return !( age >= 18 && age <= 65 );"},
+		{"email IS NULL", `return ( type( email ) == "null" );`},
+		{"email is not null", `return ( type( email ) != "null" );`},
+		{`"Name" = 'O''Brien "the" \ one'`, `return ( Name == "O'Brien \"the\" \\ one" );`},
+		{"address.city = 'Helsinki'", `return ( address.city == "Helsinki" );`},
+		{"verified = TRUE", "return ( verified == true );"},
+	}
+
+	for _, test := range tests {
+		out, err := Translate(test.input)
+		if err != nil {
+			t.Fatalf("failed to translate %s: %s", test.input, err)
+		}
+		if out != test.output {
+			t.Errorf("translating %s gave %s, expected %s", test.input, out, test.output)
+		}
+	}
+}
+
+// TestErrors tests that clauses which can't be translated are reported,
+// along with their column.
+func TestErrors(t *testing.T) {
+
+	tests := []struct {
+		input  string
+		error  string
+		column int
+	}{
+		{"", "expected a column or value, found the end of the clause", 1},
+		{"a = ", "expected a column or value", 5},
+		{"a = 'x", "unterminated string", 5},
+		{"a = 3 b = 4", "unexpected b", 7},
+		{"(a = 3", "expected ')'", 7},
+		{"a LIKE 3", "expected a pattern", 8},
+		{"a IN 3", "expected '('", 6},
+		{"a BETWEEN 1 OR 2", "expected AND", 13},
+		{"a IS 3", "expected NULL", 6},
+		{"a = 3 ; DROP TABLE x", "unexpected character ';'", 7},
+		{"if = 3", "the column if can't be used", 1},
+		{`"a b" = 3`, "the column a b can't be used", 1},
+		{"a = 1.2.3", "the number 1.2.3 is invalid", 5},
+	}
+
+	for _, test := range tests {
+		_, err := Translate(test.input)
+		if err == nil {
+			t.Fatalf("expected an error translating %s", test.input)
+		}
+		serr, ok := err.(*Error)
+		if !ok {
+			t.Fatalf("expected an *Error, got %T", err)
+		}
+		if !strings.Contains(serr.Message, test.error) || serr.Column != test.column {
+			t.Errorf("translating %s gave '%s', expected '%s' at column %d", test.input, err, test.error, test.column)
+		}
+	}
+}
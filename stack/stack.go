@@ -30,9 +30,15 @@ func New() *Stack {
 	return &Stack{}
 }
 
-// Clear removes all data from the stack
+// Clear removes all data from the stack.
+//
+// The storage is kept, so that a stack which is cleared before each run
+// of a program doesn't need to grow again - which would allocate.
 func (s *Stack) Clear() {
-	s.entries = []object.Object{}
+	for i := range s.entries {
+		s.entries[i] = nil
+	}
+	s.entries = s.entries[:0]
 }
 
 // Empty returns true if the stack is empty.
@@ -0,0 +1,142 @@
+// This file contains the statistics of the clauses of a script.
+//
+// A rule which is run against many events will often contain clauses
+// which are always true, or never true, for the events it sees - and
+// those which are expensive to evaluate ought to be tested after those
+// which usually decide the result.  Counting how often each clause was
+// true, and false, across many runs shows which is which.
+//
+// The counts are recorded in the same way as the values of an explained
+// run: the script is compiled with an OpExplain instruction after each
+// comparison, and boolean operation, and the values they record are
+// counted after each run.
+
+package evalfilter
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/skx/evalfilter/v2/ast"
+)
+
+// ClauseStatistic describes how often a clause of a script was true, and
+// false, across the runs of the script.
+type ClauseStatistic struct {
+
+	// Line holds the line of the script the clause is upon.
+	Line int
+
+	// Column holds the column of the operator of the clause.
+	Column int
+
+	// Expression holds the clause, such as `Count > 5`.
+	Expression string
+
+	// True holds the number of times the clause was true.
+	True int
+
+	// False holds the number of times the clause was false.
+	False int
+}
+
+// Dead returns true if the clause was always true, or always false, in
+// the runs which evaluated it.
+func (c ClauseStatistic) Dead() bool {
+	return c.True == 0 || c.False == 0
+}
+
+// String describes the statistic, such as
+// "line 1, column 7: Count > 5 - true 10, false 0".
+func (c ClauseStatistic) String() string {
+	return fmt.Sprintf("line %d, column %d: %s - true %d, false %d",
+		c.Line, c.Column, c.Expression, c.True, c.False)
+}
+
+// SetClauseStatistics enables, or disables, the counting of how often
+// each clause of the script is true, and false, when it is run.
+//
+// This takes effect when the script is next prepared, and disables the
+// optimizer - so that the clauses counted are those of the script as it
+// was written.
+func (e *Eval) SetClauseStatistics(enable bool) {
+	e.statistics = enable
+}
+
+// ClauseStatistics returns how often each clause of the script was true,
+// and false, in the runs since the script was prepared, or the counts
+// were reset.
+//
+// The clauses are returned in the order they appear in the script, and
+// each is included whether or not it was ever evaluated.
+func (e *Eval) ClauseStatistics() []ClauseStatistic {
+
+	var out []ClauseStatistic
+	for _, c := range e.clauses {
+		if c != nil {
+			out = append(out, *c)
+		}
+	}
+
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].Line != out[j].Line {
+			return out[i].Line < out[j].Line
+		}
+		return out[i].Column < out[j].Column
+	})
+	return out
+}
+
+// ResetClauseStatistics forgets the counts of the previous runs.
+func (e *Eval) ResetClauseStatistics() {
+	for _, c := range e.clauses {
+		if c != nil {
+			c.True = 0
+			c.False = 0
+		}
+	}
+}
+
+// prepareClauses creates the statistics of the clauses which were
+// compiled, with each starting from zero.
+func (e *Eval) prepareClauses() {
+
+	e.clauses = nil
+	if !e.statistics {
+		return
+	}
+
+	for _, x := range e.explained {
+		var c *ClauseStatistic
+		switch n := x.node.(type) {
+		case *ast.InfixExpression:
+			c = &ClauseStatistic{Line: n.Token.Line, Column: n.Token.Column}
+		case *ast.PrefixExpression:
+			c = &ClauseStatistic{Line: n.Token.Line, Column: n.Token.Column}
+		}
+		if c != nil {
+			c.Expression = describeExpression(x.node)
+		}
+		e.clauses = append(e.clauses, c)
+	}
+}
+
+// countClauses counts the values of the clauses recorded by the most
+// recent run.
+func (e *Eval) countClauses() {
+
+	if len(e.clauses) == 0 {
+		return
+	}
+
+	for _, o := range e.machine.Observations() {
+		if o.ID >= len(e.clauses) || e.clauses[o.ID] == nil {
+			continue
+		}
+		if o.True {
+			e.clauses[o.ID].True++
+		} else {
+			e.clauses[o.ID].False++
+		}
+	}
+}
@@ -0,0 +1,205 @@
+// Package stream allows a RuleSet to be applied to a stream of messages,
+// such as those consumed from a message broker like Kafka or NSQ.
+//
+// Messages are read from a Source, and each is decoded as a JSON object
+// and run against every rule in the set.  Those messages which match at
+// least one rule are passed to a Sink, along with the names of the rules
+// they matched.
+//
+// Sources and sinks are interfaces, so that any broker client may be
+// used.  For example a source wrapping a Kafka reader might look like:
+//
+//	type kafkaSource struct {
+//		reader *kafka.Reader
+//	}
+//
+//	func (k *kafkaSource) Receive() ([]byte, error) {
+//		msg, err := k.reader.ReadMessage(context.Background())
+//		return msg.Value, err
+//	}
+//
+// Implementations reading newline-delimited messages, such as the output
+// of `kcat` or `nsq_tail`, and writing them, are provided.
+package stream
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/skx/evalfilter/v2"
+)
+
+// Source is the interface for something which produces messages.
+type Source interface {
+
+	// Receive returns the next message, or io.EOF if there are no
+	// further messages.
+	Receive() ([]byte, error)
+}
+
+// Sink is the interface for something which consumes the messages which
+// matched.
+type Sink interface {
+
+	// Send is invoked with each message which matched, along with the
+	// names of the rules it matched.
+	Send(msg []byte, rules []string) error
+}
+
+// SinkFunc allows an ordinary function to be used as a Sink.
+type SinkFunc func(msg []byte, rules []string) error
+
+// Send invokes the function.
+func (f SinkFunc) Send(msg []byte, rules []string) error {
+	return f(msg, rules)
+}
+
+// Stats holds the counts of the messages we've processed.
+type Stats struct {
+
+	// Received holds the number of messages read from the source.
+	Received int
+
+	// Matched holds the number of messages which matched at least
+	// one rule, and were sent to the sink.
+	Matched int
+
+	// Rules holds the number of messages each rule matched, by the
+	// name of the rule.
+	Rules map[string]int
+}
+
+// Consume reads each message from the source, runs the rules against it,
+// and passes those which matched to the sink.
+//
+// Consume returns when the source is exhausted, or upon the first error,
+// along with the counts of the messages processed so far.
+func Consume(src Source, rules *evalfilter.RuleSet, sink Sink) (Stats, error) {
+
+	stats := Stats{Rules: make(map[string]int)}
+	for {
+		msg, err := src.Receive()
+		if err == io.EOF {
+			return stats, nil
+		}
+		if err != nil {
+			return stats, err
+		}
+		stats.Received++
+
+		obj := make(map[string]interface{})
+		err = json.Unmarshal(msg, &obj)
+		if err != nil {
+			return stats, fmt.Errorf("message %d: %w", stats.Received, err)
+		}
+
+		res, err := rules.Run(obj)
+		if err != nil {
+			return stats, fmt.Errorf("message %d: %w", stats.Received, err)
+		}
+		if len(res.Matched) == 0 {
+			continue
+		}
+
+		stats.Matched++
+		for _, name := range res.Matched {
+			stats.Rules[name]++
+		}
+		err = sink.Send(msg, res.Matched)
+		if err != nil {
+			return stats, err
+		}
+	}
+}
+
+// lines is a Source which reads newline-delimited messages.
+type lines struct {
+	reader *bufio.Reader
+}
+
+// Lines returns a Source which reads one message from each line of the
+// given reader, skipping blank lines.
+func Lines(r io.Reader) Source {
+	return &lines{reader: bufio.NewReader(r)}
+}
+
+// Receive returns the next non-blank line.
+func (l *lines) Receive() ([]byte, error) {
+	for {
+		line, err := l.reader.ReadBytes('\n')
+
+		// The final line might not be terminated.
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 {
+			return line, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Writer returns a Sink which writes each message to the given writer,
+// followed by a newline.
+func Writer(w io.Writer) Sink {
+	return SinkFunc(func(msg []byte, rules []string) error {
+		// Copy, rather than appending to the caller's message.
+		buf := make([]byte, 0, len(msg)+1)
+		buf = append(append(buf, msg...), '\n')
+
+		_, err := w.Write(buf)
+		return err
+	})
+}
+
+// fanOut is a Sink which sends each message to a sink for each of the
+// rules it matched.
+type fanOut struct {
+
+	// open returns the sink for the named rule.
+	open func(rule string) (Sink, error)
+
+	// sinks holds the sinks we've opened.
+	sinks map[string]Sink
+
+	// mutex protects our sinks.
+	mutex sync.Mutex
+}
+
+// FanOut returns a Sink which sends each message to a separate sink for
+// each rule it matched, such as a topic per rule.
+//
+// The sink for a rule is created by invoking the given function, the
+// first time that the rule matches.
+func FanOut(open func(rule string) (Sink, error)) Sink {
+	return &fanOut{open: open, sinks: make(map[string]Sink)}
+}
+
+// Send sends the message to the sink of each rule.
+func (f *fanOut) Send(msg []byte, rules []string) error {
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for _, rule := range rules {
+		sink, ok := f.sinks[rule]
+		if !ok {
+			var err error
+			sink, err = f.open(rule)
+			if err != nil {
+				return err
+			}
+			f.sinks[rule] = sink
+		}
+
+		err := sink.Send(msg, []string{rule})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package stream
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/skx/evalfilter/v2"
+)
+
+// rules returns a RuleSet containing the given scripts.
+func rules(t *testing.T, scripts map[string]string) *evalfilter.RuleSet {
+
+	set := evalfilter.NewRuleSet()
+	for _, name := range []string{"errors", "slow", "broken"} {
+		script, ok := scripts[name]
+		if !ok {
+			continue
+		}
+		eval := evalfilter.New(script)
+		err := eval.Prepare()
+		if err != nil {
+			t.Fatalf("failed to compile %s: %s", name, err)
+		}
+		err = set.AddRule(name, eval)
+		if err != nil {
+			t.Fatalf("failed to add %s: %s", name, err)
+		}
+	}
+	return set
+}
+
+// TestConsume tests consuming messages, and writing them to a sink.
+func TestConsume(t *testing.T) {
+
+	input := `{"level": "error", "duration": 10}
+
+{"level": "info", "duration": 500}
+{"level": "error", "duration": 900}
+{"level": "info", "duration": 5}`
+
+	set := rules(t, map[string]string{
+		"errors": `return level == "error";`,
+		"slow":   `return duration > 100;`,
+	})
+
+	var out bytes.Buffer
+	stats, err := Consume(Lines(strings.NewReader(input)), set, Writer(&out))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if stats.Received != 4 || stats.Matched != 3 {
+		t.Fatalf("wrong stats: %v", stats)
+	}
+	if len(stats.Rules) != 2 || stats.Rules["errors"] != 2 || stats.Rules["slow"] != 2 {
+		t.Fatalf("wrong counts of rules: %v", stats.Rules)
+	}
+
+	exp := `{"level": "error", "duration": 10}
+{"level": "info", "duration": 500}
+{"level": "error", "duration": 900}
+`
+	if out.String() != exp {
+		t.Fatalf("wrong output, got:\n%s", out.String())
+	}
+
+	// Now fan-out to a sink per rule.
+	sinks := make(map[string]*bytes.Buffer)
+	fan := FanOut(func(rule string) (Sink, error) {
+		sinks[rule] = &bytes.Buffer{}
+		return Writer(sinks[rule]), nil
+	})
+
+	_, err = Consume(Lines(strings.NewReader(input)), set, fan)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(sinks) != 2 {
+		t.Fatalf("expected two sinks, got %d", len(sinks))
+	}
+	if strings.Count(sinks["errors"].String(), "\n") != 2 || strings.Count(sinks["slow"].String(), "\n") != 2 {
+		t.Fatalf("wrong fan-out:\n%s\n%s", sinks["errors"], sinks["slow"])
+	}
+}
+
+// TestConsumeErrors tests that errors identify the message.
+func TestConsumeErrors(t *testing.T) {
+
+	tests := []struct {
+		input string
+		sink  Sink
+		error string
+	}{
+		{`{"a": 1}` + "\n[1, 2]", nil, "message 2: json: cannot unmarshal"},
+		{`{"a": 1}` + "\n{\"a\":", nil, "message 2: unexpected end of JSON input"},
+		{`{"a": 0}`, nil, "message 1: rule broken: "},
+		{`{"a": 1}`, SinkFunc(func(msg []byte, rules []string) error {
+			return fmt.Errorf("sink failed")
+		}), "sink failed"},
+	}
+
+	set := rules(t, map[string]string{
+		"errors": `return true;`,
+		"broken": `return 1 / a;`,
+	})
+
+	for _, test := range tests {
+
+		sink := test.sink
+		if sink == nil {
+			sink = Writer(&bytes.Buffer{})
+		}
+
+		_, err := Consume(Lines(strings.NewReader(test.input)), set, sink)
+		if err == nil {
+			t.Fatalf("expected an error for %q", test.input)
+		}
+		if !strings.HasPrefix(err.Error(), test.error) {
+			t.Fatalf("wrong error for %q, got '%s' expected '%s'", test.input, err, test.error)
+		}
+	}
+
+	// The error of the rule may be found.
+	_, err := Consume(Lines(strings.NewReader(`{"a": 0}`)), set, Writer(&bytes.Buffer{}))
+	var rerr *evalfilter.RuleError
+	if !errors.As(err, &rerr) || rerr.Name != "broken" {
+		t.Fatalf("the error of the rule was lost: %#v", err)
+	}
+}
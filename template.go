@@ -0,0 +1,47 @@
+// This file contains the helpers which allow scripts to be used within
+// templates.
+
+package evalfilter
+
+import (
+	"fmt"
+	"text/template"
+)
+
+// FuncMap returns a function map which makes the script available to a
+// template, under the given name, for use in conditionals.  For example:
+//
+//	tmpl := template.New("page").Funcs(eval.FuncMap("evalfilter"))
+//	tmpl.Parse(`{{ if evalfilter . }}Welcome back{{ end }}`)
+//
+// The function takes the object to run the script against, and returns
+// the result of Run; if the script fails the error stops the template
+// executing.  As html/template's FuncMap has the same underlying type
+// the map may be converted for use there.
+//
+// As with Run you must invoke Prepare before the template is executed.
+func (e *Eval) FuncMap(name string) template.FuncMap {
+	return template.FuncMap{name: e.Run}
+}
+
+// FuncMap returns a function map which makes the rules of the set
+// available to a template, under the given name.  The function takes the
+// name of a rule, and the object to run it against:
+//
+//	{{ if rule "adult" . }}Age verified{{ end }}
+//
+// Unknown rules are reported as an error, which stops the template
+// executing.
+func (r *RuleSet) FuncMap(name string) template.FuncMap {
+	return template.FuncMap{name: func(rule string, obj interface{}) (bool, error) {
+
+		r.mutex.RLock()
+		found, ok := r.names[rule]
+		r.mutex.RUnlock()
+
+		if !ok {
+			return false, fmt.Errorf("the rule %s does not exist", rule)
+		}
+		return found.Eval.Run(obj)
+	}}
+}
@@ -9,7 +9,10 @@
 // instructions which will ultimately be executed by our virtual machine.
 package token
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 // Type is a string
 type Type string
@@ -36,13 +39,16 @@ type Token struct {
 // Our known token-types
 const (
 	AND            = "&&"
+	ARROW          = "=>"
 	ASSIGN         = "="
 	ASTERISK       = "*"
 	ASTERISKEQUALS = "*="
 	BANG           = "!"
+	BREAK          = "BREAK"
 	CASE           = "case"
 	COLON          = ":"
 	COMMA          = ","
+	CONTINUE       = "CONTINUE"
 	CONTAINS       = "~="
 	DEFAULT        = "DEFAULT"
 	DOTDOT         = ".."
@@ -97,7 +103,9 @@ const (
 
 // reversed keywords
 var keywords = map[string]Type{
+	"break":    BREAK,
 	"case":     CASE,
+	"continue": CONTINUE,
 	"default":  DEFAULT,
 	"else":     ELSE,
 	"false":    FALSE,
@@ -121,6 +129,32 @@ func LookupIdentifier(identifier string) Type {
 	return IDENT
 }
 
+// aliases maps the words which may be used in place of operators, by
+// scripts written for SQL-like filters, to the operator and its literal.
+var aliases = map[string]Token{
+	"and": {Type: AND, Literal: "&&"},
+	"not": {Type: BANG, Literal: "!"},
+	"or":  {Type: OR, Literal: "||"},
+}
+
+// LookupSQLIdentifier determines whether the identifier is a keyword, as
+// LookupIdentifier does, regardless of its case - or one of the words
+// `and`, `or`, and `not`, which are aliases for `&&`, `||`, and `!`.
+//
+// The literal to use for the token is returned along with its type, as
+// the type of an alias isn't enough to tell the parser which operator it
+// stands for.
+func LookupSQLIdentifier(identifier string) (Type, string) {
+	lower := strings.ToLower(identifier)
+	if tok, ok := aliases[lower]; ok {
+		return tok.Type, tok.Literal
+	}
+	if tok, ok := keywords[lower]; ok {
+		return tok, lower
+	}
+	return IDENT, identifier
+}
+
 // Position returns a report of the current token's position, reporting on
 // the line-number and column-number of the token.
 func (t Token) Position() string {
@@ -23,6 +23,39 @@ func TestLookup(t *testing.T) {
 	}
 }
 
+// TestLookupSQL tests that keywords are found regardless of their case,
+// along with the aliases for operators.
+func TestLookupSQL(t *testing.T) {
+
+	for key, val := range keywords {
+		for _, name := range []string{key, strings.ToUpper(key), strings.ToUpper(key[:1]) + key[1:]} {
+			typ, lit := LookupSQLIdentifier(name)
+			if typ != val || lit != key {
+				t.Errorf("Lookup of %s failed, got %s %s", name, typ, lit)
+			}
+		}
+	}
+
+	tests := []struct {
+		input   string
+		typ     Type
+		literal string
+	}{
+		{"AND", AND, "&&"},
+		{"and", AND, "&&"},
+		{"Or", OR, "||"},
+		{"NOT", BANG, "!"},
+		{"Name", IDENT, "Name"},
+		{"ANDREW", IDENT, "ANDREW"},
+	}
+	for _, test := range tests {
+		typ, lit := LookupSQLIdentifier(test.input)
+		if typ != test.typ || lit != test.literal {
+			t.Errorf("Lookup of %s gave %s %s, expected %s %s", test.input, typ, lit, test.typ, test.literal)
+		}
+	}
+}
+
 // TestPosition doesn't really test anything :/
 func TestPosition(t *testing.T) {
 	x := &Token{}
@@ -0,0 +1,67 @@
+// This file contains the code which allows scripts to grade the objects
+// they're run against, rather than only matching them or not.
+
+package evalfilter
+
+import (
+	"github.com/skx/evalfilter/v2/vm"
+)
+
+// Verdict holds the outcome of running a script via RunVerdict.
+type Verdict struct {
+
+	// Rule holds the name of the rule which gave the verdict, within
+	// a RuleSet, or the empty string for a verdict from RunVerdict.
+	Rule string
+
+	// Match holds the result of the script, as Run would return it.
+	Match bool
+
+	// Score holds the sum of the values the script gave to `score`.
+	Score float64
+
+	// Severity holds the highest severity the script gave to
+	// `severity`, or vm.SeverityNone if it gave none.
+	Severity vm.Severity
+}
+
+// RunVerdict runs the script against the given object, and returns its
+// result along with the grade the script gave the object.
+//
+// Scripts grade objects via the `score` and `severity` functions, for
+// example:
+//
+//	if ( Failures > 10 ) {
+//	    score( Failures / 10 );
+//	    severity( "high" );
+//	}
+//	return Failures > 0;
+//
+// The values given to `score` are added together, and the highest of the
+// severities "informational", "low", "medium", "high", and "critical" is
+// kept.  As with Run you must invoke Prepare before RunVerdict.
+func (e *Eval) RunVerdict(obj interface{}) (*Verdict, error) {
+
+	v, err := e.verdict(obj, "")
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// verdict runs the script against the given object, as the named rule,
+// and returns its verdict.
+func (e *Eval) verdict(obj interface{}, rule string) (Verdict, error) {
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	out, err := e.Execute(obj)
+	if e.tracer != nil {
+		e.tracer.Rule = rule
+	}
+	if err != nil {
+		return Verdict{}, err
+	}
+	return Verdict{Rule: rule, Match: e.machine.True(out), Score: e.machine.Score(), Severity: e.machine.Severity()}, nil
+}
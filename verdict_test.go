@@ -0,0 +1,154 @@
+package evalfilter
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/skx/evalfilter/v2/vm"
+)
+
+// TestRunVerdict tests the grading of objects via `score` and `severity`.
+func TestRunVerdict(t *testing.T) {
+
+	tests := []struct {
+		script   string
+		match    bool
+		score    float64
+		severity vm.Severity
+	}{
+		{`return Failures > 0;`, true, 0, vm.SeverityNone},
+		{`score( 3 ); score( 1.5 ); return true;`, true, 4.5, vm.SeverityNone},
+		{`severity( "high" ); severity( "low" ); return true;`, true, 0, vm.SeverityHigh},
+		{`severity( "INFO" ); return false;`, false, 0, vm.SeverityInformational},
+		{`foreach i in 1..3 { score( i ); } return true;`, true, 6, vm.SeverityNone},
+		{`if ( Failures > 10 ) { score( Failures / 10 ); severity( "critical" ); } return Failures > 0;`, true, 2, vm.SeverityCritical},
+		{`function grade( n ) { score( n * 2 ); } grade( 2 ); return true;`, true, 4, vm.SeverityNone},
+
+		// The functions of the script take precedence.
+		{`function score( n ) { return n; } return score( 5 ) == 5;`, true, 0, vm.SeverityNone},
+	}
+
+	for _, test := range tests {
+		eval := New(test.script)
+		err := eval.Prepare()
+		if err != nil {
+			t.Fatalf("failed to compile %s: %s", test.script, err)
+		}
+
+		// Each run is graded afresh.
+		for i := 0; i < 2; i++ {
+			v, err := eval.RunVerdict(map[string]interface{}{"Failures": 20})
+			if err != nil {
+				t.Fatalf("failed to run %s: %s", test.script, err)
+			}
+			if v.Match != test.match || v.Score != test.score || v.Severity != test.severity {
+				t.Fatalf("unexpected verdict for %s: %+v", test.script, v)
+			}
+		}
+	}
+
+	// Invalid grades are errors.
+	errors := []struct {
+		script string
+		err    string
+	}{
+		{`score( "x" ); return true;`, "must be a number"},
+		{`score(); return true;`, "requires a single argument"},
+		{`severity( 3 ); return true;`, "must be a string"},
+		{`severity( "bogus" ); return true;`, "unknown severity 'bogus'"},
+	}
+	for _, test := range errors {
+		eval := New(test.script)
+		err := eval.Prepare()
+		if err != nil {
+			t.Fatalf("failed to compile %s: %s", test.script, err)
+		}
+		_, err = eval.RunVerdict(nil)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Fatalf("expected an error containing '%s' for %s, got %v", test.err, test.script, err)
+		}
+	}
+}
+
+// TestRuleSetVerdicts tests combining the verdicts of several rules.
+func TestRuleSetVerdicts(t *testing.T) {
+
+	scripts := []struct {
+		name   string
+		script string
+	}{
+		{"failures", `score( Failures ); severity( "medium" ); return Failures > 0;`},
+		{"admin", `score( 10 ); return User == "admin";`},
+		{"unmatched", `score( 100 ); severity( "critical" ); return false;`},
+	}
+
+	set := NewRuleSet()
+	for _, s := range scripts {
+		eval := New(s.script)
+		err := eval.Prepare()
+		if err != nil {
+			t.Fatalf("failed to compile %s: %s", s.name, err)
+		}
+		err = set.AddRule(s.name, eval)
+		if err != nil {
+			t.Fatalf("failed to add %s: %s", s.name, err)
+		}
+	}
+
+	err := set.SetWeight("admin", 0.5)
+	if err != nil {
+		t.Fatalf("failed to set weight: %s", err)
+	}
+	err = set.SetWeight("missing", 2)
+	if err == nil {
+		t.Fatalf("expected an error weighting a missing rule")
+	}
+
+	tests := []struct {
+		aggregation Aggregation
+		score       float64
+	}{
+		{AggregateMax, 10},
+		{AggregateSum, 14},
+		{AggregateWeighted, 9},
+	}
+
+	obj := map[string]interface{}{"Failures": 4, "User": "admin"}
+	for _, test := range tests {
+		set.SetAggregation(test.aggregation)
+		res, err := set.Run(obj)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		// Rules which didn't match are ignored.
+		if res.Score != test.score || res.Severity != vm.SeverityMedium || len(res.Verdicts) != 2 {
+			t.Fatalf("unexpected result for aggregation %d: %+v", test.aggregation, res)
+		}
+		if res.Verdicts["failures"].Score != 4 || res.Verdicts["admin"].Severity != vm.SeverityNone {
+			t.Fatalf("unexpected verdicts: %+v", res.Verdicts)
+		}
+	}
+
+	// A rule's severity applies to each of its matches.
+	err = set.SetSeverity("admin", vm.SeverityHigh)
+	if err != nil {
+		t.Fatalf("failed to set severity: %s", err)
+	}
+	res, err := set.Run(obj)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if res.Severity != vm.SeverityHigh || res.Verdicts["admin"].Severity != vm.SeverityHigh {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+
+	// Nothing matching gives no grade.
+	res, err = set.Run(map[string]interface{}{"Failures": 0, "User": "bob"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(res.Matched) != 0 || res.Score != 0 || res.Severity != vm.SeverityNone {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+}
@@ -0,0 +1,78 @@
+// This file contains the code which allows a script to be executed both
+// with and without the bytecode optimizer, to ensure that the optimizer
+// hasn't changed the behaviour of the script.
+
+package evalfilter
+
+import (
+	"fmt"
+
+	"github.com/skx/evalfilter/v2/object"
+)
+
+// VerifyOptimizer compiles and runs the script against the given object
+// twice, once with the bytecode optimizer enabled and once without, and
+// returns an error if the results differ.
+//
+// It is used in place of Prepare and Execute, primarily when testing, to
+// catch bugs in the optimizer.  Each run takes place in its own copy of
+// the environment, so that functions and variables you've set are visible
+// to both runs, but changes the script makes are seen by neither the
+// other run, nor the caller.  Host functions will be called twice.
+//
+// If both runs succeed with the same result then that result is returned,
+// if both fail then the error from the optimized run is returned.
+func (e *Eval) VerifyOptimizer(obj interface{}) (object.Object, error) {
+
+	var out [2]object.Object
+	var errs [2]error
+
+	for i, flags := range [][]byte{nil, {NoOptimize}} {
+
+		tmp := New(e.Script)
+		tmp.environment = e.environment.Clone()
+		tmp.context = e.context
+		tmp.memory = e.memory
+		tmp.iterations = e.iterations
+		tmp.stringerFields = e.stringerFields
+		tmp.fieldTag = e.fieldTag
+		tmp.language = e.language
+		tmp.schema = e.schema
+		tmp.sql = e.sql
+
+		err := tmp.Prepare(flags)
+		if err != nil {
+			return object.Nil, err
+		}
+
+		out[i], errs[i] = tmp.Execute(obj)
+	}
+
+	//
+	// Both failed?  That's consistent.
+	//
+	// We don't compare the errors, as they might contain bytecode
+	// offsets, which the optimizer will have changed.
+	//
+	if errs[0] != nil && errs[1] != nil {
+		return object.Nil, errs[0]
+	}
+
+	if errs[0] != nil {
+		return object.Nil, fmt.Errorf("the optimizer changed the result: optimized run failed with '%s', unoptimized run returned %s", errs[0], describe(out[1]))
+	}
+	if errs[1] != nil {
+		return object.Nil, fmt.Errorf("the optimizer changed the result: optimized run returned %s, unoptimized run failed with '%s'", describe(out[0]), errs[1])
+	}
+
+	if out[0].Type() != out[1].Type() || out[0].Inspect() != out[1].Inspect() {
+		return object.Nil, fmt.Errorf("the optimizer changed the result: optimized run returned %s, unoptimized run returned %s", describe(out[0]), describe(out[1]))
+	}
+
+	return out[0], nil
+}
+
+// describe returns a description of an object, for use in error messages.
+func describe(obj object.Object) string {
+	return fmt.Sprintf("%s '%s'", obj.Type(), obj.Inspect())
+}
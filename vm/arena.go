@@ -0,0 +1,198 @@
+// This file contains the arena, from which the integers, floats, and
+// strings that are created as temporaries during a run are allocated.
+//
+// Evaluating an expression such as `Count * 2 + 1 > Limit` creates a new
+// object for each intermediate result, which are discarded as soon as
+// the comparison has been made.  Rather than allocating each of them
+// individually we hand out the members of slices, and once the run is
+// over the slices are reused by the next one.
+//
+// Objects may only be reused if nothing refers to them after the run.
+// So if a run stores a value somewhere which outlives it, for example
+// in a variable or an array, or passes values to a function which might
+// keep them, then the objects it was given are left alone and only the
+// members which haven't been handed out are reused.  (Booleans don't
+// need the arena, as we use the True and False singletons for them, and
+// nor do small integers, which are interned.)
+
+package vm
+
+import (
+	"github.com/skx/evalfilter/v2/object"
+)
+
+// arenaChunk is the number of objects of each type allocated at once.
+const arenaChunk = 64
+
+// arena holds the objects we hand out during a run.
+type arena struct {
+
+	// disabled is true if objects should be allocated individually.
+	disabled bool
+
+	// escaped is true if the objects handed out during this run
+	// might be referred to after it.
+	escaped bool
+
+	// integers holds the chunks of integers, and intChunk and
+	// intOffset the position of the next one we'll hand out.
+	integers  [][]object.Integer
+	intChunk  int
+	intOffset int
+
+	// floats holds the chunks of floats, and fltChunk and fltOffset
+	// the position of the next one we'll hand out.
+	floats    [][]object.Float
+	fltChunk  int
+	fltOffset int
+
+	// strings holds the chunks of strings, and strChunk and strOffset
+	// the position of the next one we'll hand out.
+	strings   [][]object.String
+	strChunk  int
+	strOffset int
+}
+
+// SetArena controls whether the temporary objects created during a run
+// are allocated from an arena, and reused by later runs, which is the
+// default.
+//
+// Disabling the arena allocates each object individually, which may be
+// useful when debugging.
+func (vm *VM) SetArena(enable bool) {
+	vm.arena.disabled = !enable
+}
+
+// integer returns an integer with the given value.
+//
+// Small integers are shared, so they needn't come from the arena.
+func (a *arena) integer(val int64) *object.Integer {
+
+	if a.disabled || object.Interned(val) {
+		return object.Int(val)
+	}
+
+	if a.intChunk == len(a.integers) {
+		a.integers = append(a.integers, make([]object.Integer, arenaChunk))
+	}
+	chunk := a.integers[a.intChunk]
+	obj := &chunk[a.intOffset]
+	a.intOffset++
+	if a.intOffset == len(chunk) {
+		a.intChunk++
+		a.intOffset = 0
+	}
+
+	obj.Value = val
+	return obj
+}
+
+// float returns a float with the given value.
+func (a *arena) float(val float64) *object.Float {
+
+	if a.disabled {
+		return &object.Float{Value: val}
+	}
+
+	if a.fltChunk == len(a.floats) {
+		a.floats = append(a.floats, make([]object.Float, arenaChunk))
+	}
+	chunk := a.floats[a.fltChunk]
+	obj := &chunk[a.fltOffset]
+	a.fltOffset++
+	if a.fltOffset == len(chunk) {
+		a.fltChunk++
+		a.fltOffset = 0
+	}
+
+	obj.Value = val
+	return obj
+}
+
+// string returns a string with the given value.
+func (a *arena) string(val string) *object.String {
+
+	if a.disabled {
+		return &object.String{Value: val}
+	}
+
+	if a.strChunk == len(a.strings) {
+		a.strings = append(a.strings, make([]object.String, arenaChunk))
+	}
+	chunk := a.strings[a.strChunk]
+	obj := &chunk[a.strOffset]
+	a.strOffset++
+	if a.strOffset == len(chunk) {
+		a.strChunk++
+		a.strOffset = 0
+	}
+
+	obj.Value = val
+	return obj
+}
+
+// escape records that the objects handed out during this run might be
+// referred to after it.
+func (a *arena) escape() {
+	a.escaped = true
+}
+
+// keep returns the given result of a run, copying it if it might have
+// been allocated from the arena, so that the caller may keep it.
+func (a *arena) keep(obj object.Object) object.Object {
+
+	if a.disabled {
+		return obj
+	}
+
+	switch o := obj.(type) {
+	case *object.Integer:
+		return object.Int(o.Value)
+	case *object.Float:
+		return &object.Float{Value: o.Value}
+	case *object.String:
+		return &object.String{Value: o.Value}
+	}
+	return obj
+}
+
+// release is called at the end of each run, and makes the objects we've
+// handed out available to the next one, if that's safe.
+func (a *arena) release() {
+
+	if a.escaped {
+
+		// Discard the objects we handed out, keeping only those
+		// we have yet to.
+		a.integers = append([][]object.Integer(nil), a.integers[a.intChunk:]...)
+		if len(a.integers) > 0 {
+			a.integers[0] = a.integers[0][a.intOffset:]
+		}
+		a.floats = append([][]object.Float(nil), a.floats[a.fltChunk:]...)
+		if len(a.floats) > 0 {
+			a.floats[0] = a.floats[0][a.fltOffset:]
+		}
+		a.strings = append([][]object.String(nil), a.strings[a.strChunk:]...)
+		if len(a.strings) > 0 {
+			a.strings[0] = a.strings[0][a.strOffset:]
+		}
+	} else {
+
+		// Don't keep large strings alive until the objects
+		// which held them are reused.
+		for c := 0; c <= a.strChunk && c < len(a.strings); c++ {
+			end := len(a.strings[c])
+			if c == a.strChunk {
+				end = a.strOffset
+			}
+			for i := 0; i < end; i++ {
+				a.strings[c][i].Value = ""
+			}
+		}
+	}
+
+	a.intChunk, a.intOffset = 0, 0
+	a.fltChunk, a.fltOffset = 0, 0
+	a.strChunk, a.strOffset = 0, 0
+	a.escaped = false
+}
@@ -0,0 +1,66 @@
+// This file contains the limit upon the number of instructions a run may
+// execute.
+//
+// A context with a deadline stops a script which runs for too long, but
+// how much a script may do before its deadline depends upon the host it
+// runs upon, and how busy that host is.  Limiting the number of
+// instructions instead gives each script the same budget wherever it
+// runs, which is useful when the scripts are supplied by customers.
+
+package vm
+
+import (
+	"fmt"
+)
+
+// InstructionLimitError is the error which aborts a run that executed
+// more instructions than its limit allowed.
+type InstructionLimitError struct {
+
+	// Limit holds the number of instructions which were allowed.
+	Limit int
+}
+
+// Error returns the error as a string.
+func (e *InstructionLimitError) Error() string {
+	return fmt.Sprintf("the limit of %d instructions was exceeded", e.Limit)
+}
+
+// SetMaxInstructions sets the number of instructions which may be
+// executed during each run, including those of the functions which are
+// called.  A limit of zero, the default, disables the check.
+func (vm *VM) SetMaxInstructions(limit int) {
+	vm.maxInstructions = limit
+}
+
+// Executed returns the number of instructions executed during the most
+// recent run.  The instructions run upon the machine are only counted
+// when there's a limit upon them, whilst a predicate is charged for each
+// of its instructions.
+func (vm *VM) Executed() int {
+	return vm.executed
+}
+
+// spend records that an instruction is about to be executed, and returns
+// an error if that would exceed our limit.
+func (vm *VM) spend() error {
+
+	if vm.maxInstructions <= 0 {
+		return nil
+	}
+
+	vm.executed++
+	if vm.executed > vm.maxInstructions {
+		return &InstructionLimitError{Limit: vm.maxInstructions}
+	}
+	return nil
+}
+
+// predicateWithinBudget returns true if our predicate may be evaluated
+// within our instruction limit.
+//
+// A predicate executes each of its instructions once, so if there are
+// too many we leave the machine to report the error.
+func (vm *VM) predicateWithinBudget() bool {
+	return vm.maxInstructions <= 0 || len(vm.predicate.ops) <= vm.maxInstructions
+}
@@ -0,0 +1,39 @@
+// This file contains the handling of bytes, which scripts see as our
+// object.Bytes rather than as an array of integers.
+//
+// Bytes are used with strings, and with each other, as the strings which
+// hold the same bytes - so a byte-slice may be compared with a string, or
+// matched against a regular expression, such as:
+//
+//	return Body ~= /^%PDF-/;
+
+package vm
+
+import (
+	"fmt"
+
+	"github.com/skx/evalfilter/v2/code"
+	"github.com/skx/evalfilter/v2/object"
+)
+
+// evalBytesInfixExpression applies the given operator to operands, at
+// least one of which is bytes.
+func (vm *VM) evalBytesInfixExpression(op code.Opcode, left, right object.Object) error {
+
+	l, r := left, right
+	if b, ok := left.(*object.Bytes); ok {
+		l = &object.String{Value: string(b.Value)}
+	}
+	if b, ok := right.(*object.Bytes); ok {
+		r = &object.String{Value: string(b.Value)}
+	}
+
+	switch {
+	case l.Type() == object.STRING && r.Type() == object.STRING:
+		return vm.evalStringInfixExpression(op, l, r)
+	case l.Type() == object.STRING && r.Type() == object.REGEXP:
+		return vm.evalStringRegexpExpression(op, l, r)
+	}
+	return fmt.Errorf("type mismatch: %s %s %s",
+		left.Type(), code.String(op), right.Type())
+}
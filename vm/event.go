@@ -0,0 +1,404 @@
+// This file contains the handling of the event modes, which control
+// whether a script may modify the object it is run against.
+//
+// By default assigning to the name of a field creates a variable which
+// hides it, and the members of hashes and arrays are only changed in our
+// own copies of them.  Hosts may instead declare the object read-only,
+// so that any attempt to modify it is an error, or read-write, so that
+// the modifications are applied to the object itself.
+
+package vm
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/skx/evalfilter/v2/object"
+)
+
+// EventMode controls whether a script may modify the object it is run
+// against.
+type EventMode int
+
+const (
+	// EventShadow is the default mode.  Assigning to the name of a
+	// field creates a variable which hides the field, and the object
+	// itself is never modified.
+	EventShadow EventMode = iota
+
+	// EventReadOnly makes any attempt to assign to a field, or to set
+	// a member of a hash or array within the object, a runtime error.
+	EventReadOnly
+
+	// EventReadWrite applies assignments to fields, and to the members
+	// of the hashes and arrays within the object, to the object itself.
+	//
+	// Structures must be passed by pointer for their fields to be
+	// modified.
+	EventReadWrite
+)
+
+// String returns the name of the mode.
+func (m EventMode) String() string {
+	switch m {
+	case EventShadow:
+		return "shadow"
+	case EventReadOnly:
+		return "read-only"
+	case EventReadWrite:
+		return "read-write"
+	}
+	return fmt.Sprintf("EventMode(%d)", int(m))
+}
+
+// SetEventMode sets the mode which controls whether scripts may modify
+// the object they're run against.
+func (vm *VM) SetEventMode(mode EventMode) {
+	vm.mode = mode
+}
+
+// setVariable implements assignment to the given name.
+//
+// If the name refers to a field of the object, rather than a variable,
+// then the assignment is rejected or applied to the object, according
+// to our mode.  Otherwise the variable is set.
+func (vm *VM) setVariable(obj interface{}, name string, val object.Object) error {
+
+	// The value outlives the expression which created it.
+	vm.arena.escape()
+
+	if vm.mode == EventShadow || !vm.isField(obj, name) {
+		vm.environment.Set(name, val)
+		return nil
+	}
+
+	if vm.mode == EventReadOnly {
+		return fmt.Errorf("the field %s cannot be modified, the object is read-only", name)
+	}
+
+	err := vm.setField(obj, name, val)
+	if err != nil {
+		return err
+	}
+
+	// Later lookups should see the new value.
+	vm.fields[name] = val
+	return nil
+}
+
+// isField returns true if the given name refers to a field of the
+// object, rather than to a variable.
+func (vm *VM) isField(obj interface{}, name string) bool {
+
+	if _, ok := vm.environment.Get(name); ok {
+		return false
+	}
+
+	if _, ok := vm.fields[name]; ok {
+		return true
+	}
+
+	_, ok := vm.field(obj, name)
+	return ok
+}
+
+// setField stores the given value in the named field of the object,
+// which is either a map or a pointer to a structure.
+func (vm *VM) setField(obj interface{}, name string, val object.Object) error {
+
+	if _, ok := obj.(FieldProvider); ok {
+		return fmt.Errorf("the field %s cannot be modified, it was supplied by a FieldProvider", name)
+	}
+
+	target, _ := indirect(reflect.ValueOf(obj))
+
+	if target.Kind() == reflect.Map {
+		key, ok := mapKey(target.Type().Key(), name)
+		if !ok {
+			return fmt.Errorf("the field %s cannot be modified, the map has %s keys", name, target.Type().Key())
+		}
+
+		v, err := goValue(val, target.Type().Elem())
+		if err != nil {
+			return fmt.Errorf("the field %s cannot be modified, %s", name, err)
+		}
+		target.SetMapIndex(key, v)
+		return nil
+	}
+
+	field := fieldByName(target, vm.fieldTag, name)
+	if !field.CanSet() {
+		return fmt.Errorf("the field %s cannot be modified, the object must be passed by pointer", name)
+	}
+
+	v, err := goValue(val, field.Type())
+	if err != nil {
+		return fmt.Errorf("the field %s cannot be modified, %s", name, err)
+	}
+	field.Set(v)
+	return nil
+}
+
+// setMember is invoked before a member of a hash, or array, is set.
+//
+// If the collection was converted from the object then the change is
+// rejected or applied to the object, according to our mode.
+func (vm *VM) setMember(left, index, value object.Object) error {
+
+	src, ok := vm.origins[left]
+	if !ok {
+		return nil
+	}
+
+	if vm.mode == EventReadOnly {
+		return fmt.Errorf("the members of this %s cannot be modified, the object is read-only", left.Type())
+	}
+
+	switch src.Kind() {
+
+	case reflect.Map:
+		if src.IsNil() {
+			return fmt.Errorf("members cannot be added to a nil map")
+		}
+		k, err := goValue(index, src.Type().Key())
+		if err != nil {
+			return fmt.Errorf("the member %s cannot be modified, %s", index.Inspect(), err)
+		}
+		v, err := goValue(value, src.Type().Elem())
+		if err != nil {
+			return fmt.Errorf("the member %s cannot be modified, %s", index.Inspect(), err)
+		}
+		src.SetMapIndex(k, v)
+
+	case reflect.Slice, reflect.Array:
+		i, ok := index.(*object.Integer)
+		if !ok || i.Value < 0 || i.Value >= int64(src.Len()) {
+			// Let executeSetIndex report the problem.
+			return nil
+		}
+		member := src.Index(int(i.Value))
+		if !member.CanSet() {
+			return fmt.Errorf("the member %d cannot be modified, the array is not addressable", i.Value)
+		}
+		v, err := goValue(value, member.Type())
+		if err != nil {
+			return fmt.Errorf("the member %d cannot be modified, %s", i.Value, err)
+		}
+		member.Set(v)
+
+	case reflect.Struct:
+		field := fieldByName(src, vm.fieldTag, index.Inspect())
+		if !field.IsValid() {
+			return fmt.Errorf("the member %s cannot be added, the structure has no such field", index.Inspect())
+		}
+		if !field.CanSet() {
+			return fmt.Errorf("the member %s cannot be modified, the structure must be referred to by pointer", index.Inspect())
+		}
+		v, err := goValue(value, field.Type())
+		if err != nil {
+			return fmt.Errorf("the member %s cannot be modified, %s", index.Inspect(), err)
+		}
+		field.Set(v)
+	}
+
+	return nil
+}
+
+// fieldByName returns the named field of the given structure, which may
+// have been promoted from an embedded structure, finding the same field
+// which scripts see.
+func fieldByName(val reflect.Value, tag string, name string) reflect.Value {
+
+	var out reflect.Value
+	if val.Kind() != reflect.Struct {
+		return out
+	}
+
+	info := structFields(val.Type(), tag)
+	if !info.dynamic {
+		return info.lookup(val, name)
+	}
+
+	walkValues(val, tag, func(n string, field reflect.Value) {
+		if n == name && !out.IsValid() {
+			out = field
+		}
+	})
+	return out
+}
+
+// goValue converts one of our objects to a value of the given type, so
+// that it may be stored within the object a script is run against.
+//
+// This is the reverse of primitiveToObject, so times are expected to be
+// given as times, or seconds past the epoch, and durations as seconds.
+func goValue(obj object.Object, t reflect.Type) (reflect.Value, error) {
+
+	out := reflect.New(t).Elem()
+
+	if t == timeType {
+		switch v := obj.(type) {
+		case *object.Time:
+			out.Set(reflect.ValueOf(v.Value))
+			return out, nil
+		case *object.Integer:
+			out.Set(reflect.ValueOf(time.Unix(v.Value, 0)))
+			return out, nil
+		}
+		return out, fmt.Errorf("%s cannot be stored as a time", obj.Type())
+	}
+
+	if t == durationType {
+		switch n := obj.(type) {
+		case *object.Integer:
+			out.SetInt(int64(time.Duration(n.Value) * time.Second))
+			return out, nil
+		case *object.Float:
+			out.SetInt(int64(n.Value * float64(time.Second)))
+			return out, nil
+		}
+		return out, fmt.Errorf("%s cannot be stored as a duration", obj.Type())
+	}
+
+	if t == rawMessageType {
+		data, err := json.Marshal(interfaceValue(obj))
+		if err != nil {
+			return out, fmt.Errorf("%s cannot be stored as JSON", obj.Type())
+		}
+		out.SetBytes(data)
+		return out, nil
+	}
+
+	if t == jsonNumberType {
+		if s, ok := numberText(obj); ok {
+			out.SetString(s)
+			return out, nil
+		}
+		return out, fmt.Errorf("%s cannot be stored as a number", obj.Type())
+	}
+
+	switch t.Kind() {
+
+	case reflect.Interface:
+		v := interfaceValue(obj)
+		if v == nil {
+			return out, nil
+		}
+		if !reflect.TypeOf(v).AssignableTo(t) {
+			return out, fmt.Errorf("%s cannot be stored as %s", obj.Type(), t)
+		}
+		out.Set(reflect.ValueOf(v))
+		return out, nil
+
+	case reflect.Bool:
+		if b, ok := obj.(*object.Boolean); ok {
+			out.SetBool(b.Value)
+			return out, nil
+		}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if i, ok := obj.(*object.Integer); ok {
+			if out.OverflowInt(i.Value) {
+				return out, fmt.Errorf("%d overflows %s", i.Value, t)
+			}
+			out.SetInt(i.Value)
+			return out, nil
+		}
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if i, ok := obj.(*object.Integer); ok {
+			if i.Value < 0 || out.OverflowUint(uint64(i.Value)) {
+				return out, fmt.Errorf("%d overflows %s", i.Value, t)
+			}
+			out.SetUint(uint64(i.Value))
+			return out, nil
+		}
+
+	case reflect.Float32, reflect.Float64:
+		switch n := obj.(type) {
+		case *object.Float:
+			out.SetFloat(n.Value)
+			return out, nil
+		case *object.Integer:
+			out.SetFloat(float64(n.Value))
+			return out, nil
+		}
+
+	case reflect.String:
+		if s, ok := obj.(*object.String); ok {
+			out.SetString(s.Value)
+			return out, nil
+		}
+
+	case reflect.Slice:
+		if b, ok := obj.(*object.Bytes); ok && t.Elem().Kind() == reflect.Uint8 {
+			out.Set(reflect.MakeSlice(t, len(b.Value), len(b.Value)))
+			reflect.Copy(out, reflect.ValueOf(b.Value))
+			return out, nil
+		}
+		if a, ok := obj.(*object.Array); ok {
+			out.Set(reflect.MakeSlice(t, len(a.Elements), len(a.Elements)))
+			for i, el := range a.Elements {
+				v, err := goValue(el, t.Elem())
+				if err != nil {
+					return out, err
+				}
+				out.Index(i).Set(v)
+			}
+			return out, nil
+		}
+
+	case reflect.Map:
+		if h, ok := obj.(*object.Hash); ok {
+			out.Set(reflect.MakeMapWithSize(t, len(h.Pairs)))
+			for _, pair := range h.Pairs {
+				k, err := goValue(pair.Key, t.Key())
+				if err != nil {
+					return out, err
+				}
+				v, err := goValue(pair.Value, t.Elem())
+				if err != nil {
+					return out, err
+				}
+				out.SetMapIndex(k, v)
+			}
+			return out, nil
+		}
+	}
+
+	// Null clears maps, slices, and pointers.
+	if obj.Type() == object.NULL {
+		switch t.Kind() {
+		case reflect.Map, reflect.Slice, reflect.Ptr:
+			return out, nil
+		}
+	}
+
+	return out, fmt.Errorf("%s cannot be stored as %s", obj.Type(), t)
+}
+
+// interfaceValue converts one of our objects to the natural go value,
+// for storage in an interface{}.
+func interfaceValue(obj object.Object) interface{} {
+
+	switch o := obj.(type) {
+	case *object.Null, *object.Void:
+		return nil
+	case *object.Array:
+		out := make([]interface{}, len(o.Elements))
+		for i, el := range o.Elements {
+			out[i] = interfaceValue(el)
+		}
+		return out
+	case *object.Hash:
+		out := make(map[string]interface{}, len(o.Pairs))
+		for _, pair := range o.Pairs {
+			out[pair.Key.Inspect()] = interfaceValue(pair.Value)
+		}
+		return out
+	}
+
+	return obj.ToInterface()
+}
@@ -0,0 +1,54 @@
+// This file contains the observations which are recorded when a run is
+// explained.
+//
+// A script which is compiled to be explained contains an OpExplain
+// instruction after each expression of interest, which records the value
+// the expression resulted in.  The caller knows what each expression
+// was, so it can then describe how the script reached its result.
+
+package vm
+
+import (
+	"github.com/skx/evalfilter/v2/object"
+)
+
+// Observation records the value of an expression, during a run.
+type Observation struct {
+
+	// ID holds the identifier of the expression.
+	ID int
+
+	// Type holds the type of the value.
+	Type object.Type
+
+	// Value holds the value.
+	Value string
+
+	// True is true if the value was true.
+	True bool
+}
+
+// Observations returns the values of the expressions which were recorded
+// during the most recent run.
+func (vm *VM) Observations() []Observation {
+	return vm.observations
+}
+
+// observe records the value at the top of the stack, as the value of the
+// expression with the given identifier.
+func (vm *VM) observe(id int) error {
+
+	val, err := vm.stack.Pop()
+	if err != nil {
+		return err
+	}
+	vm.stack.Push(val)
+
+	vm.observations = append(vm.observations, Observation{
+		ID:    id,
+		Type:  val.Type(),
+		Value: val.Inspect(),
+		True:  vm.True(val),
+	})
+	return nil
+}
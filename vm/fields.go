@@ -0,0 +1,187 @@
+// This file contains the cache of the fields of the structures scripts
+// are run against.
+//
+// Finding a field by name means walking the fields of the structure, and
+// those of any structures embedded within it, which is wasteful when the
+// same type is seen with every run.  Instead the fields of each type are
+// found once, and remembered, so that looking up a field is only a map
+// lookup followed by indexing the structure.
+
+package vm
+
+import (
+	"reflect"
+	"sync"
+)
+
+// structField describes a single field of a structure, which might have
+// been promoted from an embedded structure.
+type structField struct {
+
+	// name is the name scripts use for the field.
+	name string
+
+	// index holds the index of the field, and of each embedded
+	// structure which leads to it, as reflect.Value.FieldByIndex
+	// expects.
+	index []int
+}
+
+// structInfo describes the fields of a structure.
+type structInfo struct {
+
+	// fields holds the fields of the structure, in the order walkStruct
+	// visits them, including those which are hidden by others of the
+	// same name.
+	fields []structField
+
+	// names holds the position of each field within fields, by name.
+	names map[string][]int
+
+	// dynamic is true if the fields depend upon the value of the
+	// structure, not just its type, as it embeds an interface, so
+	// they can't be remembered.
+	dynamic bool
+}
+
+// structCache holds the fields of the structures we've seen, for a single
+// struct tag.
+type structCache struct {
+	types sync.Map
+}
+
+var (
+	// untagged holds the fields of structures, named as they are in go.
+	untagged = &structCache{}
+
+	// tagged holds the fields of structures by the tag naming them.
+	tagged      = make(map[string]*structCache)
+	taggedMutex sync.RWMutex
+)
+
+// structFields returns the fields of the given type of structure, with
+// the names the given tag gives them.
+func structFields(t reflect.Type, tag string) *structInfo {
+
+	cache := untagged
+	if tag != "" {
+		taggedMutex.RLock()
+		cache = tagged[tag]
+		taggedMutex.RUnlock()
+
+		if cache == nil {
+			taggedMutex.Lock()
+			if cache = tagged[tag]; cache == nil {
+				cache = &structCache{}
+				tagged[tag] = cache
+			}
+			taggedMutex.Unlock()
+		}
+	}
+
+	if info, ok := cache.types.Load(t); ok {
+		return info.(*structInfo)
+	}
+	info, _ := cache.types.LoadOrStore(t, newStructInfo(t, tag))
+	return info.(*structInfo)
+}
+
+// newStructInfo finds the fields of the given type of structure.
+func newStructInfo(t reflect.Type, tag string) *structInfo {
+
+	info := &structInfo{names: make(map[string][]int)}
+
+	type pending struct {
+		t     reflect.Type
+		index []int
+
+		// outer holds the types which embed this one, as a type
+		// which embeds a pointer to itself has no end.
+		outer []reflect.Type
+	}
+
+	// The structures to visit, starting with the outermost.
+	queue := []pending{{t: t}}
+
+	for len(queue) > 0 {
+
+		cur := queue[0]
+		queue = queue[1:]
+
+		for i := 0; i < cur.t.NumField(); i++ {
+
+			typeField := cur.t.Field(i)
+			name, named := tagName(typeField, tag)
+			if name == "" {
+				continue
+			}
+
+			index := make([]int, len(cur.index)+1)
+			copy(index, cur.index)
+			index[len(cur.index)] = i
+
+			info.names[name] = append(info.names[name], len(info.fields))
+			info.fields = append(info.fields, structField{name: name, index: index})
+
+			if !typeField.Anonymous || named {
+				continue
+			}
+
+			inner := typeField.Type
+			for inner.Kind() == reflect.Ptr {
+				inner = inner.Elem()
+			}
+			if inner.Kind() == reflect.Interface {
+				info.dynamic = true
+				continue
+			}
+			if inner.Kind() != reflect.Struct || inner == timeType {
+				continue
+			}
+
+			outer := append(append([]reflect.Type{}, cur.outer...), cur.t)
+			for _, o := range outer {
+				if o == inner {
+					info.dynamic = true
+				}
+			}
+			if !info.dynamic {
+				queue = append(queue, pending{t: inner, index: index, outer: outer})
+			}
+		}
+	}
+	return info
+}
+
+// value returns the value of the field within the given structure, or
+// false if it was promoted from an embedded structure which is nil.
+func (f *structField) value(val reflect.Value) (reflect.Value, bool) {
+
+	for n, i := range f.index {
+		if n > 0 {
+			inner, ok := indirect(val)
+			if !ok {
+				return inner, false
+			}
+			val = inner
+		}
+		val = val.Field(i)
+	}
+	return val, true
+}
+
+// lookup returns the named field of the given structure, which is of the
+// type the fields were found for, or the invalid value if it has none.
+//
+// The first of the fields of that name which may be reached is the one
+// walkStruct would find, as the others are hidden by it.
+func (info *structInfo) lookup(val reflect.Value, name string) reflect.Value {
+
+	for _, pos := range info.names[name] {
+		field := &info.fields[pos]
+		if v, ok := field.value(val); ok {
+			return v
+		}
+	}
+	return reflect.Value{}
+}
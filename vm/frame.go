@@ -0,0 +1,154 @@
+// This file contains the call-frames which are used to implement calls
+// to user-defined functions.
+//
+// Originally a call to a user-defined function would recursively invoke
+// `Run` against the bytecode of the function.  That meant deeply
+// recursive scripts could exhaust the stack of the host application,
+// and an error within a function gave no indication of where it came
+// from.  Now each call pushes a frame, which records the state of the
+// caller, and returning pops it again.
+
+package vm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/skx/evalfilter/v2/code"
+	"github.com/skx/evalfilter/v2/object"
+	"github.com/skx/evalfilter/v2/stack"
+)
+
+// maxFrames is the maximum depth of function-calls we allow, which
+// protects us against runaway recursion.
+const maxFrames = 10000
+
+// frame holds the state of a caller, while a function is executing.
+type frame struct {
+
+	// name holds the name of the function which was called.
+	name string
+
+	// bytecode holds the bytecode of the caller.
+	bytecode code.Instructions
+
+	// sources holds the positions of the instructions of the caller.
+	sources code.SourceMap
+
+	// ip holds the offset of the call-instruction within the
+	// bytecode of the caller.
+	ip int
+
+	// stack holds the stack of the caller.
+	stack *stack.Stack
+
+	// depth holds the scope-depth of the caller, which is restored
+	// when the function returns.
+	depth int
+}
+
+// pushFrame saves the state of the caller, as a function is invoked.
+func (vm *VM) pushFrame(name string, ip int) error {
+
+	if len(vm.frames) >= maxFrames {
+		return fmt.Errorf("maximum call-depth of %d exceeded, calling %s", maxFrames, name)
+	}
+
+	vm.frames = append(vm.frames, &frame{
+		name:     name,
+		bytecode: vm.bytecode,
+		sources:  vm.sources,
+		ip:       ip,
+		stack:    vm.stack,
+		depth:    vm.environment.ScopeDepth(),
+	})
+	return nil
+}
+
+// enterFunction calls a function defined by the script, with the given
+// arguments, from the instruction at the given offset.
+//
+// The state of the caller is saved, and the function is given its own
+// stack and a scope which holds its arguments, along with any variables
+// it captured.  The caller must then begin executing the bytecode of the
+// function from the start.
+func (vm *VM) enterFunction(name string, ip int, params []string, bytecode code.Instructions, sources code.SourceMap, captured map[string]object.Object, args []object.Object) error {
+
+	// Sanity-check we have enough arguments
+	if len(params) != len(args) {
+		return fmt.Errorf("mismatch in argument-counts for %s, expected %d but got %d", name, len(params), len(args))
+	}
+
+	// Save the state of the caller, so that we can
+	// resume when the function returns.
+	err := vm.pushFrame(name, ip)
+	if err != nil {
+		return err
+	}
+
+	// The function gets a new stack, and a new scope
+	// for its arguments.
+	vm.stack = stack.New()
+	vm.environment.AddScope()
+
+	// Now set the variables which were captured, and then the
+	// value of each argument.
+	vm.arena.escape()
+	for name, val := range captured {
+		vm.environment.Declare(name, val)
+	}
+	for i, name := range params {
+		vm.environment.Declare(name, args[i])
+	}
+
+	vm.bytecode = bytecode
+	vm.sources = sources
+	return nil
+}
+
+// popFrame restores the state of the caller, as a function returns,
+// and returns the offset at which the caller should resume.
+func (vm *VM) popFrame() int {
+
+	fr := vm.frames[len(vm.frames)-1]
+	vm.frames = vm.frames[:len(vm.frames)-1]
+
+	vm.bytecode = fr.bytecode
+	vm.sources = fr.sources
+	vm.stack = fr.stack
+
+	// Drop the scope which means function-arguments are dropped,
+	// along with any scopes left behind by returning from within
+	// a foreach loop.
+	vm.environment.DropScopes(fr.depth)
+
+	return fr.ip
+}
+
+// resetFrames discards any frames which are left behind when a script
+// terminates with an error in the middle of a function, restoring the
+// bytecode and stack of the main program.
+func (vm *VM) resetFrames() {
+	if len(vm.frames) > 0 {
+		vm.bytecode = vm.frames[0].bytecode
+		vm.sources = vm.frames[0].sources
+		vm.stack = vm.frames[0].stack
+		vm.frames = nil
+	}
+}
+
+// trace describes the active function-calls, innermost first, given
+// the offset within the currently executing bytecode.
+//
+// For example "bar:0004 <- foo:0012 <- main:0030".
+func (vm *VM) trace(ip int) string {
+
+	var out []string
+	for i := len(vm.frames) - 1; i >= 0; i-- {
+		out = append(out, fmt.Sprintf("%s:%04d", vm.frames[i].name, ip))
+		ip = vm.frames[i].ip
+	}
+	out = append(out, fmt.Sprintf("main:%04d", ip))
+
+	return strings.Join(out, " <- ")
+}
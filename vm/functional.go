@@ -0,0 +1,249 @@
+// This file contains the support for functions which are values, which
+// may be stored in variables and passed to other functions.
+//
+// Scripts may create anonymous functions, which capture the values of the
+// local variables they refer to when they're created:
+//
+//    function scale( items, factor ) {
+//        return map( items, function( x ) { return x * factor; } );
+//    }
+//
+// The names of functions defined by the script may also be used as values,
+// in which case they're looked up like any variable.  Hosts may give the
+// script functions of their own, by setting variables to functions which
+// have a Builtin implementation.
+//
+// Calling a function value is no different to calling a function by name,
+// so the call pushes a frame rather than recursing.  The higher-order
+// functions, such as `map`, which call the functions they're given, must
+// wait for each call to complete - so they run the machine recursively.
+
+package vm
+
+import (
+	"fmt"
+
+	"github.com/skx/evalfilter/v2/object"
+)
+
+// higherOrder returns the implementation of the named function, if it is
+// one which calls the function it is given.
+//
+// Host functions can't call functions defined by the script, so these are
+// implemented by the machine itself, and are used unless a function, or
+// variable, of the same name exists.
+func higherOrder(name string) (func(vm *VM, obj interface{}, ip int, args []object.Object) (object.Object, error), bool) {
+
+	switch name {
+	case "filter":
+		return fnFilter, true
+	case "map":
+		return fnMap, true
+	}
+	return nil, false
+}
+
+// callBuiltin calls a function defined by the host, or one of our in-built
+// functions, with the given arguments.
+func (vm *VM) callBuiltin(name string, fn func(args []object.Object) object.Object, args []object.Object) (object.Object, error) {
+
+	// Functions added by the host might keep
+	// their arguments.
+	if !vm.environment.IsBuiltin(name) {
+		vm.arena.escape()
+	}
+
+	var ret object.Object
+	if vm.recorder != nil {
+		var err error
+		ret, err = vm.recorder.call(name, fn, args)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		ret = fn(args)
+	}
+
+	// The function might have built a string,
+	// or array, which we must account for.
+	err := vm.allocate(sizeOf(ret))
+	if err != nil {
+		return nil, err
+	}
+
+	// The built-in functions which return arrays,
+	// or hashes, might have placed their arguments
+	// within them.
+	switch ret.(type) {
+	case *object.Array, *object.Hash:
+		vm.arena.escape()
+	}
+
+	return ret, nil
+}
+
+// closure creates a new function from the given anonymous function, which
+// captures the current values of the local variables it refers to.
+//
+// Global variables aren't captured, so the function sees their values at
+// the time it is called - as would any other function.
+func (vm *VM) closure(proto *object.Function) *object.Function {
+
+	fn := &object.Function{
+		Name:      proto.Name,
+		Arguments: proto.Arguments,
+		Bytecode:  proto.Bytecode,
+		Sources:   proto.Sources,
+		Free:      proto.Free,
+	}
+	for _, name := range proto.Free {
+		if val, ok := vm.environment.GetLocal(name); ok {
+			if fn.Captured == nil {
+				fn.Captured = make(map[string]object.Object)
+			}
+			fn.Captured[name] = val
+		}
+	}
+
+	// The values we've captured outlive their scope.
+	if fn.Captured != nil {
+		vm.arena.escape()
+	}
+	return fn
+}
+
+// lookupFunction returns the user-defined function with the given name,
+// as a value, if there is one.
+//
+// The names of host functions aren't values, as objects often have fields
+// with the same names, such as `type` or `time`, which may be missing.
+func (vm *VM) lookupFunction(name string) (*object.Function, bool) {
+
+	if fn, ok := vm.functions[name]; ok {
+		return &object.Function{Name: name, Arguments: fn.Arguments, Bytecode: fn.Bytecode, Sources: fn.Sources}, true
+	}
+	return nil, false
+}
+
+// isFunction returns true if there is a user-defined function with the
+// given name.
+func (vm *VM) isFunction(name string) bool {
+	_, ok := vm.functions[name]
+	return ok
+}
+
+// functionName returns the name of the given function, for reporting.
+func functionName(fn *object.Function) string {
+	if fn.Name == "" {
+		return "anonymous"
+	}
+	return fn.Name
+}
+
+// invoke calls the given function from the instruction at the given
+// offset, and returns the result once it has completed.
+func (vm *VM) invoke(obj interface{}, ip int, fn *object.Function, args []object.Object) (object.Object, error) {
+
+	if fn.Builtin != nil {
+		return vm.callBuiltin(fn.Name, fn.Builtin, args)
+	}
+
+	err := vm.enterFunction(functionName(fn), ip, fn.Arguments, fn.Bytecode, fn.Sources, fn.Captured, args)
+	if err != nil {
+		return nil, err
+	}
+
+	base := len(vm.frames)
+	ret, err := vm.execute(obj, base)
+
+	// Return to the caller, discarding any frames which were left
+	// behind by an error.
+	vm.frames = vm.frames[:base]
+	vm.popFrame()
+
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// callback returns the function given to one of our higher-order
+// functions, which is expected to take a single array and a function.
+func callback(name string, args []object.Object) (*object.Array, *object.Function, error) {
+
+	if len(args) != 2 {
+		return nil, nil, fmt.Errorf("%s requires two arguments, an array and a function", name)
+	}
+	arr, ok := args[0].(*object.Array)
+	if !ok {
+		return nil, nil, fmt.Errorf("the first argument to %s must be an array, not %s", name, args[0].Type())
+	}
+	fn, ok := args[1].(*object.Function)
+	if !ok {
+		return nil, nil, fmt.Errorf("the second argument to %s must be a function, not %s", name, args[1].Type())
+	}
+	return arr, fn, nil
+}
+
+// fnMap implements `map`, which returns an array of the results of
+// calling the given function with each member of the given array.
+func fnMap(vm *VM, obj interface{}, ip int, args []object.Object) (object.Object, error) {
+
+	arr, fn, err := callback("map", args)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]object.Object, 0, len(arr.Elements))
+	for _, el := range arr.Elements {
+		ret, err := vm.invoke(obj, ip, fn, []object.Object{el})
+		if err != nil {
+			return nil, err
+		}
+		if ret.Type() == object.VOID {
+			ret = Null
+		}
+		out = append(out, ret)
+	}
+
+	// The array holds the values we were given, or created.
+	vm.arena.escape()
+
+	res := &object.Array{Elements: out}
+	err = vm.allocate(sizeOf(res))
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// fnFilter implements `filter`, which returns an array of the members
+// of the given array for which the given function returns true.
+func fnFilter(vm *VM, obj interface{}, ip int, args []object.Object) (object.Object, error) {
+
+	arr, fn, err := callback("filter", args)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]object.Object, 0)
+	for _, el := range arr.Elements {
+		ret, err := vm.invoke(obj, ip, fn, []object.Object{el})
+		if err != nil {
+			return nil, err
+		}
+		if vm.True(ret) {
+			out = append(out, el)
+		}
+	}
+
+	// The array holds the values we were given, or created.
+	vm.arena.escape()
+
+	res := &object.Array{Elements: out}
+	err = vm.allocate(sizeOf(res))
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
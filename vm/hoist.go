@@ -0,0 +1,247 @@
+// This file contains the optimization which hoists lookups out of loops.
+//
+// A loop which tests each member of an array against a field, such as
+// `foreach item in Items { if ( item == Name ) { ... } }`, looks up the
+// same field upon each iteration.  If nothing within the loop can change
+// the value of the field, or variable, then it is looked up once before
+// the loop begins and stored in a slot, and the lookups within the loop
+// are replaced by loads from that slot.
+//
+// We only consider the main program, as the slots would otherwise need to
+// be saved whilst functions call themselves.  A name is assumed to be
+// changed by a loop if the loop refers to it as a string, which is how
+// the names of variables are given to the instructions which set them,
+// or if the loop calls a user-defined, or anonymous, function which does
+// so.  (Host functions are assumed not to set variables.)
+
+package vm
+
+import (
+	"encoding/binary"
+	"strings"
+
+	"github.com/skx/evalfilter/v2/code"
+	"github.com/skx/evalfilter/v2/object"
+)
+
+// instruction holds a single decoded instruction.
+type instruction struct {
+
+	// offset is the position of the instruction in the bytecode.
+	offset int
+
+	// op is the opcode.
+	op code.Opcode
+
+	// arg is the argument of the opcode, if it has one.
+	arg int
+}
+
+// decode returns the instructions within the given bytecode.
+func decode(bytecode code.Instructions) []instruction {
+
+	var out []instruction
+
+	ip := 0
+	for ip < len(bytecode) {
+		op := code.Opcode(bytecode[ip])
+		opLen := code.Length(op)
+
+		arg := 0
+		if opLen > 1 {
+			if ip+3 > len(bytecode) {
+				return nil
+			}
+			arg = int(binary.BigEndian.Uint16(bytecode[ip+1 : ip+3]))
+		}
+		out = append(out, instruction{offset: ip, op: op, arg: arg})
+		ip += opLen
+	}
+	return out
+}
+
+// names adds the names of the variables which the given instructions
+// might set to the given map.
+func (vm *VM) names(instructions []instruction, set map[string]bool) {
+
+	for _, i := range instructions {
+		switch i.op {
+		case code.OpConstant, code.OpInc, code.OpDec:
+			if i.arg >= len(vm.constants) {
+				continue
+			}
+			if str, ok := vm.constants[i.arg].(*object.String); ok {
+				set[strings.TrimPrefix(str.Value, "$")] = true
+			}
+		}
+	}
+}
+
+// hoistLookups moves the lookups of the variables, and fields, which
+// a loop doesn't change to before the loop begins.
+//
+// This function returns the number of lookups which were hoisted.
+func (vm *VM) hoistLookups() int {
+
+	prog := decode(vm.bytecode)
+	if prog == nil {
+		return 0
+	}
+
+	// The names which the user-defined functions might set, which
+	// we only find if a loop calls something.
+	var called map[string]bool
+
+	// The instructions to be inserted before each loop.
+	inserts := make(map[int][]instruction)
+
+	// The number of slots we've used.
+	slots := 0
+
+	// Loops are found in the order they begin, so outer loops are
+	// handled before the loops they contain.
+	for n, enter := range prog {
+		if enter.op != code.OpLoopEnter {
+			continue
+		}
+
+		// The body ends with a jump back to the instruction
+		// following the OpLoopEnter.
+		start := n + 1
+		end := -1
+		for j := start; j < len(prog); j++ {
+			if prog[j].op == code.OpJump && start < len(prog) && prog[j].arg == prog[start].offset {
+				end = j
+			}
+		}
+		if end < 0 {
+			continue
+		}
+		body := prog[start : end+1]
+
+		// Find the names the loop might set.
+		set := make(map[string]bool)
+		vm.names(body, set)
+		for _, i := range body {
+			if i.op != code.OpCall {
+				continue
+			}
+			if called == nil {
+				called = make(map[string]bool)
+				for _, fun := range vm.functions {
+					vm.names(decode(fun.Bytecode), called)
+				}
+				for _, c := range vm.constants {
+					if fun, ok := c.(*object.Function); ok {
+						vm.names(decode(fun.Bytecode), called)
+					}
+				}
+			}
+			for name := range called {
+				set[name] = true
+			}
+			break
+		}
+
+		// Now replace the lookups of the other names.
+		hoisted := make(map[string]int)
+		for j := range body {
+			i := &body[j]
+			if i.op != code.OpLookup || i.arg >= len(vm.constants) {
+				continue
+			}
+			name := strings.TrimPrefix(vm.constants[i.arg].Inspect(), "$")
+			if set[name] {
+				continue
+			}
+
+			slot, ok := hoisted[name]
+			if !ok {
+				if slots > 0xFFFF {
+					continue
+				}
+				slot = slots
+				slots++
+				hoisted[name] = slot
+				inserts[n] = append(inserts[n],
+					instruction{op: code.OpLookup, arg: i.arg},
+					instruction{op: code.OpStoreSlot, arg: slot})
+			}
+			i.op = code.OpLoadSlot
+			i.arg = slot
+		}
+	}
+
+	if slots == 0 {
+		return 0
+	}
+
+	// Build the updated bytecode, along with the mapping from the
+	// old offsets to the new.  A jump to a loop must arrive before
+	// the lookups we've moved there.
+	var tmp code.Instructions
+	rewrite := make(map[int]int)
+	moved := make(map[int]int)
+	for n, i := range prog {
+		rewrite[i.offset] = len(tmp)
+		for _, extra := range inserts[n] {
+			tmp = appendInstruction(tmp, extra.op, extra.arg)
+		}
+		moved[i.offset] = len(tmp)
+		tmp = appendInstruction(tmp, i.op, i.arg)
+	}
+	rewrite[len(vm.bytecode)] = len(tmp)
+
+	// Now patch the jumps, and jump-tables.
+	tables := make(map[*object.Hash]map[object.HashKey]object.HashPair)
+	for _, i := range decode(tmp) {
+		switch i.op {
+		case code.OpJump, code.OpJumpIfFalse:
+			dst, ok := rewrite[i.arg]
+			if !ok {
+				return 0
+			}
+			binary.BigEndian.PutUint16(tmp[i.offset+1:i.offset+3], uint16(dst))
+
+		case code.OpJumpTable:
+			if i.arg >= len(vm.constants) {
+				return 0
+			}
+			table, ok := vm.constants[i.arg].(*object.Hash)
+			if !ok {
+				return 0
+			}
+			updated := make(map[object.HashKey]object.HashPair)
+			for k, pair := range table.Pairs {
+				offset, ok := pair.Value.(*object.Integer)
+				if !ok {
+					return 0
+				}
+				dst, ok := rewrite[int(offset.Value)]
+				if !ok {
+					return 0
+				}
+				updated[k] = object.HashPair{Key: pair.Key, Value: &object.Integer{Value: int64(dst)}}
+			}
+			tables[table] = updated
+		}
+	}
+	for table, pairs := range tables {
+		table.Pairs = pairs
+	}
+
+	vm.bytecode = tmp
+	vm.sources = vm.sources.Relocate(moved)
+	vm.slots = make([]object.Object, slots)
+	return slots
+}
+
+// appendInstruction appends the given instruction to the bytecode.
+func appendInstruction(bytecode code.Instructions, op code.Opcode, arg int) code.Instructions {
+
+	bytecode = append(bytecode, byte(op))
+	if code.Length(op) > 1 {
+		bytecode = append(bytecode, byte(arg>>8), byte(arg))
+	}
+	return bytecode
+}
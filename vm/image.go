@@ -0,0 +1,94 @@
+// This file contains the images of machines, which allow a program to be
+// saved once it has been compiled and optimized, and later loaded into a
+// new machine without repeating that work.
+
+package vm
+
+import (
+	"context"
+
+	"github.com/skx/evalfilter/v2/code"
+	"github.com/skx/evalfilter/v2/environment"
+	"github.com/skx/evalfilter/v2/object"
+	"github.com/skx/evalfilter/v2/stack"
+)
+
+// Image holds the program which a machine runs, after any optimization
+// has taken place.
+type Image struct {
+
+	// Constants holds the constant-pool of the program.
+	Constants []object.Object
+
+	// Bytecode holds the bytecode of the main program.
+	Bytecode code.Instructions
+
+	// Functions holds the user-defined functions of the program.
+	Functions map[string]environment.UserFunction
+
+	// Loops holds the descriptions of the loops of the program.
+	Loops []Loop
+
+	// Slots holds the number of lookups which the optimizer moved
+	// out of loops.
+	Slots int
+
+	// Language holds the version of the language the program is run
+	// by.
+	Language int
+}
+
+// Image returns the program which this machine runs.
+//
+// The contents of the image are shared with the machine, so they must
+// not be modified.
+func (vm *VM) Image() *Image {
+	return &Image{
+		Constants: vm.constants,
+		Bytecode:  vm.bytecode,
+		Functions: vm.functions,
+		Loops:     vm.loops,
+		Slots:     len(vm.slots),
+		Language:  vm.Language(),
+	}
+}
+
+// Load creates a new machine which runs the program held in the given
+// image, against the given environment.
+//
+// The program is not optimized again, as that will already have taken
+// place if it was going to.
+func Load(image *Image, env *environment.Environment) *VM {
+
+	// If we have a `DEBUG` environment then we enable debugging.
+	_, debug := env.Get("DEBUG")
+
+	functions := image.Functions
+	if functions == nil {
+		functions = make(map[string]environment.UserFunction)
+	}
+
+	vm := &VM{
+		bytecode:    image.Bytecode,
+		constants:   image.Constants,
+		debug:       debug,
+		environment: env,
+		functions:   functions,
+		stack:       stack.New(),
+		loopLimit:   DefaultLoopLimit,
+	}
+	vm.SetContext(context.Background())
+	vm.SetLoops(image.Loops)
+	vm.SetLanguage(image.Language)
+	if image.Slots > 0 {
+		vm.slots = make([]object.Object, image.Slots)
+	}
+
+	// If the program is a predicate we can evaluate it without
+	// the machine - unless we're showing our execution.
+	if !debug {
+		vm.predicate = newPredicate(vm.constants, vm.bytecode)
+	}
+
+	return vm
+}
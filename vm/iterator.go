@@ -0,0 +1,111 @@
+// This file contains the state which is used to implement our foreach
+// loops.
+//
+// Originally the iteration-offset was stored within the object being
+// iterated over, which meant that nested loops over the same object
+// would trample upon each other.  Now each loop gets its own iterator,
+// which is stored upon the stack, and the object itself is left alone.
+
+package vm
+
+import (
+	"github.com/skx/evalfilter/v2/object"
+)
+
+// iterator holds the state of a single foreach-loop.
+//
+// It implements the object.Object interface, purely so that it can be
+// stored upon our stack between iterations.
+type iterator struct {
+
+	// offset holds our position within the items being iterated.
+	offset int
+
+	// elements holds the contents of an array being iterated over.
+	elements []object.Object
+
+	// chars holds the characters of a string being iterated over.
+	chars []rune
+
+	// entries holds the contents of a hash being iterated over.
+	entries []object.HashPair
+
+	// helper is used for any other object which implements the
+	// Iterable interface.
+	//
+	// We've no choice but to store the iteration-state within
+	// objects of these types, so nesting won't work for them.
+	helper object.Iterable
+}
+
+// newIterator creates a new iterator for the given object, or returns
+// false if the object cannot be iterated over.
+func newIterator(obj object.Object) (*iterator, bool) {
+
+	switch val := obj.(type) {
+	case *object.Array:
+		return &iterator{elements: val.Elements}, true
+	case *object.String:
+		return &iterator{chars: []rune(val.Value)}, true
+	case *object.Hash:
+		return &iterator{entries: val.Entries()}, true
+	case object.Iterable:
+		val.Reset()
+		return &iterator{helper: val}, true
+	}
+
+	return nil, false
+}
+
+// Next returns the next item, and index, from the object.
+//
+// The final return value will be false when the iteration is complete.
+func (i *iterator) Next() (object.Object, object.Object, bool) {
+
+	if i.helper != nil {
+		return i.helper.Next()
+	}
+
+	idx := i.offset
+	i.offset++
+
+	switch {
+	case i.elements != nil:
+		if idx < len(i.elements) {
+			return i.elements[idx], object.Int(int64(idx)), true
+		}
+	case i.chars != nil:
+		if idx < len(i.chars) {
+			return &object.String{Value: string(i.chars[idx])}, object.Int(int64(idx)), true
+		}
+	case i.entries != nil:
+		if idx < len(i.entries) {
+			return i.entries[idx].Value, i.entries[idx].Key, true
+		}
+	}
+
+	return nil, nil, false
+}
+
+// Type returns the type of this object.
+func (i *iterator) Type() object.Type {
+	return object.ITERATOR
+}
+
+// Inspect returns a string-representation of the given object.
+func (i *iterator) Inspect() string {
+	return "<iterator>"
+}
+
+// True returns whether this object wraps a true-like value.
+func (i *iterator) True() bool {
+	return true
+}
+
+// ToInterface converts this object to a go-interface.
+func (i *iterator) ToInterface() interface{} {
+	return nil
+}
+
+// Ensure this object implements the expected interfaces.
+var _ object.Object = &iterator{}
@@ -0,0 +1,77 @@
+// This file contains the versions of our language, which allow the
+// semantics of the language to change without altering the behaviour of
+// the scripts which were written before the change.
+//
+// Each script is run according to the version it was compiled for, which
+// is Language1 unless the script, or its host, asks for another.  The
+// second version of the language changes the rules of truthiness:
+//
+//   - Numbers are true if they're non-zero, rather than only if they're
+//     positive, so `if ( -1 )` takes its branch.
+//
+//   - The `!` operator negates the truthiness of any value, rather than
+//     only that of booleans and null, so `!0` and `!""` are true.
+
+package vm
+
+import (
+	"github.com/skx/evalfilter/v2/object"
+)
+
+// The versions of our language.
+const (
+	// Language1 is the original version of the language, which is
+	// the default.
+	Language1 = 1
+
+	// Language2 treats every non-zero number as true, and allows the
+	// `!` operator to negate the truthiness of any value.
+	Language2 = 2
+
+	// LatestLanguage is the most recent version of the language.
+	LatestLanguage = Language2
+)
+
+// SetLanguage sets the version of the language our program is run by,
+// which must be between Language1 and LatestLanguage.
+func (vm *VM) SetLanguage(version int) {
+	vm.language = version
+}
+
+// Language returns the version of the language our program is run by.
+func (vm *VM) Language() int {
+	if vm.language < Language1 {
+		return Language1
+	}
+	return vm.language
+}
+
+// True returns whether the given object is true, according to the rules
+// of the version of the language our program is run by.
+func (vm *VM) True(obj object.Object) bool {
+
+	if vm.language >= Language2 {
+		switch n := obj.(type) {
+		case *object.Integer:
+			return n.Value != 0
+		case *object.Float:
+			return n.Value != 0
+		}
+	}
+	return obj.True()
+}
+
+// valueTrue returns whether the given value is true, in the same way as
+// True does for the equivalent object.
+func (vm *VM) valueTrue(v value) bool {
+
+	if vm.language >= Language2 {
+		switch v.kind {
+		case intValue:
+			return v.i != 0
+		case floatValue:
+			return v.f != 0
+		}
+	}
+	return v.True()
+}
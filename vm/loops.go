@@ -0,0 +1,84 @@
+// This file contains the loop limits, which abort scripts whose loops
+// iterate more times than they should.
+//
+// A timeout will terminate a script which never finishes, but it does
+// so after the fact, and without explaining why.  Limiting the number
+// of iterations each loop may make allows a runaway loop to be stopped
+// promptly, along with an error which identifies it.
+
+package vm
+
+import (
+	"fmt"
+)
+
+// DefaultLoopLimit is the number of iterations each loop may make,
+// unless a different limit has been configured.
+const DefaultLoopLimit = 1000000
+
+// Loop describes a single while, or foreach, loop within a script.
+type Loop struct {
+
+	// Kind holds the kind of the loop, "while" or "foreach".
+	Kind string
+
+	// Line holds the line of the script upon which the loop begins.
+	Line int
+
+	// Limit holds the number of iterations the loop may make, which
+	// overrides the limit of the machine.  Zero means the limit of
+	// the machine applies.
+	Limit int
+}
+
+// SetLoops sets the descriptions of the loops in the program, which
+// the arguments of the OpLoopEnter and OpLoopCount instructions refer
+// to.
+func (vm *VM) SetLoops(loops []Loop) {
+	vm.loops = loops
+	vm.iterations = make([]int, len(loops))
+}
+
+// SetLoopLimit sets the number of iterations each loop may make, for
+// loops without their own limit.
+//
+// A limit of zero, or less, disables the check.
+func (vm *VM) SetLoopLimit(limit int) {
+	vm.loopLimit = limit
+}
+
+// enterLoop is invoked before the given loop begins.
+func (vm *VM) enterLoop(id int) error {
+
+	if id >= len(vm.loops) {
+		return fmt.Errorf("access to loop %d which doesn't exist", id)
+	}
+
+	vm.iterations[id] = 0
+	return nil
+}
+
+// countLoop is invoked at the start of each iteration of the given
+// loop, and returns an error if the loop has exceeded its limit.
+func (vm *VM) countLoop(id int) error {
+
+	if id >= len(vm.loops) {
+		return fmt.Errorf("access to loop %d which doesn't exist", id)
+	}
+
+	loop := vm.loops[id]
+
+	limit := vm.loopLimit
+	if loop.Limit > 0 {
+		limit = loop.Limit
+	}
+	if limit <= 0 {
+		return nil
+	}
+
+	vm.iterations[id]++
+	if vm.iterations[id] > limit {
+		return fmt.Errorf("the %s loop on line %d exceeded the limit of %d iterations", loop.Kind, loop.Line, limit)
+	}
+	return nil
+}
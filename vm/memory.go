@@ -0,0 +1,68 @@
+// This file contains the memory accounting, which allows the amount of
+// memory a script may allocate to be limited.
+//
+// Scripts can construct large strings and arrays very cheaply, for
+// example `s = s + s;` doubles the length of a string each time it is
+// executed, so a short loop can exhaust the memory of the host.  We
+// keep an approximate count of the bytes allocated for the strings,
+// arrays, and hashes built during each run, and abort the run once it
+// exceeds the limit we've been given.
+
+package vm
+
+import (
+	"fmt"
+
+	"github.com/skx/evalfilter/v2/object"
+)
+
+// The approximate sizes of the things we account for, in bytes.
+const (
+	// elementSize is the size of each member of an array.
+	elementSize = 16
+
+	// pairSize is the size of each member of a hash.
+	pairSize = 64
+)
+
+// SetMemoryLimit sets the approximate number of bytes which may be
+// allocated for strings, arrays, and hashes during each run.
+//
+// The count includes values which are no longer in use, so it bounds
+// the work a script may perform as well as the memory it may hold.
+// A limit of zero, the default, disables the check.
+func (vm *VM) SetMemoryLimit(bytes int64) {
+	vm.memoryLimit = bytes
+}
+
+// allocate records that the given number of bytes are about to be
+// allocated, and returns an error if that would exceed our limit.
+func (vm *VM) allocate(bytes int64) error {
+
+	if vm.memoryLimit <= 0 {
+		return nil
+	}
+
+	vm.allocated += bytes
+	if vm.allocated > vm.memoryLimit {
+		return fmt.Errorf("the memory limit of %d bytes was exceeded", vm.memoryLimit)
+	}
+	return nil
+}
+
+// sizeOf returns the approximate size of the given object, in bytes.
+//
+// The members of arrays and hashes are not included, as they will have
+// been accounted for when they were created.
+func sizeOf(obj object.Object) int64 {
+
+	switch o := obj.(type) {
+	case *object.String:
+		return int64(len(o.Value))
+	case *object.Array:
+		return int64(len(o.Elements)) * elementSize
+	case *object.Hash:
+		return int64(len(o.Pairs)) * pairSize
+	}
+	return 0
+}
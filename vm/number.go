@@ -0,0 +1,65 @@
+// This file contains the handling of the numbers within JSON documents
+// which were decoded with json.Decoder's UseNumber, so that their values
+// aren't rounded to floats.
+//
+// Such numbers are strings, which implement fmt.Stringer, but scripts
+// expect to compare them with numbers - so integers are presented as
+// integers, and everything else as floats.
+
+package vm
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"strconv"
+
+	"github.com/skx/evalfilter/v2/object"
+)
+
+// jsonNumberType is the type of json.Number.
+var jsonNumberType = reflect.TypeOf(json.Number(""))
+
+// parseNumber returns the value of the given json.Number, which is an
+// integer if it has no fraction, or exponent, and fits within one.
+//
+// Numbers which can't be parsed are returned as their text.
+func parseNumber(s string) value {
+
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return value{kind: intValue, i: i}
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return value{kind: floatValue, f: f}
+	}
+	return value{kind: stringValue, s: s}
+}
+
+// numberObject converts the given json.Number to one of our objects.
+func numberObject(s string) object.Object {
+
+	v := parseNumber(s)
+	switch v.kind {
+	case intValue:
+		return object.Int(v.i)
+	case floatValue:
+		return &object.Float{Value: v.f}
+	}
+	return &object.String{Value: s}
+}
+
+// numberText returns the given number as the text of a json.Number, so
+// that it may be stored within a document.
+func numberText(obj object.Object) (string, bool) {
+
+	switch n := obj.(type) {
+	case *object.Integer:
+		return strconv.FormatInt(n.Value, 10), true
+	case *object.Float:
+		if math.IsInf(n.Value, 0) || math.IsNaN(n.Value) {
+			return "", false
+		}
+		return strconv.FormatFloat(n.Value, 'g', -1, 64), true
+	}
+	return "", false
+}
@@ -21,6 +21,7 @@ import (
 	"math"
 
 	"github.com/skx/evalfilter/v2/code"
+	"github.com/skx/evalfilter/v2/object"
 )
 
 // optimize optimizes our bytecode by working over the program
@@ -101,11 +102,25 @@ func (vm *VM) optimizeMaths() (bool, error) {
 	//
 	changed := false
 
+	//
+	// The offsets which are the destinations of jumps.
+	//
+	targets := vm.jumpTargets()
+
 	//
 	// Walk over the bytecode
 	//
 	err := vm.WalkBytecode(func(offset int, opCode code.Opcode, opArg interface{}) (bool, error) {
 
+		//
+		// If this instruction is the destination of a jump
+		// then the stack might hold something other than the
+		// constants we've seen, so we must forget them.
+		//
+		if _, ok := targets[offset]; ok {
+			args = nil
+		}
+
 		//
 		// Now we do the magic.
 		//
@@ -360,11 +375,25 @@ func (vm *VM) optimizeJumps() bool {
 	//
 	changed := false
 
+	//
+	// The offsets which are the destinations of jumps.
+	//
+	targets := vm.jumpTargets()
+
 	//
 	// Walk the bytecode.
 	//
 	err := vm.WalkBytecode(func(offset int, opCode code.Opcode, opArg interface{}) (bool, error) {
 
+		//
+		// If this instruction is the destination of a jump
+		// then we might reach it with something other than
+		// the result of the previous instruction on the stack.
+		//
+		if _, ok := targets[offset]; ok {
+			prevOp = code.OpNop
+		}
+
 		//
 		// Now we do the magic.
 		//
@@ -419,8 +448,21 @@ func (vm *VM) optimizeJumps() bool {
 				// `OpFalse` and `OpJumpIfFalse`
 				//
 
+				// We can't remove the instructions
+				// if something outside them jumps
+				// into the middle of them.
+				dst := opArg.(int)
+				for i := offset; i < dst; i++ {
+					for _, src := range targets[i] {
+						if src < offset-1 || src >= dst {
+							prevOp = opCode
+							return true, nil
+						}
+					}
+				}
+
 				i := offset - 1
-				for i < opArg.(int) {
+				for i < dst {
 					vm.bytecode[i] = byte(code.OpNop)
 					i++
 				}
@@ -456,6 +498,50 @@ func (vm *VM) optimizeJumps() bool {
 	return changed
 }
 
+// jumpTargets returns the destinations of all the jumps within our
+// bytecode, each mapped to the offsets of the jumps which lead there.
+//
+// An instruction which is the destination of a jump may be reached
+// from more than one place, so the optimizer must not assume that it
+// only follows the instruction before it.
+func (vm *VM) jumpTargets() map[int][]int {
+
+	targets := make(map[int][]int)
+
+	err := vm.WalkBytecode(func(offset int, opCode code.Opcode, opArg interface{}) (bool, error) {
+
+		switch opCode {
+
+		case code.OpJump, code.OpJumpIfFalse:
+			dst := opArg.(int)
+			targets[dst] = append(targets[dst], offset)
+
+		case code.OpJumpTable:
+			idx := opArg.(int)
+			if idx >= len(vm.constants) {
+				break
+			}
+			table, ok := vm.constants[idx].(*object.Hash)
+			if !ok {
+				break
+			}
+			for _, pair := range table.Pairs {
+				if dst, ok := pair.Value.(*object.Integer); ok {
+					targets[int(dst.Value)] = append(targets[int(dst.Value)], offset)
+				}
+			}
+		}
+
+		return true, nil
+	})
+
+	if err != nil {
+		fmt.Printf("jumpTargets:%s\n", err)
+	}
+
+	return targets
+}
+
 // removeNOPs removes any inline NOP instructions.
 //
 // It also rewrites the destinations for jumps as appropriate, to
@@ -472,6 +558,12 @@ func (vm *VM) removeNOPs() {
 	//
 	rewrite := make(map[int]int)
 
+	//
+	// And from the old offset of each instruction we keep to the
+	// new, which moves their positions within the script.
+	//
+	moved := make(map[int]int)
+
 	//
 	// Walk the bytecode.
 	//
@@ -507,6 +599,7 @@ func (vm *VM) removeNOPs() {
 			// instruction set.  Before we add it.
 			//
 			rewrite[offset] = len(tmp)
+			moved[offset] = len(tmp)
 
 			//
 			// Copy the instruction.
@@ -548,6 +641,11 @@ func (vm *VM) removeNOPs() {
 	// program with no NOPs.   We now need to patch up
 	// the jump targets
 	//
+	// Jump-tables hold their targets in a constant, which we
+	// update once we're sure every target could be rewritten.
+	//
+	tables := make(map[*object.Hash]map[object.HashKey]object.HashPair)
+
 	ip := 0
 	ln := len(tmp)
 	for ip < ln {
@@ -600,6 +698,30 @@ func (vm *VM) removeNOPs() {
 			tmp[ip+1] = b[0]
 			tmp[ip+2] = b[1]
 
+		case code.OpJumpTable:
+
+			if opArg >= len(vm.constants) {
+				return
+			}
+			table, ok := vm.constants[opArg].(*object.Hash)
+			if !ok {
+				return
+			}
+
+			// Build the updated destinations.
+			updated := make(map[object.HashKey]object.HashPair)
+			for k, pair := range table.Pairs {
+				offset, ok := pair.Value.(*object.Integer)
+				if !ok {
+					return
+				}
+				newDst, ok := rewrite[int(offset.Value)]
+				if !ok {
+					return
+				}
+				updated[k] = object.HashPair{Key: pair.Key, Value: &object.Integer{Value: int64(newDst)}}
+			}
+			tables[table] = updated
 		}
 
 		//
@@ -609,9 +731,17 @@ func (vm *VM) removeNOPs() {
 	}
 
 	//
-	// Replace the instructions.
+	// Replace any jump-tables we updated.
+	//
+	for table, pairs := range tables {
+		table.Pairs = pairs
+	}
+
+	//
+	// Replace the instructions, and move their positions.
 	//
 	vm.bytecode = tmp
+	vm.sources = vm.sources.Relocate(moved)
 }
 
 // removeDeadCode does the bare minimum of dead-code removal:
@@ -639,7 +769,7 @@ func (vm *VM) removeDeadCode() {
 		//
 		switch opCode {
 
-		case code.OpJumpIfFalse, code.OpJump:
+		case code.OpJumpIfFalse, code.OpJump, code.OpJumpTable:
 			// Stop walking
 			return false, nil
 
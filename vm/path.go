@@ -0,0 +1,210 @@
+// This file contains the lookup of paths of fields, such as
+// `Request.Header.Host`.
+//
+// Indexing each field in turn would convert the whole of the structure,
+// or map, which contains the next - so instead, where we can, we walk
+// the object the script was run against and convert only the value the
+// path leads to.
+//
+// That is only possible when the result is the same: paths which begin
+// with a variable, or a field the script has already used, are indexed
+// as before, as are paths leading to arrays and hashes, which the script
+// might modify and expect to see the change when it looks again.
+
+package vm
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/skx/evalfilter/v2/object"
+)
+
+// lookupPath returns the value of the given path of fields, such as
+// "Request.Header.Host".
+//
+// A path through a missing, or null, field results in null rather than
+// an error.
+func (vm *VM) lookupPath(obj interface{}, path string) (object.Object, error) {
+
+	root, rest := cut(strings.TrimPrefix(path, "$"))
+
+	if vm.walkable(root) {
+		if val, ok := vm.walkPath(obj, root, rest); ok {
+			return vm.fieldValue(val), nil
+		}
+	}
+
+	// Otherwise we index each field in turn.
+	val := vm.lookup(obj, root)
+	for rest != "" {
+		var name string
+		name, rest = cut(rest)
+
+		if val.Type() == object.NULL {
+			return Null, nil
+		}
+
+		err := vm.executeIndexExpression(val, vm.arena.string(name))
+		if err != nil {
+			return nil, err
+		}
+		val, err = vm.stack.Pop()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return val, nil
+}
+
+// walkable returns true if the path beginning with the given name may be
+// found by walking the object, rather than the value of a variable or of
+// a field which has already been converted.
+func (vm *VM) walkable(root string) bool {
+
+	if _, ok := vm.environment.Get(root); ok {
+		return false
+	}
+	_, ok := vm.fields[root]
+	return !ok
+}
+
+// walkPath finds the value of the given path of fields within the
+// object, which begins with the field root and continues with those in
+// rest.
+//
+// If the path can't be walked then false is returned, and it must be
+// indexed instead.  A path which leads through a missing, or nil, value
+// returns the invalid value, which is converted to null.
+func (vm *VM) walkPath(obj interface{}, root string, rest string) (reflect.Value, bool) {
+
+	var none reflect.Value
+
+	if obj == nil {
+		return none, false
+	}
+
+	// Providers only give us the values of their own fields.
+	if _, ok := obj.(FieldProvider); ok {
+		return none, false
+	}
+
+	var cur reflect.Value
+	if m, ok := obj.(map[string]interface{}); ok {
+		val, ok := m[root]
+		if !ok {
+			return none, false
+		}
+		cur = reflect.ValueOf(val)
+	} else {
+		cur = member(reflect.ValueOf(obj), vm.fieldTag, root)
+		if !cur.IsValid() {
+			return none, false
+		}
+	}
+
+	for rest != "" {
+		var name string
+		name, rest = cut(rest)
+
+		// Values which we'd convert specially, such as those
+		// which describe themselves, are indexed instead.
+		for cur.IsValid() && (cur.Kind() == reflect.Ptr || cur.Kind() == reflect.Interface) {
+			if cur.IsNil() {
+				return none, true
+			}
+			if !plainType(cur.Type()) {
+				return none, false
+			}
+			cur = cur.Elem()
+		}
+		if !cur.IsValid() {
+			return none, true
+		}
+		if !plainType(cur.Type()) {
+			return none, false
+		}
+
+		switch cur.Kind() {
+		case reflect.Map, reflect.Struct:
+			cur = member(cur, vm.fieldTag, name)
+		default:
+			return none, false
+		}
+		if !cur.IsValid() {
+			return none, true
+		}
+	}
+
+	return cur, vm.convertible(cur)
+}
+
+// convertible returns true if the value a path leads to may be converted
+// each time the path is looked up, because it isn't an array, or hash,
+// which the script might modify.
+func (vm *VM) convertible(val reflect.Value) bool {
+
+	for val.IsValid() {
+		if (val.Kind() == reflect.Ptr || val.Kind() == reflect.Interface) && val.IsNil() {
+			return true
+		}
+
+		// Values which describe themselves are strings, unless
+		// we're presenting their fields instead.
+		if !plainType(val.Type()) {
+			return !vm.stringerFields
+		}
+
+		switch val.Kind() {
+		case reflect.Ptr, reflect.Interface:
+			val = val.Elem()
+		case reflect.Map, reflect.Struct, reflect.Slice, reflect.Array, reflect.Chan:
+			return false
+		default:
+			return true
+		}
+	}
+	return true
+}
+
+// member returns the member of the given map, or structure, with the
+// given name - or the invalid value if there is no such member.  The
+// fields of structures are named via the given tag, as by walkStruct.
+func member(val reflect.Value, tag string, name string) reflect.Value {
+
+	var none reflect.Value
+
+	val, ok := indirect(val)
+	if !ok {
+		return none
+	}
+
+	switch val.Kind() {
+	case reflect.Map:
+		// The most common kind of map is looked up directly, as
+		// using reflection would allocate a copy of its key and
+		// value.
+		if val.CanInterface() {
+			if m, ok := val.Interface().(map[string]interface{}); ok {
+				return reflect.ValueOf(m[name])
+			}
+		}
+		key, ok := mapKey(val.Type().Key(), name)
+		if !ok {
+			return none
+		}
+		return val.MapIndex(key)
+	case reflect.Struct:
+		return fieldByName(val, tag, name)
+	}
+	return none
+}
+
+// cut returns the first name of the given path, and the rest of it.
+func cut(path string) (string, string) {
+
+	if i := strings.IndexByte(path, '.'); i >= 0 {
+		return path[:i], path[i+1:]
+	}
+	return path, ""
+}
@@ -0,0 +1,570 @@
+// This file contains the evaluation of predicates, which are programs
+// that consist of nothing more than the comparison of fields against
+// constants, combined via boolean logic, such as:
+//
+//    return Status == "active" && ( Age >= 18 || Admin );
+//
+// This is the most common shape of script, and evaluating it upon our
+// general-purpose machine means converting each field to one of our
+// objects, and allocating the result of each operation.  Instead once a
+// program has been compiled we test whether it is a predicate, and if so
+// evaluate it with a stack of plain values.
+//
+// The results must be identical to those of the machine, so anything
+// unusual, such as a field to which we'd give special handling, or an
+// operation which would fail, abandons the evaluation and the program is
+// run upon the machine instead.
+
+package vm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/skx/evalfilter/v2/code"
+	"github.com/skx/evalfilter/v2/object"
+)
+
+// valueKind describes the type of a value.
+type valueKind uint8
+
+// The kinds of values a predicate may work with.
+const (
+	nullValue valueKind = iota
+	intValue
+	floatValue
+	stringValue
+	boolValue
+)
+
+// value is a single value, which hasn't been converted to an object.
+type value struct {
+	kind valueKind
+	i    int64
+	f    float64
+	s    string
+	b    bool
+}
+
+// True returns whether the value is true, in the same way as the True
+// method of the equivalent object.
+func (v value) True() bool {
+
+	switch v.kind {
+	case intValue:
+		return v.i > 0
+	case floatValue:
+		return v.f > 0
+	case stringValue:
+		return v.s != ""
+	case boolValue:
+		return v.b
+	}
+	return false
+}
+
+// numeric returns true if the value is an integer or a float.
+func (v value) numeric() bool {
+	return v.kind == intValue || v.kind == floatValue
+}
+
+// float returns the value of a number as a float.
+func (v value) float() float64 {
+	if v.kind == intValue {
+		return float64(v.i)
+	}
+	return v.f
+}
+
+// predicateOp is a single operation of a predicate.
+type predicateOp struct {
+
+	// op is the operation.
+	op code.Opcode
+
+	// val holds the value of a constant.
+	val value
+
+	// name holds the name of the field, or variable, a lookup
+	// refers to.
+	name string
+
+	// path holds the rest of the path of fields a lookup refers
+	// to, such as "Header.Host" for `Request.Header.Host`.
+	path string
+
+	// keyType and key cache the key used to find the field within
+	// a map, which depends upon the type of the map's keys.
+	keyType reflect.Type
+	key     reflect.Value
+
+	// fieldType and plain cache whether the field's type may be
+	// handled by a predicate.
+	fieldType reflect.Type
+	plain     bool
+}
+
+// predicate holds a program which may be evaluated without creating any
+// objects.
+type predicate struct {
+
+	// ops holds the operations of the program, in order.
+	ops []predicateOp
+
+	// stack holds our values, and has room for as many as the
+	// program requires.
+	stack []value
+}
+
+// The types which would receive special handling when converted.
+var (
+	errorType    = reflect.TypeOf((*error)(nil)).Elem()
+	stringerType = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+	objectType   = reflect.TypeOf((*object.Object)(nil)).Elem()
+)
+
+// newPredicate returns the given program as a predicate, or nil if it
+// isn't one.
+func newPredicate(constants []object.Object, bytecode code.Instructions) *predicate {
+
+	p := &predicate{}
+	depth := 0
+	max := 0
+
+	// The result must be one of our booleans.
+	verdict := false
+
+	ip := 0
+	for ip < len(bytecode) {
+
+		op := code.Opcode(bytecode[ip])
+		opLen := code.Length(op)
+		opArg := 0
+		if opLen > 1 {
+			if ip+3 > len(bytecode) {
+				return nil
+			}
+			opArg = int(binary.BigEndian.Uint16(bytecode[ip+1 : ip+3]))
+		}
+		ip += opLen
+
+		o := predicateOp{op: op}
+
+		switch op {
+		case code.OpPush:
+			o.op = code.OpConstant
+			o.val = value{kind: intValue, i: int64(opArg)}
+			depth++
+			verdict = false
+
+		case code.OpConstant:
+			if opArg >= len(constants) {
+				return nil
+			}
+			val, ok := objectValue(constants[opArg])
+			if !ok {
+				return nil
+			}
+			o.val = val
+			depth++
+			verdict = false
+
+		case code.OpTrue, code.OpFalse:
+			o.op = code.OpConstant
+			o.val = value{kind: boolValue, b: op == code.OpTrue}
+			depth++
+			verdict = true
+
+		case code.OpLookup, code.OpLookupField:
+			if opArg >= len(constants) {
+				return nil
+			}
+			o.op = code.OpLookup
+			o.name = strings.TrimPrefix(constants[opArg].Inspect(), "$")
+			depth++
+			verdict = false
+
+		case code.OpLookupPath:
+			if opArg >= len(constants) {
+				return nil
+			}
+			o.name, o.path = cut(strings.TrimPrefix(constants[opArg].Inspect(), "$"))
+			depth++
+			verdict = false
+
+		case code.OpBang:
+			if depth < 1 {
+				return nil
+			}
+			verdict = true
+
+		case code.OpLess, code.OpLessEqual, code.OpGreater, code.OpGreaterEqual,
+			code.OpEqual, code.OpNotEqual, code.OpAnd, code.OpOr:
+			if depth < 2 {
+				return nil
+			}
+			depth--
+			verdict = true
+
+		case code.OpReturn:
+			// The return must be the final instruction, and
+			// return the only value.
+			if ip != len(bytecode) || depth != 1 || !verdict {
+				return nil
+			}
+
+		default:
+			return nil
+		}
+
+		if depth > max {
+			max = depth
+		}
+		p.ops = append(p.ops, o)
+	}
+
+	if len(p.ops) == 0 || p.ops[len(p.ops)-1].op != code.OpReturn {
+		return nil
+	}
+
+	p.stack = make([]value, 0, max)
+	return p
+}
+
+// clone returns a copy of the predicate, which may be evaluated
+// concurrently with this one.
+func (p *predicate) clone() *predicate {
+	return &predicate{
+		ops:   append([]predicateOp(nil), p.ops...),
+		stack: make([]value, 0, cap(p.stack)),
+	}
+}
+
+// IsPredicate returns true if our program consists only of comparisons
+// and boolean logic, and will be evaluated without creating objects.
+func (vm *VM) IsPredicate() bool {
+	return vm.predicate != nil && !vm.noPredicate
+}
+
+// SetPredicate controls whether programs which consist only of
+// comparisons and boolean logic are evaluated without creating objects,
+// which is the default.  Disabling this may be useful when debugging.
+func (vm *VM) SetPredicate(enable bool) {
+	vm.noPredicate = !enable
+}
+
+// objectValue returns the value of the given object, if it is one which
+// a predicate may handle.
+func objectValue(obj object.Object) (value, bool) {
+
+	switch o := obj.(type) {
+	case *object.Integer:
+		return value{kind: intValue, i: o.Value}, true
+	case *object.Float:
+		return value{kind: floatValue, f: o.Value}, true
+	case *object.String:
+		return value{kind: stringValue, s: o.Value}, true
+	case *object.Boolean:
+		return value{kind: boolValue, b: o.Value}, true
+	case *object.Null:
+		return value{kind: nullValue}, true
+	}
+	return value{}, false
+}
+
+// plainType returns true if the values of the given type are converted
+// to our objects without any special handling.
+func plainType(t reflect.Type) bool {
+
+	if t.Implements(objectType) || t.Implements(errorType) || t.Implements(stringerType) {
+		return false
+	}
+
+	// The methods might be implemented upon a pointer.
+	if t.Kind() != reflect.Ptr && t.Kind() != reflect.Interface {
+		p := reflect.PtrTo(t)
+		if p.Implements(objectType) || p.Implements(errorType) || p.Implements(stringerType) {
+			return false
+		}
+	}
+	return true
+}
+
+// runPredicate evaluates our predicate against the given object.
+//
+// If the evaluation was abandoned then false is returned, and the
+// program must be run upon the machine.
+func (vm *VM) runPredicate(obj interface{}) (object.Object, bool) {
+
+	p := vm.predicate
+
+	// An expired context is reported by the machine.
+	select {
+	case <-vm.context.Done():
+		return nil, false
+	default:
+	}
+
+	stack := p.stack[:0]
+	for i := range p.ops {
+
+		o := &p.ops[i]
+
+		switch o.op {
+		case code.OpConstant:
+			stack = append(stack, o.val)
+
+		case code.OpLookup:
+			val, ok := vm.predicateLookup(obj, o)
+			if !ok {
+				return nil, false
+			}
+			stack = append(stack, val)
+
+		case code.OpLookupPath:
+			val, ok := vm.predicatePath(obj, o)
+			if !ok {
+				return nil, false
+			}
+			stack = append(stack, val)
+
+		case code.OpBang:
+			top := &stack[len(stack)-1]
+			res := top.kind == nullValue || (top.kind == boolValue && !top.b)
+			if vm.language >= Language2 {
+				res = !vm.valueTrue(*top)
+			}
+			*top = value{kind: boolValue, b: res}
+
+		case code.OpAnd, code.OpOr:
+			left, right := stack[len(stack)-2], stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+
+			// Numbers, and strings, are handled by their own
+			// implementations, which don't support these.
+			if (left.numeric() && right.numeric()) || (left.kind == stringValue && right.kind == stringValue) {
+				return nil, false
+			}
+
+			res := vm.valueTrue(left) && vm.valueTrue(right)
+			if o.op == code.OpOr {
+				res = vm.valueTrue(left) || vm.valueTrue(right)
+			}
+			stack[len(stack)-1] = value{kind: boolValue, b: res}
+
+		case code.OpReturn:
+			return vm.nativeBoolToBooleanObject(stack[0].b), true
+
+		default:
+			left, right := stack[len(stack)-2], stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+
+			res, ok := compareValues(o.op, left, right)
+			if !ok {
+				return nil, false
+			}
+			stack[len(stack)-1] = value{kind: boolValue, b: res}
+		}
+	}
+
+	return nil, false
+}
+
+// predicateLookup returns the value of the named variable, or field, in
+// the same way as lookup.
+func (vm *VM) predicateLookup(obj interface{}, o *predicateOp) (value, bool) {
+
+	if val, ok := vm.environment.Get(o.name); ok {
+		return objectValue(val)
+	}
+
+	// The name of a function is a value, which the machine handles.
+	if vm.isFunction(o.name) {
+		return value{}, false
+	}
+
+	if obj == nil {
+		return value{kind: nullValue}, true
+	}
+
+	if p, ok := obj.(FieldProvider); ok {
+		f, ok := p.Field(o.name)
+		if !ok {
+			return value{kind: nullValue}, true
+		}
+		return f.val, true
+	}
+
+	// The members of the most common type of map can be found without
+	// reflection, which would copy them.
+	if m, ok := obj.(map[string]interface{}); ok {
+		return o.fieldValue(reflect.ValueOf(m[o.name]))
+	}
+
+	val, ok := indirect(reflect.ValueOf(obj))
+	if !ok {
+		return value{kind: nullValue}, true
+	}
+
+	var field reflect.Value
+
+	switch val.Kind() {
+	case reflect.Map:
+		if t := val.Type().Key(); t != o.keyType {
+			key, ok := mapKey(t, o.name)
+			if !ok {
+				return value{kind: nullValue}, true
+			}
+			o.keyType, o.key = t, key
+		}
+		field = val.MapIndex(o.key)
+	case reflect.Struct:
+		field = fieldByName(val, vm.fieldTag, o.name)
+	}
+
+	return o.fieldValue(field)
+}
+
+// predicatePath returns the value of the path of fields the given
+// operation refers to, if it may be found by walking the object.
+func (vm *VM) predicatePath(obj interface{}, o *predicateOp) (value, bool) {
+
+	// Variables, and functions, are indexed by the machine.
+	if _, ok := vm.environment.Get(o.name); ok {
+		return value{}, false
+	}
+	if vm.isFunction(o.name) {
+		return value{}, false
+	}
+
+	val, ok := vm.walkPath(obj, o.name, o.path)
+	if !ok {
+		return value{}, false
+	}
+	return o.fieldValue(val)
+}
+
+// fieldValue returns the value of the given field, if it is one the
+// predicate may handle.
+func (o *predicateOp) fieldValue(field reflect.Value) (value, bool) {
+
+	if !field.IsValid() {
+		return value{kind: nullValue}, true
+	}
+
+	for field.Kind() == reflect.Interface {
+		if field.IsNil() {
+			return value{kind: nullValue}, true
+		}
+		field = field.Elem()
+	}
+
+	t := field.Type()
+	if t == jsonNumberType {
+		return parseNumber(field.String()), true
+	}
+	if t == rawMessageType {
+		return rawValue(field.Bytes())
+	}
+	if t != o.fieldType {
+		o.fieldType, o.plain = t, plainType(t)
+	}
+	if !o.plain {
+		return value{}, false
+	}
+
+	switch field.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if t == durationType {
+			return value{kind: floatValue, f: time.Duration(field.Int()).Seconds()}, true
+		}
+		return value{kind: intValue, i: field.Int()}, true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return value{kind: intValue, i: int64(field.Uint())}, true
+	case reflect.Float32, reflect.Float64:
+		return value{kind: floatValue, f: field.Float()}, true
+	case reflect.String:
+		return value{kind: stringValue, s: field.String()}, true
+	case reflect.Bool:
+		return value{kind: boolValue, b: field.Bool()}, true
+	}
+	return value{}, false
+}
+
+// compareValues compares two values, in the same way as the machine.
+//
+// False is returned as the second result if the machine would report
+// an error.
+func compareValues(op code.Opcode, left, right value) (bool, bool) {
+
+	switch {
+	case left.kind == intValue && right.kind == intValue:
+		return compareIntegers(op, left.i, right.i), true
+	case left.numeric() && right.numeric():
+		return compareFloats(op, left.float(), right.float()), true
+	case left.kind == stringValue && right.kind == stringValue:
+		return compareStrings(op, left.s, right.s), true
+	case left.kind == boolValue && right.kind == boolValue:
+		// Booleans are compared as strings, by the machine.
+		return compareStrings(op, strconv.FormatBool(left.b), strconv.FormatBool(right.b)), true
+	}
+	return false, false
+}
+
+// compareIntegers returns the result of comparing two integers.
+func compareIntegers(op code.Opcode, l, r int64) bool {
+
+	switch op {
+	case code.OpLess:
+		return l < r
+	case code.OpLessEqual:
+		return l <= r
+	case code.OpGreater:
+		return l > r
+	case code.OpGreaterEqual:
+		return l >= r
+	case code.OpEqual:
+		return l == r
+	}
+	return l != r
+}
+
+// compareFloats returns the result of comparing two floats.
+func compareFloats(op code.Opcode, l, r float64) bool {
+
+	switch op {
+	case code.OpLess:
+		return l < r
+	case code.OpLessEqual:
+		return l <= r
+	case code.OpGreater:
+		return l > r
+	case code.OpGreaterEqual:
+		return l >= r
+	case code.OpEqual:
+		return l == r
+	}
+	return l != r
+}
+
+// compareStrings returns the result of comparing two strings.
+func compareStrings(op code.Opcode, l, r string) bool {
+
+	switch op {
+	case code.OpLess:
+		return l < r
+	case code.OpLessEqual:
+		return l <= r
+	case code.OpGreater:
+		return l > r
+	case code.OpGreaterEqual:
+		return l >= r
+	case code.OpEqual:
+		return l == r
+	}
+	return l != r
+}
@@ -0,0 +1,72 @@
+// This file contains the FieldProvider interface, which allows the host
+// to supply the fields of the objects scripts are run against directly,
+// rather than via reflection.
+
+package vm
+
+import (
+	"github.com/skx/evalfilter/v2/object"
+)
+
+// Field holds the value of a field, as returned by a FieldProvider.
+//
+// Fields are constructed via IntField, FloatField, StringField,
+// BoolField, and NullField, and are passed by value so that providing
+// them doesn't allocate.
+type Field struct {
+	val value
+}
+
+// IntField returns a field holding the given integer.
+func IntField(i int64) Field {
+	return Field{value{kind: intValue, i: i}}
+}
+
+// FloatField returns a field holding the given float.
+func FloatField(f float64) Field {
+	return Field{value{kind: floatValue, f: f}}
+}
+
+// StringField returns a field holding the given string.
+func StringField(s string) Field {
+	return Field{value{kind: stringValue, s: s}}
+}
+
+// BoolField returns a field holding the given boolean.
+func BoolField(b bool) Field {
+	return Field{value{kind: boolValue, b: b}}
+}
+
+// NullField returns a field holding the null value.
+func NullField() Field {
+	return Field{}
+}
+
+// FieldProvider may be implemented by the objects scripts are run
+// against, to supply the value of each field a script refers to.
+//
+// This avoids the cost of reflection, and scripts which consist only of
+// comparisons and boolean logic may be run against such objects without
+// allocating.  Field is invoked with the name the script used, and should
+// return false if there is no such field.
+//
+// The fields of providers can't be listed, or modified, by scripts.
+type FieldProvider interface {
+	Field(name string) (Field, bool)
+}
+
+// object returns the field as one of our objects.
+func (f Field) object() object.Object {
+
+	switch f.val.kind {
+	case intValue:
+		return object.Int(f.val.i)
+	case floatValue:
+		return &object.Float{Value: f.val.f}
+	case stringValue:
+		return &object.String{Value: f.val.s}
+	case boolValue:
+		return object.Bool(f.val.b)
+	}
+	return Null
+}
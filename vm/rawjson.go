@@ -0,0 +1,71 @@
+// This file contains the handling of json.RawMessage, which holds JSON
+// that hasn't yet been decoded.
+//
+// Objects whose members are raw JSON, such as the documents given to
+// RunJSON, are only decoded as their members are used, so that the cost
+// of decoding is only paid for the fields a script actually reads.  The
+// members are decoded as json.Decoder would decode them into interface
+// values, with UseNumber, so that they're presented as the equivalent
+// decoded document would be.
+
+package vm
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"unicode/utf8"
+
+	"github.com/skx/evalfilter/v2/object"
+)
+
+// rawMessageType is the type of json.RawMessage.
+var rawMessageType = reflect.TypeOf(json.RawMessage(nil))
+
+// rawObject decodes the given JSON to one of our objects, or null if it
+// can't be decoded.
+func (vm *VM) rawObject(data []byte) object.Object {
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var v interface{}
+	if dec.Decode(&v) != nil || v == nil {
+		return Null
+	}
+
+	ret := vm.primitiveToObject(reflect.ValueOf(v))
+	if ret == nil {
+		ret = Null
+	}
+	return ret
+}
+
+// rawValue returns the value of the given JSON, if it is a number, a
+// boolean, null, or a string which contains no escapes, and so may be
+// taken as it is.
+func rawValue(data []byte) (value, bool) {
+
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return value{}, false
+	}
+
+	switch data[0] {
+	case 'n':
+		return value{kind: nullValue}, string(data) == "null"
+	case 't':
+		return value{kind: boolValue, b: true}, string(data) == "true"
+	case 'f':
+		return value{kind: boolValue, b: false}, string(data) == "false"
+	case '"':
+		if len(data) < 2 || bytes.IndexByte(data, '\\') >= 0 || !utf8.Valid(data) {
+			return value{}, false
+		}
+		return value{kind: stringValue, s: string(data[1 : len(data)-1])}, true
+	case '-', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		v := parseNumber(string(data))
+		return v, v.kind != stringValue
+	}
+	return value{}, false
+}
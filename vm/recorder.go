@@ -0,0 +1,104 @@
+// This file contains the recorder, which allows the calls made to host
+// functions to be recorded, and later replayed.
+//
+// Scripts which depend upon external lookups are hard to debug, because
+// the results of those lookups change over time.  Recording the calls
+// during one run, then replaying them in another, allows the behaviour
+// of the script to be reproduced exactly.
+
+package vm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/skx/evalfilter/v2/object"
+)
+
+// HostCall records a single call to a host function.
+type HostCall struct {
+
+	// Name holds the name of the function which was called.
+	Name string
+
+	// Args holds the arguments the function was called with.
+	Args []object.Object
+
+	// Result holds the value the function returned.
+	Result object.Object
+}
+
+// String returns a description of the call, such as `lookup("steve")`.
+func (hc HostCall) String() string {
+
+	args := make([]string, len(hc.Args))
+	for i, a := range hc.Args {
+		args[i] = a.Inspect()
+		if a.Type() == object.STRING {
+			args[i] = fmt.Sprintf("%q", args[i])
+		}
+	}
+	return fmt.Sprintf("%s(%s)", hc.Name, strings.Join(args, ", "))
+}
+
+// Recorder records the calls made to host functions, or replays
+// previously recorded calls instead of invoking the host functions.
+type Recorder struct {
+
+	// Calls holds the calls which were made during the most recent
+	// run, when recording, or the calls to be replayed.
+	Calls []HostCall
+
+	// replay is true if we're replaying, rather than recording.
+	replay bool
+
+	// offset holds the index of the next call to replay.
+	offset int
+}
+
+// NewRecorder creates a recorder which records each call made to a
+// host function.
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// NewReplayer creates a recorder which replays the given calls, in
+// order, rather than invoking the host functions.
+func NewReplayer(calls []HostCall) *Recorder {
+	return &Recorder{Calls: calls, replay: true}
+}
+
+// reset prepares the recorder for a new run.
+func (r *Recorder) reset() {
+	if r.replay {
+		r.offset = 0
+	} else {
+		r.Calls = nil
+	}
+}
+
+// call invokes the given host function, recording the result, or
+// returns the next recorded result if we're replaying.
+func (r *Recorder) call(name string, fn func(args []object.Object) object.Object, args []object.Object) (object.Object, error) {
+
+	if !r.replay {
+		ret := fn(args)
+		r.Calls = append(r.Calls, HostCall{Name: name, Args: args, Result: ret})
+		return ret, nil
+	}
+
+	got := HostCall{Name: name, Args: args}
+
+	if r.offset >= len(r.Calls) {
+		return nil, fmt.Errorf("replay failed: there is no recorded result for the call %s", got)
+	}
+
+	// The call must match the one we recorded.
+	expected := r.Calls[r.offset]
+	if got.String() != expected.String() {
+		return nil, fmt.Errorf("replay failed: expected the call %s, but found %s", expected, got)
+	}
+
+	r.offset++
+	return expected.Result, nil
+}
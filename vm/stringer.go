@@ -0,0 +1,102 @@
+// This file contains the handling of fields which implement the error,
+// or fmt.Stringer, interfaces.
+//
+// Many types are only useful in their string form, for example net.IP
+// is a slice of bytes, and errors are usually opaque structures.  Such
+// values are presented to scripts as the strings they produce, so that
+// they may be compared against literals directly.
+
+package vm
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/skx/evalfilter/v2/object"
+)
+
+// durationType is the type of time.Duration, which implements
+// fmt.Stringer but is presented to scripts as a number.
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// SetStringerFields controls how structures which implement the error,
+// or fmt.Stringer, interfaces are presented to scripts.
+//
+// By default they're presented as the string they produce.  If enabled
+// they're presented as a hash of their fields instead, with the string
+// they produce available as the `Error`, or `String`, member.
+func (vm *VM) SetStringerFields(enable bool) {
+	vm.stringerFields = enable
+}
+
+// stringer converts the given value to one of our objects, if it
+// implements the error, or fmt.Stringer, interfaces.
+func (vm *VM) stringer(field reflect.Value) (out object.Object, ok bool) {
+
+	if !field.CanInterface() {
+		return nil, false
+	}
+
+	// Times get their own handling, even via pointers.
+	if inner, ok := indirect(field); ok {
+		if t := inner.Type(); t == timeType || t == durationType {
+			return nil, false
+		}
+	}
+
+	// Nil values are null, and calling their methods might panic.
+	switch field.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice:
+		if field.IsNil() {
+			return nil, false
+		}
+	}
+
+	// The method might be implemented upon a pointer.
+	val := field.Interface()
+	if !isStringer(val) && field.CanAddr() {
+		val = field.Addr().Interface()
+	}
+
+	// Broken methods shouldn't break the host.
+	defer func() {
+		if r := recover(); r != nil {
+			out, ok = nil, false
+		}
+	}()
+
+	var name, str string
+	switch v := val.(type) {
+	case error:
+		name, str = "Error", v.Error()
+	case fmt.Stringer:
+		name, str = "String", v.String()
+	default:
+		return nil, false
+	}
+
+	// Structures may be presented as their fields.
+	if vm.stringerFields {
+		inner, ok := indirect(field)
+		if ok && inner.Kind() == reflect.Struct {
+			hash := vm.createHashFromStruct(inner).(*object.Hash)
+			key := &object.String{Value: name}
+			hash.Pairs[key.HashKey()] = object.HashPair{Key: key, Value: &object.String{Value: str}}
+			return hash, true
+		}
+	}
+
+	return &object.String{Value: str}, true
+}
+
+// isStringer returns true if the value implements the error, or
+// fmt.Stringer, interfaces.
+func isStringer(val interface{}) bool {
+
+	switch val.(type) {
+	case error, fmt.Stringer:
+		return true
+	}
+	return false
+}
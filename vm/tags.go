@@ -0,0 +1,54 @@
+// This file contains the naming of the fields of structures via their
+// tags, so that scripts may refer to fields by the names they have when
+// they're serialized:
+//
+//    type Event struct {
+//        UserID int `json:"user_id"`
+//    }
+//
+// With the json tag in use scripts refer to `user_id` rather than
+// `UserID`.
+
+package vm
+
+import (
+	"reflect"
+	"strings"
+)
+
+// SetFieldTag sets the name of the struct tag which names the fields
+// of structures, such as "json", or disables the use of tags if given
+// the empty string.
+//
+// Fields without the tag, or whose tag gives no name, keep the name they
+// have in go, whilst fields whose tag is "-" are hidden.  Embedded
+// structures which are named by the tag are presented as a field of that
+// name, and their fields aren't promoted.
+func (vm *VM) SetFieldTag(tag string) {
+	vm.fieldTag = tag
+}
+
+// tagName returns the name of the given field, which is taken from the
+// given tag, if that names it, or the empty string if the tag hides it.
+//
+// The second result is true if the name was taken from the tag.
+func tagName(field reflect.StructField, tag string) (string, bool) {
+
+	if tag == "" {
+		return field.Name, false
+	}
+
+	val, ok := field.Tag.Lookup(tag)
+	if !ok {
+		return field.Name, false
+	}
+	if val == "-" {
+		return "", false
+	}
+
+	name := strings.SplitN(val, ",", 2)[0]
+	if name == "" {
+		return field.Name, false
+	}
+	return name, true
+}
@@ -0,0 +1,110 @@
+// This file contains the handling of times, which scripts see as our
+// object.Time rather than as the number of seconds past the epoch.
+//
+// Before times had a type of their own they were presented as integers,
+// so times may still be compared with numbers, which are then taken to
+// be seconds past the epoch.  This means that scripts such as:
+//
+//	return Created > now() - 1h;
+//
+// continue to work.
+
+package vm
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/skx/evalfilter/v2/code"
+	"github.com/skx/evalfilter/v2/object"
+)
+
+// evalTimeInfixExpression applies the given operator to operands, at
+// least one of which is a time.
+//
+// Times are compared with each other, and subtracting one from another
+// gives the seconds between them.  Adding, or subtracting, a number of
+// seconds to a time gives another time, whilst otherwise times are
+// used with numbers as the seconds past the epoch.
+func (vm *VM) evalTimeInfixExpression(op code.Opcode, left, right object.Object) error {
+
+	lt, leftTime := left.(*object.Time)
+	rt, rightTime := right.(*object.Time)
+
+	if leftTime && rightTime {
+		a, b := lt.Value, rt.Value
+		switch op {
+		case code.OpEqual:
+			vm.stack.Push(vm.nativeBoolToBooleanObject(a.Equal(b)))
+		case code.OpNotEqual:
+			vm.stack.Push(vm.nativeBoolToBooleanObject(!a.Equal(b)))
+		case code.OpLess:
+			vm.stack.Push(vm.nativeBoolToBooleanObject(a.Before(b)))
+		case code.OpLessEqual:
+			vm.stack.Push(vm.nativeBoolToBooleanObject(!a.After(b)))
+		case code.OpGreater:
+			vm.stack.Push(vm.nativeBoolToBooleanObject(a.After(b)))
+		case code.OpGreaterEqual:
+			vm.stack.Push(vm.nativeBoolToBooleanObject(!a.Before(b)))
+		case code.OpSub:
+			vm.stack.Push(&object.Float{Value: a.Sub(b).Seconds()})
+		default:
+			return fmt.Errorf("unknown operator: %s %s %s",
+				left.Type(), code.String(op), right.Type())
+		}
+		return nil
+	}
+
+	// A time plus, or minus, a number of seconds is another time.
+	if leftTime && (op == code.OpAdd || op == code.OpSub) {
+		if secs, ok := seconds(right); ok {
+			if op == code.OpSub {
+				secs = -secs
+			}
+			vm.stack.Push(&object.Time{Value: lt.Value.Add(secs)})
+			return nil
+		}
+	}
+	if rightTime && op == code.OpAdd {
+		if secs, ok := seconds(left); ok {
+			vm.stack.Push(&object.Time{Value: rt.Value.Add(secs)})
+			return nil
+		}
+	}
+
+	// Otherwise a time is seconds past the epoch, which may only be
+	// used with another number.
+	other := right
+	if rightTime {
+		other = left
+	}
+	if other.Type() != object.INTEGER && other.Type() != object.FLOAT {
+		return fmt.Errorf("type mismatch: %s %s %s",
+			left.Type(), code.String(op), right.Type())
+	}
+	if leftTime {
+		left = lt.Seconds()
+	}
+	if rightTime {
+		right = rt.Seconds()
+	}
+	vm.stack.Push(left)
+	vm.stack.Push(right)
+	return vm.executeBinaryOperation(op)
+}
+
+// seconds returns the duration of the given number of seconds.
+func seconds(obj object.Object) (time.Duration, bool) {
+
+	switch n := obj.(type) {
+	case *object.Integer:
+		return time.Duration(n.Value) * time.Second, true
+	case *object.Float:
+		if math.IsInf(n.Value, 0) || math.IsNaN(n.Value) {
+			return 0, false
+		}
+		return time.Duration(n.Value * float64(time.Second)), true
+	}
+	return 0, false
+}
@@ -0,0 +1,225 @@
+// This file contains the tracer, which records each instruction executed
+// during a run, along with the contents of the stack.
+//
+// The most common question asked of a script is "why did this event
+// match, when that one didn't?".  Recording the traces of both runs, and
+// comparing them via `DiffTraces`, shows the point at which they went
+// their separate ways.
+
+package vm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/skx/evalfilter/v2/code"
+)
+
+// Step records a single instruction which was executed.
+type Step struct {
+
+	// Function holds the name of the function containing the
+	// instruction, or "main" for the main program.
+	Function string
+
+	// Offset holds the offset of the instruction within the bytecode
+	// of the function.
+	Offset int
+
+	// Opcode holds the instruction itself.
+	Opcode code.Opcode
+
+	// Arg holds the argument of the instruction, if it has one.
+	Arg int
+
+	// Stack holds the contents of the stack, before the instruction
+	// was executed.
+	Stack []string
+}
+
+// Instruction describes the instruction of the step, such as
+// "main:0004 OpLookup 0002".
+func (s Step) Instruction() string {
+	if code.Length(s.Opcode) > 1 {
+		return fmt.Sprintf("%s:%04d %s %04d", s.Function, s.Offset, code.String(s.Opcode), s.Arg)
+	}
+	return fmt.Sprintf("%s:%04d %s", s.Function, s.Offset, code.String(s.Opcode))
+}
+
+// String describes the step, including the stack.
+func (s Step) String() string {
+	return fmt.Sprintf("%s [%s]", s.Instruction(), strings.Join(s.Stack, ", "))
+}
+
+// same returns true if the two steps are identical.
+func (s Step) same(o Step) bool {
+	return s.String() == o.String()
+}
+
+// Tracer records the steps taken by a machine, during its most recent
+// run.
+type Tracer struct {
+
+	// Steps holds the steps which were recorded.
+	Steps []Step
+
+	// Limit holds the maximum number of steps which are recorded,
+	// with zero meaning there is no limit.
+	Limit int
+
+	// Truncated is true if more steps were taken than were recorded.
+	Truncated bool
+
+	// Rule holds the name of the rule which was run, if the script
+	// was run as part of a RuleSet.
+	Rule string
+}
+
+// NewTracer creates a tracer, which records each step of a run.
+func NewTracer() *Tracer {
+	return &Tracer{}
+}
+
+// SetTracer sets the tracer which records each step of a run, or
+// removes it if nil.
+//
+// A machine with a tracer never evaluates its program as a predicate,
+// so that each instruction is seen.
+func (vm *VM) SetTracer(t *Tracer) {
+	vm.tracer = t
+}
+
+// reset prepares the tracer for a new run.
+func (t *Tracer) reset() {
+	t.Steps = nil
+	t.Truncated = false
+	t.Rule = ""
+}
+
+// record records that the machine is about to execute the given
+// instruction.
+func (t *Tracer) record(vm *VM, ip int, op code.Opcode, arg int) {
+
+	if t.Limit > 0 && len(t.Steps) >= t.Limit {
+		t.Truncated = true
+		return
+	}
+
+	fn := "main"
+	if len(vm.frames) > 0 {
+		fn = vm.frames[len(vm.frames)-1].name
+	}
+	t.Steps = append(t.Steps, Step{
+		Function: fn,
+		Offset:   ip,
+		Opcode:   op,
+		Arg:      arg,
+		Stack:    vm.stack.Export(),
+	})
+}
+
+// TraceDiff describes the differences between two traces.
+type TraceDiff struct {
+
+	// Same holds the number of steps, at the start of each trace,
+	// which were identical.
+	Same int
+
+	// Diverged holds the number of steps, at the start of each trace,
+	// which executed the same instructions - even if the contents of
+	// the stack differed.  When an event matches, and another
+	// doesn't, this is where the decision was made.
+	Diverged int
+
+	// Left holds the steps of the first trace, from the first which
+	// differed.
+	Left []Step
+
+	// Right holds the steps of the second trace, from the first which
+	// differed.
+	Right []Step
+}
+
+// DiffTraces compares the two traces.
+func DiffTraces(left, right []Step) *TraceDiff {
+
+	d := &TraceDiff{}
+
+	for d.Same < len(left) && d.Same < len(right) && left[d.Same].same(right[d.Same]) {
+		d.Same++
+	}
+
+	d.Diverged = d.Same
+	for d.Diverged < len(left) && d.Diverged < len(right) &&
+		left[d.Diverged].Instruction() == right[d.Diverged].Instruction() {
+		d.Diverged++
+	}
+
+	d.Left = left[d.Same:]
+	d.Right = right[d.Same:]
+	return d
+}
+
+// Identical returns true if the traces were the same.
+func (d *TraceDiff) Identical() bool {
+	return len(d.Left) == 0 && len(d.Right) == 0
+}
+
+// Describe describes the differences, showing at most the given number
+// of steps from each trace after the point at which they diverged.
+//
+// The steps of the first trace are prefixed with "-", and those of the
+// second with "+".
+func (d *TraceDiff) Describe(limit int) string {
+
+	if d.Identical() {
+		return fmt.Sprintf("The traces are identical, %d steps.\n", d.Same)
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "The first %d steps are identical.\n", d.Same)
+
+	// The steps which ran the same instructions, with different
+	// contents of the stack.
+	n := d.Diverged - d.Same
+	if n > 0 {
+		fmt.Fprintf(&out, "\nThe following %d steps differ in the contents of the stack:\n", n)
+		for i := 0; i < n; i++ {
+			if i == limit {
+				fmt.Fprintf(&out, "  ...\n")
+				break
+			}
+			fmt.Fprintf(&out, "- %s\n", d.Left[i])
+			fmt.Fprintf(&out, "+ %s\n", d.Right[i])
+		}
+	}
+
+	// Then the instructions which differ.
+	left := d.Left[n:]
+	right := d.Right[n:]
+	if len(left) == 0 && len(right) == 0 {
+		return out.String()
+	}
+	fmt.Fprintf(&out, "\nThe traces diverge after %d steps:\n", d.Diverged)
+	for _, s := range limitSteps(left, limit) {
+		fmt.Fprintf(&out, "- %s\n", s)
+	}
+	if len(left) > limit {
+		fmt.Fprintf(&out, "- ... %d more steps\n", len(left)-limit)
+	}
+	for _, s := range limitSteps(right, limit) {
+		fmt.Fprintf(&out, "+ %s\n", s)
+	}
+	if len(right) > limit {
+		fmt.Fprintf(&out, "+ ... %d more steps\n", len(right)-limit)
+	}
+	return out.String()
+}
+
+// limitSteps returns, at most, the given number of steps.
+func limitSteps(steps []Step, max int) []Step {
+	if len(steps) > max {
+		return steps[:max]
+	}
+	return steps
+}
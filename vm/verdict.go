@@ -0,0 +1,144 @@
+// This file contains the functions which allow a script to grade the
+// object it is run against, rather than only matching it or not:
+//
+//    if ( Failures > 10 ) {
+//        score( Failures / 10 );
+//        severity( "high" );
+//    }
+//    return Failures > 0;
+//
+// The score of each call to `score` is added together, and the highest
+// severity given to `severity` is kept, throughout a single run.
+
+package vm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/skx/evalfilter/v2/object"
+)
+
+// Severity describes how serious the object a script matched is.
+//
+// Severities are ordered, so that the most serious of several may be
+// found by comparing them.
+type Severity int
+
+// The severities a script may give, which are the levels of Sigma rules.
+const (
+	// SeverityNone is the severity of a run which didn't give one.
+	SeverityNone Severity = iota
+
+	// SeverityInformational is given via `severity("informational")`,
+	// or `severity("info")`.
+	SeverityInformational
+
+	// SeverityLow is given via `severity("low")`.
+	SeverityLow
+
+	// SeverityMedium is given via `severity("medium")`.
+	SeverityMedium
+
+	// SeverityHigh is given via `severity("high")`.
+	SeverityHigh
+
+	// SeverityCritical is given via `severity("critical")`.
+	SeverityCritical
+)
+
+// severities holds the names of our severities, in order.
+var severities = []string{"none", "informational", "low", "medium", "high", "critical"}
+
+// String returns the name of the severity.
+func (s Severity) String() string {
+	if s < 0 || int(s) >= len(severities) {
+		return fmt.Sprintf("Severity(%d)", int(s))
+	}
+	return severities[s]
+}
+
+// ParseSeverity returns the severity with the given name, which is
+// matched without regard to case.
+func ParseSeverity(name string) (Severity, error) {
+
+	name = strings.ToLower(name)
+	if name == "info" {
+		return SeverityInformational, nil
+	}
+	for i, s := range severities {
+		if name == s {
+			return Severity(i), nil
+		}
+	}
+	return SeverityNone, fmt.Errorf("unknown severity '%s', expected one of %s", name, strings.Join(severities[1:], ", "))
+}
+
+// Score returns the sum of the values the last run gave to `score`.
+func (vm *VM) Score() float64 {
+	return vm.score
+}
+
+// Severity returns the highest severity the last run gave to `severity`,
+// or SeverityNone if it gave none.
+func (vm *VM) Severity() Severity {
+	return vm.severity
+}
+
+// grading returns the implementation of the named function, if it is one
+// which grades the run.
+//
+// These are implemented by the machine itself, as they record the grade
+// upon it, and are used unless a function, or variable, of the same name
+// exists.
+func grading(name string) (func(vm *VM, obj interface{}, ip int, args []object.Object) (object.Object, error), bool) {
+
+	switch name {
+	case "score":
+		return fnScore, true
+	case "severity":
+		return fnSeverity, true
+	}
+	return nil, false
+}
+
+// fnScore implements `score`, which adds the given number to the score
+// of the run.
+func fnScore(vm *VM, obj interface{}, ip int, args []object.Object) (object.Object, error) {
+
+	if len(args) != 1 {
+		return nil, fmt.Errorf("score requires a single argument, a number")
+	}
+
+	switch n := args[0].(type) {
+	case *object.Integer:
+		vm.score += float64(n.Value)
+	case *object.Float:
+		vm.score += n.Value
+	default:
+		return nil, fmt.Errorf("the argument to score must be a number, not %s", args[0].Type())
+	}
+	return Void, nil
+}
+
+// fnSeverity implements `severity`, which raises the severity of the run
+// to the one named, if that is higher.
+func fnSeverity(vm *VM, obj interface{}, ip int, args []object.Object) (object.Object, error) {
+
+	if len(args) != 1 {
+		return nil, fmt.Errorf("severity requires a single argument, the name of a severity")
+	}
+
+	str, ok := args[0].(*object.String)
+	if !ok {
+		return nil, fmt.Errorf("the argument to severity must be a string, not %s", args[0].Type())
+	}
+	s, err := ParseSeverity(str.Value)
+	if err != nil {
+		return nil, err
+	}
+	if s > vm.severity {
+		vm.severity = s
+	}
+	return Void, nil
+}
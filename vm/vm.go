@@ -14,6 +14,7 @@ import (
 	"fmt"
 	"math"
 	"reflect"
+	"strconv"
 	"strings"
 	"time"
 	"unicode/utf8"
@@ -34,17 +35,21 @@ import (
 type BytecodeVisitor func(offset int, instruction code.Opcode, argument interface{}) (bool, error)
 
 // True is our global "true" object.
-var True = &object.Boolean{Value: true}
+var True = object.True
 
 // False is our global "false" object.
-var False = &object.Boolean{Value: false}
+var False = object.False
 
 // Null is our global "null" object.
-var Null = &object.Null{}
+var Null = object.Nil
 
 // Void is our global "void" object.
 var Void = &object.Void{}
 
+// timeType is the type of time.Time, which gets special handling when
+// we convert values to our own objects.
+var timeType = reflect.TypeOf(time.Time{})
+
 // VM is the structure which holds our state.
 type VM struct {
 
@@ -81,6 +86,113 @@ type VM struct {
 	// functions that are defined in our scripting language
 	functions map[string]environment.UserFunction
 
+	// mode controls whether the script may modify the object it is
+	// run against.
+	mode EventMode
+
+	// origins maps the hashes and arrays which were converted from
+	// the object to the values they were converted from.  It is only
+	// populated if our mode is read-only or read-write, and allows
+	// modifications to them to be rejected or applied.
+	origins map[object.Object]reflect.Value
+
+	// frames holds the state of each caller, while a user-defined
+	// function is executing.
+	frames []*frame
+
+	// traced is true once the call-stack which led to an error has
+	// been reported.
+	traced bool
+
+	// recorder, if set, records or replays the calls made to host
+	// functions.
+	recorder *Recorder
+
+	// tracer records each instruction we execute, if it is set.
+	tracer *Tracer
+
+	// observations holds the values recorded by OpExplain.
+	observations []Observation
+
+	// memoryLimit holds the approximate number of bytes which may be
+	// allocated during a run, or zero if there is no limit.
+	memoryLimit int64
+
+	// allocated holds the approximate number of bytes allocated
+	// during the current run.
+	allocated int64
+
+	// maxInstructions holds the number of instructions which may be
+	// executed during a run, or zero if there is no limit.
+	maxInstructions int
+
+	// executed holds the number of instructions executed during the
+	// current run, when there is a limit.
+	executed int
+
+	// loops describes the loops within the program.
+	loops []Loop
+
+	// iterations holds the number of iterations each loop has made.
+	iterations []int
+
+	// loopLimit holds the number of iterations a loop may make, unless
+	// it has a limit of its own.
+	loopLimit int
+
+	// converting holds the pointers we're currently converting to our
+	// own objects, so that cycles are not followed forever.
+	converting map[uintptr]bool
+
+	// stringerFields is true if structures which implement the error,
+	// or fmt.Stringer, interfaces are presented as their fields.
+	stringerFields bool
+
+	// fieldTag is the name of the struct tag which gives the names of
+	// the fields of structures, if any.
+	fieldTag string
+
+	// language is the version of the language our program is run
+	// by, or zero for Language1.
+	language int
+
+	// predicate holds our program, if it consists only of
+	// comparisons and boolean logic, so that it may be evaluated
+	// without creating objects.
+	predicate *predicate
+
+	// noPredicate disables the evaluation of our predicate.
+	noPredicate bool
+
+	// arena holds the temporary objects we create during a run.
+	arena arena
+
+	// slots holds the values of the lookups the optimizer has moved
+	// out of loops.
+	slots []object.Object
+
+	// bound holds the values of the fields found by OpLookupField
+	// during the current run, by the index of their names within
+	// the constants.
+	bound []object.Object
+
+	// sources holds the positions, within the script, of the
+	// instructions of the bytecode we're executing, if they're known.
+	sources code.SourceMap
+
+	// located is true once the position of an error, during the
+	// current run, has been reported.
+	located bool
+
+	// failed holds the position of the error which stopped the
+	// current run, if it is known.
+	failed code.Position
+
+	// score holds the sum of the values the current run has given to
+	// `score`, and severity the highest it has given to `severity`.
+	score    float64
+	severity Severity
+
 	// stack holds a pointer to our stack-object.
 	//
 	// We're a stack-based virtual machine so this is used for
@@ -94,6 +206,16 @@ type VM struct {
 // we'll also run a series of simple optimizer steps.  These are naive,
 // but do speedup carefully constructed test cases.
 func New(constants []object.Object, bytecode code.Instructions, functions map[string]environment.UserFunction, env *environment.Environment) *VM {
+	return NewWithSources(constants, bytecode, nil, functions, env)
+}
+
+// NewWithSources constructs a new virtual machine, as New does, given the
+// positions within the script of the instructions of the bytecode.
+//
+// Errors which occur when running the program then report the position
+// of the instruction which failed, as do those within functions which
+// have sources of their own.
+func NewWithSources(constants []object.Object, bytecode code.Instructions, sources code.SourceMap, functions map[string]environment.UserFunction, env *environment.Environment) *VM {
 
 	// If we have a `DEBUG` environment then we enable debugging.
 	_, debug := env.Get("DEBUG")
@@ -107,11 +229,13 @@ func New(constants []object.Object, bytecode code.Instructions, functions map[st
 	// Create the machine
 	vm := &VM{
 		bytecode:    bytecode,
+		sources:     sources,
 		constants:   constants,
 		debug:       debug,
 		environment: env,
 		functions:   functions,
 		stack:       stack.New(),
+		loopLimit:   DefaultLoopLimit,
 	}
 
 	// Set a default context
@@ -133,10 +257,10 @@ func New(constants []object.Object, bytecode code.Instructions, functions map[st
 		for name, fun := range functions {
 
 			// Save the main bytecode away
-			safe := vm.bytecode
+			safe, sources := vm.bytecode, vm.sources
 
 			// Replace it with the bytecode from the function
-			vm.bytecode = fun.Bytecode
+			vm.bytecode, vm.sources = fun.Bytecode, fun.Sources
 
 			// Tweak it
 			saved := vm.optimizeBytecode()
@@ -146,18 +270,84 @@ func New(constants []object.Object, bytecode code.Instructions, functions map[st
 			}
 
 			// Save it away
-			fun.Bytecode = vm.bytecode
+			fun.Bytecode, fun.Sources = vm.bytecode, vm.sources
 			tmp[name] = fun
 
 			// And reset the saved vm-bytecode
-			vm.bytecode = safe
+			vm.bytecode, vm.sources = safe, sources
 		}
 		vm.functions = tmp
+
+		//
+		// And the bodies of anonymous functions.
+		//
+		for _, c := range vm.constants {
+			fun, ok := c.(*object.Function)
+			if !ok {
+				continue
+			}
+			safe, sources := vm.bytecode, vm.sources
+			vm.bytecode, vm.sources = fun.Bytecode, fun.Sources
+			vm.optimizeBytecode()
+			fun.Bytecode, fun.Sources = vm.bytecode, vm.sources
+			vm.bytecode, vm.sources = safe, sources
+		}
+
+		// Finally move the lookups which loops don't change
+		// out of them.
+		hoisted := vm.hoistLookups()
+		if debug && hoisted > 0 {
+			fmt.Printf("Bytecode optimizer hoisted %d lookups out of loops\n", hoisted)
+		}
+	}
+
+	// If the program is a predicate we can evaluate it without
+	// the machine - unless we're showing our execution.
+	if !debug {
+		vm.predicate = newPredicate(vm.constants, vm.bytecode)
 	}
 
 	return vm
 }
 
+// Clone returns a new machine which runs the same program as this one,
+// with the same settings, against the given environment.
+//
+// The bytecode and constants are shared, as they're never modified, but
+// the state used whilst running is not, so the machine which is returned
+// may be run concurrently with this one.  Any recorder, or tracer, is not
+// copied, as they may not be shared.
+func (vm *VM) Clone(env *environment.Environment) *VM {
+
+	c := &VM{
+		bytecode:        vm.bytecode,
+		sources:         vm.sources,
+		constants:       vm.constants,
+		context:         vm.context,
+		debug:           vm.debug,
+		environment:     env,
+		functions:       vm.functions,
+		mode:            vm.mode,
+		memoryLimit:     vm.memoryLimit,
+		maxInstructions: vm.maxInstructions,
+		loopLimit:       vm.loopLimit,
+		stringerFields:  vm.stringerFields,
+		fieldTag:        vm.fieldTag,
+		language:        vm.language,
+		noPredicate:     vm.noPredicate,
+		stack:           stack.New(),
+	}
+	if vm.slots != nil {
+		c.slots = make([]object.Object, len(vm.slots))
+	}
+	c.SetLoops(vm.loops)
+	c.arena.disabled = vm.arena.disabled
+	if vm.predicate != nil {
+		c.predicate = vm.predicate.clone()
+	}
+	return c
+}
+
 // SetContext allows a context to be used as our virtual machine is
 // running. This is most used to allow our caller to setup a
 // timeout/deadline which will avoid denial-of-service problems if
@@ -166,6 +356,51 @@ func (vm *VM) SetContext(ctx context.Context) {
 	vm.context = ctx
 }
 
+// RunContext runs our program, as Run does, but with the given context
+// rather than that given to SetContext.
+//
+// The run is aborted with an error if the context is cancelled, or its
+// deadline passes, before the program finishes.
+func (vm *VM) RunContext(ctx context.Context, obj interface{}) (object.Object, error) {
+
+	prev := vm.context
+	vm.context = ctx
+	defer func() { vm.context = prev }()
+
+	// Don't start a run which can't finish.
+	if ctx.Err() != nil {
+		return Null, vm.contextError()
+	}
+	return vm.Run(obj)
+}
+
+// ErrorPosition returns the position, within the script, of the error
+// which stopped the most recent run - or the zero position if it isn't
+// known.
+func (vm *VM) ErrorPosition() code.Position {
+	return vm.failed
+}
+
+// contextError returns the error which aborts a run, when our context is
+// done.
+//
+// The error of the context is wrapped, so that errors.Is may be used to
+// find context.Canceled, or context.DeadlineExceeded.
+func (vm *VM) contextError() error {
+	if vm.context.Err() == context.Canceled {
+		return fmt.Errorf("execution cancelled: %w", vm.context.Err())
+	}
+	return fmt.Errorf("timeout during execution: %w", vm.context.Err())
+}
+
+// SetRecorder allows the calls made to host functions to be recorded,
+// or replayed, via the given recorder.
+//
+// Passing nil disables recording.
+func (vm *VM) SetRecorder(r *Recorder) {
+	vm.recorder = r
+}
+
 // Run launches our virtual machine, interpreting the bytecode-program we were
 // constructed with.
 //
@@ -176,7 +411,7 @@ func (vm *VM) SetContext(ctx context.Context) {
 // (Our compiler only implements the 'while' loop for control-flow, but it
 // is possible  a hand-created program could build such a things via the
 // instruction-set.)
-func (vm *VM) Run(obj interface{}) (object.Object, error) {
+func (vm *VM) Run(obj interface{}) (out object.Object, err error) {
 
 	//
 	// Sanity-check the bytecode program is non-empty
@@ -186,9 +421,52 @@ func (vm *VM) Run(obj interface{}) (object.Object, error) {
 	}
 
 	//
-	// Make an empty map to store field/map contents.
+	// Each run is graded afresh.
 	//
-	vm.fields = make(map[string]object.Object)
+	vm.score, vm.severity = 0, SeverityNone
+
+	//
+	// As is the count of the instructions executed.
+	//
+	vm.executed = 0
+
+	//
+	// Predicates may be evaluated without creating objects, though
+	// anything unusual means we must use the machine after all.
+	//
+	if vm.IsPredicate() && vm.tracer == nil && vm.predicateWithinBudget() {
+		if out, ok := vm.runPredicate(obj); ok {
+			vm.executed = len(vm.predicate.ops)
+			return out, nil
+		}
+	}
+
+	//
+	// Make an empty map to store field/map contents, reusing that of
+	// the previous run so that a machine which is run against many
+	// objects doesn't allocate a new one each time.
+	//
+	if vm.fields == nil {
+		vm.fields = make(map[string]object.Object)
+	} else {
+		for name := range vm.fields {
+			delete(vm.fields, name)
+		}
+	}
+
+	//
+	// Unless the object may be modified freely we need to know
+	// where the hashes and arrays we convert came from.
+	//
+	if vm.mode == EventShadow {
+		vm.origins = nil
+	} else if vm.origins == nil {
+		vm.origins = make(map[object.Object]reflect.Value)
+	} else {
+		for obj := range vm.origins {
+			delete(vm.origins, obj)
+		}
+	}
 
 	//
 	// When built-in functions are invoked their return value is stored
@@ -209,10 +487,116 @@ func (vm *VM) Run(obj interface{}) (object.Object, error) {
 	vm.stack.Clear()
 
 	//
-	// Instruction pointer and length of bytecode.
+	// Each run is recorded, or replayed, from the start.
+	//
+	if vm.recorder != nil {
+		vm.recorder.reset()
+	}
+	if vm.tracer != nil {
+		vm.tracer.reset()
+	}
+	vm.observations = nil
+
+	//
+	// The memory limit applies to each run.
+	//
+	vm.allocated = 0
+
+	//
+	// The temporaries we create are reused by the next run, unless
+	// they might still be referred to, and our result is copied so
+	// that the caller may keep it.
+	//
+	// Variables which were set by the host are updated in place by
+	// the next set, unless they might still be referred to.
+	//
+	defer func() {
+		switch out.(type) {
+		case *object.Integer, *object.Float, *object.String:
+			if vm.arena.disabled {
+				vm.arena.escape()
+			}
+		case *object.Boolean:
+			if out != True && out != False {
+				vm.arena.escape()
+			}
+		}
+		if vm.arena.escaped {
+			vm.environment.Disown()
+		}
+
+		out = vm.arena.keep(out)
+		vm.arena.release()
+
+		for i := range vm.slots {
+			vm.slots[i] = nil
+		}
+		for i := range vm.bound {
+			vm.bound[i] = nil
+		}
+	}()
+	if vm.recorder != nil {
+		vm.arena.escape()
+	}
+
+	//
+	// If the script returns from the middle of a foreach loop then
+	// the scope the loop created will still be present.  Discard
+	// any such scopes when we're done, so they don't accumulate
+	// over subsequent runs.
+	//
+	depth := vm.environment.ScopeDepth()
+	defer vm.environment.DropScopes(depth)
+
+	//
+	// Similarly if we terminate with an error in the middle of a
+	// function we need to restore the bytecode of the main program.
+	//
+	defer vm.resetFrames()
+
+	vm.traced = false
+	vm.located = false
+	vm.failed = code.Position{}
+	return vm.execute(obj, 0)
+}
+
+// execute interprets our bytecode, until it returns to the caller which
+// had made the given number of calls.
+//
+// The main program is run with no calls, whilst the functions invoked by
+// the higher-order functions, such as `map`, are run with those which led
+// to them.
+func (vm *VM) execute(obj interface{}, base int) (out object.Object, err error) {
+
+	//
+	// Instruction pointer.
 	//
 	ip := 0
-	ln := len(vm.bytecode)
+
+	//
+	// If an error occurs within a function then report the call-stack
+	// which led there, which makes it easier to track down the problem.
+	// The error is wrapped, so that it may still be found via errors.Is.
+	//
+	// An error within a function invoked by `map`, for example, will
+	// have been reported by the time it reaches its caller.
+	//
+	defer func() {
+		if _, budget := err.(*InstructionLimitError); budget {
+			return
+		}
+		if err != nil && !vm.located {
+			if pos, ok := vm.sources[ip]; ok {
+				err = fmt.Errorf("%w at %s", err, pos)
+				vm.failed = pos
+			}
+			vm.located = true
+		}
+		if err != nil && len(vm.frames) > 0 && !vm.traced {
+			err = fmt.Errorf("%w - in %s", err, vm.trace(ip))
+			vm.traced = true
+		}
+	}()
 
 	//
 	// Loop over all the bytecode.
@@ -221,7 +605,21 @@ func (vm *VM) Run(obj interface{}) (object.Object, error) {
 	// is possible this function will run forever, and never terminate.
 	// This is why we allow `SetContext` to setup a timeout-period.
 	//
-	for ip < ln {
+	for {
+
+		//
+		// If we've run off the end of our bytecode then we're
+		// done - unless we're inside a function, in which case
+		// we return to the caller.
+		//
+		if ip >= len(vm.bytecode) {
+			if len(vm.frames) == base {
+				break
+			}
+			ip = vm.popFrame() + code.Length(code.OpCall)
+			vm.stack.Push(Null)
+			continue
+		}
 
 		//
 		// We've been given a context, which we'll test at every
@@ -232,12 +630,18 @@ func (vm *VM) Run(obj interface{}) (object.Object, error) {
 		//
 		select {
 		case <-vm.context.Done():
-			return &object.Null{},
-				fmt.Errorf("timeout during execution")
+			return Null, vm.contextError()
 		default:
 			// nop
 		}
 
+		//
+		// And that we've not exceeded our instruction limit.
+		//
+		if err := vm.spend(); err != nil {
+			return Null, err
+		}
+
 		//
 		// Get the next opcode
 		//
@@ -263,10 +667,18 @@ func (vm *VM) Run(obj interface{}) (object.Object, error) {
 			opArg = int(binary.BigEndian.Uint16(vm.bytecode[ip+1 : ip+3]))
 		}
 
+		if vm.tracer != nil {
+			vm.tracer.record(vm, ip, op, opArg)
+		}
+
 		if vm.debug {
 			fmt.Printf("\n\tStack: [%s]\n",
 				strings.Join(vm.stack.Export(), ", "))
 
+			if len(vm.frames) > 0 {
+				fmt.Printf("[%s]\n", vm.trace(ip))
+			}
+
 			if opLen > 1 {
 				fmt.Printf("%04d\t%s\t%04d\n", ip, code.String(op), opArg)
 			} else {
@@ -284,7 +696,7 @@ func (vm *VM) Run(obj interface{}) (object.Object, error) {
 
 			// Store an integer upon the stack
 		case code.OpPush:
-			vm.stack.Push(&object.Integer{Value: int64(opArg)})
+			vm.stack.Push(vm.arena.integer(int64(opArg)))
 
 			// Lookup variable/field, by name
 		case code.OpConstant:
@@ -310,6 +722,55 @@ func (vm *VM) Run(obj interface{}) (object.Object, error) {
 			val := vm.lookup(obj, name)
 			vm.stack.Push(val)
 
+			// Lookup a field of the schema, which the script never
+			// changes, and so needs to be found only once.
+		case code.OpLookupField:
+
+			if opArg >= len(vm.constants) {
+				return nil, fmt.Errorf("access to constant which doesn't exist")
+			}
+			if vm.bound == nil {
+				vm.bound = make([]object.Object, len(vm.constants))
+			}
+
+			val := vm.bound[opArg]
+			if val == nil {
+				val = vm.lookup(obj, vm.constants[opArg].Inspect())
+				vm.bound[opArg] = val
+			}
+			vm.stack.Push(val)
+
+			// Lookup a path of fields, such as `a.b.c`.
+		case code.OpLookupPath:
+
+			if opArg >= len(vm.constants) {
+				return nil, fmt.Errorf("access to constant which doesn't exist")
+			}
+
+			val, err := vm.lookupPath(obj, vm.constants[opArg].Inspect())
+			if err != nil {
+				return nil, err
+			}
+			vm.stack.Push(val)
+
+			// Store the value of a lookup moved out of a loop.
+		case code.OpStoreSlot:
+			if opArg >= len(vm.slots) {
+				return nil, fmt.Errorf("access to slot which doesn't exist")
+			}
+			val, err := vm.stack.Pop()
+			if err != nil {
+				return nil, err
+			}
+			vm.slots[opArg] = val
+
+			// Load the value of a lookup moved out of a loop.
+		case code.OpLoadSlot:
+			if opArg >= len(vm.slots) {
+				return nil, fmt.Errorf("access to slot which doesn't exist")
+			}
+			vm.stack.Push(vm.slots[opArg])
+
 			// Setup a local variable, by name
 		case code.OpLocal:
 			name, err := vm.stack.Pop()
@@ -335,7 +796,10 @@ func (vm *VM) Run(obj interface{}) (object.Object, error) {
 				return nil, err
 			}
 
-			vm.environment.Set(name.Inspect(), val)
+			err = vm.setVariable(obj, name.Inspect(), val)
+			if err != nil {
+				return nil, err
+			}
 
 			// maths & comparisons
 		case code.OpAdd, // addition
@@ -371,11 +835,14 @@ func (vm *VM) Run(obj interface{}) (object.Object, error) {
 			// to be present upon the stack.
 
 			// Make the array of the appropriate size
+			err := vm.allocate(int64(opArg) * elementSize)
+			if err != nil {
+				return nil, err
+			}
 			elements := make([]object.Object, opArg)
 
 			// Add on each entry.
 			for opArg > 0 {
-				var err error
 				elements[opArg-1], err = vm.stack.Pop()
 				if err != nil {
 					return nil, err
@@ -384,12 +851,18 @@ func (vm *VM) Run(obj interface{}) (object.Object, error) {
 			}
 
 			// Construct the actual array and add to the stack
+			vm.arena.escape()
 			arr := &object.Array{Elements: elements}
 			vm.stack.Push(arr)
 
 			// Store a hash
 		case code.OpHash:
 
+			err := vm.allocate(int64(opArg/2) * pairSize)
+			if err != nil {
+				return nil, err
+			}
+
 			hashedPairs := make(map[object.HashKey]object.HashPair)
 
 			for i := 0; i < opArg; i += 2 {
@@ -413,6 +886,7 @@ func (vm *VM) Run(obj interface{}) (object.Object, error) {
 
 				hashedPairs[hashKey.HashKey()] = pair
 			}
+			vm.arena.escape()
 			hash := &object.Hash{Pairs: hashedPairs}
 			vm.stack.Push(hash)
 
@@ -463,6 +937,110 @@ func (vm *VM) Run(obj interface{}) (object.Object, error) {
 				return nil, err
 			}
 
+			// Field of a hash, for `a.b`, which is null for null.
+		case code.OpField:
+			index, err := vm.stack.Pop()
+			if err != nil {
+				return nil, err
+			}
+			left, err := vm.stack.Pop()
+			if err != nil {
+				return nil, err
+			}
+
+			if left.Type() == object.NULL {
+				vm.stack.Push(Null)
+				break
+			}
+			err = vm.executeIndexExpression(left, index)
+			if err != nil {
+				return nil, err
+			}
+
+			// Slice an array/string
+		case code.OpSlice:
+			end, err := vm.stack.Pop()
+			if err != nil {
+				return nil, err
+			}
+			start, err := vm.stack.Pop()
+			if err != nil {
+				return nil, err
+			}
+			left, err := vm.stack.Pop()
+			if err != nil {
+				return nil, err
+			}
+
+			err = vm.executeSliceExpression(left, start, end)
+			if err != nil {
+				return nil, err
+			}
+
+			// Search for a needle, via the `contains` function.
+		case code.OpContains:
+			needle, err := vm.stack.Pop()
+			if err != nil {
+				return nil, err
+			}
+			haystack, err := vm.stack.Pop()
+			if err != nil {
+				return nil, err
+			}
+
+			vm.stack.Push(environment.Contains(haystack, needle))
+
+			// Update an array/hash member
+		case code.OpSetIndex:
+			value, err := vm.stack.Pop()
+			if err != nil {
+				return nil, err
+			}
+			index, err := vm.stack.Pop()
+			if err != nil {
+				return nil, err
+			}
+			left, err := vm.stack.Pop()
+			if err != nil {
+				return nil, err
+			}
+
+			// If we're updating the member then we need to
+			// fetch the current value, and apply the operation
+			// to it.  e.g. `a[1] += 2`.
+			//
+			// A member missing from a hash is added to, or
+			// subtracted from, zero - so that `counts[word]++`
+			// needn't be initialised first.
+			op := code.Opcode(opArg)
+			if op != code.OpNop {
+				if (op == code.OpAdd || op == code.OpSub) && missingMember(left, index) {
+					vm.stack.Push(object.Int(0))
+				} else {
+					err = vm.executeIndexExpression(left, index)
+					if err != nil {
+						return nil, err
+					}
+				}
+				vm.stack.Push(value)
+
+				err = vm.executeBinaryOperation(op)
+				if err != nil {
+					return nil, err
+				}
+
+				value, err = vm.stack.Pop()
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			vm.arena.escape()
+			err = vm.executeSetIndex(left, index, value)
+			if err != nil {
+				return nil, err
+			}
+
 			// !true -> false
 		case code.OpBang:
 
@@ -492,6 +1070,34 @@ func (vm *VM) Run(obj interface{}) (object.Object, error) {
 		case code.OpVoid:
 			vm.stack.Push(Void)
 
+			// Duplicate the top of the stack
+		case code.OpDup:
+			val, err := vm.stack.Pop()
+			if err != nil {
+				return nil, err
+			}
+			vm.stack.Push(val)
+			vm.stack.Push(val)
+
+			// Discard the top of the stack
+		case code.OpPop:
+			_, err := vm.stack.Pop()
+			if err != nil {
+				return nil, err
+			}
+
+			// Hash-member, for match patterns
+		case code.OpMember:
+			key, err := vm.stack.Pop()
+			if err != nil {
+				return nil, err
+			}
+			obj, err := vm.stack.Pop()
+			if err != nil {
+				return nil, err
+			}
+			vm.stack.Push(vm.lookupMember(obj, key))
+
 			// Boolean literal
 		case code.OpFalse:
 			vm.stack.Push(False)
@@ -499,7 +1105,21 @@ func (vm *VM) Run(obj interface{}) (object.Object, error) {
 			// return from script
 		case code.OpReturn:
 			result, err := vm.stack.Pop()
-			return result, err
+			if err != nil || len(vm.frames) == base {
+				return result, err
+			}
+
+			// We're returning from a function, so restore the
+			// caller and resume after its call-instruction.
+			//
+			// NOTE: We reduce the offset, because at the end
+			// of our loop we increment it again.
+			ip = vm.popFrame() + code.Length(code.OpCall) - opLen
+
+			// Put the return-value on the stack
+			if result.Type() != object.VOID {
+				vm.stack.Push(result)
+			}
 
 			// flow-control: unconditional jump
 		case code.OpJump:
@@ -524,7 +1144,7 @@ func (vm *VM) Run(obj interface{}) (object.Object, error) {
 
 			// If the condition evaluated to a non-true
 			// then we change the IP.
-			if !condition.True() {
+			if !vm.True(condition) {
 
 				// NOTE: We reduce the offset, because
 				// at the end of our loop we increment
@@ -537,6 +1157,56 @@ func (vm *VM) Run(obj interface{}) (object.Object, error) {
 				}
 			}
 
+			// loops: limit the iterations they make
+		case code.OpLoopEnter:
+			err := vm.enterLoop(opArg)
+			if err != nil {
+				return nil, err
+			}
+
+		case code.OpLoopCount:
+			err := vm.countLoop(opArg)
+			if err != nil {
+				return nil, err
+			}
+
+			// flow-control: dispatch via a jump-table
+		case code.OpJumpTable:
+
+			val, err := vm.stack.Pop()
+			if err != nil {
+				return nil, err
+			}
+
+			if opArg >= len(vm.constants) {
+				return nil, fmt.Errorf("access to constant which doesn't exist")
+			}
+			table, ok := vm.constants[opArg].(*object.Hash)
+			if !ok {
+				return nil, fmt.Errorf("jump-table constant is a %s, not a hash", vm.constants[opArg].Type())
+			}
+
+			// Only strings can match, as with OpCase.
+			if str, ok := val.(*object.String); ok {
+				if pair, ok := table.Pairs[str.HashKey()]; ok {
+
+					offset, ok := pair.Value.(*object.Integer)
+					if !ok {
+						return nil, fmt.Errorf("jump-table offset is a %s, not an integer", pair.Value.Type())
+					}
+					dst := int(offset.Value)
+
+					// NOTE: We reduce the offset, because
+					// at the end of our loop we increment
+					// it again..
+					ip = dst - opLen
+
+					if dst >= len(vm.bytecode) {
+						return nil, fmt.Errorf("instruction pointer is out of bounds")
+					}
+				}
+			}
+
 			// function-call: This is messy.
 			//
 			// Handles builtins and user-defined functions.
@@ -572,76 +1242,101 @@ func (vm *VM) Run(obj interface{}) (object.Object, error) {
 				opArg--
 			}
 
-			// Get the function we're to invoke.
-			fn, ok := vm.environment.GetFunction(name)
-			if ok {
+			// Are we calling a function which is a value, rather
+			// than one we find by name?
+			fn, isValue := fName.(*object.Function)
+			if !isValue {
+
+				// Get the function we're to invoke.
+				host, ok := vm.environment.GetFunction(name)
+				if ok {
+					ret, err := vm.callBuiltin(name, host.(func(args []object.Object) object.Object), fnArgs)
+					if err != nil {
+						return nil, err
+					}
+
+					// store the result back on the stack - unless
+					// it's a weird one.
+					if ret.Type() != object.VOID {
+						vm.stack.Push(ret)
+					}
+					break
+				}
 
-				// Cast the function & call it
-				out := fn.(func(args []object.Object) object.Object)
-				ret := out(fnArgs)
+				// Function isn't a built-in, so now we need to see
+				// if it is a user-defined function.
+				val, ok := vm.functions[name]
+				if ok {
+					err = vm.enterFunction(name, ip, val.Arguments, val.Bytecode, val.Sources, nil, fnArgs)
+					if err != nil {
+						return nil, err
+					}
+
+					// switch so that we're interpreting the bytecode
+					// of the compiled function-body, from the start.
+					//
+					// NOTE: We reduce the offset, because at the end
+					// of our loop we increment it again.
+					ip = -opLen
+					break
+				}
 
-				// store the result back on the stack - unless
-				// it's a weird one.
+				// Otherwise it might be a variable which holds a
+				// function, or one of the functions which we
+				// implement because they call others.
+				if v, ok := vm.environment.Get(name); ok {
+					fn, isValue = v.(*object.Function)
+				}
+				if !isValue {
+					impl, ok := higherOrder(name)
+					if !ok {
+						impl, ok = grading(name)
+					}
+					if !ok {
+						return nil, fmt.Errorf("the function %s does not exist", name)
+					}
+					ret, err := impl(vm, obj, ip, fnArgs)
+					if err != nil {
+						return nil, err
+					}
+					if ret.Type() != object.VOID {
+						vm.stack.Push(ret)
+					}
+					break
+				}
+			}
+
+			// Calling a function which is a value.
+			if fn.Builtin != nil {
+				ret, err := vm.callBuiltin(fn.Name, fn.Builtin, fnArgs)
+				if err != nil {
+					return nil, err
+				}
 				if ret.Type() != object.VOID {
 					vm.stack.Push(ret)
 				}
 				break
 			}
-
-			// Function isn't a built-in, so now we need to see
-			// if it is a user-defined function.
-			val, ok2 := vm.functions[name]
-			if !ok2 {
-				return nil, fmt.Errorf("the function %s does not exist", name)
-			}
-
-			// Save IP + bytecode
-			oldIP := ip
-			oldBytecode := vm.bytecode
-			oldStack := vm.stack
-
-			vm.stack = stack.New()
-			vm.environment.AddScope()
-
-			// switch so that we're interpreting the bytecode
-			// of the compiled function-body.
-			vm.bytecode = val.Bytecode
-
-			// Sanity-check we have enough arguments
-			if len(val.Arguments) != len(fnArgs) {
-				return nil, fmt.Errorf("mismatch in argument-counts for %s, expected %d but got %d", name, len(val.Arguments), len(fnArgs))
-			}
-
-			// Now for each arg we set the value
-			for i, name := range val.Arguments {
-				vm.environment.SetLocal(name, fnArgs[i])
-			}
-
-			// Run ourselves against that new bytecode.
-			//
-			// This is a bit horrid.
-			out, err := vm.Run(obj)
-
-			// Did we get an error?  If so return it
+			err = vm.enterFunction(functionName(fn), ip, fn.Arguments, fn.Bytecode, fn.Sources, fn.Captured, fnArgs)
 			if err != nil {
 				return nil, err
 			}
+			ip = -opLen
 
-			// Otherwise we're going to keep running from
-			// where we left off - resetting the state of
-			// our stack, instruction-pointer, and bytecode.
-			ip = oldIP
-			vm.bytecode = oldBytecode
-			vm.stack = oldStack
-
-			// Put the return-value on the stack
-			if out.Type() != object.VOID {
-				vm.stack.Push(out)
+			// create a closure
+		case code.OpClosure:
+			if opArg >= len(vm.constants) {
+				return nil, fmt.Errorf("access to constant which doesn't exist")
 			}
+			proto, ok := vm.constants[opArg].(*object.Function)
+			if !ok {
+				return nil, fmt.Errorf("constant %d is not a function", opArg)
+			}
+			vm.stack.Push(vm.closure(proto))
 
-			// Drop the scope which means function-arguments
-			// are dropped.
-			err = vm.environment.RemoveScope()
+			// record the value of an expression, when explaining
+		case code.OpExplain:
+			err := vm.observe(opArg)
 			if err != nil {
 				return nil, err
 			}
@@ -649,23 +1344,26 @@ func (vm *VM) Run(obj interface{}) (object.Object, error) {
 			// reset the state of an object which is to be iterated upon
 		case code.OpIterationReset:
 
-			// Create a scoped environment
-			vm.environment.AddScope()
 			// get object we're iterating over..
 			out, err := vm.stack.Pop()
 			if err != nil {
 				return nil, err
 			}
 
-			// Cast it to the interface.
-			helper, ok := out.(object.Iterable)
+			// Create the state for this loop.
+			//
+			// The state is kept separate from the object, so
+			// that nested loops over the same object work.
+			iter, ok := newIterator(out)
 			if !ok {
 				return nil, fmt.Errorf("%s object doesn't implement the Iterable interface", out.Type())
 			}
 
-			// Reset it, and place back upon the stack.
-			helper.Reset()
-			vm.stack.Push(out)
+			// Create a scoped environment
+			vm.environment.AddScope()
+
+			// Place the iterator upon the stack.
+			vm.stack.Push(iter)
 
 			// Iterate over an object that implements the Iterable interface.
 		case code.OpIterationNext:
@@ -689,8 +1387,9 @@ func (vm *VM) Run(obj interface{}) (object.Object, error) {
 				return nil, err
 			}
 
-			// Ensure that it is an iterable thing.
-			helper, ok := obj.(object.Iterable)
+			// Ensure that it is the iterator which was
+			// created by OpIterationReset.
+			helper, ok := obj.(*iterator)
 			if !ok {
 				return nil, fmt.Errorf("%s object doesn't implement the Iterable interface", obj.Type())
 			}
@@ -701,12 +1400,16 @@ func (vm *VM) Run(obj interface{}) (object.Object, error) {
 
 			if ok {
 
-				// Set the index + name
-				vm.environment.SetLocal(varName.Inspect(), ret)
+				// Set the index + name.
+				//
+				// These are declared in the scope of this
+				// loop, so that an enclosing loop using the
+				// same names is unaffected.
+				vm.environment.Declare(varName.Inspect(), ret)
 
 				idxName := idxName.Inspect()
 				if idxName != "" {
-					vm.environment.SetLocal(idxName, idx)
+					vm.environment.Declare(idxName, idx)
 				}
 
 				// Push the iterable object back upon the
@@ -735,6 +1438,23 @@ func (vm *VM) Run(obj interface{}) (object.Object, error) {
 
 			}
 
+			// Terminate a foreach loop early.
+		case code.OpIterationEnd:
+
+			obj, err := vm.stack.Pop()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := obj.(*iterator); !ok {
+				return nil, fmt.Errorf("%s object is not an iterator", obj.Type())
+			}
+
+			// Remove the scope the loop created.
+			err = vm.environment.RemoveScope()
+			if err != nil {
+				return nil, err
+			}
+
 			// Create an array of numbers.
 		case code.OpRange:
 			var min object.Object
@@ -767,14 +1487,19 @@ func (vm *VM) Run(obj interface{}) (object.Object, error) {
 			// length
 			l := maxI - minI + 1
 
-			// holder for elements of the correct size
+			// holder for elements of the correct size, which
+			// we must be allowed to allocate.
+			err = vm.allocate(l * elementSize * 2)
+			if err != nil {
+				return nil, err
+			}
 			elements := make([]object.Object, l)
 
 			// Make the array
 			var i int64
 			i = 0
 			for i < l {
-				elements[i] = &object.Integer{Value: minI + i}
+				elements[i] = object.Int(minI + i)
 				i++
 			}
 
@@ -797,18 +1522,30 @@ func (vm *VM) Run(obj interface{}) (object.Object, error) {
 			// Lookup the current value of that object.
 			val := vm.lookup(obj, name)
 
-			// Can we use our interface?
-			helper, ok := val.(object.Increment)
-			if !ok {
-				return nil, fmt.Errorf("%s object doesn't implement the Increment() interface", val.Type())
+			// Numbers are replaced, rather than modified, as the
+			// object might be shared with a constant, or another
+			// variable.  Anything else may use our interface.
+			switch n := val.(type) {
+			case *object.Integer:
+				val = vm.arena.integer(n.Value + 1)
+			case *object.Float:
+				val = vm.arena.float(n.Value + 1)
+			default:
+				helper, ok := val.(object.Increment)
+				if !ok {
+					return nil, fmt.Errorf("%s object doesn't implement the Increment() interface", val.Type())
+				}
+				helper.Increase()
 			}
 
-			// Mutate & store
-			helper.Increase()
-			vm.environment.Set(name, val)
+			// Store
+			err := vm.setVariable(obj, name, val)
+			if err != nil {
+				return nil, err
+			}
 
 			// OpInc follows OpLookup, so we can drop the value we were given
-			_, err := vm.stack.Pop()
+			_, err = vm.stack.Pop()
 			if err != nil {
 				return nil, err
 			}
@@ -828,18 +1565,28 @@ func (vm *VM) Run(obj interface{}) (object.Object, error) {
 			// Lookup the current value of that object.
 			val := vm.lookup(obj, name)
 
-			// Can we use our interface?
-			helper, ok := val.(object.Decrement)
-			if !ok {
-				return nil, fmt.Errorf("%s object doesn't implement the Decrement() interface", val.Type())
+			// As with OpInc numbers are replaced.
+			switch n := val.(type) {
+			case *object.Integer:
+				val = vm.arena.integer(n.Value - 1)
+			case *object.Float:
+				val = vm.arena.float(n.Value - 1)
+			default:
+				helper, ok := val.(object.Decrement)
+				if !ok {
+					return nil, fmt.Errorf("%s object doesn't implement the Decrement() interface", val.Type())
+				}
+				helper.Decrease()
 			}
 
-			// Mutate & store
-			helper.Decrease()
-			vm.environment.Set(name, val)
+			// Store
+			err := vm.setVariable(obj, name, val)
+			if err != nil {
+				return nil, err
+			}
 
 			// OpDec follows OpLookup, so we can drop the value we were given
-			_, err := vm.stack.Pop()
+			_, err = vm.stack.Pop()
 			if err != nil {
 				return nil, err
 			}
@@ -852,83 +1599,338 @@ func (vm *VM) Run(obj interface{}) (object.Object, error) {
 			return nil, fmt.Errorf("unhandled opcode: %v %s", op, code.String(op))
 		}
 
-		ip += opLen
+		ip += opLen
+	}
+
+	//
+	// If we get here we've hit the end of the bytecode, and we
+	// didn't encounter a return-instruction.
+	//
+	// In the case where a user is running the script as a filter
+	// then a missing return code is probably going to be treated
+	// as a "false" return.  But the caller can decide that.
+	//
+	return Null, nil
+}
+
+// inspectObject discovers the names/values of all structure fields, or
+// map contents.
+//
+// Scripts usually refer to only a few fields, so lookups convert only
+// the field they refer to, via field.  This method is used when all the
+// fields are required.  (Reflection is s-l-o-w.)
+func (vm *VM) inspectObject(obj interface{}) {
+
+	//
+	// If the reference is nil we have nothing to walk.
+	//
+	if obj == nil {
+		return
+	}
+
+	//
+	// Get the value, be it a "thing", or a pointer to a thing.
+	//
+	val, ok := indirect(reflect.ValueOf(obj))
+	if !ok {
+		return
+	}
+
+	//
+	// Is this a map?
+	//
+	if val.Kind() == reflect.Map {
+
+		//
+		// Get all keys in the map.
+		//
+		for _, key := range val.MapKeys() {
+
+			// The name of the key, which might be a number.
+			name, ok := keyName(key)
+			if !ok {
+				continue
+			}
+
+			// The actual thing inside it
+			field := val.MapIndex(key)
+
+			// Convert to an object
+			ret := vm.primitiveToObject(field)
+
+			// Store it in our map
+			vm.fields[name] = ret
+		}
+		return
+	}
+
+	//
+	// Anything other than a structure has no fields.
+	//
+	if val.Kind() != reflect.Struct {
+		return
+	}
+
+	//
+	// OK this is an object, so we walk over the fields within it.
+	//
+	walkStruct(val, vm.fieldTag, func(name string, field reflect.Value) {
+
+		// Convert the value to one of our objects
+		ret := vm.primitiveToObject(field)
+
+		// Store it in our map
+		vm.fields[name] = ret
+	})
+}
+
+// field returns the named field of the object, or member of the map,
+// converted to one of our objects.
+//
+// Only the field we're asked for is converted, so the cost of reflection
+// is only paid for the fields a script actually uses.
+func (vm *VM) field(obj interface{}, name string) (object.Object, bool) {
+
+	if obj == nil {
+		return nil, false
+	}
+
+	if p, ok := obj.(FieldProvider); ok {
+		f, ok := p.Field(name)
+		if !ok {
+			return nil, false
+		}
+		return f.object(), true
+	}
+
+	// The most common kind of map is looked up directly, as using
+	// reflection to do so would allocate a copy of its key and value.
+	if m, ok := obj.(map[string]interface{}); ok {
+		member, ok := m[name]
+		if !ok {
+			return nil, false
+		}
+		if member == nil {
+			return Null, true
+		}
+		return vm.fieldValue(reflect.ValueOf(member)), true
+	}
+
+	val, ok := indirect(reflect.ValueOf(obj))
+	if !ok {
+		return nil, false
+	}
+
+	var field reflect.Value
+
+	switch val.Kind() {
+	case reflect.Map:
+		key, ok := mapKey(val.Type().Key(), name)
+		if !ok {
+			return nil, false
+		}
+		field = val.MapIndex(key)
+	case reflect.Struct:
+		field = fieldByName(val, vm.fieldTag, name)
+	}
+
+	if !field.IsValid() {
+		return nil, false
+	}
+	return vm.fieldValue(field), true
+}
+
+// fieldValue converts the value of a field of the object to one of our
+// objects, as primitiveToObject does.
+//
+// The strings and numbers which are converted are taken from the arena,
+// and booleans are our singletons, so that running a program against
+// an object doesn't allocate.  That is only safe for the fields
+// themselves: the members of the arrays and hashes we create might be
+// referred to by them after the run, without the arena being told.
+func (vm *VM) fieldValue(field reflect.Value) object.Object {
+
+	// Unwrap the members of map[string]interface{}, etc.
+	for field.Kind() == reflect.Interface && !field.IsNil() {
+		field = field.Elem()
+	}
+
+	// Types with methods might describe themselves, or be one of our
+	// own objects, so they're left to primitiveToObject.
+	if field.IsValid() && field.Type().NumMethod() == 0 {
+		switch field.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return vm.arena.integer(field.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return vm.arena.integer(int64(field.Uint()))
+		case reflect.Float32, reflect.Float64:
+			return vm.arena.float(field.Float())
+		case reflect.String:
+			return vm.arena.string(field.String())
+		case reflect.Bool:
+			return vm.nativeBoolToBooleanObject(field.Bool())
+		}
+	}
+
+	ret := vm.primitiveToObject(field)
+	if ret == nil {
+		ret = Null
+	}
+	return ret
+}
+
+// keyName returns the name by which scripts refer to the member of a
+// map with the given key.
+//
+// Strings and integers may be used as keys, other types are ignored.
+func keyName(key reflect.Value) (string, bool) {
+
+	key, ok := indirect(key)
+	if !ok {
+		return "", false
+	}
+
+	switch key.Kind() {
+	case reflect.String:
+		return key.String(), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(key.Int(), 10), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(key.Uint(), 10), true
+	}
+	return "", false
+}
+
+// mapKey converts the name a script used to a key of the given type,
+// which is the reverse of keyName.
+func mapKey(t reflect.Type, name string) (reflect.Value, bool) {
+
+	key := reflect.New(t).Elem()
+
+	switch t.Kind() {
+	case reflect.String:
+		key.SetString(name)
+	case reflect.Interface:
+		key.Set(reflect.ValueOf(name))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(name, 10, 64)
+		if err != nil || key.OverflowInt(i) {
+			return key, false
+		}
+		key.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(name, 10, 64)
+		if err != nil || key.OverflowUint(u) {
+			return key, false
+		}
+		key.SetUint(u)
+	default:
+		return key, false
+	}
+	return key, true
+}
+
+// indirect follows the given value through any pointers, or interfaces,
+// returning false if it is nil.
+func indirect(val reflect.Value) (reflect.Value, bool) {
+
+	for val.Kind() == reflect.Ptr || val.Kind() == reflect.Interface {
+		if val.IsNil() {
+			return val, false
+		}
+		val = val.Elem()
 	}
-
-	//
-	// If we get here we've hit the end of the bytecode, and we
-	// didn't encounter a return-instruction.
-	//
-	// In the case where a user is running the script as a filter
-	// then a missing return code is probably going to be treated
-	// as a "false" return.  But the caller can decide that.
-	//
-	return Null, nil
+	return val, val.IsValid()
 }
 
-// inspectObject discovers the names/values of all structure fields, or
-// map contents.
+// walkStruct invokes the callback for each field of the given structure.
 //
-// This method is called the first time any reference is made to a field
-// value - which means we don't eat the cost unless we need it, and we
-// don't have to call reflection more than once.  (Reflection is s-l-o-w.)
-func (vm *VM) inspectObject(obj interface{}) {
+// The fields of embedded structures are promoted, as they are in go, so
+// that they may be referred to directly.  As in go a field of the outer
+// structure hides a promoted field of the same name.  Embedded structures
+// which are nil pointers have no fields to promote.
+//
+// If a tag is given then fields are named as that tag of theirs says, as
+// described by SetFieldTag.
+func walkStruct(val reflect.Value, tag string, callback func(name string, field reflect.Value)) {
 
-	//
-	// If the reference is nil we have nothing to walk.
-	//
-	if obj == nil {
+	info := structFields(val.Type(), tag)
+	if info.dynamic {
+		walkValues(val, tag, callback)
 		return
 	}
 
-	//
-	// Get the value, be it a "thing", or a pointer to a thing.
-	//
-	val := reflect.Indirect(reflect.ValueOf(obj))
+	seen := make(map[string]bool, len(info.names))
+	for i := range info.fields {
+		field := &info.fields[i]
+		if seen[field.name] {
+			continue
+		}
+		if v, ok := field.value(val); ok {
+			seen[field.name] = true
+			callback(field.name, v)
+		}
+	}
+}
 
-	//
-	// Is this a map?
-	//
-	if val.Kind() == reflect.Map {
+// walkValues invokes the callback for each field of the given structure,
+// as walkStruct does, for the structures whose fields depend upon their
+// values rather than their types.
+func walkValues(val reflect.Value, tag string, callback func(name string, field reflect.Value)) {
 
-		//
-		// Get all keys in the map.
-		//
-		for _, key := range val.MapKeys() {
+	seen := make(map[string]bool)
 
-			// The name of the key.
-			name := key.Interface().(string)
+	// The structures to walk, starting with the outermost.
+	pending := []reflect.Value{val}
 
-			// The actual thing inside it
-			field := val.MapIndex(key).Elem()
+	for len(pending) > 0 {
 
-			// Convert to an object
-			ret := vm.primitiveToObject(field)
+		val := pending[0]
+		pending = pending[1:]
 
-			// Store it in our map
-			vm.fields[name] = ret
+		for i := 0; i < val.NumField(); i++ {
+
+			// Get the field, and the name
+			field := val.Field(i)
+			typeField := val.Type().Field(i)
+			name, tagged := tagName(typeField, tag)
+			if name == "" {
+				continue
+			}
+
+			if !seen[name] {
+				seen[name] = true
+				callback(name, field)
+			}
+
+			// Embedded structures are walked after the fields
+			// which might hide theirs, unless a tag named them.
+			if typeField.Anonymous && !tagged {
+				inner, ok := indirect(field)
+				if ok && inner.Kind() == reflect.Struct && inner.Type() != timeType {
+					pending = append(pending, inner)
+				}
+			}
 		}
-		return
 	}
+}
 
-	//
-	// OK this is an object, so we walk over the fields within it.
-	//
-	for i := 0; i < val.NumField(); i++ {
-
-		// Get the field
-		field := val.Field(i)
+// Fields returns the fields of the given object, converted to our own
+// objects, as a script run against the object would see them.
+//
+// Each call converts the object afresh, so the results of two calls
+// may be modified independently.
+func (vm *VM) Fields(obj interface{}) map[string]object.Object {
 
-		// Get the name
-		typeField := val.Type().Field(i)
-		name := typeField.Name
+	saved, origins := vm.fields, vm.origins
+	defer func() { vm.fields, vm.origins = saved, origins }()
 
-		// Convert the value to one of our objects
-		ret := vm.primitiveToObject(field)
+	// The copies we return aren't part of the object.
+	vm.origins = nil
 
-		// Store it in our map
-		vm.fields[name] = ret
-	}
+	vm.fields = make(map[string]object.Object)
+	vm.inspectObject(obj)
+	return vm.fields
 }
 
 // convert a primitive into one of our internal objects.
@@ -937,36 +1939,104 @@ func (vm *VM) primitiveToObject(field reflect.Value) object.Object {
 	var ret object.Object
 
 	//
-	// Time gets special handling
+	// Invalid value?  Return null
+	//
+	if !field.IsValid() {
+		return Null
+	}
+
+	//
+	// The host might have given us one of our own objects,
+	// for example an iterator producing rows on-demand.
 	//
-	timeKind := reflect.TypeOf(time.Time{}).Kind()
+	if field.CanInterface() {
+		if obj, ok := field.Interface().(object.Object); ok {
+			if field.Kind() == reflect.Ptr && field.IsNil() {
+				return Null
+			}
+			return obj
+		}
+	}
 
 	//
-	// Invalid value?  Return null
+	// Numbers decoded from JSON are presented as numbers.
 	//
-	if !field.IsValid() {
-		return &object.Null{}
+	if inner, ok := indirect(field); ok && inner.Type() == jsonNumberType {
+		return numberObject(inner.String())
+	}
+
+	//
+	// JSON which hasn't been decoded is decoded now.
+	//
+	if inner, ok := indirect(field); ok && inner.Type() == rawMessageType {
+		return vm.rawObject(inner.Bytes())
+	}
+
+	//
+	// Values which describe themselves are presented as strings.
+	//
+	if obj, ok := vm.stringer(field); ok {
+		return obj
 	}
 
 	switch field.Kind() {
 
+	case reflect.Interface:
+		// Members of []interface{}, map[string]interface{}, etc,
+		// need to be unwrapped to find their real type.
+		if field.IsNil() {
+			return Null
+		}
+		ret = vm.primitiveToObject(field.Elem())
+	case reflect.Ptr:
+		// Pointers are followed, unless they're nil, or refer
+		// to a structure we're already converting.
+		if field.IsNil() || vm.converting[field.Pointer()] {
+			return Null
+		}
+		if vm.converting == nil {
+			vm.converting = make(map[uintptr]bool)
+		}
+		vm.converting[field.Pointer()] = true
+		ret = vm.primitiveToObject(field.Elem())
+		delete(vm.converting, field.Pointer())
+	case reflect.Chan:
+		ret = vm.createIterator(field)
 	case reflect.Map:
 		ret = vm.createHash(field)
-	case reflect.Slice:
+	case reflect.Slice, reflect.Array:
+		// Byte-slices get special handling
+		if field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.Uint8 {
+			ret = &object.Bytes{Value: field.Bytes()}
+			break
+		}
 		ret = vm.createArrayFromSlice(field)
-	case reflect.Int, reflect.Int64:
-		ret = &object.Integer{Value: field.Int()}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		// Durations are converted to seconds, as times are
+		// seconds past the epoch.
+		if field.Type() == durationType {
+			ret = &object.Float{Value: time.Duration(field.Int()).Seconds()}
+			break
+		}
+		ret = object.Int(field.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		ret = object.Int(int64(field.Uint()))
 	case reflect.Float32, reflect.Float64:
 		ret = &object.Float{Value: field.Float()}
 	case reflect.String:
 		ret = &object.String{Value: field.String()}
 	case reflect.Bool:
-		ret = &object.Boolean{Value: field.Bool()}
-	case timeKind:
-		time, ok := field.Interface().(time.Time)
-		if ok {
-			ret = &object.Integer{Value: time.Unix()}
+		ret = object.Bool(field.Bool())
+	case reflect.Struct:
+		// Time gets special handling
+		if field.Type() == timeType {
+			ret = Null
+			if field.CanInterface() {
+				ret = &object.Time{Value: field.Interface().(time.Time)}
+			}
+			break
 		}
+		ret = vm.createHashFromStruct(field)
 	default:
 		fmt.Printf("Failed to reflect on %T\n", field.Interface())
 	}
@@ -974,8 +2044,36 @@ func (vm *VM) primitiveToObject(field reflect.Value) object.Object {
 	return ret
 }
 
+// create one of our internal iterator-objects from a channel.
+//
+// Each item is received from the channel as our foreach loop asks for
+// it, and the iteration completes when the channel is closed.  Channels
+// which cannot be received from, or which are nil, become null.
+func (vm *VM) createIterator(field reflect.Value) object.Object {
+
+	if field.IsNil() || field.Type().ChanDir()&reflect.RecvDir == 0 {
+		return Null
+	}
+
+	// Waiting for the next item stops once our context is done, after
+	// which the run is aborted, as it is at every instruction.
+	return object.NewIterator(func() (object.Object, bool) {
+		chosen, val, ok := reflect.Select([]reflect.SelectCase{
+			{Dir: reflect.SelectRecv, Chan: field},
+			{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(vm.context.Done())},
+		})
+		if chosen != 0 || !ok {
+			return nil, false
+		}
+		return vm.primitiveToObject(val), true
+	})
+}
+
 // create one of our internal hash-objects via reflection.
 //
+// This works for maps of any key/value types, although keys which
+// cannot be used as hash-keys are ignored.
+//
 // This may well recurse.
 func (vm *VM) createHash(field reflect.Value) object.Object {
 	hashedPairs := make(map[object.HashKey]object.HashPair)
@@ -987,107 +2085,82 @@ func (vm *VM) createHash(field reflect.Value) object.Object {
 		// can be used as hash-keys.)
 		k := vm.primitiveToObject(key)
 
-		// The actual thing inside it.
-		val := field.MapIndex(key).Elem()
+		hashable, ok := k.(object.Hashable)
+		if !ok {
+			continue
+		}
 
 		// Get the value.
-		v := vm.primitiveToObject(val)
+		v := vm.primitiveToObject(field.MapIndex(key))
+		if v == nil {
+			v = Null
+		}
 
 		pair := object.HashPair{Key: k, Value: v}
-		hashedPairs[k.(object.Hashable).HashKey()] = pair
+		hashedPairs[hashable.HashKey()] = pair
 	}
 
-	return &object.Hash{Pairs: hashedPairs}
+	hash := &object.Hash{Pairs: hashedPairs}
+	if vm.origins != nil {
+		vm.origins[hash] = field
+	}
+	return hash
 }
 
-// createArrayFromSlice creates an object.Array value from the
-// given object/map slice
+// create one of our internal hash-objects from the fields of a
+// structure, including those promoted from embedded structures.
 //
 // This may well recurse.
-func (vm *VM) createArrayFromSlice(field reflect.Value) object.Object {
+func (vm *VM) createHashFromStruct(field reflect.Value) object.Object {
+	hashedPairs := make(map[object.HashKey]object.HashPair)
 
-	// Elements we've found
-	var el []object.Object
+	walkStruct(field, vm.fieldTag, func(name string, val reflect.Value) {
 
-	// Find the length of the slice
-	l := field.Len()
+		k := &object.String{Value: name}
 
-	// For each entry
-	for i := 0; i < l; i++ {
+		v := vm.primitiveToObject(val)
+		if v == nil {
+			v = Null
+		}
 
-		// Cast the array-member to an interface
-		in := field.Index(i).Interface()
+		hashedPairs[k.HashKey()] = object.HashPair{Key: k, Value: v}
+	})
 
-		//
-		// Now we're in horrible-land
-		//
-		// We want to work out the type of the
-		// array-member.  Of course every member
-		// will have the same type, unless we're
-		// in the case of an array of interfaces.
-		//
-		// The following code will try to cast
-		// to all "reasonable" values, which will
-		// cover either case.
-		//
-		// It is still horrible though, and that
-		// should be noted.
-		//
+	hash := &object.Hash{Pairs: hashedPairs}
+	if vm.origins != nil {
+		vm.origins[hash] = field
+	}
+	return hash
+}
 
-		// Is it a string?
-		s, ok := in.(string)
-		if ok {
-			el = append(el, &object.String{Value: s})
-			continue
-		}
+// createArrayFromSlice creates an object.Array value from the
+// given slice, or array, of any type.
+//
+// This may well recurse.
+func (vm *VM) createArrayFromSlice(field reflect.Value) object.Object {
 
-		// Is it a bool?
-		b, ok := in.(bool)
-		if ok {
-			el = append(el, &object.Boolean{Value: b})
-			continue
-		}
+	// Find the length of the slice
+	l := field.Len()
 
-		// is it a float?
-		f, ok := in.(float32)
-		if ok {
-			el = append(el, &object.Float{Value: float64(f)})
-			continue
-		}
-		ff, ok := in.(float64)
-		if ok {
-			el = append(el, &object.Float{Value: ff})
-			continue
-		}
+	// Elements we've found
+	el := make([]object.Object, 0, l)
 
-		// is it an integer?
-		d, ok := in.(int)
-		if ok {
-			el = append(el, &object.Integer{Value: int64(d)})
-			continue
-		}
-		dd, ok := in.(int32)
-		if ok {
-			el = append(el, &object.Integer{Value: int64(dd)})
-			continue
-		}
-		ddd, ok := in.(int64)
-		if ok {
-			el = append(el, &object.Integer{Value: ddd})
-			continue
-		}
+	// For each entry convert it to an object, members which
+	// can't be converted will become null.
+	for i := 0; i < l; i++ {
 
-		// Is it a time value?
-		tm, ok := in.(time.Time)
-		if ok {
-			el = append(el, &object.Integer{Value: tm.Unix()})
-			continue
+		v := vm.primitiveToObject(field.Index(i))
+		if v == nil {
+			v = Null
 		}
-
-		fmt.Printf("Failed to convert array-member to object")
+		el = append(el, v)
 	}
 
-	return &object.Array{Elements: el}
+	array := &object.Array{Elements: el}
+	if vm.origins != nil {
+		vm.origins[array] = field
+	}
+	return array
 }
 
 // Execute an operation against two arguments, i.e "foo == bar", "2 + 3", etc.
@@ -1121,13 +2194,19 @@ func (vm *VM) executeBinaryOperation(op code.Opcode) error {
 		return vm.evalStringInfixExpression(op, left, right)
 	case left.Type() == object.STRING && right.Type() == object.REGEXP:
 		return vm.evalStringRegexpExpression(op, left, right)
+	case (left.Type() == object.TIME || right.Type() == object.TIME) &&
+		op != code.OpAnd && op != code.OpOr && op != code.OpArrayIn:
+		return vm.evalTimeInfixExpression(op, left, right)
+	case (left.Type() == object.BYTES || right.Type() == object.BYTES) &&
+		op != code.OpAnd && op != code.OpOr && op != code.OpArrayIn:
+		return vm.evalBytesInfixExpression(op, left, right)
 	case op == code.OpAnd:
 		// if left is false skip right
-		if !left.True() {
+		if !vm.True(left) {
 			vm.stack.Push(False)
 			return nil
 		}
-		if right.True() {
+		if vm.True(right) {
 			vm.stack.Push(True)
 		} else {
 			vm.stack.Push(False)
@@ -1135,11 +2214,11 @@ func (vm *VM) executeBinaryOperation(op code.Opcode) error {
 		return nil
 	case op == code.OpOr:
 		// if left is true skip right
-		if left.True() {
+		if vm.True(left) {
 			vm.stack.Push(True)
 			return nil
 		}
-		if right.True() {
+		if vm.True(right) {
 			vm.stack.Push(True)
 		} else {
 			vm.stack.Push(False)
@@ -1189,20 +2268,20 @@ func (vm *VM) evalIntegerInfixExpression(op code.Opcode, left, right object.Obje
 
 	switch op {
 	case code.OpAdd:
-		vm.stack.Push(&object.Integer{Value: leftVal + rightVal})
+		vm.stack.Push(vm.arena.integer(leftVal + rightVal))
 	case code.OpSub:
-		vm.stack.Push(&object.Integer{Value: leftVal - rightVal})
+		vm.stack.Push(vm.arena.integer(leftVal - rightVal))
 	case code.OpMul:
-		vm.stack.Push(&object.Integer{Value: leftVal * rightVal})
+		vm.stack.Push(vm.arena.integer(leftVal * rightVal))
 	case code.OpDiv:
 		if rightVal == 0 {
 			return fmt.Errorf("attempted division by zero: %d / %d", leftVal, rightVal)
 		}
-		vm.stack.Push(&object.Integer{Value: leftVal / rightVal})
+		vm.stack.Push(vm.arena.integer(leftVal / rightVal))
 	case code.OpMod:
-		vm.stack.Push(&object.Integer{Value: leftVal % rightVal})
+		vm.stack.Push(vm.arena.integer(leftVal % rightVal))
 	case code.OpPower:
-		vm.stack.Push(&object.Integer{Value: int64(math.Pow(float64(leftVal), float64(rightVal)))})
+		vm.stack.Push(vm.arena.integer(int64(math.Pow(float64(leftVal), float64(rightVal)))))
 	case code.OpLess:
 		vm.stack.Push(vm.nativeBoolToBooleanObject(leftVal < rightVal))
 	case code.OpLessEqual:
@@ -1229,20 +2308,20 @@ func (vm *VM) evalFloatInfixExpression(op code.Opcode, left, right object.Object
 
 	switch op {
 	case code.OpAdd:
-		vm.stack.Push(&object.Float{Value: leftVal + rightVal})
+		vm.stack.Push(vm.arena.float(leftVal + rightVal))
 	case code.OpSub:
-		vm.stack.Push(&object.Float{Value: leftVal - rightVal})
+		vm.stack.Push(vm.arena.float(leftVal - rightVal))
 	case code.OpMul:
-		vm.stack.Push(&object.Float{Value: leftVal * rightVal})
+		vm.stack.Push(vm.arena.float(leftVal * rightVal))
 	case code.OpDiv:
 		if rightVal == 0 {
 			return fmt.Errorf("attempted division by zero: %f / %f", leftVal, rightVal)
 		}
-		vm.stack.Push(&object.Float{Value: leftVal / rightVal})
+		vm.stack.Push(vm.arena.float(leftVal / rightVal))
 	case code.OpMod:
-		vm.stack.Push(&object.Float{Value: float64(int(leftVal) % int(rightVal))})
+		vm.stack.Push(vm.arena.float(float64(int(leftVal) % int(rightVal))))
 	case code.OpPower:
-		vm.stack.Push(&object.Float{Value: math.Pow(leftVal, rightVal)})
+		vm.stack.Push(vm.arena.float(math.Pow(leftVal, rightVal)))
 	case code.OpLess:
 		vm.stack.Push(vm.nativeBoolToBooleanObject(leftVal < rightVal))
 	case code.OpLessEqual:
@@ -1269,20 +2348,20 @@ func (vm *VM) evalFloatIntegerInfixExpression(op code.Opcode, left, right object
 
 	switch op {
 	case code.OpAdd:
-		vm.stack.Push(&object.Float{Value: leftVal + rightVal})
+		vm.stack.Push(vm.arena.float(leftVal + rightVal))
 	case code.OpSub:
-		vm.stack.Push(&object.Float{Value: leftVal - rightVal})
+		vm.stack.Push(vm.arena.float(leftVal - rightVal))
 	case code.OpMul:
-		vm.stack.Push(&object.Float{Value: leftVal * rightVal})
+		vm.stack.Push(vm.arena.float(leftVal * rightVal))
 	case code.OpDiv:
 		if rightVal == 0 {
 			return fmt.Errorf("attempted division by zero: %f / %f", leftVal, rightVal)
 		}
-		vm.stack.Push(&object.Float{Value: leftVal / rightVal})
+		vm.stack.Push(vm.arena.float(leftVal / rightVal))
 	case code.OpMod:
-		vm.stack.Push(&object.Float{Value: float64(int(leftVal) % int(rightVal))})
+		vm.stack.Push(vm.arena.float(float64(int(leftVal) % int(rightVal))))
 	case code.OpPower:
-		vm.stack.Push(&object.Float{Value: math.Pow(leftVal, rightVal)})
+		vm.stack.Push(vm.arena.float(math.Pow(leftVal, rightVal)))
 	case code.OpLess:
 		vm.stack.Push(vm.nativeBoolToBooleanObject(leftVal < rightVal))
 	case code.OpLessEqual:
@@ -1309,20 +2388,20 @@ func (vm *VM) evalIntegerFloatInfixExpression(op code.Opcode, left, right object
 
 	switch op {
 	case code.OpAdd:
-		vm.stack.Push(&object.Float{Value: leftVal + rightVal})
+		vm.stack.Push(vm.arena.float(leftVal + rightVal))
 	case code.OpSub:
-		vm.stack.Push(&object.Float{Value: leftVal - rightVal})
+		vm.stack.Push(vm.arena.float(leftVal - rightVal))
 	case code.OpMul:
-		vm.stack.Push(&object.Float{Value: leftVal * rightVal})
+		vm.stack.Push(vm.arena.float(leftVal * rightVal))
 	case code.OpDiv:
 		if rightVal == 0 {
 			return fmt.Errorf("attempted division by zero: %f / %f", leftVal, rightVal)
 		}
-		vm.stack.Push(&object.Float{Value: leftVal / rightVal})
+		vm.stack.Push(vm.arena.float(leftVal / rightVal))
 	case code.OpMod:
-		vm.stack.Push(&object.Float{Value: float64(int(leftVal) % int(rightVal))})
+		vm.stack.Push(vm.arena.float(float64(int(leftVal) % int(rightVal))))
 	case code.OpPower:
-		vm.stack.Push(&object.Float{Value: math.Pow(leftVal, rightVal)})
+		vm.stack.Push(vm.arena.float(math.Pow(leftVal, rightVal)))
 	case code.OpLess:
 		vm.stack.Push(vm.nativeBoolToBooleanObject(leftVal < rightVal))
 	case code.OpLessEqual:
@@ -1361,7 +2440,11 @@ func (vm *VM) evalStringInfixExpression(op code.Opcode, left object.Object, righ
 	case code.OpLess:
 		vm.stack.Push(vm.nativeBoolToBooleanObject(l.Value < r.Value))
 	case code.OpAdd:
-		vm.stack.Push(&object.String{Value: l.Value + r.Value})
+		err := vm.allocate(int64(len(l.Value) + len(r.Value)))
+		if err != nil {
+			return err
+		}
+		vm.stack.Push(vm.arena.string(l.Value + r.Value))
 	case code.OpArrayIn:
 		if strings.Contains(r.Value, l.Value) {
 			vm.stack.Push(True)
@@ -1436,6 +2519,13 @@ func (vm *VM) executeBangOperator() error {
 		return err
 	}
 
+	// Later versions of the language negate the truthiness of any
+	// value.
+	if vm.language >= Language2 {
+		vm.stack.Push(vm.nativeBoolToBooleanObject(!vm.True(operand)))
+		return nil
+	}
+
 	switch operand {
 	case True:
 		vm.stack.Push(False)
@@ -1444,7 +2534,21 @@ func (vm *VM) executeBangOperator() error {
 	case Null:
 		vm.stack.Push(True)
 	default:
-		vm.stack.Push(False)
+
+		// Functions, and fields, return their own booleans and
+		// nulls rather than ours.
+		switch obj := operand.(type) {
+		case *object.Boolean:
+			if obj.Value {
+				vm.stack.Push(False)
+			} else {
+				vm.stack.Push(True)
+			}
+		case *object.Null:
+			vm.stack.Push(True)
+		default:
+			vm.stack.Push(False)
+		}
 	}
 	return nil
 }
@@ -1459,9 +2563,9 @@ func (vm *VM) executeMinusOperator() error {
 
 	switch obj := operand.(type) {
 	case *object.Integer:
-		res = &object.Integer{Value: -obj.Value}
+		res = vm.arena.integer(-obj.Value)
 	case *object.Float:
-		res = &object.Float{Value: -obj.Value}
+		res = vm.arena.float(-obj.Value)
 	default:
 		return fmt.Errorf("unsupported type for negation: %s", operand.Type())
 	}
@@ -1480,9 +2584,9 @@ func (vm *VM) executeSquareRoot() error {
 
 	switch obj := operand.(type) {
 	case *object.Integer:
-		res = &object.Float{Value: math.Sqrt(float64(obj.Value))}
+		res = vm.arena.float(math.Sqrt(float64(obj.Value)))
 	case *object.Float:
-		res = &object.Float{Value: math.Sqrt(obj.Value)}
+		res = vm.arena.float(math.Sqrt(obj.Value))
 	default:
 		return fmt.Errorf("unsupported type for square-root: %s", operand.Type())
 	}
@@ -1516,19 +2620,26 @@ func (vm *VM) lookup(obj interface{}, name string) object.Object {
 
 	//
 	// Now we assume this is a reference to a map-key, or
-	// object member.
+	// object member, which we might have converted already.
+	//
+	if cached, found := vm.fields[name]; found {
+		return cached
+	}
+
 	//
-	// If we've not discovered them then do so now
+	// If not then we convert it now, and remember it.
 	//
-	if len(vm.fields) == 0 {
-		vm.inspectObject(obj)
+	if val, found := vm.field(obj, name); found {
+		vm.fields[name] = val
+		return val
 	}
 
 	//
-	// Now perform the lookup
+	// Finally it might be the name of a function, which is
+	// being used as a value.
 	//
-	if cached, found := vm.fields[name]; found {
-		return cached
+	if fn, found := vm.lookupFunction(name); found {
+		return fn
 	}
 
 	//
@@ -1541,8 +2652,8 @@ func (vm *VM) lookup(obj interface{}, name string) object.Object {
 func (vm *VM) executeIndexExpression(left, index object.Object) error {
 
 	// Check arguments
-	if left.Type() != object.ARRAY && left.Type() != object.HASH && left.Type() != object.STRING {
-		return fmt.Errorf("the index operator can only be applied to arrays, hashes, and strings, not %s", left.Type())
+	if left.Type() != object.ARRAY && left.Type() != object.HASH && left.Type() != object.STRING && left.Type() != object.BYTES {
+		return fmt.Errorf("the index operator can only be applied to arrays, bytes, hashes, and strings, not %s", left.Type())
 	}
 	if left.Type() == object.HASH {
 		return vm.executeHashIndex(left, index)
@@ -1554,14 +2665,33 @@ func (vm *VM) executeIndexExpression(left, index object.Object) error {
 	// Get the index we should lookup
 	idx := index.(*object.Integer).Value
 
+	// Looking at bytes?  Their members are integers, and
+	// negative indexes count from the end.
+	if b, ok := left.(*object.Bytes); ok {
+		l := int64(len(b.Value))
+		if idx < 0 {
+			idx += l
+		}
+		if idx < 0 || idx >= l {
+			vm.stack.Push(Null)
+			return nil
+		}
+		vm.stack.Push(object.Int(int64(b.Value[idx])))
+		return nil
+	}
+
 	// Looking at a string?
 	if left.Type() == object.STRING {
 
 		str := left.(*object.String).Inspect()
 
-		// Count the characters
+		// Count the characters, negative indexes count
+		// from the end.
 		l := utf8.RuneCountInString(str)
-		if idx < 0 || int(idx) > l {
+		if idx < 0 {
+			idx += int64(l)
+		}
+		if idx < 0 || int(idx) >= l {
 			vm.stack.Push(Null)
 			return nil
 		}
@@ -1570,7 +2700,7 @@ func (vm *VM) executeIndexExpression(left, index object.Object) error {
 		chars := []rune(str)
 
 		// Now index
-		val := &object.String{Value: string(chars[idx])}
+		val := vm.arena.string(string(chars[idx]))
 
 		vm.stack.Push(val)
 		return nil
@@ -1579,6 +2709,11 @@ func (vm *VM) executeIndexExpression(left, index object.Object) error {
 	// OK here we know we're dealing with an array.
 	arrayObject := left.(*object.Array)
 
+	// negative indexes count from the end
+	if idx < 0 {
+		idx += int64(len(arrayObject.Elements))
+	}
+
 	// bounds-check
 	max := int64(len(arrayObject.Elements) - 1)
 	if idx < 0 || idx > max {
@@ -1591,6 +2726,98 @@ func (vm *VM) executeIndexExpression(left, index object.Object) error {
 	return nil
 }
 
+// executeSliceExpression pushes the members of an array, or the
+// characters of a string, between the given bounds.
+//
+// As in python negative bounds count from the end, bounds beyond either
+// end are clamped, and a start after the end results in an empty slice.
+// Void bounds are those which were omitted.
+func (vm *VM) executeSliceExpression(left, start, end object.Object) error {
+
+	var length int
+	switch obj := left.(type) {
+	case *object.Array:
+		length = len(obj.Elements)
+	case *object.String:
+		length = utf8.RuneCountInString(obj.Value)
+	default:
+		return fmt.Errorf("slices can only be taken of arrays and strings, not %s", left.Type())
+	}
+
+	// Find the bounds.
+	bound := func(val object.Object, def int) (int, error) {
+		if val.Type() == object.VOID {
+			return def, nil
+		}
+		i, ok := val.(*object.Integer)
+		if !ok {
+			return 0, fmt.Errorf("the bounds of a slice must be integers, not %s", val.Type())
+		}
+		n := i.Value
+		if n < 0 {
+			n += int64(length)
+		}
+		if n < 0 {
+			n = 0
+		}
+		if n > int64(length) {
+			n = int64(length)
+		}
+		return int(n), nil
+	}
+
+	from, err := bound(start, 0)
+	if err != nil {
+		return err
+	}
+	to, err := bound(end, length)
+	if err != nil {
+		return err
+	}
+	if to < from {
+		to = from
+	}
+
+	if str, ok := left.(*object.String); ok {
+		err = vm.allocate(int64(to - from))
+		if err != nil {
+			return err
+		}
+		vm.stack.Push(vm.arena.string(string([]rune(str.Value)[from:to])))
+		return nil
+	}
+
+	err = vm.allocate(int64(to-from) * elementSize)
+	if err != nil {
+		return err
+	}
+	elements := make([]object.Object, to-from)
+	copy(elements, left.(*object.Array).Elements[from:to])
+	vm.stack.Push(&object.Array{Elements: elements})
+	return nil
+}
+
+// lookupMember returns the value of the given key within a hash, or Void
+// if the key is not present - or the object isn't a hash at all.
+//
+// This is used by match expressions to test, and bind, hash members.
+func (vm *VM) lookupMember(obj, key object.Object) object.Object {
+
+	hash, ok := obj.(*object.Hash)
+	if !ok {
+		return Void
+	}
+	hashable, ok := key.(object.Hashable)
+	if !ok {
+		return Void
+	}
+	pair, ok := hash.Pairs[hashable.HashKey()]
+	if !ok {
+		return Void
+	}
+	return pair.Value
+}
+
 func (vm *VM) executeHashIndex(hash, index object.Object) error {
 	hashObject := hash.(*object.Hash)
 
@@ -1609,6 +2836,72 @@ func (vm *VM) executeHashIndex(hash, index object.Object) error {
 	return nil
 }
 
+// missingMember returns true if the given hash doesn't contain the given
+// key.
+func missingMember(hash, index object.Object) bool {
+
+	h, ok := hash.(*object.Hash)
+	if !ok {
+		return false
+	}
+	key, ok := index.(object.Hashable)
+	if !ok {
+		return false
+	}
+	_, ok = h.Pairs[key.HashKey()]
+	return !ok
+}
+
+// executeSetIndex stores a value into the given array/hash member.
+func (vm *VM) executeSetIndex(left, index, value object.Object) error {
+
+	// Negative indexes count from the end of arrays.
+	if arr, ok := left.(*object.Array); ok {
+		if i, ok := index.(*object.Integer); ok && i.Value < 0 {
+			index = object.Int(i.Value + int64(len(arr.Elements)))
+		}
+	}
+
+	// Members of the object may need special handling.
+	err := vm.setMember(left, index, value)
+	if err != nil {
+		return err
+	}
+
+	switch obj := left.(type) {
+
+	case *object.Hash:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return fmt.Errorf("unusable as hash key: %s", index.Type())
+		}
+		if obj.Pairs == nil {
+			obj.Pairs = make(map[object.HashKey]object.HashPair)
+		}
+		if _, ok := obj.Pairs[key.HashKey()]; !ok {
+			err = vm.allocate(pairSize)
+			if err != nil {
+				return err
+			}
+		}
+		obj.Pairs[key.HashKey()] = object.HashPair{Key: index, Value: value}
+		return nil
+
+	case *object.Array:
+		if index.Type() != object.INTEGER {
+			return fmt.Errorf("index operator must be given an integer, not %s", index.Type())
+		}
+		idx := index.(*object.Integer).Value
+		if idx < 0 || idx >= int64(len(obj.Elements)) {
+			return fmt.Errorf("index %d out of range for array of length %d", idx, len(obj.Elements))
+		}
+		obj.Elements[idx] = value
+		return nil
+	}
+
+	return fmt.Errorf("members can only be set in arrays and hashes, not %s", left.Type())
+}
+
 // walkBytecode iterates over a block of bytecode, invoking the callback
 // on every instruction.
 //
@@ -1706,3 +2999,18 @@ func (vm *VM) WalkFunctionBytecode(name string, callback BytecodeVisitor) error
 	//
 	return (vm.walkBytecodeHelper(fun.Bytecode, callback))
 }
+
+// WalkClosureBytecode invokes the specified callback function upon every
+// instruction in the bytecode of the anonymous function which is held in
+// the constant-pool at the given index.
+func (vm *VM) WalkClosureBytecode(index int, callback BytecodeVisitor) error {
+
+	if index < 0 || index >= len(vm.constants) {
+		return fmt.Errorf("constant not found %d", index)
+	}
+	fun, ok := vm.constants[index].(*object.Function)
+	if !ok {
+		return fmt.Errorf("constant %d is not a function", index)
+	}
+	return (vm.walkBytecodeHelper(fun.Bytecode, callback))
+}
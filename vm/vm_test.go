@@ -7,6 +7,8 @@ package vm
 
 import (
 	"context"
+	"fmt"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -110,6 +112,51 @@ func TestContextTimeout(t *testing.T) {
 	}
 }
 
+// TestRunContext tests that a run may be cancelled via its context.
+func TestRunContext(t *testing.T) {
+
+	// The program we run - endless loop
+	bytecode := code.Instructions{
+		byte(code.OpJump),
+		byte(0),
+		byte(0),
+	}
+	vm := New([]object.Object{}, bytecode, make(map[string]environment.UserFunction), environment.New())
+
+	// A deadline.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := vm.RunContext(ctx, nil)
+	if err == nil || !strings.Contains(err.Error(), "timeout during execution") {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+
+	// A cancellation.
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	_, err = vm.RunContext(ctx, nil)
+	if err == nil || !strings.Contains(err.Error(), "execution cancelled") {
+		t.Fatalf("expected a cancellation, got %v", err)
+	}
+
+	// A program isn't run if the context is already done, even if
+	// it would finish immediately.
+	vm = New([]object.Object{}, code.Instructions{byte(code.OpTrue), byte(code.OpReturn)}, make(map[string]environment.UserFunction), environment.New())
+	_, err = vm.RunContext(ctx, nil)
+	if err == nil || !strings.Contains(err.Error(), "execution cancelled") {
+		t.Fatalf("expected a cancellation, got %v", err)
+	}
+
+	// The context is only used for that run.
+	out, err := vm.Run(nil)
+	if err != nil || out.Inspect() != "true" {
+		t.Fatalf("unexpected result %v %v", out, err)
+	}
+}
+
 // TestDivideByZero is testing a division by zero in the optimizer
 // NOT at runtime.
 //
@@ -316,6 +363,19 @@ func TestOpBang(t *testing.T) {
 			error:  false,
 		},
 
+		// !false -> true, for a boolean which isn't ours
+		{
+			program: code.Instructions{
+				byte(code.OpConstant), // 0x00
+				byte(0),               // 0x01
+				byte(1),               // 0x02
+				byte(code.OpBang),     // 0x03
+				byte(code.OpReturn),   // 0x04
+			},
+			result: "true",
+			error:  false,
+		},
+
 		// Test empty stack
 		{
 			program: code.Instructions{
@@ -328,6 +388,65 @@ func TestOpBang(t *testing.T) {
 
 	constants := []object.Object{
 		&object.String{Value: "Steve"},
+		&object.Boolean{Value: false},
+	}
+
+	RunTestCases(tests, constants, t)
+}
+
+func TestOpContains(t *testing.T) {
+
+	tests := []TestCase{
+
+		// "Steve" contains "eve"
+		{
+			program: code.Instructions{
+				byte(code.OpConstant),
+				byte(0),
+				byte(0),
+				byte(code.OpConstant),
+				byte(0),
+				byte(1),
+				byte(code.OpContains),
+				byte(code.OpReturn),
+			},
+			result: "true",
+		},
+
+		// [ "Steve" ] doesn't contain "eve"
+		{
+			program: code.Instructions{
+				byte(code.OpConstant),
+				byte(0),
+				byte(0),
+				byte(code.OpArray),
+				byte(0),
+				byte(1),
+				byte(code.OpConstant),
+				byte(0),
+				byte(1),
+				byte(code.OpContains),
+				byte(code.OpReturn),
+			},
+			result: "false",
+		},
+
+		// Test empty stack
+		{
+			program: code.Instructions{
+				byte(code.OpConstant),
+				byte(0),
+				byte(0),
+				byte(code.OpContains),
+			},
+			result: "Pop from an empty stack",
+			error:  true,
+		},
+	}
+
+	constants := []object.Object{
+		&object.String{Value: "Steve"},
+		&object.String{Value: "eve"},
 	}
 
 	RunTestCases(tests, constants, t)
@@ -1024,7 +1143,7 @@ func TestOpIndex(t *testing.T) {
 				byte(code.OpIndex),
 				byte(code.OpReturn),
 			},
-			result: "the index operator can only be applied to arrays, hashes, and strings,",
+			result: "the index operator can only be applied to arrays, bytes, hashes, and strings,",
 			error:  true,
 		},
 
@@ -1091,107 +1210,480 @@ func TestOpIndex(t *testing.T) {
 				// index
 				byte(code.OpPush),
 				byte(0),
-				byte(1),
-				byte(code.OpIndex),
+				byte(1),
+				byte(code.OpIndex),
+				byte(code.OpReturn),
+			},
+			result: "2",
+			error:  false,
+		},
+
+		// create array: array[255]
+		{
+			program: code.Instructions{
+				// create [1,2,3,...8,9,10]
+				byte(code.OpPush),
+				byte(0),
+				byte(1),
+				byte(code.OpPush),
+				byte(0),
+				byte(10),
+				byte(code.OpRange),
+
+				// index
+				byte(code.OpPush),
+				byte(0),
+				byte(255),
+				byte(code.OpIndex),
+				byte(code.OpReturn),
+			},
+			result: "null",
+			error:  false,
+		},
+
+		// hash: {foo: bar}["steve"]
+		{
+			program: code.Instructions{
+				byte(code.OpConstant),
+				byte(0),
+				byte(1), // "foo"
+				byte(code.OpConstant),
+				byte(0),
+				byte(2), // "bar"
+				byte(code.OpHash),
+				byte(0),
+				byte(2),
+				byte(code.OpConstant),
+				byte(0),
+				byte(0), // "steve"
+				byte(code.OpIndex),
+				byte(code.OpReturn),
+			},
+			result: "null",
+			error:  false,
+		},
+
+		// hash: {foo: bar}["foo"]
+		{
+			program: code.Instructions{
+				byte(code.OpConstant),
+				byte(0),
+				byte(1), // "foo"
+				byte(code.OpConstant),
+				byte(0),
+				byte(2), // "bar"
+				byte(code.OpHash),
+				byte(0),
+				byte(2),
+				byte(code.OpConstant),
+				byte(0),
+				byte(1), // "foo"
+				byte(code.OpIndex),
+				byte(code.OpReturn),
+			},
+			result: "bar",
+			error:  false,
+		},
+
+		// hash: {foo: bar}[true] -> error
+		{
+			program: code.Instructions{
+				byte(code.OpConstant),
+				byte(0),
+				byte(1), // "foo"
+				byte(code.OpConstant),
+				byte(0),
+				byte(2), // "bar"
+				byte(code.OpHash),
+				byte(0),
+				byte(2),
+				byte(code.OpTrue),
+				byte(code.OpIndex),
+				byte(code.OpReturn),
+			},
+			result: "unusable as hash key:",
+			error:  true,
+		},
+	}
+
+	// Constants
+	constants := []object.Object{&object.String{Value: "Steve"},
+		&object.String{Value: "foo"},
+		&object.String{Value: "bar"},
+	}
+
+	RunTestCases(tests, constants, t)
+}
+
+func TestOpSetIndex(t *testing.T) {
+
+	tests := []TestCase{
+
+		// empty stack
+		{
+			program: code.Instructions{
+				byte(code.OpSetIndex),
+				byte(0),
+				byte(code.OpNop),
+			},
+			result: "Pop from an empty stack",
+			error:  true,
+		},
+
+		// a = 1..3; a[1] = 5; return a;
+		{
+			program: code.Instructions{
+				byte(code.OpPush),
+				byte(0),
+				byte(1),
+				byte(code.OpPush),
+				byte(0),
+				byte(3),
+				byte(code.OpRange),
+				byte(code.OpConstant),
+				byte(0),
+				byte(0),
+				byte(code.OpSet),
+
+				byte(code.OpLookup),
+				byte(0),
+				byte(0),
+				byte(code.OpPush),
+				byte(0),
+				byte(1),
+				byte(code.OpPush),
+				byte(0),
+				byte(5),
+				byte(code.OpSetIndex),
+				byte(0),
+				byte(code.OpNop),
+
+				byte(code.OpLookup),
+				byte(0),
+				byte(0),
+				byte(code.OpReturn),
+			},
+			result: "[1, 5, 3]",
+		},
+
+		// a = 1..3; a[1] += 5; return a;
+		{
+			program: code.Instructions{
+				byte(code.OpPush),
+				byte(0),
+				byte(1),
+				byte(code.OpPush),
+				byte(0),
+				byte(3),
+				byte(code.OpRange),
+				byte(code.OpConstant),
+				byte(0),
+				byte(0),
+				byte(code.OpSet),
+
+				byte(code.OpLookup),
+				byte(0),
+				byte(0),
+				byte(code.OpPush),
+				byte(0),
+				byte(1),
+				byte(code.OpPush),
+				byte(0),
+				byte(5),
+				byte(code.OpSetIndex),
+				byte(0),
+				byte(code.OpAdd),
+
+				byte(code.OpLookup),
+				byte(0),
+				byte(0),
+				byte(code.OpReturn),
+			},
+			result: "[1, 7, 3]",
+		},
+
+		// (1..3)[5] = 1 -> out of range
+		{
+			program: code.Instructions{
+				byte(code.OpPush),
+				byte(0),
+				byte(1),
+				byte(code.OpPush),
+				byte(0),
+				byte(3),
+				byte(code.OpRange),
+				byte(code.OpPush),
+				byte(0),
+				byte(5),
+				byte(code.OpPush),
+				byte(0),
+				byte(1),
+				byte(code.OpSetIndex),
+				byte(0),
+				byte(code.OpNop),
+			},
+			result: "out of range",
+			error:  true,
+		},
+
+		// {}[true] = 1 -> bogus key
+		{
+			program: code.Instructions{
+				byte(code.OpHash),
+				byte(0),
+				byte(0),
+				byte(code.OpTrue),
+				byte(code.OpPush),
+				byte(0),
+				byte(1),
+				byte(code.OpSetIndex),
+				byte(0),
+				byte(code.OpNop),
+			},
+			result: "unusable as hash key",
+			error:  true,
+		},
+
+		// "a"[0] = 1 -> strings are immutable
+		{
+			program: code.Instructions{
+				byte(code.OpConstant),
+				byte(0),
+				byte(0),
+				byte(code.OpPush),
+				byte(0),
+				byte(0),
+				byte(code.OpPush),
+				byte(0),
+				byte(1),
+				byte(code.OpSetIndex),
+				byte(0),
+				byte(code.OpNop),
+			},
+			result: "members can only be set in arrays and hashes",
+			error:  true,
+		},
+
+		// {}["a"] *= 2 -> the member is missing, and only
+		// addition and subtraction begin from zero
+		{
+			program: code.Instructions{
+				byte(code.OpHash),
+				byte(0),
+				byte(0),
+				byte(code.OpConstant),
+				byte(0),
+				byte(0),
+				byte(code.OpPush),
+				byte(0),
+				byte(2),
+				byte(code.OpSetIndex),
+				byte(0),
+				byte(code.OpMul),
+			},
+			result: "type mismatch: NULL OpMul INTEGER",
+			error:  true,
+		},
+	}
+
+	// Constants
+	constants := []object.Object{&object.String{Value: "a"}}
+
+	RunTestCases(tests, constants, t)
+}
+
+func TestOpMember(t *testing.T) {
+
+	tests := []TestCase{
+
+		// empty stack
+		{
+			program: code.Instructions{
+				byte(code.OpDup),
+			},
+			result: "Pop from an empty stack",
+			error:  true,
+		},
+		{
+			program: code.Instructions{
+				byte(code.OpPop),
+			},
+			result: "Pop from an empty stack",
+			error:  true,
+		},
+		{
+			program: code.Instructions{
+				byte(code.OpTrue),
+				byte(code.OpMember),
+			},
+			result: "Pop from an empty stack",
+			error:  true,
+		},
+
+		// 1, 2, pop -> 1
+		{
+			program: code.Instructions{
+				byte(code.OpPush),
+				byte(0),
+				byte(1),
+				byte(code.OpPush),
+				byte(0),
+				byte(2),
+				byte(code.OpPop),
+				byte(code.OpReturn),
+			},
+			result: "1",
+		},
+
+		// 3, dup, add -> 6
+		{
+			program: code.Instructions{
+				byte(code.OpPush),
+				byte(0),
+				byte(3),
+				byte(code.OpDup),
+				byte(code.OpAdd),
+				byte(code.OpReturn),
+			},
+			result: "6",
+		},
+
+		// {"a": 7}["a"] -> 7
+		{
+			program: code.Instructions{
+				byte(code.OpConstant),
+				byte(0),
+				byte(0),
+				byte(code.OpPush),
+				byte(0),
+				byte(7),
+				byte(code.OpHash),
+				byte(0),
+				byte(2),
+				byte(code.OpConstant),
+				byte(0),
+				byte(0),
+				byte(code.OpMember),
+				byte(code.OpReturn),
+			},
+			result: "7",
+		},
+
+		// {}["a"] -> void
+		{
+			program: code.Instructions{
+				byte(code.OpHash),
+				byte(0),
+				byte(0),
+				byte(code.OpConstant),
+				byte(0),
+				byte(0),
+				byte(code.OpMember),
+				byte(code.OpReturn),
+			},
+			result: "void",
+		},
+
+		// "a"["a"] -> void, rather than an error
+		{
+			program: code.Instructions{
+				byte(code.OpConstant),
+				byte(0),
+				byte(0),
+				byte(code.OpConstant),
+				byte(0),
+				byte(0),
+				byte(code.OpMember),
 				byte(code.OpReturn),
 			},
-			result: "2",
-			error:  false,
+			result: "void",
 		},
+	}
 
-		// create array: array[255]
+	// Constants
+	constants := []object.Object{&object.String{Value: "a"}}
+
+	RunTestCases(tests, constants, t)
+}
+
+func TestOpJumpTable(t *testing.T) {
+
+	tests := []TestCase{
+
+		// empty stack
 		{
 			program: code.Instructions{
-				// create [1,2,3,...8,9,10]
-				byte(code.OpPush),
-				byte(0),
-				byte(1),
-				byte(code.OpPush),
+				byte(code.OpJumpTable),
 				byte(0),
-				byte(10),
-				byte(code.OpRange),
-
-				// index
-				byte(code.OpPush),
 				byte(0),
-				byte(255),
-				byte(code.OpIndex),
-				byte(code.OpReturn),
 			},
-			result: "null",
-			error:  false,
+			result: "Pop from an empty stack",
+			error:  true,
 		},
 
-		// hash: {foo: bar}["steve"]
+		// the constant must be a hash
 		{
 			program: code.Instructions{
 				byte(code.OpConstant),
 				byte(0),
-				byte(1), // "foo"
-				byte(code.OpConstant),
-				byte(0),
-				byte(2), // "bar"
-				byte(code.OpHash),
-				byte(0),
-				byte(2),
-				byte(code.OpConstant),
+				byte(1),
+				byte(code.OpJumpTable),
 				byte(0),
-				byte(0), // "steve"
-				byte(code.OpIndex),
-				byte(code.OpReturn),
+				byte(1),
 			},
-			result: "null",
-			error:  false,
+			result: "not a hash",
+			error:  true,
 		},
 
-		// hash: {foo: bar}["foo"]
+		// "a" is found, jump to offset 10 -> 2
 		{
 			program: code.Instructions{
 				byte(code.OpConstant),
 				byte(0),
-				byte(1), // "foo"
-				byte(code.OpConstant),
+				byte(1),
+				byte(code.OpJumpTable),
 				byte(0),
-				byte(2), // "bar"
-				byte(code.OpHash),
 				byte(0),
-				byte(2),
-				byte(code.OpConstant),
+				byte(code.OpPush),
 				byte(0),
-				byte(1), // "foo"
-				byte(code.OpIndex),
+				byte(1),
+				byte(code.OpReturn),
+				byte(code.OpPush),
+				byte(0),
+				byte(2),
 				byte(code.OpReturn),
 			},
-			result: "bar",
-			error:  false,
+			result: "2",
 		},
 
-		// hash: {foo: bar}[true] -> error
+		// 3 is not a string, fall through -> 1
 		{
 			program: code.Instructions{
-				byte(code.OpConstant),
+				byte(code.OpPush),
 				byte(0),
-				byte(1), // "foo"
-				byte(code.OpConstant),
+				byte(3),
+				byte(code.OpJumpTable),
 				byte(0),
-				byte(2), // "bar"
-				byte(code.OpHash),
+				byte(0),
+				byte(code.OpPush),
+				byte(0),
+				byte(1),
+				byte(code.OpReturn),
+				byte(code.OpPush),
 				byte(0),
 				byte(2),
-				byte(code.OpTrue),
-				byte(code.OpIndex),
 				byte(code.OpReturn),
 			},
-			result: "unusable as hash key:",
-			error:  true,
+			result: "1",
 		},
 	}
 
 	// Constants
-	constants := []object.Object{&object.String{Value: "Steve"},
-		&object.String{Value: "foo"},
-		&object.String{Value: "bar"},
-	}
+	key := &object.String{Value: "a"}
+	table := &object.Hash{Pairs: map[object.HashKey]object.HashPair{
+		key.HashKey(): {Key: key, Value: &object.Integer{Value: 10}},
+	}}
+	constants := []object.Object{table, key}
 
 	RunTestCases(tests, constants, t)
 }
@@ -1322,6 +1814,8 @@ func TestOpIterationReset(t *testing.T) {
 		},
 
 		// something that can be iterated over
+		//
+		// The object is replaced by the state of the iteration.
 		{
 			program: code.Instructions{
 				byte(code.OpConstant),
@@ -1330,9 +1824,75 @@ func TestOpIterationReset(t *testing.T) {
 				byte(code.OpIterationReset),
 				byte(code.OpReturn),
 			},
-			result: "Steve",
+			result: "<iterator>",
+			error:  false,
+		},
+	}
+
+	constants := []object.Object{&object.String{Value: "Steve"}}
+
+	RunTestCases(tests, constants, t)
+}
+
+func TestOpIterationEnd(t *testing.T) {
+
+	tests := []TestCase{
+
+		// empty stack
+		{
+			program: code.Instructions{
+				byte(code.OpIterationEnd),
+			},
+			result: "Pop from an empty stack",
+			error:  true,
+		},
+
+		// something that isn't an iterator
+		{
+			program: code.Instructions{
+				byte(code.OpConstant),
+				byte(0),
+				byte(0),
+				byte(code.OpIterationEnd),
+			},
+			result: "STRING object is not an iterator",
+			error:  true,
+		},
+
+		// an iterator is discarded, along with its scope
+		{
+			program: code.Instructions{
+				byte(code.OpTrue),
+				byte(code.OpConstant),
+				byte(0),
+				byte(0),
+				byte(code.OpIterationReset),
+				byte(code.OpIterationEnd),
+				byte(code.OpReturn),
+			},
+			result: "true",
 			error:  false,
 		},
+
+		// there must be a scope to discard
+		{
+			program: code.Instructions{
+				byte(code.OpConstant),
+				byte(0),
+				byte(0),
+				byte(code.OpIterationReset),
+				byte(code.OpIterationEnd),
+				byte(code.OpConstant),
+				byte(0),
+				byte(0),
+				byte(code.OpIterationReset),
+				byte(code.OpDup),
+				byte(code.OpIterationEnd),
+				byte(code.OpIterationEnd),
+			},
+			result: "no scopes are present",
+			error:  true,
+		},
 	}
 
 	constants := []object.Object{&object.String{Value: "Steve"}}
@@ -1464,7 +2024,7 @@ func TestOpLookup(t *testing.T) {
 			byte(0),
 			byte(2),
 			byte(code.OpReturn),
-		}, result: "1598613755", error: false},
+		}, result: "2020-08-28T11:22:35Z", error: false},
 
 		// lookup bool
 		{program: code.Instructions{
@@ -1512,7 +2072,7 @@ func TestOpLookup(t *testing.T) {
 	// The instance of that object.
 	in := Input{Name: "Steve Kemp",
 		Array: []string{"Bart", "Lisa", "Maggie"},
-		Time:  time.Unix(1598613755, 0),
+		Time:  time.Unix(1598613755, 0).UTC(),
 		True:  true,
 		Int:   17,
 		Float: 3.2}
@@ -1520,7 +2080,7 @@ func TestOpLookup(t *testing.T) {
 	m := make(map[string]interface{})
 	m["Name"] = "Steve Kemp"
 	m["Array"] = []string{"Bart", "Lisa", "Maggie"}
-	m["Time"] = time.Unix(1598613755, 0)
+	m["Time"] = time.Unix(1598613755, 0).UTC()
 	m["True"] = true
 	m["Int"] = 17
 	m["Float"] = 3.2
@@ -2501,3 +3061,237 @@ func TestBinOp(t *testing.T) {
 	}
 
 }
+
+// TestArena tests the reuse of temporaries.
+func TestArena(t *testing.T) {
+
+	var a arena
+
+	// Objects are reused once they're released.
+	first := a.integer(10001)
+	for i := 0; i < arenaChunk; i++ {
+		a.string("temporary")
+	}
+	a.release()
+	if a.integer(10002) != first || first.Value != 10002 {
+		t.Fatalf("the integer wasn't reused")
+	}
+	if a.strings[0][1].Value != "" {
+		t.Fatalf("the string wasn't cleared")
+	}
+
+	// Unless they might have escaped.
+	a.escape()
+	a.release()
+	kept := a.integer(10003)
+	if kept == first || first.Value != 10002 {
+		t.Fatalf("an escaped integer was reused")
+	}
+
+	// The objects which were never handed out are used.
+	a.release()
+	if a.integer(10004) != kept {
+		t.Fatalf("the arena wasn't reused")
+	}
+
+	// Results are copied.
+	out := a.keep(kept)
+	if out == kept || out.Inspect() != "10004" {
+		t.Fatalf("the result wasn't copied")
+	}
+
+	// Nothing is reused when disabled.
+	a.disabled = true
+	if a.integer(10005) == a.integer(10005) || a.keep(kept) != kept {
+		t.Fatalf("the arena was used whilst disabled")
+	}
+
+	// Small integers are shared, rather than coming from the arena.
+	a.disabled = false
+	if a.integer(5) != object.Int(5) || a.keep(object.Int(5)) != object.Int(5) {
+		t.Fatalf("a small integer wasn't shared")
+	}
+}
+
+// TestSeverity tests parsing, and naming, severities.
+func TestSeverity(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		severity Severity
+	}{
+		{"informational", SeverityInformational},
+		{"info", SeverityInformational},
+		{"Low", SeverityLow},
+		{"medium", SeverityMedium},
+		{"HIGH", SeverityHigh},
+		{"critical", SeverityCritical},
+	}
+	for _, test := range tests {
+		s, err := ParseSeverity(test.name)
+		if err != nil || s != test.severity {
+			t.Fatalf("unexpected result parsing %s: %v %v", test.name, s, err)
+		}
+		if s.String() != strings.ToLower(test.name) && test.name != "info" {
+			t.Fatalf("unexpected name for %s: %s", test.name, s)
+		}
+	}
+
+	_, err := ParseSeverity("severe")
+	if err == nil {
+		t.Fatalf("expected an error parsing an unknown severity")
+	}
+	if SeverityNone.String() != "none" || Severity(12).String() != "Severity(12)" {
+		t.Fatalf("unexpected names: %s %s", SeverityNone, Severity(12))
+	}
+}
+
+func TestOpField(t *testing.T) {
+
+	tests := []TestCase{
+
+		// empty stack
+		{
+			program: code.Instructions{
+				byte(code.OpField),
+			},
+			result: "Pop from an empty stack",
+			error:  true,
+		},
+
+		// {"a": 7}.a -> 7
+		{
+			program: code.Instructions{
+				byte(code.OpConstant),
+				byte(0),
+				byte(0),
+				byte(code.OpPush),
+				byte(0),
+				byte(7),
+				byte(code.OpHash),
+				byte(0),
+				byte(2),
+				byte(code.OpConstant),
+				byte(0),
+				byte(0),
+				byte(code.OpField),
+				byte(code.OpReturn),
+			},
+			result: "7",
+		},
+
+		// null.a -> null, rather than an error
+		{
+			program: code.Instructions{
+				byte(code.OpConstant),
+				byte(0),
+				byte(1),
+				byte(code.OpConstant),
+				byte(0),
+				byte(0),
+				byte(code.OpField),
+				byte(code.OpReturn),
+			},
+			result: "null",
+		},
+
+		// true.a -> error, as with OpIndex
+		{
+			program: code.Instructions{
+				byte(code.OpTrue),
+				byte(code.OpConstant),
+				byte(0),
+				byte(0),
+				byte(code.OpField),
+				byte(code.OpReturn),
+			},
+			result: "the index operator can only be applied to arrays, bytes, hashes, and strings, not BOOLEAN",
+			error:  true,
+		},
+	}
+
+	// Constants
+	constants := []object.Object{&object.String{Value: "a"}, &object.Null{}}
+
+	RunTestCases(tests, constants, t)
+}
+
+// TestStructFields tests that the fields we remember for each type of
+// structure are those found by walking its values.
+func TestStructFields(t *testing.T) {
+
+	type Inner struct {
+		Name string
+		Deep int
+	}
+	type Middle struct {
+		*Inner
+		Name string `json:"middle_name"`
+	}
+	type Loop struct {
+		*Loop
+		Value int
+	}
+	type Outer struct {
+		Middle
+		*Inner `json:"inner"`
+		fmt.Stringer
+		Hidden string `json:"-"`
+		Deep   string
+	}
+	type Plain struct {
+		Middle
+		*Inner `json:"inner"`
+		Hidden string `json:"-"`
+	}
+
+	values := []interface{}{
+		Inner{Name: "a"},
+		Middle{Name: "b"},
+		Middle{Inner: &Inner{Name: "c", Deep: 3}},
+		Outer{},
+		Outer{Middle: Middle{Inner: &Inner{Deep: 4}}, Inner: &Inner{Name: "d"}},
+		Loop{Loop: &Loop{Value: 2}, Value: 1},
+		Plain{},
+		Plain{Middle: Middle{Inner: &Inner{Name: "e", Deep: 5}}, Hidden: "f"},
+	}
+
+	for _, v := range values {
+		for _, tag := range []string{"", "json"} {
+
+			val := reflect.ValueOf(v)
+
+			var cached, walked []string
+			walkStruct(val, tag, func(name string, field reflect.Value) {
+				cached = append(cached, fmt.Sprintf("%s=%v", name, field))
+			})
+			walkValues(val, tag, func(name string, field reflect.Value) {
+				walked = append(walked, fmt.Sprintf("%s=%v", name, field))
+			})
+			if strings.Join(cached, " ") != strings.Join(walked, " ") {
+				t.Fatalf("wrong fields for %T with tag '%s': %v != %v", v, tag, cached, walked)
+			}
+
+			for _, name := range append([]string{"Missing"}, walked...) {
+				name = strings.SplitN(name, "=", 2)[0]
+				found := fieldByName(val, tag, name)
+
+				var expected reflect.Value
+				walkValues(val, tag, func(n string, field reflect.Value) {
+					if n == name {
+						expected = field
+					}
+				})
+				if found.IsValid() != expected.IsValid() || (found.IsValid() && fmt.Sprint(found) != fmt.Sprint(expected)) {
+					t.Fatalf("wrong field %s for %T: %v != %v", name, v, found, expected)
+				}
+			}
+		}
+	}
+
+	// Types are only walked once.
+	first := structFields(reflect.TypeOf(Outer{}), "json")
+	if structFields(reflect.TypeOf(Outer{}), "json") != first || structFields(reflect.TypeOf(Outer{}), "") == first {
+		t.Fatalf("the fields of the type weren't cached")
+	}
+}
@@ -0,0 +1,244 @@
+// This file contains the warnings which are generated when the optimizer
+// finds that a condition always has the same result.
+//
+// A rule such as:
+//
+//    limit = 10;
+//    if ( limit > 100 ) { return true; }
+//
+// is accepted, and its bytecode simplified, but the branch can never be
+// taken - which nearly always means the author made a mistake.  So we
+// record a warning, describing where the condition was found, which the
+// host may retrieve via `Warnings` once the script has been prepared.
+//
+// Similarly functions may be deprecated, via DeprecateFunction, so that
+// the scripts which still call them are warned, rather than broken, when
+// the function is eventually replaced.
+
+package evalfilter
+
+import (
+	"fmt"
+
+	"github.com/skx/evalfilter/v2/ast"
+	"github.com/skx/evalfilter/v2/token"
+)
+
+// Warnings returns the warnings which were generated when the script was
+// prepared, such as conditions which are always true or always false.
+//
+// Warnings about conditions are only generated when the optimizer is
+// enabled, whilst calls to deprecated functions are always reported.
+func (e *Eval) Warnings() []string {
+	return e.warnings
+}
+
+// DeprecateFunction marks the named function, which may be one of our
+// built-in functions or one added via AddFunction, as deprecated.
+//
+// The function still works, but Prepare records a warning for each call
+// to it, which suggests the replacement given - unless that is empty.
+func (e *Eval) DeprecateFunction(name string, replacement string) {
+	e.environment.Deprecate(name, replacement)
+}
+
+// checkDeprecated records a warning if the given call is to a function
+// which is deprecated.
+func (e *Eval) checkDeprecated(call *ast.CallExpression) {
+
+	ident, ok := call.Function.(*ast.Identifier)
+	if !ok {
+		return
+	}
+	hint, ok := e.environment.Deprecated(ident.Value)
+	if !ok {
+		return
+	}
+
+	// Host functions are found before those the script defines.
+	if _, ok := e.environment.GetFunction(ident.Value); !ok {
+		return
+	}
+
+	if hint == "" {
+		e.warn(call.Token, "the function %s is deprecated", ident.Value)
+	} else {
+		e.warn(call.Token, "the function %s is deprecated; use %s instead", ident.Value, hint)
+	}
+}
+
+// warn records a warning about the code at the given token.
+func (e *Eval) warn(tok token.Token, format string, args ...interface{}) {
+
+	msg := fmt.Sprintf("%s: %s", tok.Position(), fmt.Sprintf(format, args...))
+
+	// Functions which are inlined are compiled at each call-site,
+	// so we might see the same code more than once.
+	for _, w := range e.warnings {
+		if w == msg {
+			return
+		}
+	}
+	e.warnings = append(e.warnings, msg)
+	e.warned = append(e.warned, tok)
+}
+
+// checkCondition records a warning if the given condition, of an `if`
+// statement or ternary expression, always has the same result.
+//
+// The rest names the code which is skipped if the condition is true.
+func (e *Eval) checkCondition(tok token.Token, cond ast.Expression, rest string) {
+
+	val, ok := e.always(cond)
+	if !ok {
+		return
+	}
+
+	if !val {
+		e.warn(tok, "condition is always false; branch unreachable")
+	} else if rest != "" {
+		e.warn(tok, "condition is always true; %s unreachable", rest)
+	} else {
+		e.warn(tok, "condition is always true")
+	}
+}
+
+// always returns the result of the given condition, if the optimizer is
+// enabled and the result is always the same.
+func (e *Eval) always(cond ast.Expression) (bool, bool) {
+	if e.simplified == nil {
+		return false, false
+	}
+	return e.constant(e.simplify(cond))
+}
+
+// constant returns the result of the given condition, if it is always
+// the same.
+//
+// We only consider booleans, and comparisons between numbers or strings,
+// which are literals, propagated constants, or simple arithmetic upon
+// them.
+func (e *Eval) constant(node ast.Expression) (bool, bool) {
+
+	switch n := node.(type) {
+	case *ast.BooleanLiteral:
+		return n.Value, true
+
+	case *ast.Identifier:
+		if val, ok := e.known[variable(n.Value)]; ok {
+			if b, ok := val.(*ast.BooleanLiteral); ok {
+				return b.Value, true
+			}
+		}
+
+	case *ast.PrefixExpression:
+		if n.Operator == "!" {
+			if val, ok := e.constant(n.Right); ok {
+				return !val, true
+			}
+		}
+
+	case *ast.InfixExpression:
+		switch n.Operator {
+		case "&&", "||":
+			left, ok := e.constant(n.Left)
+			if !ok {
+				return false, false
+			}
+			right, ok := e.constant(n.Right)
+			if !ok {
+				return false, false
+			}
+			if n.Operator == "&&" {
+				return left && right, true
+			}
+			return left || right, true
+		}
+
+		// Comparing two numbers?
+		a, okA := e.number(n.Left)
+		b, okB := e.number(n.Right)
+		if okA && okB {
+			switch n.Operator {
+			case "==":
+				return a == b, true
+			case "!=":
+				return a != b, true
+			case "<":
+				return a < b, true
+			case "<=":
+				return a <= b, true
+			case ">":
+				return a > b, true
+			case ">=":
+				return a >= b, true
+			}
+			return false, false
+		}
+
+		// Or two strings?
+		x, okX := e.str(n.Left)
+		y, okY := e.str(n.Right)
+		if okX && okY {
+			switch n.Operator {
+			case "==":
+				return x == y, true
+			case "!=":
+				return x != y, true
+			}
+		}
+	}
+
+	return false, false
+}
+
+// number returns the value of the given expression, if it is a constant
+// number.
+func (e *Eval) number(node ast.Expression) (float64, bool) {
+
+	switch n := node.(type) {
+	case *ast.IntegerLiteral:
+		return float64(n.Value), true
+	case *ast.FloatLiteral:
+		return n.Value, true
+	case *ast.PrefixExpression:
+		if n.Operator == "-" {
+			if val, ok := e.number(n.Right); ok {
+				return -val, true
+			}
+		}
+	case *ast.InfixExpression:
+		a, okA := e.number(n.Left)
+		b, okB := e.number(n.Right)
+		if okA && okB {
+			switch n.Operator {
+			case "+":
+				return a + b, true
+			case "-":
+				return a - b, true
+			case "*":
+				return a * b, true
+			}
+		}
+	case *ast.Identifier:
+		if val, ok := e.known[variable(n.Value)]; ok {
+			return e.number(val)
+		}
+	}
+	return 0, false
+}
+
+// str returns the value of the given expression, if it is a constant
+// string.
+func (e *Eval) str(node ast.Expression) (string, bool) {
+
+	switch n := node.(type) {
+	case *ast.StringLiteral:
+		return n.Value, true
+	case *ast.Identifier:
+		if val, ok := e.known[variable(n.Value)]; ok {
+			return e.str(val)
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,46 @@
+// This file contains the code which allows scripts to be run against
+// YAML documents.
+
+package evalfilter
+
+import (
+	"fmt"
+
+	"github.com/skx/evalfilter/v2/yaml"
+)
+
+// RunYAML runs the script against each of the documents within the given
+// YAML stream, and returns the result for each of them.
+//
+// Each document must be a mapping, which the script sees as a hash, so
+// that the fields of the document may be accessed by name just as the
+// fields of an object passed to Run are.  Documents are separated by
+// `---`, as is usual in manifests, and empty documents are skipped.
+//
+// As with Run you must invoke Prepare before RunYAML.  If a document cannot
+// be parsed, or the script fails, an error is returned identifying the
+// document.
+func (e *Eval) RunYAML(data []byte) ([]bool, error) {
+
+	docs, err := yaml.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []bool
+	for i, doc := range docs {
+
+		obj, ok := doc.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("document %d is not a mapping", i+1)
+		}
+
+		res, err := e.Run(obj)
+		if err != nil {
+			return nil, fmt.Errorf("document %d: %s", i+1, err)
+		}
+		out = append(out, res)
+	}
+
+	return out, nil
+}
@@ -0,0 +1,93 @@
+// This file contains the handling of anchors, and aliases.
+//
+// An alias refers to the value of an anchor, rather than copying it, so a
+// small document which nests aliases within anchors can describe a value
+// which is enormous when expanded - as our scripts would see it.  We limit
+// the size to which a document may expand to prevent such abuse.
+
+package yaml
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// maxExpansion holds the maximum number of values which may be referred
+// to via aliases, within a single document.
+const maxExpansion = 1000000
+
+// anchors holds the anchors within a document.
+type anchors struct {
+
+	// values holds the value of each anchor.
+	values map[string]interface{}
+
+	// sizes caches the size of the collections we've measured.
+	sizes map[uintptr]int
+
+	// expanded holds the number of values referred to by aliases.
+	expanded int
+}
+
+// newAnchors creates an empty set of anchors.
+func newAnchors() *anchors {
+	return &anchors{values: make(map[string]interface{}), sizes: make(map[uintptr]int)}
+}
+
+// set records the value of an anchor.
+func (a *anchors) set(name string, val interface{}) {
+	a.values[name] = val
+}
+
+// alias returns the value of the named anchor.
+func (a *anchors) alias(name string) (interface{}, error) {
+
+	val, ok := a.values[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown alias '%s'", name)
+	}
+
+	a.expanded += a.size(val)
+	if a.expanded > maxExpansion {
+		return nil, fmt.Errorf("too many aliases, the document expands to more than %d values", maxExpansion)
+	}
+	return val, nil
+}
+
+// size returns the number of values within the given value, when it is
+// fully expanded.
+//
+// Collections may be shared, via aliases, so we cache their sizes.
+func (a *anchors) size(val interface{}) int {
+
+	switch val.(type) {
+	case map[string]interface{}, []interface{}:
+	default:
+		return 1
+	}
+
+	ptr := reflect.ValueOf(val).Pointer()
+	if n, ok := a.sizes[ptr]; ok {
+		return n
+	}
+
+	n := 1
+	switch val := val.(type) {
+	case map[string]interface{}:
+		for _, v := range val {
+			n += a.size(v)
+		}
+	case []interface{}:
+		for _, v := range val {
+			n += a.size(v)
+		}
+	}
+
+	// Avoid overflow.
+	if n > maxExpansion {
+		n = maxExpansion + 1
+	}
+
+	a.sizes[ptr] = n
+	return n
+}
@@ -0,0 +1,247 @@
+// This file contains the parser for flow collections, such as
+// `[1, 2, 3]` and `{name: steve, age: 42}`.
+
+package yaml
+
+import (
+	"fmt"
+	"strings"
+)
+
+// flow holds the state of our flow-collection parser.
+type flow struct {
+
+	// text holds the collection, which has been joined into a single
+	// line if it spanned several.
+	text string
+
+	// pos holds our offset within the text.
+	pos int
+
+	// anchors holds the values of the anchors we've seen, which are
+	// shared with the block parser.
+	anchors *anchors
+}
+
+// parse parses the collection, which must be the whole of our text.
+func (f *flow) parse() (interface{}, error) {
+
+	val, err := f.value()
+	if err != nil {
+		return nil, err
+	}
+
+	f.skipSpace()
+	if f.pos < len(f.text) {
+		return nil, fmt.Errorf("unexpected content '%s'", f.text[f.pos:])
+	}
+	return val, nil
+}
+
+// skipSpace skips whitespace.
+func (f *flow) skipSpace() {
+	for f.pos < len(f.text) && (f.text[f.pos] == ' ' || f.text[f.pos] == '\t') {
+		f.pos++
+	}
+}
+
+// peek returns the current character, or zero at the end of our text.
+func (f *flow) peek() byte {
+	if f.pos < len(f.text) {
+		return f.text[f.pos]
+	}
+	return 0
+}
+
+// value parses a single value.
+func (f *flow) value() (interface{}, error) {
+
+	f.skipSpace()
+
+	switch f.peek() {
+	case 0:
+		return nil, fmt.Errorf("unexpected end of flow collection")
+
+	case '[':
+		return f.sequence()
+
+	case '{':
+		return f.mapping()
+
+	case '"', '\'':
+		str, n, err := parseQuoted(f.text[f.pos:])
+		if err != nil {
+			return nil, err
+		}
+		f.pos += n
+		return str, nil
+
+	case '*':
+		f.pos++
+		name := f.token()
+		return f.anchors.alias(name)
+
+	case '&':
+		f.pos++
+		name := f.token()
+		val, err := f.value()
+		if err != nil {
+			return nil, err
+		}
+		f.anchors.set(name, val)
+		return val, nil
+
+	case '!':
+		tag := f.token()
+		val, err := f.value()
+		if err == nil && tag == "!!str" {
+			if _, ok := val.(string); !ok {
+				val = fmt.Sprintf("%v", val)
+			}
+		}
+		return val, err
+	}
+
+	return resolve(f.plain()), nil
+}
+
+// token returns the name of an anchor, alias, or tag.
+func (f *flow) token() string {
+	start := f.pos
+	for f.pos < len(f.text) && !strings.ContainsRune(" \t,[]{}", rune(f.text[f.pos])) {
+		f.pos++
+	}
+	return f.text[start:f.pos]
+}
+
+// plain returns the text of a plain scalar.
+//
+// A plain scalar ends at a flow-indicator, or at a colon which separates
+// a key from its value.
+func (f *flow) plain() string {
+
+	start := f.pos
+	for f.pos < len(f.text) {
+		c := f.text[f.pos]
+		if strings.ContainsRune(",[]{}", rune(c)) {
+			break
+		}
+		if c == ':' && (f.pos+1 == len(f.text) || strings.ContainsRune(" \t,[]{}", rune(f.text[f.pos+1]))) {
+			break
+		}
+		f.pos++
+	}
+	return strings.TrimSpace(f.text[start:f.pos])
+}
+
+// key parses a mapping key, which is always treated as a string.
+func (f *flow) key() (string, error) {
+
+	f.skipSpace()
+	if c := f.peek(); c == '"' || c == '\'' {
+		str, n, err := parseQuoted(f.text[f.pos:])
+		if err != nil {
+			return "", err
+		}
+		f.pos += n
+		return str, nil
+	}
+	if c := f.peek(); c == '[' || c == '{' {
+		return "", fmt.Errorf("complex mapping keys are not supported")
+	}
+	return f.plain(), nil
+}
+
+// sequence parses a flow sequence.
+func (f *flow) sequence() (interface{}, error) {
+
+	// Skip the opening bracket.
+	f.pos++
+
+	out := make([]interface{}, 0)
+	for {
+		f.skipSpace()
+		if f.peek() == ']' {
+			f.pos++
+			return out, nil
+		}
+
+		val, err := f.value()
+		if err != nil {
+			return nil, err
+		}
+
+		// An entry might be a single key/value pair.
+		f.skipSpace()
+		if f.peek() == ':' {
+			f.pos++
+			pair, err := f.value()
+			if err != nil {
+				return nil, err
+			}
+			val = map[string]interface{}{fmt.Sprintf("%v", val): pair}
+		}
+		out = append(out, val)
+
+		f.skipSpace()
+		switch f.peek() {
+		case ',':
+			f.pos++
+		case ']':
+		case 0:
+			return nil, fmt.Errorf("unexpected end of flow collection")
+		default:
+			return nil, fmt.Errorf("expected ',' or ']' in flow sequence, found '%s'", f.text[f.pos:])
+		}
+	}
+}
+
+// mapping parses a flow mapping.
+func (f *flow) mapping() (interface{}, error) {
+
+	// Skip the opening brace.
+	f.pos++
+
+	out := make(map[string]interface{})
+	for {
+		f.skipSpace()
+		if f.peek() == '}' {
+			f.pos++
+			return out, nil
+		}
+
+		key, err := f.key()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := out[key]; ok {
+			return nil, fmt.Errorf("duplicate key '%s'", key)
+		}
+
+		// A key might have no value.
+		var val interface{}
+		f.skipSpace()
+		if f.peek() == ':' {
+			f.pos++
+			f.skipSpace()
+			if c := f.peek(); c != ',' && c != '}' {
+				val, err = f.value()
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+		out[key] = val
+
+		f.skipSpace()
+		switch f.peek() {
+		case ',':
+			f.pos++
+		case '}':
+		case 0:
+			return nil, fmt.Errorf("unexpected end of flow collection")
+		default:
+			return nil, fmt.Errorf("expected ',' or '}' in flow mapping, found '%s'", f.text[f.pos:])
+		}
+	}
+}
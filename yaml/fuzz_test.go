@@ -0,0 +1,22 @@
+//go:build go1.18
+// +build go1.18
+
+package yaml
+
+import (
+	"testing"
+)
+
+// FuzzParse ensures that no input causes the decoder to panic.
+func FuzzParse(f *testing.F) {
+
+	f.Add([]byte(""))
+	f.Add([]byte("a: 1\nb: [x, {y: z}]\n"))
+	f.Add([]byte("- &a name: x\n  tags:\n  - 'q''s'\n- *a\n"))
+	f.Add([]byte("text: |-\n  one\n\n  two\nmore: >+\n  folded\n"))
+	f.Add([]byte("---\nbase: &b {k: 1}\nc:\n  <<: *b\n...\n--- \"multi\\\n  line\"\n"))
+
+	f.Fuzz(func(t *testing.T, input []byte) {
+		Parse(input)
+	})
+}
@@ -0,0 +1,895 @@
+// Package yaml contains a small YAML decoder, which allows YAML documents
+// to be used as the input to our scripts.
+//
+// We implement the subset of YAML which is commonly found in manifests
+// and configuration files, rather than the whole specification, to avoid
+// introducing an external dependency:
+//
+// * Block mappings, and block sequences, including sequences nested
+// directly beneath a mapping key.
+//
+// * Flow mappings, and flow sequences, such as `{a: 1, b: [2, 3]}`.
+//
+// * Plain, single-quoted, and double-quoted scalars.
+//
+// * Literal (`|`) and folded (`>`) block scalars, with chomping indicators.
+//
+// * Anchors, aliases, and merge keys (`<<`).
+//
+// * Comments, and streams of several documents separated by `---`.
+//
+// Scalars are resolved using the YAML 1.2 core schema, so `yes` and `no`
+// are strings rather than booleans.  Tags are ignored, except for `!!str`
+// which forces a value to be a string.  Complex mapping keys (`?`) are not
+// supported.
+package yaml
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Parse decodes the given YAML stream, and returns each of the documents
+// it contains.
+//
+// Mappings are returned as `map[string]interface{}`, sequences as
+// `[]interface{}`, and scalars as strings, int64, float64, bool, or nil.
+// Documents which are empty, or contain only comments, are skipped.
+func Parse(data []byte) ([]interface{}, error) {
+
+	var docs []interface{}
+
+	for _, lines := range splitDocuments(string(data)) {
+
+		p := &parser{lines: lines.text, first: lines.first, anchors: newAnchors()}
+
+		p.skipBlank()
+		if p.eof() {
+			continue
+		}
+
+		doc, err := p.parseBlock(-1)
+		if err != nil {
+			return nil, err
+		}
+
+		// There must be nothing left over.
+		p.skipBlank()
+		if !p.eof() {
+			return nil, p.errorf("unexpected content '%s'", strings.TrimSpace(p.lines[p.pos]))
+		}
+
+		docs = append(docs, doc)
+	}
+
+	return docs, nil
+}
+
+// document holds the lines of a single document, along with the
+// line-number within the stream of the first of them.
+type document struct {
+	text  []string
+	first int
+}
+
+// splitDocuments splits a stream into its documents.
+func splitDocuments(data string) []document {
+
+	data = strings.TrimPrefix(data, "\uFEFF")
+	data = strings.Replace(data, "\r\n", "\n", -1)
+
+	var docs []document
+	cur := document{first: 1}
+
+	for i, line := range strings.Split(data, "\n") {
+
+		// Directives may only appear before a document starts.
+		if strings.HasPrefix(line, "%") && len(cur.text) == 0 {
+			continue
+		}
+
+		if line == "---" || strings.HasPrefix(line, "--- ") || line == "..." {
+			docs = append(docs, cur)
+			cur = document{first: i + 2}
+
+			// Content may follow the marker, on the same line.
+			if strings.HasPrefix(line, "--- ") {
+				cur.text = append(cur.text, strings.TrimLeft(line[4:], " "))
+				cur.first = i + 1
+			}
+			continue
+		}
+		cur.text = append(cur.text, line)
+	}
+
+	return append(docs, cur)
+}
+
+// parser holds the state of our block-level parser.
+type parser struct {
+
+	// lines holds the lines of the document being parsed.
+	//
+	// Entries of block sequences, as in `- key: value`, are parsed by
+	// replacing the leading dash with a space, so that the rest of the
+	// line may be parsed as a block in its own right.
+	lines []string
+
+	// pos holds the index of the current line.
+	pos int
+
+	// first holds the line-number of our first line, for errors.
+	first int
+
+	// anchors holds the values of the anchors we've seen.
+	anchors *anchors
+}
+
+// errorf returns an error, which includes the current line-number.
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return p.errorAt(p.pos, format, args...)
+}
+
+// errorAt returns an error, which includes the number of the given line.
+func (p *parser) errorAt(pos int, format string, args ...interface{}) error {
+	return fmt.Errorf("yaml: line %d: %s", p.first+pos, fmt.Sprintf(format, args...))
+}
+
+// eof returns true if we've consumed all of our lines.
+func (p *parser) eof() bool {
+	return p.pos >= len(p.lines)
+}
+
+// skipBlank skips lines which are empty, or contain only a comment.
+func (p *parser) skipBlank() {
+	for !p.eof() {
+		trimmed := strings.TrimSpace(p.lines[p.pos])
+		if trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+			return
+		}
+		p.pos++
+	}
+}
+
+// indent returns the indentation of the current line.
+func (p *parser) indent() int {
+	line := p.lines[p.pos]
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+// tabbed returns true if the current line is indented with a tab.
+func (p *parser) tabbed() bool {
+	return strings.HasPrefix(p.content(), "\t")
+}
+
+// content returns the current line, without indentation.
+func (p *parser) content() string {
+	return strings.TrimLeft(p.lines[p.pos], " ")
+}
+
+// parseBlock parses the node which starts on the current line, which must
+// be indented more than the given parent-indentation.
+func (p *parser) parseBlock(parent int) (interface{}, error) {
+
+	p.skipBlank()
+	if p.eof() || p.indent() <= parent {
+		return nil, nil
+	}
+
+	n := p.indent()
+	text := p.content()
+
+	if p.tabbed() {
+		return nil, p.errorf("tabs may not be used for indentation")
+	}
+
+	// An anchor, or a tag, might precede the node.
+	if strings.HasPrefix(text, "&") || strings.HasPrefix(text, "!") {
+		return p.parseEntry(text, parent, n)
+	}
+
+	if isSequenceEntry(text) {
+		return p.parseSequence(n)
+	}
+	if _, _, ok := splitKey(text); ok {
+		return p.parseMapping(n)
+	}
+
+	// A scalar, or flow collection, on a line of its own.
+	p.pos++
+	return p.parseValue(text, parent)
+}
+
+// isSequenceEntry returns true if the given text is an entry within a
+// block sequence.
+func isSequenceEntry(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// parseSequence parses a block sequence whose entries are at the given
+// indentation.
+func (p *parser) parseSequence(n int) (interface{}, error) {
+
+	out := make([]interface{}, 0)
+
+	for {
+		p.skipBlank()
+		if !p.eof() && p.tabbed() {
+			return nil, p.errorf("tabs may not be used for indentation")
+		}
+		if p.eof() || p.indent() != n || !isSequenceEntry(p.content()) {
+			break
+		}
+
+		rest := strings.TrimLeft(p.content()[1:], " ")
+
+		val, err := p.parseEntry(rest, n, n+len(p.content())-len(rest))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, val)
+	}
+
+	if !p.eof() && p.indent() > n {
+		return nil, p.errorf("unexpected indentation")
+	}
+	return out, nil
+}
+
+// parseMapping parses a block mapping whose keys are at the given
+// indentation.
+func (p *parser) parseMapping(n int) (interface{}, error) {
+
+	out := make(map[string]interface{})
+
+	// Keys merged from other mappings, which explicit keys override.
+	merged := make(map[string]interface{})
+
+	for {
+		p.skipBlank()
+		if !p.eof() && p.tabbed() {
+			return nil, p.errorf("tabs may not be used for indentation")
+		}
+		if p.eof() || p.indent() != n {
+			break
+		}
+
+		key, rest, ok := splitKey(p.content())
+		if !ok {
+			return nil, p.errorf("expected a mapping key, found '%s'", p.content())
+		}
+
+		// The value is indented relative to the key, but a sequence
+		// may also start at the same indentation as the key.
+		line := p.pos
+		val, err := p.parseEntry(rest, n, -1)
+		if err != nil {
+			return nil, err
+		}
+
+		if key == "<<" {
+			err = p.merge(line, merged, val)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if _, ok := out[key]; ok {
+			return nil, p.errorAt(line, "duplicate key '%s'", key)
+		}
+		out[key] = val
+	}
+
+	if !p.eof() && p.indent() > n {
+		return nil, p.errorf("unexpected indentation")
+	}
+
+	for k, v := range merged {
+		if _, ok := out[k]; !ok {
+			out[k] = v
+		}
+	}
+	return out, nil
+}
+
+// merge handles a merge-key, copying the keys from the given mapping, or
+// sequence of mappings, into dst.
+//
+// Earlier mappings take precedence over later ones.
+func (p *parser) merge(line int, dst map[string]interface{}, val interface{}) error {
+
+	var maps []interface{}
+	switch val := val.(type) {
+	case map[string]interface{}:
+		maps = []interface{}{val}
+	case []interface{}:
+		maps = val
+	default:
+		return p.errorAt(line, "merge-keys require a mapping, or a sequence of mappings")
+	}
+
+	for _, m := range maps {
+		m, ok := m.(map[string]interface{})
+		if !ok {
+			return p.errorAt(line, "merge-keys require a mapping, or a sequence of mappings")
+		}
+		for k, v := range m {
+			if _, ok := dst[k]; !ok {
+				dst[k] = v
+			}
+		}
+	}
+	return nil
+}
+
+// parseEntry parses the value of a sequence entry, or mapping key, whose
+// text starts with rest, on the current line.
+//
+// n is the indentation of the entry, and column the position of the rest
+// of the line.  A column of -1 means the rest of the line cannot start a
+// nested block, as is the case for mapping values.
+func (p *parser) parseEntry(rest string, n int, column int) (interface{}, error) {
+
+	rest = stripComment(rest)
+	length := len(rest)
+
+	// An anchor, or a tag, might precede the value.
+	anchor := ""
+	if strings.HasPrefix(rest, "&") {
+		anchor, rest = splitToken(rest[1:])
+	}
+	tag := ""
+	if strings.HasPrefix(rest, "!") {
+		tag, rest = splitToken(rest)
+	}
+
+	var val interface{}
+	var err error
+
+	switch {
+	case rest == "":
+
+		// The value is on the following lines.
+		p.pos++
+		p.skipBlank()
+		if column < 0 && !p.eof() && p.indent() == n && isSequenceEntry(p.content()) {
+			val, err = p.parseSequence(n)
+		} else {
+			val, err = p.parseBlock(n)
+		}
+
+	case column >= 0 && (isSequenceEntry(rest) || isKey(rest)):
+
+		// A nested block starts on this line; parse the rest of
+		// the line as though it were indented to this column.
+		p.lines[p.pos] = strings.Repeat(" ", column+length-len(rest)) + rest
+		val, err = p.parseBlock(n)
+
+	default:
+		p.pos++
+		val, err = p.parseValue(rest, n)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	// The !!str tag forces a scalar to be a string.
+	if tag == "!!str" {
+		switch val.(type) {
+		case string, []interface{}, map[string]interface{}:
+		default:
+			val = rest
+		}
+	}
+
+	if anchor != "" {
+		p.anchors.set(anchor, val)
+	}
+	return val, nil
+}
+
+// isKey returns true if the text starts with a mapping key.
+func isKey(text string) bool {
+	_, _, ok := splitKey(text)
+	return ok
+}
+
+// splitToken splits the given text at the first space.
+func splitToken(text string) (string, string) {
+	i := strings.IndexByte(text, ' ')
+	if i < 0 {
+		return text, ""
+	}
+	return text[:i], strings.TrimLeft(text[i:], " ")
+}
+
+// splitKey splits a line such as `name: value` into the key and value.
+//
+// False is returned if the line does not contain a mapping key.
+func splitKey(text string) (string, string, bool) {
+
+	if text == "" || strings.ContainsAny(text[:1], "[{#|>*&!%@`") {
+		return "", "", false
+	}
+
+	// Quoted keys.
+	if text[0] == '"' || text[0] == '\'' {
+		key, n, err := parseQuoted(text)
+		if err != nil {
+			return "", "", false
+		}
+		rest := strings.TrimLeft(text[n:], " ")
+		if rest == ":" || strings.HasPrefix(rest, ": ") {
+			return key, strings.TrimLeft(rest[1:], " "), true
+		}
+		return "", "", false
+	}
+
+	for i := 0; i < len(text); i++ {
+
+		// A comment ends the key.
+		if text[i] == '#' && i > 0 && text[i-1] == ' ' {
+			return "", "", false
+		}
+		if text[i] == ':' && (i == len(text)-1 || text[i+1] == ' ') {
+			key := strings.TrimSpace(text[:i])
+			if key == "" || key == "-" || strings.HasPrefix(key, "- ") {
+				return "", "", false
+			}
+			return key, strings.TrimLeft(text[i+1:], " "), true
+		}
+	}
+	return "", "", false
+}
+
+// stripComment removes a trailing comment from the given text, taking
+// care to ignore comment-characters within quoted strings.
+func stripComment(text string) string {
+
+	quote := byte(0)
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == '\'' && quote == c && i+1 < len(text) && text[i+1] == c {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			// Quotes only begin a string at the start of a token.
+			if i == 0 || strings.ContainsAny(text[i-1:i], " [{,:") {
+				quote = c
+			}
+		case c == '#' && (i == 0 || text[i-1] == ' '):
+			return strings.TrimRight(text[:i], " ")
+		}
+	}
+	return strings.TrimRight(text, " ")
+}
+
+// parseValue parses a value which starts with the given text, which has
+// been consumed from the line before the current one.
+//
+// Values may continue onto subsequent lines which are indented more
+// than the parent.
+func (p *parser) parseValue(text string, parent int) (interface{}, error) {
+
+	start := p.pos - 1
+	text = stripComment(text)
+
+	switch {
+	case strings.HasPrefix(text, "*"):
+		name := strings.TrimSpace(text[1:])
+		val, err := p.anchors.alias(name)
+		if err != nil {
+			return nil, p.errorAt(start, "%s", err)
+		}
+		return val, nil
+
+	case strings.HasPrefix(text, "|") || strings.HasPrefix(text, ">"):
+		return p.parseBlockScalar(start, text, parent)
+
+	case strings.HasPrefix(text, "[") || strings.HasPrefix(text, "{"):
+
+		// Flow collections may span lines.
+		for !balanced(text) && !p.eof() {
+			text += " " + stripComment(strings.TrimSpace(p.lines[p.pos]))
+			p.pos++
+		}
+
+		f := &flow{text: text, anchors: p.anchors}
+		val, err := f.parse()
+		if err != nil {
+			return nil, p.errorAt(start, "%s", err)
+		}
+		return val, nil
+
+	case strings.HasPrefix(text, "\"") || strings.HasPrefix(text, "'"):
+
+		// Quoted strings may span lines.
+		for {
+			val, n, err := parseQuoted(text)
+			if err == nil {
+				if strings.TrimSpace(text[n:]) != "" {
+					return nil, p.errorAt(start, "unexpected content after string '%s'", text[n:])
+				}
+				return val, nil
+			}
+			if err != errUnterminated || p.eof() {
+				return nil, p.errorAt(start, "%s", err)
+			}
+
+			// Line-breaks are folded into spaces, unless they're
+			// escaped, or the line is blank.
+			line := strings.TrimSpace(p.lines[p.pos])
+			switch {
+			case text[0] == '"' && escapedBreak(text):
+				text = text[:len(text)-1] + line
+			case line == "":
+				text += "\n"
+			case strings.HasSuffix(text, "\n"):
+				text += line
+			default:
+				text += " " + line
+			}
+			p.pos++
+		}
+	}
+
+	// Plain scalars may be folded over several lines.
+	for !p.eof() {
+		line := strings.TrimSpace(p.lines[p.pos])
+		if line == "" {
+			// Blank lines are only part of the scalar if more
+			// text follows.
+			save := p.pos
+			p.skipBlank()
+			if p.eof() || p.indent() <= parent || strings.HasPrefix(p.content(), "#") {
+				p.pos = save
+				break
+			}
+			text += strings.Repeat("\n", p.pos-save)
+			continue
+		}
+		if p.indent() <= parent || strings.HasPrefix(line, "#") {
+			break
+		}
+		if strings.HasSuffix(text, "\n") {
+			text += stripComment(line)
+		} else {
+			text += " " + stripComment(line)
+		}
+		p.pos++
+	}
+
+	return resolve(text), nil
+}
+
+// escapedBreak returns true if the given text ends with a backslash which
+// escapes the line-break that follows it.
+func escapedBreak(text string) bool {
+	n := len(text) - len(strings.TrimRight(text, "\\"))
+	return n%2 == 1
+}
+
+// balanced returns true if the brackets within the given flow-collection
+// are balanced.
+func balanced(text string) bool {
+
+	depth := 0
+	quote := byte(0)
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[' || c == '{':
+			depth++
+		case c == ']' || c == '}':
+			depth--
+		}
+	}
+	return depth <= 0 && quote == 0
+}
+
+// parseBlockScalar parses a literal, or folded, block scalar introduced
+// by the given header, such as `|-`, which was found on the given line.
+func (p *parser) parseBlockScalar(start int, header string, parent int) (interface{}, error) {
+
+	folded := header[0] == '>'
+	chomp := byte(0)
+	explicit := 0
+
+	for _, c := range header[1:] {
+		switch {
+		case c == '-' || c == '+':
+			chomp = byte(c)
+		case c >= '1' && c <= '9':
+			explicit = int(c - '0')
+		default:
+			return nil, p.errorAt(start, "invalid block scalar header '%s'", header)
+		}
+	}
+
+	// Find the extent of our content, and its indentation.
+	indent := -1
+	if explicit > 0 {
+		indent = parent + explicit
+		if parent < 0 {
+			indent = explicit
+		}
+	}
+
+	var lines []string
+	for !p.eof() {
+		line := p.lines[p.pos]
+		if strings.TrimSpace(line) == "" {
+			// Spaces beyond the indentation are content.
+			if indent >= 0 && len(line) > indent {
+				lines = append(lines, line[indent:])
+			} else {
+				lines = append(lines, "")
+			}
+			p.pos++
+			continue
+		}
+		n := p.indent()
+		if n <= parent || (indent >= 0 && n < indent) {
+			break
+		}
+		if indent < 0 {
+			indent = n
+		}
+		lines = append(lines, line[indent:])
+		p.pos++
+	}
+
+	// Trailing blank lines belong to the scalar, for the purposes of
+	// chomping, but we've consumed them regardless.
+	content := len(lines)
+	for content > 0 && lines[content-1] == "" {
+		content--
+	}
+	trailing := len(lines) - content
+	lines = lines[:content]
+
+	var out strings.Builder
+	for i, line := range lines {
+		if i > 0 {
+			prev := lines[i-1]
+			switch {
+			case !folded || line == "":
+				out.WriteString("\n")
+			case prev == "":
+				// The blank lines have already been written
+				// as newlines, unless they surround a line
+				// which is more indented, as those are kept.
+				j := i - 1
+				for j > 0 && lines[j] == "" {
+					j--
+				}
+				if moreIndented(line) || moreIndented(lines[j]) {
+					out.WriteString("\n")
+				}
+			case moreIndented(line) || moreIndented(prev):
+				out.WriteString("\n")
+			default:
+				out.WriteString(" ")
+			}
+		}
+		out.WriteString(line)
+	}
+
+	str := out.String()
+	switch chomp {
+	case '-':
+	case '+':
+		if content > 0 {
+			str += "\n"
+		}
+		str += strings.Repeat("\n", trailing)
+	default:
+		if content > 0 {
+			str += "\n"
+		}
+	}
+	return str, nil
+}
+
+// moreIndented returns true if a line of a folded scalar is indented
+// beyond the content, in which case it is not folded.
+func moreIndented(line string) bool {
+	return strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")
+}
+
+// errUnterminated is returned by parseQuoted if the closing quote is
+// missing, in which case the string might continue on the next line.
+var errUnterminated = errors.New("unterminated string")
+
+// parseQuoted parses the quoted string at the start of the given text,
+// returning its value, and the number of bytes it occupied.
+func parseQuoted(text string) (string, int, error) {
+
+	quote := text[0]
+	var out strings.Builder
+
+	for i := 1; i < len(text); i++ {
+		c := text[i]
+
+		// Single-quoted strings escape quotes by doubling them.
+		if quote == '\'' {
+			if c == '\'' {
+				if i+1 < len(text) && text[i+1] == '\'' {
+					out.WriteByte('\'')
+					i++
+					continue
+				}
+				return out.String(), i + 1, nil
+			}
+			out.WriteByte(c)
+			continue
+		}
+
+		if c == '"' {
+			return out.String(), i + 1, nil
+		}
+		if c != '\\' {
+			out.WriteByte(c)
+			continue
+		}
+
+		i++
+		if i >= len(text) {
+			break
+		}
+
+		switch text[i] {
+		case 'n':
+			out.WriteByte('\n')
+		case 't':
+			out.WriteByte('\t')
+		case 'r':
+			out.WriteByte('\r')
+		case '0':
+			out.WriteByte(0)
+		case 'a':
+			out.WriteByte('\a')
+		case 'b':
+			out.WriteByte('\b')
+		case 'e':
+			out.WriteByte(0x1b)
+		case 'f':
+			out.WriteByte('\f')
+		case 'v':
+			out.WriteByte('\v')
+		case ' ', '"', '/', '\\':
+			out.WriteByte(text[i])
+		case 'x', 'u', 'U':
+			size := map[byte]int{'x': 2, 'u': 4, 'U': 8}[text[i]]
+			if i+size >= len(text) {
+				return "", 0, fmt.Errorf("invalid escape sequence in %s", text)
+			}
+			r, err := strconv.ParseUint(text[i+1:i+1+size], 16, 32)
+			if err != nil {
+				return "", 0, fmt.Errorf("invalid escape sequence in %s", text)
+			}
+			out.WriteRune(rune(r))
+			i += size
+		default:
+			return "", 0, fmt.Errorf("invalid escape sequence '\\%c'", text[i])
+		}
+	}
+
+	return "", 0, errUnterminated
+}
+
+// resolve converts a plain scalar to a value of the appropriate type.
+func resolve(text string) interface{} {
+
+	switch text {
+	case "", "~", "null", "Null", "NULL":
+		return nil
+	case "true", "True", "TRUE":
+		return true
+	case "false", "False", "FALSE":
+		return false
+	case ".inf", ".Inf", ".INF", "+.inf", "+.Inf", "+.INF":
+		return math.Inf(1)
+	case "-.inf", "-.Inf", "-.INF":
+		return math.Inf(-1)
+	case ".nan", ".NaN", ".NAN":
+		return math.NaN()
+	}
+
+	if isInteger(text) {
+		base := 10
+		digits := text
+		switch {
+		case strings.HasPrefix(text, "0x"):
+			base, digits = 16, text[2:]
+		case strings.HasPrefix(text, "0o"):
+			base, digits = 8, text[2:]
+		}
+		if i, err := strconv.ParseInt(digits, base, 64); err == nil {
+			return i
+		}
+	}
+
+	if isFloat(text) {
+		if f, err := strconv.ParseFloat(text, 64); err == nil {
+			return f
+		}
+	}
+
+	return text
+}
+
+// isInteger returns true if the text is an integer, as defined by the
+// YAML core schema.
+func isInteger(text string) bool {
+
+	switch {
+	case strings.HasPrefix(text, "0x"):
+		return len(text) > 2 && strings.Trim(text[2:], "0123456789abcdefABCDEF") == ""
+	case strings.HasPrefix(text, "0o"):
+		return len(text) > 2 && strings.Trim(text[2:], "01234567") == ""
+	}
+
+	digits := strings.TrimLeft(text, "+-")
+	return len(text)-len(digits) <= 1 && digits != "" && strings.Trim(digits, "0123456789") == ""
+}
+
+// isFloat returns true if the text is a floating-point number, as defined
+// by the YAML core schema.
+func isFloat(text string) bool {
+
+	text = strings.TrimLeft(text, "+-")
+	if text == "" {
+		return false
+	}
+
+	digits := 0
+	i := 0
+	for i < len(text) && text[i] >= '0' && text[i] <= '9' {
+		i++
+		digits++
+	}
+	if i < len(text) && text[i] == '.' {
+		i++
+		for i < len(text) && text[i] >= '0' && text[i] <= '9' {
+			i++
+			digits++
+		}
+	}
+	if digits == 0 {
+		return false
+	}
+	if i < len(text) && (text[i] == 'e' || text[i] == 'E') {
+		i++
+		if i < len(text) && (text[i] == '+' || text[i] == '-') {
+			i++
+		}
+		exp := i
+		for i < len(text) && text[i] >= '0' && text[i] <= '9' {
+			i++
+		}
+		if i == exp {
+			return false
+		}
+	}
+	return i == len(text)
+}
@@ -0,0 +1,179 @@
+package yaml
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+)
+
+// TestParse tests decoding documents, by comparing their JSON encoding.
+func TestParse(t *testing.T) {
+
+	tests := []struct {
+		input  string
+		output string
+	}{
+		// Scalars.
+		{"steve", `"steve"`},
+		{"42", `42`},
+		{"-7", `-7`},
+		{"0x1F", `31`},
+		{"0o17", `15`},
+		{"3.25", `3.25`},
+		{"1e3", `1000`},
+		{"true", `true`},
+		{"False", `false`},
+		{"~", `null`},
+		{"yes", `"yes"`},
+		{"1.2.3", `"1.2.3"`},
+		{`"a\tb\u00e9\x41"`, `"a\tbéA"`},
+		{`'it''s # not a comment'`, `"it's # not a comment"`},
+		{"plain # comment", `"plain"`},
+		{"url: http://example.com/#anchor", `{"url":"http://example.com/#anchor"}`},
+
+		// Mappings.
+		{"a: 1\nb: two\nc:\n", `{"a":1,"b":"two","c":null}`},
+		{"a:\n  b:\n    c: deep\n  d: 4\ne: 5", `{"a":{"b":{"c":"deep"},"d":4},"e":5}`},
+		{`"quoted key": 1` + "\n'other: key': 2", `{"other: key":2,"quoted key":1}`},
+		{"# leading comment\n\na: 1 # trailing\n\n# between\nb: 2\n", `{"a":1,"b":2}`},
+		{"a: 'x'\nb: \"y: z\"", `{"a":"x","b":"y: z"}`},
+		{"text: this is\n  folded over\n  lines\nnext: 1", `{"next":1,"text":"this is folded over lines"}`},
+		{"num: !!str 42\nbool: !!str true", `{"bool":"true","num":"42"}`},
+
+		// Sequences.
+		{"- a\n- 2\n-\n- - x\n  - y", `["a",2,null,["x","y"]]`},
+		{"items:\n- a\n- b\nnext: 1", `{"items":["a","b"],"next":1}`},
+		{"items:\n  - a\n  - b", `{"items":["a","b"]}`},
+		{"- name: a\n  value: 1\n- name: b\n  tags:\n  - x\n  - y", `[{"name":"a","value":1},{"name":"b","tags":["x","y"]}]`},
+		{"-   name: a\n    value: 1", `[{"name":"a","value":1}]`},
+
+		// Flow collections.
+		{"[1, two, 'three', [4]]", `[1,"two","three",[4]]`},
+		{"{a: 1, b: [x, y], c: {d: null}, e}", `{"a":1,"b":["x","y"],"c":{"d":null},"e":null}`},
+		{"list: [a, b,]\nmap: {x: 1}", `{"list":["a","b"],"map":{"x":1}}`},
+		{"list: [\n  a,\n  b  # comment\n]\nnext: 1", `{"list":["a","b"],"next":1}`},
+		{"[a: 1, b]", `[{"a":1},"b"]`},
+		{"{url: http://x/y, time: 12:30}", `{"time":"12:30","url":"http://x/y"}`},
+		{"[]", `[]`},
+		{"{}", `{}`},
+
+		// Block scalars.
+		{"a: |\n  line one\n  line two\nb: 1", `{"a":"line one\nline two\n","b":1}`},
+		{"a: |-\n  line one\n    indented\n\nb: 1", `{"a":"line one\n  indented","b":1}`},
+		{"a: |+\n  keep\n\n\nb: 1", `{"a":"keep\n\n\n","b":1}`},
+		{"a: >\n  folded\n  text\n\n  new para\n    kept\n  end\n", `{"a":"folded text\nnew para\n  kept\nend\n"}`},
+		{"- |\n  in a list\n- next", `["in a list\n","next"]`},
+		{"a: |2\n    two extra\n  base", `{"a":"  two extra\nbase\n"}`},
+
+		// Anchors, aliases, and merging.
+		{"base: &base\n  a: 1\n  b: 2\nother:\n  <<: *base\n  b: 3", `{"base":{"a":1,"b":2},"other":{"a":1,"b":3}}`},
+		{"x: &v 7\ny: *v\nz: [*v, &w q, *w]", `{"x":7,"y":7,"z":[7,"q","q"]}`},
+		{"a:\n  &anchored\n  k: 1\nb: *anchored", `{"a":{"k":1},"b":{"k":1}}`},
+		{"- &item name: x\n- !!map\n  k: v", `[{"name":"x"},{"k":"v"}]`},
+		{"a: &a {k: 1}\nb: &b {k: 2, j: 2}\nc:\n  <<: [*a, *b]", `{"a":{"k":1},"b":{"j":2,"k":2},"c":{"j":2,"k":1}}`},
+
+		// Windows line-endings.
+		{"a: 1\r\nb: 2\r\n", `{"a":1,"b":2}`},
+	}
+
+	for _, test := range tests {
+
+		docs, err := Parse([]byte(test.input))
+		if err != nil {
+			t.Fatalf("unexpected error parsing %q: %s", test.input, err)
+		}
+		if len(docs) != 1 {
+			t.Fatalf("expected one document for %q, got %d", test.input, len(docs))
+		}
+
+		out, err := json.Marshal(docs[0])
+		if err != nil {
+			t.Fatalf("failed to encode result of %q: %s", test.input, err)
+		}
+		if string(out) != test.output {
+			t.Fatalf("wrong result for %q\ngot: %s\nexp: %s", test.input, out, test.output)
+		}
+	}
+}
+
+// TestDocuments tests streams containing several documents.
+func TestDocuments(t *testing.T) {
+
+	input := `%YAML 1.2
+---
+kind: Deployment
+---
+# Only a comment
+---
+kind: Service
+...
+--- [1, 2]
+`
+
+	docs, err := Parse([]byte(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	out, _ := json.Marshal(docs)
+	exp := `[{"kind":"Deployment"},{"kind":"Service"},[1,2]]`
+	if string(out) != exp {
+		t.Fatalf("wrong result, got %s expected %s", out, exp)
+	}
+
+	// An empty stream has no documents.
+	docs, err = Parse([]byte("\n# nothing\n"))
+	if err != nil || len(docs) != 0 {
+		t.Fatalf("expected no documents, got %v %v", docs, err)
+	}
+}
+
+// TestSpecialFloats tests the values which cannot be encoded as JSON.
+func TestSpecialFloats(t *testing.T) {
+
+	docs, err := Parse([]byte("[.inf, -.Inf, .nan]"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	vals := docs[0].([]interface{})
+	if !math.IsInf(vals[0].(float64), 1) || !math.IsInf(vals[1].(float64), -1) || !math.IsNaN(vals[2].(float64)) {
+		t.Fatalf("wrong result: %v", vals)
+	}
+}
+
+// TestErrors tests that invalid documents are rejected.
+func TestErrors(t *testing.T) {
+
+	tests := []struct {
+		input string
+		error string
+	}{
+		{"a: 1\na: 2", "line 2: duplicate key 'a'"},
+		{"a: 'x'\n  - b", "line 2: unexpected indentation"},
+		{"a:\n  b: 1\n c: 2", "line 3: unexpected indentation"},
+		{"- a\nb: 1", "line 2: unexpected content"},
+		{"a: [1, 2", "line 1: unexpected end of flow collection"},
+		{"a: {b: 1} c", "unexpected content 'c'"},
+		{"a: {b: 1 c: 2}", "expected ',' or '}'"},
+		{"a: \"unterminated", "unterminated string"},
+		{"a: \"bad \\q escape\"", "invalid escape sequence"},
+		{"a: *missing", "line 1: unknown alias 'missing'"},
+		{"a: '1' x", "unexpected content after string"},
+		{"a: |x\n  b", "invalid block scalar header"},
+		{"a:\n\t- b", "tabs may not be used"},
+		{"<<: 3", "merge-keys require a mapping"},
+		{"{[a]: 1}", "complex mapping keys are not supported"},
+		{"---\na: 1\n---\nb: 1\nb: 2", "line 5: duplicate key 'b'"},
+		{"a: &a [1, 2]\nb: &b [*a, *a, *a, *a, *a, *a, *a, *a, *a, *a]\nc: &c [*b, *b, *b, *b, *b, *b, *b, *b, *b, *b]\nd: &d [*c, *c, *c, *c, *c, *c, *c, *c, *c, *c]\ne: &e [*d, *d, *d, *d, *d, *d, *d, *d, *d, *d]\nf: &f [*e, *e, *e, *e, *e, *e, *e, *e, *e, *e]\ng: [*f, *f, *f, *f, *f, *f, *f, *f, *f, *f]", "line 7: too many aliases"},
+	}
+
+	for _, test := range tests {
+		_, err := Parse([]byte(test.input))
+		if err == nil {
+			t.Fatalf("expected an error parsing %q, got none", test.input)
+		}
+		if !strings.Contains(err.Error(), test.error) {
+			t.Fatalf("wrong error parsing %q, got '%s' expected '%s'", test.input, err, test.error)
+		}
+	}
+}